@@ -0,0 +1,739 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// ScriptInfo is the static metadata of a script or module, returned by Context.Analyze.
+type ScriptInfo struct {
+	Module    bool
+	Functions []string     // names of the declared functions, nested ones included, in the order of the source
+	Imports   []ImportInfo // imports, re-exports and dynamic imports, in the order of the source
+	Exports   []string     // exported names, "default" for the default export
+	Globals   []string     // sorted names of the variables referenced but not declared, such as "eval" or "fetch"
+}
+
+// ImportInfo is an import of a script or module.
+type ImportInfo struct {
+	Module  string   // specifier of the imported module, as written; "" for a dynamic import of a computed specifier
+	Names   []string // imported names, "default" for the default export and "*" for the namespace or export *
+	Dynamic bool     // import() call, whose names are unknown
+	Line    int
+}
+
+// binding is the role of an identifier token in the analysis of a script.
+type binding uint8
+
+const (
+	bindRef     binding = iota // reference to a variable
+	bindName                   // property name, label, or other name that is not a variable
+	bindVar                    // var declaration, hoisted to the function
+	bindFunc                   // function declaration, hoisted to the function
+	bindLexical                // let, const, class, import or catch binding, in its block
+	bindParam                  // parameter, in the scope of its function
+	bindSelf                   // name of a function or class expression, in the scope of the function or class
+)
+
+// bracketKind tells the scopes opened by brackets apart.
+type bracketKind uint8
+
+const (
+	bracketPlain  bracketKind = iota
+	bracketObject             // object literal or destructuring pattern
+	bracketClass              // class body
+	bracketParams             // parameters of a function or method
+	bracketArrow              // parameters of an arrow function
+	bracketHeader             // header of a for loop or a catch clause, whose bindings are in the block after it
+)
+
+// exprKeywords are the keywords after which a brace starts an object literal.
+var exprKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true, "delete": true,
+	"void": true, "throw": true, "case": true, "yield": true, "await": true,
+}
+
+// declKeywords are the keywords that start a declaration or statement, ending the initializer of a declaration
+// before them on the previous line.
+var declKeywords = map[string]bool{
+	"var": true, "let": true, "const": true, "function": true, "class": true, "if": true, "for": true,
+	"while": true, "do": true, "return": true, "switch": true, "try": true, "throw": true, "import": true,
+	"export": true,
+}
+
+// analysis is the token level analysis of a script by Context.Analyze.
+type analysis struct {
+	info      ScriptInfo
+	toks      []jslex.Token
+	match     []int // index of the bracket matching each bracket
+	encl      []int // index of the innermost bracket enclosing each token, -1 at the top level
+	roles     []binding
+	kinds     []bracketKind
+	bound     []int // indexes of the declared identifiers, in the order of marking
+	exporting bool  // the next declaration is exported
+}
+
+// at returns the token at index i, EOF beyond the tokens.
+func (a *analysis) at(i int) jslex.Token {
+	if i < 0 || i >= len(a.toks) {
+		return jslex.Token{Kind: jslex.EOF}
+	}
+	return a.toks[i]
+}
+
+// brackets matches the brackets of the tokens.
+func (a *analysis) brackets() {
+	a.match = make([]int, len(a.toks))
+	a.encl = make([]int, len(a.toks))
+	var open []int
+	for i, tok := range a.toks {
+		a.match[i], a.encl[i] = -1, -1
+		if len(open) > 0 {
+			a.encl[i] = open[len(open)-1]
+		}
+		if tok.Kind != jslex.Punctuator {
+			continue
+		}
+		switch tok.Text {
+		case "(", "[", "{":
+			open = append(open, i)
+		case ")", "]", "}":
+			if len(open) > 0 {
+				j := open[len(open)-1]
+				open = open[:len(open)-1]
+				a.match[i], a.match[j] = j, i
+				a.encl[i] = a.encl[j]
+			}
+		}
+	}
+	// Unbalanced brackets can't be in compiled code, but keep the indexes in range.
+	for i, j := range a.match {
+		if j < 0 && a.at(i).Kind == jslex.Punctuator && strings.Contains("([{", a.at(i).Text) {
+			a.match[i] = len(a.toks) - 1
+		}
+	}
+}
+
+// mark sets the role of identifier i, unless it is already set.
+func (a *analysis) mark(i int, role binding) {
+	if a.at(i).Kind == jslex.Identifier && a.roles[i] == bindRef {
+		a.roles[i] = role
+		if role != bindName {
+			a.bound = append(a.bound, i)
+		}
+	}
+}
+
+// skipExpr returns the index of the first comma, closing bracket or semicolon after the expression at i, or of the
+// first declaration keyword on a new line.
+func (a *analysis) skipExpr(i int) int {
+	for ; i < len(a.toks)-1; i++ {
+		tok := a.toks[i]
+		switch {
+		case tok.Is(",") || tok.Is(";") || tok.Is(")") || tok.Is("]") || tok.Is("}"):
+			return i
+		case tok.Is("(") || tok.Is("[") || tok.Is("{"):
+			i = a.match[i]
+		case tok.NewlineBefore && tok.Kind == jslex.Identifier && declKeywords[tok.Text]:
+			return i
+		}
+	}
+	return i
+}
+
+// pattern marks the identifiers bound by the binding pattern at i with role and returns the index after it.
+func (a *analysis) pattern(i int, role binding) int {
+	tok := a.at(i)
+	switch {
+	case tok.Kind == jslex.Identifier:
+		a.mark(i, role)
+		return i + 1
+	case tok.Is("["):
+		end := a.match[i]
+		for j := i + 1; j < end; {
+			if a.toks[j].Is(",") {
+				j++
+				continue
+			}
+			if a.toks[j].Is("...") {
+				j++
+			}
+			j = a.pattern(j, role)
+			if a.at(j).Is("=") {
+				j = a.skipExpr(j + 1)
+			}
+			if !a.at(j).Is(",") {
+				break
+			}
+		}
+		return end + 1
+	case tok.Is("{"):
+		a.kinds[i] = bracketObject
+		end := a.match[i]
+		for j := i + 1; j < end; {
+			switch {
+			case a.toks[j].Is(","):
+				j++
+				continue
+			case a.toks[j].Is("..."):
+				j = a.pattern(j+1, role)
+			case a.toks[j].Is("["):
+				j = a.pattern(a.match[j]+2, role)
+			case a.at(j + 1).Is(":"):
+				a.mark(j, bindName)
+				j = a.pattern(j+2, role)
+			default:
+				a.mark(j, role)
+				j++
+			}
+			if a.at(j).Is("=") {
+				j = a.skipExpr(j + 1)
+			}
+			if !a.at(j).Is(",") {
+				break
+			}
+		}
+		return end + 1
+	}
+	return i + 1
+}
+
+// params marks the parameters in the parentheses at i as bracket kind.
+func (a *analysis) params(i int, kind bracketKind) {
+	if !a.at(i).Is("(") {
+		return
+	}
+	a.kinds[i] = kind
+	end := a.match[i]
+	for j := i + 1; j < end; {
+		if a.toks[j].Is("...") {
+			j++
+		}
+		j = a.pattern(j, bindParam)
+		if a.at(j).Is("=") {
+			j = a.skipExpr(j + 1)
+		}
+		if !a.at(j).Is(",") {
+			break
+		}
+		j++
+	}
+}
+
+// declarations marks the bindings of the var, let or const declaration at i.
+func (a *analysis) declarations(i int, role binding) {
+	start := len(a.bound)
+	for j := i + 1; ; j++ {
+		j = a.pattern(j, role)
+		if a.at(j).Is("=") {
+			j = a.skipExpr(j + 1)
+		}
+		if !a.at(j).Is(",") {
+			break
+		}
+	}
+	a.export(start)
+}
+
+// export adds the names bound since the index start of the bound identifiers to the exports, if they are exported.
+func (a *analysis) export(start int) {
+	if a.exporting {
+		for _, j := range a.bound[start:] {
+			a.info.Exports = append(a.info.Exports, a.toks[j].Text)
+		}
+		a.exporting = false
+	}
+}
+
+// atStatement reports whether token i starts a statement, skipping an async before it.
+func (a *analysis) atStatement(i int) bool {
+	if a.at(i - 1).Is("async") {
+		i--
+	}
+	prev := a.at(i - 1)
+	return i == 0 || prev.Is(";") || prev.Is("{") || prev.Is("}") || prev.Is("export") || prev.Is("default")
+}
+
+// specifier returns the module specifier of string token i.
+func (a *analysis) specifier(i int) string {
+	return unquoteJS(a.at(i).Text)
+}
+
+// unquoteJS returns the value of a string literal of JS without escapes beyond those of Go.
+func unquoteJS(s string) string {
+	if strings.HasPrefix(s, "'") {
+		s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return unquoted
+	}
+	return strings.Trim(s, `"'`)
+}
+
+// moduleName returns the name of an import or export clause token.
+func (a *analysis) moduleName(i int) string {
+	if a.at(i).Kind == jslex.String {
+		return a.specifier(i)
+	}
+	return a.at(i).Text
+}
+
+// importDecl marks the import declaration at i.
+func (a *analysis) importDecl(i int) {
+	imp := ImportInfo{Line: a.toks[i].Line}
+	j := i + 1
+	if a.at(j).Kind == jslex.Identifier && !a.at(j).Is("from") || a.at(j).Is("from") && a.at(j+1).Is("from") {
+		a.mark(j, bindLexical)
+		imp.Names = append(imp.Names, "default")
+		j++
+		if a.at(j).Is(",") {
+			j++
+		}
+	}
+	switch {
+	case a.at(j).Is("*"):
+		a.mark(j+1, bindName)
+		a.mark(j+2, bindLexical)
+		imp.Names = append(imp.Names, "*")
+		j += 3
+	case a.at(j).Is("{"):
+		a.kinds[j] = bracketObject
+		end := a.match[j]
+		for k := j + 1; k < end; k++ {
+			if a.toks[k].Is(",") {
+				continue
+			}
+			imp.Names = append(imp.Names, a.moduleName(k))
+			if a.at(k + 1).Is("as") {
+				a.mark(k, bindName)
+				a.mark(k+1, bindName)
+				k += 2
+			}
+			a.mark(k, bindLexical)
+		}
+		j = end + 1
+	}
+	a.mark(j, bindName)
+	if a.at(j).Is("from") {
+		j++
+	}
+	if a.at(j).Kind == jslex.String {
+		imp.Module = a.specifier(j)
+		a.info.Imports = append(a.info.Imports, imp)
+	}
+}
+
+// exportDecl marks the export declaration at i.
+func (a *analysis) exportDecl(i int) {
+	j := i + 1
+	switch next := a.at(j); {
+	case next.Is("default"):
+		a.info.Exports = append(a.info.Exports, "default")
+	case next.Is("*"):
+		imp := ImportInfo{Names: []string{"*"}, Line: a.toks[i].Line}
+		if a.at(j + 1).Is("as") {
+			a.mark(j+1, bindName)
+			a.mark(j+2, bindName)
+			a.info.Exports = append(a.info.Exports, a.moduleName(j+2))
+			j += 2
+		}
+		a.mark(j+1, bindName)
+		if a.at(j+2).Kind == jslex.String {
+			imp.Module = a.specifier(j + 2)
+			a.info.Imports = append(a.info.Imports, imp)
+		}
+	case next.Is("{"):
+		a.kinds[j] = bracketObject
+		end := a.match[j]
+		reexport := a.at(end+1).Is("from") && a.at(end+2).Kind == jslex.String
+		imp := ImportInfo{Line: a.toks[i].Line}
+		for k := j + 1; k < end; k++ {
+			if a.toks[k].Is(",") {
+				continue
+			}
+			name := a.moduleName(k)
+			if reexport {
+				a.mark(k, bindName)
+				imp.Names = append(imp.Names, name)
+			}
+			if a.at(k + 1).Is("as") {
+				a.mark(k+1, bindName)
+				a.mark(k+2, bindName)
+				k += 2
+				name = a.moduleName(k)
+			}
+			a.info.Exports = append(a.info.Exports, name)
+		}
+		if reexport {
+			a.mark(end+1, bindName)
+			imp.Module = a.specifier(end + 2)
+			a.info.Imports = append(a.info.Imports, imp)
+		}
+	default:
+		a.exporting = true
+	}
+}
+
+// classDecl marks the class at i.
+func (a *analysis) classDecl(i int) {
+	j := i + 1
+	if name := a.at(j); name.Kind == jslex.Identifier && !name.Is("extends") {
+		role := bindSelf
+		if a.atStatement(i) {
+			role = bindLexical
+		}
+		start := len(a.bound)
+		a.mark(j, role)
+		a.export(start)
+		j++
+	}
+	for ; j < len(a.toks)-1 && !(a.toks[j].Is("{") && a.encl[j] == a.encl[i]); j++ {
+	}
+	if a.at(j).Is("{") {
+		a.kinds[j] = bracketClass
+	}
+}
+
+// functionDecl marks the function at i.
+func (a *analysis) functionDecl(i int) {
+	j := i + 1
+	if a.at(j).Is("*") {
+		j++
+	}
+	if a.at(j).Kind == jslex.Identifier {
+		if a.atStatement(i) {
+			start := len(a.bound)
+			a.mark(j, bindFunc)
+			a.export(start)
+			a.info.Functions = append(a.info.Functions, a.toks[j].Text)
+		} else {
+			a.mark(j, bindSelf)
+		}
+		j++
+	}
+	a.params(j, bracketParams)
+}
+
+// isMember reports whether identifier i names a member of the object literal or class body enclosing it.
+func (a *analysis) isMember(i int) bool {
+	e := a.encl[i]
+	if e < 0 || a.kinds[e] != bracketObject && a.kinds[e] != bracketClass {
+		return false
+	}
+	class := a.kinds[e] == bracketClass
+	start := i
+	for p := a.at(start - 1); p.Is("get") || p.Is("set") || p.Is("async") || p.Is("static") || p.Is("*"); p = a.at(start - 1) {
+		start--
+	}
+	if prev := a.at(start - 1); !prev.Is("{") && !prev.Is(",") && !(class && (prev.Is(";") || prev.Is("}"))) {
+		return false
+	}
+	// Shorthand properties reference the variable of their name.
+	next := a.at(i + 1)
+	return class || !next.Is(",") && !next.Is("}") && !next.Is("=")
+}
+
+// isObjectBrace reports whether the brace at i starts an object literal rather than a block.
+func (a *analysis) isObjectBrace(i int) bool {
+	prev := a.at(i - 1)
+	switch prev.Kind {
+	case jslex.Punctuator:
+		switch prev.Text {
+		case ")", "]", "}", ";", "=>", "{":
+			return false
+		case ":":
+			e := a.encl[i]
+			return e >= 0 && a.toks[e].Is("{") && a.kinds[e] == bracketObject || e >= 0 && !a.toks[e].Is("{")
+		}
+		return true
+	case jslex.Identifier:
+		return exprKeywords[prev.Text]
+	case jslex.TemplateHead, jslex.TemplateMiddle:
+		return true
+	}
+	return false
+}
+
+// markRoles marks the roles of the identifiers and the kinds of the brackets.
+func (a *analysis) markRoles() {
+	for i := 0; i < len(a.toks)-1; i++ {
+		tok, prev, next := a.toks[i], a.at(i-1), a.at(i+1)
+		if tok.Kind == jslex.Punctuator {
+			switch tok.Text {
+			case "{":
+				if a.kinds[i] == bracketPlain && a.isObjectBrace(i) {
+					a.kinds[i] = bracketObject
+				}
+			case "(":
+				e := a.encl[i]
+				switch {
+				case a.kinds[i] != bracketPlain:
+				case a.at(a.match[i] + 1).Is("=>"):
+					a.params(i, bracketArrow)
+				case e >= 0 && (a.kinds[e] == bracketObject || a.kinds[e] == bracketClass) && a.at(a.match[i]+1).Is("{"):
+					a.params(i, bracketParams)
+				case prev.Is("for") || prev.Is("catch"):
+					a.kinds[i] = bracketHeader
+					if prev.Is("catch") {
+						a.pattern(i+1, bindLexical)
+					}
+				}
+			}
+			continue
+		}
+		if tok.Kind != jslex.Identifier || a.roles[i] != bindRef {
+			continue
+		}
+		if prev.Is(".") || prev.Is("?.") {
+			a.roles[i] = bindName
+			continue
+		}
+		switch tok.Text {
+		case "var":
+			a.declarations(i, bindVar)
+		case "let", "const":
+			if next.Kind == jslex.Identifier || next.Is("[") || next.Is("{") {
+				a.declarations(i, bindLexical)
+			}
+		case "function":
+			a.functionDecl(i)
+		case "class":
+			a.classDecl(i)
+		case "import":
+			switch {
+			case next.Is("("):
+				imp := ImportInfo{Dynamic: true, Line: tok.Line}
+				if arg := a.at(i + 2); arg.Kind == jslex.String && (a.at(i+3).Is(")") || a.at(i+3).Is(",")) {
+					imp.Module = a.specifier(i + 2)
+				}
+				a.info.Imports = append(a.info.Imports, imp)
+			case !next.Is("."):
+				a.importDecl(i)
+			}
+		case "export":
+			a.exportDecl(i)
+		case "break", "continue":
+			if !next.NewlineBefore {
+				a.mark(i+1, bindName)
+			}
+		default:
+			switch {
+			case next.Is("=>"):
+				a.mark(i, bindParam)
+			case a.isMember(i):
+				a.roles[i] = bindName
+			case next.Is(":") && (a.atStatement(i) || prev.Is(":")):
+				// Label.
+				a.roles[i] = bindName
+			}
+		}
+	}
+}
+
+// varScope is a scope of the variables of an analyzed script.
+type varScope struct {
+	parent *varScope
+	fn     bool
+	names  map[string]bool
+}
+
+func newScope(parent *varScope, fn bool) *varScope {
+	s := &varScope{parent: parent, fn: fn, names: map[string]bool{}}
+	if fn && parent != nil {
+		s.names["arguments"] = true
+	}
+	return s
+}
+
+// function returns the scope of the function of s.
+func (s *varScope) function() *varScope {
+	for !s.fn {
+		s = s.parent
+	}
+	return s
+}
+
+// declares reports whether name is declared in s or a scope enclosing it.
+func (s *varScope) declares(name string) bool {
+	for ; s != nil; s = s.parent {
+		if s.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve finds the variables referenced by the script but not declared by it.
+func (a *analysis) resolve() {
+	type frame struct {
+		scope   *varScope
+		concise bool // body of an arrow function without braces, ended by a comma, a semicolon or a closing bracket
+		kind    bracketKind
+	}
+	root := newScope(nil, true)
+	stack := []frame{{scope: root}}
+	current := func() *varScope { return stack[len(stack)-1].scope }
+	var pending, arrow, self *varScope
+	var selfName string
+	type reference struct {
+		name  string
+		scope *varScope
+	}
+	var refs []reference
+	endConcise := func() {
+		for len(stack) > 1 && stack[len(stack)-1].concise {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for i := 0; i < len(a.toks)-1; i++ {
+		tok := a.toks[i]
+		if tok.Kind == jslex.Punctuator {
+			switch tok.Text {
+			case "(":
+				s := current()
+				switch a.kinds[i] {
+				case bracketParams, bracketArrow:
+					s = newScope(s, true)
+					if self != nil {
+						s.names[selfName], self = true, nil
+					}
+				case bracketHeader:
+					s = newScope(s, false)
+				}
+				stack = append(stack, frame{scope: s, kind: a.kinds[i]})
+			case "[":
+				stack = append(stack, frame{scope: current()})
+			case "{":
+				s := newScope(current(), false)
+				if a.kinds[i] == bracketObject {
+					// Object literals and patterns declare their bindings in the scope around them.
+					s = current()
+				}
+				if pending != nil {
+					s, pending = newScope(pending, false), nil
+				}
+				if a.kinds[i] == bracketClass && self != nil {
+					s.names[selfName], self = true, nil
+				}
+				stack = append(stack, frame{scope: s, kind: a.kinds[i]})
+			case ")", "]", "}":
+				endConcise()
+				if len(stack) > 1 {
+					f := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					switch {
+					case f.kind == bracketArrow:
+						arrow = f.scope
+					case f.kind == bracketParams || f.kind == bracketHeader && a.at(i+1).Is("{"):
+						pending = f.scope
+					case f.kind == bracketHeader:
+						stack = append(stack, frame{scope: f.scope, concise: true})
+					}
+				}
+			case ",", ";":
+				endConcise()
+			case "=>":
+				if arrow != nil {
+					if a.at(i + 1).Is("{") {
+						pending = arrow
+					} else {
+						stack = append(stack, frame{scope: arrow, concise: true})
+					}
+					arrow = nil
+				}
+			}
+			continue
+		}
+		if tok.Kind != jslex.Identifier {
+			continue
+		}
+		switch a.roles[i] {
+		case bindRef:
+			if !keywords[tok.Text] {
+				refs = append(refs, reference{tok.Text, current()})
+			}
+		case bindVar, bindFunc:
+			current().function().names[tok.Text] = true
+		case bindLexical:
+			current().names[tok.Text] = true
+		case bindParam:
+			if a.at(i + 1).Is("=>") {
+				arrow = newScope(current(), true)
+				arrow.names[tok.Text] = true
+			} else {
+				current().names[tok.Text] = true
+			}
+		case bindSelf:
+			self, selfName = current(), tok.Text
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if !seen[ref.name] && !ref.scope.declares(ref.name) {
+			seen[ref.name] = true
+			a.info.Globals = append(a.info.Globals, ref.name)
+		}
+	}
+	sort.Strings(a.info.Globals)
+}
+
+// Analyze compiles code without executing it and returns its static metadata: the functions it declares, the modules
+// it imports and the names it exports, and the global variables it references, for editors, script management UIs
+// and reports such as listing the scripts that use eval:
+//
+//	info, err := ctx.Analyze(code, quickjs.EvalFileName("job.js"))
+//	for _, name := range info.Globals {
+//		if name == "eval" || name == "Function" {
+//			log.Printf("job.js uses %s", name)
+//		}
+//	}
+//
+// Code that doesn't compile returns the error of Eval. Modules are detected as Eval does, and compiled as Check does,
+// in a separate context, loading the modules they import without evaluating them, so nothing is left defined in ctx.
+//
+// Analyze cannot enforce anything. The metadata is found from the tokens of the code and its scopes, not by the
+// engine: globals accessed through other means, such as globalThis["ev" + "al"] or the constructor property of a
+// function, aren't found, and the properties of the object of a with statement are reported as globals. To forbid
+// eval and Function, create the context with ContextDisableEval, and restrict the other globals and modules scripts
+// can reach in their context.
+func (ctx *Context) Analyze(code string, opts ...EvalOption) (*ScriptInfo, error) {
+	options := EvalOptions{}
+	for _, fn := range opts {
+		fn(&options)
+	}
+	var err error
+	if isoErr := ctx.isolated(func(realm *Context) error {
+		var v Value
+		if v, err = realm.Eval(code, append(opts, EvalFlagCompileOnly(true))...); err == nil {
+			v.Free()
+		}
+		return nil
+	}); isoErr != nil {
+		return nil, isoErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	toks, err := jslex.Tokenize(code)
+	if err != nil {
+		return nil, err
+	}
+	codePtr := C.CString(code)
+	defer C.free(unsafe.Pointer(codePtr))
+	a := &analysis{toks: toks, roles: make([]binding, len(toks)), kinds: make([]bracketKind, len(toks))}
+	a.info.Module = options.js_eval_type_module || C.JS_DetectModule(codePtr, C.size_t(len(code))) != 0
+	a.brackets()
+	a.markRoles()
+	a.resolve()
+	return &a.info, nil
+}
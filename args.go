@@ -0,0 +1,256 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// Args gives typed access to the arguments of a Go function called from JS. Accessors return the zero value (or the
+// given default) on mismatch and record the first error, which FunctionArgs throws as a TypeError, or as a RangeError
+// for an integer out of the range of its type.
+type Args struct {
+	ctx      *Context
+	values   []Value
+	err      error
+	outRange bool // err is a range error
+}
+
+// NewArgs wraps the arguments of a function bound with Function.
+func NewArgs(ctx *Context, values []Value) *Args {
+	return &Args{ctx: ctx, values: values}
+}
+
+// FunctionArgs returns a js function value calling fn with typed arguments. If an accessor of args failed,
+// the result of fn is discarded and a TypeError is thrown.
+func (ctx *Context) FunctionArgs(fn func(ctx *Context, this Value, args *Args) Value) Value {
+	return ctx.Function(func(ctx *Context, this Value, values []Value) Value {
+		args := NewArgs(ctx, values)
+		ret := fn(ctx, this, args)
+		if args.err == nil {
+			return ret
+		}
+		ret.Free()
+		return args.Throw()
+	})
+}
+
+// Len returns the number of arguments.
+func (a *Args) Len() int {
+	return len(a.values)
+}
+
+// Values returns the arguments.
+func (a *Args) Values() []Value {
+	return a.values
+}
+
+// Err returns the first error recorded by an accessor.
+func (a *Args) Err() error {
+	return a.err
+}
+
+// Throw throws the recorded error as a TypeError, or as a RangeError for an integer out of range.
+func (a *Args) Throw() Value {
+	if a.outRange {
+		return a.ctx.ThrowRangeError("%s", a.err)
+	}
+	return a.ctx.ThrowTypeError("%s", a.err)
+}
+
+func (a *Args) fail(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (a *Args) failRange(i int, typ string) {
+	if a.err == nil {
+		a.err = fmt.Errorf("argument %d is out of the range of %s", i, typ)
+		a.outRange = true
+	}
+}
+
+// Require records an error if there are fewer than n arguments.
+func (a *Args) Require(n int) bool {
+	if len(a.values) < n {
+		a.fail("expected at least %d arguments, got %d", n, len(a.values))
+		return false
+	}
+	return true
+}
+
+// Value returns the argument i, or undefined if it is missing.
+func (a *Args) Value(i int) Value {
+	if i < 0 || i >= len(a.values) {
+		return a.ctx.Undefined()
+	}
+	return a.values[i]
+}
+
+// arg returns the argument i; ok is false if it is missing or undefined, in which case an error is recorded unless
+// a default is available.
+func (a *Args) arg(i int, hasDefault bool) (v Value, ok bool) {
+	v = a.Value(i)
+	if v.IsUndefined() {
+		if !hasDefault {
+			a.fail("argument %d is required", i)
+		}
+		return v, false
+	}
+	return v, true
+}
+
+// String returns the string argument i, or def if it is missing or undefined.
+func (a *Args) String(i int, def ...string) string {
+	v, ok := a.arg(i, len(def) > 0)
+	if !ok {
+		if len(def) > 0 {
+			return def[0]
+		}
+		return ""
+	}
+	if !v.IsString() {
+		a.fail("argument %d must be a string", i)
+		return ""
+	}
+	return v.String()
+}
+
+// Bool returns the boolean argument i, or def if it is missing or undefined.
+func (a *Args) Bool(i int, def ...bool) bool {
+	v, ok := a.arg(i, len(def) > 0)
+	if !ok {
+		return len(def) > 0 && def[0]
+	}
+	if !v.IsBool() {
+		a.fail("argument %d must be a boolean", i)
+		return false
+	}
+	return v.Bool()
+}
+
+// Float64 returns the number argument i, or def if it is missing or undefined.
+func (a *Args) Float64(i int, def ...float64) float64 {
+	v, ok := a.arg(i, len(def) > 0)
+	if !ok {
+		if len(def) > 0 {
+			return def[0]
+		}
+		return 0
+	}
+	if !v.IsNumber() {
+		a.fail("argument %d must be a number", i)
+		return 0
+	}
+	return v.Float64()
+}
+
+// Int64 returns the integer argument i, or def if it is missing or undefined. A BigInt is accepted as well. Integers
+// out of the range of int64 record a range error.
+func (a *Args) Int64(i int, def ...int64) int64 {
+	n, ok := a.integer(i, "int64", def...)
+	if !ok {
+		return 0
+	}
+	return n
+}
+
+// Int32 returns the integer argument i, or def if it is missing or undefined. A BigInt is accepted as well. Integers
+// out of the range of int32 record a range error.
+func (a *Args) Int32(i int, def ...int32) int32 {
+	var d []int64
+	if len(def) > 0 {
+		d = []int64{int64(def[0])}
+	}
+	n, ok := a.integer(i, "int32", d...)
+	if !ok {
+		return 0
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		a.failRange(i, "int32")
+		return 0
+	}
+	return int32(n)
+}
+
+// integer returns the integer argument i, a number or a BigInt, or def if it is missing or undefined. ok is false if
+// an error was recorded.
+func (a *Args) integer(i int, typ string, def ...int64) (n int64, ok bool) {
+	v, ok := a.arg(i, len(def) > 0)
+	if !ok {
+		if len(def) > 0 {
+			return def[0], true
+		}
+		return 0, false
+	}
+	if v.IsBigInt() {
+		b := v.BigInt()
+		if b == nil || !b.IsInt64() {
+			a.failRange(i, typ)
+			return 0, false
+		}
+		return b.Int64(), true
+	}
+	f := v.Float64()
+	if !v.IsNumber() || f != math.Trunc(f) {
+		a.fail("argument %d must be an integer", i)
+		return 0, false
+	}
+	// -2^63 is an int64, 2^63 is not.
+	if f < math.MinInt64 || f >= math.MaxInt64 {
+		a.failRange(i, typ)
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// Bytes returns a copy of the ArrayBuffer or typed array argument i.
+func (a *Args) Bytes(i int) []byte {
+	v, ok := a.arg(i, false)
+	if !ok {
+		return nil
+	}
+	var offset, length, bytesPerElement C.size_t
+	buf := C.JS_GetTypedArrayBuffer(a.ctx.ref, v.ref, &offset, &length, &bytesPerElement)
+	if C.JS_IsException(buf) == 1 {
+		C.JS_FreeValue(a.ctx.ref, C.JS_GetException(a.ctx.ref))
+		if !v.IsByteArray() {
+			a.fail("argument %d must be an ArrayBuffer or a typed array", i)
+			return nil
+		}
+		b, _ := v.ToByteArray(uint(v.ByteLen()))
+		return b
+	}
+	defer C.JS_FreeValue(a.ctx.ref, buf)
+	var size C.size_t
+	ptr := C.JS_GetArrayBuffer(a.ctx.ref, &size, buf)
+	if ptr == nil || offset+length > size {
+		C.JS_FreeValue(a.ctx.ref, C.JS_GetException(a.ctx.ref))
+		a.fail("argument %d is a detached buffer", i)
+		return nil
+	}
+	return C.GoBytes(unsafe.Add(unsafe.Pointer(ptr), int(offset)), C.int(length))
+}
+
+// Object returns the object argument i.
+func (a *Args) Object(i int) Value {
+	v, ok := a.arg(i, false)
+	if ok && !v.IsObject() {
+		a.fail("argument %d must be an object", i)
+	}
+	return v
+}
+
+// Function returns the function argument i.
+func (a *Args) Function(i int) Value {
+	v, ok := a.arg(i, false)
+	if ok && !v.IsFunction() {
+		a.fail("argument %d must be a function", i)
+	}
+	return v
+}
@@ -0,0 +1,96 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"runtime"
+	"sync"
+)
+
+// WithAutoFree makes the values returned by the API (Eval, Get, Call, String, Object, ...) free themselves once the
+// Go garbage collector finds them unreachable; values still alive are freed by Context.Close. Calling Free remains
+// allowed and releases a value immediately. Collected values are only released when the runtime is next used from its
+// own goroutine (creating a value, evaluating code, RunGC), so memory is reclaimed later than with explicit frees.
+func WithAutoFree() Option {
+	return func(o *Options) {
+		o.autoFree = true
+	}
+}
+
+// valueOwner is shared by the copies of an auto-freed value; its finalizer queues the value to be freed.
+type valueOwner struct {
+	ctx *Context
+	id  uint64
+}
+
+type pendingFree struct {
+	ctx *Context
+	id  uint64
+}
+
+// autoFreeQueue holds the values collected by the Go garbage collector until the runtime frees them.
+type autoFreeQueue struct {
+	mu      sync.Mutex
+	pending []pendingFree
+}
+
+func (q *autoFreeQueue) push(ctx *Context, id uint64) {
+	q.mu.Lock()
+	q.pending = append(q.pending, pendingFree{ctx: ctx, id: id})
+	q.mu.Unlock()
+}
+
+func (q *autoFreeQueue) take() []pendingFree {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// own attaches an owner with a finalizer to v.
+func (ctx *Context) own(v Value) Value {
+	if ctx.owned == nil {
+		ctx.owned = make(map[uint64]C.JSValue)
+	}
+	ctx.lastOwned++
+	ctx.owned[ctx.lastOwned] = v.ref
+	v.owner = &valueOwner{ctx: ctx, id: ctx.lastOwned}
+	queue := &ctx.runtime.state.autoFree
+	runtime.SetFinalizer(v.owner, func(o *valueOwner) { queue.push(o.ctx, o.id) })
+	return v
+}
+
+// disown stops the automatic freeing of v, whose reference was freed or handed over to JS.
+func (ctx *Context) disown(v Value) {
+	if v.owner == nil {
+		return
+	}
+	delete(ctx.owned, v.owner.id)
+	runtime.SetFinalizer(v.owner, nil)
+}
+
+// freeCollected frees the values collected by the Go garbage collector.
+func (r Runtime) freeCollected() {
+	for _, p := range r.state.autoFree.take() {
+		ref, ok := p.ctx.owned[p.id]
+		if !ok {
+			continue
+		}
+		delete(p.ctx.owned, p.id)
+		p.ctx.untrack(Value{ctx: p.ctx, ref: ref})
+		C.JS_FreeValue(p.ctx.ref, ref)
+	}
+}
+
+// freeOwned frees the values of the context that are still alive.
+func (ctx *Context) freeOwned() {
+	ctx.runtime.freeCollected()
+	for _, ref := range ctx.owned {
+		ctx.untrack(Value{ctx: ctx, ref: ref})
+		C.JS_FreeValue(ctx.ref, ref)
+	}
+	ctx.owned = nil
+}
@@ -0,0 +1,84 @@
+package quickjs
+
+import (
+	"context"
+	"time"
+)
+
+// awaitPollInterval bounds how long a cancellation of the context given to AwaitContext goes unnoticed.
+const awaitPollInterval = 10 * time.Millisecond
+
+// The promise races a guard promise that a timer rejects once check reports the cancellation; the timer keeps the
+// engine's event loop polling while the promise is pending.
+const awaitGuard = `(promise, check) => {
+	if (typeof setTimeout !== "function") throw new TypeError("setTimeout is not available");
+	const set = setTimeout, clear = clearTimeout;
+	let timer;
+	const guard = new Promise((_, reject) => {
+		const tick = () => {
+			const next = check();
+			if (next < 0) reject(new Error("await cancelled"));
+			else timer = set(tick, next);
+		};
+		tick();
+	});
+	return Promise.race([promise, guard]).finally(() => clear(timer));
+}`
+
+// AwaitTimeout is like Await but gives up after d, returning context.DeadlineExceeded.
+func (ctx *Context) AwaitTimeout(v Value, d time.Duration) (Value, error) {
+	goCtx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return ctx.AwaitContext(goCtx, v)
+}
+
+// AwaitContext is like Await but gives up when goCtx is done, returning its error. Pending jobs and timers keep
+// running while waiting; a cancellation is noticed within 10ms. Giving up does not settle the promise: its reactions
+// still run if it settles later.
+func (ctx *Context) AwaitContext(goCtx context.Context, v Value) (Value, error) {
+	if !v.IsPromise() {
+		return ctx.Await(v)
+	}
+	if err := goCtx.Err(); err != nil {
+		v.Free()
+		return ctx.Undefined(), err
+	}
+
+	cancelled := false
+	check := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		next := awaitPollInterval
+		if deadline, ok := goCtx.Deadline(); ok {
+			if until := time.Until(deadline); until < next {
+				next = until
+			}
+		}
+		// The deadline may pass before goCtx reports it.
+		if goCtx.Err() != nil || next <= 0 {
+			cancelled = true
+			return ctx.Int32(-1)
+		}
+		return ctx.Int64(int64((next + time.Millisecond - 1) / time.Millisecond))
+	})
+	defer check.Free()
+
+	guard, err := ctx.Eval(awaitGuard, EvalFlagInternal(true))
+	if err != nil {
+		v.Free()
+		return ctx.Undefined(), err
+	}
+	defer guard.Free()
+	race, err := ctx.InvokeE(guard, ctx.Null(), v, check)
+	v.Free()
+	if err != nil {
+		return ctx.Undefined(), err
+	}
+
+	val, err := ctx.Await(race)
+	if err != nil && cancelled {
+		if err := goCtx.Err(); err != nil {
+			return val, err
+		}
+		return val, context.DeadlineExceeded
+	}
+	return val, err
+}
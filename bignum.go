@@ -0,0 +1,113 @@
+package quickjs
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// useMath prepends the "use math" directive to code, on the first line to keep the line numbers.
+func useMath(code string) string {
+	if strings.HasPrefix(code, "#!") {
+		if i := strings.IndexByte(code, '\n'); i >= 0 {
+			return code[:i+1] + `"use math";` + code[i+1:]
+		}
+	}
+	return `"use math";` + code
+}
+
+// BigFloat returns a BigFloat value equal to f, keeping the precision of f.
+func (ctx *Context) BigFloat(f *big.Float) Value {
+	var s string
+	switch {
+	case f.IsInf() && f.Signbit():
+		s = "-Infinity"
+	case f.IsInf():
+		s = "Infinity"
+	default:
+		s = f.Text('x', -1)
+	}
+	prec := f.Prec()
+	if prec < 53 {
+		prec = 53
+	}
+	fn, err := ctx.Eval(`(s, prec) => BigFloatEnv.setPrec(() => BigFloat(s), prec)`, EvalFlagInternal(true))
+	if err != nil {
+		panic(err)
+	}
+	defer fn.Free()
+	str := ctx.String(s)
+	defer str.Free()
+	return ctx.Invoke(fn, ctx.Null(), str, ctx.Int64(int64(prec)))
+}
+
+// BigDecimal returns a BigDecimal value parsed from the decimal number s, or an exception if s is not a number.
+func (ctx *Context) BigDecimal(s string) Value {
+	ctor := ctx.Globals().Get("BigDecimal")
+	defer ctor.Free()
+	str := ctx.String(s)
+	defer str.Free()
+	return ctx.Invoke(ctor, ctx.Null(), str)
+}
+
+// ToBigFloat converts a BigFloat, BigDecimal, BigInt or Number value to a big.Float without loss of precision.
+// It fails for other values and for NaN.
+func (v Value) ToBigFloat() (*big.Float, error) {
+	switch {
+	case v.IsBigFloat():
+		fn, err := v.ctx.Eval(`(x) => x.toString(16)`, EvalFlagInternal(true))
+		if err != nil {
+			return nil, err
+		}
+		defer fn.Free()
+		hex := v.ctx.Invoke(fn, v.ctx.Null(), v)
+		defer hex.Free()
+		return parseBigFloatHex(hex.String())
+	case v.IsBigDecimal(), v.IsBigInt():
+		s := v.String()
+		f, _, err := big.ParseFloat(s, 10, uint(4*len(s)+64), big.ToNearestEven)
+		if err != nil {
+			return nil, fmt.Errorf("quickjs: invalid number %q: %w", s, err)
+		}
+		return f, nil
+	case v.IsNumber():
+		f := v.Float64()
+		if f != f {
+			return nil, fmt.Errorf("quickjs: NaN has no big.Float representation")
+		}
+		return big.NewFloat(f), nil
+	}
+	return nil, fmt.Errorf("quickjs: value is not a number")
+}
+
+// parseBigFloatHex parses the output of BigFloat.prototype.toString(16).
+func parseBigFloatHex(s string) (*big.Float, error) {
+	switch s {
+	case "Infinity":
+		return new(big.Float).SetInf(false), nil
+	case "-Infinity":
+		return new(big.Float).SetInf(true), nil
+	case "NaN":
+		return nil, fmt.Errorf("quickjs: NaN has no big.Float representation")
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	mantissa := s
+	if i := strings.IndexByte(s, 'p'); i >= 0 {
+		mantissa = s[:i]
+	} else {
+		s += "p0"
+	}
+	prec := uint(4*len(mantissa) + 4)
+	if prec < 53 {
+		prec = 53
+	}
+	f, _, err := big.ParseFloat("0x"+s, 0, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("quickjs: invalid BigFloat %q: %w", s, err)
+	}
+	if neg {
+		f.Neg(f)
+	}
+	return f, nil
+}
@@ -0,0 +1,125 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// StringEncoding is the encoding of the bytes of StringFromBytes and Value.StringLenBytes.
+type StringEncoding int
+
+const (
+	// EncodingWTF8 encodes strings in UTF-8, and their lone surrogates as if they were characters, as in WTF-8, so
+	// that every string round-trips. Invalid UTF-8 bytes are decoded as U+FFFD. It is the default encoding.
+	EncodingWTF8 StringEncoding = iota
+	// EncodingUTF8 is like EncodingWTF8, but encodes lone surrogates as U+FFFD too, so that the bytes are valid UTF-8.
+	EncodingUTF8
+	// EncodingLatin1 maps every byte to the character of the same code, as atob and btoa do, so that arbitrary bytes
+	// round-trip. Strings with characters beyond U+00FF can't be encoded.
+	EncodingLatin1
+)
+
+// StringOptions configures StringFromBytes and Value.StringLenBytes.
+type StringOptions struct {
+	encoding StringEncoding
+}
+
+// StringOption configures StringFromBytes and Value.StringLenBytes.
+type StringOption func(*StringOptions)
+
+// StringBytesEncoding sets the encoding of the bytes, EncodingWTF8 by default.
+func StringBytesEncoding(encoding StringEncoding) StringOption {
+	return func(o *StringOptions) {
+		o.encoding = encoding
+	}
+}
+
+func stringOptions(opts []StringOption) StringOptions {
+	var o StringOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// isSurrogate reports whether b starts with the WTF-8 encoding of a surrogate.
+func isSurrogate(b []byte) bool {
+	return len(b) >= 3 && b[0] == 0xed && b[1] >= 0xa0 && b[1] <= 0xbf && b[2] >= 0x80 && b[2] <= 0xbf
+}
+
+// StringFromBytes returns a string value decoded from b, which may contain NUL bytes, unlike String whose C string
+// ends at the first one.
+func (ctx *Context) StringFromBytes(b []byte, opts ...StringOption) Value {
+	switch stringOptions(opts).encoding {
+	case EncodingUTF8:
+		utf := make([]byte, 0, len(b))
+		for i := 0; i < len(b); {
+			if isSurrogate(b[i:]) {
+				utf = utf8.AppendRune(utf, utf8.RuneError)
+				i += 3
+				continue
+			}
+			_, size := utf8.DecodeRune(b[i:])
+			utf = append(utf, b[i:i+size]...)
+			i += size
+		}
+		b = utf
+	case EncodingLatin1:
+		latin1 := make([]byte, 0, len(b))
+		for _, c := range b {
+			latin1 = utf8.AppendRune(latin1, rune(c))
+		}
+		b = latin1
+	}
+	if len(b) == 0 {
+		return ctx.String("")
+	}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewStringLen(ctx.ref, (*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b)))})
+}
+
+// StringLenBytes returns the bytes of the string representation of the value, including its NUL characters, which
+// String cuts the string at. It returns the exception thrown converting the value to a string as an error, and an
+// error for strings that can't be encoded.
+func (v Value) StringLenBytes(opts ...StringOption) ([]byte, error) {
+	var n C.size_t
+	ptr := C.JS_ToCStringLen(v.ctx.ref, &n, v.ref)
+	if ptr == nil {
+		return nil, v.ctx.pendingError()
+	}
+	b := C.GoBytes(unsafe.Pointer(ptr), C.int(n))
+	C.JS_FreeCString(v.ctx.ref, ptr)
+
+	switch stringOptions(opts).encoding {
+	case EncodingUTF8:
+		utf := b[:0]
+		for i := 0; i < len(b); {
+			if isSurrogate(b[i:]) {
+				// U+FFFD takes the 3 bytes of the surrogate.
+				utf = utf8.AppendRune(utf, utf8.RuneError)
+				i += 3
+				continue
+			}
+			_, size := utf8.DecodeRune(b[i:])
+			utf = append(utf, b[i:i+size]...)
+			i += size
+		}
+		return utf, nil
+	case EncodingLatin1:
+		latin1 := b[:0]
+		for i := 0; i < len(b); {
+			r, size := utf8.DecodeRune(b[i:])
+			if r > 0xff {
+				return nil, errors.New("quickjs: string has characters beyond U+00FF")
+			}
+			latin1 = append(latin1, byte(r))
+			i += size
+		}
+		return latin1, nil
+	}
+	return b, nil
+}
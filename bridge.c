@@ -1,11 +1,61 @@
 #include "_cgo_export.h"
 #include "quickjs.h"
+#include "quickjs-libc.h"
+#ifndef _WIN32
+#include <dlfcn.h>
+#endif
+#include <stdlib.h>
+#include <string.h>
 #include <time.h>
+#ifdef _MSC_VER
+#include <windows.h>
+#endif
 
+// Portability helpers: GCC and Clang (mingw, glibc, musl, macOS) use their builtins, MSVC the Interlocked functions.
+#ifdef _MSC_VER
+#define THREAD_LOCAL __declspec(thread)
+
+static size_t atomicLoadSize(size_t *p) {
+#ifdef _WIN64
+	return (size_t)InterlockedCompareExchange64((volatile LONG64 *)p, 0, 0);
+#else
+	return (size_t)InterlockedCompareExchange((volatile LONG *)p, 0, 0);
+#endif
+}
+
+static void atomicStoreSize(size_t *p, size_t v) {
+#ifdef _WIN64
+	InterlockedExchange64((volatile LONG64 *)p, (LONG64)v);
+#else
+	InterlockedExchange((volatile LONG *)p, (LONG)v);
+#endif
+}
+
+static uint64_t atomicLoadUint64(uint64_t *p) {
+	return (uint64_t)InterlockedCompareExchange64((volatile LONG64 *)p, 0, 0);
+}
+
+static void atomicIncUint64(uint64_t *p) {
+	InterlockedIncrement64((volatile LONG64 *)p);
+}
+
+static int atomicAddInt(int *p, int v) {
+	return InterlockedExchangeAdd((volatile LONG *)p, v) + v;
+}
+#else
+#define THREAD_LOCAL __thread
+
+static size_t atomicLoadSize(size_t *p) { return __atomic_load_n(p, __ATOMIC_RELAXED); }
+static void atomicStoreSize(size_t *p, size_t v) { __atomic_store_n(p, v, __ATOMIC_RELAXED); }
+static uint64_t atomicLoadUint64(uint64_t *p) { return __atomic_load_n(p, __ATOMIC_RELAXED); }
+static void atomicIncUint64(uint64_t *p) { __atomic_add_fetch(p, 1, __ATOMIC_RELAXED); }
+static int atomicAddInt(int *p, int v) { return __atomic_add_fetch(p, v, __ATOMIC_SEQ_CST); }
+#endif
 
 JSValue JS_NewNull() { return JS_NULL; }
 JSValue JS_NewUndefined() { return JS_UNDEFINED; }
 JSValue JS_NewUninitialized() { return JS_UNINITIALIZED; }
+JSValue JS_NewException() { return JS_EXCEPTION; }
 
 JSValue ThrowSyntaxError(JSContext *ctx, const char *fmt) { return JS_ThrowSyntaxError(ctx, "%s", fmt); }
 JSValue ThrowTypeError(JSContext *ctx, const char *fmt) { return JS_ThrowTypeError(ctx, "%s", fmt); }
@@ -17,47 +67,516 @@ int ValueGetTag(JSValueConst v) {
 	return JS_VALUE_GET_TAG(v);
 }
 
+JSModuleDef *ValueGetModule(JSValueConst v) {
+	return JS_VALUE_GET_PTR(v);
+}
+
 JSValue InvokeProxy(JSContext *ctx, JSValueConst this_val, int argc, JSValueConst *argv) {
-	 return goProxy(ctx, this_val, argc, argv);
+	return goProxy(ctx, this_val, argc, argv);
 }
 
 JSValue InvokeAsyncProxy(JSContext *ctx, JSValueConst this_val, int argc, JSValueConst *argv) {
 	return goAsyncProxy(ctx, this_val, argc, argv);
 }
 
-int interruptHandler(JSRuntime *rt, void *handlerArgs) {
-	return goInterruptHandler(rt, handlerArgs);
+int interruptHandler(JSRuntime *rt, void *opaque) {
+	return goInterruptHandler(rt, (uintptr_t)opaque);
 }
 
-void SetInterruptHandler(JSRuntime *rt, void *handlerArgs){
-	JS_SetInterruptHandler(rt, &interruptHandler, handlerArgs);
+void SetInterruptHandler(JSRuntime *rt, uintptr_t handle) {
+	JS_SetInterruptHandler(rt, &interruptHandler, (void *)handle);
 }
 
-typedef struct {
-    time_t start;
-    time_t timeout;
-} TimeoutStruct;
-
-int timeoutHandler(JSRuntime *rt, void *opaque) {
-    TimeoutStruct* ts = (TimeoutStruct*)opaque;
-    time_t timeout = ts->timeout;
-    time_t start = ts->start;
-	if (timeout <= 0) {
+void SetContextHandle(JSContext *ctx, uintptr_t handle) {
+	JS_SetContextOpaque(ctx, (void *)handle);
+}
+
+uintptr_t GetContextHandle(JSContext *ctx) {
+	return (uintptr_t)JS_GetContextOpaque(ctx);
+}
+
+static JSValue callJob(JSContext *ctx, int argc, JSValueConst *argv) {
+	return JS_Call(ctx, argv[0], JS_UNDEFINED, 0, NULL);
+}
+
+int EnqueueCall(JSContext *ctx, JSValueConst fn) {
+	return JS_EnqueueJob(ctx, callJob, 1, &fn);
+}
+
+JSModuleDef *InvokeModuleLoader(JSContext *ctx, const char *module_name, void *opaque) {
+	JSModuleDef *m = goModuleLoader(ctx, (char *)module_name, opaque);
+	return m;
+}
+
+static int initHostModule(JSContext *ctx, JSModuleDef *m) {
+	return goHostModuleInit(ctx, m);
+}
+
+JSModuleDef *NewHostModule(JSContext *ctx, const char *module_name) {
+	return JS_NewCModule(ctx, module_name, initHostModule);
+}
+
+static int hasSuffix(const char *s, const char *suffix) {
+	size_t len = strlen(s), suffixLen = strlen(suffix);
+	return len >= suffixLen && strcmp(s + len - suffixLen, suffix) == 0;
+}
+
+// loadNativeModule loads a QuickJS C module from a shared library exporting js_init_module, like the file loader of
+// quickjs-libc does for ".so" names.
+static JSModuleDef *loadNativeModule(JSContext *ctx, const char *module_name) {
+#ifdef _WIN32
+	JS_ThrowReferenceError(ctx, "could not load module '%s': native modules are not supported on Windows", module_name);
+	return NULL;
+#else
+	char *filename = NULL;
+	if (strchr(module_name, '/') == NULL) {
+		// dlopen searches the library path for names without a slash: load from the current directory instead.
+		filename = js_malloc(ctx, strlen(module_name) + 3);
+		if (filename == NULL) {
+			return NULL;
+		}
+		strcpy(filename, "./");
+		strcat(filename, module_name);
+	}
+	void *handle = dlopen(filename != NULL ? filename : module_name, RTLD_NOW | RTLD_LOCAL);
+	js_free(ctx, filename);
+	if (handle == NULL) {
+		JS_ThrowReferenceError(ctx, "could not load module '%s' as shared library: %s", module_name, dlerror());
+		return NULL;
+	}
+	JSModuleDef *(*init)(JSContext *, const char *) = dlsym(handle, "js_init_module");
+	if (init == NULL) {
+		dlclose(handle);
+		JS_ThrowReferenceError(ctx, "could not load module '%s': js_init_module not found", module_name);
+		return NULL;
+	}
+	JSModuleDef *m = init(ctx, module_name);
+	if (m == NULL) {
+		dlclose(handle);
+		JS_ThrowReferenceError(ctx, "could not load module '%s': initialization error", module_name);
+		return NULL;
+	}
+	return m;
+#endif
+}
+
+JSModuleDef *ModuleLoader(JSContext *ctx, const char *module_name, void *opaque) {
+	uintptr_t flags = (uintptr_t)opaque;
+	if (hasSuffix(module_name, ".so") || hasSuffix(module_name, ".dylib")) {
+		if (!(flags & MODULE_LOADER_NATIVE)) {
+			JS_ThrowReferenceError(ctx, "could not load module '%s': native modules are not enabled", module_name);
+			return NULL;
+		}
+		return loadNativeModule(ctx, module_name);
+	}
+	if (flags & MODULE_LOADER_GO) {
+		return InvokeModuleLoader(ctx, module_name, opaque);
+	}
+	return js_module_loader(ctx, module_name, opaque);
+}
+
+void SetModuleLoader(JSRuntime *rt, int normalize, int load, uintptr_t flags) {
+	JS_SetModuleLoaderFunc(rt, normalize ? InvokeModuleNormalize : NULL, load ? ModuleLoader : NULL, (void *)flags);
+}
+
+char *InvokeModuleNormalize(JSContext *ctx, const char *module_base_name, const char *module_name, void *opaque) {
+	char *name = goModuleNormalize(ctx, (char *)module_base_name, (char *)module_name);
+	if (name == NULL) {
+		return NULL;
+	}
+	// The engine frees the name with js_free.
+	size_t len = strlen(name);
+	char *ret = js_malloc(ctx, len + 1);
+	if (ret != NULL) {
+		memcpy(ret, name, len + 1);
+	}
+	free(name);
+	return ret;
+}
+
+JSModuleDef *CompileModuleDef(JSContext *ctx, const char *code, size_t code_len, const char *module_name) {
+	JSValue func_val = JS_Eval(ctx, code, code_len, module_name, JS_EVAL_TYPE_MODULE | JS_EVAL_FLAG_COMPILE_ONLY);
+	if (JS_IsException(func_val)) {
+		return NULL;
+	}
+	js_module_set_import_meta(ctx, func_val, 0, 0);
+	JSModuleDef *m = JS_VALUE_GET_PTR(func_val);
+	JS_FreeValue(ctx, func_val);
+	return m;
+}
+
+#define ALLOC_HEADER_SIZE 16
+
+// stealing is the memory of the ArrayBuffer being detached by StealArrayBuffer: the free function receiving it keeps
+// it allocated instead of freeing it.
+static THREAD_LOCAL void *stealing;
+
+static int keepStolen(void *ptr) {
+	if (ptr == NULL || ptr != stealing) {
 		return 0;
 	}
+	stealing = NULL;
+	return 1;
+}
+
+static void updateMemoryStats(JSMallocState *s) {
+	RuntimeStats *stats = s->opaque;
+	atomicStoreSize(&stats->memory_used, s->malloc_size);
+	if (s->malloc_size > stats->peak_memory) {
+		atomicStoreSize(&stats->peak_memory, s->malloc_size);
+	}
+	if (s->malloc_size > stats->eval_peak) {
+		stats->eval_peak = s->malloc_size;
+	}
+}
+
+// overLimit reports whether allocating size more bytes, in a block of block bytes, exceeds the memory limit or the
+// maximum size of an allocation.
+static int overLimit(JSMallocState *s, size_t size, size_t block) {
+	RuntimeStats *stats = s->opaque;
+	return s->malloc_size + size > s->malloc_limit || (stats->max_alloc && block > stats->max_alloc);
+}
+
+// noteStack counts the allocations made within the last quarter, up to 64 KiB, of the maximum stack size: the engine
+// allocates its stack overflow errors there, so Go can tell them from the errors scripts throw with the same message.
+static void noteStack(RuntimeStats *stats) {
+	uintptr_t sp = (uintptr_t)__builtin_frame_address(0);
+	size_t margin = stats->max_stack / 4 < 64 * 1024 ? stats->max_stack / 4 : 64 * 1024;
+	if (stats->max_stack && sp < stats->stack_top && stats->stack_top - sp + margin > stats->max_stack) {
+		stats->stack_hits++;
+	}
+}
+
+// refuse counts an allocation refused, so that Go can tell the out of memory errors of the engine from those scripts
+// throw with the same message.
+static void *refuse(JSMallocState *s) {
+	RuntimeStats *stats = s->opaque;
+	stats->alloc_failures++;
+	return NULL;
+}
+
+static void *statsMalloc(JSMallocState *s, size_t size) {
+	noteStack(s->opaque);
+	if (overLimit(s, size + ALLOC_HEADER_SIZE, size)) {
+		return refuse(s);
+	}
+	size_t *p = malloc(size + ALLOC_HEADER_SIZE);
+	if (!p) {
+		return refuse(s);
+	}
+	*p = size;
+	s->malloc_count++;
+	s->malloc_size += size + ALLOC_HEADER_SIZE;
+	updateMemoryStats(s);
+	return (char *)p + ALLOC_HEADER_SIZE;
+}
+
+static void statsFree(JSMallocState *s, void *ptr) {
+	if (!ptr) {
+		return;
+	}
+	size_t *p = (size_t *)((char *)ptr - ALLOC_HEADER_SIZE);
+	s->malloc_count--;
+	s->malloc_size -= *p + ALLOC_HEADER_SIZE;
+	updateMemoryStats(s);
+	if (keepStolen(ptr)) {
+		return;
+	}
+	free(p);
+}
+
+static void *statsRealloc(JSMallocState *s, void *ptr, size_t size) {
+	if (!ptr) {
+		return size == 0 ? NULL : statsMalloc(s, size);
+	}
+	if (size == 0) {
+		statsFree(s, ptr);
+		return NULL;
+	}
+	size_t *p = (size_t *)((char *)ptr - ALLOC_HEADER_SIZE);
+	size_t old_size = *p;
+	if (size > old_size && overLimit(s, size - old_size, size)) {
+		return refuse(s);
+	}
+	p = realloc(p, size + ALLOC_HEADER_SIZE);
+	if (!p) {
+		return refuse(s);
+	}
+	*p = size;
+	s->malloc_size = s->malloc_size - old_size + size;
+	updateMemoryStats(s);
+	return (char *)p + ALLOC_HEADER_SIZE;
+}
 
-	time_t now = time(NULL);
-	if (now - start > timeout) {
-		free(ts);
-		return 1;
+static size_t statsUsableSize(const void *ptr) {
+	if (!ptr) {
+		return 0;
 	}
+	return *(const size_t *)((const char *)ptr - ALLOC_HEADER_SIZE);
+}
+
+static const JSMallocFunctions statsMallocFunctions = {
+	statsMalloc,
+	statsFree,
+	statsRealloc,
+	statsUsableSize,
+};
 
+JSRuntime *NewRuntime(RuntimeStats **stats) {
+	RuntimeStats *s = calloc(1, sizeof(RuntimeStats));
+	if (!s) {
+		return NULL;
+	}
+	JSRuntime *rt = JS_NewRuntime2(&statsMallocFunctions, s);
+	if (!rt) {
+		free(s);
+		return NULL;
+	}
+	SetMaxStackSize(rt, s, JS_DEFAULT_STACK_SIZE);
+	*stats = s;
+	return rt;
+}
+
+// SetMaxStackSize sets the maximum stack size of the runtime, measured from the current stack pointer, and records it
+// for noteStack.
+void SetMaxStackSize(JSRuntime *rt, RuntimeStats *stats, size_t size) {
+	JS_UpdateStackTop(rt);
+	JS_SetMaxStackSize(rt, size);
+	stats->stack_top = (uintptr_t)__builtin_frame_address(0);
+	stats->max_stack = size;
+}
+
+// FreeRuntime frees the runtime, unless objects are still alive once its contexts are freed, in which case the engine
+// would fail an assertion: it then returns -1 without freeing the runtime, leaving its memory allocated. The pending
+// jobs, freed by the engine, may hold objects, so the check is skipped while there are some.
+int FreeRuntime(JSRuntime *rt, RuntimeStats *stats) {
+	js_std_free_handlers(rt);
+	if (!JS_IsJobPending(rt)) {
+		JSMemoryUsage usage;
+		JS_RunGC(rt);
+		JS_ComputeMemoryUsage(rt, &usage);
+		if (usage.obj_count > 0 || usage.js_func_count > 0) {
+			return -1;
+		}
+	}
+	JS_FreeRuntime(rt);
+	free(stats);
 	return 0;
 }
 
-void SetExecuteTimeout(JSRuntime *rt, time_t timeout){
-    TimeoutStruct* ts = malloc(sizeof(TimeoutStruct));
-    ts->start = time(NULL);
-    ts->timeout = timeout;
-    JS_SetInterruptHandler(rt, &timeoutHandler, ts);
-}
\ No newline at end of file
+static JSClassID gcSentinelClassID;
+
+static void gcSentinelFinalizer(JSRuntime *rt, JSValue val) {
+	RuntimeStats *stats = JS_GetOpaque(val, gcSentinelClassID);
+	atomicIncUint64(&stats->gc_runs);
+	stats->gc_armed = 0;
+}
+
+static JSClassDef gcSentinelClass = {
+	"GCSentinel",
+	.finalizer = gcSentinelFinalizer,
+};
+
+void InitGCSentinelClass() {
+	JS_NewClassID(&gcSentinelClassID);
+}
+
+void ArmGCSentinel(JSContext *ctx, RuntimeStats *stats) {
+	if (stats->gc_armed) {
+		return;
+	}
+	JSRuntime *rt = JS_GetRuntime(ctx);
+	if (!JS_IsRegisteredClass(rt, gcSentinelClassID) && JS_NewClass(rt, gcSentinelClassID, &gcSentinelClass) < 0) {
+		return;
+	}
+	// The sentinel only references itself, so it is freed (and counted) by the next cycle collection.
+	JSValue obj = JS_NewObjectClass(ctx, gcSentinelClassID);
+	if (JS_IsException(obj)) {
+		JS_FreeValue(ctx, JS_GetException(ctx));
+		return;
+	}
+	JS_SetOpaque(obj, stats);
+	JS_SetPropertyStr(ctx, obj, "self", JS_DupValue(ctx, obj));
+	JS_FreeValue(ctx, obj);
+	stats->gc_armed = 1;
+}
+
+void LoadRuntimeStats(RuntimeStats *stats, RuntimeStats *out) {
+	out->memory_used = atomicLoadSize(&stats->memory_used);
+	out->peak_memory = atomicLoadSize(&stats->peak_memory);
+	out->gc_runs = atomicLoadUint64(&stats->gc_runs);
+}
+
+// ResetEvalPeak starts measuring the peak memory of an evaluation and returns the peak measured so far.
+size_t ResetEvalPeak(RuntimeStats *stats) {
+	size_t outer = stats->eval_peak;
+	stats->eval_peak = stats->memory_used;
+	return outer;
+}
+
+// RestoreEvalPeak returns the peak memory of an evaluation and resumes measuring the peak of an outer evaluation.
+size_t RestoreEvalPeak(RuntimeStats *stats, size_t outer) {
+	size_t peak = stats->eval_peak;
+	if (outer > peak) {
+		stats->eval_peak = outer;
+	}
+	return peak;
+}
+
+int ValueHasRefCount(JSValueConst v) {
+	return JS_VALUE_HAS_REF_COUNT(v);
+}
+
+uintptr_t ValueGetPtr(JSValueConst v) {
+	return (uintptr_t)JS_VALUE_GET_PTR(v);
+}
+
+static JSClassID goObjectClassID;
+
+static void goObjectFinalizer(JSRuntime *rt, JSValue val) {
+	void *handle = JS_GetOpaque(val, goObjectClassID);
+	if (handle) {
+		goFinalizeObject((uintptr_t)handle);
+	}
+}
+
+static JSClassDef goObjectClass = {
+	"GoObject",
+	.finalizer = goObjectFinalizer,
+};
+
+// Instances of the classes of ClassBuilder keep the handle of their Go value in a class of their own, so that it can't
+// be read or copied by scripts.
+static JSClassID classInstanceClassID;
+
+static void classInstanceFinalizer(JSRuntime *rt, JSValue val) {
+	void *handle = JS_GetOpaque(val, classInstanceClassID);
+	if (handle) {
+		goFinalizeObject((uintptr_t)handle);
+	}
+}
+
+static JSClassDef classInstanceClass = {
+	"GoClassInstance",
+	.finalizer = classInstanceFinalizer,
+};
+
+void InitGoObjectClass() {
+	JS_NewClassID(&goObjectClassID);
+	JS_NewClassID(&classInstanceClassID);
+}
+
+JSValue NewGoObject(JSContext *ctx, uintptr_t handle) {
+	JSRuntime *rt = JS_GetRuntime(ctx);
+	if (!JS_IsRegisteredClass(rt, goObjectClassID) && JS_NewClass(rt, goObjectClassID, &goObjectClass) < 0) {
+		return JS_EXCEPTION;
+	}
+	JSValue obj = JS_NewObjectClass(ctx, goObjectClassID);
+	if (!JS_IsException(obj)) {
+		JS_SetOpaque(obj, (void *)handle);
+	}
+	return obj;
+}
+
+uintptr_t GetGoObjectHandle(JSValueConst v) {
+	return (uintptr_t)JS_GetOpaque(v, goObjectClassID);
+}
+
+JSValue NewClassInstance(JSContext *ctx, JSValueConst proto, uintptr_t handle) {
+	JSRuntime *rt = JS_GetRuntime(ctx);
+	if (!JS_IsRegisteredClass(rt, classInstanceClassID) && JS_NewClass(rt, classInstanceClassID, &classInstanceClass) < 0) {
+		return JS_EXCEPTION;
+	}
+	JSValue obj = JS_NewObjectProtoClass(ctx, proto, classInstanceClassID);
+	if (!JS_IsException(obj)) {
+		JS_SetOpaque(obj, (void *)handle);
+	}
+	return obj;
+}
+
+uintptr_t GetClassInstanceHandle(JSValueConst v) {
+	return (uintptr_t)JS_GetOpaque(v, classInstanceClassID);
+}
+
+static JSContext *workerNewContext(JSRuntime *rt) {
+	JSContext *ctx = JS_NewContext(rt);
+	if (!ctx)
+		return NULL;
+	JS_AddIntrinsicBigFloat(ctx);
+	JS_AddIntrinsicBigDecimal(ctx);
+	JS_AddIntrinsicOperators(ctx);
+	JS_EnableBignumExt(ctx, 1);
+	js_init_module_std(ctx, "std");
+	js_init_module_os(ctx, "os");
+	return ctx;
+}
+
+void InitWorkers() {
+	js_std_set_worker_new_context_func(workerNewContext);
+}
+
+// SharedBufferHeader must match the header quickjs-libc puts before SharedArrayBuffer memory, since the std
+// handlers duplicate and free the buffers of every SharedArrayBuffer.
+typedef struct {
+	int ref_count;
+	uint64_t buf[];
+} SharedBufferHeader;
+
+void *NewSharedBuffer(size_t size) {
+	SharedBufferHeader *sab = calloc(1, sizeof(SharedBufferHeader) + size);
+	if (!sab)
+		return NULL;
+	sab->ref_count = 1;
+	return sab->buf;
+}
+
+void ReleaseSharedBuffer(void *ptr) {
+	SharedBufferHeader *sab = (SharedBufferHeader *)((uint8_t *)ptr - sizeof(SharedBufferHeader));
+	if (atomicAddInt(&sab->ref_count, -1) == 0)
+		free(sab);
+}
+
+static void freeSharedBuffer(JSRuntime *rt, void *opaque, void *ptr) {
+	ReleaseSharedBuffer(ptr);
+}
+
+JSValue NewSharedArrayBuffer(JSContext *ctx, void *ptr, size_t len) {
+	return JS_NewArrayBuffer(ctx, ptr, len, freeSharedBuffer, NULL, 1);
+}
+
+void DupSharedBuffer(void *ptr) {
+	SharedBufferHeader *sab = (SharedBufferHeader *)((uint8_t *)ptr - sizeof(SharedBufferHeader));
+	atomicAddInt(&sab->ref_count, 1);
+}
+
+// StealArrayBuffer detaches an ArrayBuffer and returns its memory, allocated by the allocator of the runtimes, for
+// NewTransferredArrayBuffer to adopt it in another runtime without copying it. It throws and returns NULL if the
+// buffer is detached or its memory is not owned by the engine.
+void *StealArrayBuffer(JSContext *ctx, JSValueConst obj, size_t *len) {
+	uint8_t *ptr = JS_GetArrayBuffer(ctx, len, obj);
+	if (!ptr) {
+		return NULL;
+	}
+	stealing = ptr;
+	JS_DetachArrayBuffer(ctx, obj);
+	if (stealing) {
+		stealing = NULL;
+		JS_ThrowTypeError(ctx, "ArrayBuffer cannot be transferred");
+		return NULL;
+	}
+	return ptr;
+}
+
+void FreeTransferredBuffer(void *ptr) {
+	free((char *)ptr - ALLOC_HEADER_SIZE);
+}
+
+static void freeTransferredBuffer(JSRuntime *rt, void *opaque, void *ptr) {
+	if (!keepStolen(ptr)) {
+		FreeTransferredBuffer(ptr);
+	}
+}
+
+JSValue NewTransferredArrayBuffer(JSContext *ctx, void *ptr, size_t len) {
+	return JS_NewArrayBuffer(ctx, ptr, len, freeTransferredBuffer, NULL, 0);
+}
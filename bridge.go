@@ -2,6 +2,7 @@ package quickjs
 
 import (
 	"runtime/cgo"
+	"time"
 	"unsafe"
 )
 
@@ -32,7 +33,19 @@ func goProxy(ctx *C.JSContext, thisVal C.JSValueConst, argc C.int, argv *C.JSVal
 		args[i].ref = refs[2+i]
 	}
 
-	result := fn(ctxOrigin, Value{ctx: ctxOrigin, ref: thisVal}, args)
+	if ctxOrigin.maxHostDepth > 0 && ctxOrigin.hostDepth >= ctxOrigin.maxHostDepth {
+		return ctxOrigin.throwLimit(ErrHostCallDepth, "%s%d", hostCallDepthMessage, ctxOrigin.maxHostDepth)
+	}
+	ctxOrigin.hostDepth++
+	defer func() { ctxOrigin.hostDepth-- }()
+
+	start := time.Now()
+	result := ctxOrigin.runtime.state.wrapHostFunc(fn)(ctxOrigin, Value{ctx: ctxOrigin, ref: thisVal}, args)
+	ctxOrigin.traceHostCall(fn, start, result)
+	ctxOrigin.untrack(result)
+	if result.IsException() {
+		ctxOrigin.reportPendingThrow()
+	}
 
 	return result.ref
 }
@@ -41,16 +54,20 @@ func goProxy(ctx *C.JSContext, thisVal C.JSValueConst, argc C.int, argv *C.JSVal
 func goAsyncProxy(ctx *C.JSContext, thisVal C.JSValueConst, argc C.int, argv *C.JSValueConst) C.JSValue {
 	refs := unsafe.Slice(argv, argc) // Go 1.17 and later
 
-	// get the function
-	fnHandler := C.int64_t(0)
-	C.JS_ToInt64(ctx, &fnHandler, refs[0])
-	asyncFn := cgo.Handle(fnHandler).Value().(func(ctx *Context, this Value, promise Value, args []Value) Value)
-
 	// get ctx
 	ctxHandler := C.int64_t(0)
 	C.JS_ToInt64(ctx, &ctxHandler, refs[1])
 	ctxOrigin := cgo.Handle(ctxHandler).Value().(*Context)
 
+	// get the function; the handle 0 reports that the promise of a call settled
+	fnHandler := C.int64_t(0)
+	C.JS_ToInt64(ctx, &fnHandler, refs[0])
+	if fnHandler == 0 {
+		ctxOrigin.asyncCalls--
+		return C.JS_NewUndefined()
+	}
+	asyncFn := cgo.Handle(fnHandler).Value().(func(ctx *Context, this Value, promise Value, args []Value) Value)
+
 	args := make([]Value, len(refs)-2)
 	for i := 0; i < len(args); i++ {
 		args[i].ctx = ctxOrigin
@@ -58,18 +75,92 @@ func goAsyncProxy(ctx *C.JSContext, thisVal C.JSValueConst, argc C.int, argv *C.
 	}
 	promise := args[0]
 
-	result := asyncFn(ctxOrigin, Value{ctx: ctxOrigin, ref: thisVal}, promise, args[1:])
+	if ctxOrigin.maxHostDepth > 0 && ctxOrigin.hostDepth >= ctxOrigin.maxHostDepth {
+		return ctxOrigin.throwLimit(ErrHostCallDepth, "%s%d", hostCallDepthMessage, ctxOrigin.maxHostDepth)
+	}
+	if ctxOrigin.maxAsync > 0 && ctxOrigin.asyncCalls >= ctxOrigin.maxAsync {
+		return ctxOrigin.throwLimit(ErrAsyncCallLimit, "%s%d", asyncCallLimitMessage, ctxOrigin.maxAsync)
+	}
+	ctxOrigin.hostDepth++
+	defer func() { ctxOrigin.hostDepth-- }()
+	ctxOrigin.asyncCalls++
+
+	start := time.Now()
+	call := ctxOrigin.runtime.state.wrapHostFunc(func(ctx *Context, this Value, args []Value) Value {
+		return asyncFn(ctx, this, promise, args)
+	})
+	result := call(ctxOrigin, Value{ctx: ctxOrigin, ref: thisVal}, args[1:])
+	ctxOrigin.traceHostCall(asyncFn, start, result)
+	ctxOrigin.untrack(result)
+	if result.IsException() {
+		ctxOrigin.reportPendingThrow()
+		// The wrapper throws without waiting for the promise.
+		ctxOrigin.asyncCalls--
+	}
 	return result.ref
 
 }
 
+//export goFinalizeObject
+func goFinalizeObject(handle C.uintptr_t) {
+	h := cgo.Handle(handle)
+	obj := h.Value().(*goObject)
+	h.Delete()
+	if obj.finalizer != nil {
+		obj.finalizer(obj.data)
+	}
+}
+
 //export goInterruptHandler
-func goInterruptHandler(rt *C.JSRuntime, handlerArgs unsafe.Pointer) C.int {
-	handlerArgsStruct := (*C.handlerArgs)(handlerArgs)
+func goInterruptHandler(rt *C.JSRuntime, handle C.uintptr_t) C.int {
+	state := cgo.Handle(handle).Value().(*runtimeState)
+	return C.int(state.interrupt())
+}
+
+//export goModuleNormalize
+func goModuleNormalize(ctx *C.JSContext, moduleBaseName *C.char, moduleName *C.char) *C.char {
+	ctxOrigin := cgo.Handle(C.GetContextHandle(ctx)).Value().(*Context)
+	importer := C.GoString(moduleBaseName)
+	name := normalizeModuleName(importer, C.GoString(moduleName))
+
+	if policy := ctxOrigin.runtime.state.modulePolicy; policy != nil && !ctxOrigin.initializing {
+		if err := policy(importer, name); err != nil {
+			ctxOrigin.ThrowReferenceError("import of module '%s' denied: %s", name, err)
+			return nil
+		}
+	}
+	return C.CString(name)
+}
+
+//export goHostModuleInit
+func goHostModuleInit(ctx *C.JSContext, m *C.JSModuleDef) C.int {
+	ctxOrigin := cgo.Handle(C.GetContextHandle(ctx)).Value().(*Context)
+	atom := C.JS_GetModuleName(ctx, m)
+	ptr := C.JS_AtomToCString(ctx, atom)
+	name := C.GoString(ptr)
+	C.JS_FreeCString(ctx, ptr)
+	C.JS_FreeAtom(ctx, atom)
+	return C.int(ctxOrigin.initHostModule(m, name))
+}
+
+//export goModuleLoader
+func goModuleLoader(ctx *C.JSContext, moduleName *C.char, opaque unsafe.Pointer) *C.JSModuleDef {
+	ctxOrigin := cgo.Handle(C.GetContextHandle(ctx)).Value().(*Context)
+	name := C.GoString(moduleName)
+
+	code, err := ctxOrigin.runtime.options.moduleLoader(ctxOrigin, name)
+	if err != nil {
+		ctxOrigin.ThrowReferenceError("could not load module '%s': %s", name, err)
+		return nil
+	}
+	if err := ctxOrigin.registerSourceMap(name, code, nil); err != nil {
+		ctxOrigin.ThrowSyntaxError("%s", err)
+		return nil
+	}
+	code = ctxOrigin.instrumentThrows(ctxOrigin.instrumentCoverage(name, code))
 
-	hFn := cgo.Handle(handlerArgsStruct.fn)
-	hFnValue := hFn.Value().(InterruptHandler)
-	// defer hFn.Delete()
+	codePtr := C.CString(code)
+	defer C.free(unsafe.Pointer(codePtr))
 
-	return C.int(hFnValue())
+	return C.CompileModuleDef(ctx, codePtr, C.size_t(len(code)), moduleName)
 }
@@ -8,6 +8,7 @@
 extern JSValue JS_NewNull();
 extern JSValue JS_NewUndefined();
 extern JSValue JS_NewUninitialized();
+extern JSValue JS_NewException();
 extern JSValue ThrowSyntaxError(JSContext *ctx, const char *fmt) ;
 extern JSValue ThrowTypeError(JSContext *ctx, const char *fmt) ;
 extern JSValue ThrowReferenceError(JSContext *ctx, const char *fmt) ;
@@ -19,11 +20,61 @@ extern JSValue InvokeProxy(JSContext *ctx, JSValueConst this_val, int argc, JSVa
 extern JSValue InvokeAsyncProxy(JSContext *ctx, JSValueConst this_val, int argc, JSValueConst *argv);
 
 extern int ValueGetTag(JSValueConst v);
+extern JSModuleDef *ValueGetModule(JSValueConst v);
 
+extern void SetInterruptHandler(JSRuntime *rt, uintptr_t handle);
+
+extern void SetContextHandle(JSContext *ctx, uintptr_t handle);
+extern uintptr_t GetContextHandle(JSContext *ctx);
+extern int EnqueueCall(JSContext *ctx, JSValueConst fn);
+
+extern JSModuleDef *InvokeModuleLoader(JSContext *ctx, const char *module_name, void *opaque);
+
+#define MODULE_LOADER_GO 1
+#define MODULE_LOADER_NATIVE 2
+extern JSModuleDef *ModuleLoader(JSContext *ctx, const char *module_name, void *opaque);
+extern char *InvokeModuleNormalize(JSContext *ctx, const char *module_base_name, const char *module_name, void *opaque);
+extern void SetModuleLoader(JSRuntime *rt, int normalize, int load, uintptr_t flags);
+extern JSModuleDef *NewHostModule(JSContext *ctx, const char *module_name);
+extern JSModuleDef *CompileModuleDef(JSContext *ctx, const char *code, size_t code_len, const char *module_name);
 typedef struct {
-    uintptr_t fn;
-} handlerArgs;
+	size_t memory_used;
+	size_t peak_memory;
+	uint64_t gc_runs;
+	int gc_armed;
+	size_t eval_peak;
+	size_t max_alloc;
+	uint64_t alloc_failures;
+	uint64_t stack_hits;
+	uintptr_t stack_top;
+	size_t max_stack;
+} RuntimeStats;
+
+extern JSRuntime *NewRuntime(RuntimeStats **stats);
+extern void SetMaxStackSize(JSRuntime *rt, RuntimeStats *stats, size_t size);
+extern int FreeRuntime(JSRuntime *rt, RuntimeStats *stats);
+extern void InitGCSentinelClass();
+extern void ArmGCSentinel(JSContext *ctx, RuntimeStats *stats);
+extern void LoadRuntimeStats(RuntimeStats *stats, RuntimeStats *out);
+extern size_t ResetEvalPeak(RuntimeStats *stats);
+extern size_t RestoreEvalPeak(RuntimeStats *stats, size_t outer);
+
+extern int ValueHasRefCount(JSValueConst v);
+extern uintptr_t ValueGetPtr(JSValueConst v);
+
+extern void InitGoObjectClass();
+extern JSValue NewGoObject(JSContext *ctx, uintptr_t handle);
+extern uintptr_t GetGoObjectHandle(JSValueConst v);
+extern JSValue NewClassInstance(JSContext *ctx, JSValueConst proto, uintptr_t handle);
+extern uintptr_t GetClassInstanceHandle(JSValueConst v);
+
+extern void InitWorkers();
 
-extern void SetInterruptHandler(JSRuntime *rt, void *handlerArgs);
+extern void *NewSharedBuffer(size_t size);
+extern void ReleaseSharedBuffer(void *ptr);
+extern JSValue NewSharedArrayBuffer(JSContext *ctx, void *ptr, size_t len);
+extern void DupSharedBuffer(void *ptr);
+extern void *StealArrayBuffer(JSContext *ctx, JSValueConst obj, size_t *len);
+extern void FreeTransferredBuffer(void *ptr);
+extern JSValue NewTransferredArrayBuffer(JSContext *ctx, void *ptr, size_t len);
 
-extern void SetExecuteTimeout(JSRuntime *rt, time_t timeout);
\ No newline at end of file
@@ -0,0 +1,150 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "sync"
+
+// BroadcastHub connects the BroadcastChannel objects of several contexts, possibly in different runtimes such as
+// the workers of an Executor: a message posted to a channel is received by every other channel with the same name.
+type BroadcastHub struct {
+	mu       sync.Mutex
+	channels map[string]map[*MessagePort]struct{}
+}
+
+// NewBroadcastHub creates a hub without channels.
+func NewBroadcastHub() *BroadcastHub {
+	return &BroadcastHub{channels: make(map[string]map[*MessagePort]struct{})}
+}
+
+// PostJSON posts a message, given as JSON text, from Go to every channel with the given name.
+func (h *BroadcastHub) PostJSON(name string, json string) {
+	h.publish(name, &portMessage{json: json}, nil)
+}
+
+// publish delivers a copy of m to the channels with the given name except from.
+func (h *BroadcastHub) publish(name string, m *portMessage, from *MessagePort) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.channels[name] {
+		if ch == from {
+			continue
+		}
+		for _, ptr := range m.shared {
+			C.DupSharedBuffer(ptr)
+		}
+		ch.enqueue(&portMessage{data: m.data, json: m.json, shared: m.shared})
+	}
+	m.free()
+}
+
+func (h *BroadcastHub) subscribe(name string, ch *MessagePort) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.channels[name] == nil {
+		h.channels[name] = make(map[*MessagePort]struct{})
+	}
+	h.channels[name][ch] = struct{}{}
+}
+
+func (h *BroadcastHub) unsubscribe(name string, ch *MessagePort) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.channels[name], ch)
+	if len(h.channels[name]) == 0 {
+		delete(h.channels, name)
+	}
+}
+
+const broadcastChannelFactory = `(subscribe) => {
+	class BroadcastChannel {
+		#channel;
+		constructor(name) {
+			if (name === undefined) throw new TypeError("BroadcastChannel requires a name");
+			this.name = String(name);
+			this.onmessage = null;
+			this.#channel = subscribe(this.name, (data) => {
+				if (typeof this.onmessage === "function") this.onmessage({ data });
+			});
+		}
+		postMessage(message) {
+			if (!this.#channel) throw new TypeError("BroadcastChannel is closed");
+			this.#channel.post(message);
+		}
+		close() {
+			if (this.#channel) this.#channel.close();
+			this.#channel = null;
+		}
+	}
+	return BroadcastChannel;
+}`
+
+// InstallBroadcastChannel defines the global BroadcastChannel class of the context, connected to the channels of
+// the other contexts using hub. Messages are structured clones delivered while the receiving context runs Serve
+// (or, in an Executor, while its worker is idle). A channel with an onmessage handler stays alive until it is
+// closed or the context is closed.
+func (ctx *Context) InstallBroadcastChannel(hub *BroadcastHub) error {
+	if ctx.broadcasts == nil {
+		ctx.broadcasts = make(broadcastChannels)
+		ctx.closeHooks = append(ctx.closeHooks, func() {
+			for ch := range ctx.broadcasts {
+				ctx.closeBroadcast(ch)
+			}
+		})
+	}
+	subscribe := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		name := args[0].String()
+		ch := &MessagePort{ctx: ctx, deliverFn: C.JS_DupValue(ctx.ref, args[1].ref), obj: C.JS_NewUndefined()}
+		hub.subscribe(name, ch)
+		ctx.broadcasts[ch] = broadcastChannel{hub: hub, name: name}
+
+		handle := ctx.Object()
+		handle.Set("post", ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			m, ok := ctx.newMessage(args[0], nil)
+			if !ok {
+				return ctx.Throw(Value{ctx: ctx, ref: C.JS_GetException(ctx.ref)})
+			}
+			hub.publish(name, m, ch)
+			return ctx.Undefined()
+		}))
+		handle.Set("close", ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			ctx.closeBroadcast(ch)
+			return ctx.Undefined()
+		}))
+		return handle
+	})
+	defer subscribe.Free()
+
+	factory, err := ctx.Eval(broadcastChannelFactory, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer factory.Free()
+	class, err := ctx.InvokeE(factory, ctx.Null(), subscribe)
+	if err != nil {
+		return err
+	}
+	ctx.Globals().Set("BroadcastChannel", class)
+	return nil
+}
+
+// broadcastChannels holds the hub and name of the open BroadcastChannel objects of a context.
+type broadcastChannels map[*MessagePort]broadcastChannel
+
+type broadcastChannel struct {
+	hub  *BroadcastHub
+	name string
+}
+
+// closeBroadcast unsubscribes an open channel and frees its delivery function.
+func (ctx *Context) closeBroadcast(ch *MessagePort) {
+	sub, ok := ctx.broadcasts[ch]
+	if !ok {
+		return
+	}
+	delete(ctx.broadcasts, ch)
+	sub.hub.unsubscribe(sub.name, ch)
+	ch.close()
+	ch.detach()
+}
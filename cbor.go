@@ -0,0 +1,428 @@
+package quickjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+	"unicode/utf8"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// CBOR tags of RFC 8949 used for dates and BigInts; typed arrays use the tags of RFC 8746.
+const (
+	cborTagDateString = 0
+	cborTagEpochDate  = 1
+	cborTagPosBignum  = 2
+	cborTagNegBignum  = 3
+)
+
+// MarshalCBOR encodes the value tree in CBOR (RFC 8949). Objects, arrays and Maps become maps and arrays, Sets become
+// arrays, ArrayBuffers become byte strings, BigInts become bignums (tags 2 and 3), dates become epoch dates (tag 1)
+// and typed arrays use the little-endian tags of RFC 8746. Object properties holding functions or symbols are left
+// out, and circular structures are an error.
+func (v Value) MarshalCBOR() ([]byte, error) {
+	var enc cborEncoder
+	if err := v.ctx.encodeTree(v, &enc); err != nil {
+		return nil, err
+	}
+	return enc.buf, nil
+}
+
+// UnmarshalCBOR decodes CBOR encoded by MarshalCBOR or by other encoders into a value. Maps with string keys become
+// objects, other maps become Maps, integers that a float64 cannot represent exactly become BigInts, and unknown tags
+// are ignored.
+func (ctx *Context) UnmarshalCBOR(data []byte) (Value, error) {
+	d := cborDecoder{b: NewTreeBuilder(ctx), data: data}
+	v, err := d.next(0)
+	if err != nil {
+		return ctx.Null(), err
+	}
+	if d.pos != len(d.data) {
+		v.Free()
+		return ctx.Null(), errors.New("quickjs: unexpected data after CBOR value")
+	}
+	return v, nil
+}
+
+// GobEncode implements gob.GobEncoder by encoding the value tree in CBOR, so that values can be sent with
+// encoding/gob.
+func (v Value) GobEncode() ([]byte, error) {
+	return v.MarshalCBOR()
+}
+
+// GobDecode implements gob.GobDecoder for a value that already belongs to a context, such as ctx.Undefined(),
+// replacing it with the decoded value in the same context and freeing the previous value.
+func (v *Value) GobDecode(data []byte) error {
+	if v.ctx == nil {
+		return errors.New("quickjs: GobDecode needs a value of a context, such as ctx.Undefined()")
+	}
+	decoded, err := v.ctx.UnmarshalCBOR(data)
+	if err != nil {
+		return err
+	}
+	v.Free()
+	*v = decoded
+	return nil
+}
+
+type cborEncoder struct {
+	buf []byte
+}
+
+// head writes the initial byte of a data item of the major type with the argument n.
+func (e *cborEncoder) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, major|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, major|27), n)
+	}
+}
+
+func (e *cborEncoder) null(undefined bool) {
+	if undefined {
+		e.buf = append(e.buf, 0xf7)
+	} else {
+		e.buf = append(e.buf, 0xf6)
+	}
+}
+
+func (e *cborEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 0xf5)
+	} else {
+		e.buf = append(e.buf, 0xf4)
+	}
+}
+
+func (e *cborEncoder) int(n int64) {
+	if n >= 0 {
+		e.head(cborUint, uint64(n))
+	} else {
+		e.head(cborNegInt, uint64(-1-n))
+	}
+}
+
+func (e *cborEncoder) float(f float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xfb), math.Float64bits(f))
+}
+
+func (e *cborEncoder) bigInt(n *big.Int) {
+	if n.Sign() >= 0 {
+		e.head(cborTag, cborTagPosBignum)
+		e.bytes(n.Bytes())
+		return
+	}
+	e.head(cborTag, cborTagNegBignum)
+	e.bytes(new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).Bytes())
+}
+
+func (e *cborEncoder) string(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cborEncoder) bytes(b []byte) {
+	e.head(cborBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *cborEncoder) typedArray(tag uint64, b []byte) {
+	e.head(cborTag, tag)
+	e.bytes(b)
+}
+
+// date writes an epoch date, in whole seconds if possible; invalid dates, which have no time, are written as null.
+func (e *cborEncoder) date(ms float64) {
+	if math.IsNaN(ms) {
+		e.null(false)
+		return
+	}
+	e.head(cborTag, cborTagEpochDate)
+	if math.Mod(ms, 1000) == 0 {
+		e.int(int64(ms / 1000))
+	} else {
+		e.float(ms / 1000)
+	}
+}
+
+func (e *cborEncoder) array(n int)  { e.head(cborArray, uint64(n)) }
+func (e *cborEncoder) object(n int) { e.head(cborMap, uint64(n)) }
+
+var errCBORTruncated = errors.New("quickjs: truncated CBOR data")
+
+// cborBreak is returned by next for the break code ending an indefinite length item.
+var cborBreak = errors.New("quickjs: unexpected CBOR break")
+
+type cborDecoder struct {
+	b    TreeBuilder
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.pos) < n {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the initial byte of a data item, returning its major type, its argument and whether it has an
+// indefinite length.
+func (d *cborDecoder) head() (major byte, arg uint64, indefinite bool, err error) {
+	c, err := d.read(1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, info := c[0]>>5, c[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info <= 27:
+		b, err := d.read(1 << (info - 24))
+		if err != nil {
+			return 0, 0, false, err
+		}
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, arg, false, nil
+	case info == 31 && major != cborUint && major != cborNegInt && major != cborTag:
+		return major, 0, true, nil
+	}
+	return 0, 0, false, fmt.Errorf("quickjs: invalid CBOR initial byte 0x%02x", c[0])
+}
+
+func (d *cborDecoder) next(depth int) (Value, error) {
+	if depth > maxTreeDepth {
+		return Value{}, errors.New("quickjs: CBOR value nested too deeply")
+	}
+	start := d.pos
+	major, arg, indefinite, err := d.head()
+	if err != nil {
+		return Value{}, err
+	}
+	ctx := d.b.ctx
+	switch major {
+	case cborUint:
+		return d.b.Int(new(big.Int).SetUint64(arg))
+	case cborNegInt:
+		n := new(big.Int).SetUint64(arg)
+		return d.b.Int(n.Sub(big.NewInt(-1), n))
+	case cborBytes:
+		b, err := d.chunks(cborBytes, arg, indefinite)
+		if err != nil {
+			return Value{}, err
+		}
+		return d.b.Bytes(b), nil
+	case cborText:
+		b, err := d.chunks(cborText, arg, indefinite)
+		if err != nil {
+			return Value{}, err
+		}
+		if !utf8.Valid(b) {
+			return Value{}, errors.New("quickjs: invalid UTF-8 in CBOR text string")
+		}
+		return ctx.stringLen(string(b)), nil
+	case cborArray:
+		return d.array(arg, indefinite, depth)
+	case cborMap:
+		return d.mapValue(arg, indefinite, depth)
+	case cborTag:
+		return d.tag(arg, depth)
+	}
+
+	// Major type 7: simple values and floats, whose argument is read by head for the 2, 4 and 8 byte floats.
+	switch info := d.data[start] & 0x1f; {
+	case indefinite:
+		return Value{}, cborBreak
+	case info == 20, info == 21:
+		return ctx.Bool(info == 21), nil
+	case info == 22:
+		return ctx.Null(), nil
+	case info == 23:
+		return ctx.Undefined(), nil
+	case info == 25:
+		return ctx.Float64(halfToFloat(uint16(arg))), nil
+	case info == 26:
+		return ctx.Float64(float64(math.Float32frombits(uint32(arg)))), nil
+	case info == 27:
+		return ctx.Float64(math.Float64frombits(arg)), nil
+	}
+	return Value{}, fmt.Errorf("quickjs: unsupported CBOR simple value %d", arg)
+}
+
+// chunks reads the content of a byte or text string, joining the chunks of an indefinite length string.
+func (d *cborDecoder) chunks(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		return d.read(n)
+	}
+	var out []byte
+	for {
+		if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+			d.pos++
+			return out, nil
+		}
+		m, n, indefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || indefinite {
+			return nil, errors.New("quickjs: invalid chunk in indefinite length CBOR string")
+		}
+		b, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+}
+
+// more reports whether an array or map has more items: the count is not reached, or for an indefinite length, the
+// break code is not next.
+func (d *cborDecoder) more(i, n uint64, indefinite bool) bool {
+	if !indefinite {
+		return i < n
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+		d.pos++
+		return false
+	}
+	return true
+}
+
+func (d *cborDecoder) array(n uint64, indefinite bool, depth int) (Value, error) {
+	if !indefinite && n > uint64(len(d.data)-d.pos) {
+		return Value{}, errCBORTruncated
+	}
+	var items []Value
+	for i := uint64(0); d.more(i, n, indefinite); i++ {
+		item, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(items)
+			return Value{}, err
+		}
+		items = append(items, item)
+	}
+	return d.b.Array(items), nil
+}
+
+func (d *cborDecoder) mapValue(n uint64, indefinite bool, depth int) (Value, error) {
+	if !indefinite && n > uint64(len(d.data)-d.pos) {
+		return Value{}, errCBORTruncated
+	}
+	var keys, values []Value
+	for i := uint64(0); d.more(i, n, indefinite); i++ {
+		key, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(keys)
+			freeValues(values)
+			return Value{}, err
+		}
+		keys = append(keys, key)
+		value, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(keys)
+			freeValues(values)
+			return Value{}, err
+		}
+		values = append(values, value)
+	}
+	return d.b.Map(keys, values), nil
+}
+
+func (d *cborDecoder) tag(tag uint64, depth int) (Value, error) {
+	switch tag {
+	case cborTagPosBignum, cborTagNegBignum:
+		major, n, indefinite, err := d.head()
+		if err != nil {
+			return Value{}, err
+		}
+		if major != cborBytes {
+			return Value{}, errors.New("quickjs: CBOR bignum is not a byte string")
+		}
+		b, err := d.chunks(cborBytes, n, indefinite)
+		if err != nil {
+			return Value{}, err
+		}
+		i := new(big.Int).SetBytes(b)
+		if tag == cborTagNegBignum {
+			i.Sub(big.NewInt(-1), i)
+		}
+		return d.b.BigInt(i)
+	case cborTagEpochDate, cborTagDateString:
+		v, err := d.next(depth + 1)
+		if err != nil {
+			return Value{}, err
+		}
+		defer v.Free()
+		switch {
+		case tag == cborTagEpochDate && v.IsNumber():
+			return d.b.Date(math.Round(v.Float64() * 1000))
+		case tag == cborTagDateString && v.IsString():
+			t, err := time.Parse(time.RFC3339Nano, v.String())
+			if err != nil {
+				return Value{}, fmt.Errorf("quickjs: invalid CBOR date: %w", err)
+			}
+			return d.b.Date(float64(t.UnixMilli()))
+		}
+		return Value{}, errors.New("quickjs: invalid CBOR date")
+	}
+	if name := typedArrayName(tag); name != "" {
+		major, n, indefinite, err := d.head()
+		if err != nil {
+			return Value{}, err
+		}
+		if major != cborBytes {
+			return Value{}, errors.New("quickjs: CBOR typed array is not a byte string")
+		}
+		b, err := d.chunks(cborBytes, n, indefinite)
+		if err != nil {
+			return Value{}, err
+		}
+		return d.b.TypedArray(tag, b)
+	}
+	return d.next(depth + 1)
+}
+
+// halfToFloat converts an IEEE 754 half precision float.
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(h>>10&0x1f), float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,57 @@
+package quickjs
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// AddChannel registers a Go channel with the event loop of the context: each value received from ch is passed to
+// handler on the goroutine serving the context with Serve (or, in an Executor, while its worker is idle), followed
+// by the promise jobs it schedules. The goroutine forwarding the values blocks until each one is handled, so values
+// are handled in order and a busy context slows down the producer instead of buffering.
+//
+// Forwarding stops when ch is closed, when remove is called or when the context is closed. It fails if ch is not a
+// channel that can be received from.
+func (ctx *Context) AddChannel(ch interface{}, handler func(ctx *Context, value interface{})) (remove func(), err error) {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.RecvDir == 0 {
+		return nil, errors.New("quickjs: AddChannel requires a receivable channel")
+	}
+
+	stop := make(chan struct{})
+	var once sync.Once
+	remove = func() { once.Do(func() { close(stop) }) }
+	_, closed := ctx.gatewayChans()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: chv},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(closed)},
+	}
+	go func() {
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen != 0 || !ok {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			value := v.Interface()
+			err := ctx.Do(func(ctx *Context) error {
+				select {
+				case <-stop:
+				default:
+					handler(ctx, value)
+				}
+				return nil
+			})
+			if errors.Is(err, ErrContextClosed) {
+				return
+			}
+		}
+	}()
+	return remove, nil
+}
@@ -0,0 +1,128 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unsafe"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// importErrorRe matches the errors of the imports that could not be resolved, naming the module.
+var importErrorRe = regexp.MustCompile(`module '([^']*)'`)
+
+// Check compiles code without executing it and returns its problems, none if it is valid, for editors and script
+// management UIs to validate scripts when they are saved. Modules, detected as Eval does or forced with
+// EvalFlagModule, have their imports resolved too: the modules they import are loaded, with the module loader and
+// policy of the runtime, and checked likewise, but not evaluated. The code is compiled in a separate context, see
+// isolated, so nothing is left defined in ctx.
+//
+// The problems are the syntax error of the script (see SyntaxErrors), or of a module it imports, and the imports
+// that can't be resolved, located at their specifier.
+func (ctx *Context) Check(code string, opts ...EvalOption) []Diagnostic {
+	options := EvalOptions{filename: "<input>"}
+	for _, fn := range opts {
+		fn(&options)
+	}
+	codePtr := C.CString(code)
+	defer C.free(unsafe.Pointer(codePtr))
+
+	var err error
+	if isoErr := ctx.isolated(func(realm *Context) error {
+		if options.js_eval_type_module || C.JS_DetectModule(codePtr, C.size_t(len(code))) != 0 {
+			// A resolved module belongs to the realm, which frees it.
+			_, err = realm.compileModule(code, options.filename)
+			if err != nil && C.JS_HasException(realm.ref) != 0 {
+				err = realm.Exception()
+			}
+		} else {
+			var v Value
+			if v, err = realm.Eval(code, append(opts, EvalFlagCompileOnly(true))...); err == nil {
+				v.Free()
+			}
+		}
+		return nil
+	}); isoErr != nil {
+		err = isoErr
+	}
+	if err == nil {
+		return nil
+	}
+
+	var diags SyntaxErrors
+	if errors.As(err, &diags) {
+		return diags
+	}
+	d := Diagnostic{Message: err.Error(), File: options.filename}
+	var jsErr *Error
+	if errors.As(err, &jsErr) {
+		if len(jsErr.Frames) == 1 && jsErr.Frames[0].Func == "" && strings.HasPrefix(jsErr.Cause, "SyntaxError: ") {
+			// A syntax error of an imported module, whose source is unknown.
+			d.Message = strings.TrimPrefix(jsErr.Cause, "SyntaxError: ")
+			d.File, d.Line = jsErr.Frames[0].File, jsErr.Frames[0].Line
+			return []Diagnostic{d}
+		}
+	}
+	if m := importErrorRe.FindStringSubmatch(d.Message); m != nil {
+		locateImport(&d, code, m[1])
+	}
+	return []Diagnostic{d}
+}
+
+// definedModule is a module defined in a context by LoadModule, from its code, by LoadModuleBytecode, from its
+// bytecode, or by LoadHostModule, from the names of its exports, rather than by the module loader.
+type definedModule struct {
+	name     string
+	code     string
+	bytecode []byte
+	exports  []string
+}
+
+// isolated runs fn with a new context, as WithRealm does, in which the modules defined in ctx are compiled again,
+// but not evaluated, so that the code compiled by fn resolves the imports it would in ctx without defining modules in
+// ctx. The modules that fail to compile in the realm are left undefined.
+func (ctx *Context) isolated(fn func(realm *Context) error) error {
+	return ctx.WithRealm(func(realm *Context) error {
+		for _, m := range ctx.modules {
+			var err error
+			if m.bytecode != nil {
+				_, err = realm.readModule(m.bytecode)
+			} else if m.exports != nil {
+				err = realm.newHostModule(m.name, m.exports)
+			} else {
+				_, err = realm.compileModule(m.code, m.name)
+			}
+			if err != nil && C.JS_HasException(realm.ref) != 0 {
+				C.JS_FreeValue(realm.ref, C.JS_GetException(realm.ref))
+			}
+		}
+		return fn(realm)
+	})
+}
+
+// locateImport sets the location of d to the first string literal of code naming the module name, as imported by
+// d.File.
+func locateImport(d *Diagnostic, code, name string) {
+	toks, err := jslex.Tokenize(code)
+	if err != nil {
+		return
+	}
+	for _, tok := range toks {
+		if tok.Kind != jslex.String {
+			continue
+		}
+		if normalizeModuleName(d.File, unquoteJS(tok.Text)) != name {
+			continue
+		}
+		lines := strings.Split(code, "\n")
+		d.Line, d.Token = tok.Line, tok.Text
+		d.Excerpt = strings.TrimSuffix(lines[tok.Line-1], "\r")
+		d.Col = len([]rune(d.Excerpt[:tok.Col-1])) + 1
+		return
+	}
+}
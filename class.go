@@ -0,0 +1,182 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "runtime/cgo"
+
+// ClassConstructor creates the Go value backing a new instance of a class; an error is thrown to JS.
+type ClassConstructor func(ctx *Context, this Value, args []Value) (interface{}, error)
+
+// ClassMethod implements a method of a class; data is the Go value backing this.
+type ClassMethod func(ctx *Context, this Value, data interface{}, args []Value) Value
+
+type classAccessor struct {
+	name   string
+	getter func(ctx *Context, this Value, data interface{}) Value
+	setter func(ctx *Context, this Value, data interface{}, value Value) Value
+}
+
+// ClassBuilder builds a JS class whose instances are backed by Go values. Scripts may extend the class; instances of
+// subclasses are backed by the value created by the constructor when they call super(). The Go value is held by the
+// engine object itself, not by a property, so scripts can neither read it nor give it to another object.
+type ClassBuilder struct {
+	ctx         *Context
+	name        string
+	constructor ClassConstructor
+	methods     []string
+	impls       map[string]ClassMethod
+	accessors   []classAccessor
+	finalizer   func(data interface{})
+}
+
+// Class starts building a class with the given name and constructor.
+func (ctx *Context) Class(name string, constructor ClassConstructor) *ClassBuilder {
+	return &ClassBuilder{ctx: ctx, name: name, constructor: constructor, impls: make(map[string]ClassMethod)}
+}
+
+// Method adds a method to the prototype of the class.
+func (b *ClassBuilder) Method(name string, fn ClassMethod) *ClassBuilder {
+	if _, ok := b.impls[name]; !ok {
+		b.methods = append(b.methods, name)
+	}
+	b.impls[name] = fn
+	return b
+}
+
+// Accessor adds an accessor property to the prototype of the class. Either function may be nil.
+func (b *ClassBuilder) Accessor(name string, getter func(ctx *Context, this Value, data interface{}) Value, setter func(ctx *Context, this Value, data interface{}, value Value) Value) *ClassBuilder {
+	b.accessors = append(b.accessors, classAccessor{name: name, getter: getter, setter: setter})
+	return b
+}
+
+// Finalizer sets a function called with the Go value of an instance when the instance is garbage collected.
+func (b *ClassBuilder) Finalizer(fn func(data interface{})) *ClassBuilder {
+	b.finalizer = fn
+	return b
+}
+
+const classFactory = `(name, construct) => {
+	const cls = class {
+		constructor(...args) {
+			return construct(new.target, ...args);
+		}
+	};
+	Object.defineProperty(cls, "name", { value: name });
+	return cls;
+}`
+
+// Build creates the class constructor, to be set on the global object or exported from a module.
+func (b *ClassBuilder) Build() (Value, error) {
+	ctx := b.ctx
+	// The instance is created with the prototype of new.target, so that subclasses calling super() get it too, and
+	// holds its Go value once the constructor succeeds.
+	construct := ctx.Function(func(ctx *Context, _ Value, args []Value) Value {
+		proto := args[0].Get("prototype")
+		defer proto.Free()
+		obj := &goObject{class: b}
+		handle := cgo.NewHandle(obj)
+		this := Value{ctx: ctx, ref: C.NewClassInstance(ctx.ref, proto.ref, C.uintptr_t(handle))}
+		if this.IsException() {
+			handle.Delete()
+			return ctx.Throw(Value{ctx: ctx, ref: C.JS_GetException(ctx.ref)})
+		}
+		data, err := b.constructor(ctx, this, args[1:])
+		if err != nil {
+			C.JS_FreeValue(ctx.ref, this.ref)
+			return ctx.ThrowError(err)
+		}
+		obj.data, obj.finalizer = data, b.finalizer
+		return ctx.track(this)
+	})
+	defer construct.Free()
+
+	factory, err := ctx.Eval(classFactory, EvalFlagInternal(true))
+	if err != nil {
+		return ctx.Undefined(), err
+	}
+	defer factory.Free()
+	name := ctx.String(b.name)
+	defer name.Free()
+	cls := ctx.Invoke(factory, ctx.Null(), name, construct)
+	if cls.IsException() {
+		return cls, ctx.Exception()
+	}
+
+	proto := cls.Get("prototype")
+	defer proto.Free()
+	for _, methodName := range b.methods {
+		impl := b.impls[methodName]
+		fn := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			data, ok := b.instanceData(this)
+			if !ok {
+				return ctx.ThrowTypeError("%s.%s called on an incompatible receiver", b.name, methodName)
+			}
+			return impl(ctx, this, data, args)
+		})
+		ctx.untrack(fn)
+		prop := ctx.Atom(methodName)
+		ret := C.JS_DefinePropertyValue(ctx.ref, proto.ref, prop.ref, fn.ref, C.JS_PROP_CONFIGURABLE|C.JS_PROP_WRITABLE)
+		prop.Free()
+		if ret < 0 {
+			cls.Free()
+			return ctx.Undefined(), ctx.Exception()
+		}
+	}
+	for _, a := range b.accessors {
+		a := a
+		var getter func(ctx *Context, this Value) Value
+		var setter func(ctx *Context, this Value, value Value) Value
+		if a.getter != nil {
+			getter = func(ctx *Context, this Value) Value {
+				data, ok := b.instanceData(this)
+				if !ok {
+					return ctx.ThrowTypeError("%s.%s read on an incompatible receiver", b.name, a.name)
+				}
+				return a.getter(ctx, this, data)
+			}
+		}
+		if a.setter != nil {
+			setter = func(ctx *Context, this Value, value Value) Value {
+				data, ok := b.instanceData(this)
+				if !ok {
+					return ctx.ThrowTypeError("%s.%s set on an incompatible receiver", b.name, a.name)
+				}
+				return a.setter(ctx, this, data, value)
+			}
+		}
+		if err := proto.DefineAccessor(a.name, getter, setter); err != nil {
+			cls.Free()
+			return ctx.Undefined(), err
+		}
+	}
+	return cls, nil
+}
+
+// instanceData returns the Go value backing v if it is an instance of the class of b, or of one of its subclasses.
+func (b *ClassBuilder) instanceData(v Value) (interface{}, bool) {
+	obj := v.classInstance()
+	if obj == nil || obj.class != b {
+		return nil, false
+	}
+	return obj.data, true
+}
+
+// classInstance returns the Go value of an instance of a class created with a ClassBuilder, or nil.
+func (v Value) classInstance() *goObject {
+	handle := C.GetClassInstanceHandle(v.ref)
+	if handle == 0 {
+		return nil
+	}
+	return cgo.Handle(handle).Value().(*goObject)
+}
+
+// classData returns the Go value backing an instance of a class created with a ClassBuilder.
+func (v Value) classData() (interface{}, bool) {
+	obj := v.classInstance()
+	if obj == nil {
+		return nil, false
+	}
+	return obj.data, true
+}
@@ -0,0 +1,39 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// CloseLeakError reports that Close found objects still alive once the contexts of the runtime were freed, which the
+// engine asserts against, aborting the process. It is the only failure of the engine caught: Close then skips freeing
+// the runtime, so the whole memory of the runtime is leaked instead, and the process goes on. The other assertions of
+// the engine and its out-of-memory aborts still abort the process.
+type CloseLeakError struct {
+	Message string
+}
+
+func (err CloseLeakError) Error() string {
+	return "quickjs: " + err.Message
+}
+
+// OnCloseLeak sets a function called on the goroutine closing the runtime when Close finds objects still alive.
+func (r Runtime) OnCloseLeak(fn func(err *CloseLeakError)) {
+	r.state.onCloseLeak = fn
+}
+
+// CloseLeak returns the error of Close finding objects still alive, or nil.
+func (r Runtime) CloseLeak() error {
+	if r.state.closeLeak == nil {
+		return nil
+	}
+	return r.state.closeLeak
+}
+
+// setCloseLeak records that Close found objects still alive.
+func (r Runtime) setCloseLeak(message string) {
+	r.state.closeLeak = &CloseLeakError{Message: message}
+	if r.state.onCloseLeak != nil {
+		r.state.onCloseLeak(r.state.closeLeak)
+	}
+}
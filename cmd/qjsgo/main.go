@@ -0,0 +1,374 @@
+/*
+Command qjsgo runs, compiles and bundles JavaScript with quickjs-go, and starts a REPL.
+
+Usage:
+
+	qjsgo run [flags] file [args...]     run a script, module or bytecode file (.ts sources are stripped of types)
+	qjsgo compile [flags] file           compile a script or module to bytecode
+	qjsgo bundle [flags] entry           bundle a module graph into a single module
+	qjsgo repl                           start an interactive session
+
+Bytecode files use the .qbc extension. Run "qjsgo <command> -h" for the flags of a command.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/repl"
+	"github.com/buke/quickjs-go/typescript"
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// bytecodeExt is the extension of the bytecode files written by compile and loaded by run.
+const bytecodeExt = ".qbc"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage: qjsgo <command> [flags] [arguments]
+
+commands:
+  run      run a script, module or bytecode file
+  compile  compile a script or module to bytecode
+  bundle   bundle a module graph into a single module
+  repl     start an interactive session
+`
+
+// run executes the command line args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "run":
+		err = runCommand(args[1:], stdout, stderr)
+	case "compile":
+		err = compileCommand(args[1:], stderr)
+	case "bundle":
+		err = bundleCommand(args[1:], stderr)
+	case "repl":
+		err = replCommand(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "qjsgo: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		var jsErr *quickjs.Error
+		if errors.As(err, &jsErr) && jsErr.Stack != "" {
+			fmt.Fprintf(stderr, "%s\n%s", jsErr.Cause, jsErr.Stack)
+		} else {
+			fmt.Fprintf(stderr, "qjsgo: %v\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// runtimeFlags are the runtime settings shared by run and repl.
+type runtimeFlags struct {
+	timeout time.Duration
+	memory  uint64
+}
+
+func (f *runtimeFlags) register(fs *flag.FlagSet) {
+	fs.DurationVar(&f.timeout, "timeout", 0, "interrupt the script, or each input of the REPL, after this duration (0 for none)")
+	fs.Uint64Var(&f.memory, "memory", 0, "memory limit in bytes (0 for none)")
+}
+
+// newRuntime creates a runtime loading modules and TypeScript from the file system, with a context printing to
+// stdout and stderr.
+func (f *runtimeFlags) newRuntime(stdout, stderr io.Writer) (quickjs.Runtime, *quickjs.Context) {
+	opts := []quickjs.Option{quickjs.WithModuleLoader(typescript.ModuleLoader(nil))}
+	if f.memory > 0 {
+		opts = append(opts, quickjs.WithMemoryLimit(f.memory))
+	}
+	rt := quickjs.NewRuntime(opts...)
+	ctx := rt.NewContext()
+	installConsole(ctx, stdout, stderr)
+	return rt, ctx
+}
+
+func runCommand(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: qjsgo run [flags] file [args...]")
+		fs.PrintDefaults()
+	}
+	var rf runtimeFlags
+	rf.register(fs)
+	module := fs.Bool("module", false, "evaluate a source file as a module even without import or export statements (default for .mjs)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing file")
+	}
+	file := fs.Arg(0)
+
+	rt, ctx := rf.newRuntime(stdout, stderr)
+	defer rt.Close()
+	defer ctx.Close()
+	scriptArgs := ctx.Array().ToValue()
+	for i, arg := range fs.Args() {
+		scriptArgs.SetIdx(int64(i), ctx.String(arg))
+	}
+	ctx.Globals().Set("scriptArgs", scriptArgs)
+	if rf.timeout > 0 {
+		deadline := time.Now().Add(rf.timeout)
+		ctx.SetInterruptHandler(func() int {
+			if time.Now().After(deadline) {
+				return 1
+			}
+			return 0
+		})
+	}
+
+	ret, err := evalFile(ctx, file, *module || filepath.Ext(file) == ".mjs")
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	ctx.Loop()
+	return nil
+}
+
+// evalFile evaluates a source or bytecode file, waiting for its promise or top-level await.
+func evalFile(ctx *quickjs.Context, file string, module bool) (quickjs.Value, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return ctx.Null(), err
+	}
+	if filepath.Ext(file) == bytecodeExt {
+		namespace, done, err := ctx.LoadModuleBytecodeAsync(b)
+		if errors.Is(err, quickjs.ErrNotModule) {
+			ret, err := ctx.EvalBytecode(b)
+			if err != nil {
+				return ret, err
+			}
+			return ctx.Await(ret)
+		}
+		if err != nil {
+			return ctx.Null(), err
+		}
+		namespace.Free()
+		return ctx.Await(done)
+	}
+	code := string(b)
+	if typescript.IsTypeScript(file) {
+		if code, err = typescript.Transform(code, file); err != nil {
+			return ctx.Null(), err
+		}
+	}
+	return ctx.Eval(code, quickjs.EvalFileName(file), quickjs.EvalFlagModule(module), quickjs.EvalAwait(true))
+}
+
+func compileCommand(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: qjsgo compile [flags] file")
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "", "output file (default: the input file with the "+bytecodeExt+" extension)")
+	module := fs.Bool("module", false, "compile as a module even without import or export statements")
+	strip := fs.Bool("strip", false, "strip debug information (file name, line numbers, variable names)")
+	name := fs.String("name", "", "module or file name recorded in the bytecode (default: the input file)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one input file")
+	}
+	file := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(file, filepath.Ext(file)) + bytecodeExt
+	}
+	if *name == "" {
+		*name = file
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	code := string(b)
+	if typescript.IsTypeScript(file) {
+		if code, err = typescript.Transform(code, file); err != nil {
+			return err
+		}
+	}
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	opts := []quickjs.EvalOption{quickjs.EvalFileName(*name)}
+	if *module {
+		opts = append(opts, quickjs.EvalFlagModule(true))
+	}
+	if *strip {
+		opts = append(opts, quickjs.EvalStrip(quickjs.StripDebug))
+	}
+	buf, err := ctx.Compile(code, opts...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, buf, 0o644)
+}
+
+func bundleCommand(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: qjsgo bundle [flags] entry")
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "", "output file (default: the entry file with the .bundle.js extension, or "+bytecodeExt+" with -compile)")
+	minify := fs.Bool("minify", false, "minify the bundle")
+	compile := fs.Bool("compile", false, "compile the bundle to bytecode")
+	strip := fs.Bool("strip", false, "strip debug information from the bytecode (with -compile)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one entry file")
+	}
+	entry := fs.Arg(0)
+	if *out == "" {
+		ext := ".bundle.js"
+		if *compile {
+			ext = bytecodeExt
+		}
+		*out = strings.TrimSuffix(entry, filepath.Ext(entry)) + ext
+	}
+
+	code, err := bundle(entry, *minify)
+	if err != nil {
+		return err
+	}
+	if !*compile {
+		return os.WriteFile(*out, []byte(code), 0o644)
+	}
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	opts := []quickjs.EvalOption{quickjs.EvalFileName(entry), quickjs.EvalFlagModule(true)}
+	if *strip {
+		opts = append(opts, quickjs.EvalStrip(quickjs.StripDebug))
+	}
+	buf, err := ctx.Compile(code, opts...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, buf, 0o644)
+}
+
+// bundle bundles the module graph of entry into a single ES module; the built-in "std" and "os" modules are left
+// as imports.
+func bundle(entry string, minify bool) (string, error) {
+	result := api.Build(api.BuildOptions{
+		EntryPoints:       []string{entry},
+		Bundle:            true,
+		Write:             false,
+		Outfile:           "bundle.js",
+		Format:            api.FormatESModule,
+		Platform:          api.PlatformNeutral,
+		Target:            api.ES2022, // keeps top-level await
+		External:          []string{"std", "os"},
+		MinifyWhitespace:  minify,
+		MinifyIdentifiers: minify,
+		MinifySyntax:      minify,
+		LogLevel:          api.LogLevelSilent,
+	})
+	if len(result.Errors) > 0 {
+		errs := make([]error, 0, len(result.Errors))
+		for _, msg := range result.Errors {
+			if msg.Location != nil {
+				errs = append(errs, fmt.Errorf("%s:%d:%d: %s", msg.Location.File, msg.Location.Line, msg.Location.Column, msg.Text))
+			} else {
+				errs = append(errs, errors.New(msg.Text))
+			}
+		}
+		return "", errors.Join(errs...)
+	}
+	for _, file := range result.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js") {
+			// Keep the bundle a module, detected from its first statement, even if it neither imports nor exports.
+			return "export {};\n" + string(file.Contents), nil
+		}
+	}
+	return "", errors.New("bundle produced no output")
+}
+
+func replCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var rf runtimeFlags
+	rf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rt, ctx := rf.newRuntime(stdout, stderr)
+	defer rt.Close()
+	defer ctx.Close()
+
+	var opts []repl.Option
+	if rf.timeout > 0 {
+		// Each input gets the whole timeout.
+		opts = append(opts, repl.WithEvalOptions(quickjs.EvalQuota(quickjs.Quota{WallTime: rf.timeout})))
+	}
+	return repl.New(ctx, opts...).Run(stdin, stdout, stderr)
+}
+
+// installConsole defines console.log, info, warn, error and debug, and print, writing their arguments to stdout, or
+// stderr for warn and error.
+func installConsole(ctx *quickjs.Context, stdout, stderr io.Writer) {
+	write := func(w io.Writer) func(*quickjs.Context, quickjs.Value, []quickjs.Value) quickjs.Value {
+		return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				if arg.IsString() {
+					parts[i] = arg.String()
+				} else {
+					parts[i] = arg.Inspect()
+				}
+			}
+			fmt.Fprintln(w, strings.Join(parts, " "))
+			return ctx.Undefined()
+		}
+	}
+	console := ctx.Object()
+	for _, name := range []string{"log", "info", "debug"} {
+		console.Set(name, ctx.Function(write(stdout)))
+	}
+	for _, name := range []string{"warn", "error"} {
+		console.Set(name, ctx.Function(write(stderr)))
+	}
+	ctx.Globals().Set("console", console)
+	ctx.Globals().Set("print", ctx.Function(write(stdout)))
+}
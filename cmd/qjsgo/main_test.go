@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.js": `import { add } from "./lib.ts";
+			console.log("sum", add(2, 3), { a: [1, 2] }, scriptArgs.slice(1));
+			await new Promise((resolve) => setTimeout(resolve, 5));
+			print("done");`,
+		"lib.ts":  `export const add = (a: number, b: number): number => a + b;`,
+		"fail.js": `throw new Error("boom");`,
+		"loop.js": `while (true) {}`,
+	})
+
+	code, stdout, stderr := runCLI("run", filepath.Join(dir, "main.js"), "arg")
+	require.Equal(t, 0, code, stderr)
+	require.Equal(t, "sum 5 { a: [ 1, 2 ] } [ 'arg' ]\ndone\n", stdout)
+
+	code, _, stderr = runCLI("run", filepath.Join(dir, "fail.js"))
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "Error: boom")
+
+	code, _, stderr = runCLI("run", "-timeout", "50ms", filepath.Join(dir, "loop.js"))
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, "interrupted")
+
+	code, _, _ = runCLI("nope")
+	require.Equal(t, 2, code)
+}
+
+func TestCompileAndBundle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"script.js": `print("script", 6 * 7)`,
+		"main.js":   `import { greet } from "./greet.js"; await null; print(greet("bundle"));`,
+		"greet.js":  `export const greet = (name) => "hello " + name;`,
+	})
+
+	code, _, stderr := runCLI("compile", "-strip", filepath.Join(dir, "script.js"))
+	require.Equal(t, 0, code, stderr)
+	code, stdout, stderr := runCLI("run", filepath.Join(dir, "script.qbc"))
+	require.Equal(t, 0, code, stderr)
+	require.Equal(t, "script 42\n", stdout)
+
+	code, _, stderr = runCLI("bundle", filepath.Join(dir, "main.js"))
+	require.Equal(t, 0, code, stderr)
+	bundled, err := os.ReadFile(filepath.Join(dir, "main.bundle.js"))
+	require.NoError(t, err)
+	require.NotContains(t, string(bundled), "./greet.js")
+	code, stdout, stderr = runCLI("run", filepath.Join(dir, "main.bundle.js"))
+	require.Equal(t, 0, code, stderr)
+	require.Equal(t, "hello bundle\n", stdout)
+
+	out := filepath.Join(dir, "app.qbc")
+	code, _, stderr = runCLI("bundle", "-compile", "-minify", "-o", out, filepath.Join(dir, "main.js"))
+	require.Equal(t, 0, code, stderr)
+	code, stdout, stderr = runCLI("run", out)
+	require.Equal(t, 0, code, stderr)
+	require.Equal(t, "hello bundle\n", stdout)
+}
+
+func TestREPL(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"repl"}, strings.NewReader("const a = 20\na * 2 + 2\n({ b: [1] })\n\"s\"\nmissing\n.exit\n"), &stdout, &stderr)
+	require.Equal(t, 0, code)
+	require.Contains(t, stdout.String(), "> 42\n")
+	require.Contains(t, stdout.String(), "> { b: [ 1 ] }\n")
+	require.Contains(t, stdout.String(), "> 's'\n")
+	require.Contains(t, stderr.String(), "'missing' is not defined")
+
+	// The timeout applies to each input, not to the session.
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"repl", "-timeout", "100ms"}, &slowReader{r: strings.NewReader("while (true) {}\n1 + 1\n"), delay: 200 * time.Millisecond}, &stdout, &stderr)
+	require.Equal(t, 0, code)
+	require.Contains(t, stderr.String(), "interrupted")
+	require.Contains(t, stdout.String(), "> 2\n")
+}
+
+// slowReader waits before each read, as a user typing.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
@@ -0,0 +1,190 @@
+// Package compress defines the "compress" host module of quickjs, compressing and decompressing data in Go.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/buke/quickjs-go"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Options configures the compress module defined by Install.
+type Options struct {
+	maxSize int64
+}
+
+// Option configures the compress module defined by Install.
+type Option func(*Options)
+
+// MaxSize limits the size of decompressed data, against decompression bombs; it is 64 MiB by default.
+func MaxSize(n int64) Option {
+	return func(o *Options) {
+		o.maxSize = n
+	}
+}
+
+// compressFormats are the formats of the compress module, with the range of their compression levels.
+var compressFormats = map[string]struct {
+	writer       func(w io.Writer, level int) (io.WriteCloser, error)
+	reader       func(r io.Reader) (io.ReadCloser, error)
+	minLevel     int
+	maxLevel     int
+	defaultLevel int
+}{
+	"gzip": {
+		func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) },
+		func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		flate.HuffmanOnly, flate.BestCompression, flate.DefaultCompression,
+	},
+	"zlib": {
+		func(w io.Writer, level int) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, level) },
+		func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+		flate.HuffmanOnly, flate.BestCompression, flate.DefaultCompression,
+	},
+	"brotli": {
+		func(w io.Writer, level int) (io.WriteCloser, error) { return brotli.NewWriterLevel(w, level), nil },
+		func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+		brotli.BestSpeed, brotli.BestCompression, brotli.DefaultCompression,
+	},
+	"zstd": {
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		1, 22, 3,
+	},
+}
+
+const compressPatch = `(compress, decompress) => {
+	const input = (data) => {
+		const chunks = Array.isArray(data) ? data : [data];
+		for (const chunk of chunks) {
+			if (typeof chunk !== "string" && !(chunk instanceof ArrayBuffer) && !ArrayBuffer.isView(chunk)) {
+				throw new TypeError("data must be a string, an ArrayBuffer, an ArrayBuffer view or an array of them");
+			}
+		}
+		return chunks;
+	};
+	return {
+		compress(format, data, { level } = {}) {
+			return compress(String(format), input(data), level === undefined ? null : Number(level));
+		},
+		decompress(format, data, { type = "arrayBuffer" } = {}) {
+			if (type !== "arrayBuffer" && type !== "text") throw new TypeError("unknown type " + type);
+			return decompress(String(format), input(data), type === "text");
+		},
+	};
+}`
+
+// Install defines the "compress" module of ctx, compressing and decompressing data in Go:
+//
+//	import { compress, decompress } from "compress";
+//	const body = decompress("gzip", payload, { type: "text" });
+//	const packed = compress("zstd", [header, records], { level: 9 });
+//
+// compress(format, data, {level}) returns the compressed data as an ArrayBuffer, and decompress(format, data, {type})
+// the decompressed data as an ArrayBuffer or, if type is "text", as a string decoded from UTF-8. The formats are gzip,
+// zlib and brotli, with their usual levels, and zstd, with levels from 1 to 22. Data is a string, encoded as UTF-8, an
+// ArrayBuffer, an ArrayBuffer view, or an array of them, concatenated, such as the chunks of a stream. Decompressing
+// more than MaxSize bytes throws a RangeError.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{maxSize: 64 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	input := func(chunks quickjs.Value) (io.Reader, error) {
+		readers := make([]io.Reader, chunks.Len())
+		for i := range readers {
+			chunk := chunks.GetIdx(int64(i))
+			b, err := ctx.DataBytes(chunk)
+			chunk.Free()
+			if err != nil {
+				return nil, err
+			}
+			readers[i] = bytes.NewReader(b)
+		}
+		return io.MultiReader(readers...), nil
+	}
+
+	compress := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name := args[0].String()
+		format, ok := compressFormats[name]
+		if !ok {
+			return ctx.ThrowTypeError("unknown format %s", name)
+		}
+		level := format.defaultLevel
+		if !args[2].IsNull() {
+			level = int(args[2].Int32())
+			if level < format.minLevel || level > format.maxLevel {
+				return ctx.ThrowRangeError("%s level must be between %d and %d", name, format.minLevel, format.maxLevel)
+			}
+		}
+		r, err := input(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		var buf bytes.Buffer
+		w, err := format.writer(&buf, level)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return ctx.ThrowError(err)
+		}
+		if err := w.Close(); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.ArrayBuffer(buf.Bytes())
+	})
+	defer compress.Free()
+	decompress := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name := args[0].String()
+		format, ok := compressFormats[name]
+		if !ok {
+			return ctx.ThrowTypeError("unknown format %s", name)
+		}
+		r, err := input(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		dr, err := format.reader(r)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		defer dr.Close()
+		b, err := io.ReadAll(io.LimitReader(dr, o.maxSize+1))
+		if int64(len(b)) > o.maxSize {
+			return ctx.ThrowRangeError("decompressed data larger than %d bytes", o.maxSize)
+		}
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if args[2].Bool() {
+			return ctx.String(string(b))
+		}
+		return ctx.ArrayBuffer(b)
+	})
+	defer decompress.Free()
+
+	patch, err := ctx.Eval(compressPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), compress, decompress)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("compress", exports, "compress", "decompress")
+}
@@ -0,0 +1,60 @@
+package compress_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/compress"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, compress.Install(ctx, compress.MaxSize(1000)))
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	io.WriteString(w, "compressed in Go")
+	w.Close()
+	ctx.Globals().Set("payload", ctx.ArrayBuffer(gz.Bytes()))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { compress, decompress } from "compress";
+		const text = "hello ".repeat(100);
+		globalThis.result = {};
+		for (const format of ["gzip", "zlib", "brotli", "zstd"]) {
+			const packed = compress(format, text, { level: 9 });
+			result[format] = [packed instanceof ArrayBuffer, packed.byteLength < 100, decompress(format, packed, { type: "text" }) === text];
+		}
+		result.chunks = decompress("zstd", compress("zstd", ["a", new Uint8Array([98]), new Uint8Array([99]).buffer]), { type: "text" });
+		result.bytes = [...new Uint8Array(decompress("zlib", compress("zlib", new Uint8Array([1, 2, 3]))))];
+		result.payload = decompress("gzip", new Uint8Array(payload), { type: "text" });
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"gzip": [true, true, true], "zlib": [true, true, true], "brotli": [true, true, true],
+		"zstd": [true, true, true], "chunks": "abc", "bytes": [1, 2, 3], "payload": "compressed in Go"}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`compress("lz4", "x")`:                                   "TypeError: unknown format lz4",
+		`compress("brotli", "x", { level: 12 })`:                 "RangeError: brotli level must be between 0 and 11",
+		`decompress("gzip", compress("gzip", "x".repeat(2000)))`: "RangeError: decompressed data larger than 1000 bytes",
+		`decompress("gzip", "this is not gzip data")`:            "Error: gzip: invalid header",
+		`compress("gzip", 42)`:                                   "TypeError: data must be a string, an ArrayBuffer, an ArrayBuffer view or an array of them",
+	} {
+		_, err := ctx.Eval(`import("compress").then(({ compress, decompress }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -1,9 +1,11 @@
 package quickjs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime/cgo"
+	"time"
 	"unsafe"
 )
 
@@ -15,11 +17,43 @@ import "C"
 
 // Context represents a Javascript context (or Realm). Each JSContext has its own global objects and system objects. There can be several JSContexts per JSRuntime and they can share objects, similar to frames of the same origin sharing Javascript objects in a web browser.
 type Context struct {
-	runtime    *Runtime
-	ref        *C.JSContext
-	globals    *Value
-	proxy      *Value
-	asyncProxy *Value
+	runtime      *Runtime
+	ref          *C.JSContext
+	handle       cgo.Handle
+	globals      *Value
+	proxy        *Value
+	asyncProxy   *Value
+	sourceMaps   map[string]*sourceMap
+	coverage     *coverage
+	tracked      map[uintptr][][]uintptr // creation stacks of tracked values by object pointer
+	owned        map[uint64]C.JSValue    // auto-freed values by owner id
+	lastOwned    uint64
+	gateway      gateway
+	opaque       interface{}
+	symbols      map[string]C.JSAtom  // private symbols used by the package, by description
+	errorClass   map[string]C.JSValue // error classes registered with RegisterErrorClass, by name
+	closeHooks   []func()             // run by Close before the context is freed
+	scheduler    *Scheduler
+	realm        bool // owns every value so that Close frees those left alive
+	kinds        map[C.JSClassID]Kind
+	futures      map[chan Result]struct{} // channels of ToChannel waiting for their promise
+	initializing bool                     // set while NewContext imports the built-in modules
+	timezone     *time.Location           // time zone of the local time methods of Date, nil until set
+	locale       *localeState             // default locale of the locale-sensitive methods, nil until set
+	exited       *ExitError               // set by process.exit
+	exitedPtr    uintptr                  // object pointer of the exception thrown by process.exit
+	rateLimits   *rateLimiter             // limits of host calls set by SetRateLimits, nil until set
+	hostDepth    int                      // calls of Go functions from JS running
+	maxHostDepth int                      // limit of hostDepth set by ContextMaxHostDepth, 0 for none
+	asyncCalls   int                      // calls of async Go functions whose promise is pending
+	maxAsync     int                      // limit of asyncCalls set by ContextMaxAsyncCalls, 0 for none
+	throwHook    bool                     // the global function of instrumented throw statements is defined
+	lastThrow    *Value                   // last value reported to the OnThrow function, nil if none
+	limitErrors  map[error]Value          // last errors thrown for the limits of host calls, by sentinel error
+	modules      []definedModule          // modules defined by LoadModule, LoadModuleBytecode and LoadHostModule, in order
+	hostModules  map[string]hostModule    // host modules of LoadHostModule not imported yet, by name
+	evalDisabled bool                     // set by ContextDisableEval
+	broadcasts   broadcastChannels        // open BroadcastChannel objects, nil until installed
 }
 
 // Runtime returns the runtime of the context.
@@ -27,8 +61,33 @@ func (ctx *Context) Runtime() *Runtime {
 	return ctx.runtime
 }
 
+// SetOpaque stores user data in the context. The engine's context opaque pointer refers to the Go context, so the
+// data is available from every callback receiving ctx.
+func (ctx *Context) SetOpaque(opaque interface{}) {
+	ctx.opaque = opaque
+}
+
+// Opaque returns the user data stored with SetOpaque.
+func (ctx *Context) Opaque() interface{} {
+	return ctx.opaque
+}
+
+// OnClose registers fn to be called by Close before the context is freed, for example to free the values kept by
+// the Go side. The functions are called in the order they were registered.
+func (ctx *Context) OnClose(fn func()) {
+	ctx.closeHooks = append(ctx.closeHooks, fn)
+}
+
 // Free will free context and all associated objects.
 func (ctx *Context) Close() {
+	ctx.closeGateway()
+	for _, fn := range ctx.closeHooks {
+		fn()
+	}
+	ctx.StopProfiling()
+	ctx.freeOwned()
+	ctx.reportLeaks()
+
 	if ctx.proxy != nil {
 		ctx.proxy.Free()
 	}
@@ -37,11 +96,31 @@ func (ctx *Context) Close() {
 		ctx.asyncProxy.Free()
 	}
 
+	ctx.releaseThrow()
+	for _, err := range ctx.limitErrors {
+		err.Free()
+	}
+
 	if ctx.globals != nil {
 		ctx.globals.Free()
 	}
 
+	for _, atom := range ctx.symbols {
+		C.JS_FreeAtom(ctx.ref, atom)
+	}
+
+	for _, ctor := range ctx.errorClass {
+		C.JS_FreeValue(ctx.ref, ctor)
+	}
+
+	for _, hm := range ctx.hostModules {
+		C.JS_FreeValue(ctx.ref, hm.exports)
+	}
+
+	// A pending exception would outlive the context, holding its objects.
+	C.JS_FreeValue(ctx.ref, C.JS_GetException(ctx.ref))
 	C.JS_FreeContext(ctx.ref)
+	ctx.handle.Delete()
 }
 
 // Null return a null value.
@@ -60,12 +139,54 @@ func (ctx *Context) Uninitialized() Value {
 }
 
 // Error returns a new exception value with given message.
+// Wrapped errors become the cause of the error, and errors wrapping several errors (such as errors.Join) become an
+// AggregateError.
 func (ctx *Context) Error(err error) Value {
-	val := Value{ctx: ctx, ref: C.JS_NewError(ctx.ref)}
+	return ctx.errorDepth(err, 0)
+}
+
+// AggregateError returns a new AggregateError value holding errs, with the given message.
+func (ctx *Context) AggregateError(errs []error, message string) Value {
+	return ctx.aggregateErrorDepth(errs, message, 0)
+}
+
+// maxErrorDepth limits the depth of the cause chains converted between Go and JS errors.
+const maxErrorDepth = 32
+
+func (ctx *Context) errorDepth(err error, depth int) Value {
+	if multi, ok := err.(interface{ Unwrap() []error }); ok && depth < maxErrorDepth {
+		return ctx.aggregateErrorDepth(multi.Unwrap(), err.Error(), depth)
+	}
+	val := ctx.track(Value{ctx: ctx, ref: C.JS_NewError(ctx.ref)})
 	val.Set("message", ctx.String(err.Error()))
+	if cause := errors.Unwrap(err); cause != nil && depth < maxErrorDepth {
+		ctx.defineCause(val, ctx.errorDepth(cause, depth+1))
+	}
 	return val
 }
 
+func (ctx *Context) aggregateErrorDepth(errs []error, message string, depth int) Value {
+	arr := ctx.Array()
+	for i, err := range errs {
+		arr.arrayValue.SetIdx(int64(i), ctx.errorDepth(err, depth+1))
+	}
+	msg := ctx.String(message)
+	ctor := ctx.Globals().Get("AggregateError")
+	val := ctor.CallConstructor(arr.arrayValue, msg)
+	ctor.Free()
+	msg.Free()
+	arr.Free()
+	return val
+}
+
+// defineCause sets the cause of the error val like the cause option of the Error constructor, consuming cause.
+func (ctx *Context) defineCause(val Value, cause Value) {
+	ctx.untrack(cause)
+	prop := ctx.Atom("cause")
+	defer prop.Free()
+	C.JS_DefinePropertyValue(ctx.ref, val.ref, prop.ref, cause.ref, C.JS_PROP_CONFIGURABLE|C.JS_PROP_WRITABLE)
+}
+
 // Bool returns a bool value with given bool.
 func (ctx *Context) Bool(b bool) Value {
 	bv := 0
@@ -92,12 +213,12 @@ func (ctx *Context) Uint32(v uint32) Value {
 
 // BigInt64 returns a int64 value with given uint64.
 func (ctx *Context) BigInt64(v int64) Value {
-	return Value{ctx: ctx, ref: C.JS_NewBigInt64(ctx.ref, C.int64_t(v))}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewBigInt64(ctx.ref, C.int64_t(v))})
 }
 
 // BigUint64 returns a uint64 value with given uint64.
 func (ctx *Context) BigUint64(v uint64) Value {
-	return Value{ctx: ctx, ref: C.JS_NewBigUint64(ctx.ref, C.uint64_t(v))}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewBigUint64(ctx.ref, C.uint64_t(v))})
 }
 
 // Float64 returns a float64 value with given float64.
@@ -105,51 +226,78 @@ func (ctx *Context) Float64(v float64) Value {
 	return Value{ctx: ctx, ref: C.JS_NewFloat64(ctx.ref, C.double(v))}
 }
 
-// String returns a string value with given string.
+// String returns a string value with given string, up to its first NUL byte; see StringFromBytes.
 func (ctx *Context) String(v string) Value {
 	ptr := C.CString(v)
 	defer C.free(unsafe.Pointer(ptr))
-	return Value{ctx: ctx, ref: C.JS_NewString(ctx.ref, ptr)}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewString(ctx.ref, ptr)})
+}
+
+// stringLen is like String, but keeps the NUL characters that C.CString would cut the string at.
+func (ctx *Context) stringLen(s string) Value {
+	if s == "" {
+		return ctx.String(s)
+	}
+	ptr := (*C.char)(unsafe.Pointer(unsafe.StringData(s)))
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewStringLen(ctx.ref, ptr, C.size_t(len(s)))})
+}
+
+// atomLen is like Atom, but keeps NUL characters.
+func (ctx *Context) atomLen(s string) Atom {
+	if s == "" {
+		return ctx.Atom(s)
+	}
+	ptr := (*C.char)(unsafe.Pointer(unsafe.StringData(s)))
+	return Atom{ctx: ctx, ref: C.JS_NewAtomLen(ctx.ref, ptr, C.size_t(len(s)))}
 }
 
 // ArrayBuffer returns a string value with given binary data.
 func (ctx *Context) ArrayBuffer(binaryData []byte) Value {
-	return Value{ctx: ctx, ref: C.JS_NewArrayBufferCopy(ctx.ref, (*C.uchar)(&binaryData[0]), C.size_t(len(binaryData)))}
+	if len(binaryData) == 0 {
+		return ctx.track(Value{ctx: ctx, ref: C.JS_NewArrayBufferCopy(ctx.ref, nil, 0)})
+	}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewArrayBufferCopy(ctx.ref, (*C.uchar)(&binaryData[0]), C.size_t(len(binaryData)))})
 }
 
 // Object returns a new object value.
 func (ctx *Context) Object() Value {
-	return Value{ctx: ctx, ref: C.JS_NewObject(ctx.ref)}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_NewObject(ctx.ref)})
 }
 
 // ParseJson parses given json string and returns a object value.
-func (ctx *Context) ParseJSON(v string) Value {
+// Options control the conversion of large integers, duplicate keys and the nesting depth; invalid JSON, or JSON
+// rejected by an option, gives an exception value holding a SyntaxError.
+func (ctx *Context) ParseJSON(v string, opts ...JSONOption) Value {
+	if len(opts) > 0 {
+		return ctx.parseJSON(v, opts)
+	}
+
 	ptr := C.CString(v)
 	defer C.free(unsafe.Pointer(ptr))
 
 	filenamePtr := C.CString("")
 	defer C.free(unsafe.Pointer(filenamePtr))
 
-	return Value{ctx: ctx, ref: C.JS_ParseJSON(ctx.ref, ptr, C.size_t(len(v)), filenamePtr)}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_ParseJSON(ctx.ref, ptr, C.size_t(len(v)), filenamePtr)})
 }
 
 // Array returns a new array value.
 func (ctx *Context) Array() *Array {
-	val := Value{ctx: ctx, ref: C.JS_NewArray(ctx.ref)}
+	val := ctx.track(Value{ctx: ctx, ref: C.JS_NewArray(ctx.ref)})
 	return NewQjsArray(val, ctx)
 }
 
 func (ctx *Context) Map() *Map {
 	ctor := ctx.Globals().Get("Map")
 	defer ctor.Free()
-	val := Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, ctor.ref, 0, nil)}
+	val := ctx.track(Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, ctor.ref, 0, nil)})
 	return NewQjsMap(val, ctx)
 }
 
 func (ctx *Context) Set() *Set {
 	ctor := ctx.Globals().Get("Set")
 	defer ctor.Free()
-	val := Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, ctor.ref, 0, nil)}
+	val := ctx.track(Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, ctor.ref, 0, nil)})
 	return NewQjsSet(val, ctx)
 }
 
@@ -166,13 +314,13 @@ func (ctx *Context) Function(fn func(ctx *Context, this Value, args []Value) Val
 	ctxHandler := ctx.Int64(int64(cgo.NewHandle(ctx)))
 	args := []C.JSValue{ctx.proxy.ref, fnHandler.ref, ctxHandler.ref}
 
-	val, err := ctx.Eval(`(proxy, fnHandler, ctx) => function() { return proxy.call(this, fnHandler, ctx, ...arguments); }`)
+	val, err := ctx.Eval(`(proxy, fnHandler, ctx) => function() { return proxy.call(this, fnHandler, ctx, ...arguments); }`, EvalFlagInternal(true))
 	defer val.Free()
 	if err != nil {
 		panic(err)
 	}
 
-	return Value{ctx: ctx, ref: C.JS_Call(ctx.ref, val.ref, ctx.Null().ref, C.int(len(args)), &args[0])}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_Call(ctx.ref, val.ref, ctx.Null().ref, C.int(len(args)), &args[0])})
 }
 
 // AsyncFunction returns a js async function value with given function template.
@@ -198,26 +346,28 @@ func (ctx *Context) AsyncFunction(asyncFn func(ctx *Context, this Value, promise
 		promise.reject = reject;
 
 		proxy.call(this, fnHandler, ctx, promise,  ...arguments);
-		return await promise;
-	}`)
+		try {
+			return await promise;
+		} finally {
+			proxy.call(null, 0, ctx);
+		}
+	}`, EvalFlagInternal(true))
 	defer val.Free()
 	if err != nil {
 		panic(err)
 	}
 
-	return Value{ctx: ctx, ref: C.JS_Call(ctx.ref, val.ref, ctx.Null().ref, C.int(len(args)), &args[0])}
+	return ctx.track(Value{ctx: ctx, ref: C.JS_Call(ctx.ref, val.ref, ctx.Null().ref, C.int(len(args)), &args[0])})
 }
 
 // InterruptHandler is a function type for interrupt handler.
 /* return != 0 if the JS code needs to be interrupted */
 type InterruptHandler func() int
 
-// SetInterruptHandler sets a interrupt handler.
+// SetInterruptHandler sets the interrupt handler of the context's runtime; it runs alongside the execute timeout.
 func (ctx *Context) SetInterruptHandler(handler InterruptHandler) {
-	handlerArgs := C.handlerArgs{
-		fn: (C.uintptr_t)(cgo.NewHandle(handler)),
-	}
-	C.SetInterruptHandler(ctx.runtime.ref, unsafe.Pointer(&handlerArgs))
+	ctx.runtime.state.interruptHandler = handler
+	ctx.runtime.enableInterrupts()
 }
 
 // Atom returns a new Atom value with given string.
@@ -239,9 +389,18 @@ func (ctx *Context) Invoke(fn Value, this Value, args ...Value) Value {
 		cargs = append(cargs, x.ref)
 	}
 	if len(cargs) == 0 {
-		return Value{ctx: ctx, ref: C.JS_Call(ctx.ref, fn.ref, this.ref, 0, nil)}
+		return ctx.track(Value{ctx: ctx, ref: ctx.call(fn.ref, this.ref, nil)})
+	}
+	return ctx.track(Value{ctx: ctx, ref: ctx.call(fn.ref, this.ref, cargs)})
+}
+
+// InvokeE is like Invoke but returns the exception thrown by the function as an error.
+func (ctx *Context) InvokeE(fn Value, this Value, args ...Value) (Value, error) {
+	v := ctx.Invoke(fn, this, args...)
+	if v.IsException() {
+		return ctx.Undefined(), ctx.pendingError()
 	}
-	return Value{ctx: ctx, ref: C.JS_Call(ctx.ref, fn.ref, this.ref, C.int(len(cargs)), &cargs[0])}
+	return v, nil
 }
 
 type EvalOptions struct {
@@ -252,6 +411,10 @@ type EvalOptions struct {
 	js_eval_flag_compile_only bool
 	filename                  string
 	await                     bool
+	sourceMap                 []byte
+	internal                  bool
+	math                      bool
+	quota                     *Quota
 }
 
 type EvalOption func(*EvalOptions)
@@ -280,6 +443,25 @@ func EvalFlagStrip(strip bool) EvalOption {
 	}
 }
 
+// StripLevel selects the debug information kept by Eval and Compile. The engine has a single strip mode, so there are
+// two levels; the source text of functions is never written to bytecode at either level.
+type StripLevel int
+
+const (
+	// StripNone keeps the file name, line numbers and names of arguments and local variables, and the source text of
+	// functions in memory for Function.prototype.toString.
+	StripNone StripLevel = iota
+	// StripDebug removes all of these, which makes bytecode noticeably smaller. Function names are kept, so stack
+	// traces and profiles still name the functions, without positions.
+	StripDebug
+)
+
+// EvalStrip sets the debug information kept by the evaluation or compilation; it is the same as EvalFlagStrip with
+// level StripDebug.
+func EvalStrip(level StripLevel) EvalOption {
+	return EvalFlagStrip(level >= StripDebug)
+}
+
 func EvalFlagCompileOnly(compileOnly bool) EvalOption {
 	return func(flags *EvalOptions) {
 		flags.js_eval_flag_compile_only = compileOnly
@@ -298,10 +480,35 @@ func EvalAwait(await bool) EvalOption {
 	}
 }
 
+// EvalSourceMap sets the source map used to remap stack traces of the evaluated code; inline source maps are detected automatically.
+// Sidecar references such as "//# sourceMappingURL=app.js.map" are not followed: pass their maps with EvalSourceMap.
+// The maps are registered by filename, so code evaluated without EvalFileName is not remapped.
+func EvalSourceMap(sourceMap []byte) EvalOption {
+	return func(flags *EvalOptions) {
+		flags.sourceMap = sourceMap
+	}
+}
+
+// EvalFlagMath enables the math mode of the bignum extensions ("use math"): operator overloading defaults,
+// `^` as the power operator, and division and literals of arbitrary precision.
+func EvalFlagMath(math bool) EvalOption {
+	return func(flags *EvalOptions) {
+		flags.math = math
+	}
+}
+
+// EvalFlagInternal marks glue code evaluated by the embedder or a host module rather than a script: it is not reported
+// to the trace hooks, nor instrumented for coverage or SetOnThrow.
+func EvalFlagInternal(internal bool) EvalOption {
+	return func(flags *EvalOptions) {
+		flags.internal = internal
+	}
+}
+
 // Eval returns a js value with given code.
 // Need call Free() `quickjs.Value`'s returned by `Eval()` and `EvalFile()` and `EvalBytecode()`.
 // func (ctx *Context) Eval(code string) (Value, error) { return ctx.EvalFile(code, "code") }
-func (ctx *Context) Eval(code string, opts ...EvalOption) (Value, error) {
+func (ctx *Context) Eval(code string, opts ...EvalOption) (_ Value, err error) {
 	options := EvalOptions{
 		js_eval_type_global: true,
 		filename:            "<input>",
@@ -310,6 +517,16 @@ func (ctx *Context) Eval(code string, opts ...EvalOption) (Value, error) {
 	for _, fn := range opts {
 		fn(&options)
 	}
+	source := code
+
+	if err := ctx.registerSourceMap(options.filename, code, options.sourceMap); err != nil {
+		return ctx.Null(), err
+	}
+
+	if !options.internal {
+		end := ctx.beginEval(options.filename)
+		defer func() { end(err) }()
+	}
 
 	cFlag := C.int(0)
 	if options.js_eval_type_global {
@@ -338,17 +555,34 @@ func (ctx *Context) Eval(code string, opts ...EvalOption) (Value, error) {
 		cFlag |= C.JS_EVAL_TYPE_MODULE
 	}
 
-	var val Value
-	if options.await {
-		val = Value{ctx: ctx, ref: C.js_std_await(ctx.ref, C.JS_Eval(ctx.ref, codePtr, C.size_t(len(code)), filenamePtr, cFlag))}
-	} else {
-		val = Value{ctx: ctx, ref: C.JS_Eval(ctx.ref, codePtr, C.size_t(len(code)), filenamePtr, cFlag)}
+	if !options.internal && !options.js_eval_flag_compile_only {
+		if instrumented := ctx.instrumentThrows(ctx.instrumentCoverage(options.filename, code)); instrumented != code {
+			code = instrumented
+			codePtr = C.CString(code)
+			defer C.free(unsafe.Pointer(codePtr))
+		}
+	}
+
+	if options.math {
+		code = useMath(code)
+		codePtr = C.CString(code)
+		defer C.free(unsafe.Pointer(codePtr))
+	}
+
+	if options.quota != nil {
+		end := ctx.beginQuota(*options.quota)
+		defer func() { err = end(err) }()
 	}
+
+	var val Value
+	val = Value{ctx: ctx, ref: ctx.eval(codePtr, len(code), filenamePtr, cFlag, options.await)}
 	if val.IsException() {
-		return val, ctx.Exception()
+		ctx.reportUncaught()
+		return val, diagnose(ctx.Exception(), options.filename, source)
 	}
+	ctx.releaseThrow()
 
-	return val, nil
+	return ctx.track(val), nil
 }
 
 // EvalFile returns a js value with given code and filename.
@@ -363,26 +597,17 @@ func (ctx *Context) EvalFile(filePath string, opts ...EvalOption) (Value, error)
 }
 
 // LoadModule returns a js value with given code and module name.
-func (ctx *Context) LoadModule(code string, moduleName string) (Value, error) {
-	codePtr := C.CString(code)
-	defer C.free(unsafe.Pointer(codePtr))
-
-	filenamePtr := C.CString(moduleName)
-	defer C.free(unsafe.Pointer(filenamePtr))
-
-	cFlag := C.JS_EVAL_TYPE_MODULE | C.JS_EVAL_FLAG_COMPILE_ONLY
-	cVal := C.JS_Eval(ctx.ref, codePtr, C.size_t(len(code)), filenamePtr, C.int(cFlag))
-	if C.ValueGetTag(cVal) != C.JS_TAG_MODULE {
-		return ctx.Null(), fmt.Errorf("not a module")
-	}
-	if C.JS_ResolveModule(ctx.ref, cVal) != 0 {
-		C.JS_FreeValue(ctx.ref, cVal)
-		return ctx.Null(), fmt.Errorf("resolve module failed")
+func (ctx *Context) LoadModule(code string, moduleName string) (_ Value, err error) {
+	end := ctx.beginEval(moduleName)
+	defer func() { end(err) }()
+	cVal, err := ctx.compileModule(code, moduleName)
+	if err != nil {
+		return ctx.Null(), err
 	}
-	C.js_module_set_import_meta(ctx.ref, cVal, 0, 1)
-	cVal = C.js_std_await(ctx.ref, cVal)
+	ctx.modules = append(ctx.modules, definedModule{name: moduleName, code: code})
+	cVal = ctx.await(cVal)
 
-	return Value{ctx: ctx, ref: cVal}, nil
+	return ctx.track(Value{ctx: ctx, ref: cVal}), nil
 }
 
 // LoadModuleFile returns a js value with given file path and module name.
@@ -401,45 +626,43 @@ func (ctx *Context) CompileModule(filePath string, moduleName string, opts ...Ev
 }
 
 // LoadModuleByteCode returns a js value with given bytecode and module name.
-func (ctx *Context) LoadModuleBytecode(buf []byte) (Value, error) {
-	cbuf := C.CBytes(buf)
-	cVal := C.JS_ReadObject(ctx.ref, (*C.uint8_t)(cbuf), C.size_t(len(buf)), C.JS_READ_OBJ_BYTECODE)
-	defer C.js_free(ctx.ref, unsafe.Pointer(cbuf))
-	if C.JS_IsException(cVal) == 1 {
-		return ctx.Null(), ctx.Exception()
-	}
-	if C.ValueGetTag(cVal) != C.JS_TAG_MODULE {
-		return ctx.Null(), fmt.Errorf("not a module")
-	}
-	if C.JS_ResolveModule(ctx.ref, cVal) != 0 {
-		C.JS_FreeValue(ctx.ref, cVal)
-		return ctx.Null(), fmt.Errorf("resolve module failed")
+func (ctx *Context) LoadModuleBytecode(buf []byte) (_ Value, err error) {
+	end := ctx.beginEval("<bytecode>")
+	defer func() { end(err) }()
+	cVal, err := ctx.readModule(buf)
+	if err != nil {
+		return ctx.Null(), err
 	}
-	C.js_module_set_import_meta(ctx.ref, cVal, 0, 1)
-	cVal = C.js_std_await(ctx.ref, cVal)
+	ctx.modules = append(ctx.modules, definedModule{bytecode: append([]byte(nil), buf...)})
+	cVal = ctx.await(cVal)
 
-	return Value{ctx: ctx, ref: cVal}, nil
+	return ctx.track(Value{ctx: ctx, ref: cVal}), nil
 }
 
 // EvalBytecode returns a js value with given bytecode.
 // Need call Free() `quickjs.Value`'s returned by `Eval()` and `EvalFile()` and `EvalBytecode()`.
-func (ctx *Context) EvalBytecode(buf []byte) (Value, error) {
+func (ctx *Context) EvalBytecode(buf []byte) (_ Value, err error) {
+	end := ctx.beginEval("<bytecode>")
+	defer func() { end(err) }()
 	cbuf := C.CBytes(buf)
 	obj := Value{ctx: ctx, ref: C.JS_ReadObject(ctx.ref, (*C.uint8_t)(cbuf), C.size_t(len(buf)), C.JS_READ_OBJ_BYTECODE)}
-	defer C.js_free(ctx.ref, unsafe.Pointer(cbuf))
+	defer C.free(cbuf)
 	if obj.IsException() {
 		return obj, ctx.Exception()
 	}
 
-	val := Value{ctx: ctx, ref: C.JS_EvalFunction(ctx.ref, obj.ref)}
+	val := Value{ctx: ctx, ref: ctx.evalFunction(obj.ref)}
 	if val.IsException() {
 		return val, ctx.Exception()
 	}
 
-	return val, nil
+	return ctx.track(val), nil
 }
 
 // Compile returns a compiled bytecode with given code.
+// The bytecode only depends on the code, the file name and the options: compiling the same input gives identical bytes
+// in any runtime and context, whatever was evaluated before, so it can be content-addressed. Atoms are numbered in
+// order of first use in the code and no timestamps or addresses are written.
 func (ctx *Context) Compile(code string, opts ...EvalOption) ([]byte, error) {
 	opts = append(opts, EvalFlagCompileOnly(true))
 	val, err := ctx.Eval(code, opts...)
@@ -492,6 +715,7 @@ func (ctx *Context) Globals() Value {
 
 // Throw returns a context's exception value.
 func (ctx *Context) Throw(v Value) Value {
+	ctx.untrack(v)
 	return Value{ctx: ctx, ref: C.JS_Throw(ctx.ref, v.ref)}
 }
 
@@ -547,16 +771,64 @@ func (ctx *Context) Exception() error {
 	return val.Error()
 }
 
+// Try runs fn, which calls wrapper methods that may throw such as Invoke, Call or Get, and converts the pending
+// exception, if any, to an error. Values thrown that are not errors are converted too.
+func (ctx *Context) Try(fn func() Value) (Value, error) {
+	v := fn()
+	if !v.IsException() && C.JS_HasException(ctx.ref) == 0 {
+		return v, nil
+	}
+	return v, ctx.pendingError()
+}
+
+// pendingError fetches the pending exception as an error, including values thrown that are not errors.
+func (ctx *Context) pendingError() error {
+	exc := Value{ctx: ctx, ref: C.JS_GetException(ctx.ref)}
+	defer exc.Free()
+	return exc.causeError(0)
+}
+
 // Loop runs the context's event loop.
 func (ctx *Context) Loop() {
 	C.js_std_loop(ctx.ref)
+	ctx.runtime.noteJobs()
 }
 
 // Wait for a promise and execute pending jobs while waiting for it. Return the promise result or JS_EXCEPTION in case of promise rejection.
 func (ctx *Context) Await(v Value) (Value, error) {
-	val := Value{ctx: ctx, ref: C.js_std_await(ctx.ref, v.ref)}
+	ctx.untrack(v)
+	val := Value{ctx: ctx, ref: ctx.await(v.ref)}
+	ctx.runtime.noteJobs()
 	if val.IsException() {
 		return val, ctx.Exception()
 	}
-	return val, nil
+	return ctx.track(val), nil
+}
+
+// call calls fn with the arguments.
+func (ctx *Context) call(fn, this C.JSValue, args []C.JSValue) C.JSValue {
+	var argv *C.JSValue
+	if len(args) > 0 {
+		argv = &args[0]
+	}
+	return C.JS_Call(ctx.ref, fn, this, C.int(len(args)), argv)
+}
+
+// eval evaluates code, awaiting the result if await is set.
+func (ctx *Context) eval(code *C.char, length int, filename *C.char, flags C.int, await bool) C.JSValue {
+	ret := C.JS_Eval(ctx.ref, code, C.size_t(length), filename, flags)
+	if await {
+		ret = C.js_std_await(ctx.ref, ret)
+	}
+	return ret
+}
+
+// evalFunction runs compiled code.
+func (ctx *Context) evalFunction(obj C.JSValue) C.JSValue {
+	return C.JS_EvalFunction(ctx.ref, obj)
+}
+
+// await waits for a promise.
+func (ctx *Context) await(obj C.JSValue) C.JSValue {
+	return C.js_std_await(ctx.ref, obj)
 }
@@ -0,0 +1,469 @@
+package quickjs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// coverageGlobal is the global object holding the coverage counters of instrumented scripts.
+const coverageGlobal = "__quickjs_coverage__"
+
+// CoverageReport is a snapshot of the coverage recorded by a context.
+type CoverageReport struct {
+	Files []FileCoverage
+}
+
+// FileCoverage is the coverage of a single script or module.
+type FileCoverage struct {
+	Filename  string
+	Lines     []LineCoverage
+	Functions []FunctionCoverage
+}
+
+// LineCoverage is the hit count of an instrumented line.
+type LineCoverage struct {
+	Line int
+	Hits int64
+}
+
+// FunctionCoverage is the hit count of an instrumented function.
+type FunctionCoverage struct {
+	Name string
+	Line int
+	Hits int64
+}
+
+// coverage holds the instrumented scripts of a context.
+type coverage struct {
+	enabled bool
+	scripts []*coverageScript
+	byName  map[string]*coverageScript
+}
+
+type coverageScript struct {
+	id        int
+	filename  string
+	source    string
+	lines     []int
+	functions []FunctionCoverage
+
+	instrumented string
+}
+
+// StartCoverage enables coverage instrumentation for scripts and modules evaluated by the context from now on.
+// Only lines starting a statement and functions with a block body are instrumented; bytecode is never instrumented.
+func (ctx *Context) StartCoverage() {
+	if ctx.coverage == nil {
+		ctx.coverage = &coverage{byName: make(map[string]*coverageScript)}
+	}
+	ctx.coverage.enabled = true
+}
+
+// StopCoverage disables coverage instrumentation. Code that was already instrumented keeps recording hits.
+func (ctx *Context) StopCoverage() {
+	if ctx.coverage != nil {
+		ctx.coverage.enabled = false
+	}
+}
+
+// Coverage returns the coverage recorded so far, one entry per instrumented filename.
+func (ctx *Context) Coverage() (*CoverageReport, error) {
+	report := &CoverageReport{}
+	if ctx.coverage == nil || len(ctx.coverage.scripts) == 0 {
+		return report, nil
+	}
+
+	counters := ctx.Globals().Get(coverageGlobal)
+	defer counters.Free()
+	var hits []struct {
+		L []int64 `json:"l"`
+		F []int64 `json:"f"`
+	}
+	if err := json.Unmarshal([]byte(counters.JSONStringify()), &hits); err != nil {
+		return nil, fmt.Errorf("invalid coverage counters: %w", err)
+	}
+
+	for _, script := range ctx.coverage.scripts {
+		if ctx.coverage.byName[script.filename] != script || script.id >= len(hits) {
+			continue
+		}
+		file := FileCoverage{Filename: script.filename}
+		for _, line := range script.lines {
+			var n int64
+			if line < len(hits[script.id].L) {
+				n = hits[script.id].L[line]
+			}
+			file.Lines = append(file.Lines, LineCoverage{Line: line, Hits: n})
+		}
+		for i, fn := range script.functions {
+			if i < len(hits[script.id].F) {
+				fn.Hits = hits[script.id].F[i]
+			}
+			file.Functions = append(file.Functions, fn)
+		}
+		report.Files = append(report.Files, file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Filename < report.Files[j].Filename })
+	return report, nil
+}
+
+// WriteLCOV writes the report in the LCOV tracefile format.
+func (r *CoverageReport) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, file := range r.Files {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", file.Filename)
+		fnHit := 0
+		for _, fn := range file.Functions {
+			fmt.Fprintf(bw, "FN:%d,%s\n", fn.Line, fn.Name)
+		}
+		for _, fn := range file.Functions {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Hits, fn.Name)
+			if fn.Hits > 0 {
+				fnHit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(file.Functions), fnHit)
+		lineHit := 0
+		for _, line := range file.Lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.Line, line.Hits)
+			if line.Hits > 0 {
+				lineHit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(file.Lines), lineHit)
+	}
+	return bw.Flush()
+}
+
+// instrumentCoverage returns code instrumented with coverage counters, or code unchanged if coverage is disabled
+// or the code cannot be tokenized (the engine reports the syntax error then).
+func (ctx *Context) instrumentCoverage(filename string, code string) string {
+	if ctx.coverage == nil || !ctx.coverage.enabled {
+		return code
+	}
+	if script := ctx.coverage.byName[filename]; script != nil && script.source == code {
+		return script.instrumented
+	}
+
+	script := &coverageScript{id: len(ctx.coverage.scripts), filename: filename, source: code}
+	instrumented, err := script.instrument()
+	if err != nil {
+		return code
+	}
+	script.instrumented = instrumented
+
+	if !ctx.Globals().Has(coverageGlobal) {
+		ctx.Globals().Set(coverageGlobal, ctx.ParseJSON("[]"))
+	}
+	counters := ctx.Globals().Get(coverageGlobal)
+	defer counters.Free()
+	maxLine := 0
+	if len(script.lines) > 0 {
+		maxLine = script.lines[len(script.lines)-1]
+	}
+	counters.SetIdx(int64(script.id), ctx.ParseJSON(fmt.Sprintf(`{"l":[%s],"f":[%s]}`, zeros(maxLine+1), zeros(len(script.functions)))))
+
+	ctx.coverage.scripts = append(ctx.coverage.scripts, script)
+	ctx.coverage.byName[filename] = script
+	return instrumented
+}
+
+func zeros(n int) string {
+	return strings.TrimSuffix(strings.Repeat("0,", n), ",")
+}
+
+type scopeKind int
+
+const (
+	scopeBlock scopeKind = iota
+	scopeSwitch
+	scopeClass
+	scopeObject
+	scopeParen
+	scopeBracket
+	scopeTemplate
+)
+
+type scope struct {
+	kind    scopeKind
+	open    int  // index of the opening token
+	control bool // paren of an if/for/while/with/switch/catch header
+	do      bool // body of a do-while loop
+	keyword string
+	inCase  bool // switch scope between a case keyword and its colon
+	ternary int
+}
+
+// statementEnd lists the keywords after which a new line may start a new statement.
+var statementEnd = map[string]bool{
+	"return": true, "break": true, "continue": true, "debugger": true,
+	"this": true, "super": true, "null": true, "true": true, "false": true, "undefined": true,
+}
+
+// nonStatementStart lists the identifiers that cannot start a statement or continue one on a new line.
+var nonStatementStart = map[string]bool{
+	"case": true, "default": true, "else": true, "catch": true, "finally": true,
+	"in": true, "instanceof": true, "of": true, "extends": true,
+}
+
+// keywords lists the reserved words that are never a variable or function name.
+var keywords = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "export": true, "extends": true,
+	"false": true, "finally": true, "for": true, "function": true, "if": true, "import": true, "in": true,
+	"instanceof": true, "let": true, "new": true, "null": true, "return": true, "static": true, "super": true,
+	"switch": true, "this": true, "throw": true, "true": true, "try": true, "typeof": true, "var": true,
+	"void": true, "while": true, "with": true, "yield": true, "async": true, "get": true, "set": true, "of": true,
+}
+
+// instrument inserts a line counter before the first statement of every line and a function counter at the start
+// of every block-bodied function. Counters are inserted without adding new lines, so line numbers in stack traces
+// are preserved.
+func (s *coverageScript) instrument() (string, error) {
+	tokens, err := jslex.Tokenize(s.source)
+	if err != nil {
+		return "", err
+	}
+
+	inserts := make(map[int]string)
+	var stack []scope
+	classDepth := -1
+	doWhile := -1
+	caseColon := -1
+	var closed scope // the scope closed by the previous token
+	anonymous := 0
+
+	top := func() *scope {
+		if len(stack) == 0 {
+			return nil
+		}
+		return &stack[len(stack)-1]
+	}
+	pop := func(kinds ...scopeKind) error {
+		sc := top()
+		if sc == nil {
+			return errors.New("unbalanced brackets")
+		}
+		for _, kind := range kinds {
+			if sc.kind == kind {
+				closed = *sc
+				stack = stack[:len(stack)-1]
+				return nil
+			}
+		}
+		return errors.New("unbalanced brackets")
+	}
+	// functionName guesses the name of a function from the tokens before its parameter list.
+	functionName := func(i int, arrow bool) string {
+		if !arrow && i > 0 && (tokens[i-1].Kind == jslex.Identifier || tokens[i-1].Kind == jslex.PrivateName) && !tokens[i-1].Is("function") {
+			return tokens[i-1].Text
+		}
+		for i > 0 && (tokens[i-1].Is("*") || tokens[i-1].Is("function") || tokens[i-1].Is("async")) {
+			i--
+		}
+		if i > 1 && (tokens[i-1].Is("=") || tokens[i-1].Is(":")) && tokens[i-2].Kind == jslex.Identifier && !keywords[tokens[i-2].Text] {
+			return tokens[i-2].Text
+		}
+		anonymous++
+		return "(anonymous_" + strconv.Itoa(anonymous-1) + ")"
+	}
+	addFunction := func(body int, name string) {
+		j := body + 1
+		// Skip the directive prologue.
+		for tokens[j].Kind == jslex.String && (tokens[j+1].Is(";") || tokens[j+1].Is("}") || tokens[j+1].NewlineBefore) {
+			j++
+			if tokens[j].Is(";") {
+				j++
+			}
+		}
+		inserts[tokens[j].Offset] += fmt.Sprintf("%s[%d].f[%d]++;", coverageGlobal, s.id, len(s.functions))
+		s.functions = append(s.functions, FunctionCoverage{Name: name, Line: tokens[body].Line})
+	}
+	// endsStatement reports whether a new line after token i may start a new statement.
+	endsStatement := func(i int, closed scope) bool {
+		prev := tokens[i]
+		switch prev.Kind {
+		case jslex.Identifier:
+			return !keywords[prev.Text] || statementEnd[prev.Text]
+		case jslex.Number, jslex.String, jslex.Template, jslex.TemplateTail, jslex.RegExp:
+			return true
+		case jslex.Punctuator:
+			switch prev.Text {
+			case ";", "{", "}", "]":
+				return true
+			case ")":
+				return !closed.control
+			case ":":
+				return i == caseColon
+			}
+		}
+		return false
+	}
+
+	for i, tok := range tokens {
+		if tok.Kind == jslex.EOF {
+			break
+		}
+
+		// Line counter.
+		sc := top()
+		if (i == 0 || tok.NewlineBefore) && (sc == nil || sc.kind == scopeBlock || sc.kind == scopeSwitch) &&
+			tok.Kind == jslex.Identifier && !nonStatementStart[tok.Text] && (i == 0 || endsStatement(i-1, closed)) &&
+			i != doWhile && (len(s.lines) == 0 || s.lines[len(s.lines)-1] != tok.Line) {
+			inserts[tok.Offset] += fmt.Sprintf("%s[%d].l[%d]++;", coverageGlobal, s.id, tok.Line)
+			s.lines = append(s.lines, tok.Line)
+		}
+
+		var prev jslex.Token
+		if i > 0 {
+			prev = tokens[i-1]
+		}
+		prevClosed := closed
+		closed = scope{}
+
+		switch tok.Kind {
+		case jslex.TemplateHead:
+			stack = append(stack, scope{kind: scopeTemplate, open: i})
+		case jslex.TemplateTail:
+			if err := pop(scopeTemplate); err != nil {
+				return "", err
+			}
+		case jslex.Identifier:
+			switch tok.Text {
+			case "class":
+				classDepth = len(stack)
+			case "while":
+				if prev.Is("}") && prevClosed.do {
+					doWhile = i
+				}
+			case "case", "default":
+				if sc != nil && sc.kind == scopeSwitch && !prev.Is(".") {
+					sc.inCase = true
+					sc.ternary = 0
+				}
+			}
+		case jslex.Punctuator:
+			switch tok.Text {
+			case "(":
+				control := false
+				if i > 0 && prev.Kind == jslex.Identifier {
+					switch prev.Text {
+					case "if", "for", "with", "switch", "catch":
+						control = true
+					case "while":
+						control = i-1 != doWhile
+					case "await":
+						control = i > 1 && tokens[i-2].Is("for")
+					}
+				}
+				keyword := ""
+				if control {
+					keyword = prev.Text
+				}
+				stack = append(stack, scope{kind: scopeParen, open: i, control: control, keyword: keyword})
+			case ")":
+				if err := pop(scopeParen); err != nil {
+					return "", err
+				}
+			case "[":
+				stack = append(stack, scope{kind: scopeBracket, open: i})
+			case "]":
+				if err := pop(scopeBracket); err != nil {
+					return "", err
+				}
+			case "?":
+				if sc != nil && sc.inCase {
+					sc.ternary++
+				}
+			case ":":
+				if sc != nil && sc.inCase {
+					if sc.ternary == 0 {
+						sc.inCase = false
+						caseColon = i
+					} else {
+						sc.ternary--
+					}
+				}
+			case "{":
+				statementLevel := sc == nil || sc.kind == scopeBlock || sc.kind == scopeSwitch
+				next := scope{kind: scopeObject, open: i}
+				switch {
+				case classDepth == len(stack):
+					next.kind = scopeClass
+					classDepth = -1
+				case prev.Is(")") && prevClosed.control:
+					next.kind = scopeBlock
+					if prevClosed.keyword == "switch" {
+						next.kind = scopeSwitch
+					}
+				case prev.Is(")"):
+					next.kind = scopeBlock
+					addFunction(i, functionName(prevClosed.open, false))
+				case prev.Is("=>"):
+					next.kind = scopeBlock
+					start := i - 1
+					if start > 0 && tokens[start-1].Is(")") {
+						start = prevArrowParams(tokens, start-1)
+					} else if start > 0 {
+						start--
+					}
+					addFunction(i, functionName(start, true))
+				case prev.Is("else") || prev.Is("try") || prev.Is("finally"):
+					next.kind = scopeBlock
+				case prev.Is("do"):
+					next.kind = scopeBlock
+					next.do = true
+				case prev.Is("static") && sc != nil && sc.kind == scopeClass:
+					next.kind = scopeBlock
+				case statementLevel && (i == 0 || prev.Is(";") || prev.Is("{") || prev.Is("}") || i-1 == caseColon):
+					next.kind = scopeBlock
+				}
+				stack = append(stack, next)
+			case "}":
+				if err := pop(scopeBlock, scopeSwitch, scopeClass, scopeObject); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return "", errors.New("unbalanced brackets")
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, tok := range tokens {
+		if text, ok := inserts[tok.Offset]; ok && tok.Offset >= last {
+			b.WriteString(s.source[last:tok.Offset])
+			b.WriteString(text)
+			last = tok.Offset
+		}
+	}
+	b.WriteString(s.source[last:])
+	return b.String(), nil
+}
+
+// prevArrowParams returns the index of the "(" matching the ")" at index end.
+func prevArrowParams(tokens []jslex.Token, end int) int {
+	depth := 0
+	for i := end; i >= 0; i-- {
+		switch {
+		case tokens[i].Is(")"):
+			depth++
+		case tokens[i].Is("("):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return end
+}
@@ -0,0 +1,228 @@
+// Package csv defines the "csv" host module of quickjs, streaming CSV rows from Go readers and to Go writers.
+package csv
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"github.com/buke/quickjs-go"
+)
+
+// Options configures the csv module defined by Install.
+type Options struct {
+	readers map[string]io.Reader
+	writers map[string]io.Writer
+}
+
+// Option configures the csv module defined by Install.
+type Option func(*Options)
+
+// Reader makes r readable by scripts as the source name. A source is read once.
+func Reader(name string, r io.Reader) Option {
+	return func(o *Options) {
+		o.readers[name] = r
+	}
+}
+
+// Writer makes w writable by scripts as the sink name. The rows written by successive calls are appended.
+func Writer(name string, w io.Writer) Option {
+	return func(o *Options) {
+		o.writers[name] = w
+	}
+}
+
+const csvPatch = `(open, next, writeRow, flush) => {
+	const done = { done: true, value: undefined };
+	const cell = (v) => v === null || v === undefined ? "" : v instanceof Date ? v.toISOString() : String(v);
+	const columns = new Map();
+	return {
+		read(source, { header = true, comma = ",", comment = "", trimLeadingSpace = false, lazyQuotes = false } = {}) {
+			const reader = open(String(source), String(comma), String(comment), !!trimLeadingSpace, !!lazyQuotes);
+			let names = Array.isArray(header) ? header.map(String) : null;
+			let finished = false;
+			return {
+				[Symbol.asyncIterator]() { return this; },
+				async next() {
+					if (finished) return done;
+					let fields = next(reader);
+					if (fields !== null && header === true && names === null) {
+						names = fields;
+						fields = next(reader);
+					}
+					if (fields === null) {
+						finished = true;
+						return done;
+					}
+					if (names === null) return { done: false, value: fields };
+					return { done: false, value: Object.fromEntries(names.map((name, i) => [name, fields[i] ?? ""])) };
+				},
+				async return() {
+					finished = true;
+					return done;
+				},
+			};
+		},
+		async write(sink, rows, { header = true, columns: names, comma = ",", crlf = false } = {}) {
+			sink = String(sink);
+			if (rows === null || typeof rows !== "object" || !(Symbol.asyncIterator in rows || Symbol.iterator in rows)) {
+				throw new TypeError("rows must be iterable");
+			}
+			const row = (fields) => writeRow(sink, fields.map(cell), String(comma), !!crlf);
+			let n = 0;
+			try {
+				for await (const value of rows) {
+					if (!columns.has(sink)) {
+						const keys = names ? [...names].map(String) : Array.isArray(value) ? null : Object.keys(value ?? {});
+						columns.set(sink, keys);
+						if (keys && header) row(keys);
+					}
+					if (Array.isArray(value)) {
+						row(value);
+					} else if (value !== null && typeof value === "object") {
+						const keys = columns.get(sink);
+						if (!keys) throw new TypeError("rows of " + sink + " are arrays, not objects");
+						row(keys.map((key) => value[key]));
+					} else {
+						throw new TypeError("row must be an array or an object");
+					}
+					n++;
+				}
+			} finally {
+				flush(sink);
+			}
+			return n;
+		},
+	};
+}`
+
+// Install defines the "csv" module of ctx, streaming rows from the readers and to the writers given by Reader and
+// Writer, for data processing scripts over files too large to hold in memory:
+//
+//	import { read, write } from "csv";
+//	async function* adults(rows) {
+//		for await (const row of rows) if (Number(row.age) >= 18) yield row;
+//	}
+//	await write("out", adults(read("in")));
+//
+// read(source, {header, comma, comment, trimLeadingSpace, lazyQuotes}) returns an async iterator of the rows of a
+// source: objects keyed by the first record if header is true, the default, or by the names of header if it is an
+// array, and arrays of strings if it is false. Malformed records throw a SyntaxError.
+//
+// write(sink, rows, {header, columns, comma, crlf}) writes the rows of an iterable or an async iterable, arrays or
+// objects, and returns the number of rows written. The columns of objects are the keys of the first row unless given,
+// and are written first as a header unless header is false. null and undefined are written as empty fields and dates in
+// ISO format.
+//
+// Records are read as the script asks for them and written as it produces them: a slow writer blocks the script, and
+// through it the reading, so that the rows in flight stay bounded.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{readers: map[string]io.Reader{}, writers: map[string]io.Writer{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	writers := map[string]*csv.Writer{}
+	char := func(s, name string) (rune, error) {
+		r, size := utf8.DecodeRuneInString(s)
+		if size != len(s) {
+			return 0, errors.New(name + " must be a single character")
+		}
+		return r, nil
+	}
+
+	open := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name := args[0].String()
+		r, ok := o.readers[name]
+		if !ok {
+			return ctx.ThrowTypeError("unknown source %s", name)
+		}
+		if r == nil {
+			return ctx.ThrowError(errors.New("source " + name + " is already read"))
+		}
+		cr := csv.NewReader(r)
+		var err error
+		if cr.Comma, err = char(args[1].String(), "comma"); err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		if comment := args[2].String(); comment != "" {
+			if cr.Comment, err = char(comment, "comment"); err != nil {
+				return ctx.ThrowTypeError("%s", err)
+			}
+		}
+		cr.TrimLeadingSpace = args[3].Bool()
+		cr.LazyQuotes = args[4].Bool()
+		o.readers[name] = nil
+		return ctx.GoObject(cr, nil)
+	})
+	defer open.Free()
+	next := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, _ := args[0].GoData()
+		record, err := data.(*csv.Reader).Read()
+		if err == io.EOF {
+			return ctx.Null()
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return ctx.ThrowSyntaxError("%s", err)
+		}
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		fields := make([]quickjs.Value, len(record))
+		for i, field := range record {
+			fields[i] = ctx.String(field)
+		}
+		return quickjs.NewTreeBuilder(ctx).Array(fields)
+	})
+	defer next.Free()
+	writeRow := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name := args[0].String()
+		cw, ok := writers[name]
+		if !ok {
+			w, ok := o.writers[name]
+			if !ok {
+				return ctx.ThrowTypeError("unknown sink %s", name)
+			}
+			cw = csv.NewWriter(w)
+			var err error
+			if cw.Comma, err = char(args[2].String(), "comma"); err != nil {
+				return ctx.ThrowTypeError("%s", err)
+			}
+			cw.UseCRLF = args[3].Bool()
+			writers[name] = cw
+		}
+		record := make([]string, args[1].Len())
+		for i := range record {
+			field := args[1].GetIdx(int64(i))
+			record[i] = field.String()
+			field.Free()
+		}
+		if err := cw.Write(record); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.Undefined()
+	})
+	defer writeRow.Free()
+	flush := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if cw, ok := writers[args[0].String()]; ok {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return ctx.ThrowError(err)
+			}
+		}
+		return ctx.Undefined()
+	})
+	defer flush.Free()
+
+	patch, err := ctx.Eval(csvPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), open, next, writeRow, flush)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("csv", exports, "read", "write")
+}
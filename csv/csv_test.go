@@ -0,0 +1,66 @@
+package csv_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/csv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	var out, raw bytes.Buffer
+	require.NoError(t, csv.Install(ctx,
+		csv.Reader("people", strings.NewReader("name,age\nAnn,34\n\"Bob, Jr.\",12\nCid,19\n")),
+		csv.Reader("semi", strings.NewReader("# comment\na; b\nc; \"d\"\"\"\n")),
+		csv.Reader("bad", strings.NewReader("a,b\n1\n")),
+		csv.Reader("spare", strings.NewReader("")),
+		csv.Writer("out", &out),
+		csv.Writer("raw", &raw),
+	))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { read, write } from "csv";
+		async function* adults(rows) {
+			for await (const row of rows) {
+				if (Number(row.age) >= 18) yield { ...row, adult: true };
+			}
+		}
+		const written = await write("out", adults(read("people")));
+		const semi = [];
+		for await (const row of read("semi", { header: false, comma: ";", comment: "#", trimLeadingSpace: true })) semi.push(row);
+		await write("raw", [[1, null, "x,y"], [new Date(0)]], { columns: ["a", "b", "c"] });
+		await write("raw", [{ c: 3, a: 1 }]);
+		globalThis.result = { written, semi };
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"written": 2, "semi": [["a", "b"], ["c", "d\""]]}`, ret.String())
+	ret.Free()
+	require.Equal(t, "name,age,adult\nAnn,34,true\nCid,19,true\n", out.String())
+	require.Equal(t, "a,b,c\n1,,\"x,y\"\n1970-01-01T00:00:00.000Z\n1,,3\n", raw.String())
+
+	for src, want := range map[string]string{
+		`(async () => { for await (const row of read("bad")); })()`: "SyntaxError: record on line 2: wrong number of fields",
+		`read("people")`:                 "Error: source people is already read",
+		`read("missing")`:                "TypeError: unknown source missing",
+		`read("spare", { comma: ";;" })`: "TypeError: comma must be a single character",
+		`write("missing", [[1]])`:        "TypeError: unknown sink missing",
+		`write("raw", 42)`:               "TypeError: rows must be iterable",
+		`write("raw", [1])`:              "TypeError: row must be an array or an object",
+	} {
+		_, err := ctx.Eval(`import("csv").then(({ read, write }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
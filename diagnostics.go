@@ -0,0 +1,92 @@
+package quickjs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// Diagnostic is a syntax error of a script, located for editors to underline it.
+type Diagnostic struct {
+	Message string // message of the SyntaxError, such as "unexpected token in expression: ';'"
+	File    string
+	Line    int    // 1-based line number
+	Col     int    // 1-based column number, in characters; 0 if unknown
+	Token   string // text of the offending token, if known
+	Excerpt string // text of the line
+}
+
+// SyntaxErrors are the syntax errors of a script that failed to compile. The engine stops at the first error, so
+// there is one. The *Error returned by Eval, Compile and LoadModule for a script that doesn't compile wraps them:
+//
+//	var diags quickjs.SyntaxErrors
+//	if errors.As(err, &diags) {
+//		for _, d := range diags {
+//			fmt.Printf("%s:%d:%d: %s\n", d.File, d.Line, d.Col, d.Message)
+//		}
+//	}
+type SyntaxErrors []Diagnostic
+
+func (errs SyntaxErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, d := range errs {
+		msgs[i] = fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col, d.Message)
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// unexpectedTokenRe matches the messages of the engine naming the offending token.
+var unexpectedTokenRe = regexp.MustCompile(`^unexpected token in [\w ]+: '(.*)'$`)
+
+// diagnose adds its diagnostics to err if it is the syntax error of compiling code, the source of the script
+// named filename.
+func diagnose(err error, filename, code string) error {
+	var jsErr *Error
+	if !errors.As(err, &jsErr) || !strings.HasPrefix(jsErr.Cause, "SyntaxError: ") || len(jsErr.Frames) != 1 {
+		return err
+	}
+	// The location of a syntax error of the script is its only frame, without a function; those of the code it
+	// parses, such as JSON or eval code, are followed by the frames of the script.
+	frame := jsErr.Frames[0]
+	if frame.Func != "" || frame.File != filename || frame.Line <= 0 {
+		return err
+	}
+	d := Diagnostic{Message: strings.TrimPrefix(jsErr.Cause, "SyntaxError: "), File: filename, Line: frame.Line}
+	lines := strings.Split(code, "\n")
+	if d.Line > len(lines) {
+		return err
+	}
+	d.Excerpt = strings.TrimSuffix(lines[d.Line-1], "\r")
+	if m := unexpectedTokenRe.FindStringSubmatch(d.Message); m != nil {
+		d.Token = m[1]
+	}
+
+	offset := 0 // 1-based byte column in the line
+	toks, lexErr := jslex.Tokenize(code)
+	var lerr *jslex.Error
+	switch {
+	case errors.As(lexErr, &lerr):
+		if lerr.Line == d.Line {
+			offset = lerr.Col
+		}
+	case d.Token != "":
+		for _, tok := range toks {
+			if tok.Line == d.Line && tok.Text == d.Token {
+				offset = tok.Col
+				break
+			}
+		}
+	case strings.Contains(d.Message, "unexpected token"):
+		// The end of the script.
+		offset = len(d.Excerpt) + 1
+	}
+	if offset > 0 && offset <= len(d.Excerpt)+1 {
+		d.Col = utf8.RuneCountInString(d.Excerpt[:offset-1]) + 1
+	}
+	jsErr.Diagnostics = SyntaxErrors{d}
+	return err
+}
@@ -0,0 +1,215 @@
+// Package dom defines the "dom" host module of quickjs, parsing HTML and XML documents in Go with a read-only DOM-like
+// API.
+package dom
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/buke/quickjs-go"
+	"golang.org/x/net/html"
+)
+
+// parseXML parses an XML document to the node tree of the HTML parser, so that both are queried alike. Names lose their
+// namespace prefix; processing instructions and directives are dropped.
+func parseXML(r io.Reader) (*html.Node, error) {
+	doc := &html.Node{Type: html.DocumentNode}
+	parent := doc
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &html.Node{Type: html.ElementNode, Data: tok.Name.Local}
+			for _, a := range tok.Attr {
+				n.Attr = append(n.Attr, html.Attribute{Key: a.Name.Local, Val: a.Value})
+			}
+			parent.AppendChild(n)
+			parent = n
+		case xml.EndElement:
+			parent = parent.Parent
+		case xml.CharData:
+			parent.AppendChild(&html.Node{Type: html.TextNode, Data: string(tok)})
+		case xml.Comment:
+			parent.AppendChild(&html.Node{Type: html.CommentNode, Data: string(tok)})
+		}
+	}
+	if doc.FirstChild == nil {
+		return nil, errors.New("XML document has no element")
+	}
+	return doc, nil
+}
+
+// nodeText returns the concatenated text of the descendants of n.
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+const domPatch = `(parse, select, info, children, parent, text, render) => {
+	const wrap = (node) => node === null ? null : new Element(node);
+	class Element {
+		#node;
+		constructor(node) {
+			this.#node = node;
+		}
+		get tagName() { return info(this.#node).tagName; }
+		get attributes() { return info(this.#node).attributes; }
+		getAttribute(name) { return info(this.#node).attributes[String(name)] ?? null; }
+		hasAttribute(name) { return Object.hasOwn(info(this.#node).attributes, String(name)); }
+		get textContent() { return text(this.#node); }
+		get children() { return children(this.#node).map(wrap); }
+		get parentElement() { return wrap(parent(this.#node)); }
+		get innerHTML() { return render(this.#node, false); }
+		get outerHTML() { return render(this.#node, true); }
+		querySelector(selector) { return wrap(select(this.#node, String(selector), false)[0] ?? null); }
+		querySelectorAll(selector) { return select(this.#node, String(selector), true).map(wrap); }
+	}
+	Object.defineProperty(Element.prototype, Symbol.toStringTag, { value: "Element", configurable: true });
+	const check = (text) => {
+		if (typeof text !== "string") throw new TypeError("document must be a string");
+		return text;
+	};
+	return {
+		parseHTML: (text) => new Element(parse(check(text), false)),
+		parseXML: (text) => new Element(parse(check(text), true)),
+	};
+}`
+
+// Install defines the "dom" module of ctx, parsing HTML and XML documents in Go for scraping and transformation
+// scripts:
+//
+//	import { parseHTML } from "dom";
+//	const doc = parseHTML(page);
+//	const links = doc.querySelectorAll("a[href]").map((a) => a.getAttribute("href"));
+//
+// parseHTML(text) parses a document as browsers do, and parseXML(text) parses a well-formed XML document, throwing a
+// SyntaxError otherwise. Both return the document, an element with querySelector and querySelectorAll, taking CSS
+// selectors, tagName, attributes, getAttribute, hasAttribute, textContent, children, parentElement, innerHTML and
+// outerHTML. The elements are read-only views of the parsed tree.
+func Install(ctx *quickjs.Context) error {
+	node := func(v quickjs.Value) *html.Node {
+		data, _ := v.GoData()
+		return data.(*html.Node)
+	}
+	wrap := func(n *html.Node) quickjs.Value {
+		return ctx.GoObject(n, nil)
+	}
+
+	parse := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		var doc *html.Node
+		var err error
+		if args[1].Bool() {
+			doc, err = parseXML(strings.NewReader(args[0].String()))
+			if err != nil {
+				return ctx.ThrowSyntaxError("%s", err)
+			}
+		} else if doc, err = html.Parse(strings.NewReader(args[0].String())); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return wrap(doc)
+	})
+	defer parse.Free()
+	selectNodes := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		sel, err := cascadia.Parse(args[1].String())
+		if err != nil {
+			return ctx.ThrowSyntaxError("invalid selector %q: %s", args[1].String(), err)
+		}
+		arr := ctx.Array().ToValue()
+		if args[2].Bool() {
+			for i, n := range cascadia.QueryAll(node(args[0]), sel) {
+				arr.SetIdx(int64(i), wrap(n))
+			}
+		} else if n := cascadia.Query(node(args[0]), sel); n != nil {
+			arr.SetIdx(0, wrap(n))
+		}
+		return arr
+	})
+	defer selectNodes.Free()
+	info := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		n := node(args[0])
+		ret := ctx.Object()
+		if n.Type == html.ElementNode {
+			ret.Set("tagName", ctx.String(n.Data))
+		} else {
+			ret.Set("tagName", ctx.Null())
+		}
+		attrs := ctx.Object()
+		for _, a := range n.Attr {
+			attrs.Set(a.Key, ctx.String(a.Val))
+		}
+		ret.Set("attributes", attrs)
+		return ret
+	})
+	defer info.Free()
+	children := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		arr := ctx.Array().ToValue()
+		var i int64
+		for c := node(args[0]).FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				arr.SetIdx(i, wrap(c))
+				i++
+			}
+		}
+		return arr
+	})
+	defer children.Free()
+	parent := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if p := node(args[0]).Parent; p != nil {
+			return wrap(p)
+		}
+		return ctx.Null()
+	})
+	defer parent.Free()
+	text := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.String(nodeText(node(args[0])))
+	})
+	defer text.Free()
+	render := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		n := node(args[0])
+		var sb strings.Builder
+		if args[1].Bool() && n.Type != html.DocumentNode {
+			if err := html.Render(&sb, n); err != nil {
+				return ctx.ThrowError(err)
+			}
+		} else {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if err := html.Render(&sb, c); err != nil {
+					return ctx.ThrowError(err)
+				}
+			}
+		}
+		return ctx.String(sb.String())
+	})
+	defer render.Free()
+
+	patch, err := ctx.Eval(domPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), parse, selectNodes, info, children, parent, text, render)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("dom", exports, "parseHTML", "parseXML")
+}
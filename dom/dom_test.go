@@ -0,0 +1,71 @@
+package dom_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/dom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, dom.Install(ctx))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { parseHTML, parseXML } from "dom";
+		const doc = parseHTML('<ul id="menu"><li class="item"><a href="/a">A</a></li><li class="item active"><a href="/b">B <b>!</b></a></li></ul>');
+		const active = doc.querySelector("li.active");
+		const feed = parseXML('<?xml version="1.0"?><feed><entry id="1"><title>First</title></entry><entry id="2"><title>Second</title></entry></feed>');
+		globalThis.result = {
+			links: doc.querySelectorAll("#menu > li a[href]").map((a) => a.getAttribute("href")),
+			text: active.textContent,
+			tag: active.tagName,
+			attributes: active.attributes,
+			has: [active.hasAttribute("class"), active.hasAttribute("id")],
+			children: doc.querySelector("ul").children.length,
+			parent: active.parentElement.getAttribute("id"),
+			inner: active.innerHTML,
+			outer: doc.querySelector("a").outerHTML,
+			missing: doc.querySelector("table"),
+			titles: feed.querySelectorAll("entry > title").map((t) => t.textContent),
+			second: feed.querySelector("entry[id='2'] title").textContent,
+			tagOf: Object.prototype.toString.call(doc),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"links": ["/a", "/b"],
+		"text": "B !",
+		"tag": "li",
+		"attributes": {"class": "item active"},
+		"has": [true, false],
+		"children": 2,
+		"parent": "menu",
+		"inner": "<a href=\"/b\">B <b>!</b></a>",
+		"outer": "<a href=\"/a\">A</a>",
+		"missing": null,
+		"titles": ["First", "Second"],
+		"second": "Second",
+		"tagOf": "[object Element]"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`parseHTML("<p>").querySelector("p[")`: `SyntaxError: invalid selector "p[":`,
+		`parseXML("<a><b></a>")`:               "SyntaxError: XML syntax error on line 1",
+		`parseHTML(42)`:                        "TypeError: document must be a string",
+	} {
+		_, err := ctx.Eval(`import("dom").then(({ parseHTML, parseXML }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.ErrorContains(t, err, want, src)
+	}
+}
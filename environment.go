@@ -0,0 +1,186 @@
+package quickjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// EnvironmentProfile describes the browser-like globals defined by SetEnvironmentProfile. Zero fields take the
+// defaults documented below.
+type EnvironmentProfile struct {
+	// UserAgent is navigator.userAgent; default "quickjs-go".
+	UserAgent string
+	// Languages is navigator.languages, the first being navigator.language; default the locale of the context, or
+	// en-US.
+	Languages []string
+	// Platform is navigator.platform; default the operating system and architecture, such as "linux amd64".
+	Platform string
+	// HardwareConcurrency is navigator.hardwareConcurrency; default the number of CPUs.
+	HardwareConcurrency int
+	// Location is the URL of the location stub; default "about:blank".
+	Location string
+	// Aliases are the names of globals referring to the global object, such as self and window.
+	Aliases []string
+}
+
+var (
+	// BrowserProfile is the environment of the scripts of a web page.
+	BrowserProfile = EnvironmentProfile{Aliases: []string{"self", "window"}}
+	// WorkerProfile is the environment of the scripts of a web worker, which have no window.
+	WorkerProfile = EnvironmentProfile{Aliases: []string{"self"}}
+)
+
+const environmentPatch = `(profile) => {
+	const define = (name, value) => Object.defineProperty(globalThis, name, { value, writable: true, configurable: true });
+	const tagged = (obj, tag) => Object.defineProperty(obj, Symbol.toStringTag, { value: tag, configurable: true });
+	const { location, aliases, ...navigator } = profile;
+	define("navigator", Object.freeze(tagged({
+		...navigator,
+		languages: Object.freeze(navigator.languages),
+		onLine: true,
+		cookieEnabled: false,
+		webdriver: false,
+	}, "Navigator")));
+	define("location", tagged({
+		...location,
+		assign() {},
+		replace() {},
+		reload() {},
+		toString() { return this.href; },
+	}, "Location"));
+	for (const name of aliases) define(name, globalThis);
+}`
+
+type locationStub struct {
+	Href     string `json:"href"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Hostname string `json:"hostname"`
+	Port     string `json:"port"`
+	Pathname string `json:"pathname"`
+	Search   string `json:"search"`
+	Hash     string `json:"hash"`
+	Origin   string `json:"origin"`
+}
+
+// SetEnvironmentProfile defines the globals that scripts written for browsers expect, so that their feature detection
+// does not fail: navigator, with the properties of profile, location, a stub of profile.Location whose navigation
+// methods do nothing, and the aliases of the global object. It may be called again to change them; globals of aliases
+// no longer in the profile are kept.
+func (ctx *Context) SetEnvironmentProfile(profile EnvironmentProfile) error {
+	if profile.UserAgent == "" {
+		profile.UserAgent = "quickjs-go"
+	}
+	if len(profile.Languages) == 0 {
+		profile.Languages = []string{"en-US"}
+		if locale := ctx.Locale(); locale != "" {
+			profile.Languages[0] = locale
+		}
+	}
+	if profile.Platform == "" {
+		profile.Platform = runtime.GOOS + " " + runtime.GOARCH
+	}
+	if profile.HardwareConcurrency == 0 {
+		profile.HardwareConcurrency = runtime.NumCPU()
+	}
+	if profile.Location == "" {
+		profile.Location = "about:blank"
+	}
+	u, err := url.Parse(profile.Location)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("location %q is not an absolute URL", profile.Location)
+	}
+	// Like browsers, the host is lower-cased and the path of URLs with a host is never empty.
+	u.Host = strings.ToLower(u.Host)
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+
+	location := locationStub{
+		Href:     u.String(),
+		Protocol: u.Scheme + ":",
+		Host:     u.Host,
+		Hostname: u.Hostname(),
+		Port:     u.Port(),
+		Pathname: u.EscapedPath(),
+		Search:   u.RawQuery,
+		Hash:     u.EscapedFragment(),
+		Origin:   "null",
+	}
+	if u.Opaque != "" {
+		location.Pathname = u.Opaque
+	}
+	if location.Search != "" {
+		location.Search = "?" + location.Search
+	}
+	if location.Hash != "" {
+		location.Hash = "#" + location.Hash
+	}
+	if u.Host != "" {
+		location.Origin = u.Scheme + "://" + u.Host
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"userAgent":           profile.UserAgent,
+		"language":            profile.Languages[0],
+		"languages":           profile.Languages,
+		"platform":            profile.Platform,
+		"hardwareConcurrency": profile.HardwareConcurrency,
+		"location":            location,
+		"aliases":             append([]string{}, profile.Aliases...),
+	})
+	if err != nil {
+		return err
+	}
+	arg, err := ctx.FromJSONRawMessage(raw)
+	if err != nil {
+		return err
+	}
+	defer arg.Free()
+	patch, err := ctx.Eval(environmentPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), arg)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
+
+// SetEnv defines the global env, a frozen object holding the variables of env, to inject configuration in scripts
+// without exposing the environment of the Go process. It may be called again to replace them.
+func (ctx *Context) SetEnv(env map[string]string) error {
+	if env == nil {
+		env = map[string]string{}
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	obj, err := ctx.FromJSONRawMessage(raw)
+	if err != nil {
+		return err
+	}
+	defer obj.Free()
+	patch, err := ctx.Eval(`(env) => Object.defineProperty(globalThis, "env", { value: Object.freeze(env), configurable: true })`,
+		EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), obj)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
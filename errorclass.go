@@ -0,0 +1,65 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+const errorClassFactory = `(name) => {
+	const cls = class extends Error {};
+	Object.defineProperty(cls, "name", { value: name });
+	Object.defineProperty(cls.prototype, "name", { value: name, writable: true, configurable: true });
+	return cls;
+}`
+
+// RegisterErrorClass defines a global subclass of Error with the given name, which Go functions can throw with
+// ThrowCustom and scripts can catch with instanceof. Registering a name again keeps the existing class.
+func (ctx *Context) RegisterErrorClass(name string) error {
+	if _, ok := ctx.errorClass[name]; ok {
+		return nil
+	}
+	factory, err := ctx.Eval(errorClassFactory, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer factory.Free()
+	nameVal := ctx.String(name)
+	defer nameVal.Free()
+	cls := ctx.Invoke(factory, ctx.Null(), nameVal)
+	if cls.IsException() {
+		return ctx.Exception()
+	}
+	ctx.untrack(cls)
+
+	if ctx.errorClass == nil {
+		ctx.errorClass = make(map[string]C.JSValue)
+	}
+	ctx.errorClass[name] = cls.ref
+	ctx.Globals().Set(name, ctx.track(Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, cls.ref)}))
+	return nil
+}
+
+// ThrowCustom returns a context's exception value with an error of the class registered as name, with given message
+// and additional properties. The property values are consumed. An InternalError is thrown if the class is not registered.
+func (ctx *Context) ThrowCustom(name string, message string, props map[string]Value) Value {
+	ctor, ok := ctx.errorClass[name]
+	if !ok {
+		for _, v := range props {
+			v.Free()
+		}
+		return ctx.ThrowInternalError("unknown error class %q", name)
+	}
+	msg := ctx.String(message)
+	defer msg.Free()
+	val := Value{ctx: ctx, ref: ctor}.CallConstructor(msg)
+	if val.IsException() {
+		for _, v := range props {
+			v.Free()
+		}
+		return val
+	}
+	for k, v := range props {
+		val.Set(k, v)
+	}
+	return ctx.Throw(val)
+}
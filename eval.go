@@ -0,0 +1,26 @@
+package quickjs
+
+const evalPatch = `(() => {
+	const disabled = function () {
+		throw new EvalError("code generation from strings is disabled");
+	};
+	const lock = (obj, name, value) => Object.defineProperty(obj, name, { value, writable: false, configurable: false });
+	// The prototypes of the kinds of functions lead to their constructors, which compile code like Function.
+	for (const fn of [function () {}, async function () {}, function* () {}, async function* () {}]) {
+		lock(Object.getPrototypeOf(fn), "constructor", disabled);
+	}
+	disabled.prototype = Function.prototype;
+	lock(globalThis, "Function", disabled);
+	lock(globalThis, "eval", disabled);
+})()`
+
+// disableEval replaces eval and the function constructors with functions throwing an EvalError.
+func (ctx *Context) disableEval() error {
+	ret, err := ctx.Eval(evalPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	ctx.evalDisabled = true
+	return nil
+}
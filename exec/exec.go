@@ -0,0 +1,188 @@
+// Package exec defines the "exec" host module of quickjs, running allowlisted commands for trusted automation scripts.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+// ErrCommandNotAllowed is thrown by the run function of the exec module for commands not allowed by Commands, or whose
+// arguments are rejected by Validate.
+var ErrCommandNotAllowed = errors.New("exec: command not allowed")
+
+// Options configures the exec module defined by Install.
+type Options struct {
+	commands  map[string]bool
+	validate  func(command string, args []string) error
+	maxOutput int64
+	timeout   time.Duration
+	dir       string
+	env       []string
+}
+
+// Option configures the exec module defined by Install.
+type Option func(*Options)
+
+// Commands allows the given commands, names looked up in the PATH of the Go process or paths; by default no command is
+// allowed.
+func Commands(commands ...string) Option {
+	return func(o *Options) {
+		for _, c := range commands {
+			o.commands[c] = true
+		}
+	}
+}
+
+// Validate sets a function consulted with the arguments of every allowed command, returning an error to reject them.
+func Validate(validate func(command string, args []string) error) Option {
+	return func(o *Options) {
+		o.validate = validate
+	}
+}
+
+// MaxOutput limits the size of the standard output and of the standard error of a command; it is 1 MiB by default. A
+// command writing more is killed.
+func MaxOutput(n int64) Option {
+	return func(o *Options) {
+		o.maxOutput = n
+	}
+}
+
+// Timeout kills a command running longer than d; it is 30 seconds by default.
+func Timeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = d
+	}
+}
+
+// Dir sets the working directory of the commands; by default it is the one of the Go process.
+func Dir(dir string) Option {
+	return func(o *Options) {
+		o.dir = dir
+	}
+}
+
+// Env sets the environment of the commands, as "key=value" strings; by default it is empty.
+func Env(env ...string) Option {
+	return func(o *Options) {
+		o.env = env
+	}
+}
+
+// limitedBuffer is the output of a command, canceling it when it exceeds its limit.
+type limitedBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		b.exceeded = true
+		b.cancel()
+		return 0, errors.New("output limit exceeded")
+	}
+	return b.buf.Write(p)
+}
+
+const execPatch = `(run) => ({
+	run(command, args = [], { input } = {}) {
+		if (!Array.isArray(args)) throw new TypeError("args must be an array");
+		if (input !== undefined && typeof input !== "string") throw new TypeError("input must be a string");
+		return run(String(command), args.map(String), input);
+	},
+})`
+
+// Install defines the "exec" module of ctx, running the commands allowed by Commands for trusted automation scripts:
+//
+//	import { run } from "exec";
+//	const { code, stdout } = run("git", ["rev-parse", "HEAD"]);
+//
+// run(command, args, {input}) runs a command with args, without shell, writing input to its standard input, and returns
+// an object with its exit code, stdout and stderr. Commands not allowed, or whose arguments are rejected by Validate,
+// throw ErrCommandNotAllowed; commands writing more than MaxOutput throw a RangeError and commands running longer than
+// Timeout throw an error. The module is not defined unless Install is called.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{commands: map[string]bool{}, maxOutput: 1 << 20, timeout: 30 * time.Second, env: []string{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	run := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		command := args[0].String()
+		cmdArgs := make([]string, args[1].Len())
+		for i := range cmdArgs {
+			arg := args[1].GetIdx(int64(i))
+			b, err := arg.StringLenBytes()
+			arg.Free()
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			cmdArgs[i] = string(b)
+		}
+		if !o.commands[command] {
+			return ctx.ThrowError(fmt.Errorf("%w: %s", ErrCommandNotAllowed, command))
+		}
+		if o.validate != nil {
+			if err := o.validate(command, cmdArgs); err != nil {
+				return ctx.ThrowError(fmt.Errorf("%w: %s: %v", ErrCommandNotAllowed, command, err))
+			}
+		}
+
+		runCtx, cancel := context.WithTimeout(context.Background(), o.timeout)
+		defer cancel()
+		cmd := exec.CommandContext(runCtx, command, cmdArgs...)
+		cmd.Dir, cmd.Env = o.dir, o.env
+		cmd.WaitDelay = 100 * time.Millisecond // for the children of a killed command holding its output
+		if args[2].IsString() {
+			input, err := args[2].StringLenBytes()
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			cmd.Stdin = bytes.NewReader(input)
+		}
+		stdout := &limitedBuffer{limit: o.maxOutput, cancel: cancel}
+		stderr := &limitedBuffer{limit: o.maxOutput, cancel: cancel}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		err := cmd.Run()
+		switch {
+		case stdout.exceeded || stderr.exceeded:
+			return ctx.ThrowRangeError("%s wrote more than %d bytes", command, o.maxOutput)
+		case runCtx.Err() == context.DeadlineExceeded:
+			return ctx.ThrowError(fmt.Errorf("%s timed out after %s", command, o.timeout))
+		}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return ctx.ThrowError(err)
+		}
+
+		ret := ctx.Object()
+		ret.Set("code", ctx.Int32(int32(cmd.ProcessState.ExitCode())))
+		ret.Set("stdout", ctx.String(stdout.buf.String()))
+		ret.Set("stderr", ctx.String(stderr.buf.String()))
+		return ret
+	})
+	defer run.Free()
+
+	patch, err := ctx.Eval(execPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), run)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("exec", exports, "run")
+}
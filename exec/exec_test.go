@@ -0,0 +1,67 @@
+package exec_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjsexec "github.com/buke/quickjs-go/exec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, quickjsexec.Install(ctx,
+		quickjsexec.Commands("echo", "cat", "sh"),
+		quickjsexec.Validate(func(command string, args []string) error {
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "--") {
+					return fmt.Errorf("option %s not allowed", arg)
+				}
+			}
+			return nil
+		}),
+		quickjsexec.MaxOutput(64),
+		quickjsexec.Timeout(200*time.Millisecond),
+		quickjsexec.Env("GREETING=hello"),
+	))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { run } from "exec";
+		globalThis.result = {
+			echo: run("echo", ["a", 1]),
+			cat: run("cat", [], { input: "piped" }).stdout,
+			env: run("sh", ["-c", "echo $GREETING"]).stdout,
+			failed: run("sh", ["-c", "echo oops >&2; exit 3"]),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"echo": {"code": 0, "stdout": "a 1\n", "stderr": ""}, "cat": "piped", "env": "hello\n",
+		"failed": {"code": 3, "stdout": "", "stderr": "oops\n"}}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`run("rm", ["-rf", "/tmp/x"])`:           "Error: exec: command not allowed: rm",
+		`run("echo", ["--help"])`:                "Error: exec: command not allowed: echo: option --help not allowed",
+		`run("sh", ["-c", "yes | head -c 100"])`: "RangeError: sh wrote more than 64 bytes",
+		`run("sh", ["-c", "sleep 5"])`:           "Error: sh timed out after 200ms",
+		`run("echo", "a")`:                       "TypeError: args must be an array",
+	} {
+		_, err := ctx.Eval(`import("exec").then(({ run }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,347 @@
+package quickjs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrExecutorClosed is returned by the futures of tasks submitted after Executor.Close.
+	ErrExecutorClosed = errors.New("quickjs: executor closed")
+	// ErrRuntimeCorrupted may be returned (or wrapped) by a task to make its worker replace the runtime and retry the task.
+	ErrRuntimeCorrupted = errors.New("quickjs: runtime corrupted")
+)
+
+// Task is a unit of work run by an Executor on one of its contexts.
+type Task func(ctx *Context) (interface{}, error)
+
+// Future is the pending result of a submitted task.
+type Future struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Done returns a channel closed when the task has finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the task to finish and returns its result.
+func (f *Future) Wait() (interface{}, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+func (f *Future) resolve(value interface{}, err error) {
+	f.value, f.err = value, err
+	close(f.done)
+}
+
+type taskLimits struct {
+	timeout     time.Duration
+	memoryLimit uint64
+	retries     int
+}
+
+// TaskOption overrides the limits of the executor for a single task.
+type TaskOption func(*taskLimits)
+
+// TaskTimeout interrupts the JS code of the task after d.
+func TaskTimeout(d time.Duration) TaskOption {
+	return func(l *taskLimits) {
+		l.timeout = d
+	}
+}
+
+// TaskMemoryLimit limits the memory of the runtime while the task runs.
+func TaskMemoryLimit(limit uint64) TaskOption {
+	return func(l *taskLimits) {
+		l.memoryLimit = limit
+	}
+}
+
+// TaskRetries sets how many times the task is retried on a fresh runtime after corrupting its runtime.
+func TaskRetries(retries int) TaskOption {
+	return func(l *taskLimits) {
+		l.retries = retries
+	}
+}
+
+type executorOptions struct {
+	workers        int
+	queueSize      int
+	runtimeOptions []Option
+	setup          func(*Context) error
+	limits         taskLimits
+	hub            *BroadcastHub
+}
+
+// ExecutorOption configures an Executor.
+type ExecutorOption func(*executorOptions)
+
+// WithWorkers sets the number of runtimes of the executor; default is 1.
+func WithWorkers(n int) ExecutorOption {
+	return func(o *executorOptions) {
+		o.workers = n
+	}
+}
+
+// WithQueueSize sets how many submitted tasks may wait for a worker before Submit blocks; default is 0.
+func WithQueueSize(n int) ExecutorOption {
+	return func(o *executorOptions) {
+		o.queueSize = n
+	}
+}
+
+// WithRuntimeOptions sets the options of the runtimes created by the executor.
+func WithRuntimeOptions(opts ...Option) ExecutorOption {
+	return func(o *executorOptions) {
+		o.runtimeOptions = opts
+	}
+}
+
+// WithContextSetup sets a function preparing each context (globals, modules) before it runs tasks.
+func WithContextSetup(setup func(*Context) error) ExecutorOption {
+	return func(o *executorOptions) {
+		o.setup = setup
+	}
+}
+
+// WithBroadcastHub installs BroadcastChannel in every context of the executor, connected through hub.
+func WithBroadcastHub(hub *BroadcastHub) ExecutorOption {
+	return func(o *executorOptions) {
+		o.hub = hub
+	}
+}
+
+// WithTaskLimits sets the default limits of the tasks.
+func WithTaskLimits(opts ...TaskOption) ExecutorOption {
+	return func(o *executorOptions) {
+		for _, opt := range opts {
+			opt(&o.limits)
+		}
+	}
+}
+
+type executorTask struct {
+	task   Task
+	limits taskLimits
+	future *Future
+}
+
+// Executor runs tasks on a pool of runtimes, each owned by its own goroutine.
+type Executor struct {
+	options executorOptions
+	mu      sync.RWMutex
+	closed  bool
+	tasks   chan executorTask
+	stop    chan struct{} // closed by Close
+	workers int32         // workers running
+	wg      sync.WaitGroup
+	ready   chan error
+}
+
+// NewExecutor starts the workers of an executor. It fails if a context could not be set up.
+func NewExecutor(opts ...ExecutorOption) (*Executor, error) {
+	e := &Executor{options: executorOptions{workers: 1}}
+	for _, opt := range opts {
+		opt(&e.options)
+	}
+	if e.options.workers < 1 {
+		e.options.workers = 1
+	}
+	e.tasks = make(chan executorTask, e.options.queueSize)
+	e.stop = make(chan struct{})
+	e.ready = make(chan error, e.options.workers)
+
+	e.workers = int32(e.options.workers)
+	for i := 0; i < e.options.workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+	var errs []error
+	for i := 0; i < e.options.workers; i++ {
+		if err := <-e.ready; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		e.Close()
+		return nil, errors.Join(errs...)
+	}
+	return e, nil
+}
+
+// Submit queues a task and returns its future. It blocks while the queue is full.
+func (e *Executor) Submit(task Task, opts ...TaskOption) *Future {
+	f := &Future{done: make(chan struct{})}
+	limits := e.options.limits
+	for _, opt := range opts {
+		opt(&limits)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		f.resolve(nil, ErrExecutorClosed)
+		return f
+	}
+	e.tasks <- executorTask{task: task, limits: limits, future: f}
+	return f
+}
+
+// Close waits for the submitted tasks to finish and closes the runtimes.
+func (e *Executor) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.tasks)
+		close(e.stop)
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+type executorWorker struct {
+	rt  Runtime
+	ctx *Context
+}
+
+func (e *Executor) newWorker() (*executorWorker, error) {
+	rt := NewRuntime(e.options.runtimeOptions...)
+	ctx := rt.NewContext()
+	if e.options.hub != nil {
+		if err := ctx.InstallBroadcastChannel(e.options.hub); err != nil {
+			ctx.Close()
+			rt.Close()
+			return nil, err
+		}
+	}
+	if e.options.setup != nil {
+		if err := e.options.setup(ctx); err != nil {
+			ctx.Close()
+			rt.Close()
+			return nil, err
+		}
+	}
+	return &executorWorker{rt: rt, ctx: ctx}, nil
+}
+
+func (w *executorWorker) close() {
+	w.ctx.Close()
+	w.rt.Close()
+}
+
+func (e *Executor) work() {
+	defer e.wg.Done()
+	defer func() {
+		if atomic.AddInt32(&e.workers, -1) == 0 {
+			// No worker is left to run the tasks still queued when the executor was closed.
+			for t := range e.tasks {
+				t.future.resolve(nil, ErrExecutorClosed)
+			}
+		}
+	}()
+	w, err := e.newWorker()
+	e.ready <- err
+	if err != nil {
+		return
+	}
+
+	for {
+		ready, _ := w.ctx.gatewayChans()
+		select {
+		case t, ok := <-e.tasks:
+			if !ok {
+				w.close()
+				return
+			}
+			if w = e.runTask(w, t); w == nil {
+				if w = e.recoverWorker(); w == nil {
+					return
+				}
+			}
+		case <-ready:
+			// Idle workers serve the calls of Do, such as the delivery of broadcast messages.
+			w.ctx.serveCalls(nil)
+		}
+	}
+}
+
+// recoverWorker creates the runtime of a worker whose runtime could not be replaced, waiting longer after each
+// failure, while the other workers keep running the tasks. It returns nil once the executor is closed.
+func (e *Executor) recoverWorker() *executorWorker {
+	delay := 10 * time.Millisecond
+	for {
+		select {
+		case <-time.After(delay):
+		case <-e.stop:
+			return nil
+		}
+		if w, err := e.newWorker(); err == nil {
+			return w
+		}
+		if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+	}
+}
+
+// runTask runs a task, replacing the worker if the task corrupts its runtime. It returns the worker to use next, or
+// nil if no runtime could be created; the task then fails with the error of the runtime creation.
+func (e *Executor) runTask(w *executorWorker, t executorTask) *executorWorker {
+	for attempt := 0; ; attempt++ {
+		value, corrupted, err := w.run(t)
+		if corrupted {
+			w.close()
+			var newErr error
+			if w, newErr = e.newWorker(); newErr != nil {
+				t.future.resolve(nil, newErr)
+				return nil
+			}
+			if attempt < t.limits.retries {
+				continue
+			}
+		}
+		t.future.resolve(value, err)
+		return w
+	}
+}
+
+// run runs a task with its limits and reports whether the runtime must be replaced.
+func (w *executorWorker) run(t executorTask) (value interface{}, corrupted bool, err error) {
+	if t.limits.memoryLimit > 0 {
+		w.rt.SetMemoryLimit(t.limits.memoryLimit)
+		defer w.rt.SetMemoryLimit(w.rt.defaultMemoryLimit())
+	}
+	if t.limits.timeout > 0 {
+		// The timeout of the task can't extend the execute timeout of the runtime.
+		prev := w.rt.state.deadline
+		if deadline := time.Now().Add(t.limits.timeout); prev.IsZero() || deadline.Before(prev) {
+			w.rt.state.deadline = deadline
+		}
+		w.rt.enableInterrupts()
+		defer func() { w.rt.state.deadline = prev }()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			value, corrupted, err = nil, true, fmt.Errorf("quickjs: panic in task: %v", r)
+		}
+	}()
+
+	value, err = t.task(w.ctx)
+	corrupted = errors.Is(err, ErrRuntimeCorrupted) || errors.Is(err, ErrOutOfMemory)
+	return value, corrupted, err
+}
+
+// defaultMemoryLimit returns the memory limit set by the runtime options, or the maximum if none.
+func (r Runtime) defaultMemoryLimit() uint64 {
+	if r.options.memoryLimit > 0 {
+		return r.options.memoryLimit
+	}
+	return ^uint64(0)
+}
@@ -0,0 +1,312 @@
+// Package file defines the "file" host module of quickjs, giving scripts access to an fs.FS instead of the whole file
+// system.
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/buke/quickjs-go"
+)
+
+// ErrQuota is thrown by the write function of the file module when a write exceeds MaxBytes or MaxFiles, and by
+// the read function when a file is larger than MaxReadBytes.
+var ErrQuota = errors.New("file: quota exceeded")
+
+// defaultMaxReadBytes is the largest file read when MaxReadBytes is not set.
+const defaultMaxReadBytes = 32 << 20
+
+// WritableFS is a file system the file module can write to.
+type WritableFS interface {
+	fs.FS
+	// WriteFile creates or replaces the file name, a path valid for fs.ValidPath, with data, creating its directory
+	// if needed.
+	WriteFile(name string, data []byte) error
+}
+
+// DirFS returns a read-only file system for the tree of files rooted at dir, like os.DirFS, except that the symbolic
+// links leading out of dir are refused. Links are checked when a file is opened, so a link changed concurrently by
+// another process can still escape.
+func DirFS(dir string) fs.FS {
+	return dirFS(dir)
+}
+
+// WritableDirFS returns a file system for the tree of files rooted at dir, like DirFS, that can also be written.
+func WritableDirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	file, err := d.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+func (d dirFS) WriteFile(name string, data []byte) error {
+	file, err := d.resolve("write", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o644)
+}
+
+// resolve returns the path of name with its symbolic links resolved, failing if it leads out of the directory. The
+// part of the path that does not exist yet is kept as is.
+func (d dirFS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	existing, rest := filepath.Join(root, filepath.FromSlash(name)), ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			file := filepath.Join(resolved, rest)
+			if rel, err := filepath.Rel(root, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+			}
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			// A dangling link, which a write would follow.
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+}
+
+// Options configures the file module defined by Install.
+type Options struct {
+	maxBytes int64
+	maxFiles int
+	maxRead  int64
+}
+
+// Option configures the file module defined by Install.
+type Option func(*Options)
+
+// MaxBytes limits the bytes the scripts of the context write, in all their writes.
+func MaxBytes(n int64) Option {
+	return func(o *Options) {
+		o.maxBytes = n
+	}
+}
+
+// MaxReadBytes limits the size of the files the scripts of the context read, 32 MiB by default.
+func MaxReadBytes(n int64) Option {
+	return func(o *Options) {
+		o.maxRead = n
+	}
+}
+
+// MaxFiles limits the number of files the scripts of the context create.
+func MaxFiles(n int) Option {
+	return func(o *Options) {
+		o.maxFiles = n
+	}
+}
+
+// cleanPath converts the path given by a script, relative to the root of the file system or starting with a slash, to a
+// path valid for fs.ValidPath.
+func cleanPath(p string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: p, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// readFile reads the file name, failing if it is larger than max bytes.
+func readFile(fsys fs.FS, name string, max int64) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > max {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrQuota, name, max)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrQuota, name, max)
+	}
+	return data, nil
+}
+
+const filePatch = `(read, write, stat, list) => ({
+	read(path, type = "text") {
+		if (typeof type === "object" && type !== null) type = type.type ?? "text";
+		if (type !== "text" && type !== "json" && type !== "arrayBuffer") throw new TypeError("unknown type " + type);
+		const data = read(String(path), type === "arrayBuffer");
+		return type === "json" ? JSON.parse(data) : data;
+	},
+	write(path, data) {
+		if (typeof data !== "string" && !(data instanceof ArrayBuffer) && !ArrayBuffer.isView(data)) {
+			throw new TypeError("data must be a string, an ArrayBuffer or an ArrayBuffer view");
+		}
+		write(String(path), data);
+	},
+	stat(path) {
+		const info = stat(String(path));
+		if (info !== null) info.modified = new Date(info.modified);
+		return info;
+	},
+	list: (path = ".") => list(String(path)),
+})`
+
+// Install defines the "file" module of ctx, giving scripts access to fsys only, instead of the whole file system as the
+// os module of the engine does:
+//
+//	import * as file from "file";
+//	const config = file.read("config.json", "json");
+//	file.write("out/report.txt", render(config));
+//
+// read(path, type) returns the content of a file as a string, parsed as JSON if type is "json", or as an ArrayBuffer if
+// type is "arrayBuffer". write(path, data) writes a string or binary data if fsys is a WritableFS, within the quotas
+// set by MaxBytes and MaxFiles. stat(path) returns an object with name, size, isDirectory, mode and modified, a Date,
+// or null if path does not exist, and list(path) returns the sorted names of the entries of a directory, the root by
+// default. Paths are relative to the root of fsys, and cannot leave it, but the symbolic links of the directory of
+// os.DirFS are followed wherever they lead: use DirFS or WritableDirFS to refuse them.
+func Install(ctx *quickjs.Context, fsys fs.FS, opts ...Option) error {
+	o := Options{maxRead: defaultMaxReadBytes}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var written int64
+	var created int
+
+	read := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name, err := cleanPath(args[0].String())
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		data, err := readFile(fsys, name, o.maxRead)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if args[1].Bool() {
+			return ctx.ArrayBuffer(data)
+		}
+		return ctx.String(string(data))
+	})
+	defer read.Free()
+	write := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		wfs, ok := fsys.(WritableFS)
+		if !ok {
+			return ctx.ThrowError(errors.New("file system is read-only"))
+		}
+		name, err := cleanPath(args[0].String())
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		data, err := ctx.DataBytes(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if o.maxBytes > 0 && written+int64(len(data)) > o.maxBytes {
+			return ctx.ThrowError(fmt.Errorf("%w: writing %s would exceed %d bytes", ErrQuota, name, o.maxBytes))
+		}
+		_, err = fs.Stat(fsys, name)
+		isNew := errors.Is(err, fs.ErrNotExist)
+		if isNew && o.maxFiles > 0 && created >= o.maxFiles {
+			return ctx.ThrowError(fmt.Errorf("%w: creating %s would exceed %d files", ErrQuota, name, o.maxFiles))
+		}
+		if err := wfs.WriteFile(name, data); err != nil {
+			return ctx.ThrowError(err)
+		}
+		written += int64(len(data))
+		if isNew {
+			created++
+		}
+		return ctx.Undefined()
+	})
+	defer write.Free()
+	stat := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name, err := cleanPath(args[0].String())
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		info, err := fs.Stat(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return ctx.Null()
+		}
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		ret := ctx.Object()
+		ret.Set("name", ctx.String(info.Name()))
+		ret.Set("size", ctx.Int64(info.Size()))
+		ret.Set("isDirectory", ctx.Bool(info.IsDir()))
+		ret.Set("mode", ctx.Int32(int32(info.Mode().Perm())))
+		ret.Set("modified", ctx.Float64(float64(info.ModTime().UnixMilli())))
+		return ret
+	})
+	defer stat.Free()
+	list := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		name, err := cleanPath(args[0].String())
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		sort.Strings(names)
+		arr := ctx.Array().ToValue()
+		for i, name := range names {
+			arr.SetIdx(int64(i), ctx.String(name))
+		}
+		return arr
+	})
+	defer list.Free()
+
+	patch, err := ctx.Eval(filePatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), read, write, stat, list)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("file", exports, "read", "write", "stat", "list")
+}
@@ -0,0 +1,119 @@
+package file_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/file"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"name": "demo"}`), 0o644))
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, file.Install(ctx, file.WritableDirFS(dir), file.MaxBytes(12), file.MaxFiles(2)))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import * as file from "file";
+		file.write("out/a.txt", "hello");
+		file.write("/out/../out/b.bin", new Uint8Array([1, 2, 3]));
+		file.write("out/a.txt", "hi");
+		const stat = file.stat("out/a.txt");
+		globalThis.result = {
+			config: file.read("config.json", "json"),
+			text: file.read("out/a.txt"),
+			bytes: [...new Uint8Array(file.read("out/b.bin", { type: "arrayBuffer" }))],
+			stat: [stat.name, stat.size, stat.isDirectory, stat.modified instanceof Date],
+			dir: file.stat("out").isDirectory,
+			missing: file.stat("missing.txt"),
+			root: file.list(),
+			out: file.list("out"),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"config": {"name": "demo"}, "text": "hi", "bytes": [1, 2, 3], "stat": ["a.txt", 2, false, true],
+		"dir": true, "missing": null, "root": ["config.json", "out"], "out": ["a.txt", "b.bin"]}`, ret.String())
+	ret.Free()
+	data, err := os.ReadFile(filepath.Join(dir, "out", "b.bin"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, data)
+
+	for src, want := range map[string]string{
+		`file.write("c.txt", "x")`:       "Error: file: quota exceeded: creating c.txt would exceed 2 files",
+		`file.write("out/a.txt", "abc")`: "Error: file: quota exceeded: writing out/a.txt would exceed 12 bytes",
+		`file.read("missing.txt")`:       "Error: open missing.txt: no such file or directory",
+		`file.write("a.txt", 1)`:         "TypeError: data must be a string, an ArrayBuffer or an ArrayBuffer view",
+		`file.read("../../etc/passwd")`:  "Error: open etc/passwd: no such file or directory",
+	} {
+		_, err := ctx.Eval(`import("file").then((file) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+
+	readOnly := rt.NewContext()
+	defer readOnly.Close()
+	require.NoError(t, file.Install(readOnly, os.DirFS(dir)))
+	_, err = readOnly.Eval(`import("file").then((file) => file.write("x.txt", "x"))`, quickjs.EvalAwait(true))
+	require.EqualError(t, err, "Error: file system is read-only")
+}
+
+func TestDirFSSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.txt"), make([]byte, 100), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "inside.txt"), []byte("inside"), 0o644))
+	for name, target := range map[string]string{
+		"out":        outside,
+		"secret.txt": filepath.Join(outside, "secret.txt"),
+		"dangling":   filepath.Join(outside, "created.txt"),
+		"inside.txt": filepath.Join(dir, "sub", "inside.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skip("symbolic links are not supported:", err)
+		}
+	}
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, file.Install(ctx, file.WritableDirFS(dir), file.MaxReadBytes(50)))
+
+	for src, want := range map[string]string{
+		`file.read("inside.txt")`:          "inside",
+		`file.read("secret.txt")`:          "Error: open secret.txt: permission denied",
+		`file.read("out/secret.txt")`:      "Error: open out/secret.txt: permission denied",
+		`file.write("out/new.txt", "x")`:   "Error: write out/new.txt: permission denied",
+		`file.write("secret.txt", "x")`:    "Error: write secret.txt: permission denied",
+		`file.write("dangling", "x")`:      "Error: write dangling: permission denied",
+		`file.write("sub/new/a.txt", "x")`: "undefined",
+		`file.read("big.txt")`:             "Error: file: quota exceeded: big.txt is larger than 50 bytes",
+	} {
+		ret, err := ctx.Eval(`import("file").then((file) => `+src+`)`, quickjs.EvalAwait(true))
+		if err != nil {
+			require.EqualError(t, err, want, src)
+			continue
+		}
+		require.Equal(t, want, ret.String(), src)
+		ret.Free()
+	}
+	_, err := os.Stat(filepath.Join(outside, "created.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "new", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(data))
+}
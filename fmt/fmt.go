@@ -0,0 +1,147 @@
+// Package fmt defines the "fmt" host module of quickjs, formatting with the fmt and text/template packages of Go.
+package fmt
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/buke/quickjs-go"
+)
+
+// Options configures the fmt module defined by Install.
+type Options struct {
+	templates *template.Template
+	funcs     template.FuncMap
+}
+
+// Option configures the fmt module defined by Install.
+type Option func(*Options)
+
+// Templates makes the templates associated with t executable by name, and callable with the template action from the
+// templates rendered by scripts, so that Go and JS code share their templates.
+func Templates(t *template.Template) Option {
+	return func(o *Options) {
+		o.templates = t
+	}
+}
+
+// Funcs adds functions to the templates rendered by scripts.
+func Funcs(funcs template.FuncMap) Option {
+	return func(o *Options) {
+		o.funcs = funcs
+	}
+}
+
+// goData returns the Go value of a value tree for fmt and text/template: objects with string keys are
+// map[string]interface{}, so that templates access their fields by name, and Maps with other keys
+// map[interface{}]interface{}.
+func goData(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = goData(item)
+		}
+	case *quickjs.TreeMap:
+		strs := make(map[string]interface{}, len(v.Keys))
+		for i, key := range v.Keys {
+			s, ok := key.(string)
+			if !ok {
+				m := make(map[interface{}]interface{}, len(v.Keys))
+				for i, key := range v.Keys {
+					m[key] = goData(v.Values[i])
+				}
+				return m
+			}
+			strs[s] = goData(v.Values[i])
+		}
+		return strs
+	}
+	return v
+}
+
+// Install defines the "fmt" module of ctx, formatting with the fmt and text/template packages of Go, for code bases
+// sharing their formats and templates between Go and JS:
+//
+//	import { sprintf, render } from "fmt";
+//	sprintf("%-8s|%6.2f|%x", "total", 3.14159, 255); // "total   |  3.14|ff"
+//	render("Hello {{.name}}{{range .tags}}, #{{.}}{{end}}", { name: "Ann", tags: ["a", "b"] });
+//
+// sprintf(format, ...args) formats like fmt.Sprintf. render(text, data) parses a text/template and executes it with
+// data, and execute(name, data) executes a template given by Templates. The values are converted to Go by Value.GoTree:
+// integers are int64 and other numbers float64, BigInts *big.Int, dates time.Time, ArrayBuffers and typed arrays
+// []byte, arrays []interface{}, and objects map[string]interface{}, without their functions. Invalid templates throw a
+// SyntaxError.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	data := func(v quickjs.Value) (interface{}, error) {
+		tree, err := v.GoTree()
+		if err != nil {
+			return nil, err
+		}
+		return goData(tree), nil
+	}
+
+	exports := ctx.Object()
+	exports.Set("sprintf", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		format := args.String(0)
+		if args.Err() != nil {
+			return ctx.Undefined()
+		}
+		values := make([]interface{}, 0, args.Len())
+		for _, arg := range args.Values()[1:] {
+			v, err := data(arg)
+			if err != nil {
+				return ctx.ThrowTypeError("%s", err)
+			}
+			values = append(values, v)
+		}
+		return ctx.String(fmt.Sprintf(format, values...))
+	}))
+	exports.Set("render", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		text := args.String(0)
+		v, err := data(args.Value(1))
+		if err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		t := template.New("render")
+		if o.templates != nil {
+			if t, err = o.templates.Clone(); err != nil {
+				return ctx.ThrowError(err)
+			}
+			t = t.New("render")
+		}
+		if _, err := t.Funcs(o.funcs).Parse(text); err != nil {
+			return ctx.ThrowSyntaxError("%s", err)
+		}
+		var sb strings.Builder
+		if err := t.Execute(&sb, v); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.String(sb.String())
+	}))
+	exports.Set("execute", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		name := args.String(0)
+		v, err := data(args.Value(1))
+		if err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		var t *template.Template
+		if o.templates != nil {
+			t = o.templates.Lookup(name)
+		}
+		if t == nil {
+			return ctx.ThrowReferenceError("template %s is not defined", name)
+		}
+		var sb strings.Builder
+		if err := t.Execute(&sb, v); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.String(sb.String())
+	}))
+
+	return ctx.LoadHostModule("fmt", exports, "sprintf", "render", "execute")
+}
@@ -0,0 +1,67 @@
+package fmt_test
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/fmt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	templates := template.Must(template.New("header").Parse(`== {{.title}} ==`))
+	template.Must(templates.New("row").Parse(`{{.name}}: {{.qty}}`))
+	require.NoError(t, fmt.Install(ctx,
+		fmt.Templates(templates),
+		fmt.Funcs(template.FuncMap{"upper": strings.ToUpper}),
+	))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { sprintf, render, execute } from "fmt";
+		globalThis.result = {
+			sprintf: sprintf("%-6s|%6.2f|%x|%05d|%v|%q|%t", "total", 3.14159, 255, 42, [1, "a", null], "hi", true),
+			big: sprintf("%d %x", 2n ** 70n, new Uint8Array([1, 171])),
+			date: sprintf("%v", new Date(0)),
+			render: render('{{template "header" .}}{{range .items}}\n{{upper .name}} x{{.qty}}{{end}}', {
+				title: "Order",
+				items: [{ name: "tea", qty: 2 }, { name: "cake", qty: 1 }],
+				skipped: () => 1,
+			}),
+			map: render("{{len .}}", new Map([[1, "one"], [2, "two"]])),
+			execute: execute("row", { name: "tea", qty: 2 }),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"sprintf": "total |  3.14|ff|00042|[1 a <nil>]|\"hi\"|true",
+		"big": "1180591620717411303424 01ab",
+		"date": "1970-01-01 00:00:00 +0000 UTC",
+		"render": "== Order ==\nTEA x2\nCAKE x1",
+		"map": "2",
+		"execute": "tea: 2"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`render("{{.name", {})`:        "SyntaxError: template: render:1: unclosed action",
+		`render("{{.a.b}}", { a: 1 })`: `Error: template: render:1:4: executing "render" at <.a.b>: can't evaluate field b in type interface {}`,
+		`execute("footer", {})`:        "ReferenceError: template footer is not defined",
+		`sprintf()`:                    "TypeError: argument 0 is required",
+		`sprintf("%v", (() => { const a = []; a.push(a); return a; })())`: "TypeError: quickjs: circular reference in value tree",
+	} {
+		_, err := ctx.Eval(`import("fmt").then(({ sprintf, render, execute }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,80 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// Result is the outcome of a promise delivered by Value.ToChannel: its value if fulfilled, or the rejection reason
+// as Err.
+type Result struct {
+	Value Value
+	Err   error
+}
+
+const thenGlue = `(promise, fulfilled, rejected) => { Promise.resolve(promise).then(fulfilled, rejected); }`
+
+// Then calls fn when the promise settles, with its value if fulfilled or the rejection reason as err, on the goroutine
+// running the promise jobs of the context (Await, Loop, Serve or an Executor worker). The value is freed after fn
+// returns. A value that is not a promise is passed to fn at the next job. fn is not called if the context is closed
+// first.
+func (v Value) Then(fn func(ctx *Context, result Value, err error)) error {
+	ctx := v.ctx
+	fulfilled := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		fn(ctx, args[0], nil)
+		return ctx.Undefined()
+	})
+	defer fulfilled.Free()
+	rejected := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		fn(ctx, ctx.Undefined(), args[0].Error())
+		return ctx.Undefined()
+	})
+	defer rejected.Free()
+
+	glue, err := ctx.Eval(thenGlue, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer glue.Free()
+	ret, err := ctx.InvokeE(glue, ctx.Null(), v, fulfilled, rejected)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
+
+// ToChannel returns a channel receiving the outcome of the promise once it settles, for use in select statements or
+// from other goroutines. The promise only settles while the context runs its jobs, with Await, Loop, Serve or in an
+// Executor. The received value belongs to the context: it must only be used on the goroutine owning it, with Do for
+// instance, and freed there. If the context is closed first, the channel receives ErrContextClosed.
+func (v Value) ToChannel() <-chan Result {
+	ctx := v.ctx
+	ch := make(chan Result, 1)
+	if ctx.futures == nil {
+		ctx.futures = make(map[chan Result]struct{})
+		ctx.closeHooks = append(ctx.closeHooks, func() {
+			for ch := range ctx.futures {
+				ch <- Result{Value: ctx.Undefined(), Err: ErrContextClosed}
+			}
+			ctx.futures = nil
+		})
+	}
+	ctx.futures[ch] = struct{}{}
+
+	err := v.Then(func(ctx *Context, result Value, err error) {
+		if _, ok := ctx.futures[ch]; !ok {
+			return
+		}
+		delete(ctx.futures, ch)
+		if err == nil {
+			result = ctx.track(Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, result.ref)})
+		}
+		ch <- Result{Value: result, Err: err}
+	})
+	if err != nil {
+		delete(ctx.futures, ch)
+		ch <- Result{Value: ctx.Undefined(), Err: err}
+	}
+	return ch
+}
@@ -0,0 +1,87 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "time"
+
+// GCStats describes a garbage collection cycle of a runtime.
+type GCStats struct {
+	// Runs is the number of collections observed by the hooks so far, including this one. The collections started
+	// by the engine are undercounted (see SetGCHooks).
+	Runs uint64
+	// Forced reports whether the collection was requested with RunGC.
+	Forced bool
+	// MemoryBefore and MemoryAfter are the bytes allocated by the runtime before and after the collection.
+	MemoryBefore uint64
+	MemoryAfter  uint64
+	// Pause is the time the collection took.
+	Pause time.Duration
+}
+
+type gcHooks struct {
+	before, after func(stats GCStats)
+	runs          uint64
+	seen          uint64 // RuntimeStats.GCSentinelCollections when last checked
+}
+
+// SetGCHooks sets functions called before and after the garbage collections of the runtime; either may be nil.
+// Only the collections requested with RunGC are reported exactly, to both hooks. The engine has no notification for
+// the collections it starts by itself when allocating, so these events are approximate: they are detected afterwards,
+// when a sentinel object re-armed at the start of each evaluation has been freed, and passed to after only, at the
+// start of the next evaluation or RunGC, with Forced false, MemoryAfter the memory at that time, and no MemoryBefore
+// or Pause. Several collections may be reported as one event, and the collections while the sentinel is not armed
+// are missed. The hooks must not use the runtime.
+func (r Runtime) SetGCHooks(before, after func(stats GCStats)) {
+	if before == nil && after == nil {
+		r.state.gcHooks = nil
+		return
+	}
+	r.state.gcHooks = &gcHooks{before: before, after: after, seen: r.stats().GCSentinelCollections}
+}
+
+// reportGC passes the collections started by the engine since the last report to the after hook.
+func (r Runtime) reportGC() {
+	hooks := r.state.gcHooks
+	if hooks == nil {
+		return
+	}
+	seen := r.stats().GCSentinelCollections
+	if seen == hooks.seen {
+		return
+	}
+	hooks.runs += seen - hooks.seen
+	hooks.seen = seen
+	if hooks.after != nil {
+		hooks.after(GCStats{Runs: hooks.runs, MemoryAfter: uint64(r.memoryUsed())})
+	}
+}
+
+// runGC runs a collection requested with RunGC, calling the hooks around it.
+func (r Runtime) runGC() {
+	hooks := r.state.gcHooks
+	if hooks == nil {
+		r.collect()
+		return
+	}
+	r.reportGC()
+	hooks.runs++
+	stats := GCStats{Runs: hooks.runs, Forced: true, MemoryBefore: uint64(r.memoryUsed())}
+	if hooks.before != nil {
+		hooks.before(stats)
+	}
+	start := time.Now()
+	r.collect()
+	stats.Pause = time.Since(start)
+	stats.MemoryAfter = uint64(r.memoryUsed())
+	// The sentinel, if armed, detected this collection too.
+	hooks.seen = r.stats().GCSentinelCollections
+	if hooks.after != nil {
+		hooks.after(stats)
+	}
+}
+
+func (r Runtime) collect() {
+	C.JS_RunGC(r.ref)
+}
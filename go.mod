@@ -1,11 +1,32 @@
 module github.com/buke/quickjs-go
 
-go 1.20
+go 1.21
 
-require github.com/stretchr/testify v1.10.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/andybalholm/brotli v1.1.0
+	github.com/andybalholm/cascadia v1.3.2
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/evanw/esbuild v0.23.1
+	github.com/klauspost/compress v1.17.4
+	github.com/rivo/uniseg v0.4.7
+	github.com/stretchr/testify v1.10.0
+	go.opentelemetry.io/otel v1.21.0
+	go.opentelemetry.io/otel/sdk v1.21.0
+	go.opentelemetry.io/otel/trace v1.21.0
+	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.14.0
+	golang.org/x/text v0.16.0
+	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logr/logr v1.3.0 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	go.opentelemetry.io/otel/metric v1.21.0 // indirect
 )
@@ -0,0 +1,36 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "runtime/cgo"
+
+type goObject struct {
+	data      interface{}
+	finalizer func(data interface{})
+	class     *ClassBuilder // class of the instance, nil for objects of GoObject
+}
+
+// GoObject returns a new object holding a Go value, such as a file or a connection used by the methods set on the
+// object. If finalizer is not nil, it is called with data on the runtime's goroutine when the object is garbage
+// collected, at the latest when the runtime is closed. The object has no prototype.
+func (ctx *Context) GoObject(data interface{}, finalizer func(data interface{})) Value {
+	handle := cgo.NewHandle(&goObject{data: data, finalizer: finalizer})
+	val := Value{ctx: ctx, ref: C.NewGoObject(ctx.ref, C.uintptr_t(handle))}
+	if val.IsException() {
+		handle.Delete()
+		return val
+	}
+	return ctx.track(val)
+}
+
+// GoData returns the Go value held by an object created with GoObject, or backing an instance of a class created
+// with Class, including instances of subclasses defined in JS.
+func (v Value) GoData() (interface{}, bool) {
+	handle := C.GetGoObjectHandle(v.ref)
+	if handle == 0 {
+		return v.classData()
+	}
+	return cgo.Handle(handle).Value().(*goObject).data, true
+}
@@ -0,0 +1,139 @@
+// Package hash defines the "hash" host module of quickjs, computing digests and HMACs in Go.
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+
+	"github.com/buke/quickjs-go"
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+)
+
+// hashes are the algorithms of the hash module; the cryptographic ones can be used with HMAC.
+var hashes = map[string]struct {
+	new    func() hash.Hash
+	crypto bool
+}{
+	"md5":         {md5.New, true},
+	"sha1":        {sha1.New, true},
+	"sha256":      {sha256.New, true},
+	"sha384":      {sha512.New384, true},
+	"sha512":      {sha512.New, true},
+	"blake2b-256": {func() hash.Hash { h, _ := blake2b.New256(nil); return h }, true},
+	"blake2b-512": {func() hash.Hash { h, _ := blake2b.New512(nil); return h }, true},
+	"blake2s-256": {func() hash.Hash { h, _ := blake2s.New256(nil); return h }, true},
+	"crc32":       {func() hash.Hash { return crc32.NewIEEE() }, false},
+	"xxhash64":    {func() hash.Hash { return xxhash.New() }, false},
+}
+
+const hashPatch = `(newHash, update, sum) => {
+	const check = (name, data) => {
+		if (typeof data !== "string" && !(data instanceof ArrayBuffer) && !ArrayBuffer.isView(data)) {
+			throw new TypeError(name + " must be a string, an ArrayBuffer or an ArrayBuffer view");
+		}
+	};
+	class Hash {
+		#hash;
+		constructor(algorithm, key) {
+			this.#hash = newHash(String(algorithm), key);
+		}
+		update(data) {
+			check("data", data);
+			update(this.#hash, data);
+			return this;
+		}
+		digest(encoding = "hex") {
+			if (encoding !== "hex" && encoding !== "base64" && encoding !== "arrayBuffer") throw new TypeError("unknown encoding " + encoding);
+			return sum(this.#hash, encoding);
+		}
+	}
+	const createHash = (algorithm) => new Hash(algorithm);
+	const createHmac = (algorithm, key) => {
+		check("key", key);
+		return new Hash(algorithm, key);
+	};
+	return {
+		createHash,
+		createHmac,
+		digest: (algorithm, data, encoding) => createHash(algorithm).update(data).digest(encoding),
+		hmac: (algorithm, key, data, encoding) => createHmac(algorithm, key).update(data).digest(encoding),
+	};
+}`
+
+// Install defines the "hash" module of ctx, hashing data in Go, much faster than in JS:
+//
+//	import { digest, hmac, createHash } from "hash";
+//	const etag = digest("sha256", body);
+//	const signature = hmac("sha256", secret, payload, "base64");
+//	const h = createHash("xxhash64");
+//	for (const chunk of chunks) h.update(chunk);
+//	const sum = h.digest();
+//
+// The algorithms are md5, sha1, sha256, sha384, sha512, blake2b-256, blake2b-512, blake2s-256, and the
+// non-cryptographic crc32 and xxhash64, which cannot be used with HMAC. Data and keys are strings, hashed as UTF-8,
+// ArrayBuffers or ArrayBuffer views; digests are encoded as "hex", the default, "base64" or "arrayBuffer". The digests
+// of crc32 and xxhash64 are their values in big-endian order.
+func Install(ctx *quickjs.Context) error {
+	newHash := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		algorithm := args[0].String()
+		alg, ok := hashes[algorithm]
+		if !ok {
+			return ctx.ThrowTypeError("unknown algorithm %s", algorithm)
+		}
+		h := alg.new()
+		if !args[1].IsUndefined() {
+			if !alg.crypto {
+				return ctx.ThrowTypeError("%s cannot be used with HMAC", algorithm)
+			}
+			key, err := ctx.DataBytes(args[1])
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			h = hmac.New(alg.new, key)
+		}
+		return ctx.GoObject(h, nil)
+	})
+	defer newHash.Free()
+	update := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, err := ctx.DataBytes(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		h, _ := args[0].GoData()
+		h.(hash.Hash).Write(data)
+		return ctx.Undefined()
+	})
+	defer update.Free()
+	sum := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		h, _ := args[0].GoData()
+		b := h.(hash.Hash).Sum(nil)
+		switch args[1].String() {
+		case "base64":
+			return ctx.String(base64.StdEncoding.EncodeToString(b))
+		case "arrayBuffer":
+			return ctx.ArrayBuffer(b)
+		}
+		return ctx.String(hex.EncodeToString(b))
+	})
+	defer sum.Free()
+
+	patch, err := ctx.Eval(hashPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), newHash, update, sum)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("hash", exports, "digest", "hmac", "createHash", "createHmac")
+}
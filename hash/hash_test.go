@@ -0,0 +1,64 @@
+package hash_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/hash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, hash.Install(ctx))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { digest, hmac, createHash } from "hash";
+		const h = createHash("sha256");
+		for (const chunk of ["a", new Uint8Array([98]), new Uint8Array([99]).buffer]) h.update(chunk);
+		globalThis.result = {
+			sha256: digest("sha256", "abc"),
+			chunked: h.digest(),
+			md5: digest("md5", "abc"),
+			blake2b: digest("blake2b-256", "abc"),
+			crc32: digest("crc32", "abc"),
+			xxhash: digest("xxhash64", "abc"),
+			base64: digest("sha1", "abc", "base64"),
+			bytes: new Uint8Array(digest("crc32", "abc", "arrayBuffer")).length,
+			hmac: hmac("sha256", "key", "The quick brown fox jumps over the lazy dog"),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"chunked": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"md5": "900150983cd24fb0d6963f7d28e17f72",
+		"blake2b": "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		"crc32": "352441c2",
+		"xxhash": "44bc2cf5ad770999",
+		"base64": "qZk+NkcGgWq6PiVxeFDCbJzQ2J0=",
+		"bytes": 4,
+		"hmac": "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`hash.digest("sha3", "abc")`:       "TypeError: unknown algorithm sha3",
+		`hash.hmac("crc32", "k", "abc")`:   "TypeError: crc32 cannot be used with HMAC",
+		`hash.digest("md5", 42)`:           "TypeError: data must be a string, an ArrayBuffer or an ArrayBuffer view",
+		`hash.digest("md5", "a", "utf8")`:  "TypeError: unknown encoding utf8",
+		`hash.hmac("sha256", null, "abc")`: "TypeError: key must be a string, an ArrayBuffer or an ArrayBuffer view",
+	} {
+		_, err := ctx.Eval(`import("hash").then((hash) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
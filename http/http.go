@@ -0,0 +1,208 @@
+// Package http defines the "http" host module of quickjs, a small HTTP client whose requests are all checked by Go.
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/network"
+)
+
+// ErrHostNotAllowed is thrown by the functions of the http module for URLs whose host is not allowed by AllowHosts or
+// NetworkPolicy.
+var ErrHostNotAllowed = network.ErrHostNotAllowed
+
+// Options configures the http module defined by Install.
+type Options struct {
+	hosts       []string
+	policy      network.Policy
+	maxBodySize int64
+	timeout     time.Duration
+	client      *http.Client
+}
+
+// Option configures the http module defined by Install.
+type Option func(*Options)
+
+// AllowHosts allows requests to the given hosts; by default no host is allowed. A host is a name, such as
+// api.example.com, a name with a port, such as localhost:8080, or a wildcard, such as *.example.com, matching the
+// subdomains of a name.
+func AllowHosts(hosts ...string) Option {
+	return func(o *Options) {
+		for _, host := range hosts {
+			o.hosts = append(o.hosts, strings.ToLower(host))
+		}
+	}
+}
+
+// NetworkPolicy sends the requests through policy, which decides the hosts requests are allowed to, including
+// redirects, and opens the connections. It replaces the hosts allowed by AllowHosts.
+func NetworkPolicy(policy network.Policy) Option {
+	return func(o *Options) {
+		o.policy = policy
+	}
+}
+
+// MaxBodySize limits the size of the response bodies read by scripts; it is 10 MiB by default.
+func MaxBodySize(n int64) Option {
+	return func(o *Options) {
+		o.maxBodySize = n
+	}
+}
+
+// Timeout cancels a request, including the reading of its response, running longer than d; it is 30 seconds by default.
+func Timeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = d
+	}
+}
+
+// Client sets the client sending the requests, for its transport or its cookies; it is http.DefaultClient by default.
+// Redirects are still checked against the allowed hosts. If its transport is an *http.Transport, it is copied to dial
+// through the network policy, without proxy; otherwise the policy only checks the URLs.
+func Client(client *http.Client) Option {
+	return func(o *Options) {
+		o.client = client
+	}
+}
+
+const httpPatch = `(request) => {
+	const send = (method, url, body, { headers = {} } = {}) => {
+		headers = Object.entries(headers).map(([name, value]) => [name, String(value)]);
+		if (body !== undefined && body !== null && typeof body !== "string" && !(body instanceof ArrayBuffer) && !ArrayBuffer.isView(body)) {
+			body = JSON.stringify(body);
+			if (!headers.some(([name]) => name.toLowerCase() === "content-type")) headers.push(["Content-Type", "application/json"]);
+		}
+		const response = request(method, String(url), headers, body ?? undefined);
+		response.ok = response.status >= 200 && response.status < 300;
+		response.json = () => JSON.parse(response.body);
+		return response;
+	};
+	return {
+		get: (url, options) => send("GET", url, undefined, options),
+		post: (url, body, options) => send("POST", url, body, options),
+		request: ({ method = "GET", url, body, headers } = {}) => send(String(method).toUpperCase(), url, body, { headers }),
+	};
+}`
+
+// Install defines the "http" module of ctx, a small HTTP client for embedders who want to control every request rather
+// than offer the semantics of fetch:
+//
+//	import * as http from "http";
+//	const rates = http.get("https://api.example.com/rates", { headers: { Accept: "application/json" } }).json();
+//	http.post("https://api.example.com/orders", { id: 42 });
+//
+// get(url, {headers}), post(url, body, {headers}) and request({method, url, body, headers}) send a request and return
+// the response, an object with url, status, ok, headers, keyed by lower-case names, body, the text of the body, and
+// json(). A body is a string, an ArrayBuffer or an ArrayBuffer view; other values are sent as JSON. Requests are
+// synchronous and run in Go: hosts not allowed by AllowHosts or NetworkPolicy throw ErrHostNotAllowed, responses larger
+// than MaxBodySize throw a RangeError and requests longer than Timeout are canceled.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{maxBodySize: 10 << 20, timeout: 30 * time.Second, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.policy == nil {
+		o.policy = network.NewPolicy(network.AllowHosts(o.hosts...))
+	}
+	client := *o.client
+	transport, _ := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	if transport != nil {
+		transport = transport.Clone()
+		transport.Proxy = nil
+		transport.DialContext = o.policy.DialContext
+		client.Transport = transport
+		ctx.OnClose(transport.CloseIdleConnections)
+	}
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := o.policy.AllowURL(req.URL); err != nil {
+			return err
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	request := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		u, err := url.Parse(args[1].String())
+		if err != nil {
+			return ctx.ThrowTypeError("invalid URL %s", args[1].String())
+		}
+		if err := o.policy.AllowURL(u); err != nil {
+			return ctx.ThrowError(err)
+		}
+		var body io.Reader
+		if !args[3].IsUndefined() {
+			b, err := ctx.DataBytes(args[3])
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			body = bytes.NewReader(b)
+		}
+
+		reqCtx, cancel := context.WithTimeout(context.Background(), o.timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(reqCtx, args[0].String(), u.String(), body)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		for i, n := int64(0), args[2].Len(); i < n; i++ {
+			header := args[2].GetIdx(i)
+			name, value := header.GetIdx(0), header.GetIdx(1)
+			req.Header.Add(name.String(), value.String())
+			name.Free()
+			value.Free()
+			header.Free()
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(io.LimitReader(resp.Body, o.maxBodySize+1))
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if int64(len(b)) > o.maxBodySize {
+			return ctx.ThrowRangeError("response body larger than %d bytes", o.maxBodySize)
+		}
+
+		ret := ctx.Object()
+		ret.Set("url", ctx.String(resp.Request.URL.String()))
+		ret.Set("status", ctx.Int32(int32(resp.StatusCode)))
+		headers := ctx.Object()
+		for name, values := range resp.Header {
+			headers.Set(strings.ToLower(name), ctx.String(strings.Join(values, ", ")))
+		}
+		ret.Set("headers", headers)
+		ret.Set("body", ctx.String(string(b)))
+		return ret
+	})
+	defer request.Free()
+
+	patch, err := ctx.Eval(httpPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), request)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("http", exports, "get", "post", "request")
+}
@@ -0,0 +1,89 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjshttp "github.com/buke/quickjs-go/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"method": r.Method, "type": r.Header.Get("Content-Type"), "token": r.Header.Get("X-Token"), "body": string(body),
+			})
+		case "/large":
+			w.Write(bytes.Repeat([]byte("x"), 300))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, quickjshttp.Install(ctx, quickjshttp.AllowHosts(host), quickjshttp.MaxBodySize(200),
+		quickjshttp.Timeout(100*time.Millisecond)))
+	ctx.Globals().Set("base", ctx.String(srv.URL))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import * as http from "http";
+		const get = http.get(base + "/echo", { headers: { "X-Token": 42 } });
+		const post = http.post(base + "/echo", { id: 1 });
+		const put = http.request({ method: "put", url: base + "/echo", body: new Uint8Array([104, 105]) });
+		const missing = http.get(base + "/missing");
+		globalThis.result = {
+			get: get.json(),
+			getType: get.headers["content-type"],
+			post: post.json(),
+			put: put.json().body,
+			missing: [missing.status, missing.ok],
+			ok: get.ok,
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"get": {"method": "GET", "type": "", "token": "42", "body": ""},
+		"getType": "application/json",
+		"post": {"method": "POST", "type": "application/json", "token": "", "body": "{\"id\":1}"},
+		"put": "hi",
+		"missing": [404, false],
+		"ok": true
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`http.get("http://example.com/")`:   "Error: network: host not allowed: example.com",
+		`http.get("file:///etc/passwd")`:    "Error: network: host not allowed: ",
+		`http.get(base + "/large")`:         "RangeError: response body larger than 200 bytes",
+		`http.get(base + "/redirect").body`: "network: host not allowed: example.com",
+		`http.get(base + "/slow")`:          "context deadline exceeded",
+	} {
+		_, err := ctx.Eval(`import("http").then((http) => `+src+`)`, quickjs.EvalAwait(true))
+		require.ErrorContains(t, err, want, src)
+	}
+}
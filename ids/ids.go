@@ -0,0 +1,156 @@
+// Package ids defines the "ids" host module of quickjs, generating UUIDs, ULIDs and nanoids in Go.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	mathrand "math/rand"
+	"time"
+	"unicode/utf8"
+
+	"github.com/buke/quickjs-go"
+)
+
+// Options configures the ids module defined by Install.
+type Options struct {
+	rand io.Reader
+	now  func() time.Time
+}
+
+// Option configures the ids module defined by Install.
+type Option func(*Options)
+
+// Seed generates the random bits of the identifiers from a pseudo-random generator seeded with seed instead of the
+// CSPRNG of Go, so that tests get the same identifiers on every run. With Clock, the time-ordered identifiers are
+// deterministic too. It must not be used in production.
+func Seed(seed int64) Option {
+	return func(o *Options) {
+		o.rand = mathrand.New(mathrand.NewSource(seed))
+	}
+}
+
+// Clock sets the clock giving the timestamps of UUIDv7s and ULIDs; it is time.Now by default.
+func Clock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.now = now
+	}
+}
+
+// nanoidAlphabet is the default alphabet of nanoids, safe in URLs.
+const nanoidAlphabet = "useandom-26T198340PX75pxJACKVERYMINDBUSHWOLF_GQZbfghjklqvwyzrict"
+
+// crockford is the base 32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// formatUUID formats 16 bytes as a UUID with the given version and the RFC 9562 variant.
+func formatUUID(b []byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b)
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// Install defines the "ids" module of ctx, generating identifiers from the CSPRNG of Go:
+//
+//	import { uuid, uuidv7, ulid, nanoid } from "ids";
+//	const id = uuidv7(); // "0190a6d2-1f3c-7b4e-9a1d-5c2e8f0b7a61"
+//
+// uuid() returns a random UUID (version 4), uuidv7() a time-ordered UUID (version 7), ulid() a ULID and nanoid(size,
+// alphabet) a nanoid of size characters, 21 by default, from alphabet, URL-safe by default. Use Seed and Clock for
+// deterministic identifiers in tests.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{rand: rand.Reader, now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	random := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(o.rand, b)
+		return b, err
+	}
+	// timed returns 16 random bytes starting with the 48-bit Unix time in milliseconds.
+	timed := func() ([]byte, error) {
+		b, err := random(16)
+		if err != nil {
+			return nil, err
+		}
+		ms := uint64(o.now().UnixMilli())
+		for i := 0; i < 6; i++ {
+			b[i] = byte(ms >> (40 - 8*i))
+		}
+		return b, nil
+	}
+
+	exports := ctx.Object()
+	exports.Set("uuid", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		b, err := random(16)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.String(formatUUID(b, 4))
+	}))
+	exports.Set("uuidv7", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		b, err := timed()
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.String(formatUUID(b, 7))
+	}))
+	exports.Set("ulid", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		b, err := timed()
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		// 128 bits in 26 characters of 5 bits, the first one holding 3 bits.
+		var s [26]byte
+		var acc uint32
+		bits := 2 // zero bits padding the start
+		i := 0
+		for _, c := range b {
+			acc = acc<<8 | uint32(c)
+			bits += 8
+			for bits >= 5 {
+				bits -= 5
+				s[i] = crockford[acc>>bits&31]
+				i++
+			}
+		}
+		return ctx.String(string(s[:]))
+	}))
+	exports.Set("nanoid", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		size, alphabet := int(args.Int32(0, 21)), []rune(args.String(1, nanoidAlphabet))
+		if args.Err() != nil {
+			return ctx.Undefined()
+		}
+		if size < 1 {
+			return ctx.ThrowRangeError("size must be positive")
+		}
+		if len(alphabet) < 2 || len(alphabet) > 256 {
+			return ctx.ThrowRangeError("alphabet must have between 2 and 256 characters")
+		}
+		// Random bytes are masked to the smallest power of two covering the alphabet, rejecting the values beyond it
+		// so that every character is equally likely.
+		mask := 1
+		for mask < len(alphabet) {
+			mask <<= 1
+		}
+		mask--
+		id := make([]byte, 0, size*utf8.UTFMax)
+		for n := 0; n < size; {
+			b, err := random(size)
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			for _, c := range b {
+				if i := int(c) & mask; i < len(alphabet) && n < size {
+					id = utf8.AppendRune(id, alphabet[i])
+					n++
+				}
+			}
+		}
+		return ctx.String(string(id))
+	}))
+
+	return ctx.LoadHostModule("ids", exports, "uuid", "uuidv7", "ulid", "nanoid")
+}
@@ -0,0 +1,59 @@
+package ids_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/ids"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	generate := func(opts ...ids.Option) string {
+		rt := quickjs.NewRuntime()
+		defer rt.Close()
+		ctx := rt.NewContext()
+		defer ctx.Close()
+		require.NoError(t, ids.Install(ctx, opts...))
+		ret, err := ctx.Eval(`import("ids").then(({ uuid, uuidv7, ulid, nanoid }) => JSON.stringify({
+			uuid: uuid(), uuidv7: uuidv7(), ulid: ulid(), nanoid: nanoid(), short: nanoid(8, "ab"),
+		}))`, quickjs.EvalAwait(true))
+		require.NoError(t, err)
+		defer ret.Free()
+		return ret.String()
+	}
+
+	var got struct{ UUID, UUIDv7, ULID, Nanoid, Short string }
+	require.NoError(t, json.Unmarshal([]byte(generate()), &got))
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, got.UUID)
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, got.UUIDv7)
+	require.Regexp(t, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, got.ULID)
+	require.Regexp(t, `^[A-Za-z0-9_-]{21}$`, got.Nanoid)
+	require.Regexp(t, `^[ab]{8}$`, got.Short)
+	require.NotEqual(t, generate(), generate())
+
+	clock := ids.Clock(func() time.Time { return time.UnixMilli(1704067200000) })
+	seeded := generate(ids.Seed(42), clock)
+	require.Equal(t, seeded, generate(ids.Seed(42), clock))
+	require.NotEqual(t, seeded, generate(ids.Seed(43), clock))
+	require.NoError(t, json.Unmarshal([]byte(seeded), &got))
+	require.True(t, strings.HasPrefix(got.UUIDv7, "018cc251-f400-7"), got.UUIDv7)
+	require.True(t, strings.HasPrefix(got.ULID, "01HK153X00"), got.ULID)
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, ids.Install(ctx))
+	for src, want := range map[string]string{
+		`nanoid(0)`:      "RangeError: size must be positive",
+		`nanoid(5, "a")`: "RangeError: alphabet must have between 2 and 256 characters",
+		`nanoid("5")`:    "TypeError: argument 0 must be an integer",
+	} {
+		_, err := ctx.Eval(`import("ids").then(({ nanoid }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
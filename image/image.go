@@ -0,0 +1,312 @@
+// Package image defines the "image" host module of quickjs, decoding, resizing and encoding images in Go.
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/buke/quickjs-go"
+	_ "golang.org/x/image/bmp" // registers the BMP decoder
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+)
+
+// Options configures the image module defined by Install.
+type Options struct {
+	maxPixels int
+}
+
+// Option configures the image module defined by Install.
+type Option func(*Options)
+
+// MaxPixels limits the width times the height of the images decoded and created, so that a small file can't exhaust the
+// memory; it is 40 million pixels by default.
+func MaxPixels(n int) Option {
+	return func(o *Options) {
+		o.maxPixels = n
+	}
+}
+
+// errImageSize marks the errors of image sizes, thrown as RangeErrors.
+var errImageSize = errors.New("image size")
+
+// imageDecodeError is an error of a decoder, thrown as an Error rather than as a TypeError.
+type imageDecodeError struct {
+	error
+}
+
+// imageFilters are the interpolations of the image module.
+var imageFilters = map[string]draw.Interpolator{
+	"nearest":    draw.NearestNeighbor,
+	"bilinear":   draw.BiLinear,
+	"catmullRom": draw.CatmullRom,
+}
+
+// encodeImage encodes img to format, with quality for JPEG.
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// fitImage returns the size of the image a source of sw×sh is resized to, and the part of the source it shows, for a
+// width and a height, either of which may be 0 to keep the aspect ratio, and a fit.
+func fitImage(sw, sh, width, height int, fit string) (dw, dh int, sr image.Rectangle, err error) {
+	sr = image.Rect(0, 0, sw, sh)
+	scale := func(n int, f float64) int {
+		return int(math.Max(1, math.Round(float64(n)*f)))
+	}
+	switch {
+	case width <= 0 && height <= 0:
+		return 0, 0, sr, fmt.Errorf("width or height must be positive")
+	case height <= 0:
+		return width, scale(sh, float64(width)/float64(sw)), sr, nil
+	case width <= 0:
+		return scale(sw, float64(height)/float64(sh)), height, sr, nil
+	}
+	fx, fy := float64(width)/float64(sw), float64(height)/float64(sh)
+	switch fit {
+	case "fill":
+		return width, height, sr, nil
+	case "contain":
+		f := math.Min(fx, fy)
+		return scale(sw, f), scale(sh, f), sr, nil
+	case "cover":
+		f := math.Max(fx, fy)
+		cw, ch := scale(width, 1/f), scale(height, 1/f)
+		x, y := (sw-cw)/2, (sh-ch)/2
+		return width, height, image.Rect(x, y, x+cw, y+ch), nil
+	}
+	return 0, 0, sr, fmt.Errorf("unknown fit %s", fit)
+}
+
+const imagePatch = `(info, decode, encode, resizePixels, resizeData) => {
+	const data = (v) => {
+		if (!(v instanceof ArrayBuffer) && !ArrayBuffer.isView(v)) throw new TypeError("data must be an ArrayBuffer or an ArrayBuffer view");
+		return v;
+	};
+	const pixels = (img) => {
+		if (img === null || typeof img !== "object" || !("width" in img) || !("height" in img)) {
+			throw new TypeError("image must be an object with width, height and data");
+		}
+		return [Number(img.width), Number(img.height), data(img.data)];
+	};
+	const image = ([width, height, buffer]) => ({ width, height, data: new Uint8ClampedArray(buffer) });
+	return {
+		info: (input) => info(data(input)),
+		decode(input) {
+			const [format, ...rest] = decode(data(input));
+			return { format, ...image(rest) };
+		},
+		encode: (img, format = "png", { quality = 75 } = {}) => encode(...pixels(img), String(format), Number(quality)),
+		resize(input, { width = 0, height = 0, fit = "contain", filter = "catmullRom", format = "", quality = 75 } = {}) {
+			const opts = [Number(width), Number(height), String(fit), String(filter)];
+			if (input instanceof ArrayBuffer || ArrayBuffer.isView(input)) {
+				return resizeData(input, ...opts, String(format), Number(quality));
+			}
+			return image(resizePixels(...pixels(input), ...opts));
+		},
+	};
+}`
+
+// Install defines the "image" module of ctx, decoding, resizing and encoding images in Go, so that thumbnailing scripts
+// need no codecs of their own:
+//
+//	import { resize } from "image";
+//	const thumb = resize(upload, { width: 128, height: 128, fit: "cover", format: "jpeg", quality: 80 });
+//
+// info(data) returns the format, width and height of an encoded image without decoding it. decode(data) returns the
+// pixels of an image as {format, width, height, data}, data being a Uint8ClampedArray of non-premultiplied RGBA like
+// ImageData. encode(image, format, {quality}) encodes such pixels, returning an ArrayBuffer. The formats read are PNG,
+// JPEG, GIF (its first frame), BMP and WebP; the formats written are "png", "jpeg" and "gif".
+//
+// resize(input, {width, height, fit, filter, format, quality}) resizes pixels, returning pixels, or an encoded image,
+// returning it encoded in format, its own one by default or PNG if it can't be written. With only a width or a height,
+// the aspect ratio is kept; with both, fit is "contain" (the default, fitting the image in the box), "cover" (filling
+// the box, cropping the center of the image) or "fill" (stretching it). The filter is "nearest", "bilinear" or
+// "catmullRom", the default.
+//
+// Images larger than MaxPixels throw a RangeError, checked before decoding.
+func Install(ctx *quickjs.Context, opts ...Option) error {
+	o := Options{maxPixels: 40_000_000}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	checkSize := func(width, height int) error {
+		if width <= 0 || height <= 0 {
+			return fmt.Errorf("%w %dx%d must be positive", errImageSize, width, height)
+		}
+		if width*height > o.maxPixels || width*height/height != width {
+			return fmt.Errorf("%w %dx%d is larger than %d pixels", errImageSize, width, height, o.maxPixels)
+		}
+		return nil
+	}
+	decodeData := func(v quickjs.Value) (image.Image, string, error) {
+		b, err := ctx.DataBytes(v)
+		if err != nil {
+			return nil, "", err
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, "", imageDecodeError{err}
+		}
+		if err := checkSize(cfg.Width, cfg.Height); err != nil {
+			return nil, "", err
+		}
+		img, _, err := image.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, "", imageDecodeError{err}
+		}
+		return img, format, nil
+	}
+	pixels := func(args []quickjs.Value) (*image.NRGBA, error) {
+		width, height := int(args[0].Int32()), int(args[1].Int32())
+		if err := checkSize(width, height); err != nil {
+			return nil, err
+		}
+		b, err := ctx.DataBytes(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != width*height*4 {
+			return nil, fmt.Errorf("image data of %d bytes must have %d bytes", len(b), width*height*4)
+		}
+		return &image.NRGBA{Pix: b, Stride: width * 4, Rect: image.Rect(0, 0, width, height)}, nil
+	}
+	toNRGBA := func(img image.Image) *image.NRGBA {
+		if img, ok := img.(*image.NRGBA); ok && img.Rect.Min == (image.Point{}) {
+			return img
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+		return dst
+	}
+	// pixelsValue returns the array of the size and the pixels of img, after the values of head.
+	pixelsValue := func(img *image.NRGBA, head ...quickjs.Value) quickjs.Value {
+		items := append(head, ctx.Int32(int32(img.Rect.Dx())), ctx.Int32(int32(img.Rect.Dy())), ctx.ArrayBuffer(img.Pix))
+		return quickjs.NewTreeBuilder(ctx).Array(items)
+	}
+	resize := func(img image.Image, args []quickjs.Value) (*image.NRGBA, error) {
+		bounds := img.Bounds()
+		dw, dh, sr, err := fitImage(bounds.Dx(), bounds.Dy(), int(args[0].Int32()), int(args[1].Int32()), args[2].String())
+		if err != nil {
+			return nil, err
+		}
+		filter, ok := imageFilters[args[3].String()]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %s", args[3].String())
+		}
+		if err := checkSize(dw, dh); err != nil {
+			return nil, err
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+		filter.Scale(dst, dst.Rect, img, sr.Add(bounds.Min), draw.Src, nil)
+		return dst, nil
+	}
+	encode := func(img image.Image, format string, quality int) quickjs.Value {
+		if quality < 1 || quality > 100 {
+			return ctx.ThrowRangeError("quality must be between 1 and 100")
+		}
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, format, quality); err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		return ctx.ArrayBuffer(buf.Bytes())
+	}
+	throw := func(err error) quickjs.Value {
+		var derr imageDecodeError
+		switch {
+		case errors.Is(err, errImageSize):
+			return ctx.ThrowRangeError("%s", err)
+		case errors.As(err, &derr):
+			return ctx.ThrowError(derr.error)
+		}
+		return ctx.ThrowTypeError("%s", err)
+	}
+
+	infoFn := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		b, err := ctx.DataBytes(args[0])
+		if err != nil {
+			return throw(err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		ret := ctx.Object()
+		ret.Set("format", ctx.String(format))
+		ret.Set("width", ctx.Int32(int32(cfg.Width)))
+		ret.Set("height", ctx.Int32(int32(cfg.Height)))
+		return ret
+	})
+	defer infoFn.Free()
+	decodeFn := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		img, format, err := decodeData(args[0])
+		if err != nil {
+			return throw(err)
+		}
+		return pixelsValue(toNRGBA(img), ctx.String(format))
+	})
+	defer decodeFn.Free()
+	encodeFn := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		img, err := pixels(args)
+		if err != nil {
+			return throw(err)
+		}
+		return encode(img, args[3].String(), int(args[4].Int32()))
+	})
+	defer encodeFn.Free()
+	resizePixelsFn := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		img, err := pixels(args)
+		if err != nil {
+			return throw(err)
+		}
+		dst, err := resize(img, args[3:])
+		if err != nil {
+			return throw(err)
+		}
+		return pixelsValue(dst)
+	})
+	defer resizePixelsFn.Free()
+	resizeDataFn := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		img, format, err := decodeData(args[0])
+		if err != nil {
+			return throw(err)
+		}
+		dst, err := resize(img, args[1:])
+		if err != nil {
+			return throw(err)
+		}
+		if f := args[5].String(); f != "" {
+			format = f
+		} else if format != "jpeg" && format != "gif" {
+			format = "png"
+		}
+		return encode(dst, format, int(args[6].Int32()))
+	})
+	defer resizeDataFn.Free()
+
+	patch, err := ctx.Eval(imagePatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), infoFn, decodeFn, encodeFn, resizePixelsFn, resizeDataFn)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("image", exports, "info", "decode", "encode", "resize")
+}
@@ -0,0 +1,87 @@
+package image_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjsimage "github.com/buke/quickjs-go/image"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, quickjsimage.Install(ctx, quickjsimage.MaxPixels(64)))
+
+	// 4×2 pixels: red, green, blue and transparent white on top, black at the bottom.
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x, c := range []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 0}} {
+		img.SetNRGBA(x, 0, c)
+		img.SetNRGBA(x, 1, color.NRGBA{0, 0, 0, 255})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	ctx.Globals().Set("photo", ctx.ArrayBuffer(buf.Bytes()))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { info, decode, encode, resize } from "image";
+		const pixels = decode(new Uint8Array(photo));
+		const half = resize(pixels, { width: 2, filter: "nearest" });
+		const thumb = resize(photo, { height: 1, format: "jpeg", quality: 90 });
+		const cover = decode(resize(photo, { width: 2, height: 2, fit: "cover", filter: "nearest" }));
+		const contain = info(resize(photo, { width: 2, height: 2 }));
+		globalThis.result = {
+			info: info(photo),
+			format: pixels.format,
+			size: [pixels.width, pixels.height],
+			type: Object.prototype.toString.call(pixels.data),
+			top: [...pixels.data.subarray(0, 16)],
+			half: { width: half.width, height: half.height, data: [...half.data] },
+			thumb: info(thumb),
+			cover: [cover.format, ...cover.data.subarray(0, 8)],
+			contain,
+			encoded: info(encode({ width: 1, height: 1, data: new Uint8ClampedArray([1, 2, 3, 4]) }, "gif")),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"info": {"format": "png", "width": 4, "height": 2},
+		"format": "png",
+		"size": [4, 2],
+		"type": "[object Uint8ClampedArray]",
+		"top": [255, 0, 0, 255, 0, 255, 0, 255, 0, 0, 255, 255, 255, 255, 255, 0],
+		"half": {"width": 2, "height": 1, "data": [0, 0, 0, 255, 0, 0, 0, 255]},
+		"thumb": {"format": "jpeg", "width": 2, "height": 1},
+		"cover": ["png", 0, 255, 0, 255, 0, 0, 255, 255],
+		"contain": {"format": "png", "width": 2, "height": 1},
+		"encoded": {"format": "gif", "width": 1, "height": 1}
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`resize(photo, { width: 10, height: 10, fit: "fill" })`:                            "RangeError: image size 10x10 is larger than 64 pixels",
+		`decode(new ArrayBuffer(8))`:                                                       "Error: image: unknown format",
+		`decode("photo")`:                                                                  "TypeError: data must be an ArrayBuffer or an ArrayBuffer view",
+		`resize(photo, {})`:                                                                "TypeError: width or height must be positive",
+		`resize(photo, { width: 1, filter: "lanczos" })`:                                   "TypeError: unknown filter lanczos",
+		`resize(photo, { width: 1, height: 1, fit: "crop" })`:                              "TypeError: unknown fit crop",
+		`encode({ width: 2, height: 2, data: new Uint8Array(4) })`:                         "TypeError: image data of 4 bytes must have 16 bytes",
+		`encode({ width: 1, height: 1, data: new Uint8Array(4) }, "avif")`:                 "TypeError: unknown format avif",
+		`encode({ width: 1, height: 1, data: new Uint8Array(4) }, "jpeg", { quality: 0 })`: "RangeError: quality must be between 1 and 100",
+	} {
+		_, err := ctx.Eval(`import("image").then(({ info, decode, encode, resize }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,337 @@
+package quickjs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type InspectOptions struct {
+	depth       int
+	colors      bool
+	breakLength int
+	maxItems    int
+}
+
+type InspectOption func(*InspectOptions)
+
+// InspectDepth sets how many levels of nested objects are shown before they are abbreviated as [Object], 2 by
+// default; a negative depth shows all levels.
+func InspectDepth(depth int) InspectOption {
+	return func(opts *InspectOptions) {
+		opts.depth = depth
+	}
+}
+
+// InspectColors styles the output with ANSI color codes, as for a terminal.
+func InspectColors(colors bool) InspectOption {
+	return func(opts *InspectOptions) {
+		opts.colors = colors
+	}
+}
+
+// InspectBreakLength sets the line length above which the entries of an object are put on separate lines, 72 by
+// default.
+func InspectBreakLength(length int) InspectOption {
+	return func(opts *InspectOptions) {
+		opts.breakLength = length
+	}
+}
+
+// InspectMaxItems sets the number of array, map and set entries shown before the rest is summarized, 100 by default;
+// a negative number shows all entries.
+func InspectMaxItems(n int) InspectOption {
+	return func(opts *InspectOptions) {
+		opts.maxItems = n
+	}
+}
+
+// ANSI styles of the rendered values, as used by Node.
+const (
+	styleNumber    = "33" // yellow: numbers, bigints and booleans
+	styleString    = "32" // green: strings and symbols
+	styleNull      = "1"  // bold
+	styleUndefined = "90" // grey
+	styleSpecial   = "36" // cyan: functions, getters and [Circular]
+	styleDate      = "35" // magenta
+	styleRegExp    = "31" // red
+)
+
+var inspectIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// Inspect returns a human readable rendering of the value in the style of Node's util.inspect: strings are quoted,
+// objects, arrays, maps, sets and typed arrays show their contents down to a limited depth, circular references are
+// marked as [Circular] and accessors are shown as [Getter] and [Setter] without being called.
+func (v Value) Inspect(opts ...InspectOption) string {
+	i := inspector{ctx: v.ctx, opts: InspectOptions{depth: 2, breakLength: 72, maxItems: 100}}
+	for _, opt := range opts {
+		opt(&i.opts)
+	}
+	return i.inspect(v, 0)
+}
+
+type inspector struct {
+	ctx  *Context
+	opts InspectOptions
+	seen []Value
+}
+
+func (i *inspector) style(s, style string) string {
+	if !i.opts.colors {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+func (i *inspector) inspect(v Value, depth int) string {
+	kind := v.Kind()
+	switch kind {
+	case KindUndefined:
+		return i.style("undefined", styleUndefined)
+	case KindNull:
+		return i.style("null", styleNull)
+	case KindBool, KindNumber, KindBigFloat, KindBigDecimal:
+		return i.style(v.String(), styleNumber)
+	case KindBigInt:
+		return i.style(v.String()+"n", styleNumber)
+	case KindString:
+		return i.style(inspectQuote(v.String()), styleString)
+	case KindSymbol:
+		return i.style(i.callString(i.ctx.Globals(), "String", v), styleString)
+	case KindFunction:
+		return i.style(i.inspectFunction(v), styleSpecial)
+	case KindError:
+		if stack := i.getString(v, "stack"); stack != "" {
+			return v.String() + "\n" + strings.TrimRight(stack, "\n")
+		}
+		return v.String()
+	case KindDate:
+		return i.style(i.callString(v, "toISOString"), styleDate)
+	case KindRegExp:
+		return i.style(v.String(), styleRegExp)
+	case KindModule:
+		return "[Module]"
+	}
+
+	for _, s := range i.seen {
+		if s.StrictEquals(v) {
+			return i.style("[Circular]", styleSpecial)
+		}
+	}
+	i.seen = append(i.seen, v)
+	defer func() { i.seen = i.seen[:len(i.seen)-1] }()
+
+	name := i.constructorName(v)
+	nested := i.opts.depth >= 0 && depth > i.opts.depth
+	switch kind {
+	case KindArray:
+		if nested {
+			return i.style("[Array]", styleSpecial)
+		}
+		return i.join("[", i.inspectItems(v, v.Len(), depth), "]")
+	case KindTypedArray:
+		n := i.getInt(v, "length")
+		if nested {
+			return i.style("["+name+"]", styleSpecial)
+		}
+		return fmt.Sprintf("%s(%d) ", name, n) + i.join("[", i.inspectItems(v, n, depth), "]")
+	case KindArrayBuffer:
+		return name + " { byteLength: " + i.style(strconv.FormatInt(v.ByteLen(), 10), styleNumber) + " }"
+	case KindPromise:
+		return i.inspectPromise(v, depth)
+	case KindMap, KindSet:
+		if nested {
+			return i.style("["+kind.String()+"]", styleSpecial)
+		}
+		return i.inspectCollection(v, kind, depth)
+	}
+
+	if nested {
+		if name == "" {
+			name = "Object"
+		}
+		return i.style("["+name+"]", styleSpecial)
+	}
+	prefix := ""
+	if name != "Object" {
+		if name == "" {
+			name = "[Object: null prototype]"
+		}
+		prefix = name + " "
+	}
+	return prefix + i.join("{", i.inspectEntries(v, depth), "}")
+}
+
+func (i *inspector) inspectFunction(v Value) string {
+	name := i.getString(v, "name")
+	class := strings.HasPrefix(i.callString(v, "toString"), "class")
+	switch {
+	case class && name != "":
+		return "[class " + name + "]"
+	case class:
+		return "[class (anonymous)]"
+	case name != "":
+		return "[Function: " + name + "]"
+	}
+	return "[Function (anonymous)]"
+}
+
+// inspectItems renders the first n indexed elements of an array or typed array.
+func (i *inspector) inspectItems(v Value, n int64, depth int) []string {
+	items := make([]string, 0, n)
+	for idx := int64(0); idx < n; idx++ {
+		if i.opts.maxItems >= 0 && idx >= int64(i.opts.maxItems) {
+			items = append(items, fmt.Sprintf("... %d more items", n-idx))
+			break
+		}
+		item := v.GetIdx(idx)
+		items = append(items, i.inspect(item, depth+1))
+		item.Free()
+	}
+	return items
+}
+
+func (i *inspector) inspectPromise(v Value, depth int) string {
+	switch v.PromiseState() {
+	case PromiseFulfilled:
+		result := v.PromiseResult()
+		defer result.Free()
+		return "Promise { " + i.inspect(result, depth+1) + " }"
+	case PromiseRejected:
+		result := v.PromiseResult()
+		defer result.Free()
+		return "Promise { " + i.style("<rejected>", styleSpecial) + " " + i.inspect(result, depth+1) + " }"
+	}
+	return "Promise { " + i.style("<pending>", styleSpecial) + " }"
+}
+
+// inspectCollection renders a Map or a Set from the array of its entries.
+func (i *inspector) inspectCollection(v Value, kind Kind, depth int) string {
+	size := i.getInt(v, "size")
+	entries := i.call("Array", "from", v)
+	defer entries.Free()
+	items := make([]string, 0, size)
+	for idx := int64(0); idx < size; idx++ {
+		if i.opts.maxItems >= 0 && idx >= int64(i.opts.maxItems) {
+			items = append(items, fmt.Sprintf("... %d more items", size-idx))
+			break
+		}
+		entry := entries.GetIdx(idx)
+		if kind == KindMap {
+			key, value := entry.GetIdx(0), entry.GetIdx(1)
+			items = append(items, i.inspect(key, depth+1)+" => "+i.inspect(value, depth+1))
+			key.Free()
+			value.Free()
+		} else {
+			items = append(items, i.inspect(entry, depth+1))
+		}
+		entry.Free()
+	}
+	return fmt.Sprintf("%s(%d) ", kind, size) + i.join("{", items, "}")
+}
+
+// inspectEntries renders the own enumerable string keyed properties of an object, without calling accessors.
+func (i *inspector) inspectEntries(v Value, depth int) []string {
+	keys := i.call("Object", "keys", v)
+	defer keys.Free()
+	n := keys.Len()
+	items := make([]string, 0, n)
+	for idx := int64(0); idx < n; idx++ {
+		key := keys.GetIdx(idx)
+		desc := i.call("Object", "getOwnPropertyDescriptor", v, key)
+		name := key.String()
+		key.Free()
+		if !inspectIdentifier.MatchString(name) {
+			name = inspectQuote(name)
+		}
+		getter, setter := desc.Get("get"), desc.Get("set")
+		switch {
+		case getter.IsFunction() && setter.IsFunction():
+			items = append(items, name+": "+i.style("[Getter/Setter]", styleSpecial))
+		case getter.IsFunction():
+			items = append(items, name+": "+i.style("[Getter]", styleSpecial))
+		case setter.IsFunction():
+			items = append(items, name+": "+i.style("[Setter]", styleSpecial))
+		default:
+			value := desc.Get("value")
+			items = append(items, name+": "+i.inspect(value, depth+1))
+			value.Free()
+		}
+		getter.Free()
+		setter.Free()
+		desc.Free()
+	}
+	return items
+}
+
+// join puts the items between open and close on one line if it is short enough, or one per line otherwise.
+func (i *inspector) join(open string, items []string, close string) string {
+	if len(items) == 0 {
+		return open + close
+	}
+	length := len(open) + len(close)
+	multiline := false
+	for _, item := range items {
+		length += len(item) + 2
+		multiline = multiline || strings.Contains(item, "\n")
+	}
+	if !multiline && length <= i.opts.breakLength {
+		return open + " " + strings.Join(items, ", ") + " " + close
+	}
+	var b strings.Builder
+	b.WriteString(open)
+	for idx, item := range items {
+		b.WriteString("\n  ")
+		b.WriteString(strings.ReplaceAll(item, "\n", "\n  "))
+		if idx < len(items)-1 {
+			b.WriteString(",")
+		}
+	}
+	b.WriteString("\n" + close)
+	return b.String()
+}
+
+func (i *inspector) constructorName(v Value) string {
+	ctor := v.Get("constructor")
+	defer ctor.Free()
+	if !ctor.IsFunction() {
+		return ""
+	}
+	return i.getString(ctor, "name")
+}
+
+// call calls a static method of a global constructor, such as Object.keys.
+func (i *inspector) call(global, method string, args ...Value) Value {
+	obj := i.ctx.Globals().Get(global)
+	defer obj.Free()
+	return obj.Call(method, args...)
+}
+
+func (i *inspector) callString(v Value, method string, args ...Value) string {
+	ret := v.Call(method, args...)
+	defer ret.Free()
+	return ret.String()
+}
+
+func (i *inspector) getString(v Value, name string) string {
+	prop := v.Get(name)
+	defer prop.Free()
+	if !prop.IsString() {
+		return ""
+	}
+	return prop.String()
+}
+
+func (i *inspector) getInt(v Value, name string) int64 {
+	prop := v.Get(name)
+	defer prop.Free()
+	return prop.Int64()
+}
+
+// inspectQuote quotes a string with single quotes, escaping it like a Go string literal.
+func inspectQuote(s string) string {
+	q := strconv.Quote(s)
+	q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(q, "'", `\'`) + "'"
+}
@@ -0,0 +1,388 @@
+// Package jslex implements a small JavaScript tokenizer used for source level tooling such as
+// coverage instrumentation. It is not a validating parser: it only splits source text into tokens
+// and keeps track of template literals and the regexp/division ambiguity.
+package jslex
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind is the kind of a token.
+type Kind int
+
+const (
+	EOF Kind = iota
+	Identifier
+	PrivateName
+	Number
+	String
+	Template
+	TemplateHead
+	TemplateMiddle
+	TemplateTail
+	RegExp
+	Punctuator
+)
+
+var kindNames = [...]string{"EOF", "Identifier", "PrivateName", "Number", "String", "Template", "TemplateHead", "TemplateMiddle", "TemplateTail", "RegExp", "Punctuator"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Token is a lexical token. Offsets are byte offsets, lines and columns are 1-based.
+type Token struct {
+	Kind          Kind
+	Text          string
+	Offset        int
+	Line          int
+	Col           int
+	NewlineBefore bool
+}
+
+// Is reports whether the token is the given punctuator or identifier name.
+func (t Token) Is(text string) bool {
+	return (t.Kind == Punctuator || t.Kind == Identifier) && t.Text == text
+}
+
+// Error is a tokenizer error.
+type Error struct {
+	Line    int
+	Col     int
+	Message string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Message) }
+
+// punctuators are sorted longest first so the first prefix match wins.
+var punctuators = []string{
+	">>>=",
+	"...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<", ">>", "**",
+	"{", "}", "(", ")", "[", "]", ";", ",", "<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "!", "~", "?", ":", "=", ".", "@",
+}
+
+// regexpKeywords are the keywords after which a slash starts a regular expression.
+var regexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true, "delete": true,
+	"void": true, "throw": true, "case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
+type lexer struct {
+	src     string
+	pos     int
+	line    int
+	lineOff int
+	tokens  []Token
+	braces  []bool // true for a brace opened by a template substitution
+}
+
+// Tokenize splits src into tokens, skipping whitespace and comments. The returned slice always ends with an EOF token.
+func Tokenize(src string) ([]Token, error) {
+	l := &lexer{src: src, line: 1}
+	if strings.HasPrefix(src, "#!") {
+		for l.pos < len(src) && !isLineTerminator(src, l.pos) {
+			l.pos++
+		}
+	}
+	for {
+		newline, err := l.skipSpace()
+		if err != nil {
+			return nil, err
+		}
+		tok := Token{Offset: l.pos, Line: l.line, Col: l.pos - l.lineOff + 1, NewlineBefore: newline}
+		if l.pos >= len(l.src) {
+			tok.Kind = EOF
+			l.tokens = append(l.tokens, tok)
+			return l.tokens, nil
+		}
+		if err := l.scan(&tok); err != nil {
+			return nil, err
+		}
+		tok.Text = l.src[tok.Offset:l.pos]
+		l.tokens = append(l.tokens, tok)
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &Error{Line: l.line, Col: l.pos - l.lineOff + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+// isLineTerminator reports whether src[i:] starts with a line terminator.
+func isLineTerminator(src string, i int) bool {
+	switch src[i] {
+	case '\n', '\r':
+		return true
+	case 0xe2:
+		return strings.HasPrefix(src[i:], "\u2028") || strings.HasPrefix(src[i:], "\u2029")
+	}
+	return false
+}
+
+// newline consumes a line terminator at the current position.
+func (l *lexer) newline() {
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "\r\n"):
+		l.pos += 2
+	case l.src[l.pos] == '\n' || l.src[l.pos] == '\r':
+		l.pos++
+	default:
+		l.pos += 3
+	}
+	l.line++
+	l.lineOff = l.pos
+}
+
+func (l *lexer) skipSpace() (bool, error) {
+	newline := false
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isLineTerminator(l.src, l.pos):
+			l.newline()
+			newline = true
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f':
+			l.pos++
+		case c == '/' && strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && !isLineTerminator(l.src, l.pos) {
+				l.pos++
+			}
+		case c == '/' && strings.HasPrefix(l.src[l.pos:], "/*"):
+			l.pos += 2
+			for {
+				if l.pos >= len(l.src) {
+					return false, l.errorf("unterminated comment")
+				}
+				if strings.HasPrefix(l.src[l.pos:], "*/") {
+					l.pos += 2
+					break
+				}
+				if isLineTerminator(l.src, l.pos) {
+					l.newline()
+					newline = true
+				} else {
+					l.pos++
+				}
+			}
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if !isSpaceRune(r) {
+				return newline, nil
+			}
+			l.pos += size
+		default:
+			return newline, nil
+		}
+	}
+	return newline, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '$' || c == '_' || c == '\\' || c >= utf8.RuneSelf || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// isSpaceRune reports whether r is a non-ASCII white space character.
+func isSpaceRune(r rune) bool {
+	return r == '\u00a0' || r == '\ufeff' || r == '\u1680' || (r >= '\u2000' && r <= '\u200a') ||
+		r == '\u202f' || r == '\u205f' || r == '\u3000'
+}
+
+// regexpAllowed reports whether a slash at the current position starts a regular expression.
+func (l *lexer) regexpAllowed() bool {
+	if len(l.tokens) == 0 {
+		return true
+	}
+	prev := l.tokens[len(l.tokens)-1]
+	switch prev.Kind {
+	case Identifier:
+		return regexpKeywords[prev.Text]
+	case Punctuator:
+		return prev.Text != ")" && prev.Text != "]"
+	case TemplateHead, TemplateMiddle:
+		return true
+	}
+	return false
+}
+
+func (l *lexer) scan(tok *Token) error {
+	c := l.src[l.pos]
+	switch {
+	case isIdentStart(c):
+		tok.Kind = Identifier
+		l.scanIdent()
+	case c == '#' && l.pos+1 < len(l.src) && isIdentStart(l.src[l.pos+1]):
+		tok.Kind = PrivateName
+		l.pos++
+		l.scanIdent()
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1])):
+		tok.Kind = Number
+		l.scanNumber()
+	case c == '"' || c == '\'':
+		tok.Kind = String
+		return l.scanString(c)
+	case c == '`':
+		l.pos++
+		return l.scanTemplate(tok, false)
+	case c == '}' && len(l.braces) > 0 && l.braces[len(l.braces)-1]:
+		l.braces = l.braces[:len(l.braces)-1]
+		l.pos++
+		return l.scanTemplate(tok, true)
+	case c == '/' && l.regexpAllowed():
+		tok.Kind = RegExp
+		return l.scanRegExp()
+	default:
+		for _, p := range punctuators {
+			if strings.HasPrefix(l.src[l.pos:], p) {
+				// "?." followed by a digit is a conditional operator and a number.
+				if p == "?." && l.pos+2 < len(l.src) && isDigit(l.src[l.pos+2]) {
+					continue
+				}
+				tok.Kind = Punctuator
+				l.pos += len(p)
+				switch p {
+				case "{":
+					l.braces = append(l.braces, false)
+				case "}":
+					if len(l.braces) > 0 {
+						l.braces = l.braces[:len(l.braces)-1]
+					}
+				}
+				return nil
+			}
+		}
+		return l.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func (l *lexer) scanIdent() {
+	for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+		if l.src[l.pos] >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if isSpaceRune(r) || r == '\u2028' || r == '\u2029' {
+				return
+			}
+			l.pos += size
+			continue
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) scanNumber() {
+	radix := strings.HasPrefix(l.src[l.pos:], "0x") || strings.HasPrefix(l.src[l.pos:], "0X") ||
+		strings.HasPrefix(l.src[l.pos:], "0b") || strings.HasPrefix(l.src[l.pos:], "0B") ||
+		strings.HasPrefix(l.src[l.pos:], "0o") || strings.HasPrefix(l.src[l.pos:], "0O")
+	dot, exp := false, false
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isDigit(c) || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			if !radix && (c == 'e' || c == 'E') {
+				exp = true
+				if l.pos+1 < len(l.src) && (l.src[l.pos+1] == '+' || l.src[l.pos+1] == '-') {
+					l.pos++
+				}
+			}
+			l.pos++
+		case c == '.' && !radix && !dot && !exp:
+			dot = true
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) scanString(quote byte) error {
+	l.pos++
+	for {
+		if l.pos >= len(l.src) || isLineTerminator(l.src, l.pos) {
+			return l.errorf("unterminated string literal")
+		}
+		switch l.src[l.pos] {
+		case quote:
+			l.pos++
+			return nil
+		case '\\':
+			l.pos++
+			if l.pos < len(l.src) && isLineTerminator(l.src, l.pos) {
+				l.newline()
+				continue
+			}
+		}
+		l.pos++
+	}
+}
+
+// scanTemplate scans a template literal part, starting after "`" or the "}" closing a substitution.
+func (l *lexer) scanTemplate(tok *Token, continued bool) error {
+	for {
+		if l.pos >= len(l.src) {
+			return l.errorf("unterminated template literal")
+		}
+		switch {
+		case l.src[l.pos] == '`':
+			l.pos++
+			if continued {
+				tok.Kind = TemplateTail
+			} else {
+				tok.Kind = Template
+			}
+			return nil
+		case strings.HasPrefix(l.src[l.pos:], "${"):
+			l.pos += 2
+			if continued {
+				tok.Kind = TemplateMiddle
+			} else {
+				tok.Kind = TemplateHead
+			}
+			l.braces = append(l.braces, true)
+			return nil
+		case l.src[l.pos] == '\\':
+			l.pos += 2
+		case isLineTerminator(l.src, l.pos):
+			l.newline()
+		default:
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) scanRegExp() error {
+	l.pos++
+	inClass := false
+	for {
+		if l.pos >= len(l.src) || isLineTerminator(l.src, l.pos) {
+			return l.errorf("unterminated regular expression")
+		}
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				l.pos++
+				for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+					l.pos++
+				}
+				return nil
+			}
+		}
+		l.pos++
+	}
+}
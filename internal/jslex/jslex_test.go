@@ -0,0 +1,36 @@
+package jslex_test
+
+import (
+	"testing"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := jslex.Tokenize("#!/usr/bin/env qjs\nlet a = b / 2 /* c */\nlet r = /[/]x/g; `t${ {k: `n${1}`}.k }u` // end")
+	require.NoError(t, err)
+
+	var kinds []jslex.Kind
+	var texts []string
+	for _, tok := range tokens {
+		kinds = append(kinds, tok.Kind)
+		texts = append(texts, tok.Text)
+	}
+	require.EqualValues(t, []string{
+		"let", "a", "=", "b", "/", "2",
+		"let", "r", "=", "/[/]x/g", ";", "`t${", "{", "k", ":", "`n${", "1", "}`", "}", ".", "k", "}u`", "",
+	}, texts)
+	require.EqualValues(t, jslex.RegExp, kinds[9])
+	require.EqualValues(t, jslex.TemplateHead, kinds[11])
+	require.EqualValues(t, jslex.TemplateTail, kinds[17])
+	require.EqualValues(t, jslex.EOF, kinds[len(kinds)-1])
+
+	require.True(t, tokens[6].NewlineBefore)
+	require.EqualValues(t, 3, tokens[6].Line)
+	require.EqualValues(t, 1, tokens[6].Col)
+	require.EqualValues(t, 9, tokens[9].Col)
+
+	_, err = jslex.Tokenize("let s = 'open\n")
+	require.EqualError(t, err, "1:14: unterminated string literal")
+}
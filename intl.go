@@ -0,0 +1,904 @@
+package quickjs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// The engine is built without Intl, so InstallIntl defines a subset of it backed by golang.org/x/text. The formatters
+// keep their Go state in objects created with GoObject, held in private fields.
+const intlPatch = `(numberFormat, formatNumber, dateTimeFormat, formatDate, collator, compare, canonicalLocales) => {
+	const define = (obj, name, value) => Object.defineProperty(obj, name, { value, writable: true, configurable: true });
+	class NumberFormat {
+		#f; #o;
+		constructor(locales, options) { [this.#f, this.#o] = numberFormat(locales, options); }
+		get format() { const f = this.#f; return (x) => formatNumber(f, Number(x)); }
+		resolvedOptions() { return { ...this.#o }; }
+		static supportedLocalesOf(locales) { return canonicalLocales(locales); }
+	}
+	class DateTimeFormat {
+		#f; #o;
+		constructor(locales, options) { [this.#f, this.#o] = dateTimeFormat(locales, options, "any", "date"); }
+		get format() { const f = this.#f; return (d) => formatDate(f, d === undefined ? Date.now() : Number(d)); }
+		resolvedOptions() { return { ...this.#o }; }
+		static supportedLocalesOf(locales) { return canonicalLocales(locales); }
+	}
+	class Collator {
+		#f; #o;
+		constructor(locales, options) { [this.#f, this.#o] = collator(locales, options); }
+		get compare() { const f = this.#f; return (a, b) => compare(f, String(a), String(b)); }
+		resolvedOptions() { return { ...this.#o }; }
+		static supportedLocalesOf(locales) { return canonicalLocales(locales); }
+	}
+	const Intl = { NumberFormat, DateTimeFormat, Collator, getCanonicalLocales: canonicalLocales };
+	Object.defineProperty(Intl, Symbol.toStringTag, { value: "Intl", configurable: true });
+	define(globalThis, "Intl", Intl);
+
+	const valueOf = Number.prototype.valueOf, getTime = Date.prototype.getTime;
+	define(Number.prototype, "toLocaleString", function (locales, options) {
+		return formatNumber(numberFormat(locales, options)[0], valueOf.call(this));
+	});
+	define(String.prototype, "localeCompare", function (that, locales, options) {
+		return compare(collator(locales, options)[0], String(this), String(that));
+	});
+	for (const [name, required, defaults] of [["toLocaleString", "any", "all"], ["toLocaleDateString", "date", "date"], ["toLocaleTimeString", "time", "time"]]) {
+		define(Date.prototype, name, function (locales, options) {
+			const t = getTime.call(this);
+			return t !== t ? "Invalid Date" : formatDate(dateTimeFormat(locales, options, required, defaults)[0], t);
+		});
+	}
+}`
+
+// InstallIntl defines the global Intl object of the context with a subset of the ECMA-402 API implemented with
+// golang.org/x/text, so that scripts formatting numbers and dates do not need an ICU polyfill:
+//
+//   - Intl.NumberFormat with the decimal, percent and currency styles, digit and grouping options
+//   - Intl.DateTimeFormat with dateStyle and timeStyle, the component options, hour12 and any IANA timeZone; month and
+//     weekday names are available in English, German, French, Spanish, Italian, Dutch, Portuguese, Japanese and
+//     Chinese, other languages use the English names
+//   - Intl.Collator with the sensitivity and numeric options
+//   - Intl.getCanonicalLocales and the supportedLocalesOf methods
+//
+// Number.prototype.toLocaleString, String.prototype.localeCompare and the locale methods of Date use them as well.
+// The default locale is the one set with SetLocale, en-US if none.
+func (ctx *Context) InstallIntl() error {
+	if ctx.locale == nil {
+		if err := ctx.SetLocale("en-US"); err != nil {
+			return err
+		}
+	}
+
+	numberFormat := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		f, resolved, err := ctx.newNumberFormat(args[0], args[1])
+		if err != nil {
+			return ctx.throwIntl(err)
+		}
+		return ctx.intlPair(f, resolved)
+	})
+	defer numberFormat.Free()
+	formatNumber := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		f, _ := args[0].GoData()
+		return ctx.String(f.(*numberFormatter).format(args[1].Float64()))
+	})
+	defer formatNumber.Free()
+	dateTimeFormat := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		f, resolved, err := ctx.newDateTimeFormat(args[0], args[1], args[2].String(), args[3].String())
+		if err != nil {
+			return ctx.throwIntl(err)
+		}
+		return ctx.intlPair(f, resolved)
+	})
+	defer dateTimeFormat.Free()
+	formatDate := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		f, _ := args[0].GoData()
+		ms := args[1].Float64()
+		if math.IsNaN(ms) || math.IsInf(ms, 0) {
+			return ctx.ThrowRangeError("Invalid time value")
+		}
+		return ctx.String(f.(*dateTimeFormatter).format(time.UnixMilli(int64(ms))))
+	})
+	defer formatDate.Free()
+	newCollator := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		c, resolved, err := ctx.newCollator(args[0], args[1])
+		if err != nil {
+			return ctx.throwIntl(err)
+		}
+		return ctx.intlPair(c, resolved)
+	})
+	defer newCollator.Free()
+	compare := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		c, _ := args[0].GoData()
+		return ctx.Int32(int32(c.(*collate.Collator).CompareString(args[1].String(), args[2].String())))
+	})
+	defer compare.Free()
+	canonicalLocales := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		locales := ctx.Undefined()
+		if len(args) > 0 {
+			locales = args[0]
+		}
+		tags, err := ctx.requestedLocales(locales)
+		if err != nil {
+			return ctx.throwIntl(err)
+		}
+		arr := ctx.Array().arrayValue
+		for i, t := range tags {
+			arr.SetIdx(int64(i), ctx.String(t.String()))
+		}
+		return arr
+	})
+	defer canonicalLocales.Free()
+
+	patch, err := ctx.Eval(intlPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), numberFormat, formatNumber, dateTimeFormat, formatDate, newCollator, compare, canonicalLocales)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
+
+// intlTypeError is an error of the Intl functions thrown as a TypeError; others are thrown as RangeErrors.
+type intlTypeError string
+
+func (err intlTypeError) Error() string { return string(err) }
+
+func (ctx *Context) throwIntl(err error) Value {
+	var typeErr intlTypeError
+	if errors.As(err, &typeErr) {
+		return ctx.ThrowTypeError("%s", err)
+	}
+	return ctx.ThrowRangeError("%s", err)
+}
+
+// intlField is a property of the object returned by resolvedOptions.
+type intlField struct {
+	name  string
+	value interface{} // string, int or bool
+}
+
+// intlPair returns the pair of the Go state of a formatter and its resolved options.
+func (ctx *Context) intlPair(state interface{}, resolved []intlField) Value {
+	obj := ctx.Object()
+	for _, f := range resolved {
+		switch v := f.value.(type) {
+		case string:
+			obj.Set(f.name, ctx.String(v))
+		case int:
+			obj.Set(f.name, ctx.Int32(int32(v)))
+		case bool:
+			obj.Set(f.name, ctx.Bool(v))
+		}
+	}
+	arr := ctx.Array().arrayValue
+	arr.SetIdx(0, ctx.GoObject(state, nil))
+	arr.SetIdx(1, obj)
+	return arr
+}
+
+// requestedLocales returns the language tags of the locales argument of an Intl function, a tag or an array of tags.
+func (ctx *Context) requestedLocales(locales Value) ([]language.Tag, error) {
+	if locales.IsUndefined() {
+		return nil, nil
+	}
+	var names []string
+	switch v := locales; {
+	case v.IsString():
+		names = []string{v.String()}
+	case v.IsArray():
+		for i := int64(0); i < v.Len(); i++ {
+			item := v.GetIdx(i)
+			names = append(names, item.String())
+			item.Free()
+		}
+	default:
+		return nil, intlTypeError("locales must be a string or an array of strings")
+	}
+	tags := make([]language.Tag, 0, len(names))
+	for _, name := range names {
+		t, err := language.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("Incorrect locale information provided: %s", name)
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// intlLocale returns the locale requested by the locales argument of an Intl constructor, or the default locale.
+func (ctx *Context) intlLocale(locales Value) (language.Tag, error) {
+	tags, err := ctx.requestedLocales(locales)
+	if err != nil {
+		return language.Und, err
+	}
+	if len(tags) > 0 {
+		return tags[0], nil
+	}
+	if ctx.locale != nil {
+		return ctx.locale.tag, nil
+	}
+	return language.AmericanEnglish, nil
+}
+
+// intlOptions reads the options argument of an Intl constructor.
+type intlOptions struct {
+	obj     Value
+	service string
+}
+
+func (o intlOptions) get(name string) (Value, bool) {
+	if !o.obj.IsObject() {
+		return Value{}, false
+	}
+	v := o.obj.Get(name)
+	if v.IsUndefined() {
+		v.Free()
+		return Value{}, false
+	}
+	return v, true
+}
+
+// string returns the option name, one of allowed, or def if it is not set.
+func (o intlOptions) string(name string, allowed []string, def string) (string, error) {
+	v, ok := o.get(name)
+	if !ok {
+		return def, nil
+	}
+	s := v.String()
+	v.Free()
+	for _, a := range allowed {
+		if s == a {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("Value %s out of range for Intl.%s options property %s", s, o.service, name)
+}
+
+// int returns the option name, an integer from min to max, or def if it is not set.
+func (o intlOptions) int(name string, min, max, def int) (int, error) {
+	v, ok := o.get(name)
+	if !ok {
+		return def, nil
+	}
+	f := v.Float64()
+	v.Free()
+	if math.IsNaN(f) || f < float64(min) || f > float64(max) {
+		return 0, fmt.Errorf("%s value is out of range", name)
+	}
+	return int(math.Floor(f)), nil
+}
+
+// bool returns the option name and whether it is set.
+func (o intlOptions) bool(name string) (value, set bool) {
+	v, ok := o.get(name)
+	if !ok {
+		return false, false
+	}
+	b := v.Bool()
+	v.Free()
+	return b, true
+}
+
+// currencySuffixLanguages write currency amounts with the symbol after the number.
+var currencySuffixLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true, "es": true, "et": true, "fi": true,
+	"fr": true, "hr": true, "hu": true, "it": true, "lt": true, "lv": true, "nb": true, "no": true, "pl": true,
+	"ro": true, "ru": true, "sk": true, "sl": true, "sr": true, "sv": true, "uk": true,
+}
+
+type numberFormatter struct {
+	tag             language.Tag
+	style           string
+	currency        currency.Unit
+	currencyDisplay string
+	maxFrac         int
+	options         []number.Option
+}
+
+func (ctx *Context) newNumberFormat(locales, options Value) (*numberFormatter, []intlField, error) {
+	tag, err := ctx.intlLocale(locales)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := intlOptions{obj: options, service: "NumberFormat"}
+	f := &numberFormatter{tag: tag}
+	if f.style, err = o.string("style", []string{"decimal", "percent", "currency"}, "decimal"); err != nil {
+		return nil, nil, err
+	}
+	var code string
+	if v, ok := o.get("currency"); ok {
+		code = strings.ToUpper(v.String())
+		v.Free()
+	}
+	if f.currencyDisplay, err = o.string("currencyDisplay", []string{"symbol", "narrowSymbol", "code"}, "symbol"); err != nil {
+		return nil, nil, err
+	}
+	minFrac, maxFrac := 0, 3
+	if f.style == "percent" {
+		maxFrac = 0
+	}
+	if f.style == "currency" {
+		if code == "" {
+			return nil, nil, intlTypeError("Currency code is required with currency style.")
+		}
+		if f.currency, err = currency.ParseISO(code); err != nil {
+			return nil, nil, fmt.Errorf("Invalid currency code : %s", code)
+		}
+		digits, _ := currency.Standard.Rounding(f.currency)
+		minFrac, maxFrac = digits, digits
+	}
+
+	minInt, err := o.int("minimumIntegerDigits", 1, 21, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, minSet := o.get("minimumFractionDigits")
+	if minFrac, err = o.int("minimumFractionDigits", 0, 20, minFrac); err != nil {
+		return nil, nil, err
+	}
+	if minSet && maxFrac < minFrac {
+		maxFrac = minFrac
+	}
+	if maxFrac, err = o.int("maximumFractionDigits", 0, 20, maxFrac); err != nil {
+		return nil, nil, err
+	}
+	if maxFrac < minFrac {
+		if minSet {
+			return nil, nil, errors.New("maximumFractionDigits value is out of range")
+		}
+		minFrac = maxFrac
+	}
+	grouping, set := o.bool("useGrouping")
+	if !set {
+		grouping = true
+	}
+
+	f.maxFrac = maxFrac
+	f.options = []number.Option{number.MinIntegerDigits(minInt), number.MinFractionDigits(minFrac), number.MaxFractionDigits(maxFrac)}
+	if !grouping {
+		f.options = append(f.options, number.NoSeparator())
+	}
+	resolved := []intlField{{"locale", tag.String()}, {"numberingSystem", "latn"}, {"style", f.style}}
+	if f.style == "currency" {
+		resolved = append(resolved, intlField{"currency", f.currency.String()}, intlField{"currencyDisplay", f.currencyDisplay})
+	}
+	resolved = append(resolved, intlField{"minimumIntegerDigits", minInt}, intlField{"minimumFractionDigits", minFrac},
+		intlField{"maximumFractionDigits", maxFrac}, intlField{"useGrouping", grouping}, intlField{"notation", "standard"},
+		intlField{"signDisplay", "auto"})
+	return f, resolved, nil
+}
+
+func (f *numberFormatter) format(x float64) string {
+	p := message.NewPrinter(f.tag)
+	sign := ""
+	if x < 0 || (x == 0 && math.Signbit(x)) {
+		sign = "-"
+	}
+	var s string
+	switch {
+	case math.IsNaN(x):
+		s, sign = "NaN", ""
+	case math.IsInf(x, 0):
+		s = "∞"
+	case f.style == "percent":
+		s = p.Sprint(number.Percent(roundHalfExpand(math.Abs(x), f.maxFrac+2), f.options...))
+	default:
+		s = p.Sprint(number.Decimal(roundHalfExpand(math.Abs(x), f.maxFrac), f.options...))
+	}
+	if f.style != "currency" || s == "NaN" {
+		return sign + s
+	}
+
+	var symbol string
+	switch f.currencyDisplay {
+	case "code":
+		symbol = f.currency.String()
+	case "narrowSymbol":
+		symbol = p.Sprint(currency.NarrowSymbol(f.currency))
+	default:
+		symbol = p.Sprint(currency.Symbol(f.currency))
+	}
+	base, _ := f.tag.Base()
+	region, _ := f.tag.Region()
+	switch lang := base.String(); {
+	case currencySuffixLanguages[lang] || (lang == "pt" && region.String() == "PT"):
+		return sign + s + " " + symbol
+	case lang == "nl" || lang == "pt" || unicode.IsLetter(lastRune(symbol)):
+		return sign + symbol + " " + s
+	}
+	return sign + symbol + s
+}
+
+// roundHalfExpand rounds x, positive, to the given number of fraction digits, with ties away from zero as in JS where
+// x/text would round them to even. Like JS, it rounds the shortest decimal representation of x, so that 1.005 is
+// rounded up.
+func roundHalfExpand(x float64, digits int) float64 {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(x, 'f', -1, 64))
+	if !ok {
+		return x
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+	r.Add(r, big.NewRat(1, 2))
+	rounded, _ := new(big.Rat).SetFrac(new(big.Int).Quo(r.Num(), r.Denom()), scale).Float64()
+	return rounded
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func (ctx *Context) newCollator(locales, options Value) (*collate.Collator, []intlField, error) {
+	tag, err := ctx.intlLocale(locales)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := intlOptions{obj: options, service: "Collator"}
+	usage, err := o.string("usage", []string{"sort", "search"}, "sort")
+	if err != nil {
+		return nil, nil, err
+	}
+	sensitivity, err := o.string("sensitivity", []string{"base", "accent", "case", "variant"}, "variant")
+	if err != nil {
+		return nil, nil, err
+	}
+	numeric, _ := o.bool("numeric")
+
+	var opts []collate.Option
+	switch sensitivity {
+	case "base":
+		opts = append(opts, collate.IgnoreCase, collate.IgnoreDiacritics)
+	case "accent":
+		opts = append(opts, collate.IgnoreCase)
+	case "case":
+		opts = append(opts, collate.IgnoreDiacritics)
+	}
+	if numeric {
+		opts = append(opts, collate.Numeric)
+	}
+	return collate.New(tag, opts...), []intlField{{"locale", tag.String()}, {"usage", usage}, {"sensitivity", sensitivity},
+		{"ignorePunctuation", false}, {"collation", "default"}, {"numeric", numeric}, {"caseFirst", "false"}}, nil
+}
+
+// dateLocale holds the names and patterns DateTimeFormat uses for a language. Patterns contain the placeholders
+// {weekday}, {year}, {month} and {day}; the placeholders of the fields that are not shown are removed with the text
+// following them, or preceding them at the end of the pattern.
+type dateLocale struct {
+	months, shortMonths     []string
+	weekdays, shortWeekdays []string
+	numeric, text           string // patterns of dates with a numeric and a named month
+	pad                     bool   // numeric days and months have two digits
+	yearSuffix, daySuffix   string // appended to the year and day numbers in text patterns
+	hour12                  bool
+	join                    string // between the date and the time
+}
+
+var dateLocales = map[string]*dateLocale{
+	"en": {
+		months:        strings.Fields("January February March April May June July August September October November December"),
+		shortMonths:   strings.Fields("Jan Feb Mar Apr May Jun Jul Aug Sep Oct Nov Dec"),
+		weekdays:      strings.Fields("Sunday Monday Tuesday Wednesday Thursday Friday Saturday"),
+		shortWeekdays: strings.Fields("Sun Mon Tue Wed Thu Fri Sat"),
+		numeric:       "{weekday}, {month}/{day}/{year}", text: "{weekday}, {month} {day}, {year}",
+		hour12: true, join: ", ",
+	},
+	"en-GB": {
+		months:        strings.Fields("January February March April May June July August September October November December"),
+		shortMonths:   strings.Fields("Jan Feb Mar Apr May Jun Jul Aug Sept Oct Nov Dec"),
+		weekdays:      strings.Fields("Sunday Monday Tuesday Wednesday Thursday Friday Saturday"),
+		shortWeekdays: strings.Fields("Sun Mon Tue Wed Thu Fri Sat"),
+		numeric:       "{weekday}, {day}/{month}/{year}", text: "{weekday} {day} {month} {year}",
+		pad: true, join: ", ",
+	},
+	"de": {
+		months:        strings.Fields("Januar Februar März April Mai Juni Juli August September Oktober November Dezember"),
+		shortMonths:   strings.Fields("Jan. Feb. März Apr. Mai Juni Juli Aug. Sept. Okt. Nov. Dez."),
+		weekdays:      strings.Fields("Sonntag Montag Dienstag Mittwoch Donnerstag Freitag Samstag"),
+		shortWeekdays: strings.Fields("So. Mo. Di. Mi. Do. Fr. Sa."),
+		numeric:       "{weekday}, {day}.{month}.{year}", text: "{weekday}, {day} {month} {year}", daySuffix: ".",
+		join: ", ",
+	},
+	"fr": {
+		months:        strings.Fields("janvier février mars avril mai juin juillet août septembre octobre novembre décembre"),
+		shortMonths:   strings.Fields("janv. févr. mars avr. mai juin juil. août sept. oct. nov. déc."),
+		weekdays:      strings.Fields("dimanche lundi mardi mercredi jeudi vendredi samedi"),
+		shortWeekdays: strings.Fields("dim. lun. mar. mer. jeu. ven. sam."),
+		numeric:       "{weekday} {day}/{month}/{year}", text: "{weekday} {day} {month} {year}",
+		pad: true, join: " ",
+	},
+	"es": {
+		months:        strings.Fields("enero febrero marzo abril mayo junio julio agosto septiembre octubre noviembre diciembre"),
+		shortMonths:   strings.Fields("ene feb mar abr may jun jul ago sept oct nov dic"),
+		weekdays:      strings.Fields("domingo lunes martes miércoles jueves viernes sábado"),
+		shortWeekdays: strings.Fields("dom lun mar mié jue vie sáb"),
+		numeric:       "{weekday}, {day}/{month}/{year}", text: "{weekday}, {day} de {month} de {year}",
+		join: ", ",
+	},
+	"it": {
+		months:        strings.Fields("gennaio febbraio marzo aprile maggio giugno luglio agosto settembre ottobre novembre dicembre"),
+		shortMonths:   strings.Fields("gen feb mar apr mag giu lug ago set ott nov dic"),
+		weekdays:      strings.Fields("domenica lunedì martedì mercoledì giovedì venerdì sabato"),
+		shortWeekdays: strings.Fields("dom lun mar mer gio ven sab"),
+		numeric:       "{weekday} {day}/{month}/{year}", text: "{weekday} {day} {month} {year}",
+		join: ", ",
+	},
+	"nl": {
+		months:        strings.Fields("januari februari maart april mei juni juli augustus september oktober november december"),
+		shortMonths:   strings.Fields("jan feb mrt apr mei jun jul aug sep okt nov dec"),
+		weekdays:      strings.Fields("zondag maandag dinsdag woensdag donderdag vrijdag zaterdag"),
+		shortWeekdays: strings.Fields("zo ma di wo do vr za"),
+		numeric:       "{weekday} {day}-{month}-{year}", text: "{weekday} {day} {month} {year}",
+		join: ", ",
+	},
+	"pt": {
+		months:        strings.Fields("janeiro fevereiro março abril maio junho julho agosto setembro outubro novembro dezembro"),
+		shortMonths:   strings.Fields("jan. fev. mar. abr. mai. jun. jul. ago. set. out. nov. dez."),
+		weekdays:      strings.Fields("domingo segunda-feira terça-feira quarta-feira quinta-feira sexta-feira sábado"),
+		shortWeekdays: strings.Fields("dom. seg. ter. qua. qui. sex. sáb."),
+		numeric:       "{weekday}, {day}/{month}/{year}", text: "{weekday}, {day} de {month} de {year}",
+		pad: true, join: ", ",
+	},
+	"ja": {
+		months:        strings.Fields("1月 2月 3月 4月 5月 6月 7月 8月 9月 10月 11月 12月"),
+		shortMonths:   strings.Fields("1月 2月 3月 4月 5月 6月 7月 8月 9月 10月 11月 12月"),
+		weekdays:      strings.Fields("日曜日 月曜日 火曜日 水曜日 木曜日 金曜日 土曜日"),
+		shortWeekdays: strings.Fields("日 月 火 水 木 金 土"),
+		numeric:       "{year}/{month}/{day}({weekday})", text: "{year}{month}{day}{weekday}",
+		yearSuffix: "年", daySuffix: "日", join: " ",
+	},
+	"zh": {
+		months:        strings.Fields("一月 二月 三月 四月 五月 六月 七月 八月 九月 十月 十一月 十二月"),
+		shortMonths:   strings.Fields("1月 2月 3月 4月 5月 6月 7月 8月 9月 10月 11月 12月"),
+		weekdays:      strings.Fields("星期日 星期一 星期二 星期三 星期四 星期五 星期六"),
+		shortWeekdays: strings.Fields("周日 周一 周二 周三 周四 周五 周六"),
+		numeric:       "{year}/{month}/{day}{weekday}", text: "{year}{month}{day}{weekday}",
+		yearSuffix: "年", daySuffix: "日", join: " ",
+	},
+}
+
+// dateLocaleOf returns the names and patterns for a locale; languages without their own use English.
+func dateLocaleOf(tag language.Tag) *dateLocale {
+	base, _ := tag.Base()
+	region, _ := tag.Region()
+	if base.String() == "en" {
+		if r := region.String(); r != "US" && r != "ZZ" && r != "CA" && r != "PH" {
+			return dateLocales["en-GB"]
+		}
+	}
+	if l, ok := dateLocales[base.String()]; ok {
+		return l
+	}
+	return dateLocales["en"]
+}
+
+type dateTimeFormatter struct {
+	locale                             *dateLocale
+	loc                                *time.Location
+	weekday, year, month, day          string
+	hour, minute, second, timeZoneName string
+	fractionalSecondDigits             int
+	hour12                             bool
+}
+
+var (
+	dateStyles = []string{"full", "long", "medium", "short"}
+	textWidths = []string{"long", "short", "narrow"}
+	numbers    = []string{"numeric", "2-digit"}
+)
+
+func (ctx *Context) newDateTimeFormat(locales, options Value, required, defaults string) (*dateTimeFormatter, []intlField, error) {
+	tag, err := ctx.intlLocale(locales)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := intlOptions{obj: options, service: "DateTimeFormat"}
+	f := &dateTimeFormatter{locale: dateLocaleOf(tag), loc: ctx.Timezone()}
+	f.hour12 = f.locale.hour12
+
+	if v, ok := o.get("timeZone"); ok {
+		name := v.String()
+		v.Free()
+		if strings.EqualFold(name, "UTC") || strings.EqualFold(name, "Etc/UTC") {
+			f.loc = time.UTC
+		} else if f.loc, err = time.LoadLocation(name); err != nil || name == "" || name == "Local" {
+			return nil, nil, fmt.Errorf("Invalid time zone specified: %s", name)
+		}
+	}
+	if hour12, ok := o.bool("hour12"); ok {
+		f.hour12 = hour12
+	} else {
+		cycle, err := o.string("hourCycle", []string{"h11", "h12", "h23", "h24"}, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		if cycle != "" {
+			f.hour12 = cycle == "h11" || cycle == "h12"
+		}
+	}
+
+	for _, field := range []struct {
+		name    string
+		allowed []string
+		value   *string
+	}{
+		{"weekday", textWidths, &f.weekday},
+		{"year", numbers, &f.year},
+		{"month", append(numbers[:2:2], textWidths...), &f.month},
+		{"day", numbers, &f.day},
+		{"hour", numbers, &f.hour},
+		{"minute", numbers, &f.minute},
+		{"second", numbers, &f.second},
+		{"timeZoneName", []string{"short", "long", "shortOffset", "longOffset"}, &f.timeZoneName},
+	} {
+		if *field.value, err = o.string(field.name, field.allowed, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	if f.fractionalSecondDigits, err = o.int("fractionalSecondDigits", 1, 3, 0); err != nil {
+		return nil, nil, err
+	}
+	dateStyle, err := o.string("dateStyle", dateStyles, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	timeStyle, err := o.string("timeStyle", dateStyles, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hasDate := f.weekday != "" || f.year != "" || f.month != "" || f.day != ""
+	hasTime := f.hour != "" || f.minute != "" || f.second != "" || f.fractionalSecondDigits > 0
+	if dateStyle != "" || timeStyle != "" {
+		if hasDate || hasTime || f.timeZoneName != "" {
+			return nil, nil, intlTypeError("dateStyle and timeStyle can't be used with other date or time options")
+		}
+		if (required == "date" && dateStyle == "") || (required == "time" && timeStyle == "") {
+			return nil, nil, intlTypeError("Invalid option : " + required + "Style is required")
+		}
+		f.applyStyles(dateStyle, timeStyle)
+	} else {
+		needDefaults := true
+		if (required == "date" || required == "any") && hasDate {
+			needDefaults = false
+		}
+		if (required == "time" || required == "any") && hasTime {
+			needDefaults = false
+		}
+		if needDefaults && (defaults == "date" || defaults == "all") {
+			f.year, f.month, f.day = "numeric", "numeric", "numeric"
+		}
+		if needDefaults && (defaults == "time" || defaults == "all") {
+			f.hour, f.minute, f.second = "numeric", "numeric", "numeric"
+		}
+	}
+
+	resolved := []intlField{{"locale", tag.String()}, {"calendar", "gregory"}, {"numberingSystem", "latn"},
+		{"timeZone", f.loc.String()}}
+	if f.hour != "" {
+		cycle := "h23"
+		if f.hour12 {
+			cycle = "h12"
+		}
+		resolved = append(resolved, intlField{"hourCycle", cycle}, intlField{"hour12", f.hour12})
+	}
+	for _, field := range []intlField{{"weekday", f.weekday}, {"year", f.year}, {"month", f.month}, {"day", f.day},
+		{"hour", f.hour}, {"minute", f.minute}, {"second", f.second}, {"fractionalSecondDigits", f.fractionalSecondDigits},
+		{"timeZoneName", f.timeZoneName}, {"dateStyle", dateStyle}, {"timeStyle", timeStyle}} {
+		if field.value != "" && field.value != 0 {
+			resolved = append(resolved, field)
+		}
+	}
+	return f, resolved, nil
+}
+
+// applyStyles sets the fields shown by dateStyle and timeStyle.
+func (f *dateTimeFormatter) applyStyles(dateStyle, timeStyle string) {
+	switch dateStyle {
+	case "full":
+		f.weekday, f.year, f.month, f.day = "long", "numeric", "long", "numeric"
+	case "long":
+		f.year, f.month, f.day = "numeric", "long", "numeric"
+	case "medium":
+		f.year, f.month, f.day = "numeric", "short", "numeric"
+	case "short":
+		f.year, f.month, f.day = "2-digit", "numeric", "numeric"
+	}
+	switch timeStyle {
+	case "full", "long":
+		f.hour, f.minute, f.second, f.timeZoneName = "numeric", "2-digit", "2-digit", "short"
+	case "medium":
+		f.hour, f.minute, f.second = "numeric", "2-digit", "2-digit"
+	case "short":
+		f.hour, f.minute = "numeric", "2-digit"
+	}
+}
+
+func (f *dateTimeFormatter) format(t time.Time) string {
+	t = t.In(f.loc)
+	l := f.locale
+	parts := make([]string, 0, 3)
+	if date := f.formatDate(t); date != "" {
+		parts = append(parts, date)
+	}
+	if clock := f.formatTime(t); clock != "" {
+		parts = append(parts, clock)
+	}
+	s := strings.Join(parts, l.join)
+	if f.timeZoneName != "" {
+		s += " " + timeZoneName(t, f.timeZoneName)
+	}
+	return s
+}
+
+func (f *dateTimeFormatter) formatDate(t time.Time) string {
+	l := f.locale
+	pattern := l.numeric
+	fields := map[string]string{}
+	digits := func(style string, n int) string {
+		if style == "2-digit" || (style == "numeric" && l.pad) {
+			return fmt.Sprintf("%02d", n%100)
+		}
+		return fmt.Sprint(n)
+	}
+	if f.year == "2-digit" {
+		fields["year"] = fmt.Sprintf("%02d", t.Year()%100)
+	} else if f.year != "" {
+		fields["year"] = fmt.Sprint(t.Year())
+	}
+	if f.day != "" {
+		fields["day"] = digits(f.day, t.Day())
+	}
+	switch f.month {
+	case "":
+	case "numeric", "2-digit":
+		fields["month"] = digits(f.month, int(t.Month()))
+	default:
+		pattern = l.text
+		fields["month"] = textName(l.months, l.shortMonths, f.month, int(t.Month())-1)
+		if f.year != "" {
+			fields["year"] += l.yearSuffix
+		}
+		if f.day != "" {
+			fields["day"] = fmt.Sprint(t.Day()) + l.daySuffix
+		}
+	}
+	if f.weekday != "" {
+		fields["weekday"] = textName(l.weekdays, l.shortWeekdays, f.weekday, int(t.Weekday()))
+	}
+	return fillPattern(pattern, fields)
+}
+
+func textName(long, short []string, width string, i int) string {
+	switch width {
+	case "short":
+		return short[i]
+	case "narrow":
+		r, _ := utf8.DecodeRuneInString(long[i])
+		return string(unicode.ToUpper(r))
+	}
+	return long[i]
+}
+
+// fillPattern replaces the placeholders of a date pattern with the fields. The text between two fields shown is the
+// text following the first in the pattern; the text before the first placeholder and after the last one is only
+// shown with their fields.
+func fillPattern(pattern string, fields map[string]string) string {
+	var names, texts []string
+	lead, rest, _ := strings.Cut(pattern, "{")
+	for rest != "" {
+		name, after, _ := strings.Cut(rest, "}")
+		text, next, _ := strings.Cut(after, "{")
+		names, texts = append(names, name), append(texts, text)
+		rest = next
+	}
+
+	var out strings.Builder
+	sep, shown := "", false
+	for i, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		switch {
+		case shown:
+			out.WriteString(sep)
+		case i == 0:
+			out.WriteString(lead)
+		}
+		out.WriteString(value)
+		if i == len(names)-1 {
+			out.WriteString(texts[i])
+		}
+		sep, shown = texts[i], true
+	}
+	return out.String()
+}
+
+func (f *dateTimeFormatter) formatTime(t time.Time) string {
+	if f.hour == "" && f.minute == "" && f.second == "" && f.fractionalSecondDigits == 0 {
+		return ""
+	}
+	var parts []string
+	if f.hour != "" {
+		h := t.Hour()
+		if f.hour12 {
+			if h = h % 12; h == 0 {
+				h = 12
+			}
+		}
+		if f.hour == "2-digit" || !f.hour12 {
+			parts = append(parts, fmt.Sprintf("%02d", h))
+		} else {
+			parts = append(parts, fmt.Sprint(h))
+		}
+	}
+	if f.minute != "" {
+		if f.hour != "" || f.minute == "2-digit" {
+			parts = append(parts, fmt.Sprintf("%02d", t.Minute()))
+		} else {
+			parts = append(parts, fmt.Sprint(t.Minute()))
+		}
+	}
+	if f.second != "" || f.fractionalSecondDigits > 0 {
+		s := fmt.Sprint(t.Second())
+		if f.hour != "" || f.minute != "" || f.second == "2-digit" {
+			s = fmt.Sprintf("%02d", t.Second())
+		}
+		if f.fractionalSecondDigits > 0 {
+			s += "." + fmt.Sprintf("%03d", t.Nanosecond()/1e6)[:f.fractionalSecondDigits]
+		}
+		parts = append(parts, s)
+	}
+	s := strings.Join(parts, ":")
+	if f.hour != "" && f.hour12 {
+		if t.Hour() < 12 {
+			s += " AM"
+		} else {
+			s += " PM"
+		}
+	}
+	return s
+}
+
+// timeZoneName returns the name of the time zone at t as an offset from GMT, such as "GMT+1" for the short styles
+// and "GMT+01:00" for the long ones.
+func timeZoneName(t time.Time, style string) string {
+	_, offset := t.Zone()
+	if offset == 0 {
+		if style == "short" || style == "long" {
+			if t.Location() == time.UTC {
+				return "UTC"
+			}
+		}
+		return "GMT"
+	}
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	h, m := offset/3600, offset/60%60
+	if style == "short" || style == "shortOffset" {
+		if m == 0 {
+			return fmt.Sprintf("GMT%s%d", sign, h)
+		}
+		return fmt.Sprintf("GMT%s%d:%02d", sign, h, m)
+	}
+	return fmt.Sprintf("GMT%s%02d:%02d", sign, h, m)
+}
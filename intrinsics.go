@@ -0,0 +1,69 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// Intrinsic is a set of built-in objects added to new contexts. The base objects (Object, Function, Array, String,
+// Number, Math, errors, ...), eval and Promise are always added: the package relies on them.
+type Intrinsic uint32
+
+const (
+	IntrinsicDate Intrinsic = 1 << iota
+	IntrinsicRegExp
+	IntrinsicJSON
+	IntrinsicProxy
+	IntrinsicMapSet
+	IntrinsicTypedArrays
+	IntrinsicBigInt
+	IntrinsicStringNormalize
+	// IntrinsicBigNum adds BigFloat, BigDecimal, operator overloading and the bignum extensions.
+	IntrinsicBigNum
+
+	IntrinsicAll = IntrinsicDate | IntrinsicRegExp | IntrinsicJSON | IntrinsicProxy | IntrinsicMapSet |
+		IntrinsicTypedArrays | IntrinsicBigInt | IntrinsicStringNormalize | IntrinsicBigNum
+)
+
+// newContextWithIntrinsics creates a context with the given intrinsics; zero means IntrinsicAll.
+func newContextWithIntrinsics(rt *C.JSRuntime, intrinsics Intrinsic) *C.JSContext {
+	if intrinsics == 0 {
+		intrinsics = IntrinsicAll
+	}
+	ctx := C.JS_NewContextRaw(rt)
+	C.JS_AddIntrinsicBaseObjects(ctx)
+	C.JS_AddIntrinsicEval(ctx)
+	C.JS_AddIntrinsicPromise(ctx)
+	if intrinsics&IntrinsicDate != 0 {
+		C.JS_AddIntrinsicDate(ctx)
+	}
+	if intrinsics&IntrinsicStringNormalize != 0 {
+		C.JS_AddIntrinsicStringNormalize(ctx)
+	}
+	if intrinsics&IntrinsicRegExp != 0 {
+		C.JS_AddIntrinsicRegExpCompiler(ctx)
+		C.JS_AddIntrinsicRegExp(ctx)
+	}
+	if intrinsics&IntrinsicJSON != 0 {
+		C.JS_AddIntrinsicJSON(ctx)
+	}
+	if intrinsics&IntrinsicProxy != 0 {
+		C.JS_AddIntrinsicProxy(ctx)
+	}
+	if intrinsics&IntrinsicMapSet != 0 {
+		C.JS_AddIntrinsicMapSet(ctx)
+	}
+	if intrinsics&IntrinsicTypedArrays != 0 {
+		C.JS_AddIntrinsicTypedArrays(ctx)
+	}
+	if intrinsics&(IntrinsicBigInt|IntrinsicBigNum) != 0 {
+		C.JS_AddIntrinsicBigInt(ctx)
+	}
+	if intrinsics&IntrinsicBigNum != 0 {
+		C.JS_AddIntrinsicBigFloat(ctx)
+		C.JS_AddIntrinsicBigDecimal(ctx)
+		C.JS_AddIntrinsicOperators(ctx)
+		C.JS_EnableBignumExt(ctx, C.int(1))
+	}
+	return ctx
+}
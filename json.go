@@ -0,0 +1,531 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// LargeIntMode selects how ParseJSON converts integers outside the range of integers that a float64 represents
+// exactly, ±(2^53-1).
+type LargeIntMode int
+
+const (
+	// LargeIntFloat64 rounds large integers to the nearest float64, like JSON.parse.
+	LargeIntFloat64 LargeIntMode = iota
+	// LargeIntBigInt converts large integers to BigInt values, without loss.
+	LargeIntBigInt
+	// LargeIntError rejects JSON containing large integers.
+	LargeIntError
+)
+
+// DuplicateKeyPolicy selects how ParseJSON handles an object with the same key several times.
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeyLast keeps the last value, like JSON.parse.
+	DuplicateKeyLast DuplicateKeyPolicy = iota
+	// DuplicateKeyFirst keeps the first value.
+	DuplicateKeyFirst
+	// DuplicateKeyError rejects JSON containing duplicate keys.
+	DuplicateKeyError
+)
+
+// maxSafeInteger is Number.MAX_SAFE_INTEGER.
+const maxSafeInteger = 1<<53 - 1
+
+type JSONOptions struct {
+	largeInts     LargeIntMode
+	duplicateKeys DuplicateKeyPolicy
+	maxDepth      int
+}
+
+type JSONOption func(*JSONOptions)
+
+// JSONLargeInts sets how integers that a float64 cannot represent exactly are converted, LargeIntFloat64 by default.
+func JSONLargeInts(mode LargeIntMode) JSONOption {
+	return func(opts *JSONOptions) {
+		opts.largeInts = mode
+	}
+}
+
+// JSONDuplicateKeys sets how duplicate object keys are handled, DuplicateKeyLast by default.
+func JSONDuplicateKeys(policy DuplicateKeyPolicy) JSONOption {
+	return func(opts *JSONOptions) {
+		opts.duplicateKeys = policy
+	}
+}
+
+// JSONMaxDepth rejects JSON with objects and arrays nested more than depth levels deep; 0, the default, sets no
+// limit.
+func JSONMaxDepth(depth int) JSONOption {
+	return func(opts *JSONOptions) {
+		opts.maxDepth = depth
+	}
+}
+
+// parseJSON parses JSON with options, building the values itself instead of calling JSON.parse. Errors are thrown as
+// SyntaxError and the exception is returned, as JS_ParseJSON does.
+func (ctx *Context) parseJSON(v string, opts []JSONOption) Value {
+	val, err := ctx.decodeJSON(strings.NewReader(v), opts)
+	if err != nil {
+		return ctx.ThrowSyntaxError("%s", err)
+	}
+	return val
+}
+
+// ParseJSONReader parses JSON read from r, like ParseJSON with the same options, decoding it as it is read so that
+// the whole text is never held in memory. Invalid JSON gives a SyntaxError, returned as an *Error; read errors are
+// returned as is.
+func (ctx *Context) ParseJSONReader(r io.Reader, opts ...JSONOption) (Value, error) {
+	val, err := ctx.decodeJSON(r, opts)
+	var syntaxErr *jsonSyntaxError
+	if errors.As(err, &syntaxErr) {
+		ctx.ThrowSyntaxError("%s", syntaxErr.err)
+		return ctx.Undefined(), ctx.Exception()
+	}
+	if err != nil {
+		return ctx.Undefined(), err
+	}
+	return val, nil
+}
+
+// jsonSyntaxError wraps errors of invalid or rejected JSON, to tell them from read errors.
+type jsonSyntaxError struct{ err error }
+
+func (e *jsonSyntaxError) Error() string { return e.err.Error() }
+
+// jsonReader records the first error of the reader read by a json.Decoder, which returns it unwrapped.
+type jsonReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *jsonReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (ctx *Context) decodeJSON(r io.Reader, opts []JSONOption) (Value, error) {
+	reader := &jsonReader{r: r}
+	d := jsonDecoder{ctx: ctx, dec: json.NewDecoder(reader)}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	d.dec.UseNumber()
+	val, err := d.next(0)
+	if err == nil {
+		if _, err = d.dec.Token(); err == io.EOF {
+			return val, nil
+		}
+		val.Free()
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+	}
+	if reader.err != nil {
+		return Value{}, reader.err
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = errors.New("unexpected end of JSON input")
+	}
+	return Value{}, &jsonSyntaxError{err}
+}
+
+type jsonDecoder struct {
+	ctx  *Context
+	dec  *json.Decoder
+	opts JSONOptions
+}
+
+// next decodes the next value, at the given nesting depth.
+func (d *jsonDecoder) next(depth int) (Value, error) {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return Value{}, err
+	}
+	switch tok := tok.(type) {
+	case nil:
+		return d.ctx.Null(), nil
+	case bool:
+		return d.ctx.Bool(tok), nil
+	case string:
+		return d.ctx.stringLen(tok), nil
+	case json.Number:
+		return d.number(string(tok))
+	case json.Delim:
+		if d.opts.maxDepth > 0 && depth >= d.opts.maxDepth {
+			return Value{}, fmt.Errorf("JSON nested deeper than %d levels", d.opts.maxDepth)
+		}
+		if tok == '[' {
+			return d.array(depth + 1)
+		}
+		return d.object(depth + 1)
+	}
+	return Value{}, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+func (d *jsonDecoder) number(s string) (Value, error) {
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return Value{}, err
+		}
+		return d.ctx.Float64(f), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= -maxSafeInteger && n <= maxSafeInteger {
+		if n == 0 && strings.HasPrefix(s, "-") {
+			return d.ctx.Float64(math.Copysign(0, -1)), nil
+		}
+		return d.ctx.Int64(n), nil
+	}
+	switch d.opts.largeInts {
+	case LargeIntBigInt:
+		ctor := d.ctx.Globals().Get("BigInt")
+		defer ctor.Free()
+		str := d.ctx.String(s)
+		defer str.Free()
+		return d.ctx.InvokeE(ctor, d.ctx.Null(), str)
+	case LargeIntError:
+		return Value{}, fmt.Errorf("JSON integer %s cannot be represented exactly", s)
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return d.ctx.Float64(f), nil
+}
+
+func (d *jsonDecoder) array(depth int) (Value, error) {
+	arr := d.ctx.track(Value{ctx: d.ctx, ref: C.JS_NewArray(d.ctx.ref)})
+	for idx := uint32(0); d.dec.More(); idx++ {
+		item, err := d.next(depth)
+		if err != nil {
+			arr.Free()
+			return Value{}, err
+		}
+		d.ctx.untrack(item)
+		C.JS_DefinePropertyValueUint32(d.ctx.ref, arr.ref, C.uint32_t(idx), item.ref, C.JS_PROP_C_W_E)
+	}
+	if _, err := d.dec.Token(); err != nil {
+		arr.Free()
+		return Value{}, err
+	}
+	return arr, nil
+}
+
+func (d *jsonDecoder) object(depth int) (Value, error) {
+	obj := d.ctx.Object()
+	seen := map[string]bool{}
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			obj.Free()
+			return Value{}, err
+		}
+		key := tok.(string)
+		val, err := d.next(depth)
+		if err != nil {
+			obj.Free()
+			return Value{}, err
+		}
+		if seen[key] {
+			switch d.opts.duplicateKeys {
+			case DuplicateKeyFirst:
+				val.Free()
+				continue
+			case DuplicateKeyError:
+				val.Free()
+				obj.Free()
+				return Value{}, fmt.Errorf("duplicate key %q in JSON object", key)
+			}
+		}
+		seen[key] = true
+		atom := d.ctx.atomLen(key)
+		d.ctx.untrack(val)
+		C.JS_DefinePropertyValue(d.ctx.ref, obj.ref, atom.ref, val.ref, C.JS_PROP_C_W_E)
+		atom.Free()
+	}
+	if _, err := d.dec.Token(); err != nil {
+		obj.Free()
+		return Value{}, err
+	}
+	return obj, nil
+}
+
+// MarshalJSON implements json.Marshaler with JSON.stringify, so that values can be embedded in Go data encoded with
+// encoding/json. Values JSON.stringify turns into undefined, such as functions, are encoded as null.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.ctx == nil {
+		return []byte("null"), nil
+	}
+	ret := v.ctx.track(Value{ctx: v.ctx, ref: C.JS_JSONStringify(v.ctx.ref, v.ref, C.JS_NewUndefined(), C.JS_NewUndefined())})
+	defer ret.Free()
+	if ret.IsException() {
+		return nil, v.ctx.Exception()
+	}
+	if ret.IsUndefined() {
+		return []byte("null"), nil
+	}
+	var n C.size_t
+	ptr := C.JS_ToCStringLen(v.ctx.ref, &n, ret.ref)
+	defer C.JS_FreeCString(v.ctx.ref, ptr)
+	return C.GoBytes(unsafe.Pointer(ptr), C.int(n)), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for a value that already belongs to a context, such as ctx.Undefined(),
+// replacing it with the parsed JSON in the same context and freeing the previous value.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	if v.ctx == nil {
+		return errors.New("quickjs: UnmarshalJSON needs a value of a context, such as ctx.Undefined()")
+	}
+	parsed, err := v.ctx.FromJSONRawMessage(data)
+	if err != nil {
+		return err
+	}
+	v.Free()
+	*v = parsed
+	return nil
+}
+
+// FromJSONRawMessage parses a json.RawMessage, such as a field of a Go struct decoded with encoding/json, into a
+// value. An empty message gives null.
+func (ctx *Context) FromJSONRawMessage(raw json.RawMessage, opts ...JSONOption) (Value, error) {
+	if len(raw) == 0 {
+		return ctx.Null(), nil
+	}
+	v := ctx.ParseJSON(string(raw), opts...)
+	if v.IsException() {
+		return ctx.Null(), ctx.Exception()
+	}
+	return v, nil
+}
+
+// JSONStringifyWriter writes the value serialized like JSON.stringify to w, a value at a time, so that the whole text
+// is never held in memory. Like JSON.stringify, it calls toJSON methods and getters, leaves out properties holding
+// undefined, functions or symbols, and fails on BigInt values and circular structures; a value that JSON.stringify
+// turns into undefined writes nothing.
+func (v Value) JSONStringifyWriter(w io.Writer) error {
+	e := jsonEncoder{ctx: v.ctx, w: bufio.NewWriter(w)}
+	if err := e.encodeProperty(v, "", false); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+type jsonEncoder struct {
+	ctx   *Context
+	w     *bufio.Writer
+	stack []Value
+}
+
+// resolve returns the value serialized for v, the value of the property key: the result of its toJSON method, or
+// the primitive value of a wrapper object. The returned value is owned by the caller.
+func (e *jsonEncoder) resolve(v Value, key string) (Value, error) {
+	v = e.ctx.track(Value{ctx: e.ctx, ref: C.JS_DupValue(e.ctx.ref, v.ref)})
+	if v.IsObject() || v.IsBigInt() {
+		toJSON, err := v.GetE("toJSON")
+		if err != nil {
+			v.Free()
+			return Value{}, err
+		}
+		if toJSON.IsFunction() {
+			k := e.ctx.String(key)
+			ret, err := e.ctx.InvokeE(toJSON, v, k)
+			k.Free()
+			toJSON.Free()
+			v.Free()
+			if err != nil {
+				return Value{}, err
+			}
+			v = ret
+		} else {
+			toJSON.Free()
+		}
+	}
+	if v.Kind() == KindObject {
+		if unboxed, ok := e.unbox(v); ok {
+			v.Free()
+			v = unboxed
+		}
+	}
+	return v, nil
+}
+
+// skipped reports whether a resolved value is left out of objects and written as null in arrays.
+func skipped(v Value) bool {
+	return v.IsUndefined() || v.IsFunction() || v.IsSymbol()
+}
+
+// encode writes a resolved value, which must not be skipped.
+func (e *jsonEncoder) encode(v Value) error {
+	switch {
+	case v.IsNull():
+		e.w.WriteString("null")
+	case v.IsBool():
+		e.w.WriteString(strconv.FormatBool(v.Bool()))
+	case v.IsNumber():
+		if f := v.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			e.w.WriteString("null")
+		} else {
+			e.w.WriteString(v.String())
+		}
+	case v.IsString():
+		e.writeString(v.goString())
+	case v.IsBigInt():
+		return errors.New("quickjs: BigInt value can't be serialized in JSON")
+	case v.IsObject():
+		for _, s := range e.stack {
+			if s.StrictEquals(v) {
+				return errors.New("quickjs: circular reference in JSON")
+			}
+		}
+		e.stack = append(e.stack, v)
+		defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+		if v.IsArray() {
+			return e.encodeArray(v)
+		}
+		return e.encodeObject(v)
+	}
+	return nil
+}
+
+// encodeProperty writes the value of the property key, or null in place of a skipped value if orNull is set.
+func (e *jsonEncoder) encodeProperty(item Value, key string, orNull bool) error {
+	v, err := e.resolve(item, key)
+	if err != nil {
+		return err
+	}
+	defer v.Free()
+	if skipped(v) {
+		if orNull {
+			e.w.WriteString("null")
+		}
+		return nil
+	}
+	return e.encode(v)
+}
+
+// unbox returns the primitive value of a Number, String, Boolean or BigInt wrapper object.
+func (e *jsonEncoder) unbox(v Value) (Value, bool) {
+	for _, name := range []string{"Number", "String", "Boolean", "BigInt"} {
+		ctor := e.ctx.Globals().Get(name)
+		is := v.IsInstanceOf(ctor)
+		ctor.Free()
+		if is {
+			ret, err := v.CallE("valueOf")
+			return ret, err == nil
+		}
+	}
+	return Value{}, false
+}
+
+func (e *jsonEncoder) encodeArray(v Value) error {
+	e.w.WriteByte('[')
+	n := v.Len()
+	for i := int64(0); i < n; i++ {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		index := strconv.FormatInt(i, 10)
+		item, err := v.GetE(index)
+		if err != nil {
+			return err
+		}
+		err = e.encodeProperty(item, index, true)
+		item.Free()
+		if err != nil {
+			return err
+		}
+	}
+	e.w.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) encodeObject(v Value) error {
+	objectCtor := e.ctx.Globals().Get("Object")
+	keys, err := objectCtor.CallE("keys", v)
+	objectCtor.Free()
+	if err != nil {
+		return err
+	}
+	defer keys.Free()
+	e.w.WriteByte('{')
+	first := true
+	for i, n := int64(0), keys.Len(); i < n; i++ {
+		key := keys.GetIdx(i)
+		name := key.goString()
+		key.Free()
+		item, err := v.GetE(name)
+		if err != nil {
+			return err
+		}
+		resolved, err := e.resolve(item, name)
+		item.Free()
+		if err != nil {
+			return err
+		}
+		if !skipped(resolved) {
+			if !first {
+				e.w.WriteByte(',')
+			}
+			first = false
+			e.writeString(name)
+			e.w.WriteByte(':')
+			err = e.encode(resolved)
+		}
+		resolved.Free()
+		if err != nil {
+			return err
+		}
+	}
+	e.w.WriteByte('}')
+	return nil
+}
+
+// writeString writes s as a JSON string, escaping it as JSON.stringify does.
+func (e *jsonEncoder) writeString(s string) {
+	const hex = "0123456789abcdef"
+	e.w.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		e.w.WriteString(s[start:i])
+		switch c {
+		case '"', '\\':
+			e.w.WriteByte('\\')
+			e.w.WriteByte(c)
+		case '\b':
+			e.w.WriteString(`\b`)
+		case '\f':
+			e.w.WriteString(`\f`)
+		case '\n':
+			e.w.WriteString(`\n`)
+		case '\r':
+			e.w.WriteString(`\r`)
+		case '\t':
+			e.w.WriteString(`\t`)
+		default:
+			e.w.WriteString(`\u00`)
+			e.w.WriteByte(hex[c>>4])
+			e.w.WriteByte(hex[c&0xf])
+		}
+		start = i + 1
+	}
+	e.w.WriteString(s[start:])
+	e.w.WriteByte('"')
+}
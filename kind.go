@@ -0,0 +1,136 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// Kind is the type of a value, as returned by Value.Kind.
+type Kind int
+
+const (
+	KindUndefined Kind = iota
+	KindNull
+	KindBool
+	KindNumber
+	KindBigInt
+	KindString
+	KindSymbol
+	KindObject // any object without a more specific kind
+	KindArray
+	KindFunction
+	KindError
+	KindPromise
+	KindDate
+	KindRegExp
+	KindTypedArray
+	KindArrayBuffer // ArrayBuffer or SharedArrayBuffer
+	KindMap
+	KindSet
+	KindModule
+	KindBigFloat
+	KindBigDecimal
+)
+
+var kindNames = [...]string{
+	"Undefined", "Null", "Bool", "Number", "BigInt", "String", "Symbol", "Object", "Array", "Function", "Error",
+	"Promise", "Date", "RegExp", "TypedArray", "ArrayBuffer", "Map", "Set", "Module", "BigFloat", "BigDecimal",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "Kind(?)"
+	}
+	return kindNames[k]
+}
+
+// Kind returns the type of the value. Objects are classified by their internal class, so subclass instances have
+// the kind of their built-in base class and Symbol.toStringTag is ignored; Proxy objects are Object, except for
+// proxies of arrays and functions.
+func (v Value) Kind() Kind {
+	switch C.ValueGetTag(v.ref) {
+	case C.JS_TAG_NULL:
+		return KindNull
+	case C.JS_TAG_BOOL:
+		return KindBool
+	case C.JS_TAG_INT, C.JS_TAG_FLOAT64:
+		return KindNumber
+	case C.JS_TAG_BIG_INT:
+		return KindBigInt
+	case C.JS_TAG_BIG_FLOAT:
+		return KindBigFloat
+	case C.JS_TAG_BIG_DECIMAL:
+		return KindBigDecimal
+	case C.JS_TAG_STRING:
+		return KindString
+	case C.JS_TAG_SYMBOL:
+		return KindSymbol
+	case C.JS_TAG_MODULE:
+		return KindModule
+	case C.JS_TAG_OBJECT:
+		switch {
+		case v.IsArray():
+			return KindArray
+		case v.IsFunction():
+			return KindFunction
+		}
+		if kind, ok := v.ctx.classKinds()[C.JS_GetClassID(v.ref)]; ok {
+			return kind
+		}
+		return KindObject
+	}
+	if C.JS_IsNumber(v.ref) == 1 {
+		return KindNumber // NaN-boxed float
+	}
+	return KindUndefined
+}
+
+const classKindSamples = `(() => {
+	const samples = [];
+	const add = (kind, create) => {
+		try {
+			samples.push([kind, create()]);
+		} catch (e) {} // missing intrinsic
+	};
+	add("Error", () => new Error());
+	add("Promise", () => new Promise(() => {}));
+	add("Date", () => new Date(0));
+	add("RegExp", () => new RegExp(""));
+	add("ArrayBuffer", () => new ArrayBuffer(0));
+	add("ArrayBuffer", () => new SharedArrayBuffer(0));
+	add("Map", () => new Map());
+	add("Set", () => new Set());
+	for (const name of ["Int8Array", "Uint8Array", "Uint8ClampedArray", "Int16Array", "Uint16Array", "Int32Array",
+		"Uint32Array", "BigInt64Array", "BigUint64Array", "Float32Array", "Float64Array"]) {
+		add("TypedArray", () => new globalThis[name](0));
+	}
+	return samples;
+})()`
+
+// classKinds returns the kinds of the built-in classes by class id, found from sample objects since the engine does
+// not publish the ids.
+func (ctx *Context) classKinds() map[C.JSClassID]Kind {
+	if ctx.kinds != nil {
+		return ctx.kinds
+	}
+	ctx.kinds = make(map[C.JSClassID]Kind)
+	samples, err := ctx.Eval(classKindSamples, EvalFlagInternal(true))
+	if err != nil {
+		return ctx.kinds
+	}
+	defer samples.Free()
+	byName := make(map[string]Kind, len(kindNames))
+	for k, name := range kindNames {
+		byName[name] = Kind(k)
+	}
+	n := samples.Len()
+	for i := int64(0); i < n; i++ {
+		sample := samples.GetIdx(i)
+		kind, obj := sample.GetIdx(0), sample.GetIdx(1)
+		ctx.kinds[C.JS_GetClassID(obj.ref)] = byName[kind.String()]
+		kind.Free()
+		obj.Free()
+		sample.Free()
+	}
+	return ctx.kinds
+}
@@ -0,0 +1,110 @@
+// Package kv defines the "kv" host module of quickjs, exposing a key-value store of the embedder to scripts.
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+// Store is a key-value store exposed to scripts by Install. Implementations are provided by the embedder, backed by a
+// database or a cache; they must be safe for concurrent use if shared by several runtimes.
+type Store interface {
+	// Get returns the value of key; ok is false if the key does not exist or has expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set sets the value of key, expiring after ttl if it is positive.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes key; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns at most limit keys starting with prefix, in lexical order; limit is 0 for all of them.
+	List(ctx context.Context, prefix string, limit int) ([]string, error)
+}
+
+const kvPatch = `(get, set, del, list) => ({
+	get(key, type = "text") {
+		if (typeof type === "object" && type !== null) type = type.type ?? "text";
+		if (type !== "text" && type !== "json" && type !== "arrayBuffer") throw new TypeError("unknown type " + type);
+		const value = get(String(key), type === "arrayBuffer");
+		return value !== null && type === "json" ? JSON.parse(value) : value;
+	},
+	set(key, value, options = {}) {
+		if (typeof value !== "string" && !(value instanceof ArrayBuffer) && !ArrayBuffer.isView(value)) {
+			throw new TypeError("value must be a string, an ArrayBuffer or an ArrayBuffer view");
+		}
+		const ttl = options.expirationTtl === undefined ? 0 : Number(options.expirationTtl);
+		if (!(ttl >= 0)) throw new RangeError("expirationTtl must be a non-negative number of seconds");
+		set(String(key), value, ttl);
+	},
+	delete(key) { del(String(key)); },
+	list({ prefix = "", limit = 0 } = {}) { return list(String(prefix), Number(limit)); },
+})`
+
+// Install defines the "kv" module of ctx, reading and writing store:
+//
+//	import * as kv from "kv";
+//	const count = Number(kv.get("visits") ?? 0) + 1;
+//	kv.set("visits", String(count), { expirationTtl: 3600 });
+//
+// get(key, type) returns the value of key as a string, parsed as JSON if type is "json", or as an ArrayBuffer if type
+// is "arrayBuffer"; it returns null for a missing key. set(key, value, options) sets a string or binary value, expiring
+// after options.expirationTtl seconds if set. delete(key) deletes a key, and list({prefix, limit}) returns the array of
+// the keys starting with prefix. Errors of the store are thrown as JS errors.
+func Install(ctx *quickjs.Context, store Store) error {
+	get := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		value, ok, err := store.Get(context.Background(), args[0].String())
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if !ok {
+			return ctx.Null()
+		}
+		if args[1].Bool() {
+			return ctx.ArrayBuffer(value)
+		}
+		return ctx.String(string(value))
+	})
+	defer get.Free()
+	set := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		value, err := ctx.DataBytes(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		ttl := time.Duration(args[2].Float64() * float64(time.Second))
+		if err := store.Set(context.Background(), args[0].String(), value, ttl); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.Undefined()
+	})
+	defer set.Free()
+	del := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if err := store.Delete(context.Background(), args[0].String()); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.Undefined()
+	})
+	defer del.Free()
+	list := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		keys, err := store.List(context.Background(), args[0].String(), int(args[1].Int32()))
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		arr := ctx.Array().ToValue()
+		for i, key := range keys {
+			arr.SetIdx(int64(i), ctx.String(key))
+		}
+		return arr
+	})
+	defer list.Free()
+
+	patch, err := ctx.Eval(kvPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), get, set, del, list)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("kv", exports, "get", "set", "delete", "list")
+}
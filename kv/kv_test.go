@@ -0,0 +1,105 @@
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKV is a kv.Store keeping values in memory, for tests.
+type memoryKV struct {
+	values  map[string][]byte
+	expires map[string]time.Duration
+}
+
+func (m *memoryKV) Get(_ context.Context, key string) ([]byte, bool, error) {
+	if key == "broken" {
+		return nil, false, errors.New("store unavailable")
+	}
+	v, ok := m.values[key]
+	return v, ok, nil
+}
+
+func (m *memoryKV) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.values[key] = value
+	m.expires[key] = ttl
+	return nil
+}
+
+func (m *memoryKV) Delete(_ context.Context, key string) error {
+	delete(m.values, key)
+	return nil
+}
+
+func (m *memoryKV) List(_ context.Context, prefix string, limit int) ([]string, error) {
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func TestInstall(t *testing.T) {
+	store := &memoryKV{values: map[string][]byte{"visits": []byte("41")}, expires: map[string]time.Duration{}}
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, kv.Install(ctx, store))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import * as kv from "kv";
+		const visits = Number(kv.get("visits")) + 1;
+		kv.set("visits", String(visits), { expirationTtl: 1.5 });
+		kv.set("user:1", JSON.stringify({ name: "Ann" }));
+		kv.set("user:2", new Uint8Array([104, 105]));
+		kv.set("blob", new Uint8Array([0, 1, 2, 3]).buffer);
+		kv.delete("blob");
+		kv.delete("missing");
+		globalThis.result = {
+			visits: kv.get("visits"),
+			user: kv.get("user:1", "json"),
+			bytes: [...new Uint8Array(kv.get("user:2", { type: "arrayBuffer" }))],
+			text: kv.get("user:2"),
+			missing: kv.get("missing", "json"),
+			users: kv.list({ prefix: "user:" }),
+			first: kv.list({ limit: 1 }),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"visits": "42", "user": {"name": "Ann"}, "bytes": [104, 105], "text": "hi", "missing": null,
+		"users": ["user:1", "user:2"], "first": ["user:1"]}`, ret.String())
+	ret.Free()
+	require.Equal(t, 1500*time.Millisecond, store.expires["visits"])
+	require.Zero(t, store.expires["user:1"])
+	require.NotContains(t, store.values, "blob")
+
+	for src, want := range map[string]string{
+		`kv.get("broken")`:                        "Error: store unavailable",
+		`kv.set("k", {})`:                         "TypeError: value must be a string, an ArrayBuffer or an ArrayBuffer view",
+		`kv.set("k", "v", { expirationTtl: -1 })`: "RangeError: expirationTtl must be a non-negative number of seconds",
+		`kv.get("visits", "blob")`:                "TypeError: unknown type blob",
+	} {
+		_, err := ctx.Eval(`import("kv").then((kv) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,118 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// UnfreedValue is a tracked value that has not been freed.
+type UnfreedValue struct {
+	// Stack is the Go call stack that created the value.
+	Stack string
+}
+
+// LeakHandler is called by Context.Close with the tracked values of the context that were not freed.
+type LeakHandler func(ctx *Context, leaks []UnfreedValue)
+
+// SetTrackValues enables or disables leak tracking. While enabled, the creation stack of every reference-counted value
+// returned by the API (Eval, Get, Call, String, Object, ...) is recorded until the value is freed or handed over to
+// JS (Set, SetIdx, Throw, Await, returned from a Go function), and Context.Close reports the values left.
+// Tracking is meant for debugging: it slows down every value creation.
+func (r Runtime) SetTrackValues(track bool) {
+	r.state.trackValues = track
+}
+
+// SetLeakHandler sets the function receiving the unfreed values reported by Context.Close; by default they are
+// written to standard error.
+func (r Runtime) SetLeakHandler(handler LeakHandler) {
+	r.state.leakHandler = handler
+}
+
+// UnfreedValues returns the tracked values of the context that have not been freed yet.
+func (ctx *Context) UnfreedValues() []UnfreedValue {
+	var leaks []UnfreedValue
+	for _, stacks := range ctx.tracked {
+		for _, pcs := range stacks {
+			leaks = append(leaks, UnfreedValue{Stack: formatStack(pcs)})
+		}
+	}
+	return leaks
+}
+
+// track records the creation stack of v if value tracking is enabled, and attaches an owner to v in auto-free mode.
+func (ctx *Context) track(v Value) Value {
+	if C.ValueHasRefCount(v.ref) == 0 {
+		return v
+	}
+	if ctx.runtime.options.autoFree || ctx.realm {
+		ctx.runtime.freeCollected()
+		v = ctx.own(v)
+	}
+	if !ctx.runtime.state.trackValues {
+		return v
+	}
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	if ctx.tracked == nil {
+		ctx.tracked = make(map[uintptr][][]uintptr)
+	}
+	ptr := uintptr(C.ValueGetPtr(v.ref))
+	ctx.tracked[ptr] = append(ctx.tracked[ptr], pcs)
+	return v
+}
+
+// untrack forgets one tracked reference to v.
+func (ctx *Context) untrack(v Value) {
+	ctx.disown(v)
+	if len(ctx.tracked) == 0 || C.ValueHasRefCount(v.ref) == 0 {
+		return
+	}
+	ptr := uintptr(C.ValueGetPtr(v.ref))
+	stacks := ctx.tracked[ptr]
+	switch len(stacks) {
+	case 0:
+	case 1:
+		delete(ctx.tracked, ptr)
+	default:
+		ctx.tracked[ptr] = stacks[:len(stacks)-1]
+	}
+}
+
+// reportLeaks passes the unfreed values to the leak handler.
+func (ctx *Context) reportLeaks() {
+	leaks := ctx.UnfreedValues()
+	ctx.tracked = nil
+	if len(leaks) == 0 {
+		return
+	}
+	if ctx.runtime.state.leakHandler != nil {
+		ctx.runtime.state.leakHandler(ctx, leaks)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "quickjs: %d values not freed before Context.Close\n", len(leaks))
+	for _, leak := range leaks {
+		fmt.Fprintf(os.Stderr, "value created at:\n%s\n", leak.Stack)
+	}
+}
+
+// formatStack formats a call stack, skipping the frames of this package.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/buke/quickjs-go.") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,380 @@
+package quickjs
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits caps the sizes of values that scripts can request with a single call; zero fields are not limited. The
+// engine itself only bounds allocations with the memory limit, so the built-in methods able to build large values
+// from small inputs are replaced with checking versions, which throw a RangeError before building the value.
+//
+// The length limits do not cover string concatenation with +, Array.prototype.concat, push, splice and fill, growing
+// an array by setting its length or an index, the spread syntax, JSON.stringify, TypedArray.prototype.join and the
+// values built by host functions: MaxAllocation is the hard cap for them.
+type Limits struct {
+	// MaxStringLength caps the length of the strings built by String.prototype.repeat, padStart, padEnd, concat
+	// (which template literals call), replace and replaceAll, RegExp.prototype[Symbol.replace] and
+	// Array.prototype.join, checked from the lengths of their inputs and replacements before the result is allocated.
+	MaxStringLength int
+	// MaxArrayLength caps the length of the arrays created by Array(n) and Array.from, and of the ArrayBuffers and
+	// typed arrays created with a length.
+	MaxArrayLength int
+	// MaxAllocation caps the size in bytes of any single allocation of the runtime, whatever the operation: a string
+	// of n characters takes n or 2n bytes, an array of n elements 16n bytes. An allocation over it fails like one
+	// over the memory limit, throwing an InternalError matching ErrOutOfMemory. It also applies to the compilation
+	// of scripts and to the values created by Go code.
+	MaxAllocation int
+	// MaxRegExpInput caps the length of the strings a regular expression is executed on.
+	MaxRegExpInput int
+	// SafeRegExp rejects, when they are executed, the regular expressions that can backtrack exponentially: those
+	// with nested unbounded quantifiers such as (a+)+, and those repeating an alternation whose branches can start
+	// with the same character such as (a|a)* or (a|ab)+. It is a heuristic on the source of the expression: it may
+	// reject safe expressions, such as repeated alternations of character classes, and it misses the polynomial
+	// backtracking of adjacent quantifiers such as \d*\d*x, as well as backreferences and lookarounds. The execution
+	// of a regular expression cannot be interrupted by the execute timeout or an interrupt handler, so this and
+	// MaxRegExpInput are the only protections against ReDoS.
+	SafeRegExp bool
+}
+
+// WithLimits will set the limits of the runtime's contexts.
+func WithLimits(limits Limits) Option {
+	return func(o *Options) {
+		o.limits = limits
+	}
+}
+
+const limitsPatch = `(maxString, maxArray, maxInput, safeRegExp, isSafe) => {
+	const define = (obj, name, fn) => Object.defineProperty(obj, name, { value: fn, writable: true, configurable: true });
+	const check = (what, n, max) => {
+		if (max && n > max) throw new RangeError(what + " length exceeds the limit of " + max);
+	};
+	// str converts like the built-in methods, which throw for symbols.
+	const str = (v) => {
+		if (typeof v === "symbol") throw new TypeError("cannot convert symbol to string");
+		return String(v);
+	};
+
+	if (maxString) {
+		const S = String.prototype;
+		const { repeat, padStart, padEnd, concat } = S;
+		define(S, "repeat", function (count) {
+			const s = String(this);
+			check("string", s.length * Math.trunc(Number(count)), maxString);
+			return repeat.call(s, count);
+		});
+		define(S, "padStart", function (length, fill) {
+			check("string", Number(length), maxString);
+			return padStart.call(this, length, fill);
+		});
+		define(S, "padEnd", function (length, fill) {
+			check("string", Number(length), maxString);
+			return padEnd.call(this, length, fill);
+		});
+		define(S, "concat", function (...args) {
+			if (this == null) return concat.call(this);
+			const s = str(this), parts = args.map(str);
+			check("string", parts.reduce((n, p) => n + p.length, s.length), maxString);
+			return concat.apply(s, parts);
+		});
+
+		// substitute expands the $ patterns of a replacement string for the arguments of a replacer function.
+		const substitute = (r, args) => {
+			let n = args.length, groups;
+			if (typeof args[n - 1] !== "string") groups = args[--n];
+			const s = args[n - 1], position = args[n - 2], matched = args[0], m = n - 3;
+			let out = "";
+			for (let i = 0; i < r.length; i++) {
+				const c = r[i + 1];
+				if (r[i] !== "$" || c === undefined) {
+					out += r[i];
+				} else if (c === "$") {
+					out += "$", i++;
+				} else if (c === "&") {
+					out += matched, i++;
+				} else if (c === "` + "`" + `") {
+					out += s.slice(0, position), i++;
+				} else if (c === "'") {
+					out += s.slice(position + matched.length), i++;
+				} else if (c >= "0" && c <= "9") {
+					let k = c.charCodeAt(0) - 48, skip = 1;
+					const d = r[i + 2];
+					if (d >= "0" && d <= "9" && k * 10 + d.charCodeAt(0) - 48 >= 1 && k * 10 + d.charCodeAt(0) - 48 <= m) {
+						k = k * 10 + d.charCodeAt(0) - 48, skip = 2;
+					}
+					if (k >= 1 && k <= m) {
+						if (args[k] !== undefined) out += args[k];
+						i += skip;
+					} else {
+						out += "$";
+					}
+				} else if (c === "<" && groups !== undefined && r.indexOf(">", i) >= 0) {
+					const end = r.indexOf(">", i), v = groups[r.slice(i + 2, end)];
+					if (v !== undefined) out += str(v);
+					i = end;
+				} else {
+					out += "$";
+				}
+			}
+			return out;
+		};
+		// replacer returns a replacer function checking the length of the result as it is built: the parts of s
+		// kept and the replacements cannot exceed it.
+		const replacer = (s, replaceValue) => {
+			let fn = replaceValue;
+			if (typeof fn !== "function") {
+				const r = str(replaceValue);
+				fn = (...args) => substitute(r, args);
+			}
+			let total = s.length;
+			return (...args) => {
+				const piece = str(fn(...args));
+				total += piece.length;
+				check("string", total, maxString);
+				return piece;
+			};
+		};
+		for (const name of ["replace", "replaceAll"]) {
+			const fn = S[name];
+			define(S, name, function (pattern, replaceValue) {
+				// Patterns defining Symbol.replace, such as regular expressions, build the result themselves.
+				if (this == null || (pattern != null && pattern[Symbol.replace] !== undefined)) {
+					return fn.call(this, pattern, replaceValue);
+				}
+				const s = str(this);
+				return fn.call(s, pattern, replacer(s, replaceValue));
+			});
+		}
+		if (typeof RegExp === "function") {
+			const R = RegExp.prototype, replace = R[Symbol.replace];
+			define(R, Symbol.replace, function (s, replaceValue) {
+				s = str(s);
+				return replace.call(this, s, replacer(s, replaceValue));
+			});
+		}
+
+		const join = Array.prototype.join;
+		define(Array.prototype, "join", function (separator) {
+			if (this == null) return join.call(this);
+			const o = Object(this), length = Math.min(Math.max(Math.trunc(Number(o.length)) || 0, 0), Number.MAX_SAFE_INTEGER);
+			const sep = separator === undefined ? "," : str(separator);
+			let s = "";
+			for (let i = 0; i < length; i++) {
+				const v = o[i], piece = v == null ? "" : str(v);
+				if (i > 0) {
+					check("string", s.length + sep.length + piece.length, maxString);
+					s += sep;
+				} else {
+					check("string", piece.length, maxString);
+				}
+				s += piece;
+			}
+			return s;
+		});
+	}
+
+	if (maxArray) {
+		const length = (args) => args.length === 1 && typeof args[0] === "number" ? args[0] : 0;
+		const limit = (name) => {
+			const C = globalThis[name];
+			if (typeof C !== "function") return;
+			// A function rather than a Proxy, which the context may lack.
+			const Limited = function (...args) {
+				check("array", length(args), maxArray);
+				return new.target ? Reflect.construct(C, args, new.target === Limited ? C : new.target) : C(...args);
+			};
+			Object.defineProperties(Limited, Object.getOwnPropertyDescriptors(C));
+			define(C.prototype, "constructor", Limited);
+			globalThis[name] = Limited;
+		};
+		const from = Array.from;
+		define(Array, "from", function (items, ...rest) {
+			if (items != null && typeof items[Symbol.iterator] !== "function") check("array", Number(items.length), maxArray);
+			return from.call(this, items, ...rest);
+		});
+		for (const name of ["Array", "ArrayBuffer", "SharedArrayBuffer", "Int8Array", "Uint8Array", "Uint8ClampedArray",
+			"Int16Array", "Uint16Array", "Int32Array", "Uint32Array", "BigInt64Array", "BigUint64Array", "Float32Array",
+			"Float64Array"]) limit(name);
+	}
+
+	if ((maxInput || safeRegExp) && typeof RegExp === "function") {
+		const R = RegExp.prototype, exec = R.exec, source = Object.getOwnPropertyDescriptor(R, "source").get;
+		const checked = new WeakMap();
+		define(R, "exec", function (s) {
+			s = String(s);
+			check("regular expression input", s.length, maxInput);
+			if (safeRegExp && typeof this === "object" && this !== null) {
+				let safe = checked.get(this);
+				if (safe === undefined) {
+					safe = isSafe(source.call(this));
+					checked.set(this, safe);
+				}
+				if (!safe) throw new RangeError("regular expression may backtrack catastrophically: /" + source.call(this) + "/");
+			}
+			return exec.call(this, s);
+		});
+	}
+}`
+
+// setLimits installs the checks of limits in the context.
+func (ctx *Context) setLimits(limits Limits) error {
+	isSafe := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		return ctx.Bool(safeRegExp(args[0].String()))
+	})
+	defer isSafe.Free()
+
+	patch, err := ctx.Eval(limitsPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret := ctx.Invoke(patch, ctx.Null(), ctx.Int64(int64(limits.MaxStringLength)), ctx.Int64(int64(limits.MaxArrayLength)),
+		ctx.Int64(int64(limits.MaxRegExpInput)), ctx.Bool(limits.SafeRegExp), isSafe)
+	defer ret.Free()
+	if ret.IsException() {
+		return ctx.Exception()
+	}
+	return nil
+}
+
+// regexpAtom is the first atom of an alternative of a regular expression, compared by safeRegExp.
+type regexpAtom struct {
+	class string // the source of an atom matching a set of characters, such as [a-z] or \d, "" for a literal
+	char  rune   // the literal character
+}
+
+// overlaps reports whether two atoms can match the same character, ignoring the case; atoms the check does not
+// understand overlap with any other.
+func (a regexpAtom) overlaps(b regexpAtom) bool {
+	if a.class == "" && b.class == "" {
+		return strings.EqualFold(string(a.char), string(b.char))
+	}
+	if a.class != "" && b.class != "" {
+		return true
+	}
+	if a.class == "" {
+		a, b = b, a
+	}
+	re, err := regexp.Compile(`(?i)^(?:` + a.class + `)$`)
+	return err != nil || re.MatchString(string(b.char))
+}
+
+// regexpGroup is a group of a regular expression parsed by safeRegExp.
+type regexpGroup struct {
+	unbounded bool         // whether the group contains an unbounded quantifier
+	firsts    []regexpAtom // the first atoms of the alternatives of the group
+	starting  bool         // whether the next atom starts an alternative
+}
+
+// safeRegExp reports whether a regular expression source is free of the patterns backtracking exponentially: a group
+// repeated by *, + or {n,} must not contain such a quantifier itself, nor alternatives able to start with the same
+// character.
+func safeRegExp(source string) bool {
+	groups := []regexpGroup{{starting: true}}
+	// quantifier returns the length of the unbounded quantifier at i, or 0.
+	quantifier := func(i int) int {
+		if i >= len(source) {
+			return 0
+		}
+		switch source[i] {
+		case '*', '+':
+			return 1
+		case '{':
+			j := i + 1
+			for j < len(source) && source[j] >= '0' && source[j] <= '9' {
+				j++
+			}
+			if j > i+1 && j+1 < len(source) && source[j] == ',' && source[j+1] == '}' {
+				return j + 2 - i
+			}
+		}
+		return 0
+	}
+	// first records the atom at i if it starts an alternative of the innermost group.
+	first := func(atom regexpAtom) {
+		if g := &groups[len(groups)-1]; g.starting {
+			g.firsts = append(g.firsts, atom)
+			g.starting = false
+		}
+	}
+
+	for i := 0; i < len(source); i++ {
+		switch c := source[i]; c {
+		case '\\':
+			if i+1 < len(source) {
+				if next, size := utf8.DecodeRuneInString(source[i+1:]); next < utf8.RuneSelf && (next >= '0' && next <= '9' ||
+					next|0x20 >= 'a' && next|0x20 <= 'z') {
+					first(regexpAtom{class: source[i : i+2]})
+				} else {
+					first(regexpAtom{char: next})
+					i += size - 1
+				}
+			}
+			i++
+		case '[':
+			start := i
+			for i++; i < len(source) && source[i] != ']'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			first(regexpAtom{class: source[start:min(i+1, len(source))]})
+		case '(':
+			first(regexpAtom{class: "."})
+			groups = append(groups, regexpGroup{starting: true})
+			// Skip the ?:, ?=, ?!, ?<=, ?<! or ?<name> prefix of the group.
+			if i+2 < len(source) && source[i+1] == '?' {
+				switch {
+				case source[i+2] != '<':
+					i += 2
+				case i+3 < len(source) && (source[i+3] == '=' || source[i+3] == '!'):
+					i += 3
+				default:
+					if end := strings.IndexByte(source[i:], '>'); end > 0 {
+						i += end
+					}
+				}
+			}
+		case '|':
+			groups[len(groups)-1].starting = true
+		case ')':
+			if len(groups) == 1 {
+				continue
+			}
+			g := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			if n := quantifier(i + 1); n > 0 {
+				if g.unbounded {
+					return false
+				}
+				for j, a := range g.firsts {
+					for _, b := range g.firsts[j+1:] {
+						if a.overlaps(b) {
+							return false
+						}
+					}
+				}
+				g.unbounded = true
+				i += n - 1
+			}
+			if g.unbounded {
+				groups[len(groups)-1].unbounded = true
+			}
+		default:
+			if quantifier(i) > 0 {
+				groups[len(groups)-1].unbounded = true
+			} else if c != '?' && c != '{' && c != '}' {
+				// Anchors and the dot are understood as matching anything.
+				if c == '.' || c == '^' || c == '$' {
+					first(regexpAtom{class: "."})
+				} else {
+					r, size := utf8.DecodeRuneInString(source[i:])
+					first(regexpAtom{char: r})
+					i += size - 1
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+//go:build system_quickjs
+
+package quickjs
+
+// Links against the QuickJS installed by its Makefile or by a distribution package, in include/quickjs and
+// lib/quickjs under /usr/local or /usr. Other locations are set with CGO_CFLAGS and CGO_LDFLAGS. The library must be
+// QuickJS 2024-01-13 or later built with bignum support (CONFIG_BIGNUM).
+
+/*
+#cgo CFLAGS: -I/usr/local/include/quickjs -I/usr/include/quickjs
+#cgo LDFLAGS: -L/usr/local/lib/quickjs -L/usr/lib/quickjs -lquickjs -lm
+#cgo linux LDFLAGS: -lpthread -ldl
+#cgo windows LDFLAGS: -lpthread
+*/
+import "C"
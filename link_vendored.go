@@ -0,0 +1,20 @@
+//go:build !system_quickjs
+
+package quickjs
+
+// The libraries use pthreads for workers and timers, and dlopen for native modules on Linux: these come from the C
+// library with glibc 2.34 and later and with musl, and from libpthread and libdl with older glibc or static links.
+// On Windows, pthreads come from the winpthreads library of mingw-w64.
+
+/*
+#cgo CFLAGS: -I./deps/include
+#cgo darwin,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/darwin_amd64 -lquickjs -lm
+#cgo darwin,arm64 LDFLAGS: -L${SRCDIR}/deps/libs/darwin_arm64 -lquickjs -lm
+#cgo linux,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/linux_amd64 -lquickjs -lm
+#cgo linux,arm64 LDFLAGS: -L${SRCDIR}/deps/libs/linux_arm64 -lquickjs -lm
+#cgo windows,amd64 LDFLAGS: -L${SRCDIR}/deps/libs/windows_amd64 -lquickjs -lm
+#cgo windows,386 LDFLAGS: -L${SRCDIR}/deps/libs/windows_386 -lquickjs -lm
+#cgo linux LDFLAGS: -lpthread -ldl
+#cgo windows LDFLAGS: -lpthread
+*/
+import "C"
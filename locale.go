@@ -0,0 +1,115 @@
+package quickjs
+
+import (
+	"math"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Without Intl the engine ignores locales, so the locale-sensitive methods of the built-in objects are replaced with
+// Go implementations.
+const localePatch = `(toLocaleString, compare, toUpper, toLower) => {
+	const define = (obj, name, fn) => Object.defineProperty(obj, name, { value: fn, writable: true, configurable: true });
+	const tag = (locales) => Array.isArray(locales) ? locales[0] : locales;
+	const valueOf = Number.prototype.valueOf;
+	define(Number.prototype, "toLocaleString", function (locales) { return toLocaleString(valueOf.call(this), tag(locales)); });
+	define(String.prototype, "localeCompare", function (that, locales) { return compare(String(this), String(that), tag(locales)); });
+	define(String.prototype, "toLocaleUpperCase", function (locales) { return toUpper(String(this), tag(locales)); });
+	define(String.prototype, "toLocaleLowerCase", function (locales) { return toLower(String(this), tag(locales)); });
+}`
+
+// localeState is the locale of a context and the collators created for the locales requested by its scripts.
+type localeState struct {
+	tag       language.Tag
+	collators map[language.Tag]*collate.Collator
+}
+
+// Locale returns the default locale of the locale-sensitive methods of the context, or "" if none was set.
+func (ctx *Context) Locale() string {
+	if ctx.locale == nil {
+		return ""
+	}
+	return ctx.locale.tag.String()
+}
+
+// SetLocale sets the default locale, a BCP 47 language tag, of the locale-sensitive methods of the context
+// (Number.prototype.toLocaleString, String.prototype.localeCompare, toLocaleUpperCase and toLocaleLowerCase). It may
+// be called at any time.
+func (ctx *Context) SetLocale(locale string) error {
+	tag := language.Make(locale)
+	if ctx.locale != nil {
+		ctx.locale.tag = tag
+		return nil
+	}
+	state := &localeState{tag: tag, collators: make(map[language.Tag]*collate.Collator)}
+	if err := ctx.patchLocale(state); err != nil {
+		return err
+	}
+	ctx.locale = state
+	return nil
+}
+
+// patchLocale replaces the locale-sensitive methods of the context with Go implementations using state.
+func (ctx *Context) patchLocale(state *localeState) error {
+	// withTag returns a function calling fn with the locale requested by a script in the argument at index i, or with
+	// the default locale.
+	withTag := func(i int, fn func(ctx *Context, args []Value, t language.Tag) Value) Value {
+		return ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			t := state.tag
+			if args[i].IsString() {
+				var err error
+				if t, err = language.Parse(args[i].String()); err != nil {
+					return ctx.ThrowRangeError("invalid language tag: %s", args[i].String())
+				}
+			}
+			return fn(ctx, args, t)
+		})
+	}
+
+	toLocaleString := withTag(1, func(ctx *Context, args []Value, t language.Tag) Value {
+		x := args[0].Float64()
+		switch {
+		case math.IsNaN(x):
+			return ctx.String("NaN")
+		case math.IsInf(x, 1):
+			return ctx.String("∞")
+		case math.IsInf(x, -1):
+			return ctx.String("-∞")
+		}
+		return ctx.String(message.NewPrinter(t).Sprint(number.Decimal(x, number.MaxFractionDigits(3))))
+	})
+	defer toLocaleString.Free()
+	compare := withTag(2, func(ctx *Context, args []Value, t language.Tag) Value {
+		c, ok := state.collators[t]
+		if !ok {
+			c = collate.New(t)
+			state.collators[t] = c
+		}
+		return ctx.Int32(int32(c.CompareString(args[0].String(), args[1].String())))
+	})
+	defer compare.Free()
+	toUpper := withTag(1, func(ctx *Context, args []Value, t language.Tag) Value {
+		return ctx.String(cases.Upper(t).String(args[0].String()))
+	})
+	defer toUpper.Free()
+	toLower := withTag(1, func(ctx *Context, args []Value, t language.Tag) Value {
+		return ctx.String(cases.Lower(t).String(args[0].String()))
+	})
+	defer toLower.Free()
+
+	patch, err := ctx.Eval(localePatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret := ctx.Invoke(patch, ctx.Null(), toLocaleString, compare, toUpper, toLower)
+	defer ret.Free()
+	if ret.IsException() {
+		return ctx.Exception()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package log defines the "log" host module of quickjs, emitting the structured records of scripts through a
+// slog.Handler.
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+const logPatch = `(enabled, emit) => {
+	const levels = { debug: -4, info: 0, warn: 4, error: 8 };
+	// Errors and BigInts are not serializable by JSON.stringify.
+	const replacer = (key, value) => value instanceof Error ? String(value) : typeof value === "bigint" ? String(value) : value;
+	const logger = (bound) => {
+		const log = (level, msg, fields) => {
+			if (!enabled(level)) return;
+			if (msg !== null && typeof msg === "object") [msg, fields] = ["msg" in msg ? msg.msg : "", msg];
+			if (fields !== null && typeof fields === "object" && "msg" in fields) {
+				const { msg: _, ...rest } = fields;
+				fields = rest;
+			}
+			emit(level, msg === undefined ? "" : String(msg), JSON.stringify({ ...bound, ...fields }, replacer));
+		};
+		const methods = { with: (fields) => logger({ ...bound, ...fields }) };
+		for (const [name, level] of Object.entries(levels)) methods[name] = (msg, fields) => log(level, msg, fields);
+		return methods;
+	};
+	return logger({});
+}`
+
+// Install defines the "log" module of ctx, emitting structured records to handler with attrs, such as the tenant or the
+// name of the script, added to every record. It exports debug, info, warn and error, called with a message and an
+// object of fields, or with the object only, its msg field being the message:
+//
+//	import * as log from "log";
+//	log.info("order placed", { id: 42, total: 9.5 });
+//	log.warn({ msg: "slow request", ms: 1200 });
+//
+// and with(fields), which returns the same functions adding fields to their records. Fields are converted as
+// JSON.stringify would convert them, nested objects becoming groups; errors are converted to strings.
+func Install(ctx *quickjs.Context, handler slog.Handler, attrs ...slog.Attr) error {
+	if len(attrs) > 0 {
+		handler = handler.WithAttrs(attrs)
+	}
+
+	enabled := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.Bool(handler.Enabled(context.Background(), slog.Level(args[0].Int32())))
+	})
+	defer enabled.Free()
+	emit := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		record := slog.NewRecord(time.Now(), slog.Level(args[0].Int32()), args[1].String(), 0)
+		fields, err := jsonAttrs(json.NewDecoder(strings.NewReader(args[2].String())))
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		record.AddAttrs(fields...)
+		if err := handler.Handle(context.Background(), record); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.Undefined()
+	})
+	defer emit.Free()
+
+	patch, err := ctx.Eval(logPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), enabled, emit)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("log", exports, "debug", "info", "warn", "error", "with")
+}
+
+// jsonAttrs decodes a JSON object to attributes, keeping the order of its members.
+func jsonAttrs(dec *json.Decoder) ([]slog.Attr, error) {
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil { // {
+		return nil, err
+	}
+	var attrs []slog.Attr
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		attr, err := jsonAttr(dec, key.(string))
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	_, err := dec.Token() // }
+	return attrs, err
+}
+
+func jsonAttr(dec *json.Decoder, key string) (slog.Attr, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return slog.Attr{}, err
+	}
+	switch raw[0] {
+	case '{':
+		attrs, err := jsonAttrs(json.NewDecoder(strings.NewReader(string(raw))))
+		if err != nil {
+			return slog.Attr{}, err
+		}
+		return slog.Attr{Key: key, Value: slog.GroupValue(attrs...)}, nil
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return slog.String(key, s), err
+	case 't', 'f':
+		return slog.Bool(key, raw[0] == 't'), nil
+	case 'n':
+		return slog.Any(key, nil), nil
+	case '[':
+		var v []interface{}
+		err := json.Unmarshal(raw, &v)
+		return slog.Any(key, v), err
+	}
+	n := json.Number(raw)
+	if i, err := n.Int64(); err == nil {
+		return slog.Int64(key, i), nil
+	}
+	f, err := n.Float64()
+	return slog.Float64(key, f), err
+}
@@ -0,0 +1,50 @@
+package log_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjslog "github.com/buke/quickjs-go/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	require.NoError(t, quickjslog.Install(ctx, handler, slog.String("tenant", "acme"), slog.String("script", "orders.js")))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import * as log from "log";
+		log.debug("not enabled", { skipped: true });
+		log.info("order placed", { id: 42, total: 9.5, items: ["a", "b"], customer: { name: "Ann", vip: true }, note: null });
+		log.warn({ msg: "slow request", ms: 1200, big: 10n });
+		const req = log.with({ requestId: "r1" });
+		req.error("failed", { err: new TypeError("bad input") });
+		req.info();
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	require.Equal(t, `{"level":"INFO","msg":"order placed","tenant":"acme","script":"orders.js","id":42,"total":9.5,"items":["a","b"],"customer":{"name":"Ann","vip":true},"note":null}
+{"level":"WARN","msg":"slow request","tenant":"acme","script":"orders.js","ms":1200,"big":"10"}
+{"level":"ERROR","msg":"failed","tenant":"acme","script":"orders.js","requestId":"r1","err":"TypeError: bad input"}
+{"level":"INFO","msg":"","tenant":"acme","script":"orders.js","requestId":"r1"}
+`, buf.String())
+}
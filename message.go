@@ -0,0 +1,335 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// portMessage is a message in transit between two ports: the serialized data, the shared memory it references and the
+// memory of the transferred ArrayBuffers.
+type portMessage struct {
+	data    []byte
+	json    string // JSON text posted from Go, used instead of data
+	shared  []unsafe.Pointer
+	buffers []transferredBuffer
+}
+
+type transferredBuffer struct {
+	ptr  unsafe.Pointer
+	size C.size_t
+}
+
+// free releases the memory of a message that will not be delivered.
+func (m *portMessage) free() {
+	for _, ptr := range m.shared {
+		C.ReleaseSharedBuffer(ptr)
+	}
+	for _, b := range m.buffers {
+		C.FreeTransferredBuffer(b.ptr)
+	}
+}
+
+// MessagePort is one end of a channel created by NewMessageChannel. Each end is attached to a context, possibly in
+// another runtime, with Context.MessagePort.
+type MessagePort struct {
+	mu        sync.Mutex
+	peer      *MessagePort
+	ctx       *Context
+	obj       C.JSValue // JS port object while attached
+	deliverFn C.JSValue
+	queue     []*portMessage
+	scheduled bool
+	closed    bool
+	detached  bool
+}
+
+// NewMessageChannel returns the two connected ports of a channel. Messages posted to one port are received by the
+// other one, in order.
+func NewMessageChannel() (*MessagePort, *MessagePort) {
+	a, b := &MessagePort{}, &MessagePort{}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// Close closes both ends of the channel; messages not yet delivered are dropped. It is safe to call from any
+// goroutine.
+func (p *MessagePort) Close() {
+	p.close()
+	p.peer.close()
+}
+
+func (p *MessagePort) close() {
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.closed = true
+	p.mu.Unlock()
+	for _, m := range queue {
+		m.free()
+	}
+}
+
+// enqueue queues m for delivery by p.
+func (p *MessagePort) enqueue(m *portMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		m.free()
+		return
+	}
+	p.queue = append(p.queue, m)
+	p.scheduleLocked()
+}
+
+// scheduleLocked makes the context of p deliver the queued messages.
+func (p *MessagePort) scheduleLocked() {
+	if p.ctx == nil || p.scheduled || len(p.queue) == 0 {
+		return
+	}
+	p.scheduled = true
+	p.ctx.DoAsync(func(ctx *Context) error {
+		p.drain()
+		return nil
+	})
+}
+
+// drain delivers the queued messages; it runs on the goroutine of the context of p.
+func (p *MessagePort) drain() {
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.scheduled = false
+	p.mu.Unlock()
+	for i, m := range queue {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			for _, m := range queue[i:] {
+				m.free()
+			}
+			return
+		}
+		p.deliver(m)
+	}
+}
+
+func (p *MessagePort) deliver(m *portMessage) {
+	ctx := p.ctx
+	var data C.JSValue
+	if m.data == nil {
+		data = ctx.ParseJSON(m.json).ref
+		ctx.untrack(Value{ctx: ctx, ref: data})
+	} else {
+		data = C.JS_ReadObject(ctx.ref, (*C.uint8_t)(unsafe.Pointer(&m.data[0])), C.size_t(len(m.data)), C.JS_READ_OBJ_REFERENCE|C.JS_READ_OBJ_SAB)
+	}
+	// The SharedArrayBuffer objects read hold their own references.
+	for _, ptr := range m.shared {
+		C.ReleaseSharedBuffer(ptr)
+	}
+	if C.JS_IsException(data) == 1 {
+		for _, b := range m.buffers {
+			C.FreeTransferredBuffer(b.ptr)
+		}
+		C.JS_FreeValue(ctx.ref, C.JS_GetException(ctx.ref))
+		return
+	}
+	buffers := C.JS_NewArray(ctx.ref)
+	for i, b := range m.buffers {
+		C.JS_SetPropertyUint32(ctx.ref, buffers, C.uint32_t(i), C.NewTransferredArrayBuffer(ctx.ref, b.ptr, b.size))
+	}
+	args := []C.JSValue{data, buffers}
+	ret := C.JS_Call(ctx.ref, p.deliverFn, C.JS_NewNull(), 2, &args[0])
+	if C.JS_IsException(ret) == 1 {
+		C.JS_FreeValue(ctx.ref, C.JS_GetException(ctx.ref))
+	}
+	C.JS_FreeValue(ctx.ref, ret)
+	C.JS_FreeValue(ctx.ref, buffers)
+	C.JS_FreeValue(ctx.ref, data)
+}
+
+// detach frees the JS values of p; it runs on the goroutine of the context of p.
+func (p *MessagePort) detach() {
+	p.mu.Lock()
+	detached := p.detached
+	p.detached = true
+	p.mu.Unlock()
+	if !detached {
+		C.JS_FreeValue(p.ctx.ref, p.obj)
+		C.JS_FreeValue(p.ctx.ref, p.deliverFn)
+	}
+}
+
+const messagePortFactory = `(post, close) => {
+	const isPlain = (v) => Array.isArray(v) || Object.getPrototypeOf(v) === Object.prototype || Object.getPrototypeOf(v) === null;
+	// prepare replaces the transferred buffers and their views with empty placeholder objects in a copy of the
+	// containers of the message, and returns it with the slots describing the placeholders: the serialization keeps
+	// the identity of the placeholders, so the receiver finds them without marking the data. The contents of the
+	// buffers are moved separately.
+	const prepare = (message, transfer) => {
+		const index = new Map(transfer.map((b, i) => [b, i]));
+		const seen = new Map();
+		const slots = [];
+		const walk = (v) => {
+			if (typeof v !== "object" || v === null) return v;
+			if (seen.has(v)) return seen.get(v);
+			let out = v;
+			if (v instanceof ArrayBuffer) {
+				if (index.has(v)) {
+					out = {};
+					slots.push([out, index.get(v)]);
+				}
+			} else if (ArrayBuffer.isView(v)) {
+				if (index.has(v.buffer)) {
+					const length = v instanceof DataView ? v.byteLength : v.length;
+					out = {};
+					slots.push([out, index.get(v.buffer), v.constructor.name, v.byteOffset, length]);
+				}
+			} else if (isPlain(v)) {
+				out = Array.isArray(v) ? [] : {};
+				seen.set(v, out);
+				for (const k of Object.keys(v)) out[k] = walk(v[k]);
+			}
+			seen.set(v, out);
+			return out;
+		};
+		const data = walk(message);
+		return [data, slots];
+	};
+	const restore = ([data, slots], buffers) => {
+		const replaced = new Map(slots.map(([placeholder, i, view, byteOffset, length]) =>
+			[placeholder, view ? new globalThis[view](buffers[i], byteOffset, length) : buffers[i]]));
+		const seen = new Set();
+		const walk = (v) => {
+			if (typeof v !== "object" || v === null) return v;
+			if (replaced.has(v)) return replaced.get(v);
+			if (!seen.has(v) && isPlain(v)) {
+				seen.add(v);
+				for (const k of Object.keys(v)) v[k] = walk(v[k]);
+			}
+			return v;
+		};
+		return walk(data);
+	};
+	const port = {
+		onmessage: null,
+		postMessage(message, transfer = []) {
+			if (!Array.isArray(transfer)) throw new TypeError("transfer must be an array");
+			if (new Set(transfer).size !== transfer.length) throw new TypeError("ArrayBuffer transferred more than once");
+			for (const b of transfer) {
+				if (!(b instanceof ArrayBuffer)) throw new TypeError("only ArrayBuffers can be transferred");
+			}
+			post(transfer.length ? prepare(message, transfer) : message, transfer);
+		},
+		close() {
+			close();
+		},
+	};
+	const deliver = (data, buffers) => {
+		if (buffers.length) data = restore(data, buffers);
+		if (typeof port.onmessage === "function") port.onmessage({ data });
+	};
+	return [port, deliver];
+}`
+
+// MessagePort attaches p to the context and returns its JS object, with postMessage(message, transfer), close()
+// and onmessage like a web MessagePort. Messages are structured clones (see Snapshot for the supported values);
+// SharedArrayBuffers are shared, and the ArrayBuffers listed in transfer are moved instead of serialized: they are
+// detached in the sender and their memory, not copied, is adopted by the receiver. Messages are delivered while the
+// receiving context runs Serve. It throws if p is already attached.
+func (ctx *Context) MessagePort(p *MessagePort) Value {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx != nil {
+		return ctx.ThrowTypeError("MessagePort is already attached")
+	}
+
+	post := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		transfer := make([]Value, args[1].Len())
+		for i := range transfer {
+			transfer[i] = args[1].GetIdx(int64(i))
+			defer transfer[i].Free()
+		}
+		m, ok := ctx.newMessage(args[0], transfer)
+		if !ok {
+			return ctx.Throw(Value{ctx: ctx, ref: C.JS_GetException(ctx.ref)})
+		}
+		p.peer.enqueue(m)
+		return ctx.Undefined()
+	})
+	defer post.Free()
+	closeFn := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		p.Close()
+		p.detach()
+		return ctx.Undefined()
+	})
+	defer closeFn.Free()
+
+	factory, err := ctx.Eval(messagePortFactory, EvalFlagInternal(true))
+	if err != nil {
+		return ctx.ThrowError(err)
+	}
+	defer factory.Free()
+	pair := ctx.Invoke(factory, ctx.Null(), post, closeFn)
+	if pair.IsException() {
+		return pair
+	}
+	defer pair.Free()
+	obj := pair.GetIdx(0)
+	deliverFn := pair.GetIdx(1)
+	ctx.untrack(obj)
+	ctx.untrack(deliverFn)
+
+	p.ctx = ctx
+	p.obj = obj.ref
+	p.deliverFn = deliverFn.ref
+	ctx.closeHooks = append(ctx.closeHooks, func() {
+		p.Close()
+		p.detach()
+	})
+	p.scheduleLocked()
+	return ctx.track(Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, p.obj)})
+}
+
+// newMessage serializes data and moves the memory of the ArrayBuffers of transfer. It leaves an exception pending
+// on failure.
+func (ctx *Context) newMessage(data Value, transfer []Value) (*portMessage, bool) {
+	for _, ab := range transfer {
+		var size C.size_t
+		if C.JS_GetArrayBuffer(ctx.ref, &size, ab.ref) == nil {
+			return nil, false
+		}
+	}
+
+	var size C.size_t
+	var sabTab **C.uint8_t
+	var sabLen C.size_t
+	ptr := C.JS_WriteObject2(ctx.ref, &size, data.ref, C.JS_WRITE_OBJ_REFERENCE|C.JS_WRITE_OBJ_SAB, &sabTab, &sabLen)
+	if ptr == nil {
+		return nil, false
+	}
+	m := &portMessage{data: C.GoBytes(unsafe.Pointer(ptr), C.int(size))}
+	C.js_free(ctx.ref, unsafe.Pointer(ptr))
+	if sabTab != nil {
+		for _, sab := range unsafe.Slice(sabTab, sabLen) {
+			C.DupSharedBuffer(unsafe.Pointer(sab))
+			m.shared = append(m.shared, unsafe.Pointer(sab))
+		}
+		C.js_free(ctx.ref, unsafe.Pointer(sabTab))
+	}
+
+	// The memory is handed over to the receiving runtime as is.
+	for _, ab := range transfer {
+		var b transferredBuffer
+		if b.ptr = C.StealArrayBuffer(ctx.ref, ab.ref, &b.size); b.ptr == nil {
+			m.free()
+			return nil, false
+		}
+		m.buffers = append(m.buffers, b)
+	}
+	return m, true
+}
@@ -0,0 +1,36 @@
+package quickjs
+
+// HostFunc is a Go function called from JS, as bound with Function. Middleware sees the functions bound with
+// AsyncFunction as HostFuncs taking their arguments without the promise.
+type HostFunc func(ctx *Context, this Value, args []Value) Value
+
+// Middleware wraps the calls of Go functions from JS, returning a HostFunc calling next or not.
+type Middleware func(next HostFunc) HostFunc
+
+// Use adds middleware around every call of a Go function from the JS code of the runtime's contexts: the functions
+// bound with Function, FunctionArgs and AsyncFunction, before or after Use, and those of classes and host modules.
+// It suits cross-cutting concerns such as logging, timing, rate limiting or turning panics into exceptions:
+//
+//	rt.Use(func(next quickjs.HostFunc) quickjs.HostFunc {
+//		return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) (ret quickjs.Value) {
+//			defer func() {
+//				if r := recover(); r != nil {
+//					ret = ctx.ThrowError(fmt.Errorf("panic: %v", r))
+//				}
+//			}()
+//			return next(ctx, this, args)
+//		}
+//	})
+//
+// The middleware added first is the outermost one.
+func (r Runtime) Use(middleware ...Middleware) {
+	r.state.middleware = append(r.state.middleware, middleware...)
+}
+
+// wrapHostFunc returns fn wrapped in the middleware of the runtime.
+func (s *runtimeState) wrapHostFunc(fn HostFunc) HostFunc {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		fn = s.middleware[i](fn)
+	}
+	return fn
+}
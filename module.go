@@ -0,0 +1,283 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// ErrNotModule is returned by LoadModuleBytecode and LoadModuleBytecodeAsync when the bytecode is not a module.
+var ErrNotModule = errors.New("quickjs: not a module")
+
+// ModuleOption configures LoadModuleAsync and LoadModuleBytecodeAsync.
+type ModuleOption func(*moduleOptions)
+
+type moduleOptions struct {
+	timeout time.Duration
+}
+
+// ModuleTimeout waits at most d for the evaluation of the module, including its top-level await, pumping the jobs
+// and timers of the context meanwhile.
+func ModuleTimeout(d time.Duration) ModuleOption {
+	return func(o *moduleOptions) {
+		o.timeout = d
+	}
+}
+
+// LoadModuleAsync compiles and evaluates a module, returning its namespace and a promise settled when its evaluation,
+// including top-level await, completes. The evaluation only progresses while the context runs its jobs, so the
+// module's exports may be uninitialized until done is fulfilled. With ModuleTimeout, done is awaited first and err
+// reports the failure of the evaluation or context.DeadlineExceeded; namespace and done are returned in either case.
+func (ctx *Context) LoadModuleAsync(code string, moduleName string, opts ...ModuleOption) (namespace, done Value, err error) {
+	end := ctx.beginEval(moduleName)
+	defer func() { end(err) }()
+	cVal, err := ctx.compileModule(code, moduleName)
+	if err != nil {
+		return ctx.Null(), ctx.Null(), err
+	}
+	return ctx.evalModule(cVal, opts)
+}
+
+// LoadModuleFileAsync is like LoadModuleAsync with the code read from filePath.
+func (ctx *Context) LoadModuleFileAsync(filePath string, moduleName string, opts ...ModuleOption) (namespace, done Value, err error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return ctx.Null(), ctx.Null(), err
+	}
+	return ctx.LoadModuleAsync(string(b), moduleName, opts...)
+}
+
+// LoadModuleBytecodeAsync is like LoadModuleAsync with a module compiled by CompileModule.
+func (ctx *Context) LoadModuleBytecodeAsync(buf []byte, opts ...ModuleOption) (namespace, done Value, err error) {
+	end := ctx.beginEval("<bytecode>")
+	defer func() { end(err) }()
+	cVal, err := ctx.readModule(buf)
+	if err != nil {
+		return ctx.Null(), ctx.Null(), err
+	}
+	return ctx.evalModule(cVal, opts)
+}
+
+// compileModule compiles and resolves a module without evaluating it.
+func (ctx *Context) compileModule(code string, moduleName string) (C.JSValue, error) {
+	source := code
+	code = ctx.instrumentThrows(ctx.instrumentCoverage(moduleName, code))
+
+	codePtr := C.CString(code)
+	defer C.free(unsafe.Pointer(codePtr))
+
+	filenamePtr := C.CString(moduleName)
+	defer C.free(unsafe.Pointer(filenamePtr))
+
+	cFlag := C.JS_EVAL_TYPE_MODULE | C.JS_EVAL_FLAG_COMPILE_ONLY
+	cVal := ctx.eval(codePtr, len(code), filenamePtr, C.int(cFlag), false)
+	if C.JS_IsException(cVal) == 1 {
+		return cVal, diagnose(ctx.Exception(), moduleName, source)
+	}
+	return cVal, ctx.resolveModule(cVal)
+}
+
+// readModule reads and resolves a module compiled to bytecode without evaluating it.
+func (ctx *Context) readModule(buf []byte) (C.JSValue, error) {
+	cbuf := C.CBytes(buf)
+	defer C.free(cbuf)
+	cVal := C.JS_ReadObject(ctx.ref, (*C.uint8_t)(cbuf), C.size_t(len(buf)), C.JS_READ_OBJ_BYTECODE)
+	if C.JS_IsException(cVal) == 1 {
+		return cVal, ctx.Exception()
+	}
+	return cVal, ctx.resolveModule(cVal)
+}
+
+// resolveModule resolves the imports of a compiled module, freeing it on failure.
+func (ctx *Context) resolveModule(cVal C.JSValue) error {
+	if C.ValueGetTag(cVal) != C.JS_TAG_MODULE {
+		C.JS_FreeValue(ctx.ref, cVal)
+		return ErrNotModule
+	}
+	if C.JS_ResolveModule(ctx.ref, cVal) != 0 {
+		C.JS_FreeValue(ctx.ref, cVal)
+		return fmt.Errorf("resolve module failed")
+	}
+	C.js_module_set_import_meta(ctx.ref, cVal, 0, 1)
+	return nil
+}
+
+// evalModule evaluates a resolved module, returning its namespace and the promise of its evaluation.
+func (ctx *Context) evalModule(cVal C.JSValue, opts []ModuleOption) (namespace, done Value, err error) {
+	var options moduleOptions
+	for _, fn := range opts {
+		fn(&options)
+	}
+
+	m := C.ValueGetModule(cVal)
+	done = Value{ctx: ctx, ref: ctx.evalFunction(cVal)}
+	if done.IsException() {
+		return ctx.Null(), ctx.Null(), ctx.Exception()
+	}
+	done = ctx.track(done)
+	namespace = ctx.track(Value{ctx: ctx, ref: C.JS_GetModuleNamespace(ctx.ref, m)})
+	if namespace.IsException() {
+		done.Free()
+		return ctx.Null(), ctx.Null(), ctx.Exception()
+	}
+
+	if options.timeout > 0 {
+		goCtx, cancel := context.WithTimeout(context.Background(), options.timeout)
+		defer cancel()
+		ret, err := ctx.AwaitContext(goCtx, ctx.track(Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, done.ref)}))
+		ret.Free()
+		if err != nil {
+			return namespace, done, err
+		}
+	}
+	return namespace, done, nil
+}
+
+// LoadHostModule loads a module named name exporting the properties names of exports, a value it consumes, so that
+// scripts import functions implemented in Go like any module. The rate limits of the context apply to its exports.
+func (ctx *Context) LoadHostModule(name string, exports Value, names ...string) error {
+	if ctx.rateLimits != nil {
+		if err := ctx.limitExports(name, exports, names); err != nil {
+			exports.Free()
+			return err
+		}
+	}
+	if _, ok := ctx.hostModules[name]; ok {
+		exports.Free()
+		return fmt.Errorf("host module '%s' already loaded", name)
+	}
+	if err := ctx.newHostModule(name, names); err != nil {
+		exports.Free()
+		return err
+	}
+	// The module is evaluated when it is first imported, so its exports are kept on the Go side until then, out of
+	// reach of the scripts that the module policy does not allow to import it.
+	if ctx.hostModules == nil {
+		ctx.hostModules = make(map[string]hostModule)
+	}
+	ctx.untrack(exports)
+	ctx.hostModules[name] = hostModule{exports: exports.ref, names: names}
+	ctx.modules = append(ctx.modules, definedModule{name: name, exports: append([]string{}, names...)})
+	return nil
+}
+
+// hostModule is a module of LoadHostModule not evaluated yet.
+type hostModule struct {
+	exports C.JSValue
+	names   []string
+}
+
+// newHostModule defines the native module name exporting names, set by initHostModule when it is evaluated.
+func (ctx *Context) newHostModule(name string, names []string) error {
+	namePtr := C.CString(name)
+	defer C.free(unsafe.Pointer(namePtr))
+	m := C.NewHostModule(ctx.ref, namePtr)
+	if m == nil {
+		return ctx.Exception()
+	}
+	for _, export := range names {
+		exportPtr := C.CString(export)
+		ret := C.JS_AddModuleExport(ctx.ref, m, exportPtr)
+		C.free(unsafe.Pointer(exportPtr))
+		if ret != 0 {
+			return ctx.Exception()
+		}
+	}
+	return nil
+}
+
+// initHostModule sets the exports of the host module m named name when it is evaluated, returning -1 with a pending
+// exception on failure. A module compiled again by isolated has no exports and is never evaluated.
+func (ctx *Context) initHostModule(m *C.JSModuleDef, name string) int {
+	hm, ok := ctx.hostModules[name]
+	if !ok {
+		ctx.ThrowReferenceError("host module '%s' has no exports", name)
+		return -1
+	}
+	delete(ctx.hostModules, name)
+	defer C.JS_FreeValue(ctx.ref, hm.exports)
+	for _, export := range hm.names {
+		exportPtr := C.CString(export)
+		ret := C.JS_SetModuleExport(ctx.ref, m, exportPtr, C.JS_GetPropertyStr(ctx.ref, hm.exports, exportPtr))
+		C.free(unsafe.Pointer(exportPtr))
+		if ret != 0 {
+			return -1
+		}
+	}
+	return 0
+}
+
+// ModulePolicy decides whether importer may import the module named specifier, returning an error to deny it.
+type ModulePolicy func(importer, specifier string) error
+
+// SetModulePolicy sets a function consulted for every static and dynamic import of the runtime's contexts, including
+// the built-in "std" and "os" modules and the modules already loaded. The specifier is normalized: relative
+// specifiers are resolved against the importer's name, so the policy can match name prefixes safely. A denied import
+// throws a ReferenceError in the importing code. A nil policy allows every import.
+func (r Runtime) SetModulePolicy(policy ModulePolicy) {
+	r.state.modulePolicy = policy
+	r.setModuleLoader()
+}
+
+// setModuleLoader installs the module name normalizer and loader of the runtime.
+func (r Runtime) setModuleLoader() {
+	var normalize, load C.int
+	var flags C.uintptr_t
+	if r.state.modulePolicy != nil {
+		normalize = 1
+	}
+	if r.options.moduleImport {
+		load = 1
+	}
+	if r.options.moduleLoader != nil {
+		flags |= C.MODULE_LOADER_GO
+	}
+	if r.options.nativeModules {
+		flags |= C.MODULE_LOADER_NATIVE
+	}
+	C.SetModuleLoader(r.ref, normalize, load, flags)
+}
+
+// normalizeModuleName resolves a module specifier against the name of the importing module like the engine's default
+// normalizer: specifiers starting with "." are relative to the importer's directory, others are kept as is.
+func normalizeModuleName(base, name string) string {
+	if !strings.HasPrefix(name, ".") {
+		return name
+	}
+	dir := ""
+	if i := strings.LastIndexByte(base, '/'); i >= 0 {
+		dir = base[:i]
+	}
+	for {
+		if rest, ok := strings.CutPrefix(name, "./"); ok {
+			name = rest
+		} else if rest, ok := strings.CutPrefix(name, "../"); ok {
+			if dir == "" {
+				break
+			}
+			last := dir
+			if i := strings.LastIndexByte(dir, '/'); i >= 0 {
+				last = dir[i+1:]
+			}
+			if last == "." || last == ".." {
+				break
+			}
+			dir = strings.TrimSuffix(strings.TrimSuffix(dir, last), "/")
+			name = rest
+		} else {
+			break
+		}
+	}
+	if dir != "" {
+		return dir + "/" + name
+	}
+	return name
+}
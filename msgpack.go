@@ -0,0 +1,403 @@
+package quickjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// MessagePack extension types used for JS values without a MessagePack counterpart. Dates use the standard
+// timestamp extension, -1.
+const (
+	// MsgpackExtBigInt holds a BigInt as a sign byte, 0 or 1 for negative numbers, followed by the big-endian
+	// magnitude.
+	MsgpackExtBigInt int8 = 1
+	// MsgpackExtTypedArray holds a typed array as its RFC 8746 CBOR tag number in one byte, such as 64 for
+	// Uint8Array or 86 for Float64Array, followed by the little-endian elements.
+	MsgpackExtTypedArray int8 = 2
+	// msgpackExtTimestamp is the standard timestamp extension.
+	msgpackExtTimestamp int8 = -1
+)
+
+// MarshalMsgpack encodes the value tree in MessagePack. Objects, arrays and Maps become maps and arrays, Sets become
+// arrays, ArrayBuffers become binary data, and BigInts, typed arrays and dates use the extension types
+// MsgpackExtBigInt, MsgpackExtTypedArray and the timestamp extension; undefined becomes nil. Object properties
+// holding functions or symbols are left out, and circular structures are an error.
+func (v Value) MarshalMsgpack() ([]byte, error) {
+	var enc msgpackEncoder
+	if err := v.ctx.encodeTree(v, &enc); err != nil {
+		return nil, err
+	}
+	return enc.buf, nil
+}
+
+// UnmarshalMsgpack decodes MessagePack encoded by MarshalMsgpack or by other encoders into a value. Maps with string
+// keys become objects, other maps become Maps, and integers that a float64 cannot represent exactly become BigInts.
+func (ctx *Context) UnmarshalMsgpack(data []byte) (Value, error) {
+	d := msgpackDecoder{b: NewTreeBuilder(ctx), data: data}
+	v, err := d.next(0)
+	if err != nil {
+		return ctx.Null(), err
+	}
+	if d.pos != len(d.data) {
+		v.Free()
+		return ctx.Null(), errors.New("quickjs: unexpected data after MessagePack value")
+	}
+	return v, nil
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) null(bool) { e.buf = append(e.buf, 0xc0) }
+
+func (e *msgpackEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) int(n int64) {
+	switch {
+	case n >= 0 && n <= 0x7f, n < 0 && n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	case n >= 0:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) float(f float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(f))
+}
+
+func (e *msgpackEncoder) bigInt(n *big.Int) {
+	payload := []byte{0}
+	if n.Sign() < 0 {
+		payload[0] = 1
+	}
+	e.ext(MsgpackExtBigInt, append(payload, n.Bytes()...))
+}
+
+func (e *msgpackEncoder) string(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) bytes(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) typedArray(tag uint64, b []byte) {
+	e.ext(MsgpackExtTypedArray, append([]byte{byte(tag)}, b...))
+}
+
+// date writes the timestamp extension in its 96-bit form; invalid dates, which have no time, are written as nil.
+func (e *msgpackEncoder) date(ms float64) {
+	if math.IsNaN(ms) {
+		e.null(false)
+		return
+	}
+	sec := int64(math.Floor(ms / 1000))
+	nsec := uint32((int64(ms) - sec*1000) * int64(time.Millisecond))
+	payload := binary.BigEndian.AppendUint32(nil, nsec)
+	e.ext(msgpackExtTimestamp, binary.BigEndian.AppendUint64(payload, uint64(sec)))
+}
+
+func (e *msgpackEncoder) array(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xdc), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdd), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) object(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xde), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdf), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) ext(typ int8, data []byte) {
+	n := len(data)
+	switch n {
+	case 1:
+		e.buf = append(e.buf, 0xd4)
+	case 2:
+		e.buf = append(e.buf, 0xd5)
+	case 4:
+		e.buf = append(e.buf, 0xd6)
+	case 8:
+		e.buf = append(e.buf, 0xd7)
+	case 16:
+		e.buf = append(e.buf, 0xd8)
+	default:
+		switch {
+		case n <= math.MaxUint8:
+			e.buf = append(e.buf, 0xc7, byte(n))
+		case n <= math.MaxUint16:
+			e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc8), uint16(n))
+		default:
+			e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc9), uint32(n))
+		}
+	}
+	e.buf = append(append(e.buf, byte(typ)), data...)
+}
+
+var errMsgpackTruncated = errors.New("quickjs: truncated MessagePack data")
+
+type msgpackDecoder struct {
+	b    TreeBuilder
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) next(depth int) (Value, error) {
+	if depth > maxTreeDepth {
+		return Value{}, errors.New("quickjs: MessagePack value nested too deeply")
+	}
+	c, err := d.read(1)
+	if err != nil {
+		return Value{}, err
+	}
+	ctx := d.b.ctx
+	switch t := c[0]; {
+	case t <= 0x7f:
+		return ctx.Int64(int64(t)), nil
+	case t >= 0xe0:
+		return ctx.Int64(int64(int8(t))), nil
+	case t >= 0xa0 && t <= 0xbf:
+		return d.str(int(t & 0x1f))
+	case t >= 0x90 && t <= 0x9f:
+		return d.array(int(t&0x0f), depth)
+	case t >= 0x80 && t <= 0x8f:
+		return d.mapValue(int(t&0x0f), depth)
+	case t == 0xc0:
+		return ctx.Null(), nil
+	case t == 0xc2, t == 0xc3:
+		return ctx.Bool(t == 0xc3), nil
+	case t >= 0xcc && t <= 0xcf:
+		n, err := d.uint(1 << (t - 0xcc))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.b.Int(new(big.Int).SetUint64(n))
+	case t >= 0xd0 && t <= 0xd3:
+		size := 1 << (t - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return Value{}, err
+		}
+		shift := 64 - 8*size
+		return d.b.Int(big.NewInt(int64(n<<shift) >> shift))
+	case t == 0xca:
+		n, err := d.uint(4)
+		if err != nil {
+			return Value{}, err
+		}
+		return ctx.Float64(float64(math.Float32frombits(uint32(n)))), nil
+	case t == 0xcb:
+		n, err := d.uint(8)
+		if err != nil {
+			return Value{}, err
+		}
+		return ctx.Float64(math.Float64frombits(n)), nil
+	case t >= 0xd9 && t <= 0xdb:
+		n, err := d.uint(1 << (t - 0xd9))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.str(int(n))
+	case t >= 0xc4 && t <= 0xc6:
+		n, err := d.uint(1 << (t - 0xc4))
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := d.read(int(n))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.b.Bytes(b), nil
+	case t == 0xdc, t == 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.array(int(n), depth)
+	case t == 0xde, t == 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.mapValue(int(n), depth)
+	case t >= 0xd4 && t <= 0xd8:
+		return d.ext(1 << (t - 0xd4))
+	case t >= 0xc7 && t <= 0xc9:
+		n, err := d.uint(1 << (t - 0xc7))
+		if err != nil {
+			return Value{}, err
+		}
+		return d.ext(int(n))
+	}
+	return Value{}, fmt.Errorf("quickjs: invalid MessagePack type byte 0x%02x", c[0])
+}
+
+func (d *msgpackDecoder) str(n int) (Value, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return Value{}, err
+	}
+	return d.b.ctx.stringLen(string(b)), nil
+}
+
+func (d *msgpackDecoder) array(n, depth int) (Value, error) {
+	if n > len(d.data)-d.pos {
+		return Value{}, errMsgpackTruncated
+	}
+	items := make([]Value, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(items)
+			return Value{}, err
+		}
+		items = append(items, item)
+	}
+	return d.b.Array(items), nil
+}
+
+func (d *msgpackDecoder) mapValue(n, depth int) (Value, error) {
+	if n > len(d.data)-d.pos {
+		return Value{}, errMsgpackTruncated
+	}
+	keys := make([]Value, 0, n)
+	values := make([]Value, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(keys)
+			freeValues(values)
+			return Value{}, err
+		}
+		keys = append(keys, key)
+		value, err := d.next(depth + 1)
+		if err != nil {
+			freeValues(keys)
+			freeValues(values)
+			return Value{}, err
+		}
+		values = append(values, value)
+	}
+	return d.b.Map(keys, values), nil
+}
+
+func (d *msgpackDecoder) ext(n int) (Value, error) {
+	typ, err := d.read(1)
+	if err != nil {
+		return Value{}, err
+	}
+	data, err := d.read(n)
+	if err != nil {
+		return Value{}, err
+	}
+	switch int8(typ[0]) {
+	case msgpackExtTimestamp:
+		var sec int64
+		var nsec uint64
+		switch n {
+		case 4:
+			sec = int64(binary.BigEndian.Uint32(data))
+		case 8:
+			v := binary.BigEndian.Uint64(data)
+			nsec, sec = v>>34, int64(v&(1<<34-1))
+		case 12:
+			nsec, sec = uint64(binary.BigEndian.Uint32(data)), int64(binary.BigEndian.Uint64(data[4:]))
+		default:
+			return Value{}, errors.New("quickjs: invalid MessagePack timestamp")
+		}
+		return d.b.Date(float64(sec)*1000 + float64(nsec/uint64(time.Millisecond)))
+	case MsgpackExtBigInt:
+		if n < 1 {
+			return Value{}, errors.New("quickjs: invalid MessagePack BigInt")
+		}
+		i := new(big.Int).SetBytes(data[1:])
+		if data[0] == 1 {
+			i.Neg(i)
+		}
+		return d.b.BigInt(i)
+	case MsgpackExtTypedArray:
+		if n < 1 {
+			return Value{}, errors.New("quickjs: invalid MessagePack typed array")
+		}
+		return d.b.TypedArray(uint64(data[0]), data[1:])
+	}
+	return Value{}, fmt.Errorf("quickjs: unsupported MessagePack extension type %d", int8(typ[0]))
+}
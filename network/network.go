@@ -0,0 +1,250 @@
+// Package network defines the policies deciding which connections the network host modules of quickjs open, such as the
+// http module.
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrHostNotAllowed is returned by policies for the hosts they do not allow.
+var ErrHostNotAllowed = errors.New("network: host not allowed")
+
+// Policy decides which connections the network host modules open, currently the http module. A policy may be shared by
+// the contexts of several runtimes, so that its limits apply to all of them.
+type Policy interface {
+	// AllowURL returns an error wrapping ErrHostNotAllowed if requests to u are not allowed. It is consulted before
+	// sending a request and for every redirect.
+	AllowURL(u *url.URL) error
+	// DialContext opens a connection to address, or returns an error if it is not allowed.
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Options configures the policy created by NewPolicy.
+type Options struct {
+	hosts     []string
+	cidrs     []*net.IPNet
+	maxConns  int
+	bandwidth int64
+	resolver  *net.Resolver
+}
+
+// Option configures the policy created by NewPolicy.
+type Option func(*Options)
+
+// AllowHosts allows connections to the given hosts. A host is a name, such as api.example.com, a name with a port, such
+// as localhost:8080, or a wildcard, such as *.example.com, matching the subdomains of a name.
+func AllowHosts(hosts ...string) Option {
+	return func(o *Options) {
+		for _, host := range hosts {
+			o.hosts = append(o.hosts, strings.ToLower(host))
+		}
+	}
+}
+
+// AllowCIDRs allows connections to the addresses of the given networks, such as 10.0.0.0/8. A host not allowed by
+// AllowHosts is allowed if all its addresses are in these networks; it is then connected to by address, so that it
+// cannot resolve to another address afterwards. Invalid networks are ignored.
+func AllowCIDRs(cidrs ...string) Option {
+	return func(o *Options) {
+		for _, cidr := range cidrs {
+			if _, n, err := net.ParseCIDR(cidr); err == nil {
+				o.cidrs = append(o.cidrs, n)
+			}
+		}
+	}
+}
+
+// MaxConns limits the number of connections open at the same time; dialing waits for a connection to be closed.
+func MaxConns(n int) Option {
+	return func(o *Options) {
+		o.maxConns = n
+	}
+}
+
+// Bandwidth limits the bytes read and written per second by all the connections together.
+func Bandwidth(bytesPerSecond int64) Option {
+	return func(o *Options) {
+		o.bandwidth = bytesPerSecond
+	}
+}
+
+// Resolver sets the resolver of the host names checked against AllowCIDRs; it is net.DefaultResolver by default.
+func Resolver(resolver *net.Resolver) Option {
+	return func(o *Options) {
+		o.resolver = resolver
+	}
+}
+
+// NewPolicy creates a policy allowing the connections to the hosts and networks of opts, within its limits of
+// connections and bandwidth. Without AllowHosts or AllowCIDRs, no connection is allowed.
+func NewPolicy(opts ...Option) Policy {
+	p := &policy{Options: Options{resolver: net.DefaultResolver}}
+	for _, opt := range opts {
+		opt(&p.Options)
+	}
+	if p.maxConns > 0 {
+		p.conns = make(chan struct{}, p.maxConns)
+	}
+	return p
+}
+
+type policy struct {
+	Options
+	conns chan struct{} // a slot by open connection
+	mu    sync.Mutex
+	next  time.Time // when the bytes already transferred are paid for by the bandwidth
+}
+
+func (p *policy) AllowURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u)
+	}
+	if p.allowedHost(u.Host) {
+		return nil
+	}
+	if len(p.cidrs) == 0 {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !p.allowedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+	return nil // names are resolved and checked by DialContext
+}
+
+// allowedHost reports whether hostport, with or without a port, is allowed by AllowHosts.
+func (p *policy) allowedHost(hostport string) bool {
+	hostport = strings.ToLower(hostport)
+	name := hostport
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		name = host
+	}
+	for _, h := range p.hosts {
+		switch {
+		case strings.HasPrefix(h, "*."):
+			if strings.HasSuffix(name, h[1:]) {
+				return true
+			}
+		case strings.Contains(h, ":"):
+			if h == hostport {
+				return true
+			}
+		case h == name:
+			return true
+		}
+	}
+	return false
+}
+
+func (p *policy) allowedIP(ip net.IP) bool {
+	for _, n := range p.cidrs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *policy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if !p.allowedHost(address) {
+		addrs, err := p.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if !p.allowedIP(addr.IP) {
+				return nil, fmt.Errorf("%w: %s (%s)", ErrHostNotAllowed, host, addr.IP)
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		address = net.JoinHostPort(addrs[0].IP.String(), port)
+	}
+
+	if p.conns != nil {
+		select {
+		case p.conns <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		if p.conns != nil {
+			<-p.conns
+		}
+		return nil, err
+	}
+	return &policyConn{Conn: conn, policy: p}, nil
+}
+
+// throttle waits until n more bytes fit in the bandwidth.
+func (p *policy) throttle(n int) {
+	if p.bandwidth <= 0 || n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	wait := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(int64(n) * int64(time.Second) / p.bandwidth))
+	p.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// policyConn is a connection counted and throttled by its policy.
+type policyConn struct {
+	net.Conn
+	policy *policy
+	once   sync.Once
+}
+
+func (c *policyConn) Read(b []byte) (int, error) {
+	if bw := c.policy.bandwidth; bw > 0 && int64(len(b)) > bw {
+		b = b[:bw]
+	}
+	n, err := c.Conn.Read(b)
+	c.policy.throttle(n)
+	return n, err
+}
+
+func (c *policyConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if bw := c.policy.bandwidth; bw > 0 && int64(len(chunk)) > bw {
+			chunk = chunk[:bw]
+		}
+		c.policy.throttle(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *policyConn) Close() error {
+	c.once.Do(func() {
+		if c.policy.conns != nil {
+			<-c.policy.conns
+		}
+	})
+	return c.Conn.Close()
+}
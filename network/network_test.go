@@ -0,0 +1,83 @@
+package network_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjshttp "github.com/buke/quickjs-go/http"
+	"github.com/buke/quickjs-go/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	policy := network.NewPolicy(network.AllowCIDRs("127.0.0.0/8"), network.AllowHosts("*.example.com"))
+	for u, allowed := range map[string]bool{
+		srv.URL:                     true,
+		"https://api.example.com/":  true,
+		"http://10.0.0.1/":          false,
+		"ftp://api.example.com/":    false,
+		"http://localhost.invalid/": true, // resolved when dialing
+	} {
+		parsed, err := url.Parse(u)
+		require.NoError(t, err)
+		if err := policy.AllowURL(parsed); allowed {
+			require.NoError(t, err, u)
+		} else {
+			require.ErrorIs(t, err, network.ErrHostNotAllowed, u)
+		}
+	}
+	_, err := policy.DialContext(context.Background(), "tcp", "localhost.invalid:80")
+	require.Error(t, err)
+	hostsOnly := network.NewPolicy(network.AllowHosts("*.example.com"))
+	require.ErrorIs(t, hostsOnly.AllowURL(&url.URL{Scheme: "https", Host: "example.com"}), network.ErrHostNotAllowed)
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, quickjshttp.Install(ctx, quickjshttp.NetworkPolicy(policy)))
+	ctx.Globals().Set("base", ctx.String(srv.URL))
+	ret, err := ctx.Eval(`import("http").then((http) => http.get(base).body)`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.Equal(t, "pong", ret.String())
+	ret.Free()
+
+	denied := network.NewPolicy(network.AllowCIDRs("10.0.0.0/8"))
+	_, err = denied.DialContext(context.Background(), "tcp", addr)
+	require.ErrorIs(t, err, network.ErrHostNotAllowed)
+
+	limited := network.NewPolicy(network.AllowHosts(addr), network.MaxConns(1),
+		network.Bandwidth(10000))
+	conn, err := limited.DialContext(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = limited.DialContext(waitCtx, "tcp", addr)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	start := time.Now()
+	_, err = conn.Write(bytes.Repeat([]byte("x"), 3000))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("x"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	conn, err = limited.DialContext(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	conn.Close()
+}
@@ -0,0 +1,78 @@
+// Package otel creates OpenTelemetry spans for the script evaluations and Go function calls of a quickjs runtime.
+package otel
+
+import (
+	"context"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys set on the spans.
+const (
+	ScriptKey      = attribute.Key("quickjs.script")
+	MemoryDeltaKey = attribute.Key("quickjs.memory_delta")
+	FunctionKey    = attribute.Key("quickjs.function")
+)
+
+// Install sets trace hooks on rt creating a span for every evaluation and a child span for every Go function call,
+// replacing any trace hooks already set. Measures recorded with performance.measure become child spans as well, and
+// marks recorded with performance.mark events of the current span. parent returns the parent context of top-level
+// evaluations; if parent is nil they are root spans.
+func Install(rt quickjs.Runtime, tracer trace.Tracer, parent func(ctx *quickjs.Context) context.Context) {
+	type entry struct {
+		ctx  context.Context
+		span trace.Span
+	}
+	var stack []entry
+
+	current := func(ctx *quickjs.Context) context.Context {
+		if len(stack) > 0 {
+			return stack[len(stack)-1].ctx
+		}
+		if parent != nil {
+			return parent(ctx)
+		}
+		return context.Background()
+	}
+
+	rt.SetTraceHooks(
+		func(ctx *quickjs.Context, filename string) {
+			spanCtx, span := tracer.Start(current(ctx), "eval "+filename, trace.WithAttributes(ScriptKey.String(filename)))
+			stack = append(stack, entry{spanCtx, span})
+		},
+		func(ctx *quickjs.Context, info quickjs.EvalInfo) {
+			if len(stack) == 0 {
+				return
+			}
+			span := stack[len(stack)-1].span
+			stack = stack[:len(stack)-1]
+			span.SetAttributes(MemoryDeltaKey.Int64(info.MemoryDelta))
+			endSpan(span, info.Err, info.Start.Add(info.Duration))
+		},
+		func(ctx *quickjs.Context, info quickjs.HostCallInfo) {
+			_, span := tracer.Start(current(ctx), "call "+info.Name,
+				trace.WithTimestamp(info.Start), trace.WithAttributes(FunctionKey.String(info.Name)))
+			endSpan(span, info.Err, info.Start.Add(info.Duration))
+		},
+	)
+	rt.SetPerformanceHook(func(ctx *quickjs.Context, entry quickjs.PerformanceEntry) {
+		if entry.EntryType == "mark" {
+			trace.SpanFromContext(current(ctx)).AddEvent(entry.Name, trace.WithTimestamp(entry.Start))
+			return
+		}
+		_, span := tracer.Start(current(ctx), "measure "+entry.Name, trace.WithTimestamp(entry.Start))
+		span.End(trace.WithTimestamp(entry.Start.Add(entry.Duration)))
+	})
+}
+
+func endSpan(span trace.Span, err error, end time.Time) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,89 @@
+package otel_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buke/quickjs-go"
+	quickjsotel "github.com/buke/quickjs-go/otel"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstall(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	quickjsotel.Install(rt, provider.Tracer("test"), nil)
+
+	ctx.Globals().Set("fail", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.ThrowTypeError("bad input")
+	}))
+	ret, err := ctx.Eval(`try { fail() } catch (e) {} [1, 2, 3].map(x => x * 2)`, quickjs.EvalFileName("rule.js"))
+	require.NoError(t, err)
+	ret.Free()
+
+	_, err = ctx.Eval(`throw new Error("boom")`, quickjs.EvalFileName("broken.js"))
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	call, eval, broken := spans[0], spans[1], spans[2]
+	require.Contains(t, call.Name(), "call ")
+	require.Equal(t, codes.Error, call.Status().Code)
+	require.Contains(t, call.Status().Description, "TypeError: bad input")
+	require.Equal(t, eval.SpanContext().SpanID(), call.Parent().SpanID())
+
+	require.Equal(t, "eval rule.js", eval.Name())
+	require.Contains(t, eval.Attributes(), attribute.String("quickjs.script", "rule.js"))
+	require.Equal(t, codes.Unset, eval.Status().Code)
+	require.False(t, eval.Parent().IsValid())
+
+	require.Equal(t, "eval broken.js", broken.Name())
+	require.Equal(t, codes.Error, broken.Status().Code)
+	require.Contains(t, broken.Status().Description, "Error: boom")
+}
+
+func TestInstallPerformance(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, ctx.InstallPerformance())
+
+	quickjsotel.Install(rt, provider.Tracer("test"), nil)
+
+	ret, err := ctx.Eval(`performance.mark("a"); performance.measure("since a", "a")`, quickjs.EvalFileName("timing.js"))
+	require.NoError(t, err)
+	ret.Free()
+
+	var eval, measure sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "eval timing.js":
+			eval = span
+		case "measure since a":
+			measure = span
+		}
+	}
+	require.NotNil(t, eval)
+	require.NotNil(t, measure)
+	require.Equal(t, eval.SpanContext().SpanID(), measure.Parent().SpanID())
+	require.Len(t, eval.Events(), 1)
+	require.Equal(t, "a", eval.Events()[0].Name)
+	require.Equal(t, eval.Events()[0].Time, measure.StartTime())
+}
@@ -0,0 +1,127 @@
+package quickjs
+
+import (
+	"time"
+)
+
+// PerformanceEntry is a mark or measure recorded by a script with the performance global.
+type PerformanceEntry struct {
+	Name string
+	// EntryType is "mark" or "measure".
+	EntryType string
+	Start     time.Time
+	// Duration is zero for marks.
+	Duration time.Duration
+}
+
+// PerformanceHook is called when a script records a performance mark or measure.
+type PerformanceHook func(ctx *Context, entry PerformanceEntry)
+
+// SetPerformanceHook sets the hook called for the performance marks and measures of all contexts of the runtime,
+// for example to report them along with the spans of evaluations; nil removes it.
+func (r Runtime) SetPerformanceHook(hook PerformanceHook) {
+	r.state.performanceHook = hook
+}
+
+const performancePatch = `(timeOrigin, now, record) => {
+	const entries = [];
+	const entry = (name, entryType, startTime, duration, detail) =>
+		Object.freeze({ name, entryType, startTime, duration, detail, toJSON() { return { name, entryType, startTime, duration, detail }; } });
+	const add = (e) => { entries.push(e); record(e.name, e.entryType, e.startTime, e.duration); return e; };
+	const time = (value, what) => {
+		if (typeof value === "number") {
+			if (value < 0) throw new TypeError(what + " cannot be negative");
+			return value;
+		}
+		const name = String(value);
+		for (let i = entries.length - 1; i >= 0; i--) {
+			if (entries[i].entryType === "mark" && entries[i].name === name) return entries[i].startTime;
+		}
+		throw new SyntaxError("The mark '" + name + "' does not exist.");
+	};
+	const clear = (type, name) => {
+		for (let i = entries.length - 1; i >= 0; i--) {
+			if (entries[i].entryType === type && (name === undefined || entries[i].name === name)) entries.splice(i, 1);
+		}
+	};
+	const performance = {
+		timeOrigin,
+		now,
+		mark(name, options = {}) {
+			if (arguments.length === 0) throw new TypeError("mark requires a name");
+			const startTime = options.startTime === undefined ? now() : time(Number(options.startTime), "startTime");
+			return add(entry(String(name), "mark", startTime, 0, options.detail ?? null));
+		},
+		measure(name, startOrOptions, endMark) {
+			if (arguments.length === 0) throw new TypeError("measure requires a name");
+			let start = 0, end, detail = null;
+			if (startOrOptions !== null && typeof startOrOptions === "object") {
+				const { start: s, end: e, duration: d } = startOrOptions;
+				if (endMark !== undefined) throw new TypeError("endMark cannot be passed with measure options");
+				if (s !== undefined && e !== undefined && d !== undefined) throw new TypeError("start, end and duration cannot all be passed");
+				end = e === undefined ? undefined : time(e, "end");
+				if (s !== undefined) start = time(s, "start");
+				if (d !== undefined) {
+					if (s !== undefined) end = start + Number(d);
+					else start = (end ?? now()) - Number(d);
+				}
+				detail = startOrOptions.detail ?? null;
+			} else if (startOrOptions !== undefined) {
+				start = time(startOrOptions, "start");
+			}
+			if (endMark !== undefined) end = time(endMark, "end");
+			if (end === undefined) end = now();
+			return add(entry(String(name), "measure", start, end - start, detail));
+		},
+		getEntries() { return entries.slice(); },
+		getEntriesByName(name, type) { return entries.filter((e) => e.name === String(name) && (type === undefined || e.entryType === type)); },
+		getEntriesByType(type) { return entries.filter((e) => e.entryType === type); },
+		clearMarks(name) { clear("mark", name === undefined ? undefined : String(name)); },
+		clearMeasures(name) { clear("measure", name === undefined ? undefined : String(name)); },
+		toJSON() { return { timeOrigin }; },
+	};
+	Object.defineProperty(performance, Symbol.toStringTag, { value: "Performance", configurable: true });
+	Object.defineProperty(globalThis, "performance", { value: performance, writable: true, configurable: true });
+}`
+
+// InstallPerformance defines the global performance object of the context, with the monotonic clock now(), timeOrigin
+// and the user timing methods mark, measure, getEntries, getEntriesByName, getEntriesByType, clearMarks and
+// clearMeasures. Times are in milliseconds since the call of InstallPerformance, read from the monotonic clock of Go.
+// Marks and measures are passed to the hook set with SetPerformanceHook.
+func (ctx *Context) InstallPerformance() error {
+	origin := time.Now()
+	duration := func(ms float64) time.Duration {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	now := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		return ctx.Float64(float64(time.Since(origin).Microseconds()) / 1e3)
+	})
+	defer now.Free()
+	record := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		if hook := ctx.runtime.state.performanceHook; hook != nil {
+			hook(ctx, PerformanceEntry{
+				Name:      args[0].String(),
+				EntryType: args[1].String(),
+				Start:     origin.Add(duration(args[2].Float64())),
+				Duration:  duration(args[3].Float64()),
+			})
+		}
+		return ctx.Undefined()
+	})
+	defer record.Free()
+	timeOrigin := ctx.Float64(float64(origin.UnixMicro()) / 1e3)
+	defer timeOrigin.Free()
+
+	patch, err := ctx.Eval(performancePatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), timeOrigin, now, record)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
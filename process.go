@@ -0,0 +1,175 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ExitError is returned by an evaluation ended by process.exit.
+type ExitError struct {
+	Code int
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("quickjs: process exited with code %d", err.Code)
+}
+
+// ProcessOptions configures the process object defined by InstallProcess.
+type ProcessOptions struct {
+	allowEnv func(name string) bool
+	argv     []string
+	platform string
+	onExit   func(ctx *Context, code int)
+}
+
+// ProcessOption configures the process object defined by InstallProcess.
+type ProcessOption func(*ProcessOptions)
+
+// ProcessEnv exposes the environment variables of the Go process for which allow returns true in process.env; by
+// default it is empty. Scripts may set and delete variables, which only changes process.env.
+func ProcessEnv(allow func(name string) bool) ProcessOption {
+	return func(o *ProcessOptions) {
+		o.allowEnv = allow
+	}
+}
+
+// ProcessArgv sets process.argv; by default it is empty.
+func ProcessArgv(argv ...string) ProcessOption {
+	return func(o *ProcessOptions) {
+		o.argv = argv
+	}
+}
+
+// ProcessPlatform sets process.platform; by default it is the operating system, named as in Node.js.
+func ProcessPlatform(platform string) ProcessOption {
+	return func(o *ProcessOptions) {
+		o.platform = platform
+	}
+}
+
+// ProcessExit sets a function called with the exit code when a script calls process.exit.
+func ProcessExit(onExit func(ctx *Context, code int)) ProcessOption {
+	return func(o *ProcessOptions) {
+		o.onExit = onExit
+	}
+}
+
+// nodePlatforms and nodeArchs are the names Node.js gives to the values of GOOS and GOARCH, when they differ.
+var (
+	nodePlatforms = map[string]string{"windows": "win32", "solaris": "sunos", "illumos": "sunos"}
+	nodeArchs     = map[string]string{"amd64": "x64", "386": "ia32", "ppc64le": "ppc64", "mipsle": "mipsel"}
+)
+
+const processPatch = `(lookup, names, argv, platform, arch, exit) => {
+	const local = new Map(); // variables set by scripts, undefined when deleted
+	const get = (name) => typeof name !== "string" ? undefined : local.has(name) ? local.get(name) : lookup(name);
+	const env = new Proxy({}, {
+		get: (_, name) => get(name),
+		has: (_, name) => get(name) !== undefined,
+		set: (_, name, value) => { local.set(String(name), String(value)); return true; },
+		defineProperty: (_, name, desc) => { local.set(String(name), String(desc.value)); return true; },
+		deleteProperty: (_, name) => { local.set(String(name), undefined); return true; },
+		ownKeys: () => [...new Set([...names(), ...local.keys()])].filter((name) => get(name) !== undefined),
+		getOwnPropertyDescriptor: (_, name) => {
+			const value = get(name);
+			return value === undefined ? undefined : { value, writable: true, enumerable: true, configurable: true };
+		},
+	});
+	const process = {
+		env,
+		argv,
+		platform,
+		arch,
+		exitCode: undefined,
+		exit(code) { exit(code === undefined ? process.exitCode ?? 0 : Number(code) | 0); },
+		nextTick(fn, ...args) { Promise.resolve().then(() => fn(...args)); },
+	};
+	Object.defineProperty(process, Symbol.toStringTag, { value: "process", configurable: true });
+	Object.defineProperty(globalThis, "process", { value: process, writable: true, configurable: true });
+}`
+
+// InstallProcess defines a global process object for scripts written for Node.js, with env, argv, platform, arch,
+// exitCode, exit and nextTick. The embedder controls what it exposes: process.env only holds the variables allowed
+// with ProcessEnv, and process.exit calls the function set with ProcessExit, then ends the evaluation with an
+// uncatchable exception converted to an *ExitError. Jobs and timers already scheduled are kept.
+func (ctx *Context) InstallProcess(opts ...ProcessOption) error {
+	o := ProcessOptions{platform: runtime.GOOS, argv: []string{}}
+	if p, ok := nodePlatforms[o.platform]; ok {
+		o.platform = p
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	arch := runtime.GOARCH
+	if a, ok := nodeArchs[arch]; ok {
+		arch = a
+	}
+
+	lookup := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		name := args[0].String()
+		if o.allowEnv == nil || !o.allowEnv(name) {
+			return ctx.Undefined()
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return ctx.String(value)
+		}
+		return ctx.Undefined()
+	})
+	defer lookup.Free()
+	names := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		var allowed []string
+		if o.allowEnv != nil {
+			for _, kv := range os.Environ() {
+				if name, _, _ := strings.Cut(kv, "="); name != "" && o.allowEnv(name) {
+					allowed = append(allowed, name)
+				}
+			}
+		}
+		sort.Strings(allowed)
+		arr := ctx.Array().arrayValue
+		for i, name := range allowed {
+			arr.SetIdx(int64(i), ctx.String(name))
+		}
+		return arr
+	})
+	defer names.Free()
+	argvValue := ctx.Array().arrayValue
+	defer argvValue.Free()
+	for i, arg := range o.argv {
+		argvValue.SetIdx(int64(i), ctx.String(arg))
+	}
+	platform, archValue := ctx.String(o.platform), ctx.String(arch)
+	defer platform.Free()
+	defer archValue.Free()
+	exit := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		code := int(args[0].Int32())
+		if o.onExit != nil {
+			o.onExit(ctx, code)
+		}
+		ctx.exited = &ExitError{Code: code}
+		exc := ctx.Error(ctx.exited)
+		ctx.exitedPtr = uintptr(C.ValueGetPtr(exc.ref))
+		C.JS_SetUncatchableError(ctx.ref, exc.ref, 1)
+		return ctx.Throw(exc)
+	})
+	defer exit.Free()
+
+	patch, err := ctx.Eval(processPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), lookup, names, argvValue, platform, archValue, exit)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	return nil
+}
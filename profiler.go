@@ -0,0 +1,265 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Profile is a sampled CPU profile of the JS code executed by a context.
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Period   time.Duration
+	Samples  []ProfileSample
+}
+
+// ProfileSample is a JS call stack captured at a point in time; frames are ordered from the innermost call.
+type ProfileSample struct {
+	Time   time.Time
+	Frames []ProfileFrame
+}
+
+// ProfileFrame is a single frame of a sampled call stack. File is empty for native functions.
+type ProfileFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+type profiler struct {
+	ctx       *Context
+	errorCtor Value
+	last      time.Time
+	profile   *Profile
+}
+
+// StartProfiling starts sampling the JS call stack hz times per second (100 if hz <= 0) while JS code is executing.
+// Samples are taken from the runtime's interrupt handler, so time spent in Go functions is attributed to their JS callers
+// only when JS code resumes; if several contexts of the runtime run code, their frames are sampled as well. Taking a
+// sample allocates an Error object, so no sample is taken while the memory used is within 256 KiB of the memory limit.
+func (ctx *Context) StartProfiling(hz int) {
+	if hz <= 0 {
+		hz = 100
+	}
+	if ctx.runtime.state.profilers == nil {
+		ctx.runtime.state.profilers = make(map[*Context]*profiler)
+	}
+	if p, ok := ctx.runtime.state.profilers[ctx]; ok {
+		p.errorCtor.Free()
+	}
+	now := time.Now()
+	ctx.runtime.state.profilers[ctx] = &profiler{
+		ctx:       ctx,
+		errorCtor: ctx.Globals().Get("Error"),
+		last:      now,
+		profile:   &Profile{Start: now, Period: time.Second / time.Duration(hz)},
+	}
+	ctx.runtime.enableInterrupts()
+}
+
+// StopProfiling stops sampling and returns the collected profile, or nil if the context is not profiling.
+func (ctx *Context) StopProfiling() *Profile {
+	p, ok := ctx.runtime.state.profilers[ctx]
+	if !ok {
+		return nil
+	}
+	delete(ctx.runtime.state.profilers, ctx)
+	p.errorCtor.Free()
+	p.profile.Duration = time.Since(p.profile.Start)
+	return p.profile
+}
+
+// sample records the current JS call stack if a sampling period has elapsed.
+func (p *profiler) sample() {
+	now := time.Now()
+	if now.Sub(p.last) < p.profile.Period {
+		return
+	}
+	p.last = now
+
+	ctx := p.ctx
+	err := Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, p.errorCtor.ref, 0, nil)}
+	if err.IsException() {
+		C.JS_FreeValue(ctx.ref, C.JS_GetException(ctx.ref))
+		return
+	}
+	defer err.Free()
+	stack := err.Get("stack")
+	defer stack.Free()
+
+	sample := ProfileSample{Time: now}
+	for _, f := range parseStack(ctx.remapStack(stack.String())) {
+		frame := ProfileFrame{Function: f.Func, File: f.File, Line: f.Line}
+		if frame.File == "native" {
+			frame.File = ""
+		}
+		sample.Frames = append(sample.Frames, frame)
+	}
+	if len(sample.Frames) > 0 {
+		p.profile.Samples = append(p.profile.Samples, sample)
+	}
+}
+
+// WritePprof writes the profile in the gzip-compressed protocol buffer format read by `go tool pprof`.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := []string{""}
+	strIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		if i, ok := strIndex[s]; ok {
+			return uint64(i)
+		}
+		strIndex[s] = len(strs)
+		strs = append(strs, s)
+		return uint64(len(strs) - 1)
+	}
+
+	var out protobuf
+	valueType := func(field int, typ, unit string) {
+		var vt protobuf
+		vt.uint64(1, str(typ))
+		vt.uint64(2, str(unit))
+		out.bytes(field, vt.Bytes())
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "cpu", "nanoseconds")
+
+	type funcKey struct{ name, file string }
+	funcs := map[funcKey]uint64{}
+	locs := map[ProfileFrame]uint64{}
+	var funcBuf, locBuf protobuf
+	for _, s := range p.Samples {
+		ids := make([]uint64, len(s.Frames))
+		for i, frame := range s.Frames {
+			id, ok := locs[frame]
+			if !ok {
+				key := funcKey{frame.Function, frame.File}
+				fid, ok := funcs[key]
+				if !ok {
+					fid = uint64(len(funcs) + 1)
+					funcs[key] = fid
+					var fn protobuf
+					fn.uint64(1, fid)
+					fn.uint64(2, str(frame.Function))
+					fn.uint64(3, str(frame.Function))
+					fn.uint64(4, str(frame.File))
+					funcBuf.bytes(5, fn.Bytes())
+				}
+				id = uint64(len(locs) + 1)
+				locs[frame] = id
+				var line, loc protobuf
+				line.uint64(1, fid)
+				line.uint64(2, uint64(frame.Line))
+				loc.uint64(1, id)
+				loc.bytes(4, line.Bytes())
+				locBuf.bytes(4, loc.Bytes())
+			}
+			ids[i] = id
+		}
+		var sample protobuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{1, uint64(p.Period)})
+		out.bytes(2, sample.Bytes())
+	}
+	out.Write(locBuf.Bytes())
+	out.Write(funcBuf.Bytes())
+	out.uint64(9, uint64(p.Start.UnixNano()))
+	out.uint64(10, uint64(p.Duration))
+	valueType(11, "cpu", "nanoseconds")
+	out.uint64(12, uint64(p.Period))
+	for _, s := range strs {
+		out.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// WriteChromeTrace writes the profile as Chrome trace events (https://ui.perfetto.dev, chrome://tracing),
+// merging consecutive samples sharing the same frames into one event per call.
+func (p *Profile) WriteChromeTrace(w io.Writer) error {
+	type event struct {
+		Name string            `json:"name"`
+		Cat  string            `json:"cat"`
+		Ph   string            `json:"ph"`
+		Ts   float64           `json:"ts"`
+		Dur  float64           `json:"dur"`
+		Pid  int               `json:"pid"`
+		Tid  int               `json:"tid"`
+		Args map[string]string `json:"args,omitempty"`
+	}
+	micros := func(t time.Time) float64 { return float64(t.Sub(p.Start).Nanoseconds()) / 1e3 }
+
+	events := []event{}
+	var open []ProfileFrame
+	var starts []time.Time
+	closeFrames := func(depth int, at time.Time) {
+		for len(open) > depth {
+			i := len(open) - 1
+			ev := event{Name: open[i].Function, Cat: "js", Ph: "X", Ts: micros(starts[i]), Dur: micros(at) - micros(starts[i]), Pid: 1, Tid: 1}
+			if open[i].File != "" {
+				ev.Args = map[string]string{"file": open[i].File + ":" + strconv.Itoa(open[i].Line)}
+			}
+			events = append(events, ev)
+			open, starts = open[:i], starts[:i]
+		}
+	}
+	for _, s := range p.Samples {
+		depth := 0
+		for depth < len(open) && depth < len(s.Frames) && open[depth] == s.Frames[len(s.Frames)-1-depth] {
+			depth++
+		}
+		closeFrames(depth, s.Time)
+		for i := len(s.Frames) - 1 - depth; i >= 0; i-- {
+			open = append(open, s.Frames[i])
+			starts = append(starts, s.Time)
+		}
+	}
+	if len(p.Samples) > 0 {
+		closeFrames(0, p.Samples[len(p.Samples)-1].Time.Add(p.Period))
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{"traceEvents": events, "displayTimeUnit": "ms"})
+}
+
+// protobuf is a minimal protocol buffer encoder.
+type protobuf struct {
+	bytes.Buffer
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protobuf) packed(field int, xs []uint64) {
+	var buf protobuf
+	for _, x := range xs {
+		buf.varint(x)
+	}
+	b.bytes(field, buf.Bytes())
+}
@@ -0,0 +1,79 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// PropertyDescriptor describes an own property of an object. Data properties have a Value, accessor properties a
+// Getter and a Setter (undefined when missing). The values must be released with Free.
+type PropertyDescriptor struct {
+	Value        Value
+	Getter       Value
+	Setter       Value
+	Writable     bool
+	Enumerable   bool
+	Configurable bool
+	Accessor     bool
+}
+
+// Free frees the values of the descriptor.
+func (d *PropertyDescriptor) Free() {
+	d.Value.Free()
+	d.Getter.Free()
+	d.Setter.Free()
+}
+
+// GetOwnPropertyDescriptor returns the descriptor of the own property name of v, or nil if there is no such property.
+func (v Value) GetOwnPropertyDescriptor(name string) (*PropertyDescriptor, error) {
+	prop := v.ctx.Atom(name)
+	defer prop.Free()
+
+	var desc C.JSPropertyDescriptor
+	switch C.JS_GetOwnProperty(v.ctx.ref, &desc, v.ref, prop.ref) {
+	case -1:
+		return nil, v.ctx.Exception()
+	case 0:
+		return nil, nil
+	}
+	flags := desc.flags
+	return &PropertyDescriptor{
+		Value:        v.ctx.track(Value{ctx: v.ctx, ref: desc.value}),
+		Getter:       v.ctx.track(Value{ctx: v.ctx, ref: desc.getter}),
+		Setter:       v.ctx.track(Value{ctx: v.ctx, ref: desc.setter}),
+		Writable:     flags&C.JS_PROP_WRITABLE != 0,
+		Enumerable:   flags&C.JS_PROP_ENUMERABLE != 0,
+		Configurable: flags&C.JS_PROP_CONFIGURABLE != 0,
+		Accessor:     flags&C.JS_PROP_GETSET != 0,
+	}, nil
+}
+
+// DefineAccessor defines the enumerable, configurable accessor property name on v, computed by Go functions. Either
+// function may be nil. The setter returns undefined, or an exception thrown with ctx.Throw*.
+func (v Value) DefineAccessor(name string, getter func(ctx *Context, this Value) Value, setter func(ctx *Context, this Value, value Value) Value) error {
+	get, set := v.ctx.Undefined(), v.ctx.Undefined()
+	if getter != nil {
+		get = v.ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return getter(ctx, this)
+		})
+		v.ctx.untrack(get)
+	}
+	if setter != nil {
+		set = v.ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			value := ctx.Undefined()
+			if len(args) > 0 {
+				value = args[0]
+			}
+			return setter(ctx, this, value)
+		})
+		v.ctx.untrack(set)
+	}
+
+	prop := v.ctx.Atom(name)
+	defer prop.Free()
+	flags := C.int(C.JS_PROP_ENUMERABLE | C.JS_PROP_CONFIGURABLE | C.JS_PROP_THROW)
+	if C.JS_DefinePropertyGetSet(v.ctx.ref, v.ref, prop.ref, get.ref, set.ref, flags) < 0 {
+		return v.ctx.Exception()
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+// Package protobuf converts protocol buffer messages to JS values and back, so that services handing messages to
+// scripts do not go through JSON themselves.
+//
+// Messages become objects keyed by the JSON names of their fields. Scalars without presence are always set, to
+// their default value if needed, while fields with presence, such as message fields and the members of a oneof, are
+// left out when unset. Integers become numbers, or BigInts beyond the safe integers, bytes become ArrayBuffers, enums
+// become the names of their values, repeated fields become arrays and maps become objects.
+//
+// The well-known types follow the JSON mapping of protocol buffers, except that google.protobuf.Timestamp becomes a
+// Date: wrappers become their value, Duration and FieldMask strings, Struct, Value and ListValue the JSON values they
+// hold, and Any an object with an "@type" property, its message being resolved in protoregistry.GlobalTypes.
+package protobuf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxSafeInteger is the largest integer a number represents exactly, and every smaller one.
+const maxSafeInteger = 1<<53 - 1
+
+// jsonTypes are the well-known types converted through their JSON mapping.
+var jsonTypes = map[protoreflect.FullName]bool{
+	"google.protobuf.Duration":  true,
+	"google.protobuf.FieldMask": true,
+	"google.protobuf.Struct":    true,
+	"google.protobuf.Value":     true,
+	"google.protobuf.ListValue": true,
+	"google.protobuf.Any":       true,
+	"google.protobuf.Empty":     true,
+}
+
+// wrapperTypes are the well-known types wrapping a scalar in their field value.
+var wrapperTypes = map[protoreflect.FullName]bool{
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+// ToValue converts m to a JS value of ctx.
+func ToValue(ctx *quickjs.Context, m proto.Message) (quickjs.Value, error) {
+	v, err := toMessage(ctx, m.ProtoReflect())
+	if err != nil {
+		return v, fmt.Errorf("protobuf: %w", err)
+	}
+	return v, nil
+}
+
+func toMessage(ctx *quickjs.Context, m protoreflect.Message) (quickjs.Value, error) {
+	desc := m.Descriptor()
+	switch name := desc.FullName(); {
+	case name == "google.protobuf.Timestamp":
+		fields := desc.Fields()
+		sec, nsec := m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+		date := ctx.Globals().Get("Date")
+		defer date.Free()
+		return date.New(ctx.Float64(float64(sec)*1000 + float64(nsec/1e6))), nil
+	case wrapperTypes[name]:
+		fd := desc.Fields().ByName("value")
+		return toScalar(ctx, fd, m.Get(fd))
+	case jsonTypes[name]:
+		b, err := protojson.Marshal(m.Interface())
+		if err != nil {
+			return ctx.Null(), err
+		}
+		v := ctx.ParseJSON(string(b))
+		if v.IsException() {
+			return v, ctx.Exception()
+		}
+		return v, nil
+	}
+
+	obj := ctx.Object()
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasPresence() && !m.Has(fd) {
+			continue
+		}
+		v, err := toField(ctx, fd, m.Get(fd))
+		if err != nil {
+			obj.Free()
+			return ctx.Null(), fmt.Errorf("field %s: %w", fd.FullName(), err)
+		}
+		obj.Set(fd.JSONName(), v)
+	}
+	return obj, nil
+}
+
+func toField(ctx *quickjs.Context, fd protoreflect.FieldDescriptor, v protoreflect.Value) (quickjs.Value, error) {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		arr := ctx.Array().ToValue()
+		for i := 0; i < list.Len(); i++ {
+			item, err := toScalar(ctx, fd, list.Get(i))
+			if err != nil {
+				arr.Free()
+				return ctx.Null(), err
+			}
+			arr.SetIdx(int64(i), item)
+		}
+		return arr, nil
+	case fd.IsMap():
+		obj := ctx.Object()
+		var err error
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			var item quickjs.Value
+			if item, err = toScalar(ctx, fd.MapValue(), v); err == nil {
+				obj.Set(k.String(), item)
+			}
+			return err == nil
+		})
+		if err != nil {
+			obj.Free()
+			return ctx.Null(), err
+		}
+		return obj, nil
+	}
+	return toScalar(ctx, fd, v)
+}
+
+func toScalar(ctx *quickjs.Context, fd protoreflect.FieldDescriptor, v protoreflect.Value) (quickjs.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return ctx.Bool(v.Bool()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return ctx.Int32(int32(v.Int())), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return ctx.Uint32(uint32(v.Uint())), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n := v.Int(); n >= -maxSafeInteger && n <= maxSafeInteger {
+			return ctx.Int64(n), nil
+		}
+		return ctx.BigInt64(v.Int()), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n := v.Uint(); n <= maxSafeInteger {
+			return ctx.Int64(int64(n)), nil
+		}
+		return ctx.BigUint64(v.Uint()), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return ctx.Float64(v.Float()), nil
+	case protoreflect.StringKind:
+		return ctx.String(v.String()), nil
+	case protoreflect.BytesKind:
+		return arrayBuffer(ctx, v.Bytes()), nil
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return ctx.Null(), nil
+		}
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return ctx.String(string(ev.Name())), nil
+		}
+		return ctx.Int32(int32(v.Enum())), nil
+	}
+	return toMessage(ctx, v.Message())
+}
+
+func arrayBuffer(ctx *quickjs.Context, b []byte) quickjs.Value {
+	if len(b) == 0 {
+		ctor := ctx.Globals().Get("ArrayBuffer")
+		defer ctor.Free()
+		return ctor.New(ctx.Int32(0))
+	}
+	return ctx.ArrayBuffer(b)
+}
+
+// FromValue sets m from a JS value in the form made by ToValue. Fields may also be named by their names in the
+// .proto file, and missing, undefined or null properties leave their fields unset. Integers are numbers, BigInts or
+// decimal strings, bytes are ArrayBuffers, ArrayBuffer views or base64 strings, enums are names or numbers, and
+// timestamps are Dates, RFC 3339 strings or milliseconds since the epoch. Unknown properties are an error.
+func FromValue(v quickjs.Value, m proto.Message) error {
+	proto.Reset(m)
+	if err := fromMessage(v, m.ProtoReflect()); err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+	return nil
+}
+
+func fromMessage(v quickjs.Value, m protoreflect.Message) error {
+	desc := m.Descriptor()
+	switch name := desc.FullName(); {
+	case name == "google.protobuf.Timestamp":
+		t, err := toTime(v)
+		if err != nil {
+			return err
+		}
+		fields := desc.Fields()
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return nil
+	case wrapperTypes[name]:
+		fd := desc.Fields().ByName("value")
+		pv, err := fromScalar(v, fd, m)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, pv)
+		return nil
+	case jsonTypes[name]:
+		json := v.JSONStringify()
+		if name == "google.protobuf.Value" && v.IsUndefined() {
+			json = "null"
+		}
+		return protojson.Unmarshal([]byte(json), m.Interface())
+	}
+
+	if v.Kind() != quickjs.KindObject {
+		return fmt.Errorf("%s must be an object, not %s", desc.FullName(), v.Kind())
+	}
+	names, err := v.PropertyNames()
+	if err != nil {
+		return err
+	}
+	fields := desc.Fields()
+	for _, name := range names {
+		fd := fields.ByJSONName(name)
+		if fd == nil {
+			fd = fields.ByTextName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown field %s of %s", name, desc.FullName())
+		}
+		item := v.Get(name)
+		err := fromField(item, fd, m)
+		item.Free()
+		if err != nil {
+			return fmt.Errorf("field %s: %w", fd.FullName(), err)
+		}
+	}
+	return nil
+}
+
+func fromField(v quickjs.Value, fd protoreflect.FieldDescriptor, m protoreflect.Message) error {
+	if v.IsUndefined() || v.IsNull() && !(fd.Message() != nil && fd.Message().FullName() == "google.protobuf.Value") {
+		return nil
+	}
+	switch {
+	case fd.IsList():
+		if !v.IsArray() {
+			return fmt.Errorf("must be an array, not %s", v.Kind())
+		}
+		list := m.Mutable(fd).List()
+		for i := int64(0); i < v.Len(); i++ {
+			item := v.GetIdx(i)
+			pv, err := fromScalar(item, fd, list)
+			item.Free()
+			if err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			list.Append(pv)
+		}
+		return nil
+	case fd.IsMap():
+		if v.Kind() != quickjs.KindObject {
+			return fmt.Errorf("must be an object, not %s", v.Kind())
+		}
+		names, err := v.PropertyNames()
+		if err != nil {
+			return err
+		}
+		mp := m.Mutable(fd).Map()
+		for _, name := range names {
+			key, err := mapKey(fd.MapKey(), name)
+			if err != nil {
+				return err
+			}
+			item := v.Get(name)
+			pv, err := fromScalar(item, fd.MapValue(), mp)
+			item.Free()
+			if err != nil {
+				return fmt.Errorf("key %s: %w", name, err)
+			}
+			mp.Set(key, pv)
+		}
+		return nil
+	}
+	pv, err := fromScalar(v, fd, m)
+	if err != nil {
+		return err
+	}
+	m.Set(fd, pv)
+	return nil
+}
+
+// fromScalar converts a value of the type of fd; container is the message, list or map which will hold it.
+func fromScalar(v quickjs.Value, fd protoreflect.FieldDescriptor, container interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if !v.IsBool() {
+			return protoreflect.Value{}, fmt.Errorf("must be a boolean, not %s", v.Kind())
+		}
+		return protoreflect.ValueOfBool(v.Bool()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := toInt(v, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := toInt(v, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := toUint(v, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := toUint(v, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		if !v.IsNumber() {
+			return protoreflect.Value{}, fmt.Errorf("must be a number, not %s", v.Kind())
+		}
+		return protoreflect.ValueOfFloat32(float32(v.Float64())), nil
+	case protoreflect.DoubleKind:
+		if !v.IsNumber() {
+			return protoreflect.Value{}, fmt.Errorf("must be a number, not %s", v.Kind())
+		}
+		return protoreflect.ValueOfFloat64(v.Float64()), nil
+	case protoreflect.StringKind:
+		if !v.IsString() {
+			return protoreflect.Value{}, fmt.Errorf("must be a string, not %s", v.Kind())
+		}
+		return protoreflect.ValueOfString(v.String()), nil
+	case protoreflect.BytesKind:
+		b, err := toBytes(v)
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		n, err := toEnum(v, fd.Enum())
+		return protoreflect.ValueOfEnum(n), err
+	}
+
+	var elem protoreflect.Value
+	switch c := container.(type) {
+	case protoreflect.List:
+		elem = c.NewElement()
+	case protoreflect.Map:
+		elem = c.NewValue()
+	default:
+		elem = container.(protoreflect.Message).NewField(fd)
+	}
+	return elem, fromMessage(v, elem.Message())
+}
+
+func toInt(v quickjs.Value, bits int) (int64, error) {
+	switch {
+	case v.IsBigInt():
+		n := v.BigInt()
+		if !n.IsInt64() || bits == 32 && (n.Int64() < math.MinInt32 || n.Int64() > math.MaxInt32) {
+			return 0, fmt.Errorf("%s out of the range of int%d", n, bits)
+		}
+		return n.Int64(), nil
+	case v.IsString():
+		return strconv.ParseInt(v.String(), 10, bits)
+	case v.IsNumber():
+		f := v.Float64()
+		if f != math.Trunc(f) || f < -math.Pow(2, float64(bits-1)) || f >= math.Pow(2, float64(bits-1)) {
+			return 0, fmt.Errorf("%v is not an int%d", f, bits)
+		}
+		return int64(f), nil
+	}
+	return 0, fmt.Errorf("must be an integer, not %s", v.Kind())
+}
+
+func toUint(v quickjs.Value, bits int) (uint64, error) {
+	switch {
+	case v.IsBigInt():
+		n := v.BigInt()
+		if !n.IsUint64() || bits == 32 && n.Uint64() > math.MaxUint32 {
+			return 0, fmt.Errorf("%s out of the range of uint%d", n, bits)
+		}
+		return n.Uint64(), nil
+	case v.IsString():
+		return strconv.ParseUint(v.String(), 10, bits)
+	case v.IsNumber():
+		f := v.Float64()
+		if f != math.Trunc(f) || f < 0 || f >= math.Pow(2, float64(bits)) {
+			return 0, fmt.Errorf("%v is not a uint%d", f, bits)
+		}
+		return uint64(f), nil
+	}
+	return 0, fmt.Errorf("must be an integer, not %s", v.Kind())
+}
+
+func toBytes(v quickjs.Value) ([]byte, error) {
+	switch v.Kind() {
+	case quickjs.KindString:
+		return base64.StdEncoding.DecodeString(v.String())
+	case quickjs.KindArrayBuffer:
+		return v.ToByteArray(uint(v.ByteLen()))
+	case quickjs.KindTypedArray:
+		buf, offset := v.Get("buffer"), v.Get("byteOffset")
+		defer buf.Free()
+		defer offset.Free()
+		b, err := buf.ToByteArray(uint(buf.ByteLen()))
+		if err != nil {
+			return nil, err
+		}
+		start := offset.Int64()
+		return b[start : start+v.ByteLen()], nil
+	}
+	return nil, fmt.Errorf("must be an ArrayBuffer, an ArrayBuffer view or a base64 string, not %s", v.Kind())
+}
+
+func toEnum(v quickjs.Value, ed protoreflect.EnumDescriptor) (protoreflect.EnumNumber, error) {
+	if v.IsString() {
+		ev := ed.Values().ByName(protoreflect.Name(v.String()))
+		if ev == nil {
+			return 0, fmt.Errorf("unknown value %s of %s", v.String(), ed.FullName())
+		}
+		return ev.Number(), nil
+	}
+	n, err := toInt(v, 32)
+	return protoreflect.EnumNumber(n), err
+}
+
+func toTime(v quickjs.Value) (time.Time, error) {
+	switch v.Kind() {
+	case quickjs.KindDate:
+		ms := v.Call("getTime")
+		defer ms.Free()
+		return time.UnixMilli(ms.Int64()), nil
+	case quickjs.KindString:
+		return time.Parse(time.RFC3339Nano, v.String())
+	case quickjs.KindNumber:
+		return time.UnixMilli(v.Int64()), nil
+	}
+	return time.Time{}, fmt.Errorf("google.protobuf.Timestamp must be a Date, a string or a number, not %s", v.Kind())
+}
+
+func mapKey(fd protoreflect.FieldDescriptor, s string) (protoreflect.MapKey, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s).MapKey(), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b).MapKey(), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)).MapKey(), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n).MapKey(), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)).MapKey(), err
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return protoreflect.ValueOfUint64(n).MapKey(), err
+}
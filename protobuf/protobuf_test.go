@@ -0,0 +1,207 @@
+package protobuf_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/protobuf"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const orderProto = `
+name: "order.proto"
+package: "test"
+syntax: "proto3"
+dependency: ["google/protobuf/timestamp.proto", "google/protobuf/wrappers.proto", "google/protobuf/struct.proto", "google/protobuf/duration.proto"]
+message_type {
+	name: "Order"
+	field { name: "id" number: 1 type: TYPE_INT64 label: LABEL_OPTIONAL json_name: "id" }
+	field { name: "big" number: 2 type: TYPE_UINT64 label: LABEL_OPTIONAL json_name: "big" }
+	field { name: "customer_name" number: 3 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "customerName" }
+	field { name: "payload" number: 4 type: TYPE_BYTES label: LABEL_OPTIONAL json_name: "payload" }
+	field { name: "status" number: 5 type: TYPE_ENUM type_name: ".test.Order.Status" label: LABEL_OPTIONAL json_name: "status" }
+	field { name: "items" number: 6 type: TYPE_MESSAGE type_name: ".test.Order.Item" label: LABEL_REPEATED json_name: "items" }
+	field { name: "counts" number: 7 type: TYPE_MESSAGE type_name: ".test.Order.CountsEntry" label: LABEL_REPEATED json_name: "counts" }
+	field { name: "created" number: 8 type: TYPE_MESSAGE type_name: ".google.protobuf.Timestamp" label: LABEL_OPTIONAL json_name: "created" }
+	field { name: "note" number: 9 type: TYPE_MESSAGE type_name: ".google.protobuf.StringValue" label: LABEL_OPTIONAL json_name: "note" }
+	field { name: "meta" number: 10 type: TYPE_MESSAGE type_name: ".google.protobuf.Struct" label: LABEL_OPTIONAL json_name: "meta" }
+	field { name: "ttl" number: 11 type: TYPE_MESSAGE type_name: ".google.protobuf.Duration" label: LABEL_OPTIONAL json_name: "ttl" }
+	field { name: "email" number: 12 type: TYPE_STRING label: LABEL_OPTIONAL oneof_index: 0 json_name: "email" }
+	field { name: "phone" number: 13 type: TYPE_STRING label: LABEL_OPTIONAL oneof_index: 0 json_name: "phone" }
+	nested_type {
+		name: "Item"
+		field { name: "sku" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "sku" }
+		field { name: "qty" number: 2 type: TYPE_INT32 label: LABEL_OPTIONAL json_name: "qty" }
+	}
+	nested_type {
+		name: "CountsEntry"
+		field { name: "key" number: 1 type: TYPE_STRING label: LABEL_OPTIONAL json_name: "key" }
+		field { name: "value" number: 2 type: TYPE_INT32 label: LABEL_OPTIONAL json_name: "value" }
+		options { map_entry: true }
+	}
+	enum_type {
+		name: "Status"
+		value { name: "UNKNOWN" number: 0 }
+		value { name: "PAID" number: 1 }
+	}
+	oneof_decl { name: "contact" }
+}
+`
+
+func orderDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	// Registers the well-known types the order depends on.
+	_ = []proto.Message{&timestamppb.Timestamp{}, &wrapperspb.StringValue{}, &structpb.Struct{}, &durationpb.Duration{}}
+
+	var fdp descriptorpb.FileDescriptorProto
+	require.NoError(t, prototext.Unmarshal([]byte(orderProto), &fdp))
+	fd, err := protodesc.NewFile(&fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	return fd.Messages().ByName("Order")
+}
+
+func TestToValue(t *testing.T) {
+	desc := orderDescriptor(t)
+	order := dynamicpb.NewMessage(desc)
+	require.NoError(t, prototext.Unmarshal([]byte(`
+		id: 42
+		big: 18446744073709551615
+		customer_name: "Ann"
+		payload: "\x01\x02"
+		status: PAID
+		items { sku: "tea" qty: 2 }
+		items { sku: "cake" }
+		counts { key: "views" value: 3 }
+		created { seconds: 1704067200 nanos: 500000000 }
+		note { value: "ring twice" }
+		meta { fields { key: "tags" value { list_value { values { string_value: "gift" } } } } }
+		ttl { seconds: 90 }
+		email: "ann@example.com"
+	`), order))
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	v, err := protobuf.ToValue(ctx, order)
+	require.NoError(t, err)
+	ctx.Globals().Set("order", v)
+	ret, err := ctx.Eval(`JSON.stringify({
+		...order,
+		big: typeof order.big + ":" + order.big,
+		payload: [...new Uint8Array(order.payload)],
+		created: order.created.toISOString(),
+	})`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"id": 42,
+		"big": "bigint:18446744073709551615",
+		"customerName": "Ann",
+		"payload": [1, 2],
+		"status": "PAID",
+		"items": [{"sku": "tea", "qty": 2}, {"sku": "cake", "qty": 0}],
+		"counts": {"views": 3},
+		"created": "2024-01-01T00:00:00.500Z",
+		"note": "ring twice",
+		"meta": {"tags": ["gift"]},
+		"ttl": "90s",
+		"email": "ann@example.com"
+	}`, ret.String())
+	ret.Free()
+
+	empty, err := protobuf.ToValue(ctx, dynamicpb.NewMessage(desc))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": 0, "big": 0, "customerName": "", "payload": {}, "status": "UNKNOWN", "items": [], "counts": {}}`,
+		empty.JSONStringify())
+	empty.Free()
+}
+
+func TestFromValue(t *testing.T) {
+	desc := orderDescriptor(t)
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	v, err := ctx.Eval(`({
+		id: 9007199254740993n,
+		big: "18446744073709551615",
+		customer_name: "Bob",
+		payload: new Uint8Array([0, 1, 2, 3]).subarray(1, 3),
+		status: 1,
+		items: [{ sku: "tea", qty: 2 }],
+		counts: { views: 3 },
+		created: new Date("2024-01-01T00:00:00.250Z"),
+		note: "leave at door",
+		meta: { vip: true, score: 1.5 },
+		ttl: "1.5s",
+		phone: "555",
+		email: undefined,
+	})`)
+	require.NoError(t, err)
+	order := dynamicpb.NewMessage(desc)
+	require.NoError(t, protobuf.FromValue(v, order))
+	v.Free()
+
+	want := dynamicpb.NewMessage(desc)
+	require.NoError(t, prototext.Unmarshal([]byte(`
+		id: 9007199254740993
+		big: 18446744073709551615
+		customer_name: "Bob"
+		payload: "\x01\x02"
+		status: PAID
+		items { sku: "tea" qty: 2 }
+		counts { key: "views" value: 3 }
+		created { seconds: 1704067200 nanos: 250000000 }
+		note { value: "leave at door" }
+		meta {
+			fields { key: "vip" value { bool_value: true } }
+			fields { key: "score" value { number_value: 1.5 } }
+		}
+		ttl { seconds: 1 nanos: 500000000 }
+		phone: "555"
+	`), want))
+	require.True(t, proto.Equal(want, order), "got %v", order)
+
+	// Round trip.
+	rv, err := protobuf.ToValue(ctx, order)
+	require.NoError(t, err)
+	again := dynamicpb.NewMessage(desc)
+	require.NoError(t, protobuf.FromValue(rv, again))
+	rv.Free()
+	require.True(t, proto.Equal(order, again), "got %v", again)
+
+	for src, want := range map[string]string{
+		`({ unknown: 1 })`:            "protobuf: unknown field unknown of test.Order",
+		`({ status: "LOST" })`:        "protobuf: field test.Order.status: unknown value LOST of test.Order.Status",
+		`({ id: 1.5 })`:               "protobuf: field test.Order.id: 1.5 is not an int64",
+		`({ items: {} })`:             "protobuf: field test.Order.items: must be an array, not Object",
+		`({ customerName: 1 })`:       "protobuf: field test.Order.customer_name: must be a string, not Number",
+		`({ items: [{ qty: "x" }] })`: `protobuf: field test.Order.items: item 0: field test.Order.Item.qty: strconv.ParseInt: parsing "x": invalid syntax`,
+		`[]`:                          "protobuf: test.Order must be an object, not Array",
+	} {
+		v, err := ctx.Eval("(" + src + ")")
+		require.NoError(t, err)
+		require.EqualError(t, protobuf.FromValue(v, dynamicpb.NewMessage(desc)), want, src)
+		v.Free()
+	}
+
+	ts := &timestamppb.Timestamp{}
+	v = ctx.String("2024-01-01T00:00:00Z")
+	require.NoError(t, protobuf.FromValue(v, ts))
+	v.Free()
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ts.AsTime())
+}
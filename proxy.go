@@ -0,0 +1,101 @@
+package quickjs
+
+// ProxyHandler holds the traps of a proxy implemented in Go. Nil traps forward the operation to the target.
+// Traps receive string keys only: operations on symbol keys always go to the target.
+type ProxyHandler struct {
+	Get            func(ctx *Context, target Value, key string, receiver Value) Value
+	Set            func(ctx *Context, target Value, key string, value Value, receiver Value) bool
+	Has            func(ctx *Context, target Value, key string) bool
+	DeleteProperty func(ctx *Context, target Value, key string) bool
+	// OwnKeys lists the keys of the proxy. Keys missing from the target are reported as enumerable, configurable
+	// data properties whose value is read with Get.
+	OwnKeys func(ctx *Context, target Value) []string
+	// Apply and Construct are only called if the target is a function.
+	Apply     func(ctx *Context, target Value, this Value, args []Value) Value
+	Construct func(ctx *Context, target Value, args []Value, newTarget Value) Value
+}
+
+const proxyFactory = `(target, get, set, has, deleteProperty, ownKeys, apply, construct) => {
+	const handler = {};
+	if (get) handler.get = (t, k, r) => typeof k === "symbol" ? Reflect.get(t, k, r) : get(t, k, r);
+	if (set) handler.set = (t, k, v, r) => typeof k === "symbol" ? Reflect.set(t, k, v, r) : set(t, k, v, r);
+	if (has) handler.has = (t, k) => typeof k === "symbol" ? Reflect.has(t, k) : has(t, k);
+	if (deleteProperty) handler.deleteProperty = (t, k) => typeof k === "symbol" ? Reflect.deleteProperty(t, k) : deleteProperty(t, k);
+	if (ownKeys) {
+		handler.ownKeys = (t) => {
+			const keys = ownKeys(t);
+			for (const k of Reflect.ownKeys(t)) {
+				if (!keys.includes(k) && !Reflect.getOwnPropertyDescriptor(t, k).configurable) keys.push(k);
+			}
+			return keys;
+		};
+		handler.getOwnPropertyDescriptor = (t, k) => {
+			const desc = Reflect.getOwnPropertyDescriptor(t, k);
+			if (desc || typeof k === "symbol" || !ownKeys(t).includes(k)) return desc;
+			return { value: handler.get ? handler.get(t, k, t) : undefined, writable: true, enumerable: true, configurable: true };
+		};
+	}
+	if (apply) handler.apply = (t, thisArg, args) => apply(t, thisArg, ...args);
+	if (construct) handler.construct = (t, args, newTarget) => construct(t, newTarget, ...args);
+	return new Proxy(target, handler);
+}`
+
+// Proxy returns a JS Proxy of target whose traps are the Go functions of handler.
+func (ctx *Context) Proxy(target Value, handler ProxyHandler) Value {
+	factory, err := ctx.Eval(proxyFactory, EvalFlagInternal(true))
+	if err != nil {
+		panic(err)
+	}
+	defer factory.Free()
+
+	traps := make([]Value, 7)
+	for i := range traps {
+		traps[i] = ctx.Undefined()
+	}
+	if h := handler.Get; h != nil {
+		traps[0] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return h(ctx, args[0], args[1].String(), args[2])
+		})
+	}
+	if h := handler.Set; h != nil {
+		traps[1] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return ctx.Bool(h(ctx, args[0], args[1].String(), args[2], args[3]))
+		})
+	}
+	if h := handler.Has; h != nil {
+		traps[2] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return ctx.Bool(h(ctx, args[0], args[1].String()))
+		})
+	}
+	if h := handler.DeleteProperty; h != nil {
+		traps[3] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return ctx.Bool(h(ctx, args[0], args[1].String()))
+		})
+	}
+	if h := handler.OwnKeys; h != nil {
+		traps[4] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			keys := ctx.Array().arrayValue
+			for i, key := range h(ctx, args[0]) {
+				keys.SetIdx(int64(i), ctx.String(key))
+			}
+			return keys
+		})
+	}
+	if h := handler.Apply; h != nil {
+		traps[5] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return h(ctx, args[0], args[1], args[2:])
+		})
+	}
+	if h := handler.Construct; h != nil {
+		traps[6] = ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+			return h(ctx, args[0], args[2:], args[1])
+		})
+	}
+	defer func() {
+		for _, trap := range traps {
+			trap.Free()
+		}
+	}()
+
+	return ctx.Invoke(factory, ctx.Null(), append([]Value{target}, traps...)...)
+}
@@ -1,15 +1,31 @@
 package quickjs_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/buke/quickjs-go"
+	quickjsfmt "github.com/buke/quickjs-go/fmt"
+	"github.com/buke/quickjs-go/ids"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -115,8 +131,13 @@ func TestRuntimeMemoryLimit(t *testing.T) {
 
 	if assert.Error(t, err, "expected a memory limit violation") {
 		require.Equal(t, "InternalError: out of memory", err.Error())
+		require.ErrorIs(t, err, quickjs.ErrOutOfMemory)
 	}
 
+	// Scripts cannot forge it.
+	_, err = ctx.Eval(`array = null; throw new InternalError("out of memory")`)
+	require.EqualError(t, err, "InternalError: out of memory")
+	require.NotErrorIs(t, err, quickjs.ErrOutOfMemory)
 }
 
 func TestRuntimeStackSize(t *testing.T) {
@@ -853,6 +874,13 @@ func TestModule2(t *testing.T) {
 	defer r5.Free()
 	require.NoError(t, err)
 	require.EqualValues(t, 144, ctx.Globals().Get("result").Int32())
+
+	// The bytecode of a script is not a module.
+	buf, err = ctx.Compile(`1 + 1`)
+	require.NoError(t, err)
+	r6, err := ctx.LoadModuleBytecode(buf)
+	defer r6.Free()
+	require.ErrorIs(t, err, quickjs.ErrNotModule)
 }
 
 func TestClassConstructor(t *testing.T) {
@@ -885,3 +913,3871 @@ func TestClassConstructor(t *testing.T) {
 	require.EqualValues(t, 10, x.Int32())
 
 }
+
+func TestModuleLoader(t *testing.T) {
+	modules := map[string]string{
+		"greet":     `export const greet = (name) => "Hello " + name;`,
+		"lib/upper": `import { greet } from "greet"; export const upper = (name) => greet(name).toUpperCase();`,
+	}
+	rt := quickjs.NewRuntime(quickjs.WithModuleLoader(func(ctx *quickjs.Context, moduleName string) (string, error) {
+		code, ok := modules[moduleName]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return code, nil
+	}))
+	defer rt.Close()
+
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`
+	import { upper } from "lib/upper";
+	globalThis.result = upper("loader");
+	`, quickjs.EvalAwait(true))
+	defer ret.Free()
+	require.NoError(t, err)
+
+	result := ctx.Globals().Get("result")
+	defer result.Free()
+	require.EqualValues(t, "HELLO LOADER", result.String())
+
+	ret2, err := ctx.Eval(`import "missing";`, quickjs.EvalAwait(true))
+	defer ret2.Free()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not load module 'missing'")
+}
+
+func TestSourceMap(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	code := "// generated\nfunction fail() {\n  throw new Error(\"boom\");\n}\nfail();\n"
+	sourceMap := `{"version":3,"sources":["orig.js"],"sourceRoot":"src","mappings":";AAQA;EACI;;AAUJ"}`
+
+	// sidecar source map
+	_, err := ctx.Eval(code, quickjs.EvalFileName("gen.js"), quickjs.EvalSourceMap([]byte(sourceMap)))
+	require.Error(t, err)
+	stack := err.(*quickjs.Error).Stack
+	require.Contains(t, stack, "at fail (src/orig.js:10:5)")
+	require.Contains(t, stack, "(src/orig.js:20:1)")
+
+	// inline source map
+	inline := code + "//# sourceMappingURL=data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(sourceMap)) + "\n"
+	_, err = ctx.Eval(inline, quickjs.EvalFileName("inline.js"))
+	require.Error(t, err)
+	require.Contains(t, err.(*quickjs.Error).Stack, "at fail (src/orig.js:10:5)")
+
+	// unmapped files are left untouched
+	_, err = ctx.Eval(code, quickjs.EvalFileName("plain.js"))
+	require.Error(t, err)
+	require.Contains(t, err.(*quickjs.Error).Stack, "at fail (plain.js:3)")
+
+	// the map of inline.js is dropped when inline.js is evaluated again without one
+	_, err = ctx.Eval(code, quickjs.EvalFileName("inline.js"))
+	require.Error(t, err)
+	require.Contains(t, err.(*quickjs.Error).Stack, "at fail (inline.js:3)")
+
+	// maps of code evaluated without a filename are not registered
+	_, err = ctx.Eval(inline)
+	require.Error(t, err)
+	_, err = ctx.Eval(code)
+	require.Error(t, err)
+	require.Contains(t, err.(*quickjs.Error).Stack, "at fail (<input>:3)")
+
+	require.Error(t, ctx.SetSourceMap("bad.js", []byte(`{"version":2}`)))
+}
+
+func TestCoverage(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ctx.StartCoverage()
+	ret, err := ctx.Eval(`function discount(total) {
+  if (total > 100) {
+    return total * 0.9
+  }
+  return total
+}
+function unused() {
+  return 0
+}
+discount(50)
+discount(200)
+`, quickjs.EvalFileName("rules.js"))
+	require.NoError(t, err)
+	ret.Free()
+	ctx.StopCoverage()
+
+	ret, err = ctx.Eval(`discount(10)`, quickjs.EvalFileName("ignored.js"))
+	require.NoError(t, err)
+	require.EqualValues(t, 10, ret.Int32())
+	ret.Free()
+
+	report, err := ctx.Coverage()
+	require.NoError(t, err)
+	require.Len(t, report.Files, 1)
+	file := report.Files[0]
+	require.EqualValues(t, "rules.js", file.Filename)
+	require.EqualValues(t, []quickjs.LineCoverage{
+		{Line: 1, Hits: 1}, {Line: 2, Hits: 3}, {Line: 3, Hits: 1}, {Line: 5, Hits: 2},
+		{Line: 7, Hits: 1}, {Line: 8, Hits: 0}, {Line: 10, Hits: 1}, {Line: 11, Hits: 1},
+	}, file.Lines)
+	require.EqualValues(t, []quickjs.FunctionCoverage{
+		{Name: "discount", Line: 1, Hits: 3}, {Name: "unused", Line: 7, Hits: 0},
+	}, file.Functions)
+
+	var lcov strings.Builder
+	require.NoError(t, report.WriteLCOV(&lcov))
+	require.Contains(t, lcov.String(), "SF:rules.js\nFN:1,discount\nFN:7,unused\nFNDA:3,discount\nFNDA:0,unused\nFNF:2\nFNH:1\n")
+	require.Contains(t, lcov.String(), "DA:8,0\n")
+	require.Contains(t, lcov.String(), "LF:8\nLH:7\nend_of_record\n")
+}
+
+func TestProfiling(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	require.Nil(t, ctx.StopProfiling())
+
+	ctx.StartProfiling(1000)
+	ret, err := ctx.Eval(`
+function hot() {
+  const end = Date.now() + 100
+  let n = 0
+  while (Date.now() < end) n++
+  return n
+}
+hot()
+`, quickjs.EvalFileName("prof.js"))
+	require.NoError(t, err)
+	ret.Free()
+	profile := ctx.StopProfiling()
+
+	require.NotNil(t, profile)
+	require.EqualValues(t, time.Millisecond, profile.Period)
+	require.NotEmpty(t, profile.Samples)
+	frames := profile.Samples[0].Frames
+	require.Len(t, frames, 2)
+	require.EqualValues(t, "hot", frames[0].Function)
+	require.EqualValues(t, "prof.js", frames[0].File)
+	require.EqualValues(t, "<eval>", frames[1].Function)
+	require.EqualValues(t, 8, frames[1].Line)
+
+	var pprof bytes.Buffer
+	require.NoError(t, profile.WritePprof(&pprof))
+	require.True(t, bytes.HasPrefix(pprof.Bytes(), []byte{0x1f, 0x8b}))
+
+	var trace strings.Builder
+	require.NoError(t, profile.WriteChromeTrace(&trace))
+	require.Contains(t, trace.String(), `"name":"hot","cat":"js","ph":"X"`)
+
+	// No sample is taken close to the memory limit.
+	rt.SetMemoryLimit(rt.Stats().MemoryUsed + 64<<10)
+	ctx.StartProfiling(1000)
+	ret, err = ctx.Eval(`hot()`)
+	require.NoError(t, err)
+	ret.Free()
+	require.Empty(t, ctx.StopProfiling().Samples)
+}
+
+func TestTraceHooks(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var started []string
+	var evals []quickjs.EvalInfo
+	var calls []quickjs.HostCallInfo
+	rt.SetTraceHooks(
+		func(ctx *quickjs.Context, filename string) { started = append(started, filename) },
+		func(ctx *quickjs.Context, info quickjs.EvalInfo) { evals = append(evals, info) },
+		func(ctx *quickjs.Context, info quickjs.HostCallInfo) { calls = append(calls, info) },
+	)
+
+	ctx.Globals().Set("sleep", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		time.Sleep(10 * time.Millisecond)
+		return ctx.Null()
+	}))
+	ret, err := ctx.Eval(`sleep(); globalThis.data = new Array(10000).fill("x")`, quickjs.EvalFileName("script.js"))
+	require.NoError(t, err)
+	ret.Free()
+
+	_, err = ctx.Eval(`throw new Error("boom")`)
+	require.Error(t, err)
+
+	require.EqualValues(t, []string{"script.js", "<input>"}, started)
+	require.Len(t, evals, 2)
+	require.EqualValues(t, "script.js", evals[0].Filename)
+	require.GreaterOrEqual(t, evals[0].Duration, 10*time.Millisecond)
+	require.Greater(t, evals[0].MemoryDelta, int64(10000))
+	require.NoError(t, evals[0].Err)
+	require.EqualError(t, evals[1].Err, "Error: boom")
+
+	require.Len(t, calls, 1)
+	require.Contains(t, calls[0].Name, "TestTraceHooks")
+	require.GreaterOrEqual(t, calls[0].Duration, 10*time.Millisecond)
+
+	rt.SetTraceHooks(nil, nil, nil)
+	ret, err = ctx.Eval(`sleep()`)
+	require.NoError(t, err)
+	ret.Free()
+	require.Len(t, evals, 2)
+	require.Len(t, calls, 1)
+}
+
+func TestRuntimeUse(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var log []string
+	trace := func(name string) quickjs.Middleware {
+		return func(next quickjs.HostFunc) quickjs.HostFunc {
+			return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+				log = append(log, name+">")
+				defer func() { log = append(log, "<"+name) }()
+				return next(ctx, this, args)
+			}
+		}
+	}
+	calls := 0
+	rt.Use(trace("a"), func(next quickjs.HostFunc) quickjs.HostFunc {
+		return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) (ret quickjs.Value) {
+			defer func() {
+				if r := recover(); r != nil {
+					ret = ctx.ThrowError(fmt.Errorf("panic: %v", r))
+				}
+			}()
+			if calls++; calls > 3 {
+				return ctx.ThrowRangeError("rate limit exceeded")
+			}
+			return next(ctx, this, args)
+		}
+	})
+	ctx.Globals().Set("add", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		log = append(log, "add")
+		return ctx.Int32(args[0].Int32() + args[1].Int32())
+	}))
+	// Middleware added after binding applies as well.
+	rt.Use(trace("b"))
+	ctx.Globals().Set("fail", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		panic("boom")
+	}))
+	ctx.Globals().Set("concat", ctx.AsyncFunction(func(ctx *quickjs.Context, this quickjs.Value, promise quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return promise.Call("resolve", ctx.String(args[0].String()+args[1].String()))
+	}))
+
+	ret, err := ctx.Eval(`add(1, 2)`)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, ret.Int32())
+	ret.Free()
+	require.EqualValues(t, []string{"a>", "b>", "add", "<b", "<a"}, log)
+
+	_, err = ctx.Eval(`fail()`)
+	require.EqualError(t, err, "Error: panic: boom")
+
+	ret, err = ctx.Eval(`concat("a", "b")`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.EqualValues(t, "ab", ret.String())
+	ret.Free()
+
+	log = nil
+	_, err = ctx.Eval(`add(1, 2)`)
+	require.EqualError(t, err, "RangeError: rate limit exceeded")
+	require.EqualValues(t, []string{"a>", "<a"}, log)
+}
+
+func TestRuntimeStats(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	ctx := rt.NewContext()
+
+	ret, err := ctx.Eval(`globalThis.data = new Array(100000).fill(1); Promise.resolve().then(() => {})`)
+	require.NoError(t, err)
+	ret.Free()
+
+	stats := rt.Stats()
+	require.EqualValues(t, 1, stats.Evals)
+	require.True(t, stats.HasPendingJobs)
+	require.Greater(t, stats.MemoryUsed, uint64(800000))
+	require.GreaterOrEqual(t, stats.PeakMemory, stats.MemoryUsed)
+
+	// Other goroutines read what the goroutine of the runtime recorded.
+	read := make(chan quickjs.RuntimeStats)
+	go func() { read <- rt.Stats() }()
+	require.True(t, (<-read).HasPendingJobs)
+
+	ctx.Loop()
+	ret, err = ctx.Eval(`globalThis.data = null`)
+	require.NoError(t, err)
+	ret.Free()
+	rt.RunGC()
+
+	stats2 := rt.Stats()
+	require.EqualValues(t, 2, stats2.Evals)
+	require.False(t, stats2.HasPendingJobs)
+	require.GreaterOrEqual(t, stats2.GCSentinelCollections, uint64(1))
+	require.Less(t, stats2.MemoryUsed, stats.MemoryUsed)
+	require.EqualValues(t, stats.PeakMemory, stats2.PeakMemory)
+
+	rt.SetExecuteTimeout(1)
+	_, err = ctx.Eval(`while (true) {}`)
+	require.Error(t, err)
+	require.EqualValues(t, 1, rt.Stats().Interrupts)
+
+	require.Contains(t, rt.Expvar().String(), `"Evals":3`)
+
+	ctx.Close()
+	rt.Close()
+	require.EqualValues(t, 3, rt.Stats().Evals)
+}
+
+func TestValueLeaks(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	rt.SetTrackValues(true)
+	var leaked quickjs.Value
+	var reported []quickjs.UnfreedValue
+	rt.SetLeakHandler(func(ctx *quickjs.Context, leaks []quickjs.UnfreedValue) {
+		reported = leaks
+		leaked.Free()
+	})
+	ctx := rt.NewContext()
+
+	obj := ctx.Object()
+	obj.Set("name", ctx.String("freed by Set"))
+	name := obj.Get("name")
+	name.Free()
+	obj.Free()
+
+	leaked, err := ctx.Eval(`({})`)
+	require.NoError(t, err)
+
+	ctx.Globals().Set("f", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.String("handed to JS")
+	}))
+	ret, err := ctx.Eval(`f()`)
+	require.NoError(t, err)
+	ret.Free()
+
+	leaks := ctx.UnfreedValues()
+	require.Len(t, leaks, 1)
+	require.Contains(t, leaks[0].Stack, "TestValueLeaks")
+
+	ctx.Close()
+	require.Len(t, reported, 1)
+	require.Contains(t, reported[0].Stack, "quickjs_test.go")
+}
+
+func TestScope(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	rt.SetTrackValues(true)
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var kept quickjs.Value
+	ctx.Scope(func(s *quickjs.Scope) {
+		obj := s.Object()
+		obj.Set("name", s.Keep(s.String("quickjs")))
+		require.EqualValues(t, "quickjs", s.Get(obj, "name").String())
+
+		ret, err := s.Eval(`[1, 2, 3]`)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, s.Call(ret, "indexOf", ctx.Int32(3)).Int32())
+		require.EqualValues(t, 2, s.GetIdx(ret, 1).Int32())
+
+		s.Array().Push(ctx.Int32(1))
+		_, err = s.Eval(`throw new Error("boom")`)
+		require.Error(t, err)
+
+		kept = s.Keep(s.ParseJSON(`{"a": 1}`))
+	})
+	require.Len(t, ctx.UnfreedValues(), 1)
+	require.EqualValues(t, `{"a":1}`, kept.JSONStringify())
+	kept.Free()
+	require.Empty(t, ctx.UnfreedValues())
+}
+
+func TestAutoFree(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithAutoFree())
+	defer rt.Close()
+	rt.SetTrackValues(true)
+	ctx := rt.NewContext()
+
+	for i := 0; i < 100; i++ {
+		ctx.String(fmt.Sprintf("value %d", i))
+		ret, err := ctx.Eval(`({ answer: 42 })`)
+		require.NoError(t, err)
+		require.EqualValues(t, 42, ret.Get("answer").Int32())
+	}
+	obj := ctx.Object()
+	obj.Set("kept", ctx.String("by JS"))
+	freed := ctx.Object()
+	freed.Free()
+
+	for i := 0; i < 10 && len(ctx.UnfreedValues()) > 1; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		rt.RunGC()
+	}
+	require.Len(t, ctx.UnfreedValues(), 1)
+	require.EqualValues(t, "by JS", obj.Get("kept").String())
+	runtime.KeepAlive(obj)
+
+	ctx.Close()
+}
+
+func TestArgs(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ctx.Globals().Set("f", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		if !args.Require(1) {
+			return ctx.Undefined()
+		}
+		name := args.String(0)
+		count := args.Int64(1, 2)
+		data := args.Bytes(2)
+		return ctx.String(fmt.Sprintf("%s:%d:%d:%v", name, count, len(data), args.Bool(3, true)))
+	}))
+
+	ret, err := ctx.Eval(`f("a", undefined, new Uint8Array([1, 2, 3]).subarray(1))`)
+	require.NoError(t, err)
+	require.EqualValues(t, "a:2:2:true", ret.String())
+	ret.Free()
+
+	ret, err = ctx.Eval(`f("b", 5, new ArrayBuffer(4), false)`)
+	require.NoError(t, err)
+	require.EqualValues(t, "b:5:4:false", ret.String())
+	ret.Free()
+
+	for code, msg := range map[string]string{
+		`f()`:                "expected at least 1 arguments, got 0",
+		`f(1)`:               "argument 0 must be a string",
+		`f("a", 1.5)`:        "argument 1 must be an integer",
+		`f("a", 1)`:          "argument 2 is required",
+		`f("a", 1, [1, 2])`:  "argument 2 must be an ArrayBuffer or a typed array",
+		`f("a", 1n, "data")`: "argument 2 must be an ArrayBuffer or a typed array",
+	} {
+		_, err = ctx.Eval(code)
+		require.Error(t, err, code)
+		require.EqualValues(t, "TypeError: "+msg, err.Error(), code)
+	}
+
+	ctx.Globals().Set("g", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		return ctx.String(fmt.Sprintf("%d:%d", args.Int32(0), args.Int64(1, 0)))
+	}))
+	ret, err = ctx.Eval(`g(-2147483648, 2n ** 63n - 1n)`)
+	require.NoError(t, err)
+	require.EqualValues(t, "-2147483648:9223372036854775807", ret.String())
+	ret.Free()
+	for code, msg := range map[string]string{
+		`g(2147483648)`:      "argument 0 is out of the range of int32",
+		`g(-2147483649n)`:    "argument 0 is out of the range of int32",
+		`g(1, 2 ** 63)`:      "argument 1 is out of the range of int64",
+		`g(1, -(2n ** 64n))`: "argument 1 is out of the range of int64",
+		`g(1, Infinity)`:     "argument 1 is out of the range of int64",
+	} {
+		_, err = ctx.Eval(code)
+		require.EqualError(t, err, "RangeError: "+msg, code)
+	}
+}
+
+func TestContextDo(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+
+	ret, err := ctx.Eval(`globalThis.counter = 0; globalThis.results = []`)
+	require.NoError(t, err)
+	ret.Free()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ctx.Do(func(ctx *quickjs.Context) error {
+				ret, err := ctx.Eval(`counter++; Promise.resolve(counter).then((n) => results.push(n))`)
+				if err == nil {
+					ret.Free()
+				}
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	var panicErr error
+	go func() {
+		panicErr = <-ctx.DoAsync(func(ctx *quickjs.Context) error {
+			panic("boom")
+		})
+		wg.Wait()
+		close(stop)
+	}()
+	ctx.Serve(stop)
+
+	require.EqualError(t, panicErr, "quickjs: panic in Do: boom")
+	ret, err = ctx.Eval(`counter + ":" + results.length`)
+	require.NoError(t, err)
+	require.EqualValues(t, "10:10", ret.String())
+	ret.Free()
+
+	ctx.Close()
+	require.ErrorIs(t, ctx.Do(func(*quickjs.Context) error { return nil }), quickjs.ErrContextClosed)
+	require.ErrorIs(t, ctx.DoSync(func(*quickjs.Context) error { return nil }), quickjs.ErrContextClosed)
+}
+
+func TestContextDoAsyncOrder(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var order []int
+	var last <-chan error
+	for i := 0; i < 200; i++ {
+		i := i
+		last = ctx.DoAsync(func(*quickjs.Context) error {
+			order = append(order, i)
+			return nil
+		})
+	}
+	stop := make(chan struct{})
+	go func() {
+		<-last
+		close(stop)
+	}()
+	ctx.Serve(stop)
+
+	require.Len(t, order, 200)
+	for i, n := range order {
+		require.Equal(t, i, n)
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	e, err := quickjs.NewExecutor(
+		quickjs.WithWorkers(4),
+		quickjs.WithQueueSize(8),
+		quickjs.WithContextSetup(func(ctx *quickjs.Context) error {
+			ret, err := ctx.Eval(`globalThis.square = (n) => n * n`)
+			if err == nil {
+				ret.Free()
+			}
+			return err
+		}),
+	)
+	require.NoError(t, err)
+
+	futures := make([]*quickjs.Future, 20)
+	for i := range futures {
+		n := i
+		futures[i] = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+			ret, err := ctx.Eval(fmt.Sprintf("square(%d)", n))
+			if err != nil {
+				return nil, err
+			}
+			defer ret.Free()
+			return ret.Int32(), nil
+		})
+	}
+	for i, f := range futures {
+		v, err := f.Wait()
+		require.NoError(t, err)
+		require.EqualValues(t, i*i, v)
+	}
+
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		_, err := ctx.Eval(`while (true) {}`)
+		return nil, err
+	}, quickjs.TaskTimeout(50*time.Millisecond)).Wait()
+	require.ErrorContains(t, err, "interrupted")
+
+	attempts := 0
+	v, err := e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			_, err := ctx.Eval(`globalThis.leak = []; for (;;) leak.push(null)`)
+			return nil, err
+		}
+		ret, err := ctx.Eval(`typeof leak + ":" + square(3)`)
+		if err != nil {
+			return nil, err
+		}
+		defer ret.Free()
+		return ret.String(), nil
+	}, quickjs.TaskMemoryLimit(4<<20), quickjs.TaskRetries(2)).Wait()
+	require.NoError(t, err)
+	require.EqualValues(t, "undefined:9", v)
+	require.EqualValues(t, 3, attempts)
+
+	e.Close()
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) { return nil, nil }).Wait()
+	require.ErrorIs(t, err, quickjs.ErrExecutorClosed)
+
+	// The timeout of a task leaves the execute timeout of the runtime in place.
+	e, err = quickjs.NewExecutor(quickjs.WithWorkers(1), quickjs.WithRuntimeOptions(quickjs.WithExecuteTimeout(1)))
+	require.NoError(t, err)
+	defer e.Close()
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) { return nil, nil }, quickjs.TaskTimeout(time.Second)).Wait()
+	require.NoError(t, err)
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		_, err := ctx.Eval(`while (true) {}`)
+		return nil, err
+	}).Wait()
+	require.ErrorContains(t, err, "interrupted")
+}
+
+func TestExecutorRuntimeFailure(t *testing.T) {
+	var setups int32
+	setupErr := errors.New("setup failed")
+	e, err := quickjs.NewExecutor(
+		quickjs.WithWorkers(4),
+		quickjs.WithContextSetup(func(ctx *quickjs.Context) error {
+			// The runtimes of the first workers are set up, but none can be created again.
+			if atomic.AddInt32(&setups, 1) > 4 {
+				return setupErr
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		return nil, quickjs.ErrRuntimeCorrupted
+	}).Wait()
+	require.ErrorIs(t, err, setupErr)
+
+	// The other workers run the tasks submitted afterwards.
+	futures := make([]*quickjs.Future, 20)
+	for i := range futures {
+		n := i
+		futures[i] = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+			return n, nil
+		})
+	}
+	for i, f := range futures {
+		v, err := f.Wait()
+		require.NoError(t, err)
+		require.EqualValues(t, i, v)
+	}
+	e.Close()
+}
+
+func TestSnapshot(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+
+	ret, err := ctx.Eval(`
+		var counter = 42;
+		globalThis.config = { name: "app", tags: ["a", "b"], created: new Date(0) };
+		config.self = config;
+		globalThis.buffer = new Uint8Array([1, 2, 3]);
+		globalThis.handler = () => counter;
+		globalThis.withFunction = { run() {} };
+	`)
+	require.NoError(t, err)
+	ret.Free()
+
+	data, err := ctx.Snapshot()
+	require.NoError(t, err)
+	ctx.Close()
+
+	restored, err := rt.RestoreContext(data)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	ret, err = restored.Eval(`[counter, config.name, config.self === config, config.tags[1], config.created.getTime(),
+		buffer[2], typeof handler, typeof withFunction, typeof Math.max].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "42,app,true,b,0,3,undefined,undefined,function", ret.String())
+	ret.Free()
+
+	_, err = rt.RestoreContext([]byte("garbage"))
+	require.Error(t, err)
+
+	// Taking a snapshot keeps the timers of the runtime.
+	ret, err = restored.Eval(`globalThis.fired = false; setTimeout(() => { fired = true; }, 0);`)
+	require.NoError(t, err)
+	ret.Free()
+	_, err = restored.Snapshot()
+	require.NoError(t, err)
+	restored.Loop()
+	ret, err = restored.Eval(`fired`)
+	require.NoError(t, err)
+	require.True(t, ret.Bool())
+	ret.Free()
+}
+
+func TestFork(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	template := rt.NewContext()
+	defer template.Close()
+
+	ret, err := template.Eval(`globalThis.config = { limits: [1, 2, 3] }; var visits = 0`)
+	require.NoError(t, err)
+	ret.Free()
+
+	for i := 0; i < 2; i++ {
+		fork, err := template.Fork()
+		require.NoError(t, err)
+		ret, err := fork.Eval(`visits++; config.limits.push(4); visits + ":" + config.limits.length`)
+		require.NoError(t, err)
+		require.EqualValues(t, "1:4", ret.String())
+		ret.Free()
+		fork.Close()
+	}
+
+	ret, err = template.Eval(`visits + ":" + config.limits.length`)
+	require.NoError(t, err)
+	require.EqualValues(t, "0:3", ret.String())
+	ret.Free()
+
+	// Forking keeps the timers armed in the runtime.
+	ret, err = template.Eval(`globalThis.fired = false; setTimeout(() => { fired = true; }, 0);`)
+	require.NoError(t, err)
+	ret.Free()
+	fork, err := template.Fork()
+	require.NoError(t, err)
+	fork.Close()
+	template.Loop()
+	ret, err = template.Eval(`fired`)
+	require.NoError(t, err)
+	require.True(t, ret.Bool())
+	ret.Free()
+}
+
+func TestOpaque(t *testing.T) {
+	type app struct{ name string }
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	rt.SetOpaque(&app{name: "server"})
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	ctx.SetOpaque("tenant-1")
+
+	ctx.Globals().Set("owner", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.String(ctx.Runtime().Opaque().(*app).name + "/" + ctx.Opaque().(string))
+	}))
+	ret, err := ctx.Eval(`owner()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "server/tenant-1", ret.String())
+	ret.Free()
+
+	other := rt.NewContext()
+	defer other.Close()
+	require.Nil(t, other.Opaque())
+}
+
+func TestGoObject(t *testing.T) {
+	type conn struct {
+		name   string
+		closed bool
+	}
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var finalized []*conn
+	release := func(data interface{}) {
+		c := data.(*conn)
+		c.closed = true
+		finalized = append(finalized, c)
+	}
+	ctx.Globals().Set("connect", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		obj := ctx.GoObject(&conn{name: args[0].String()}, release)
+		obj.Set("name", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			data, ok := this.GoData()
+			if !ok {
+				return ctx.ThrowTypeError("not a connection")
+			}
+			return ctx.String(data.(*conn).name)
+		}))
+		return obj
+	}))
+
+	ret, err := ctx.Eval(`globalThis.kept = connect("kept"); connect("temp").name()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "temp", ret.String())
+	ret.Free()
+
+	rt.RunGC()
+	require.Len(t, finalized, 1)
+	require.EqualValues(t, "temp", finalized[0].name)
+	require.True(t, finalized[0].closed)
+
+	_, ok := ctx.Globals().GoData()
+	require.False(t, ok)
+	kept := ctx.Globals().Get("kept")
+	data, ok := kept.GoData()
+	kept.Free()
+	require.True(t, ok)
+	require.EqualValues(t, "kept", data.(*conn).name)
+}
+
+func TestProxy(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	row := map[string]string{"id": "1", "name": "alice"}
+	target := ctx.Object()
+	defer target.Free()
+	ctx.Globals().Set("row", ctx.Proxy(target, quickjs.ProxyHandler{
+		Get: func(ctx *quickjs.Context, target quickjs.Value, key string, receiver quickjs.Value) quickjs.Value {
+			if v, ok := row[key]; ok {
+				return ctx.String(v)
+			}
+			return ctx.Undefined()
+		},
+		Set: func(ctx *quickjs.Context, target quickjs.Value, key string, value quickjs.Value, receiver quickjs.Value) bool {
+			row[key] = value.String()
+			return true
+		},
+		Has: func(ctx *quickjs.Context, target quickjs.Value, key string) bool {
+			_, ok := row[key]
+			return ok
+		},
+		DeleteProperty: func(ctx *quickjs.Context, target quickjs.Value, key string) bool {
+			delete(row, key)
+			return true
+		},
+		OwnKeys: func(ctx *quickjs.Context, target quickjs.Value) []string {
+			keys := make([]string, 0, len(row))
+			for k := range row {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+	}))
+
+	fn, err := ctx.Eval(`(a, b) => a + b`)
+	require.NoError(t, err)
+	defer fn.Free()
+	ctx.Globals().Set("add", ctx.Proxy(fn, quickjs.ProxyHandler{
+		Apply: func(ctx *quickjs.Context, target quickjs.Value, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			return ctx.Int32(args[0].Int32()*10 + args[1].Int32())
+		},
+	}))
+
+	ret, err := ctx.Eval(`
+		row.email = "a@example.com";
+		delete row.id;
+		[row.name, "id" in row, Object.keys(row).join("|"), JSON.stringify(row), add(4, 2)].join()
+	`)
+	require.NoError(t, err)
+	require.EqualValues(t, `alice,false,email|name,{"email":"a@example.com","name":"alice"},42`, ret.String())
+	ret.Free()
+	require.EqualValues(t, map[string]string{"name": "alice", "email": "a@example.com"}, row)
+}
+
+func TestWeakRef(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var cleaned []interface{}
+	registry := ctx.FinalizationRegistry(func(held interface{}) {
+		cleaned = append(cleaned, held)
+	})
+
+	obj, err := ctx.Eval(`globalThis.cached = { name: "entry" }; cached.self = cached; cached`)
+	require.NoError(t, err)
+	ref, err := ctx.WeakRef(obj)
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(obj, "entry"))
+	require.NoError(t, registry.Register(obj, 42))
+	obj.Free()
+
+	_, err = ctx.WeakRef(ctx.Int32(1))
+	require.Error(t, err)
+
+	rt.RunGC()
+	v, ok := ref.Deref()
+	require.True(t, ok)
+	require.EqualValues(t, "entry", v.Get("name").String())
+	v.Free()
+	require.Empty(t, cleaned)
+
+	ret, err := ctx.Eval(`delete globalThis.cached`)
+	require.NoError(t, err)
+	ret.Free()
+	rt.RunGC()
+	_, ok = ref.Deref()
+	require.False(t, ok)
+	require.EqualValues(t, []interface{}{"entry", 42}, cleaned)
+}
+
+func TestBigFloatAndBigDecimal(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	third := new(big.Float).SetPrec(200).Quo(big.NewFloat(1).SetPrec(200), big.NewFloat(3).SetPrec(200))
+	v := ctx.BigFloat(third)
+	require.True(t, v.IsBigFloat())
+	back, err := v.ToBigFloat()
+	v.Free()
+	require.NoError(t, err)
+	require.Zero(t, back.Cmp(third))
+
+	ctx.Globals().Set("x", ctx.BigFloat(big.NewFloat(-2.5)))
+	ret, err := ctx.Eval(`x * 2`)
+	require.NoError(t, err)
+	f, err := ret.ToBigFloat()
+	ret.Free()
+	require.NoError(t, err)
+	require.EqualValues(t, "-5", f.String())
+
+	ctx.Globals().Set("d", ctx.BigDecimal("0.1"))
+	ret, err = ctx.Eval(`d + BigDecimal("0.2")`)
+	require.NoError(t, err)
+	require.True(t, ret.IsBigDecimal())
+	f, err = ret.ToBigFloat()
+	ret.Free()
+	require.NoError(t, err)
+	require.EqualValues(t, "0.3", f.Text('g', 10))
+
+	ret = ctx.BigDecimal("not a number")
+	require.True(t, ret.IsException())
+	require.Error(t, ctx.Exception())
+
+	ret, err = ctx.Eval(`2 ^ 10`, quickjs.EvalFlagMath(true))
+	require.NoError(t, err)
+	require.EqualValues(t, "1024", ret.String())
+	ret.Free()
+	ret, err = ctx.Eval(`2 ^ 10`)
+	require.NoError(t, err)
+	require.EqualValues(t, "8", ret.String())
+	ret.Free()
+
+	s := ctx.String("x")
+	_, err = s.ToBigFloat()
+	s.Free()
+	require.Error(t, err)
+}
+
+func TestValueComparison(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	values, err := ctx.Eval(`const o = {}; class A {}; [o, o, {}, NaN, 0, -0, "1", 1, new A(), A, Map]`)
+	require.NoError(t, err)
+	defer values.Free()
+	v := make([]quickjs.Value, 11)
+	for i := range v {
+		v[i] = values.GetIdx(int64(i))
+		defer v[i].Free()
+	}
+
+	require.True(t, v[0].StrictEquals(v[1]))
+	require.False(t, v[0].StrictEquals(v[2]))
+	require.False(t, v[3].StrictEquals(v[3]))
+	require.True(t, v[3].SameValue(v[3]))
+	require.True(t, v[4].StrictEquals(v[5]))
+	require.False(t, v[4].SameValue(v[5]))
+	require.True(t, v[4].SameValueZero(v[5]))
+	require.False(t, v[6].StrictEquals(v[7]))
+
+	require.True(t, v[8].IsInstanceOf(v[9]))
+	require.False(t, v[8].IsInstanceOf(v[10]))
+	require.False(t, v[8].IsInstanceOf(v[0]))
+	require.NoError(t, ctx.Exception())
+}
+
+func TestHasDelete(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	obj, err := ctx.Eval(`({ a: 1, 2: "two", __proto__: { inherited: true } })`)
+	require.NoError(t, err)
+	defer obj.Free()
+	require.True(t, obj.Has("a"))
+	require.True(t, obj.Has("inherited"))
+	require.True(t, obj.HasIdx(2))
+	require.False(t, obj.HasIdx(3))
+	require.True(t, obj.Delete("a"))
+	require.False(t, obj.Has("a"))
+	require.True(t, obj.DeleteIdx(2))
+	require.False(t, obj.HasIdx(2))
+
+	frozen, err := ctx.Eval(`Object.freeze({ a: 1 })`)
+	require.NoError(t, err)
+	defer frozen.Free()
+	require.False(t, frozen.Delete("a"))
+	require.True(t, frozen.Has("a"))
+
+	throwing, err := ctx.Eval(`new Proxy({}, { has() { throw new Error("has") }, deleteProperty() { throw new Error("delete") } })`)
+	require.NoError(t, err)
+	defer throwing.Free()
+	require.False(t, throwing.Has("a"))
+	require.EqualError(t, ctx.Exception(), "Error: has")
+	require.False(t, throwing.Delete("a"))
+	require.EqualError(t, ctx.Exception(), "Error: delete")
+	_, err = throwing.HasE("a")
+	require.EqualError(t, err, "Error: has")
+	_, err = throwing.DeleteE("a")
+	require.EqualError(t, err, "Error: delete")
+	require.NoError(t, ctx.Exception())
+	ok, err := obj.HasE("inherited")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestGetOwnPropertyDescriptor(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	obj, err := ctx.Eval(`
+		const obj = { data: 1, get computed() { return 2 } };
+		Object.defineProperty(obj, "hidden", { value: "h", enumerable: false });
+		obj`)
+	require.NoError(t, err)
+	defer obj.Free()
+
+	desc, err := obj.GetOwnPropertyDescriptor("data")
+	require.NoError(t, err)
+	require.False(t, desc.Accessor)
+	require.True(t, desc.Writable && desc.Enumerable && desc.Configurable)
+	require.EqualValues(t, 1, desc.Value.Int32())
+	desc.Free()
+
+	desc, err = obj.GetOwnPropertyDescriptor("computed")
+	require.NoError(t, err)
+	require.True(t, desc.Accessor)
+	require.False(t, desc.Writable)
+	require.True(t, desc.Getter.IsFunction())
+	require.True(t, desc.Setter.IsUndefined())
+	desc.Free()
+
+	desc, err = obj.GetOwnPropertyDescriptor("hidden")
+	require.NoError(t, err)
+	require.False(t, desc.Writable || desc.Enumerable || desc.Configurable)
+	require.EqualValues(t, "h", desc.Value.String())
+	desc.Free()
+
+	desc, err = obj.GetOwnPropertyDescriptor("toString")
+	require.NoError(t, err)
+	require.Nil(t, desc)
+
+	throwing, err := ctx.Eval(`new Proxy({}, { getOwnPropertyDescriptor() { throw new Error("nope") } })`)
+	require.NoError(t, err)
+	defer throwing.Free()
+	_, err = throwing.GetOwnPropertyDescriptor("a")
+	require.EqualError(t, err, "Error: nope")
+}
+
+func TestDefineAccessor(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	counter := 0
+	host := ctx.Object()
+	require.NoError(t, host.DefineAccessor("counter",
+		func(ctx *quickjs.Context, this quickjs.Value) quickjs.Value {
+			counter++
+			return ctx.Int32(int32(counter))
+		},
+		func(ctx *quickjs.Context, this quickjs.Value, value quickjs.Value) quickjs.Value {
+			if !value.IsNumber() {
+				return ctx.ThrowTypeError("counter must be a number")
+			}
+			counter = int(value.Int32())
+			return ctx.Undefined()
+		}))
+	require.NoError(t, host.DefineAccessor("readOnly", func(ctx *quickjs.Context, this quickjs.Value) quickjs.Value {
+		return ctx.String("fixed")
+	}, nil))
+	ctx.Globals().Set("host", host)
+
+	ret, err := ctx.Eval(`const a = host.counter; const b = host.counter; host.counter = 10; [a, b, host.counter, host.readOnly, Object.keys(host)].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "1,2,11,fixed,counter,readOnly", ret.String())
+	ret.Free()
+
+	_, err = ctx.Eval(`host.counter = "x"`)
+	require.EqualError(t, err, "TypeError: counter must be a number")
+
+	frozen, err := ctx.Eval(`Object.freeze({})`)
+	require.NoError(t, err)
+	defer frozen.Free()
+	require.Error(t, frozen.DefineAccessor("x", nil, nil))
+}
+
+type testCounter struct {
+	count int
+}
+
+func TestClassInheritance(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	finalized := 0
+	cls, err := ctx.Class("Counter", func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) (interface{}, error) {
+		if len(args) > 0 && args[0].Int32() < 0 {
+			return nil, errors.New("start must not be negative")
+		}
+		c := &testCounter{}
+		if len(args) > 0 {
+			c.count = int(args[0].Int32())
+		}
+		return c, nil
+	}).Method("increment", func(ctx *quickjs.Context, this quickjs.Value, data interface{}, args []quickjs.Value) quickjs.Value {
+		data.(*testCounter).count++
+		return ctx.Int32(int32(data.(*testCounter).count))
+	}).Accessor("count", func(ctx *quickjs.Context, this quickjs.Value, data interface{}) quickjs.Value {
+		return ctx.Int32(int32(data.(*testCounter).count))
+	}, nil).Finalizer(func(data interface{}) {
+		finalized++
+	}).Build()
+	require.NoError(t, err)
+	ctx.Globals().Set("Counter", cls)
+
+	ret, err := ctx.Eval(`
+		class Double extends Counter {
+			constructor(start) { super(start * 2); this.label = "double"; }
+			increment() { super.increment(); return super.increment(); }
+		}
+		const d = new Double(5);
+		const c = new Counter();
+		c.increment();
+		[Counter.name, d.increment(), d.count, d.label, c.count, d instanceof Counter, Object.keys(d)].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "Counter,12,12,double,1,true,label", ret.String())
+	ret.Free()
+
+	ret, err = ctx.Eval(`d`)
+	require.NoError(t, err)
+	data, ok := ret.GoData()
+	require.True(t, ok)
+	require.EqualValues(t, 12, data.(*testCounter).count)
+	ret.Free()
+
+	_, err = ctx.Eval(`new Counter(-1)`)
+	require.EqualError(t, err, "Error: start must not be negative")
+	_, err = ctx.Eval(`Counter.prototype.increment.call({})`)
+	require.EqualError(t, err, "TypeError: Counter.increment called on an incompatible receiver")
+	_, err = ctx.Eval(`Counter()`)
+	require.Error(t, err)
+
+	// The Go value can't be reached or moved to another object by scripts.
+	ret, err = ctx.Eval(`Reflect.ownKeys(c).length + Object.getOwnPropertySymbols(d).length`)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ret.Int32())
+	ret.Free()
+	_, err = ctx.Eval(`const fake = Object.create(Counter.prototype); Object.assign(fake, c); fake.increment()`)
+	require.EqualError(t, err, "TypeError: Counter.increment called on an incompatible receiver")
+	other, err := ctx.Class("Other", func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) (interface{}, error) {
+		return &testCounter{}, nil
+	}).Build()
+	require.NoError(t, err)
+	ctx.Globals().Set("Other", other)
+	_, err = ctx.Eval(`Counter.prototype.increment.call(new Other())`)
+	require.EqualError(t, err, "TypeError: Counter.increment called on an incompatible receiver")
+
+	ret, err = ctx.Eval(`for (let i = 0; i < 3; i++) new Double(i); 0`)
+	require.NoError(t, err)
+	ret.Free()
+	rt.RunGC()
+	require.GreaterOrEqual(t, finalized, 3)
+}
+
+func TestErrorCauseAndAggregateError(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	root := errors.New("connection refused")
+	ctx.Globals().Set("wrapped", ctx.Error(fmt.Errorf("query failed: %w", root)))
+	ret, err := ctx.Eval(`[wrapped.message, wrapped.cause.message, wrapped.cause instanceof Error, Object.keys(wrapped).includes("cause")].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "query failed: connection refused,connection refused,true,false", ret.String())
+	ret.Free()
+
+	ctx.Globals().Set("joined", ctx.Error(errors.Join(errors.New("a"), errors.New("b"))))
+	ret, err = ctx.Eval(`[joined instanceof AggregateError, joined.errors.map((e) => e.message)].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "true,a,b", ret.String())
+	ret.Free()
+
+	ctx.Globals().Set("aggregate", ctx.AggregateError([]error{errors.New("x")}, "several"))
+	ret, err = ctx.Eval(`[aggregate.message, aggregate.errors.length].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "several,1", ret.String())
+	ret.Free()
+
+	_, err = ctx.Eval(`throw new Error("outer", { cause: new TypeError("inner", { cause: "root" }) })`)
+	require.EqualError(t, err, "Error: outer")
+	var jsErr *quickjs.Error
+	require.True(t, errors.As(err, &jsErr))
+	require.EqualError(t, jsErr.Wrapped, "TypeError: inner")
+	require.EqualError(t, jsErr.Wrapped.(*quickjs.Error).Wrapped, "root")
+
+	_, err = ctx.Eval(`throw new AggregateError([new RangeError("r"), 42], "all failed")`)
+	require.EqualError(t, err, "AggregateError: all failed")
+	require.True(t, errors.As(err, &jsErr))
+	require.Len(t, jsErr.Errors, 2)
+	require.EqualError(t, jsErr.Errors[0], "RangeError: r")
+	require.EqualError(t, jsErr.Errors[1], "42")
+
+	_, err = ctx.Eval(`const e = new Error("loop"); e.cause = e; throw e`)
+	require.EqualError(t, err, "Error: loop")
+}
+
+func TestCustomErrorClass(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	require.NoError(t, ctx.RegisterErrorClass("PaymentError"))
+	require.NoError(t, ctx.RegisterErrorClass("PaymentError"))
+	ctx.Globals().Set("pay", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.ThrowCustom("PaymentError", "card declined", map[string]quickjs.Value{
+			"code":   ctx.String("declined"),
+			"amount": ctx.Int32(42),
+		})
+	}))
+	ctx.Globals().Set("unknown", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.ThrowCustom("MissingError", "nope", map[string]quickjs.Value{"x": ctx.Object()})
+	}))
+
+	ret, err := ctx.Eval(`
+		let result;
+		try { pay(); } catch (e) {
+			result = [e instanceof PaymentError, e instanceof Error, e.name, e.message, e.code, e.amount, String(e)].join();
+		}
+		result`)
+	require.NoError(t, err)
+	require.EqualValues(t, "true,true,PaymentError,card declined,declined,42,PaymentError: card declined", ret.String())
+	ret.Free()
+
+	_, err = ctx.Eval(`pay()`)
+	require.EqualError(t, err, "PaymentError: card declined")
+	_, err = ctx.Eval(`throw new PaymentError("from js")`)
+	require.EqualError(t, err, "PaymentError: from js")
+	_, err = ctx.Eval(`unknown()`)
+	require.EqualError(t, err, `InternalError: unknown error class "MissingError"`)
+}
+
+func TestTry(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	fn, err := ctx.Eval(`(x) => { if (x < 0) throw new RangeError("negative"); if (x === 0) throw "zero"; return x * 2; }`)
+	require.NoError(t, err)
+	defer fn.Free()
+
+	ret, err := ctx.Try(func() quickjs.Value { return ctx.Invoke(fn, ctx.Null(), ctx.Int32(2)) })
+	require.NoError(t, err)
+	require.EqualValues(t, 4, ret.Int32())
+
+	_, err = ctx.Try(func() quickjs.Value { return ctx.Invoke(fn, ctx.Null(), ctx.Int32(-1)) })
+	require.EqualError(t, err, "RangeError: negative")
+
+	_, err = ctx.Try(func() quickjs.Value { return ctx.Invoke(fn, ctx.Null(), ctx.Int32(0)) })
+	require.EqualError(t, err, "zero")
+
+	obj, err := ctx.Eval(`({ get broken() { throw new Error("getter failed"); } })`)
+	require.NoError(t, err)
+	defer obj.Free()
+	_, err = ctx.Try(func() quickjs.Value { return obj.Get("broken") })
+	require.EqualError(t, err, "Error: getter failed")
+
+	ret, err = ctx.Try(func() quickjs.Value { return ctx.String("ok") })
+	require.NoError(t, err)
+	require.EqualValues(t, "ok", ret.String())
+	ret.Free()
+}
+
+func TestErrorReturningVariants(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	obj, err := ctx.Eval(`({
+		get broken() { throw new Error("get failed"); },
+		set broken(v) { throw new TypeError("set failed"); },
+		twice(x) { return x * 2; },
+		fail() { throw "plain"; },
+		notFunction: 1,
+	})`)
+	require.NoError(t, err)
+	defer obj.Free()
+
+	_, err = obj.GetE("broken")
+	require.EqualError(t, err, "Error: get failed")
+	require.EqualError(t, obj.SetE("broken", ctx.Int32(1)), "TypeError: set failed")
+	require.NoError(t, obj.SetE("other", ctx.Int32(1)))
+	other, err := obj.GetE("other")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, other.Int32())
+
+	ret, err := obj.CallE("twice", ctx.Int32(21))
+	require.NoError(t, err)
+	require.EqualValues(t, 42, ret.Int32())
+	_, err = obj.CallE("fail")
+	require.EqualError(t, err, "plain")
+	_, err = obj.CallE("notFunction")
+	require.EqualError(t, err, "Object not a function")
+
+	fn := obj.Get("twice")
+	defer fn.Free()
+	ret, err = ctx.InvokeE(fn, obj, ctx.Int32(2))
+	require.NoError(t, err)
+	require.EqualValues(t, 4, ret.Int32())
+	_, err = ctx.InvokeE(fn, obj, ctx.BigInt64(1))
+	require.Error(t, err)
+
+	// The exception was fetched, so later calls are not affected.
+	ret, err = ctx.Eval(`1 + 1`)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, ret.Int32())
+}
+
+func TestNewRuntimeWithOptions(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	rt := quickjs.NewRuntimeWithOptions(quickjs.RuntimeOptions{
+		MemoryLimit:  64 * 1024 * 1024,
+		MaxStackSize: 1024 * 1024,
+		Timezone:     paris,
+		Locale:       "de-DE",
+		ModuleLoader: func(ctx *quickjs.Context, moduleName string) (string, error) {
+			return `export const answer = 42;`, nil
+		},
+	})
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`
+		const winter = new Date(Date.UTC(2024, 0, 2, 3, 4, 5));
+		const summer = new Date("2024-07-01T12:00:00");
+		const local = new Date(2024, 2, 31, 2, 30);
+		winter.setHours(23);
+		[
+			winter.getHours(), winter.getTimezoneOffset(), winter.toISOString(),
+			summer.toISOString(), summer.getTimezoneOffset(), summer.toString(),
+			local.toISOString(), new Date("2024-01-02").toISOString(),
+			new Date(0) instanceof Date, Date.prototype.constructor === Date, new Date("Jan 2 2024 10:00").getHours(),
+		].join("|")`)
+	require.NoError(t, err)
+	require.EqualValues(t, "23|-60|2024-01-02T22:04:05.000Z|"+
+		"2024-07-01T10:00:00.000Z|-120|Mon Jul 01 2024 12:00:00 GMT+0200|"+
+		"2024-03-31T01:30:00.000Z|2024-01-02T00:00:00.000Z|"+
+		"true|true|10", ret.String())
+	ret.Free()
+
+	ret, err = ctx.Eval(`[(1234567.891).toLocaleString(), (1234.5).toLocaleString("en-US"), "a".localeCompare("ä"), "ä".localeCompare("b"), "i".toLocaleUpperCase("tr")].join("|")`)
+	require.NoError(t, err)
+	require.EqualValues(t, "1.234.567,891|1,234.5|-1|-1|İ", ret.String())
+	ret.Free()
+
+	_, err = ctx.Eval(`"a".localeCompare("b", "not a tag!")`)
+	require.EqualError(t, err, "RangeError: invalid language tag: not a tag!")
+
+	ret, err = ctx.Eval(`import { answer } from "virtual"; globalThis.answer = answer;`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	answer := ctx.Globals().Get("answer")
+	require.EqualValues(t, 42, answer.Int32())
+	answer.Free()
+
+	restricted := quickjs.NewRuntimeWithOptions(quickjs.RuntimeOptions{Intrinsics: quickjs.IntrinsicJSON})
+	defer restricted.Close()
+	rctx := restricted.NewContext()
+	defer rctx.Close()
+	ret, err = rctx.Eval(`[typeof JSON, typeof Date, typeof Proxy, typeof Map, typeof Promise].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "object,undefined,undefined,undefined,function", ret.String())
+	ret.Free()
+
+	blocking := quickjs.NewRuntimeWithOptions(quickjs.RuntimeOptions{})
+	defer blocking.Close()
+	bctx := blocking.NewContext()
+	defer bctx.Close()
+	ret, err = bctx.Eval(`Atomics.wait(new Int32Array(new SharedArrayBuffer(8)), 0, 0, 0)`)
+	require.NoError(t, err)
+	require.EqualValues(t, "timed-out", ret.String())
+	ret.Free()
+
+	nonBlocking := quickjs.NewRuntimeWithOptions(quickjs.RuntimeOptions{DisallowBlocking: true})
+	defer nonBlocking.Close()
+	nctx := nonBlocking.NewContext()
+	defer nctx.Close()
+	_, err = nctx.Eval(`Atomics.wait(new Int32Array(new SharedArrayBuffer(8)), 0, 0, 0)`)
+	require.Error(t, err)
+}
+
+func TestAtomicsWait(t *testing.T) {
+	worker := filepath.Join(t.TempDir(), "worker.js")
+	require.NoError(t, os.WriteFile(worker, []byte(`
+		import * as os from "os";
+		const parent = os.Worker.parent;
+		parent.onmessage = (e) => {
+			const ia = new Int32Array(e.data);
+			Atomics.store(ia, 0, 7);
+			Atomics.notify(ia, 0);
+			parent.onmessage = null;
+		};`), 0o644))
+
+	rt := quickjs.NewRuntime(quickjs.WithModuleImport(true))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ctx.Globals().Set("workerFile", ctx.String(worker))
+	ret, err := ctx.Eval(`
+		import * as os from "os";
+		const w = new os.Worker(workerFile);
+		const ia = new Int32Array(new SharedArrayBuffer(8));
+		w.postMessage(ia.buffer);
+		// The worker may notify before the wait starts, in which case the value already changed.
+		const status = Atomics.wait(ia, 0, 0, 5000);
+		globalThis.result = [status === "timed-out" ? status : "woken", Atomics.load(ia, 0)].join();`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	result := ctx.Globals().Get("result")
+	require.EqualValues(t, "woken,7", result.String())
+	result.Free()
+
+	rt.SetCanBlock(false)
+	_, err = ctx.Eval(`Atomics.wait(new Int32Array(new SharedArrayBuffer(8)), 0, 0, 10)`)
+	require.Error(t, err)
+}
+
+func TestSharedArrayBuffer(t *testing.T) {
+	buf, err := quickjs.NewSharedArrayBuffer(16)
+	require.NoError(t, err)
+	require.EqualValues(t, 16, buf.Len())
+
+	done := make(chan string)
+	go func() {
+		rt := quickjs.NewRuntime()
+		defer rt.Close()
+		ctx := rt.NewContext()
+		defer ctx.Close()
+
+		ctx.Globals().Set("shared", ctx.SharedArrayBuffer(buf))
+		ret, err := ctx.Eval(`
+			const ia = new Int32Array(shared);
+			Atomics.store(ia, 1, 1);
+			Atomics.notify(ia, 1);
+			while (Atomics.load(ia, 0) === 0) Atomics.wait(ia, 0, 0, 5000);
+			ia[0] + ia[2]`)
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- ret.String()
+		ret.Free()
+	}()
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	ctx.Globals().Set("shared", ctx.SharedArrayBuffer(buf))
+	ret, err := ctx.Eval(`
+		const ia = new Int32Array(shared);
+		while (Atomics.load(ia, 1) === 0) Atomics.wait(ia, 1, 0, 5000);
+		ia[2] = 30;
+		Atomics.store(ia, 0, 12);
+		Atomics.notify(ia, 0);`)
+	require.NoError(t, err)
+	ret.Free()
+	require.EqualValues(t, "42", <-done)
+	require.EqualValues(t, 12, buf.Bytes()[0])
+
+	buf.Close()
+	ret, err = ctx.Eval(`new Int32Array(shared)[2]`)
+	require.NoError(t, err)
+	require.EqualValues(t, 30, ret.Int32())
+	_, err = ctx.Try(func() quickjs.Value { return ctx.SharedArrayBuffer(buf) })
+	require.EqualError(t, err, "TypeError: SharedArrayBuffer is closed")
+}
+
+func TestMessagePortTransfer(t *testing.T) {
+	a, b := quickjs.NewMessageChannel()
+	received := make(chan string, 1)
+	stop := make(chan struct{})
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		rt := quickjs.NewRuntime()
+		defer rt.Close()
+		ctx := rt.NewContext()
+		defer ctx.Close()
+
+		ctx.Globals().Set("port", ctx.MessagePort(b))
+		ctx.Globals().Set("report", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			received <- args[0].String()
+			return ctx.Undefined()
+		}))
+		ret, err := ctx.Eval(`port.onmessage = ({ data }) => {
+			const { name, buffer, view, shared, marked } = data;
+			new Int32Array(shared)[0] = 99;
+			report([name, buffer.byteLength, new Uint8Array(buffer)[1], view.length, view[0], view.buffer === buffer,
+				marked?.__quickjs_transfer__].join());
+			port.postMessage("ack");
+		};`)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		ret.Free()
+		ctx.Serve(stop)
+	}()
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	serveStop := make(chan struct{})
+	ctx.Globals().Set("port", ctx.MessagePort(a))
+	ctx.Globals().Set("stopServing", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		close(serveStop)
+		return ctx.Undefined()
+	}))
+	_, err := ctx.Try(func() quickjs.Value { return ctx.MessagePort(a) })
+	require.EqualError(t, err, "TypeError: MessagePort is already attached")
+
+	ret, err := ctx.Eval(`
+		globalThis.replies = [];
+		port.onmessage = ({ data }) => { replies.push(data); stopServing(); };
+		const buffer = new ArrayBuffer(1024);
+		new Uint8Array(buffer).set([1, 2, 3]);
+		const view = new Uint8Array(buffer, 2, 4);
+		const shared = new SharedArrayBuffer(4);
+		// Data looking like the internal representation of a transferred buffer is left alone.
+		port.postMessage({ name: "payload", buffer, view, shared, marked: { __quickjs_transfer__: 0 } }, [buffer]);
+		globalThis.check = () => [buffer.byteLength, new Int32Array(shared)[0]].join();
+		buffer.byteLength`)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ret.Int32())
+
+	require.EqualValues(t, "payload,1024,2,4,3,true,0", <-received)
+
+	_, err = ctx.Eval(`port.postMessage(1, [new ArrayBuffer(1), 2])`)
+	require.EqualError(t, err, "TypeError: only ArrayBuffers can be transferred")
+
+	// The reply is delivered while this context serves.
+	ctx.Serve(serveStop)
+	ret, err = ctx.Eval(`[replies.join(), check()].join("|")`)
+	require.NoError(t, err)
+	require.EqualValues(t, "ack|0,99", ret.String())
+	ret.Free()
+
+	// The memory of a transferred buffer moves to the receiver without a copy.
+	ret, err = ctx.Eval(`globalThis.big = new Uint8Array(16 << 20).fill(7).buffer`)
+	require.NoError(t, err)
+	ret.Free()
+	before := rt.Stats().MemoryUsed
+	ret, err = ctx.Eval(`port.postMessage({ name: "big", buffer: big, view: new Uint8Array(big, 0, 1), shared: new SharedArrayBuffer(4) }, [big]);
+		big.byteLength`)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ret.Int32())
+	ret.Free()
+	require.Less(t, rt.Stats().MemoryUsed, before-15<<20)
+	require.EqualValues(t, "big,16777216,7,1,7,true,", <-received)
+
+	close(stop)
+	<-served
+}
+
+func TestBroadcastChannel(t *testing.T) {
+	hub := quickjs.NewBroadcastHub()
+	received := make(chan string, 10)
+	var mu sync.Mutex
+	workers := 0
+	e, err := quickjs.NewExecutor(quickjs.WithWorkers(3), quickjs.WithBroadcastHub(hub), quickjs.WithContextSetup(func(ctx *quickjs.Context) error {
+		mu.Lock()
+		workers++
+		id := workers
+		mu.Unlock()
+		ctx.Globals().Set("record", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			received <- fmt.Sprintf("%d:%s", id, args[0].String())
+			return ctx.Undefined()
+		}))
+		ctx.Globals().Set("workerID", ctx.Int32(int32(id)))
+		ret, err := ctx.Eval(`globalThis.channel = new BroadcastChannel("cache");
+			channel.onmessage = ({ data }) => record(data.key);`)
+		if err == nil {
+			ret.Free()
+		}
+		return err
+	}))
+	require.NoError(t, err)
+	defer e.Close()
+
+	value, err := e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		ret, err := ctx.Eval(`channel.postMessage({ key: "from-js" }); workerID`)
+		if err != nil {
+			return nil, err
+		}
+		defer ret.Free()
+		return ret.Int32(), nil
+	}).Wait()
+	require.NoError(t, err)
+	sender := value.(int32)
+
+	collect := func(n int) []string {
+		var got []string
+		for i := 0; i < n; i++ {
+			select {
+			case msg := <-received:
+				got = append(got, msg)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %v, want %d messages", got, n)
+			}
+		}
+		sort.Strings(got)
+		return got
+	}
+	var want []string
+	for id := 1; id <= 3; id++ {
+		if int32(id) != sender {
+			want = append(want, fmt.Sprintf("%d:from-js", id))
+		}
+	}
+	require.Equal(t, want, collect(2))
+
+	hub.PostJSON("cache", `{"key": "from-go"}`)
+	require.Equal(t, []string{"1:from-go", "2:from-go", "3:from-go"}, collect(3))
+	hub.PostJSON("other", `{"key": "ignored"}`)
+
+	_, err = e.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		_, err := ctx.Eval(`channel.close(); channel.postMessage({})`)
+		return nil, err
+	}).Wait()
+	require.EqualError(t, err, "TypeError: BroadcastChannel is closed")
+
+	// Channels closed by scripts and left open are both released with their context.
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	require.NoError(t, ctx.InstallBroadcastChannel(hub))
+	ret, err := ctx.Eval(`for (let i = 0; i < 1000; i++) new BroadcastChannel("tmp").close();
+		globalThis.open = new BroadcastChannel("tmp");`)
+	require.NoError(t, err)
+	ret.Free()
+	ctx.Close()
+	hub.PostJSON("tmp", `{}`)
+}
+
+func TestAddChannel(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	_, err := ctx.AddChannel(make(chan<- int), nil)
+	require.Error(t, err)
+	_, err = ctx.AddChannel(42, nil)
+	require.Error(t, err)
+
+	onEvent, err := ctx.Eval(`globalThis.events = []; (event) => { events.push(event); Promise.resolve().then(() => events.push("job")); }`)
+	require.NoError(t, err)
+	defer onEvent.Free()
+
+	stop := make(chan struct{})
+	events := make(chan string)
+	remove, err := ctx.AddChannel(events, func(ctx *quickjs.Context, value interface{}) {
+		event := value.(string)
+		arg := ctx.String(event)
+		ctx.Invoke(onEvent, ctx.Null(), arg).Free()
+		arg.Free()
+		if event == "last" {
+			close(stop)
+		}
+	})
+	require.NoError(t, err)
+	defer remove()
+
+	go func() {
+		for _, event := range []string{"first", "second", "last"} {
+			events <- event
+		}
+	}()
+	ctx.Serve(stop)
+
+	ret, err := ctx.Eval(`events.join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "first,job,second,job,last,job", ret.String())
+	ret.Free()
+
+	remove()
+	select {
+	case events <- "after remove":
+	case <-time.After(50 * time.Millisecond):
+	}
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+	ctx.Serve(done)
+	ret, err = ctx.Eval(`events.length`)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, ret.Int32())
+	ret.Free()
+}
+
+func TestScheduler(t *testing.T) {
+	schedule, err := quickjs.ParseCron("30 9 * * mon-fri")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC) // Friday
+	require.Equal(t, time.Date(2024, 3, 11, 9, 30, 0, 0, time.UTC), schedule.Next(from))
+	schedule, err = quickjs.ParseCron("@monthly")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), schedule.Next(from))
+	schedule, err = quickjs.ParseCron("0 0 13 * fri")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), schedule.Next(from))
+	_, err = quickjs.ParseCron("61 * * * *")
+	require.Error(t, err)
+	require.Equal(t, from.Add(2*time.Millisecond), quickjs.Every(1001*time.Microsecond).Next(from))
+	require.Equal(t, from.Add(time.Millisecond), quickjs.Every(0).Next(from))
+	require.Equal(t, from.Add(math.MaxInt64/time.Millisecond*time.Millisecond), quickjs.Every(math.MaxInt64).Next(from))
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, ctx.InstallScheduler(quickjs.SchedulerMaxJobs(3), quickjs.SchedulerMinInterval(5*time.Millisecond)))
+
+	stop := make(chan struct{})
+	var goRuns int
+	ctx.Scheduler().Add(quickjs.Every(10*time.Millisecond), func(ctx *quickjs.Context) error {
+		goRuns++
+		if goRuns == 5 {
+			close(stop)
+		}
+		return nil
+	})
+	var errs []string
+	ctx.Scheduler().OnError(func(id int, err error) {
+		errs = append(errs, err.Error())
+	})
+
+	ret, err := ctx.Eval(`
+		globalThis.ticks = 0;
+		const id = scheduler.every(5, () => { if (++ticks === 2) scheduler.cancel(id); });
+		scheduler.every(5, () => { throw new Error("job failed"); });
+	`)
+	require.NoError(t, err)
+	ret.Free()
+	_, err = ctx.Eval(`scheduler.cron("* * *", () => {})`)
+	require.Error(t, err)
+	for _, code := range []string{
+		`scheduler.every(1e300, () => {})`,
+		`scheduler.every(0, () => {})`,
+		`scheduler.every(4, () => {})`,
+		`scheduler.every(NaN, () => {})`,
+		`scheduler.cron("@every 1ms", () => {})`,
+		`scheduler.every(5, () => {}); scheduler.every(5, () => {})`,
+	} {
+		_, err = ctx.Eval(code)
+		require.ErrorContains(t, err, "RangeError", code)
+	}
+	// The jobs follow the time zone of the context, changed while they run.
+	require.NoError(t, ctx.SetTimezone(time.UTC))
+
+	ctx.Serve(stop)
+	ret, err = ctx.Eval(`ticks`)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, ret.Int32())
+	ret.Free()
+	require.NotEmpty(t, errs)
+	require.Contains(t, errs[0], "job failed")
+	require.Equal(t, 3, ctx.Scheduler().Len())
+}
+
+func TestWithRealm(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var result string
+	err := ctx.WithRealm(func(realm *quickjs.Context) error {
+		// Nothing is freed explicitly: closing the realm does it.
+		obj := realm.Object()
+		obj.Set("name", realm.String("realm"))
+		realm.Globals().Set("obj", obj)
+		ret, err := realm.Eval(`globalThis.leaked = { nested: [1, 2, 3] }; Promise.resolve().then(() => obj.done = true); obj.name`)
+		if err != nil {
+			return err
+		}
+		result = ret.String()
+		realm.Eval(`[leaked, obj]`)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "realm", result)
+
+	ret, err := ctx.Eval(`typeof leaked`)
+	require.NoError(t, err)
+	require.Equal(t, "undefined", ret.String())
+	ret.Free()
+
+	err = ctx.WithRealm(func(realm *quickjs.Context) error {
+		_, err := realm.Eval(`throw new TypeError("untrusted")`)
+		return err
+	})
+	require.ErrorContains(t, err, "untrusted")
+
+	// The realm runs its own jobs, not those queued by ctx after them, and the timers of ctx survive it.
+	ret, err = ctx.Eval(`
+		globalThis.fired = false;
+		setTimeout(() => fired = true, 1);
+		globalThis.ticks = 0;
+		const tick = () => { if (++ticks < 1000) Promise.resolve().then(tick) };
+		Promise.resolve().then(tick);
+	`)
+	require.NoError(t, err)
+	ret.Free()
+	var settled bool
+	err = ctx.WithRealm(func(realm *quickjs.Context) error {
+		realm.Globals().Set("settle", realm.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			settled = true
+			return ctx.Undefined()
+		}))
+		ret, err := realm.Eval(`Promise.resolve().then(() => 1).then(() => 2).then(settle)`)
+		ret.Free()
+		return err
+	})
+	require.NoError(t, err)
+	require.True(t, settled)
+	ret, err = ctx.Eval(`ticks`)
+	require.NoError(t, err)
+	require.Less(t, ret.Int32(), int32(10))
+	ret.Free()
+	ctx.Loop()
+	ret, err = ctx.Eval(`ticks === 1000 && fired`)
+	require.NoError(t, err)
+	require.True(t, ret.Bool())
+	ret.Free()
+}
+
+func TestEvalQuota(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	quota := quickjs.Quota{
+		WallTime:     5 * time.Second,
+		Instructions: 1_000_000_000,
+		Memory:       64 << 20,
+		Objects:      100_000,
+	}
+	exceeded := func(code string, q quickjs.Quota) quickjs.QuotaLimit {
+		ret, err := ctx.Eval(code, quickjs.EvalQuota(q))
+		defer ret.Free()
+		var quotaErr *quickjs.QuotaExceededError
+		require.ErrorAs(t, err, &quotaErr)
+		return quotaErr.Limit
+	}
+
+	wall := quota
+	wall.WallTime = 50 * time.Millisecond
+	wall.Instructions = 0
+	require.Equal(t, quickjs.QuotaWallTime, exceeded(`for (;;) {}`, wall))
+
+	instructions := quota
+	instructions.Instructions = 100_000
+	require.Equal(t, quickjs.QuotaInstructions, exceeded(`for (;;) {}`, instructions))
+
+	memory := quota
+	memory.Memory = 1 << 20
+	require.Equal(t, quickjs.QuotaMemory, exceeded(`const a = []; for (;;) a.push("x".repeat(1000) + a.length)`, memory))
+
+	objects := quota
+	objects.Objects = 1000
+	require.Equal(t, quickjs.QuotaObjects, exceeded(`const o = []; for (;;) o.push({})`, objects))
+
+	// Within the quota, and without a quota afterwards.
+	ret, err := ctx.Eval(`let n = 0; for (let i = 0; i < 1000; i++) n += i; n`, quickjs.EvalQuota(quota))
+	require.NoError(t, err)
+	require.EqualValues(t, 499500, ret.Int32())
+	ret.Free()
+	ret, err = ctx.Eval(`"x".repeat(4 << 20).length`)
+	require.NoError(t, err)
+	ret.Free()
+
+	ret, err = ctx.Eval(`throw new Error("plain")`, quickjs.EvalQuota(quota))
+	ret.Free()
+	require.EqualError(t, err, "Error: plain")
+
+	// A script error mentioning memory is not a memory quota error.
+	ret, err = ctx.Eval(`throw new Error("database out of memory")`, quickjs.EvalQuota(memory))
+	ret.Free()
+	require.EqualError(t, err, "Error: database out of memory")
+}
+
+func TestLimits(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithLimits(quickjs.Limits{
+		MaxStringLength: 1000,
+		MaxArrayLength:  1000,
+		MaxRegExpInput:  1000,
+		MaxAllocation:   1 << 20,
+		SafeRegExp:      true,
+	}))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	for _, code := range []string{
+		`"ab".repeat(501)`,
+		`"".padStart(1001)`,
+		`new Array(1001).fill(0).length`,
+		`Array(1001)`,
+		`Array.from({ length: 1001 })`,
+		`new Uint8Array(1001)`,
+		`new ArrayBuffer(1001)`,
+		`Array(100).fill("0123456789").join("-")`,
+		`/a/.test("a".repeat(600) + "b".repeat(600))`,
+		`/^(a+)+$/.test("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa!")`,
+		`"aaaa!".match(new RegExp("(?:a|b*)*c"))`,
+		`"aaaa!".replace(/(x+x+)+y/g, "")`,
+		`/(a|a)*$/.test("aaaa!")`,
+		`/(?:a|ab)+c/.test("abab!")`,
+		`/(\d|1)+x/.test("1111")`,
+		`"a".repeat(600).concat("b".repeat(600))`,
+		`"a".repeat(20).replace("a", "$'".repeat(60))`,
+		`"a".repeat(20).replaceAll("a", () => "b".repeat(60))`,
+		`"a".repeat(20).replace(/a/g, "$&".repeat(60))`,
+		`/a/g[Symbol.replace]("a".repeat(20), "$` + "`" + `".repeat(60))`,
+	} {
+		ret, err := ctx.Eval(code)
+		ret.Free()
+		require.ErrorContains(t, err, "RangeError", code)
+	}
+
+	ret, err := ctx.Eval(`[
+		"ab".repeat(500).length,
+		new Array(3).length,
+		Array.from([1, 2, 3]).length,
+		new Uint8Array([1, 2]).length,
+		[] instanceof Array && new Uint8Array(1) instanceof Uint8Array,
+		class extends Array {}.from([1]).length,
+		/^(ab)+c*[+*]+(x{2,3})+$/.test("ababc+xx"),
+		"a-b-c".replace(/-/g, "+"),
+		/(a|b)*c/.test("ababc") && /(?:foo|bar)+/.test("foobar") && /([a-c]|d)+/.test("abd"),
+		"x".concat(1, "y", null),
+		"2024-01-02".replace(/(?<y>\d+)-(\d+)-(\d+)/, "$3/$2/$<y> $$ $0 $10 $<z>"),
+		"abc".replace("b", "[$` + "`" + `$&$']"),
+		"abc".replace("b", "$<x>$1"),
+		["a", null, 1, undefined].join(),
+	].join("|")`)
+	require.NoError(t, err)
+	require.Equal(t, "1000|3|3|2|true|1|true|a+b+c|true|x1ynull|02/01/2024 $ $0 20240 |a[abc]c|a$<x>$1c|a,,1,", ret.String())
+	ret.Free()
+
+	// The operations without a length check are bounded by the size of an allocation.
+	for _, code := range []string{
+		`let s = "x"; for (;;) s += s`,
+		`const a = []; for (;;) a.push(0)`,
+	} {
+		ret, err = ctx.Eval(code)
+		ret.Free()
+		require.ErrorIs(t, err, quickjs.ErrOutOfMemory, code)
+	}
+}
+
+func TestGCHooks(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var before, after []quickjs.GCStats
+	rt.SetGCHooks(func(stats quickjs.GCStats) {
+		before = append(before, stats)
+	}, func(stats quickjs.GCStats) {
+		after = append(after, stats)
+	})
+
+	ret, err := ctx.Eval(`globalThis.garbage = []; for (let i = 0; i < 1000; i++) { const o = {}; o.self = o; garbage.push(o) }; garbage = null`)
+	require.NoError(t, err)
+	ret.Free()
+	rt.RunGC()
+	require.Len(t, before, 1)
+	forced := after[len(after)-1]
+	require.True(t, forced.Forced)
+	require.GreaterOrEqual(t, forced.Runs, uint64(len(after)))
+	require.Less(t, forced.MemoryAfter, before[0].MemoryBefore)
+	require.Positive(t, forced.Pause)
+	reported := len(after)
+
+	// Collections started by the engine are reported afterwards, some of them at least.
+	rt.SetGCThreshold(1)
+	for i := 0; i < 5; i++ {
+		ret, err = ctx.Eval(`for (let i = 0; i < 10000; i++) { const o = {}; o.self = o; }`)
+		require.NoError(t, err)
+		ret.Free()
+	}
+	require.Greater(t, len(after), reported)
+	require.False(t, after[len(after)-1].Forced)
+	require.Len(t, before, 1)
+}
+
+func TestOnCloseLeak(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	var leak *quickjs.CloseLeakError
+	rt.OnCloseLeak(func(err *quickjs.CloseLeakError) {
+		leak = err
+	})
+	ctx := rt.NewContext()
+	// The object is never freed: the engine asserts that no object is left when the runtime is freed.
+	_, err := ctx.Eval(`({ leaked: true })`)
+	require.NoError(t, err)
+	ctx.Close()
+	rt.Close()
+
+	require.NotNil(t, leak)
+	require.Equal(t, "objects are still alive when the runtime is freed", leak.Message)
+	require.ErrorAs(t, rt.CloseLeak(), &leak)
+}
+
+func TestStackOverflow(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithMaxStackSize(512 << 10))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`function down(n) { return down(n + 1) + 1 } down(0)`)
+	ret.Free()
+	require.ErrorIs(t, err, quickjs.ErrStackOverflow)
+	require.EqualError(t, err, "InternalError: stack overflow")
+
+	// Scripts can catch it, and the context remains usable.
+	ret, err = ctx.Eval(`try { down(0) } catch (e) { e instanceof InternalError }`)
+	require.NoError(t, err)
+	require.True(t, ret.Bool())
+	ret.Free()
+
+	// Recursion through Go functions re-entering the engine is stopped too.
+	var depth int
+	ctx.Globals().Set("host", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		depth++
+		fn := ctx.Globals().Get("guest")
+		defer fn.Free()
+		return ctx.Invoke(fn, ctx.Null())
+	}))
+	ret, err = ctx.Eval(`function guest() { return host() } guest()`)
+	ret.Free()
+	require.ErrorIs(t, err, quickjs.ErrStackOverflow)
+	require.Greater(t, depth, 10)
+
+	ret, err = ctx.Eval(`1 + 1`)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, ret.Int32())
+	ret.Free()
+
+	// Scripts cannot forge it.
+	_, err = ctx.Eval(`throw new InternalError("stack overflow")`)
+	require.EqualError(t, err, "InternalError: stack overflow")
+	require.NotErrorIs(t, err, quickjs.ErrStackOverflow)
+}
+
+func TestValueKind(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	for code, kind := range map[string]quickjs.Kind{
+		`undefined`:                         quickjs.KindUndefined,
+		`null`:                              quickjs.KindNull,
+		`true`:                              quickjs.KindBool,
+		`1`:                                 quickjs.KindNumber,
+		`1.5`:                               quickjs.KindNumber,
+		`NaN`:                               quickjs.KindNumber,
+		`1n`:                                quickjs.KindBigInt,
+		`"s"`:                               quickjs.KindString,
+		`Symbol()`:                          quickjs.KindSymbol,
+		`({})`:                              quickjs.KindObject,
+		`[]`:                                quickjs.KindArray,
+		`new Proxy([], {})`:                 quickjs.KindArray,
+		`() => {}`:                          quickjs.KindFunction,
+		`(class {})`:                        quickjs.KindFunction,
+		`new TypeError()`:                   quickjs.KindError,
+		`new (class extends Error {})()`:    quickjs.KindError,
+		`Promise.resolve()`:                 quickjs.KindPromise,
+		`new Date()`:                        quickjs.KindDate,
+		`/a/`:                               quickjs.KindRegExp,
+		`new Float64Array(2)`:               quickjs.KindTypedArray,
+		`new ArrayBuffer(2)`:                quickjs.KindArrayBuffer,
+		`new Map()`:                         quickjs.KindMap,
+		`new Set()`:                         quickjs.KindSet,
+		`new WeakMap()`:                     quickjs.KindObject,
+		`({ [Symbol.toStringTag]: "Map" })`: quickjs.KindObject,
+	} {
+		ret, err := ctx.Eval(code)
+		require.NoError(t, err, code)
+		require.Equal(t, kind, ret.Kind(), code)
+		ret.Free()
+	}
+	require.Equal(t, "TypedArray", quickjs.KindTypedArray.String())
+}
+
+func TestPromiseState(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`
+		let resolve;
+		[new Promise((r) => { resolve = r }), Promise.resolve(42), Promise.reject(new Error("nope")), 42]
+	`)
+	require.NoError(t, err)
+	defer ret.Free()
+	pending, fulfilled, rejected, number := ret.GetIdx(0), ret.GetIdx(1), ret.GetIdx(2), ret.GetIdx(3)
+	defer pending.Free()
+	defer fulfilled.Free()
+	defer rejected.Free()
+	defer number.Free()
+
+	require.Equal(t, quickjs.PromisePending, pending.PromiseState())
+	require.Equal(t, quickjs.PromiseFulfilled, fulfilled.PromiseState())
+	require.Equal(t, quickjs.PromiseRejected, rejected.PromiseState())
+	require.Equal(t, quickjs.PromiseNotAPromise, number.PromiseState())
+	require.Equal(t, "fulfilled", fulfilled.PromiseState().String())
+
+	result := fulfilled.PromiseResult()
+	require.EqualValues(t, 42, result.Int32())
+	result.Free()
+	result = rejected.PromiseResult()
+	require.EqualError(t, result.Error(), "Error: nope")
+	result.Free()
+	result = pending.PromiseResult()
+	require.True(t, result.IsUndefined())
+
+	ret2, err := ctx.Eval(`resolve("done")`)
+	require.NoError(t, err)
+	ret2.Free()
+	require.Equal(t, quickjs.PromiseFulfilled, pending.PromiseState())
+	result = pending.PromiseResult()
+	require.Equal(t, "done", result.String())
+	result.Free()
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	never, err := ctx.Eval(`new Promise(() => {})`)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = ctx.AwaitTimeout(never, 50*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	later, err := ctx.Eval(`new Promise((resolve) => setTimeout(() => resolve(42), 10))`)
+	require.NoError(t, err)
+	ret, err := ctx.AwaitTimeout(later, time.Second)
+	require.NoError(t, err)
+	require.EqualValues(t, 42, ret.Int32())
+	ret.Free()
+
+	rejected, err := ctx.Eval(`Promise.reject(new Error("nope"))`)
+	require.NoError(t, err)
+	_, err = ctx.AwaitTimeout(rejected, time.Second)
+	require.EqualError(t, err, "Error: nope")
+
+	goCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	never, err = ctx.Eval(`new Promise(() => {})`)
+	require.NoError(t, err)
+	_, err = ctx.AwaitContext(goCtx, never)
+	require.ErrorIs(t, err, context.Canceled)
+
+	ret, err = ctx.Eval(`1 + 1`)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, ret.Int32())
+	ret.Free()
+}
+
+func TestPromiseToChannel(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+
+	ret, err := ctx.Eval(`[new Promise((resolve) => setTimeout(() => resolve(42), 10)), Promise.reject(new Error("nope")), 7, new Promise(() => {})]`)
+	require.NoError(t, err)
+	later, rejected, number, never := ret.GetIdx(0), ret.GetIdx(1), ret.GetIdx(2), ret.GetIdx(3)
+	ret.Free()
+	laterCh, rejectedCh, numberCh, neverCh := later.ToChannel(), rejected.ToChannel(), number.ToChannel(), never.ToChannel()
+	later.Free()
+	rejected.Free()
+	number.Free()
+	never.Free()
+
+	var thenErr error
+	thenPromise, err := ctx.Eval(`Promise.reject(new TypeError("bad"))`)
+	require.NoError(t, err)
+	require.NoError(t, thenPromise.Then(func(ctx *quickjs.Context, result quickjs.Value, err error) { thenErr = err }))
+	thenPromise.Free()
+
+	select {
+	case <-laterCh:
+		t.Fatal("received before the jobs ran")
+	default:
+	}
+	ctx.Loop()
+
+	res := <-laterCh
+	require.NoError(t, res.Err)
+	require.EqualValues(t, 42, res.Value.Int32())
+	res.Value.Free()
+	res = <-rejectedCh
+	require.EqualError(t, res.Err, "Error: nope")
+	res = <-numberCh
+	require.NoError(t, res.Err)
+	require.EqualValues(t, 7, res.Value.Int32())
+	res.Value.Free()
+	require.EqualError(t, thenErr, "TypeError: bad")
+
+	ctx.Close()
+	res = <-neverCh
+	require.ErrorIs(t, res.Err, quickjs.ErrContextClosed)
+}
+
+func TestLoadModuleAsync(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		export const early = 1;
+		export let late;
+		await new Promise((resolve) => setTimeout(resolve, 10));
+		late = 2;
+	`, "tla")
+	require.NoError(t, err)
+	require.Equal(t, quickjs.PromisePending, done.PromiseState())
+	require.EqualValues(t, 1, ns.Get("early").Int32())
+	ret, err := ctx.AwaitTimeout(done, time.Second)
+	require.NoError(t, err)
+	ret.Free()
+	require.EqualValues(t, 2, ns.Get("late").Int32())
+	ns.Free()
+
+	ns, done, err = ctx.LoadModuleAsync(`await new Promise(() => {}); export const x = 1;`, "stuck", quickjs.ModuleTimeout(30*time.Millisecond))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, quickjs.PromisePending, done.PromiseState())
+	ns.Free()
+	done.Free()
+
+	ns, done, err = ctx.LoadModuleAsync(`await null; throw new Error("init failed");`, "failing", quickjs.ModuleTimeout(time.Second))
+	require.EqualError(t, err, "Error: init failed")
+	ns.Free()
+	done.Free()
+
+	buf, err := ctx.CompileModule("./test/fib_module.js", "fib_async")
+	require.NoError(t, err)
+	ns, done, err = ctx.LoadModuleBytecodeAsync(buf, quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, quickjs.PromiseFulfilled, done.PromiseState())
+	fib := ns.Get("fib")
+	ret = ctx.Invoke(fib, ctx.Null(), ctx.Int32(10))
+	require.EqualValues(t, 55, ret.Int32())
+	ret.Free()
+	fib.Free()
+	ns.Free()
+	done.Free()
+
+	_, _, err = ctx.LoadModuleAsync(`export const = ;`, "broken")
+	require.Error(t, err)
+}
+
+func TestModulePolicy(t *testing.T) {
+	modules := map[string]string{
+		"app/main":   `import { name } from "./util"; export const greeting = "Hello " + name;`,
+		"app/util":   `export const name = "policy";`,
+		"app/escape": `import "../secret";`,
+		"secret":     `export const key = 42;`,
+	}
+	rt := quickjs.NewRuntime(quickjs.WithModuleLoader(func(ctx *quickjs.Context, moduleName string) (string, error) {
+		code, ok := modules[moduleName]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return code, nil
+	}))
+	defer rt.Close()
+	var imports []string
+	rt.SetModulePolicy(func(importer, specifier string) error {
+		imports = append(imports, importer+" -> "+specifier)
+		if !strings.HasPrefix(specifier, "app/") {
+			return errors.New("only app modules may be imported")
+		}
+		return nil
+	})
+
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`import { greeting } from "app/main"; globalThis.result = greeting;`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	result := ctx.Globals().Get("result")
+	require.Equal(t, "Hello policy", result.String())
+	result.Free()
+	require.Contains(t, imports, "app/main -> app/util")
+
+	_, err = ctx.Eval(`import * as std from "std";`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "import of module 'std' denied: only app modules may be imported")
+
+	_, err = ctx.Eval(`import "app/escape";`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "import of module 'secret' denied")
+
+	ret, err = ctx.Eval(`import("secret").then(() => "loaded", (e) => e.message)`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.Contains(t, ret.String(), "denied")
+	ret.Free()
+
+	rt.SetModulePolicy(nil)
+	ret, err = ctx.Eval(`import { key } from "secret"; globalThis.key = key;`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	key := ctx.Globals().Get("key")
+	require.EqualValues(t, 42, key.Int32())
+	key.Free()
+}
+
+func TestHostModulePolicy(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	exports := ctx.Object()
+	exports.Set("run", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.String("ran")
+	}))
+	require.NoError(t, ctx.LoadHostModule("secret", exports, "run"))
+	require.Error(t, ctx.LoadHostModule("secret", ctx.Object(), "run"))
+	require.Empty(t, ctx.Check(`import { run } from "secret";`, quickjs.EvalFlagModule(true)))
+
+	rt.SetModulePolicy(func(importer, specifier string) error {
+		if specifier == "secret" {
+			return errors.New("not allowed")
+		}
+		return nil
+	})
+	ret, err := ctx.Eval(`Reflect.ownKeys(globalThis).filter((key) => String(key).includes("secret")).length`)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ret.Int32())
+	ret.Free()
+	_, err = ctx.Eval(`import { run } from "secret";`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "import of module 'secret' denied")
+
+	rt.SetModulePolicy(nil)
+	ret, err = ctx.Eval(`import { run } from "secret"; globalThis.result = run();`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	result := ctx.Globals().Get("result")
+	require.Equal(t, "ran", result.String())
+	result.Free()
+}
+
+func TestNativeModules(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithModuleImport(true))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	_, err := ctx.Eval(`import "./test/missing.so";`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "native modules are not enabled")
+
+	rt2 := quickjs.NewRuntime(quickjs.WithNativeModules(true))
+	defer rt2.Close()
+	ctx2 := rt2.NewContext()
+	defer ctx2.Close()
+	_, err = ctx2.Eval(`import "./test/missing.so";`, quickjs.EvalAwait(true))
+	if runtime.GOOS == "windows" {
+		require.ErrorContains(t, err, "not supported on Windows")
+	} else {
+		require.ErrorContains(t, err, "could not load module 'test/missing.so' as shared library")
+	}
+
+	// Other modules still load from files.
+	ret, err := ctx2.Eval(`import { fib } from "./test/fib_module.js"; globalThis.result = fib(10);`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ret.Free()
+	result := ctx2.Globals().Get("result")
+	require.EqualValues(t, 55, result.Int32())
+	result.Free()
+}
+
+func TestCompileDeterministic(t *testing.T) {
+	code := `
+		const config = { alpha: 1, beta: [2, 3], gamma: "x", big: 10n };
+		function sum(a, b) { return a.zeta + b.eta + 1.5; }
+		class Counter { #count = 0; inc() { return ++this.#count; } }
+		export { config, sum, Counter };
+	`
+	compile := func(warm bool) ([]byte, []byte) {
+		rt := quickjs.NewRuntime()
+		defer rt.Close()
+		ctx := rt.NewContext()
+		defer ctx.Close()
+		if warm {
+			// Create atoms and shapes in a different order than the compiled code uses them.
+			ret, err := ctx.Eval(`globalThis.eta = { omega: 1, zeta: 2, gamma: 3 }; class Other { #count = 1 }; "beta"`)
+			require.NoError(t, err)
+			ret.Free()
+		}
+		script, err := ctx.Compile(strings.ReplaceAll(code, "export", "//"), quickjs.EvalFileName("script.js"))
+		require.NoError(t, err)
+		module, err := ctx.Compile(code, quickjs.EvalFlagModule(true), quickjs.EvalFileName("module.js"))
+		require.NoError(t, err)
+		return script, module
+	}
+
+	script, module := compile(false)
+	for i := 0; i < 3; i++ {
+		script2, module2 := compile(i%2 == 1)
+		require.Equal(t, script, script2)
+		require.Equal(t, module, module2)
+	}
+
+	// The file name is part of the bytecode.
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	other, err := ctx.Compile(code, quickjs.EvalFlagModule(true), quickjs.EvalFileName("other.js"))
+	require.NoError(t, err)
+	require.NotEqual(t, module, other)
+}
+
+func TestEvalStrip(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	code := "function compute(input) {\n\tconst doubled = input * 2;\n\treturn new Error(String(doubled)).stack;\n}\ncompute(1)"
+	full, err := ctx.Compile(code, quickjs.EvalStrip(quickjs.StripNone), quickjs.EvalFileName("strip.js"))
+	require.NoError(t, err)
+	stripped, err := ctx.Compile(code, quickjs.EvalStrip(quickjs.StripDebug), quickjs.EvalFileName("strip.js"))
+	require.NoError(t, err)
+	require.Less(t, len(stripped), len(full))
+	require.False(t, bytes.Contains(full, []byte("input * 2")))
+	require.True(t, bytes.Contains(full, []byte("doubled")))
+	require.False(t, bytes.Contains(stripped, []byte("doubled")))
+	require.False(t, bytes.Contains(stripped, []byte("strip.js")))
+
+	ret, err := ctx.EvalBytecode(full)
+	require.NoError(t, err)
+	require.Contains(t, ret.String(), "at compute (strip.js:3)")
+	ret.Free()
+	ret, err = ctx.EvalBytecode(stripped)
+	require.NoError(t, err)
+	require.Contains(t, ret.String(), "at compute\n")
+	ret.Free()
+
+	ret, err = ctx.Eval("(function named() { return 1 }).toString()", quickjs.EvalStrip(quickjs.StripDebug))
+	require.NoError(t, err)
+	require.Contains(t, ret.String(), "[native code]")
+	ret.Free()
+}
+
+func TestSignedBytecode(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	buf, err := ctx.CompileModule("./test/fib_module.js", "fib_signed")
+	require.NoError(t, err)
+	signed := quickjs.SignBytecode(buf, key)
+	require.Equal(t, signed, quickjs.SignBytecode(buf, key))
+
+	verified, err := quickjs.VerifyBytecode(signed, pub)
+	require.NoError(t, err)
+	require.Equal(t, buf, verified)
+
+	_, err = ctx.LoadModuleBytecodeVerified(signed, otherPub)
+	require.ErrorIs(t, err, quickjs.ErrBytecodeSignature)
+	_, err = ctx.LoadModuleBytecodeVerified(buf, pub)
+	require.ErrorIs(t, err, quickjs.ErrBytecodeSignature)
+	tampered := append([]byte{}, signed...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = ctx.LoadModuleBytecodeVerified(tampered, pub)
+	require.ErrorIs(t, err, quickjs.ErrBytecodeSignature)
+	_, err = ctx.LoadModuleBytecodeVerified(signed[:10], pub)
+	require.ErrorIs(t, err, quickjs.ErrBytecodeSignature)
+
+	mod, err := ctx.LoadModuleBytecodeVerified(signed, pub)
+	require.NoError(t, err)
+	mod.Free()
+	ret, err := ctx.Eval(`import { fib } from "fib_signed"; globalThis.result = fib(10);`)
+	require.NoError(t, err)
+	ret.Free()
+	result := ctx.Globals().Get("result")
+	require.EqualValues(t, 55, result.Int32())
+	result.Free()
+
+	script, err := ctx.Compile("6 * 7")
+	require.NoError(t, err)
+	ret, err = ctx.EvalBytecodeVerified(quickjs.SignBytecode(script, key), pub)
+	require.NoError(t, err)
+	require.EqualValues(t, 42, ret.Int32())
+	ret.Free()
+}
+
+func TestInspect(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	for src, want := range map[string]string{
+		`"it's"`:                               `'it\'s'`,
+		`1.5`:                                  `1.5`,
+		`10n`:                                  `10n`,
+		`undefined`:                            `undefined`,
+		`Symbol("s")`:                          `Symbol(s)`,
+		`[1, "a", [2, [3, [4]]]]`:              `[ 1, 'a', [ 2, [ 3, [Array] ] ] ]`,
+		`({a: 1, "b-c": {d: null}})`:           `{ a: 1, 'b-c': { d: null } }`,
+		`({get x() { throw 1 }, set y(v) {}})`: `{ x: [Getter], y: [Setter] }`,
+		`(function add() {})`:                  `[Function: add]`,
+		`(() => {})`:                           `[Function (anonymous)]`,
+		`(class Point {})`:                     `[class Point]`,
+		`new (class Point { constructor() { this.x = 1 } })`: `Point { x: 1 }`,
+		`new Map([["k", {v: 1}]])`:                           `Map(1) { 'k' => { v: 1 } }`,
+		`new Set([1, 2])`:                                    `Set(2) { 1, 2 }`,
+		`new Uint8Array([1, 2])`:                             `Uint8Array(2) [ 1, 2 ]`,
+		`new ArrayBuffer(8)`:                                 `ArrayBuffer { byteLength: 8 }`,
+		`Promise.resolve(3)`:                                 `Promise { 3 }`,
+		`new Promise(() => {})`:                              `Promise { <pending> }`,
+		`new Date(0)`:                                        `1970-01-01T00:00:00.000Z`,
+		`/a+/g`:                                              `/a+/g`,
+		`Object.create(null)`:                                `[Object: null prototype] {}`,
+		`const o = {name: "o"}; o.self = o; o`:               `{ name: 'o', self: [Circular] }`,
+	} {
+		v, err := ctx.Eval(src)
+		require.NoError(t, err, src)
+		require.Equal(t, want, v.Inspect(), src)
+		v.Free()
+	}
+
+	v, err := ctx.Eval(`({list: Array.from({length: 20}, (_, i) => "item" + i), deep: {a: {b: {c: {}}}}})`)
+	require.NoError(t, err)
+	defer v.Free()
+	require.True(t, strings.HasPrefix(v.Inspect(), "{\n  list: [\n    'item0',\n    'item1',"))
+	require.Contains(t, v.Inspect(), "deep: { a: { b: [Object] } }")
+	require.Contains(t, v.Inspect(quickjs.InspectDepth(-1)), "deep: { a: { b: { c: {} } } }")
+	require.Contains(t, v.Inspect(quickjs.InspectMaxItems(2), quickjs.InspectBreakLength(200)), "list: [ 'item0', 'item1', ... 18 more items ]")
+	require.Contains(t, v.Inspect(quickjs.InspectColors(true)), "\x1b[32m'item0'\x1b[0m")
+}
+
+func TestParseJSONOptions(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	const payload = `{"id": 12345678901234567890, "small": -42, "zero": -0, "price": 19.99, "big": [-9007199254740993],
+		"s": "a\u0000b", "__proto__": {"x": 1}, "nested": {"ok": true, "none": null}}`
+
+	v := ctx.ParseJSON(payload)
+	require.False(t, v.IsException())
+	require.Equal(t, "12345678901234567000", v.Get("id").String())
+	v.Free()
+
+	v = ctx.ParseJSON(payload, quickjs.JSONLargeInts(quickjs.LargeIntBigInt))
+	require.False(t, v.IsException())
+	defer v.Free()
+	id := v.Get("id")
+	require.True(t, id.IsBigInt())
+	require.Equal(t, "12345678901234567890", id.BigInt().String())
+	id.Free()
+	for expr, want := range map[string]string{
+		`v.small === -42`:                 "true",
+		`Object.is(v.zero, -0)`:           "true",
+		`v.price`:                         "19.99",
+		`v.big[0] === -9007199254740993n`: "true",
+		`v.s.length`:                      "3",
+		`Object.getPrototypeOf(v) === Object.prototype && v.__proto__.x`: "1",
+		`JSON.stringify(v.nested)`:                                       `{"ok":true,"none":null}`,
+	} {
+		fn, err := ctx.Eval("(v) => " + expr)
+		require.NoError(t, err)
+		ret, err := ctx.InvokeE(fn, ctx.Null(), v)
+		require.NoError(t, err, expr)
+		require.Equal(t, want, ret.String(), expr)
+		ret.Free()
+		fn.Free()
+	}
+
+	v2 := ctx.ParseJSON(payload, quickjs.JSONLargeInts(quickjs.LargeIntError))
+	require.True(t, v2.IsException())
+	require.ErrorContains(t, ctx.Exception(), "SyntaxError: JSON integer 12345678901234567890 cannot be represented exactly")
+
+	const dup = `{"a": 1, "b": 2, "a": 3}`
+	for policy, want := range map[quickjs.DuplicateKeyPolicy]string{
+		quickjs.DuplicateKeyLast:  `{"a":3,"b":2}`,
+		quickjs.DuplicateKeyFirst: `{"a":1,"b":2}`,
+	} {
+		v := ctx.ParseJSON(dup, quickjs.JSONDuplicateKeys(policy))
+		require.Equal(t, want, v.JSONStringify())
+		v.Free()
+	}
+	v2 = ctx.ParseJSON(dup, quickjs.JSONDuplicateKeys(quickjs.DuplicateKeyError))
+	require.True(t, v2.IsException())
+	require.ErrorContains(t, ctx.Exception(), `duplicate key "a"`)
+
+	v2 = ctx.ParseJSON(`[[{"a": [1]}]]`, quickjs.JSONMaxDepth(4))
+	require.False(t, v2.IsException())
+	v2.Free()
+	v2 = ctx.ParseJSON(`[[{"a": [1]}]]`, quickjs.JSONMaxDepth(3))
+	require.True(t, v2.IsException())
+	require.ErrorContains(t, ctx.Exception(), "nested deeper than 3 levels")
+
+	for _, bad := range []string{`{"a": }`, `[1, 2`, `1 2`, ``} {
+		v2 = ctx.ParseJSON(bad, quickjs.JSONMaxDepth(10))
+		require.True(t, v2.IsException(), bad)
+		require.ErrorContains(t, ctx.Exception(), "SyntaxError", bad)
+	}
+}
+
+func TestJSONStreaming(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`{"rows": [`))
+		for i := 0; i < 10000; i++ {
+			if i > 0 {
+				pw.Write([]byte(","))
+			}
+			fmt.Fprintf(pw, `{"id": %d, "name": "row %d"}`, i, i)
+		}
+		pw.Write([]byte(`], "total": 9007199254740993}`))
+		pw.Close()
+	}()
+	v, err := ctx.ParseJSONReader(pr, quickjs.JSONLargeInts(quickjs.LargeIntBigInt))
+	require.NoError(t, err)
+	defer v.Free()
+	rows := v.Get("rows")
+	require.EqualValues(t, 10000, rows.Len())
+	last := rows.GetIdx(9999)
+	require.Equal(t, "row 9999", last.Get("name").String())
+	last.Free()
+	rows.Free()
+	require.Equal(t, "9007199254740993", v.Get("total").BigInt().String())
+
+	_, err = ctx.ParseJSONReader(strings.NewReader(`{"a": [1, 2}`))
+	require.ErrorContains(t, err, "SyntaxError")
+	readErr := errors.New("connection reset")
+	_, err = ctx.ParseJSONReader(io.MultiReader(strings.NewReader(`[1, 2`), iotest.ErrReader(readErr)))
+	require.ErrorIs(t, err, readErr)
+
+	obj, err := ctx.Eval(`({
+		s: "quote \" backslash \\ newline \n tab \t nul \u0000 unicode é 😀",
+		n: [1, -0.5, 1e21, NaN, Infinity, undefined, () => 1, Symbol("x")],
+		nested: {a: null, b: true, skip: undefined, fn() {}},
+		date: new Date(0),
+		boxed: [new Number(3), new String("s"), new Boolean(false)],
+		custom: {toJSON(key) { return "key:" + key }},
+		gone: {toJSON() { return undefined }},
+		empty: {},
+		list: [],
+	})`)
+	require.NoError(t, err)
+	defer obj.Free()
+	var buf bytes.Buffer
+	require.NoError(t, obj.JSONStringifyWriter(&buf))
+	require.Equal(t, obj.JSONStringify(), buf.String())
+
+	for src, want := range map[string]string{
+		`({big: 1n})`:                               "BigInt",
+		`const c = {}; c.self = c; c`:               "circular",
+		`({get x() { throw new Error("getter") }})`: "getter",
+	} {
+		v, err := ctx.Eval(src)
+		require.NoError(t, err)
+		require.ErrorContains(t, v.JSONStringifyWriter(io.Discard), want, src)
+		v.Free()
+	}
+
+	buf.Reset()
+	undef := ctx.Undefined()
+	require.NoError(t, undef.JSONStringifyWriter(&buf))
+	require.Empty(t, buf.String())
+}
+
+func TestValueJSONMarshaling(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	result, err := ctx.Eval(`({total: 3, items: ["a", "b"], meta: {ok: true, at: new Date(0)}})`)
+	require.NoError(t, err)
+	defer result.Free()
+	fn, err := ctx.Eval(`() => 1`)
+	require.NoError(t, err)
+	defer fn.Free()
+
+	type response struct {
+		ID     string        `json:"id"`
+		Result quickjs.Value `json:"result"`
+		Fn     quickjs.Value `json:"fn"`
+		Empty  quickjs.Value `json:"empty"`
+	}
+	data, err := json.Marshal(response{ID: "r1", Result: result, Fn: fn})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"r1","result":{"total":3,"items":["a","b"],"meta":{"ok":true,"at":"1970-01-01T00:00:00.000Z"}},"fn":null,"empty":null}`, string(data))
+
+	big, err := ctx.Eval(`({n: 1n})`)
+	require.NoError(t, err)
+	defer big.Free()
+	_, err = json.Marshal(big)
+	require.ErrorContains(t, err, "TypeError")
+
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Data   quickjs.Value   `json:"data"`
+	}
+	req.Data = ctx.Undefined()
+	require.NoError(t, json.Unmarshal([]byte(`{"method":"sum","params":[1,2,3],"data":{"k":[true]}}`), &req))
+	defer req.Data.Free()
+	require.Equal(t, `{"k":[true]}`, req.Data.JSONStringify())
+
+	params, err := ctx.FromJSONRawMessage(req.Params)
+	require.NoError(t, err)
+	defer params.Free()
+	require.EqualValues(t, 3, params.Len())
+	_, err = ctx.FromJSONRawMessage(json.RawMessage(`[1,`))
+	require.ErrorContains(t, err, "SyntaxError")
+
+	var unbound struct{ Data quickjs.Value }
+	require.Error(t, json.Unmarshal([]byte(`{"Data":1}`), &unbound))
+}
+
+func TestMsgpackCBOR(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	tree, err := ctx.Eval(`({
+		name: "a\u0000b", n: 42, neg: -7, zero: -0, f: 1.5, big: 12345678901234567890123n, small: -3n,
+		at: new Date(1234567), bytes: new Uint8Array([1, 2, 3]), floats: new Float64Array([0.5, -2]),
+		bigs: new BigInt64Array([-1n, 2n]), buf: new ArrayBuffer(2), list: [1, "x", null, undefined, [true]],
+		map: new Map([[1, "one"], ["k", {deep: false}]]), set: new Set(["s", 2]), fn() {}, none: undefined,
+	})`)
+	require.NoError(t, err)
+	defer tree.Free()
+	check, err := ctx.Eval(`(v) => [
+		v.name === "a\u0000b", v.n === 42, v.neg === -7, Object.is(v.zero, -0), v.f === 1.5,
+		v.big === 12345678901234567890123n, v.small === -3n, v.at instanceof Date && v.at.getTime() === 1234567,
+		v.bytes instanceof Uint8Array && v.bytes.join() === "1,2,3",
+		v.floats instanceof Float64Array && v.floats.join() === "0.5,-2",
+		v.bigs instanceof BigInt64Array && v.bigs.join() === "-1,2",
+		v.buf instanceof ArrayBuffer && v.buf.byteLength === 2,
+		JSON.stringify(v.list) === '[1,"x",null,null,[true]]' && v.list[3] == null,
+		v.map instanceof Map && v.map.get(1) === "one" && v.map.get("k").deep === false,
+		Array.isArray(v.set) && v.set.join() === "s,2", !("fn" in v), "none" in v && v.none == null,
+	].indexOf(false)`)
+	require.NoError(t, err)
+	defer check.Free()
+
+	for name, codec := range map[string]struct {
+		marshal   func(quickjs.Value) ([]byte, error)
+		unmarshal func([]byte) (quickjs.Value, error)
+	}{
+		"msgpack": {quickjs.Value.MarshalMsgpack, ctx.UnmarshalMsgpack},
+		"cbor":    {quickjs.Value.MarshalCBOR, ctx.UnmarshalCBOR},
+	} {
+		data, err := codec.marshal(tree)
+		require.NoError(t, err, name)
+		v, err := codec.unmarshal(data)
+		require.NoError(t, err, name)
+		failed, err := ctx.InvokeE(check, ctx.Null(), v)
+		require.NoError(t, err, name)
+		require.EqualValues(t, -1, failed.Int32(), name)
+		failed.Free()
+		v.Free()
+
+		for i := 0; i < len(data); i++ {
+			_, err = codec.unmarshal(data[:i])
+			require.Error(t, err, "%s truncated at %d", name, i)
+		}
+	}
+
+	obj, err := ctx.Eval(`({a: 1})`)
+	require.NoError(t, err)
+	defer obj.Free()
+	data, err := obj.MarshalMsgpack()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x81, 0xa1, 'a', 0x01}, data)
+	data, err = obj.MarshalCBOR()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xa1, 0x61, 'a', 0x01}, data)
+
+	// Indefinite lengths, half floats and RFC 3339 dates from other CBOR encoders.
+	v, err := ctx.UnmarshalCBOR([]byte{0x9f, 0xf9, 0x3c, 0x00, 0x7f, 0x61, 'a', 0x61, 'b', 0xff,
+		0xc0, 0x74, '1', '9', '7', '0', '-', '0', '1', '-', '0', '1', 'T', '0', '0', ':', '0', '0', ':', '0', '1', 'Z', 0xff})
+	require.NoError(t, err)
+	require.Equal(t, "[ 1, 'ab', 1970-01-01T00:00:01.000Z ]", v.Inspect())
+	v.Free()
+
+	cyclic, err := ctx.Eval(`const c = {}; c.self = c; c`)
+	require.NoError(t, err)
+	defer cyclic.Free()
+	_, err = cyclic.MarshalMsgpack()
+	require.ErrorContains(t, err, "circular")
+	_, err = cyclic.MarshalCBOR()
+	require.ErrorContains(t, err, "circular")
+
+	var buf bytes.Buffer
+	type message struct {
+		ID   int
+		Data quickjs.Value
+	}
+	require.NoError(t, gob.NewEncoder(&buf).Encode(message{ID: 1, Data: obj}))
+	out := message{Data: ctx.Undefined()}
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&out))
+	defer out.Data.Free()
+	require.Equal(t, 1, out.ID)
+	require.Equal(t, `{"a":1}`, out.Data.JSONStringify())
+}
+
+func TestEvalStats(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	v, stats, err := ctx.EvalStats(`
+		let kept = [];
+		for (let i = 0; i < 200000; i++) {
+			const a = {}; a.self = a;
+			if (i % 1000 === 0) kept.push(new Array(1000).fill(i));
+		}
+		kept.length`)
+	require.NoError(t, err)
+	require.EqualValues(t, 200, v.Int32())
+	v.Free()
+	require.Positive(t, stats.Duration)
+	require.Greater(t, stats.PeakMemoryDelta, uint64(200*1000*8))
+	require.Greater(t, stats.GCSentinelCollections, uint64(1))
+	require.Greater(t, stats.InterruptChecks, uint64(10))
+
+	v, stats, err = ctx.EvalStats(`1 + 1`)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, v.Int32())
+	require.Less(t, stats.PeakMemoryDelta, uint64(64<<10))
+	require.Zero(t, stats.GCSentinelCollections)
+	require.Zero(t, stats.InterruptChecks)
+
+	// Statistics of an evaluation started by a Go function called from another are included in the outer ones.
+	var inner quickjs.EvalStats
+	ctx.Globals().Set("inner", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		v, stats, err := ctx.EvalStats(`new Array(100000).fill(0).length`)
+		require.NoError(t, err)
+		inner = stats
+		return v
+	}))
+	v, stats, err = ctx.EvalStats(`inner()`)
+	require.NoError(t, err)
+	require.EqualValues(t, 100000, v.Int32())
+	v.Free()
+	require.Greater(t, inner.PeakMemoryDelta, uint64(100000*8))
+	require.GreaterOrEqual(t, stats.PeakMemoryDelta, inner.PeakMemoryDelta)
+
+	_, _, err = ctx.EvalStats(`throw new Error("boom")`)
+	require.ErrorContains(t, err, "boom")
+}
+
+func TestContextTimezoneAndLocale(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	rt := quickjs.NewRuntime(quickjs.WithTimezone(paris), quickjs.WithLocale("de-DE"))
+	defer rt.Close()
+
+	hours := func(ctx *quickjs.Context) string {
+		ret, err := ctx.Eval(`[new Date(Date.UTC(2024, 0, 2, 12)).getHours(), (1234.5).toLocaleString()].join("|")`)
+		require.NoError(t, err)
+		defer ret.Free()
+		return ret.String()
+	}
+
+	def := rt.NewContext()
+	defer def.Close()
+	require.Equal(t, "13|1.234,5", hours(def))
+	require.Equal(t, paris, def.Timezone())
+	require.Equal(t, "de-DE", def.Locale())
+
+	tokyo := rt.NewContext(quickjs.ContextTZ(":Asia/Tokyo"), quickjs.ContextLocale("en-US"))
+	defer tokyo.Close()
+	require.Equal(t, "21|1,234.5", hours(tokyo))
+	require.Equal(t, "Asia/Tokyo", tokyo.Timezone().String())
+
+	unknown := rt.NewContext(quickjs.ContextTZ("Nowhere/Special"))
+	defer unknown.Close()
+	require.Equal(t, "12|1.234,5", hours(unknown))
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	require.NoError(t, tokyo.SetTimezone(ny))
+	require.NoError(t, tokyo.SetLocale("fr-FR"))
+	require.Equal(t, "7|1\u00a0234,5", hours(tokyo))
+
+	require.NoError(t, tokyo.WithRealm(func(realm *quickjs.Context) error {
+		require.Equal(t, "7|1\u00a0234,5", hours(realm))
+		return nil
+	}))
+	fork, err := tokyo.Fork()
+	require.NoError(t, err)
+	defer fork.Close()
+	require.Equal(t, "7|1\u00a0234,5", hours(fork))
+
+	plain := quickjs.NewRuntime()
+	defer plain.Close()
+	ctx := plain.NewContext(quickjs.ContextTimezone(time.UTC))
+	defer ctx.Close()
+	require.Equal(t, "12", strings.Split(hours(ctx), "|")[0])
+	require.Empty(t, ctx.Locale())
+}
+
+func TestNewContextE(t *testing.T) {
+	// The time zone and the limits do not need the RegExp and Proxy intrinsics.
+	rt := quickjs.NewRuntime(quickjs.WithIntrinsics(quickjs.IntrinsicDate|quickjs.IntrinsicTypedArrays),
+		quickjs.WithTimezone(time.UTC), quickjs.WithLimits(quickjs.Limits{MaxArrayLength: 10, SafeRegExp: true}))
+	defer rt.Close()
+	ctx, err := rt.NewContextE(quickjs.ContextTZ("Asia/Tokyo"))
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`[
+		new Date(Date.UTC(2024, 0, 2, 12)).getHours(),
+		new Date("2024-01-02T12:00:00Z").getHours(),
+		Date.name, typeof Date.now(), new Date() instanceof Date, typeof Date(),
+		class extends Date {}.UTC(2024, 0) === Date.UTC(2024, 0),
+		Array(3).length, new Uint8Array(2).length,
+	].join()`)
+	require.NoError(t, err)
+	require.Equal(t, "21,21,Date,number,true,string,true,3,2", ret.String())
+	ret.Free()
+	for _, code := range []string{`Array(11)`, `new Uint8Array(11)`, `Uint8Array(1)`} {
+		ret, err = ctx.Eval(code)
+		ret.Free()
+		require.Error(t, err, code)
+	}
+
+	ctx2 := rt.NewContext()
+	defer ctx2.Close()
+	ret, err = ctx2.Eval(`new Date(Date.UTC(2024, 0, 2, 12)).getHours()`)
+	require.NoError(t, err)
+	require.Equal(t, int32(12), ret.Int32())
+	ret.Free()
+}
+
+func TestIntl(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithTimezone(time.UTC))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, ctx.InstallIntl())
+
+	for src, want := range map[string]string{
+		`new Intl.NumberFormat().format(1234567.891)`:                                                             "1,234,567.891",
+		`new Intl.NumberFormat("de-DE").format(-1234.5)`:                                                          "-1.234,5",
+		`new Intl.NumberFormat("en-US", {maximumFractionDigits: 2}).format(1.005)`:                                "1.01",
+		`new Intl.NumberFormat("en-US", {maximumFractionDigits: 0}).format(2.5)`:                                  "3",
+		`new Intl.NumberFormat("en-US", {minimumFractionDigits: 2, useGrouping: false}).format(1e4)`:              "10000.00",
+		`new Intl.NumberFormat("en-US", {minimumIntegerDigits: 3}).format(7)`:                                     "007",
+		`new Intl.NumberFormat("en-US", {style: "percent"}).format(0.256)`:                                        "26%",
+		`new Intl.NumberFormat("fr-FR", {style: "percent", maximumFractionDigits: 1}).format(0.2555)`:             "25,6 %",
+		`new Intl.NumberFormat("en-US", {style: "currency", currency: "USD"}).format(-1234.5)`:                    "-$1,234.50",
+		`new Intl.NumberFormat("de-DE", {style: "currency", currency: "EUR"}).format(1234.5)`:                     "1.234,50 €",
+		`new Intl.NumberFormat("ja-JP", {style: "currency", currency: "JPY"}).format(1234.5)`:                     "￥1,235",
+		`new Intl.NumberFormat("en-US", {style: "currency", currency: "CHF"}).format(3)`:                          "CHF 3.00",
+		`new Intl.NumberFormat("en-US", {style: "currency", currency: "EUR", currencyDisplay: "code"}).format(3)`: "EUR 3.00",
+		`[NaN, Infinity, -Infinity].map(new Intl.NumberFormat().format).join()`:                                   "NaN,∞,-∞",
+		`(1234.5).toLocaleString("en-US", {style: "currency", currency: "usd"})`:                                  "$1,234.50",
+		`JSON.stringify(new Intl.NumberFormat("de", {style: "currency", currency: "EUR"}).resolvedOptions())`:     `{"locale":"de","numberingSystem":"latn","style":"currency","currency":"EUR","currencyDisplay":"symbol","minimumIntegerDigits":1,"minimumFractionDigits":2,"maximumFractionDigits":2,"useGrouping":true,"notation":"standard","signDisplay":"auto"}`,
+
+		`new Intl.DateTimeFormat().format(new Date(Date.UTC(2024, 0, 2, 15, 4, 5)))`:                                                                                                     "1/2/2024",
+		`new Intl.DateTimeFormat("en-GB").format(Date.UTC(2024, 0, 2))`:                                                                                                                  "02/01/2024",
+		`new Intl.DateTimeFormat("de-DE").format(Date.UTC(2024, 0, 2))`:                                                                                                                  "2.1.2024",
+		`new Intl.DateTimeFormat("en-US", {dateStyle: "full"}).format(Date.UTC(2024, 0, 2))`:                                                                                             "Tuesday, January 2, 2024",
+		`new Intl.DateTimeFormat("en-US", {dateStyle: "long", timeStyle: "short"}).format(Date.UTC(2024, 0, 2, 15, 4))`:                                                                  "January 2, 2024, 3:04 PM",
+		`new Intl.DateTimeFormat("de-DE", {dateStyle: "full"}).format(Date.UTC(2024, 0, 2))`:                                                                                             "Dienstag, 2. Januar 2024",
+		`new Intl.DateTimeFormat("fr-FR", {dateStyle: "long"}).format(Date.UTC(2024, 6, 14))`:                                                                                            "14 juillet 2024",
+		`new Intl.DateTimeFormat("es-ES", {dateStyle: "long"}).format(Date.UTC(2024, 0, 2))`:                                                                                             "2 de enero de 2024",
+		`new Intl.DateTimeFormat("ja-JP", {dateStyle: "long"}).format(Date.UTC(2024, 0, 2))`:                                                                                             "2024年1月2日",
+		`new Intl.DateTimeFormat("en-US", {month: "long", year: "numeric"}).format(Date.UTC(2024, 0, 2))`:                                                                                "January 2024",
+		`new Intl.DateTimeFormat("en-US", {month: "short", day: "numeric"}).format(Date.UTC(2024, 0, 2))`:                                                                                "Jan 2",
+		`new Intl.DateTimeFormat("en-US", {weekday: "short"}).format(Date.UTC(2024, 0, 2))`:                                                                                              "Tue",
+		`new Intl.DateTimeFormat("en-US", {hour: "numeric", minute: "2-digit", timeZone: "Asia/Tokyo"}).format(Date.UTC(2024, 0, 2, 15, 4))`:                                             "12:04 AM",
+		`new Intl.DateTimeFormat("de-DE", {timeStyle: "long", timeZone: "Europe/Berlin"}).format(Date.UTC(2024, 6, 2, 15, 4, 5))`:                                                        "17:04:05 GMT+2",
+		`new Intl.DateTimeFormat("en-US", {hour: "2-digit", minute: "2-digit", second: "2-digit", hour12: false, fractionalSecondDigits: 2}).format(Date.UTC(2024, 0, 2, 9, 4, 5, 678))`: "09:04:05.67",
+		`new Date(Date.UTC(2024, 0, 2, 15, 4, 5)).toLocaleString()`:                                                                                                                      "1/2/2024, 3:04:05 PM",
+		`new Date(Date.UTC(2024, 0, 2, 15, 4, 5)).toLocaleDateString("fr-FR")`:                                                                                                           "02/01/2024",
+		`new Date(Date.UTC(2024, 0, 2, 15, 4, 5)).toLocaleTimeString("de-DE")`:                                                                                                           "15:04:05",
+		`new Date(NaN).toLocaleString()`: "Invalid Date",
+		`new Intl.DateTimeFormat("en", {timeZone: "America/New_York"}).resolvedOptions().timeZone`: "America/New_York",
+
+		`["b", "a", "ä", "z", "A"].sort(new Intl.Collator("de").compare).join("")`:          "aAäbz",
+		`["b", "a", "ä", "z"].sort(new Intl.Collator("sv").compare).join("")`:               "abzä",
+		`new Intl.Collator("en", {sensitivity: "base"}).compare("a", "Á")`:                  "0",
+		`["item10", "item9"].sort(new Intl.Collator("en", {numeric: true}).compare).join()`: "item9,item10",
+		`"a".localeCompare("B")`:                                   "-1",
+		`Intl.getCanonicalLocales(["EN-us", "zh-hant-tw"]).join()`: "en-US,zh-Hant-TW",
+		`Intl.NumberFormat.supportedLocalesOf("de-de").join()`:     "de-DE",
+		`Object.prototype.toString.call(Intl)`:                     "[object Intl]",
+	} {
+		v, err := ctx.Eval(src)
+		require.NoError(t, err, src)
+		require.Equal(t, want, v.String(), src)
+		v.Free()
+	}
+
+	for src, want := range map[string]string{
+		`new Intl.NumberFormat("en", {style: "currency"})`:                    "TypeError: Currency code is required with currency style.",
+		`new Intl.NumberFormat("en", {style: "unit"})`:                        "RangeError: Value unit out of range for Intl.NumberFormat options property style",
+		`new Intl.NumberFormat("en", {maximumFractionDigits: 50})`:            "RangeError: maximumFractionDigits value is out of range",
+		`new Intl.NumberFormat("not a tag!")`:                                 "RangeError: Incorrect locale information provided: not a tag!",
+		`new Intl.DateTimeFormat("en", {timeZone: "Mars/Base"})`:              "RangeError: Invalid time zone specified: Mars/Base",
+		`new Intl.DateTimeFormat("en", {dateStyle: "full", year: "numeric"})`: "TypeError: dateStyle and timeStyle can't be used with other date or time options",
+		`new Intl.DateTimeFormat().format(NaN)`:                               "RangeError: Invalid time value",
+	} {
+		_, err := ctx.Eval(src)
+		require.EqualError(t, err, want, src)
+	}
+
+	// The default locale is the one of the context.
+	require.NoError(t, ctx.SetLocale("de-DE"))
+	v, err := ctx.Eval(`new Intl.NumberFormat().format(1234.5) + " " + new Date(Date.UTC(2024, 0, 2)).toLocaleDateString()`)
+	require.NoError(t, err)
+	require.Equal(t, "1.234,5 2.1.2024", v.String())
+	v.Free()
+}
+
+func TestUnicode(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, ctx.InstallUnicode())
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import * as unicode from "unicode";
+		import { normalize, graphemes } from "unicode";
+		const family = "\u{1f468}\u200d\u{1f469}\u200d\u{1f467}", flag = "\u{1f1f3}\u{1f1f1}";
+		globalThis.results = [
+			normalize("e\u0301") === "\u00e9",
+			normalize("\u00e9", "NFD") === "e\u0301",
+			normalize("\ufb01", "NFKC"),
+			unicode.normalize("\u2460", "NFKD"),
+			unicode.isNormalized("e\u0301"),
+			unicode.isNormalized("e\u0301", "NFD"),
+			unicode.casefold("Straße"),
+			unicode.casefold("ΣΑΣ") === unicode.casefold("σας"),
+			unicode.toUpperCase("istanbul", "tr") === "\u0130STANBUL",
+			unicode.toUpperCase("istanbul"),
+			unicode.toLowerCase("\u0130", "tr"),
+			unicode.toTitleCase("hello wORLD"),
+			graphemes(family + "e\u0301" + flag).map((g) => g.length),
+			unicode.graphemeCount(family + "e\u0301" + flag),
+		];
+		globalThis.keys = Object.keys(unicode).join();
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	results, err := ctx.Eval(`JSON.stringify(results)`)
+	require.NoError(t, err)
+	defer results.Free()
+	require.JSONEq(t, `[true, true, "fi", "1", false, true, "strasse", true, true, "ISTANBUL", "i", "Hello World",
+		[8, 2, 4], 3]`, results.String())
+
+	keys, err := ctx.Eval(`keys`)
+	require.NoError(t, err)
+	defer keys.Free()
+	require.Equal(t, "casefold,graphemeCount,graphemes,isNormalized,normalize,toLowerCase,toTitleCase,toUpperCase", keys.String())
+
+	_, err = ctx.Eval(`import("unicode").then((u) => u.normalize("x", "NFX"))`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "RangeError: The normalization form should be one of NFC, NFD, NFKC, NFKD.")
+	_, err = ctx.Eval(`import("unicode").then((u) => u.toUpperCase("x", "not a tag!"))`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "RangeError: Incorrect locale information provided")
+	_, err = ctx.Eval(`import("unicode").then((u) => u.casefold(1))`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "TypeError: argument 0 must be a string")
+
+	undefined, err := ctx.Eval(`typeof __hostModuleExports`)
+	require.NoError(t, err)
+	defer undefined.Free()
+	require.Equal(t, "undefined", undefined.String())
+}
+
+func TestPerformance(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var entries []quickjs.PerformanceEntry
+	rt.SetPerformanceHook(func(ctx *quickjs.Context, entry quickjs.PerformanceEntry) {
+		entries = append(entries, entry)
+	})
+	before := time.Now()
+	require.NoError(t, ctx.InstallPerformance())
+
+	ret, err := ctx.Eval(`
+		const t0 = performance.now();
+		performance.mark("start", { detail: { step: 1 } });
+		const until = performance.now() + 5;
+		while (performance.now() < until);
+		performance.mark("end");
+		performance.measure("work", "start", "end");
+		performance.measure("total");
+		performance.measure("fixed", { start: 1, duration: 2 });
+		JSON.stringify({
+			monotonic: performance.now() >= t0 && t0 >= 0,
+			origin: Math.abs(performance.timeOrigin + t0 - Date.now()) < 1000,
+			types: performance.getEntries().map((e) => e.entryType + ":" + e.name),
+			work: performance.getEntriesByName("work")[0].duration >= 5,
+			fixed: performance.getEntriesByName("fixed", "measure").map((e) => [e.startTime, e.duration]),
+			detail: performance.getEntriesByType("mark")[0].detail,
+			tag: Object.prototype.toString.call(performance),
+		})
+	`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"monotonic": true, "origin": true, "types": ["mark:start", "mark:end", "measure:work", "measure:total",
+		"measure:fixed"], "work": true, "fixed": [[1, 2]], "detail": {"step": 1}, "tag": "[object Performance]"}`, ret.String())
+	ret.Free()
+
+	require.Len(t, entries, 5)
+	require.Equal(t, "mark", entries[0].EntryType)
+	require.Equal(t, "start", entries[0].Name)
+	require.Zero(t, entries[0].Duration)
+	require.True(t, !entries[0].Start.Before(before))
+	work := entries[2]
+	require.Equal(t, quickjs.PerformanceEntry{Name: "work", EntryType: "measure", Start: entries[0].Start, Duration: work.Duration}, work)
+	require.GreaterOrEqual(t, work.Duration, 5*time.Millisecond)
+	require.Equal(t, 2*time.Millisecond, entries[4].Duration)
+
+	ret, err = ctx.Eval(`
+		performance.clearMarks("start");
+		performance.clearMeasures();
+		performance.getEntries().map((e) => e.name).join()
+	`)
+	require.NoError(t, err)
+	require.Equal(t, "end", ret.String())
+	ret.Free()
+
+	_, err = ctx.Eval(`performance.measure("missing", "start")`)
+	require.EqualError(t, err, "SyntaxError: The mark 'start' does not exist.")
+	_, err = ctx.Eval(`performance.mark("bad", { startTime: -1 })`)
+	require.EqualError(t, err, "TypeError: startTime cannot be negative")
+}
+
+func TestEnvironmentProfile(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext(quickjs.ContextLocale("fr-FR"))
+	defer ctx.Close()
+
+	profile := quickjs.BrowserProfile
+	profile.UserAgent = "Mozilla/5.0 (test)"
+	profile.Location = "https://Example.com:8443/app/index.html?q=1#top"
+	require.NoError(t, ctx.SetEnvironmentProfile(profile))
+
+	ret, err := ctx.Eval(`JSON.stringify({
+		ua: navigator.userAgent,
+		language: navigator.language,
+		languages: navigator.languages,
+		cores: navigator.hardwareConcurrency > 0,
+		aliases: self === globalThis && window === globalThis && window.navigator === navigator,
+		document: typeof document,
+		href: String(location),
+		location: [location.protocol, location.host, location.hostname, location.port, location.pathname, location.search, location.hash, location.origin],
+		tags: [Object.prototype.toString.call(navigator), Object.prototype.toString.call(location)],
+	})`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"ua": "Mozilla/5.0 (test)", "language": "fr-FR", "languages": ["fr-FR"], "cores": true, "aliases": true,
+		"document": "undefined", "href": "https://example.com:8443/app/index.html?q=1#top",
+		"location": ["https:", "example.com:8443", "example.com", "8443", "/app/index.html", "?q=1", "#top", "https://example.com:8443"],
+		"tags": ["[object Navigator]", "[object Location]"]}`, ret.String())
+	ret.Free()
+
+	require.NoError(t, ctx.SetEnvironmentProfile(quickjs.EnvironmentProfile{Languages: []string{"de-DE", "en"}, Location: "http://localhost"}))
+	ret, err = ctx.Eval(`[navigator.userAgent, navigator.language, navigator.languages.join(), location.href, location.pathname].join(" ")`)
+	require.NoError(t, err)
+	require.Equal(t, "quickjs-go de-DE de-DE,en http://localhost/ /", ret.String())
+	ret.Free()
+
+	worker := rt.NewContext()
+	defer worker.Close()
+	require.NoError(t, worker.SetEnvironmentProfile(quickjs.WorkerProfile))
+	ret, err = worker.Eval(`[typeof window, self === globalThis, navigator.language, location.href, location.origin].join()`)
+	require.NoError(t, err)
+	require.Equal(t, "undefined,true,en-US,about:blank,null", ret.String())
+	ret.Free()
+
+	require.ErrorContains(t, worker.SetEnvironmentProfile(quickjs.EnvironmentProfile{Location: "/relative"}), "not an absolute URL")
+}
+
+func TestProcess(t *testing.T) {
+	t.Setenv("QJS_TEST_VISIBLE", "yes")
+	t.Setenv("QJS_TEST_SECRET", "hidden")
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	var exitCodes []int
+	require.NoError(t, ctx.InstallProcess(
+		quickjs.ProcessEnv(func(name string) bool { return name == "QJS_TEST_VISIBLE" || name == "QJS_TEST_UNSET" }),
+		quickjs.ProcessArgv("node", "script.js", "--verbose"),
+		quickjs.ProcessPlatform("linux"),
+		quickjs.ProcessExit(func(ctx *quickjs.Context, code int) { exitCodes = append(exitCodes, code) }),
+	))
+
+	ret, err := ctx.Eval(`
+		process.env.LOCAL = 42;
+		const keys = Object.keys(process.env);
+		delete process.env.QJS_TEST_VISIBLE;
+		JSON.stringify({
+			visible: process.env.QJS_TEST_VISIBLE === undefined && keys.includes("QJS_TEST_VISIBLE"),
+			secret: process.env.QJS_TEST_SECRET ?? null,
+			unset: "QJS_TEST_UNSET" in process.env,
+			local: process.env.LOCAL,
+			keys: Object.keys(process.env),
+			args: process.argv.slice(2),
+			platform: process.platform,
+			arch: typeof process.arch,
+			tag: Object.prototype.toString.call(process),
+		})
+	`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"visible": true, "secret": null, "unset": false, "local": "42", "keys": ["LOCAL"], "args": ["--verbose"],
+		"platform": "linux", "arch": "string", "tag": "[object process]"}`, ret.String())
+	ret.Free()
+	require.Equal(t, "yes", os.Getenv("QJS_TEST_VISIBLE"))
+
+	_, err = ctx.Eval(`globalThis.after = false; try { process.exit(3) } catch (e) {} globalThis.after = true`)
+	var exitErr *quickjs.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 3, exitErr.Code)
+	_, err = ctx.Eval(`process.exitCode = 2; process.exit()`)
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 2, exitErr.Code)
+	require.Equal(t, []int{3, 2}, exitCodes)
+
+	ret, err = ctx.Eval(`after`)
+	require.NoError(t, err)
+	require.False(t, ret.Bool())
+	ret.Free()
+
+	_, err = ctx.Eval(`throw new Error("quickjs: process exited with code 2")`)
+	require.False(t, errors.As(err, &exitErr))
+
+	ret, err = ctx.Eval(`const ticks = []; process.nextTick((a, b) => ticks.push(a + b), 1, 2); ticks.push(0); ticks`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	ctx.Loop()
+	ret.Free()
+	ret, err = ctx.Eval(`ticks.join()`)
+	require.NoError(t, err)
+	require.Equal(t, "0,3", ret.String())
+	ret.Free()
+}
+
+func TestSetEnv(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	require.NoError(t, ctx.SetEnv(map[string]string{"API_URL": "https://api.example.com", "MODE": "production"}))
+	ret, err := ctx.Eval(`"use strict";
+		const errors = [];
+		for (const f of [() => { env.MODE = "debug" }, () => { env.NEW = "1" }, () => { delete env.MODE }, () => { env = {} }]) {
+			try { f() } catch (e) { errors.push(e.name) }
+		}
+		JSON.stringify({ env, frozen: Object.isFrozen(env), errors, typeofProcess: typeof process })
+	`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"env": {"API_URL": "https://api.example.com", "MODE": "production"}, "frozen": true,
+		"errors": ["TypeError", "TypeError", "TypeError", "TypeError"], "typeofProcess": "undefined"}`, ret.String())
+	ret.Free()
+
+	require.NoError(t, ctx.SetEnv(nil))
+	ret, err = ctx.Eval(`JSON.stringify(env)`)
+	require.NoError(t, err)
+	require.Equal(t, "{}", ret.String())
+	ret.Free()
+}
+
+func TestRateLimits(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+
+	policy := quickjs.NewRateLimitPolicy().
+		Limit("ids.uuid", 2, time.Hour).
+		Limit("fmt", 3, time.Hour).
+		Limit("greet", 1, 50*time.Millisecond)
+	newContext := func() *quickjs.Context {
+		ctx := rt.NewContext()
+		ctx.Globals().Set("greet", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			return ctx.String("hello")
+		}))
+		require.NoError(t, ctx.SetRateLimits(policy))
+		require.NoError(t, ids.Install(ctx))
+		require.NoError(t, quickjsfmt.Install(ctx))
+		return ctx
+	}
+	ctx := newContext()
+	defer ctx.Close()
+	require.EqualError(t, ctx.SetRateLimits(policy), "quickjs: rate limits are already set")
+
+	ret, err := ctx.Eval(`
+		import("ids").then(({ uuid, ulid }) => {
+			const calls = [uuid(), uuid(), ulid(), ulid(), ulid()].length;
+			try {
+				uuid();
+			} catch (err) {
+				return [calls, err instanceof RateLimitError, err.message, err.limit, err.retryAfter > 0];
+			}
+		})`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.JSONEq(t, `[5, true, "rate limit of ids.uuid exceeded: 2 calls per 1h0m0s", "ids.uuid", true]`, ret.JSONStringify())
+	ret.Free()
+
+	ret, err = ctx.Eval(`
+		import("fmt").then(({ sprintf, render }) => [sprintf("%d", 1), render("{{.}}", 2), sprintf("%d", 3)])`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.JSONEq(t, `["1", "2", "3"]`, ret.JSONStringify())
+	ret.Free()
+	_, err = ctx.Eval(`import("fmt").then(({ render }) => render("{{.}}", 4))`, quickjs.EvalAwait(true))
+	require.EqualError(t, err, "RateLimitError: rate limit of fmt exceeded: 3 calls per 1h0m0s")
+
+	ret, err = ctx.Eval(`greet()`)
+	require.NoError(t, err)
+	ret.Free()
+	_, err = ctx.Eval(`greet()`)
+	require.EqualError(t, err, "RateLimitError: rate limit of greet exceeded: 1 calls per 50ms")
+	time.Sleep(60 * time.Millisecond)
+	ret, err = ctx.Eval(`greet()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "hello", ret.String())
+	ret.Free()
+
+	// Every context has buckets of its own.
+	other := newContext()
+	defer other.Close()
+	ret, err = other.Eval(`import("ids").then(({ uuid }) => typeof uuid())`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.EqualValues(t, "string", ret.String())
+	ret.Free()
+}
+
+func TestHostCallGuards(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext(quickjs.ContextMaxHostDepth(3), quickjs.ContextMaxAsyncCalls(2))
+	defer ctx.Close()
+
+	ctx.Globals().Set("call", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.Invoke(args[0], ctx.Null())
+	}))
+	ret, err := ctx.Eval(`
+		let depth = 0;
+		const recurse = () => (depth++, call(recurse));
+		try {
+			recurse();
+		} catch (err) {
+			globalThis.error = err.message;
+		}
+		[depth, error, call(() => "again")]`)
+	require.NoError(t, err)
+	require.JSONEq(t, `[4, "host call depth exceeds the limit of 3", "again"]`, ret.JSONStringify())
+	ret.Free()
+	_, err = ctx.Eval(`const loop = () => call(loop); loop()`)
+	require.ErrorIs(t, err, quickjs.ErrHostCallDepth)
+
+	ctx.Globals().Set("wait", ctx.AsyncFunction(func(ctx *quickjs.Context, this quickjs.Value, promise quickjs.Value, args []quickjs.Value) quickjs.Value {
+		pending := ctx.Globals().Get("pending")
+		defer pending.Free()
+		resolve := promise.Get("resolve")
+		defer resolve.Free()
+		return pending.Call("push", resolve)
+	}))
+	ret, err = ctx.Eval(`
+		globalThis.pending = [];
+		const results = [];
+		const track = (p) => p.then((v) => results.push(v), (err) => results.push(err.message));
+		track(wait());
+		track(wait());
+		track(wait());
+		pending.forEach((resolve, i) => resolve(i));
+		new Promise((resolve) => setTimeout(resolve, 0)).then(() => {
+			track(wait());
+			pending[2]("after");
+			return new Promise((resolve) => setTimeout(resolve, 0));
+		}).then(() => results)`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.JSONEq(t, `["pending async host calls exceed the limit of 2", 0, 1, "after"]`, ret.JSONStringify())
+	ret.Free()
+
+	_, err = ctx.Eval(`wait(); wait(); wait()`, quickjs.EvalAwait(true))
+	require.ErrorIs(t, err, quickjs.ErrAsyncCallLimit)
+
+	// Scripts cannot forge them.
+	_, err = ctx.Eval(`throw new RangeError("host call depth exceeds the limit of 3")`)
+	require.NotErrorIs(t, err, quickjs.ErrHostCallDepth)
+	_, err = ctx.Eval(`throw new RangeError("pending async host calls exceed the limit of 2")`)
+	require.NotErrorIs(t, err, quickjs.ErrAsyncCallLimit)
+}
+
+func TestStringBytes(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	codes := func(v quickjs.Value) string {
+		ctx.Globals().Set("s", v)
+		ret, err := ctx.Eval(`Array.from({ length: s.length }, (_, i) => s.charCodeAt(i).toString(16)).join()`)
+		require.NoError(t, err)
+		defer ret.Free()
+		return ret.String()
+	}
+	latin1 := quickjs.StringBytesEncoding(quickjs.EncodingLatin1)
+	utf8 := quickjs.StringBytesEncoding(quickjs.EncodingUTF8)
+
+	require.EqualValues(t, "61,0,62,e9", codes(ctx.StringFromBytes([]byte("a\x00bé"))))
+	require.EqualValues(t, "fffd,28", codes(ctx.StringFromBytes([]byte("\xc3("))))
+	require.EqualValues(t, "d800,78", codes(ctx.StringFromBytes([]byte("\xed\xa0\x80x"))))
+	require.EqualValues(t, "fffd,78", codes(ctx.StringFromBytes([]byte("\xed\xa0\x80x"), utf8)))
+	require.EqualValues(t, "0,ff,c3,28", codes(ctx.StringFromBytes([]byte("\x00\xff\xc3("), latin1)))
+	require.EqualValues(t, "", codes(ctx.StringFromBytes(nil)))
+
+	ret, err := ctx.Eval(`"a\0b\ud800é😀"`)
+	require.NoError(t, err)
+	defer ret.Free()
+	require.EqualValues(t, "a", ret.String())
+	b, err := ret.StringLenBytes()
+	require.NoError(t, err)
+	require.EqualValues(t, []byte("a\x00b\xed\xa0\x80é😀"), b)
+	// Set takes ownership of the value.
+	str, err := ctx.Eval(`"a\0b\ud800é😀"`)
+	require.NoError(t, err)
+	require.EqualValues(t, codes(ctx.StringFromBytes(b)), codes(str))
+	b, err = ret.StringLenBytes(utf8)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte("a\x00b�é😀"), b)
+	_, err = ret.StringLenBytes(latin1)
+	require.EqualError(t, err, "quickjs: string has characters beyond U+00FF")
+
+	// Arbitrary bytes round-trip through binary strings.
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	ctx.Globals().Set("binary", ctx.StringFromBytes(binary, latin1))
+	ret2, err := ctx.Eval(`binary.split("").reverse().join("")`)
+	require.NoError(t, err)
+	defer ret2.Free()
+	b, err = ret2.StringLenBytes(latin1)
+	require.NoError(t, err)
+	require.Len(t, b, 256)
+	for i, c := range b {
+		require.EqualValues(t, 255-i, c)
+	}
+
+	obj, err := ctx.Eval(`({ toString() { throw new Error("no string") } })`)
+	require.NoError(t, err)
+	defer obj.Free()
+	_, err = obj.StringLenBytes()
+	require.EqualError(t, err, "Error: no string")
+}
+
+func TestStringUTF16(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`"aé😀\ud800b"`)
+	require.NoError(t, err)
+	defer ret.Free()
+
+	units, err := ret.UTF16()
+	require.NoError(t, err)
+	require.EqualValues(t, []uint16{'a', 0xe9, 0xd83d, 0xde00, 0xd800, 'b'}, units)
+	runes, err := ret.Runes()
+	require.NoError(t, err)
+	require.EqualValues(t, []rune{'a', 'é', '😀', 0xd800, 'b'}, runes)
+	n, err := ret.UTF16Len()
+	require.NoError(t, err)
+	require.EqualValues(t, 6, n)
+	length := ret.Get("length")
+	defer length.Free()
+	require.EqualValues(t, length.Int64(), n)
+	n, err = ret.RuneLen()
+	require.NoError(t, err)
+	require.EqualValues(t, 5, n)
+
+	ctx.Globals().Set("s", ctx.StringFromUTF16(units))
+	ctx.Globals().Set("swapped", ctx.StringFromUTF16([]uint16{0xde00, 0xd83d}))
+	check, err := ctx.Eval(`[s === "aé😀\ud800b", swapped.length, swapped.codePointAt(0).toString(16)]`)
+	require.NoError(t, err)
+	defer check.Free()
+	require.JSONEq(t, `[true, 2, "de00"]`, check.JSONStringify())
+}
+
+func TestErrorFrames(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	_, err := ctx.Eval("function fail() {\n  throw new Error(\"boom\");\n}\n[1].map(() => fail());\n", quickjs.EvalFileName("app.js"))
+	var jsErr *quickjs.Error
+	require.ErrorAs(t, err, &jsErr)
+	require.EqualValues(t, []quickjs.StackFrame{
+		{Func: "fail", File: "app.js", Line: 2},
+		{Func: "<anonymous>", File: "app.js"},
+		{Func: "map", File: "native"},
+		{Func: "<eval>", File: "app.js", Line: 4},
+	}, jsErr.Frames)
+
+	// Source maps give columns.
+	code := "// generated\nfunction fail() {\n  throw new Error(\"boom\");\n}\nfail();\n"
+	sourceMap := `{"version":3,"sources":["orig.js"],"sourceRoot":"src","mappings":";AAQA;EACI;;AAUJ"}`
+	_, err = ctx.Eval(code, quickjs.EvalFileName("gen.js"), quickjs.EvalSourceMap([]byte(sourceMap)))
+	require.ErrorAs(t, err, &jsErr)
+	require.EqualValues(t, quickjs.StackFrame{Func: "fail", File: "src/orig.js", Line: 10, Col: 5}, jsErr.Frames[0])
+}
+
+func TestSetOnThrow(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	type thrown struct {
+		value  string
+		frames []quickjs.StackFrame
+	}
+	var throws []thrown
+	rt.SetOnThrow(func(exc quickjs.Value, frames []quickjs.StackFrame) {
+		throws = append(throws, thrown{exc.String(), frames})
+	})
+	ctx.Globals().Set("fail", ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.ThrowTypeError("from go")
+	}))
+
+	ret, err := ctx.Eval(`function swallow() {
+  try { throw new Error("first") } catch {}
+  try { fail() } catch {}
+  try { throw ("plain") } catch (e) {}
+  try { throw "ignored", "last" } catch (e) {}
+  try { throw "multi"
+    + "line" } catch (e) {}
+  const o = { throw: 1, throw() { return "method" } };
+  return o.throw() + it.throw;
+}
+const it = { throw: "!" };
+swallow();
+`, quickjs.EvalFileName("app.js"))
+	require.NoError(t, err)
+	require.EqualValues(t, "method!", ret.String())
+	ret.Free()
+
+	require.EqualValues(t, []thrown{
+		{"Error: first", []quickjs.StackFrame{{Func: "swallow", File: "app.js", Line: 2}, {Func: "<eval>", File: "app.js", Line: 12}}},
+		{"TypeError: from go", []quickjs.StackFrame{{Func: "swallow", File: "app.js", Line: 3}, {Func: "<eval>", File: "app.js", Line: 12}}},
+		{"plain", []quickjs.StackFrame{{Func: "swallow", File: "app.js", Line: 4}, {Func: "<eval>", File: "app.js", Line: 12}}},
+		{"last", []quickjs.StackFrame{{Func: "swallow", File: "app.js", Line: 5}, {Func: "<eval>", File: "app.js", Line: 12}}},
+		{"multiline", []quickjs.StackFrame{{Func: "swallow", File: "app.js", Line: 6}, {Func: "<eval>", File: "app.js", Line: 12}}},
+	}, throws)
+
+	// Modules are instrumented too, and uncaught exceptions are reported before they propagate.
+	throws = nil
+	mod, err := ctx.LoadModule("export function f() {\n  throw new RangeError(\"module\");\n}", "mod")
+	require.NoError(t, err)
+	mod.Free()
+	_, err = ctx.Eval(`import("mod").then(({ f }) => f())`, quickjs.EvalAwait(true))
+	require.EqualError(t, err, "RangeError: module")
+	require.Len(t, throws, 1)
+	require.EqualValues(t, "RangeError: module", throws[0].value)
+	require.EqualValues(t, quickjs.StackFrame{Func: "f", File: "mod", Line: 2}, throws[0].frames[0])
+
+	// Engine errors are only reported when they reach Eval, and the hook cannot be replaced.
+	throws = nil
+	_, err = ctx.Eval("try { undefined.x } catch {}\nfunction read() {\n  return undefined.y;\n}\nread();", quickjs.EvalFileName("engine.js"))
+	require.ErrorContains(t, err, "TypeError")
+	require.Len(t, throws, 1)
+	require.Contains(t, throws[0].value, "TypeError")
+	require.EqualValues(t, quickjs.StackFrame{Func: "read", File: "engine.js", Line: 3}, throws[0].frames[0])
+	ret, err = ctx.Eval(`const d = Object.getOwnPropertyDescriptor(globalThis, "__quickjs_throw__");
+[d.writable, d.enumerable, d.configurable, Object.keys(globalThis).includes("__quickjs_throw__")].join()`)
+	require.NoError(t, err)
+	require.EqualValues(t, "false,false,false,false", ret.String())
+	ret.Free()
+
+	// The limits enforced when calling Go functions are reported once.
+	throws = nil
+	deep := rt.NewContext(quickjs.ContextMaxHostDepth(1))
+	defer deep.Close()
+	deep.Globals().Set("again", deep.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.Invoke(args[0], ctx.Null())
+	}))
+	ret, err = deep.Eval(`try { again(() => again(() => 1)) } catch {}`)
+	require.NoError(t, err)
+	ret.Free()
+	require.Len(t, throws, 1)
+	require.Contains(t, throws[0].value, "RangeError")
+
+	rt.SetOnThrow(nil)
+	throws = nil
+	_, err = ctx.Eval(`try { throw 1 } catch {}; try { fail() } catch {}`)
+	require.NoError(t, err)
+	require.Empty(t, throws)
+}
+
+func TestSyntaxDiagnostics(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	diagnose := func(err error) quickjs.Diagnostic {
+		var diags quickjs.SyntaxErrors
+		require.ErrorAs(t, err, &diags)
+		require.Len(t, diags, 1)
+		return diags[0]
+	}
+
+	_, err := ctx.Eval("let a = 1;\nlet é = (2 + ;\n", quickjs.EvalFileName("app.js"))
+	require.EqualError(t, err, "SyntaxError: unexpected token in expression: ';'")
+	require.EqualValues(t, quickjs.Diagnostic{
+		Message: "unexpected token in expression: ';'",
+		File:    "app.js",
+		Line:    2,
+		Col:     14,
+		Token:   ";",
+		Excerpt: "let é = (2 + ;",
+	}, diagnose(err))
+
+	_, err = ctx.Compile("x = 1;\nx = \"abc\n", quickjs.EvalFileName("str.js"))
+	d := diagnose(err)
+	require.EqualValues(t, []interface{}{"unexpected end of string", 2, 9, "x = \"abc"}, []interface{}{d.Message, d.Line, d.Col, d.Excerpt})
+
+	_, err = ctx.Eval("function f() {\n  return 1\n", quickjs.EvalFileName("eof.js"))
+	d = diagnose(err)
+	require.EqualValues(t, []interface{}{3, 1, ""}, []interface{}{d.Line, d.Col, d.Token})
+
+	_, err = ctx.LoadModule("export const a = 1;\nexport const a = 2;\n", "mod.js")
+	d = diagnose(err)
+	require.EqualValues(t, "mod.js", d.File)
+	require.EqualValues(t, 2, d.Line)
+	require.Contains(t, err.(*quickjs.Error).Diagnostics.Error(), "mod.js:2:")
+
+	// Syntax errors thrown by running scripts have no diagnostics.
+	_, err = ctx.Eval(`JSON.parse("{")`)
+	require.Error(t, err)
+	var diags quickjs.SyntaxErrors
+	require.False(t, errors.As(err, &diags))
+}
+
+func TestCheck(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	require.Nil(t, ctx.Check("globalThis.ran = true;"))
+	require.Nil(t, ctx.Check("export const a = 1;\nglobalThis.ran = true;\n", quickjs.EvalFileName("valid.js")))
+	ran := ctx.Globals().Get("ran")
+	defer ran.Free()
+	require.True(t, ran.IsUndefined())
+
+	diags := ctx.Check("let a = 1;\nlet b = (2 + ;\n", quickjs.EvalFileName("app.js"))
+	require.Len(t, diags, 1)
+	require.EqualValues(t, []interface{}{"app.js", 2, 14, ";"}, []interface{}{diags[0].File, diags[0].Line, diags[0].Col, diags[0].Token})
+
+	// Checked modules are not defined in the context, but the modules it defines are visible to checked code.
+	diags = ctx.Check("import { a } from \"./valid.js\";\n", quickjs.EvalFileName("main.js"))
+	require.Len(t, diags, 1)
+	require.Contains(t, diags[0].Message, "valid.js")
+	mod, err := ctx.LoadModule("export const a = 2;\n", "valid.js")
+	require.NoError(t, err)
+	mod.Free()
+	a, err := ctx.Eval(`import("valid.js").then((m) => m.a)`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, a.Int32())
+	a.Free()
+
+	diags = ctx.Check("import { a } from \"./valid.js\";\nimport { b } from './missing.js';\n", quickjs.EvalFileName("main.js"))
+	require.Len(t, diags, 1)
+	require.Contains(t, diags[0].Message, "missing.js")
+	require.EqualValues(t, []interface{}{"main.js", 2, 19, "'./missing.js'"}, []interface{}{diags[0].File, diags[0].Line, diags[0].Col, diags[0].Token})
+
+	loaderRt := quickjs.NewRuntime(quickjs.WithModuleLoader(func(ctx *quickjs.Context, name string) (string, error) {
+		return "export const a = (;\n", nil
+	}))
+	defer loaderRt.Close()
+	loaderCtx := loaderRt.NewContext()
+	defer loaderCtx.Close()
+	diags = loaderCtx.Check("import { a } from './dep.js';\n", quickjs.EvalFileName("main.js"))
+	require.Len(t, diags, 1)
+	require.EqualValues(t, []interface{}{"dep.js", 1}, []interface{}{diags[0].File, diags[0].Line})
+}
+
+func TestAnalyze(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithModuleLoader(func(ctx *quickjs.Context, name string) (string, error) {
+		return "export default 1; export const a = 1, b = 2;", nil
+	}))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	info, err := ctx.Analyze(`import main, { a, b as c } from "./lib.js";
+export const { total, sum: s } = compute(a, c);
+export default function run(items, { limit = max } = {}) {
+	const out = items.map((item, i) => item.value + i + main);
+	for (const k of Object.keys(out)) console.log(k, limit);
+	return import("./lazy.js").then((m) => m.apply({ out, s }));
+}
+function helper(fetch) { var x = fetch(1); return x + Function; }
+globalThis.ran = true;
+`, quickjs.EvalFileName("job.js"))
+	require.NoError(t, err)
+	require.True(t, info.Module)
+	require.EqualValues(t, []string{"run", "helper"}, info.Functions)
+	require.EqualValues(t, []string{"total", "s", "default"}, info.Exports)
+	require.EqualValues(t, []quickjs.ImportInfo{
+		{Module: "./lib.js", Names: []string{"default", "a", "b"}, Line: 1},
+		{Module: "./lazy.js", Dynamic: true, Line: 6},
+	}, info.Imports)
+	require.EqualValues(t, []string{"Function", "Object", "compute", "console", "globalThis", "max"}, info.Globals)
+
+	// The code is not run.
+	ran := ctx.Globals().Get("ran")
+	defer ran.Free()
+	require.True(t, ran.IsUndefined())
+
+	// Analyzed modules are not defined in the context.
+	info, err = ctx.Analyze("export const a = 1;\n", quickjs.EvalFileName("analyzed.js"))
+	require.NoError(t, err)
+	require.EqualValues(t, []string{"a"}, info.Exports)
+	mod, err := ctx.LoadModule("export const a = 2;\n", "analyzed.js")
+	require.NoError(t, err)
+	mod.Free()
+	a, err := ctx.Eval(`import("analyzed.js").then((m) => m.a)`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, a.Int32())
+	a.Free()
+
+	info, err = ctx.Analyze("let n = 1; eval('n++');")
+	require.NoError(t, err)
+	require.False(t, info.Module)
+	require.EqualValues(t, []string{"eval"}, info.Globals)
+
+	_, err = ctx.Analyze("let n = ;")
+	var diags quickjs.SyntaxErrors
+	require.ErrorAs(t, err, &diags)
+}
+
+func TestContextDisableEval(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx, err := rt.NewContextE(quickjs.ContextDisableEval(true))
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	for _, code := range []string{
+		`eval("1")`,
+		`globalThis["ev" + "al"]("1")`,
+		`new Function("return 1")()`,
+		`(() => {}).constructor("return 1")()`,
+		`Object.getPrototypeOf(async () => {}).constructor("return 1")`,
+		`Object.getPrototypeOf(function* () {}).constructor("yield 1")`,
+		`Object.getPrototypeOf(async function* () {}).constructor("yield 1")`,
+		`Function.prototype.constructor = null; Function.prototype.constructor("return 1")`,
+	} {
+		_, err := ctx.Eval(code)
+		require.EqualError(t, err, "EvalError: code generation from strings is disabled", code)
+	}
+
+	// Functions keep working, and Go code still evaluates scripts.
+	ret, err := ctx.Eval(`[(() => 1) instanceof Function, typeof eval, [1, 2].map((x) => x * 2).join()].join()`)
+	require.NoError(t, err)
+	require.Equal(t, "true,function,2,4", ret.String())
+	ret.Free()
+
+	// Realms of the context inherit the setting.
+	err = ctx.WithRealm(func(realm *quickjs.Context) error {
+		_, err := realm.Eval(`eval("1")`)
+		return err
+	})
+	require.EqualError(t, err, "EvalError: code generation from strings is disabled")
+}
@@ -0,0 +1,127 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Quota combines limits enforced together on one evaluation; zero fields are not limited.
+type Quota struct {
+	// WallTime limits the time spent evaluating, including Go functions called by the script.
+	WallTime time.Duration
+	// Instructions limits the number of operations executed. The engine counts them in steps of 10000 (function
+	// calls and loop iterations), so the limit is approximate.
+	Instructions uint64
+	// Memory limits the bytes the runtime may allocate on top of what it used when the evaluation started.
+	Memory uint64
+	// Objects limits the number of objects created by the evaluation, net of those collected meanwhile. It is checked
+	// with the instruction steps.
+	Objects uint64
+}
+
+// QuotaLimit identifies a limit of a Quota.
+type QuotaLimit string
+
+const (
+	QuotaWallTime     QuotaLimit = "wall time"
+	QuotaInstructions QuotaLimit = "instructions"
+	QuotaMemory       QuotaLimit = "memory"
+	QuotaObjects      QuotaLimit = "objects"
+)
+
+// QuotaExceededError is returned by an evaluation interrupted by its quota.
+type QuotaExceededError struct {
+	Limit QuotaLimit
+	Quota Quota
+	Err   error // the exception of the interrupted evaluation
+}
+
+func (err QuotaExceededError) Error() string {
+	return fmt.Sprintf("quickjs: %s quota exceeded", err.Limit)
+}
+
+func (err QuotaExceededError) Unwrap() error { return err.Err }
+
+// EvalQuota enforces q on the evaluation; the error returned when a limit trips is a *QuotaExceededError.
+func EvalQuota(q Quota) EvalOption {
+	return func(flags *EvalOptions) {
+		flags.quota = &q
+	}
+}
+
+// interruptSteps is the number of operations the engine executes between two calls of the interrupt handler.
+const interruptSteps = 10000
+
+// quotaState tracks an evaluation running under a quota.
+type quotaState struct {
+	quota    Quota
+	rt       *C.JSRuntime
+	deadline time.Time
+	steps    uint64
+	objects  int64 // object count when the evaluation started
+	exceeded QuotaLimit
+}
+
+// check is called by the interrupt handler and reports whether a limit has tripped.
+func (q *quotaState) check() bool {
+	q.steps++
+	switch {
+	case q.quota.WallTime > 0 && time.Now().After(q.deadline):
+		q.exceeded = QuotaWallTime
+	case q.quota.Instructions > 0 && q.steps*interruptSteps > q.quota.Instructions:
+		q.exceeded = QuotaInstructions
+	case q.quota.Objects > 0 && q.objectCount()-q.objects > int64(q.quota.Objects):
+		q.exceeded = QuotaObjects
+	}
+	return q.exceeded != ""
+}
+
+func (q *quotaState) objectCount() int64 {
+	var usage C.JSMemoryUsage
+	C.JS_ComputeMemoryUsage(q.rt, &usage)
+	return int64(usage.obj_count)
+}
+
+// beginQuota starts enforcing q. It returns the function to call with the error of the evaluation, which returns
+// the error to report.
+func (ctx *Context) beginQuota(q Quota) func(err error) error {
+	state := ctx.runtime.state
+	qs := &quotaState{quota: q, rt: ctx.runtime.ref, deadline: time.Now().Add(q.WallTime)}
+	if q.Objects > 0 {
+		qs.objects = qs.objectCount()
+	}
+	if q.Memory > 0 {
+		var usage C.JSMemoryUsage
+		C.JS_ComputeMemoryUsage(ctx.runtime.ref, &usage)
+		limit := uint64(usage.malloc_size) + q.Memory
+		if ctx.runtime.options.memoryLimit > 0 && ctx.runtime.options.memoryLimit < limit {
+			limit = ctx.runtime.options.memoryLimit
+		}
+		ctx.runtime.SetMemoryLimit(limit)
+	}
+	outer := state.quota
+	state.quota = qs
+	ctx.runtime.enableInterrupts()
+
+	return func(err error) error {
+		state.quota = outer
+		if q.Memory > 0 {
+			ctx.runtime.SetMemoryLimit(ctx.runtime.defaultMemoryLimit())
+		}
+		if err == nil {
+			return nil
+		}
+		if qs.exceeded == "" && q.Memory > 0 && errors.Is(err, ErrOutOfMemory) {
+			qs.exceeded = QuotaMemory
+		}
+		if qs.exceeded == "" {
+			return err
+		}
+		return &QuotaExceededError{Limit: qs.exceeded, Quota: q, Err: err}
+	}
+}
@@ -0,0 +1,211 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// RateLimitPolicy limits how often scripts call host functions, with token buckets. A policy only describes the
+// limits: every context given it with SetRateLimits counts its calls in buckets of its own.
+type RateLimitPolicy struct {
+	limits []rateLimit
+}
+
+type rateLimit struct {
+	name string
+	n    int
+	per  time.Duration
+}
+
+// NewRateLimitPolicy creates a policy without limits.
+func NewRateLimitPolicy() *RateLimitPolicy {
+	return &RateLimitPolicy{}
+}
+
+// Limit allows n calls per period to the functions named name, in bursts of up to n calls, replacing a previous
+// limit of name. The name is that of a host module, such as "http", limiting the calls to all its exports together,
+// of an export, such as "http.get", or of a global function, such as "fetch" or "console.log". It returns p, so that
+// limits can be chained.
+func (p *RateLimitPolicy) Limit(name string, n int, per time.Duration) *RateLimitPolicy {
+	for i, l := range p.limits {
+		if l.name == name {
+			p.limits[i] = rateLimit{name, n, per}
+			return p
+		}
+	}
+	p.limits = append(p.limits, rateLimit{name, n, per})
+	return p
+}
+
+// tokenBucket holds the calls left to a limit, refilled continuously.
+type tokenBucket struct {
+	rateLimit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill, and returns the time until the next token.
+func (b *tokenBucket) refill(now time.Time) time.Duration {
+	if b.per > 0 {
+		b.tokens = math.Min(float64(b.n), b.tokens+float64(now.Sub(b.last))*float64(b.n)/float64(b.per))
+	}
+	b.last = now
+	if b.tokens >= 1 || b.n <= 0 || b.per <= 0 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.per) / float64(b.n))
+}
+
+// rateLimiter holds the buckets of the context, and the function limiting the calls to a function.
+type rateLimiter struct {
+	buckets map[string]*tokenBucket
+	limit   C.JSValue // (fn, names) => fn taking a token of each bucket of names before every call
+}
+
+const rateLimitPatch = `(take) => (fn, names) => new Proxy(fn, {
+	apply(target, self, args) {
+		take(names);
+		return Reflect.apply(target, self, args);
+	},
+	construct(target, args, newTarget) {
+		take(names);
+		return Reflect.construct(target, args, newTarget);
+	},
+})`
+
+// SetRateLimits enforces the limits of p on the calls of the context to host functions: the exports of the host
+// modules installed afterwards, and the global functions defined when it is called. A call beyond a limit throws a
+// RateLimitError, a global subclass of Error whose limit property is the name of the limit and whose retryAfter
+// property is the number of milliseconds until the next call is allowed. Calls that are not allowed take no token.
+// The limits of a context can be set once.
+func (ctx *Context) SetRateLimits(p *RateLimitPolicy) error {
+	if ctx.rateLimits != nil {
+		return errors.New("quickjs: rate limits are already set")
+	}
+	if err := ctx.RegisterErrorClass("RateLimitError"); err != nil {
+		return err
+	}
+	rl := &rateLimiter{buckets: make(map[string]*tokenBucket, len(p.limits))}
+	now := time.Now()
+	for _, l := range p.limits {
+		rl.buckets[l.name] = &tokenBucket{rateLimit: l, tokens: float64(l.n), last: now}
+	}
+
+	take := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		now := time.Now()
+		buckets := make([]*tokenBucket, 0, args[0].Len())
+		for i := int64(0); i < args[0].Len(); i++ {
+			name := args[0].GetIdx(i)
+			b := rl.buckets[name.String()]
+			name.Free()
+			if wait := b.refill(now); b.tokens < 1 {
+				return ctx.ThrowCustom("RateLimitError",
+					fmt.Sprintf("rate limit of %s exceeded: %d calls per %s", b.name, b.n, b.per),
+					map[string]Value{"limit": ctx.String(b.name), "retryAfter": ctx.Float64(math.Ceil(float64(wait) / float64(time.Millisecond)))})
+			}
+			buckets = append(buckets, b)
+		}
+		for _, b := range buckets {
+			b.tokens--
+		}
+		return ctx.Undefined()
+	})
+	defer take.Free()
+	patch, err := ctx.Eval(rateLimitPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	limit, err := ctx.InvokeE(patch, ctx.Null(), take)
+	if err != nil {
+		return err
+	}
+	ctx.untrack(limit)
+	rl.limit = limit.ref
+	ctx.closeHooks = append(ctx.closeHooks, func() { C.JS_FreeValue(ctx.ref, rl.limit) })
+	ctx.rateLimits = rl
+
+	for _, l := range p.limits {
+		if err := ctx.limitGlobal(l.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitGlobal limits the calls to the global function at the dotted path name, if there is one.
+func (ctx *Context) limitGlobal(name string) error {
+	path := strings.Split(name, ".")
+	holder := ctx.Globals()
+	for i, key := range path[:len(path)-1] {
+		next := holder.Get(key)
+		if i > 0 {
+			holder.Free()
+		}
+		holder = next
+		if !holder.IsObject() {
+			holder.Free()
+			return nil
+		}
+	}
+	if len(path) > 1 {
+		defer holder.Free()
+	}
+	key := path[len(path)-1]
+	fn := holder.Get(key)
+	defer fn.Free()
+	if !fn.IsFunction() {
+		return nil
+	}
+	limited, err := ctx.rateLimits.apply(ctx, fn, name)
+	if err != nil {
+		return err
+	}
+	holder.Set(key, limited)
+	return nil
+}
+
+// limitExports limits the calls to the exports of the host module named module, in place.
+func (ctx *Context) limitExports(module string, exports Value, names []string) error {
+	for _, name := range names {
+		var limits []string
+		for _, limit := range []string{module, module + "." + name} {
+			if _, ok := ctx.rateLimits.buckets[limit]; ok {
+				limits = append(limits, limit)
+			}
+		}
+		if len(limits) == 0 {
+			continue
+		}
+		fn := exports.Get(name)
+		if !fn.IsFunction() {
+			fn.Free()
+			continue
+		}
+		limited, err := ctx.rateLimits.apply(ctx, fn, limits...)
+		fn.Free()
+		if err != nil {
+			return err
+		}
+		exports.Set(name, limited)
+	}
+	return nil
+}
+
+// apply returns fn limited by the buckets of names.
+func (rl *rateLimiter) apply(ctx *Context, fn Value, names ...string) (Value, error) {
+	list := make([]Value, len(names))
+	for i, name := range names {
+		list[i] = ctx.String(name)
+	}
+	arr := NewTreeBuilder(ctx).Array(list)
+	defer arr.Free()
+	return ctx.InvokeE(Value{ctx: ctx, ref: rl.limit}, ctx.Null(), fn, arr)
+}
@@ -0,0 +1,303 @@
+// Package re2 defines the "re2" host module of quickjs, matching regular expressions in linear time with the regexp
+// package of Go.
+package re2
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/buke/quickjs-go"
+)
+
+// re2 is a regular expression compiled by the re2 module, with the matches of the last string it searched in full.
+type re2 struct {
+	re      *regexp.Regexp
+	input   string
+	matches [][]int
+}
+
+// utf16Offsets converts the byte offsets of loc in s, the WTF-8 encoding of a JS string, to UTF-16 offsets in place.
+func utf16Offsets(s string, loc []int) {
+	sorted := make([]int, 0, len(loc))
+	for _, i := range loc {
+		if i >= 0 {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.Ints(sorted)
+	units := make(map[int]int, len(sorted))
+	b, u := 0, 0
+	for _, i := range sorted {
+		for ; b < i; b++ {
+			switch c := s[b]; {
+			case c >= 0xf0:
+				u += 2
+			case c < 0x80 || c >= 0xc0:
+				u++
+			}
+		}
+		units[i] = u
+	}
+	for k, i := range loc {
+		if i >= 0 {
+			loc[k] = units[i]
+		}
+	}
+}
+
+// all returns the matches of s, in UTF-16 offsets, keeping them for the next searches of s.
+func (r *re2) all(s string) [][]int {
+	if r.matches == nil || r.input != s {
+		r.input, r.matches = s, r.re.FindAllStringSubmatchIndex(s, -1)
+		var flat []int
+		for _, m := range r.matches {
+			flat = append(flat, m...)
+		}
+		utf16Offsets(s, flat)
+		for i, m := range r.matches {
+			copy(m, flat[i*len(m):])
+		}
+		if r.matches == nil {
+			r.matches = [][]int{}
+		}
+	}
+	return r.matches
+}
+
+const re2Patch = `(compile, names, find, escape) => {
+	const expand = (template, m) => {
+		let out = "";
+		for (let i = 0; i < template.length; i++) {
+			const c = template[i], n = template[i + 1];
+			if (c !== "$" || n === undefined) {
+				out += c;
+			} else if (n === "$") {
+				out += "$", i++;
+			} else if (n === "&") {
+				out += m[0], i++;
+			} else if (n === "\x60") {
+				out += m.input.slice(0, m.index), i++;
+			} else if (n === "'") {
+				out += m.input.slice(m.index + m[0].length), i++;
+			} else if (n === "<" && m.groups && template.indexOf(">", i) > 0) {
+				const end = template.indexOf(">", i);
+				out += m.groups[template.slice(i + 2, end)] ?? "";
+				i = end;
+			} else if (n >= "0" && n <= "9") {
+				const d = template[i + 2], two = d >= "0" && d <= "9" ? Number(n + d) : 0;
+				if (two >= 1 && two < m.length) {
+					out += m[two] ?? "", i += 2;
+				} else if (Number(n) >= 1 && Number(n) < m.length) {
+					out += m[Number(n)] ?? "", i++;
+				} else {
+					out += c;
+				}
+			} else {
+				out += c;
+			}
+		}
+		return out;
+	};
+	class RE2 {
+		#re;
+		#names;
+		constructor(pattern, flags) {
+			if (pattern instanceof RE2) {
+				flags ??= pattern.flags;
+				pattern = pattern.source;
+			}
+			this.source = String(pattern);
+			this.flags = flags === undefined ? "" : String(flags);
+			this.#re = compile(this.source, this.flags);
+			this.#names = names(this.#re);
+			this.lastIndex = 0;
+		}
+		get global() { return this.flags.includes("g"); }
+		#match(str, offsets, at) {
+			const m = [];
+			let groups;
+			for (let k = 0; k < this.#names.length; k++) {
+				const start = offsets[at + 2 * k], end = offsets[at + 2 * k + 1];
+				m.push(start < 0 ? undefined : str.slice(start, end));
+				if (this.#names[k] !== "") (groups ??= Object.create(null))[this.#names[k]] = m[k];
+			}
+			m.index = offsets[at];
+			m.input = str;
+			m.groups = groups;
+			return m;
+		}
+		#all(str) {
+			const offsets = find(this.#re, str, 0, true), size = 2 * this.#names.length, all = [];
+			for (let at = 0; at < offsets.length; at += size) all.push(this.#match(str, offsets, at));
+			return all;
+		}
+		exec(str) {
+			str = String(str);
+			const start = this.global ? Number(this.lastIndex) || 0 : 0;
+			const offsets = start <= str.length ? find(this.#re, str, start, false) : null;
+			if (this.global) this.lastIndex = offsets === null ? 0 : offsets[1];
+			return offsets === null ? null : this.#match(str, offsets, 0);
+		}
+		test(str) {
+			return this.exec(str) !== null;
+		}
+		match(str) {
+			str = String(str);
+			if (!this.global) return this.exec(str);
+			this.lastIndex = 0;
+			const all = this.#all(str).map((m) => m[0]);
+			return all.length ? all : null;
+		}
+		matchAll(str) {
+			return this.#all(String(str));
+		}
+		replace(str, replacement) {
+			str = String(str);
+			const all = this.global ? this.#all(str) : [this.exec(str)].filter((m) => m !== null);
+			if (this.global) this.lastIndex = 0;
+			let out = "", last = 0;
+			for (const m of all) {
+				out += str.slice(last, m.index);
+				if (typeof replacement === "function") {
+					out += String(replacement(...m, m.index, str, ...(m.groups ? [m.groups] : [])));
+				} else {
+					out += expand(String(replacement), m);
+				}
+				last = m.index + m[0].length;
+			}
+			return out + str.slice(last);
+		}
+		split(str, limit) {
+			str = String(str);
+			const max = limit === undefined ? 2 ** 32 - 1 : limit >>> 0, parts = [];
+			if (max === 0) return parts;
+			if (str === "") return find(this.#re, str, 0, false) === null ? [str] : parts;
+			let last = 0;
+			for (const m of this.#all(str)) {
+				const end = m.index + m[0].length;
+				if (end === m.index && (end === 0 || end === str.length)) continue;
+				parts.push(str.slice(last, m.index), ...m.slice(1));
+				if (parts.length >= max) return parts.slice(0, max);
+				last = end;
+			}
+			parts.push(str.slice(last));
+			return parts.slice(0, max);
+		}
+		toString() {
+			return "/" + this.source + "/" + this.flags;
+		}
+		[Symbol.match](str) { return this.match(str); }
+		[Symbol.matchAll](str) { return this.matchAll(str)[Symbol.iterator](); }
+		[Symbol.replace](str, replacement) { return this.replace(str, replacement); }
+		[Symbol.split](str, limit) { return this.split(str, limit); }
+		[Symbol.search](str) {
+			const offsets = find(this.#re, String(str), 0, false);
+			return offsets === null ? -1 : offsets[0];
+		}
+	}
+	Object.defineProperty(RE2.prototype, Symbol.toStringTag, { value: "RE2", configurable: true });
+	return { RE2, escape: (str) => escape(String(str)) };
+}`
+
+// Install defines the "re2" module of ctx, matching regular expressions with the regexp package of Go, whose time is
+// linear in the size of the input, unlike the backtracking RegExp of JS whose time is exponential for some patterns.
+// Hosts running untrusted scripts can create their contexts without IntrinsicRegExp (see WithIntrinsics) and give them
+// this module instead:
+//
+//	import { RE2 } from "re2";
+//	const date = new RE2("(?P<year>\\d{4})-(?P<month>\\d{2})");
+//	const { year } = date.exec(text).groups;
+//	const clean = text.replace(new RE2("\\s+", "g"), " ");
+//
+// new RE2(pattern, flags) compiles a pattern in the syntax of RE2, with no backreferences or lookarounds; named groups
+// are written (?P<name>...), or (?<name>...) since Go 1.22. The flags are g (global), i (ignore case), m (multiline)
+// and s (dot matches newlines). The methods exec, test, match, matchAll, replace and split and the lastIndex of global
+// expressions behave as those of RegExp and String, and RE2s can be passed to the String methods taking a RegExp; with
+// a lastIndex, exec returns the next of the matches found from the start of the string. escape(str) escapes the
+// metacharacters of str.
+func Install(ctx *quickjs.Context) error {
+	compile := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		pattern, flags := goString(args[0]), args[1].String()
+		var prefix strings.Builder
+		for i, f := range flags {
+			if !strings.ContainsRune("gims", f) || strings.ContainsRune(flags[i+1:], f) {
+				return ctx.ThrowSyntaxError("invalid flags %q", flags)
+			}
+			if f != 'g' {
+				prefix.WriteRune(f)
+			}
+		}
+		if prefix.Len() > 0 {
+			pattern = "(?" + prefix.String() + ")" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return ctx.ThrowSyntaxError("%s", err)
+		}
+		return ctx.GoObject(&re2{re: re}, nil)
+	})
+	defer compile.Free()
+	names := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, _ := args[0].GoData()
+		arr := ctx.Array().ToValue()
+		for i, name := range data.(*re2).re.SubexpNames() {
+			arr.SetIdx(int64(i), ctx.String(name))
+		}
+		return arr
+	})
+	defer names.Free()
+	find := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, _ := args[0].GoData()
+		r, s, start := data.(*re2), goString(args[1]), int(args[2].Int64())
+		var loc []int
+		switch {
+		case args[3].Bool():
+			for _, m := range r.all(s) {
+				loc = append(loc, m...)
+			}
+		case start > 0:
+			for _, m := range r.all(s) {
+				if m[0] >= start {
+					loc = m
+					break
+				}
+			}
+			if loc == nil {
+				return ctx.Null()
+			}
+		default:
+			if loc = r.re.FindStringSubmatchIndex(s); loc == nil {
+				return ctx.Null()
+			}
+			utf16Offsets(s, loc)
+		}
+		values := make([]quickjs.Value, len(loc))
+		for i, offset := range loc {
+			values[i] = ctx.Int32(int32(offset))
+		}
+		return quickjs.NewTreeBuilder(ctx).Array(values)
+	})
+	defer find.Free()
+	escape := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		return ctx.String(regexp.QuoteMeta(goString(args[0])))
+	})
+	defer escape.Free()
+
+	patch, err := ctx.Eval(re2Patch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), compile, names, find, escape)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("re2", exports, "RE2", "escape")
+}
+
+// goString returns the string of v, keeping the NUL characters that Value.String cuts the string at.
+func goString(v quickjs.Value) string {
+	b, _ := v.StringLenBytes()
+	return string(b)
+}
@@ -0,0 +1,85 @@
+package re2_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/re2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithIntrinsics(quickjs.IntrinsicAll &^ quickjs.IntrinsicRegExp))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, re2.Install(ctx))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { RE2, escape } from "re2";
+		const date = new RE2("(?P<year>\\d{4})-(?P<month>\\d{2})(-(\\d{2}))?");
+		const m = date.exec("on 2024-03 and é 2025-04-05");
+		const g = new RE2("\\d+", "g");
+		const seen = [];
+		let n;
+		while ((n = g.exec("a1 é22 😀333")) !== null) seen.push([n[0], n.index, g.lastIndex]);
+		globalThis.result = {
+			match: [...m],
+			index: m.index,
+			groups: { ...m.groups },
+			test: [new RE2("^abc$", "im").test("x\nABC"), new RE2("a.b").test("a\nb"), new RE2("a.b", "s").test("a\nb")],
+			seen,
+			matchAll: [..."x1y22".matchAll(new RE2("\\d+", "g"))].map((m) => m[0] + "@" + m.index),
+			strMatch: "😀a1b22".match(new RE2("\\d+", "g")),
+			replace: "2024-03, 2025-04".replace(new RE2("(\\d+)-(?P<m>\\d+)", "g"), "$<m>/$1 ($&) $$"),
+			replaceFirst: "aaa".replace(new RE2("a"), "b"),
+			replaceFn: "a1b2".replace(new RE2("\\d", "g"), (d, offset) => d * 2 + ":" + offset),
+			split: "a, b,c".split(new RE2(",\\s*")),
+			splitCaptures: "a1b2c".split(new RE2("(\\d)"), 4),
+			splitChars: "abc".split(new RE2("")),
+			search: "héllo wörld".search(new RE2("w")),
+			escape: escape("a.b*c"),
+			string: String(new RE2("a+", "gi")),
+			tag: Object.prototype.toString.call(g),
+			regexp: typeof RegExp,
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"match": ["2024-03", "2024", "03", null, null],
+		"index": 3,
+		"groups": {"year": "2024", "month": "03"},
+		"test": [true, false, true],
+		"seen": [["1", 1, 2], ["22", 4, 6], ["333", 9, 12]],
+		"matchAll": ["1@1", "22@3"],
+		"strMatch": ["1", "22"],
+		"replace": "03/2024 (2024-03) $, 04/2025 (2025-04) $",
+		"replaceFirst": "baa",
+		"replaceFn": "a2:1b4:3",
+		"split": ["a", "b", "c"],
+		"splitCaptures": ["a", "1", "b", "2"],
+		"splitChars": ["a", "b", "c"],
+		"search": 6,
+		"escape": "a\\.b\\*c",
+		"string": "/a+/gi",
+		"tag": "[object RE2]",
+		"regexp": "undefined"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`new RE2("(a")`:      "SyntaxError: error parsing regexp: missing closing ): `(a`",
+		`new RE2("(?=a)")`:   "SyntaxError: error parsing regexp: invalid or unsupported Perl syntax: `(?=`",
+		`new RE2("a", "gg")`: `SyntaxError: invalid flags "gg"`,
+		`new RE2("a", "y")`:  `SyntaxError: invalid flags "y"`,
+	} {
+		_, err := ctx.Eval(`import("re2").then(({ RE2 }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
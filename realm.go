@@ -0,0 +1,66 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// WithRealm runs fn with a new context sharing the runtime of ctx, with its own global object and built-in objects and
+// the time zone and locale of ctx, and closes it afterwards: the values of the realm still alive when fn returns are
+// freed, so fn does not need to free them, and its pending promise jobs are run first. Values of the realm must not be
+// used after fn returns; pass results back to ctx as Go values. It returns the error of fn, or of creating the realm.
+func (ctx *Context) WithRealm(fn func(realm *Context) error) error {
+	realm, err := ctx.runtime.NewContextE(ctx.inheritedOptions()...)
+	if err != nil {
+		return err
+	}
+	realm.realm = true
+	defer func() {
+		realm.runOwnJobs()
+		realm.Close()
+	}()
+	return fn(realm)
+}
+
+// runOwnJobs runs the pending promise jobs of the realm. The engine queues the jobs of all the contexts of the runtime
+// together, so a marker job is queued after those of the realm, and the jobs are run up to it until a round runs no
+// job of the realm: the jobs of other contexts queued later are left to them. The uncaught exceptions of the jobs of
+// other contexts run on the way are reported as Loop does.
+func (realm *Context) runOwnJobs() {
+	defer realm.runtime.noteJobs()
+	rt := C.JS_GetRuntime(realm.ref)
+	for C.JS_IsJobPending(rt) == 1 {
+		reached := false
+		marker := realm.Function(func(ctx *Context, this Value, args []Value) Value {
+			reached = true
+			return ctx.Undefined()
+		})
+		queued := C.EnqueueCall(realm.ref, marker.ref)
+		marker.Free()
+		if queued < 0 {
+			C.JS_FreeValue(realm.ref, C.JS_GetException(realm.ref))
+			return
+		}
+
+		own := 0
+		for !reached {
+			var jobCtx *C.JSContext
+			ret := C.JS_ExecutePendingJob(rt, &jobCtx)
+			if ret == 0 {
+				return
+			}
+			switch {
+			case jobCtx == realm.ref:
+				own++
+				if ret < 0 {
+					C.JS_FreeValue(jobCtx, C.JS_GetException(jobCtx))
+				}
+			case ret < 0:
+				C.js_std_dump_error(jobCtx)
+			}
+		}
+		if own <= 1 {
+			return
+		}
+	}
+}
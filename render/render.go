@@ -0,0 +1,205 @@
+/*
+Package render renders text with a JavaScript template function, such as the body of an email or a document,
+compiled once and run on a pool of runtimes with limits enforced on every render.
+*/
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+// ErrOutputTooLarge is returned by Render when the rendered text is longer than the limit set by WithMaxOutput.
+var ErrOutputTooLarge = errors.New("render: output too large")
+
+type options struct {
+	fileName       string
+	workers        int
+	timeout        time.Duration
+	memoryLimit    uint64
+	maxOutput      int
+	runtimeOptions []quickjs.Option
+	setup          func(*quickjs.Context) error
+}
+
+// Option configures a Template.
+type Option func(*options)
+
+// WithFileName sets the file name of the template in stack traces, "<template>" by default.
+func WithFileName(name string) Option {
+	return func(o *options) {
+		o.fileName = name
+	}
+}
+
+// WithWorkers sets the number of runtimes rendering concurrently; default is 1.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// WithTimeout interrupts a render running longer than d.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithMemoryLimit limits the memory of the runtime while a render runs.
+func WithMemoryLimit(limit uint64) Option {
+	return func(o *options) {
+		o.memoryLimit = limit
+	}
+}
+
+// WithMaxOutput limits the length in bytes of the rendered text.
+func WithMaxOutput(n int) Option {
+	return func(o *options) {
+		o.maxOutput = n
+	}
+}
+
+// WithRuntimeOptions sets the options of the runtimes rendering the template.
+func WithRuntimeOptions(opts ...quickjs.Option) Option {
+	return func(o *options) {
+		o.runtimeOptions = opts
+	}
+}
+
+// WithSetup sets a function preparing each context before the template is loaded, for example to define helpers
+// shared by templates.
+func WithSetup(setup func(*quickjs.Context) error) Option {
+	return func(o *options) {
+		o.setup = setup
+	}
+}
+
+// Template is a compiled template function. It is safe for concurrent use.
+type Template struct {
+	exec      *quickjs.Executor
+	maxOutput int
+
+	mu  sync.Mutex
+	fns map[*quickjs.Context]quickjs.Value
+}
+
+// Compile compiles src, a script evaluating to the template function, such as "(data) => `Hello ${data.name}`". The
+// function is called with the data of each render and returns the text, or a promise of it.
+func Compile(src string, opts ...Option) (*Template, error) {
+	o := options{fileName: "<template>", workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	rt := quickjs.NewRuntime(o.runtimeOptions...)
+	ctx := rt.NewContext()
+	bytecode, err := ctx.Compile(src, quickjs.EvalFileName(o.fileName))
+	ctx.Close()
+	rt.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Template{maxOutput: o.maxOutput, fns: map[*quickjs.Context]quickjs.Value{}}
+	var limits []quickjs.TaskOption
+	if o.timeout > 0 {
+		limits = append(limits, quickjs.TaskTimeout(o.timeout))
+	}
+	if o.memoryLimit > 0 {
+		limits = append(limits, quickjs.TaskMemoryLimit(o.memoryLimit))
+	}
+	t.exec, err = quickjs.NewExecutor(
+		quickjs.WithWorkers(o.workers),
+		quickjs.WithRuntimeOptions(o.runtimeOptions...),
+		quickjs.WithTaskLimits(limits...),
+		quickjs.WithContextSetup(func(ctx *quickjs.Context) error {
+			if o.setup != nil {
+				if err := o.setup(ctx); err != nil {
+					return err
+				}
+			}
+			fn, err := ctx.EvalBytecode(bytecode)
+			if err != nil {
+				return err
+			}
+			if !fn.IsFunction() {
+				fn.Free()
+				return fmt.Errorf("render: %s does not evaluate to a function", o.fileName)
+			}
+			t.mu.Lock()
+			t.fns[ctx] = fn
+			t.mu.Unlock()
+			ctx.OnClose(func() {
+				t.mu.Lock()
+				delete(t.fns, ctx)
+				t.mu.Unlock()
+				fn.Free()
+			})
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Literal returns the source of a template function rendering text as the body of a template literal: ${...}
+// placeholders are evaluated with the render data in scope as data, for example "Hello ${data.name}". Backticks and
+// backslashes in text are kept as is.
+func Literal(text string) string {
+	text = strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+	return "(data) => `" + text + "`"
+}
+
+// Render calls the template function with data, converted to JavaScript as encoding/json would encode it, and
+// returns the text it returns.
+func (t *Template) Render(data interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	result, err := t.exec.Submit(func(ctx *quickjs.Context) (interface{}, error) {
+		t.mu.Lock()
+		fn := t.fns[ctx]
+		t.mu.Unlock()
+
+		arg, err := ctx.FromJSONRawMessage(raw)
+		if err != nil {
+			return nil, err
+		}
+		defer arg.Free()
+		ret, err := ctx.InvokeE(fn, ctx.Undefined(), arg)
+		if err != nil {
+			return nil, err
+		}
+		if ret, err = ctx.Await(ret); err != nil {
+			return nil, err
+		}
+		defer ret.Free()
+		if !ret.IsString() {
+			return nil, fmt.Errorf("render: template returned %s, not a string", ret.Kind())
+		}
+		s := ret.String()
+		if t.maxOutput > 0 && len(s) > t.maxOutput {
+			return nil, ErrOutputTooLarge
+		}
+		return s, nil
+	}).Wait()
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
+}
+
+// Close waits for the renders in progress and releases the runtimes.
+func (t *Template) Close() {
+	t.exec.Close()
+}
@@ -0,0 +1,103 @@
+package render_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/render"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := render.Compile(`(data) => data.items.map((item) => shout(item)).join(", ") + "!"`,
+		render.WithWorkers(2),
+		render.WithSetup(func(ctx *quickjs.Context) error {
+			ret, err := ctx.Eval(`globalThis.shout = (s) => s.toUpperCase()`)
+			ret.Free()
+			return err
+		}),
+	)
+	require.NoError(t, err)
+	defer tmpl.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out, err := tmpl.Render(map[string]interface{}{"items": []string{"a", fmt.Sprint(i)}})
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("A, %d!", i), out)
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = tmpl.Render(map[string]interface{}{})
+	require.ErrorContains(t, err, "TypeError")
+}
+
+func TestRenderLiteral(t *testing.T) {
+	tmpl, err := render.Compile(render.Literal("Hello ${data.name},\n`quoted` \\n\n"))
+	require.NoError(t, err)
+	defer tmpl.Close()
+
+	out, err := tmpl.Render(struct {
+		Name string `json:"name"`
+	}{"Ann"})
+	require.NoError(t, err)
+	require.Equal(t, "Hello Ann,\n`quoted` \\n\n", out)
+}
+
+func TestRenderAsync(t *testing.T) {
+	tmpl, err := render.Compile(`async (data) => (await Promise.resolve(data)).toFixed(2)`)
+	require.NoError(t, err)
+	defer tmpl.Close()
+
+	out, err := tmpl.Render(1.5)
+	require.NoError(t, err)
+	require.Equal(t, "1.50", out)
+}
+
+func TestRenderErrors(t *testing.T) {
+	_, err := render.Compile(`(data) =>`, render.WithFileName("mail.js"))
+	require.ErrorContains(t, err, "SyntaxError")
+
+	_, err = render.Compile(`42`)
+	require.ErrorContains(t, err, "does not evaluate to a function")
+
+	tmpl, err := render.Compile(`(data) => data`)
+	require.NoError(t, err)
+	_, err = tmpl.Render(1)
+	require.ErrorContains(t, err, "not a string")
+	_, err = tmpl.Render(func() {})
+	require.Error(t, err)
+	tmpl.Close()
+}
+
+func TestRenderLimits(t *testing.T) {
+	tmpl, err := render.Compile(`(data) => { if (data.loop) for (;;); return "x".repeat(data.n) }`,
+		render.WithTimeout(50*time.Millisecond), render.WithMaxOutput(10))
+	require.NoError(t, err)
+	defer tmpl.Close()
+
+	_, err = tmpl.Render(map[string]interface{}{"loop": true})
+	require.ErrorContains(t, err, "interrupted")
+	_, err = tmpl.Render(map[string]interface{}{"n": 11})
+	require.ErrorIs(t, err, render.ErrOutputTooLarge)
+
+	out, err := tmpl.Render(map[string]interface{}{"n": 10})
+	require.NoError(t, err)
+	require.Equal(t, "xxxxxxxxxx", out)
+
+	mem, err := render.Compile(`(data) => new Array(data.n).fill("abc").join("")`, render.WithMemoryLimit(8<<20))
+	require.NoError(t, err)
+	defer mem.Close()
+	_, err = mem.Render(map[string]interface{}{"n": 1 << 24})
+	require.ErrorContains(t, err, "out of memory")
+	out, err = mem.Render(map[string]interface{}{"n": 2})
+	require.NoError(t, err)
+	require.Equal(t, "abcabc", out)
+}
@@ -0,0 +1,212 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInterrupt is returned by a line editor when the user presses Ctrl-C.
+var ErrInterrupt = errors.New("repl: interrupted")
+
+// lineReader reads input lines after printing a prompt.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// plainReader reads lines from input that is not a terminal, such as a pipe.
+type plainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// editor is a line editor for a terminal in raw mode, with cursor movement, history and the usual Emacs key
+// bindings.
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history *[]string
+
+	prompt string
+	line   []rune
+	pos    int
+}
+
+func newEditor(in io.Reader, out io.Writer, history *[]string) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, history: history}
+}
+
+// ReadLine reads a line, returning io.EOF for Ctrl-D on an empty line and ErrInterrupt for Ctrl-C.
+func (e *editor) ReadLine(prompt string) (string, error) {
+	e.prompt, e.line, e.pos = prompt, e.line[:0], 0
+	hist := len(*e.history)
+	saved := ""
+	e.refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupt
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.line)
+		case 2: // Ctrl-B
+			e.move(-1)
+		case 6: // Ctrl-F
+			e.move(1)
+		case 11: // Ctrl-K
+			e.line = e.line[:e.pos]
+		case 21: // Ctrl-U
+			e.line = append(e.line[:0], e.line[e.pos:]...)
+			e.pos = 0
+		case 23: // Ctrl-W
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			e.line = append(e.line[:start], e.line[e.pos:]...)
+			e.pos = start
+		case 8, 127: // Backspace
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case 16: // Ctrl-P
+			hist, saved = e.recall(hist, -1, saved)
+		case 14: // Ctrl-N
+			hist, saved = e.recall(hist, 1, saved)
+		case 27: // escape sequence
+			switch e.readEscape() {
+			case 'A':
+				hist, saved = e.recall(hist, -1, saved)
+			case 'B':
+				hist, saved = e.recall(hist, 1, saved)
+			case 'C':
+				e.move(1)
+			case 'D':
+				e.move(-1)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.line)
+			case '~':
+				e.deleteAt(e.pos)
+			}
+		case '\t':
+			e.insert(' ', ' ')
+		default:
+			if r != utf8.RuneError && unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of a CSI or SS3 escape sequence and returns its final byte, or '~' for the Delete key.
+func (e *editor) readEscape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	var params []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c >= 0x40 && c <= 0x7e {
+			switch {
+			case c != '~':
+				return c
+			case string(params) == "3":
+				return '~'
+			case string(params) == "1" || string(params) == "7":
+				return 'H'
+			case string(params) == "4" || string(params) == "8":
+				return 'F'
+			}
+			return 0
+		}
+		params = append(params, c)
+	}
+}
+
+func (e *editor) insert(rs ...rune) {
+	e.line = append(e.line[:e.pos], append(rs, e.line[e.pos:]...)...)
+	e.pos += len(rs)
+}
+
+func (e *editor) deleteAt(i int) {
+	if i < len(e.line) {
+		e.line = append(e.line[:i], e.line[i+1:]...)
+	}
+}
+
+func (e *editor) move(delta int) {
+	if pos := e.pos + delta; pos >= 0 && pos <= len(e.line) {
+		e.pos = pos
+	}
+}
+
+// recall replaces the line with the history entry delta steps from hist, keeping the line being edited in saved
+// while browsing the history.
+func (e *editor) recall(hist, delta int, saved string) (int, string) {
+	next := hist + delta
+	if next < 0 || next > len(*e.history) {
+		return hist, saved
+	}
+	if hist == len(*e.history) {
+		saved = string(e.line)
+	}
+	if next == len(*e.history) {
+		e.line = []rune(saved)
+	} else {
+		e.line = []rune((*e.history)[next])
+	}
+	e.pos = len(e.line)
+	return next, saved
+}
+
+// refresh redraws the prompt and the line and puts the cursor in place.
+func (e *editor) refresh() {
+	var b strings.Builder
+	b.WriteString("\r")
+	b.WriteString(e.prompt)
+	b.WriteString(string(e.line))
+	b.WriteString("\x1b[K")
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	fmt.Fprint(e.out, b.String())
+}
@@ -0,0 +1,34 @@
+package repl
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditor(t *testing.T) {
+	history := []string{"first", "second"}
+	keys := strings.Join([]string{
+		"helo\x1b[D\x1b[Dl\x05 world\r", // left arrow twice, insert, Ctrl-E
+		"\x1b[A\x1b[A\x7fX\r",           // up arrow twice, backspace
+		"abc\x01\x1b[3~\x0b\r",          // Ctrl-A, Delete, Ctrl-K
+		"one two\x17\x15x\r",            // Ctrl-W, Ctrl-U
+		"typing\x03",                    // Ctrl-C
+		"\x04",                          // Ctrl-D
+	}, "")
+	var out strings.Builder
+	e := newEditor(strings.NewReader(keys), &out, &history)
+
+	for _, want := range []string{"hello world", "firsX", "", "x"} {
+		line, err := e.ReadLine("> ")
+		require.NoError(t, err)
+		require.Equal(t, want, line)
+	}
+	_, err := e.ReadLine("> ")
+	require.ErrorIs(t, err, ErrInterrupt)
+	_, err = e.ReadLine("> ")
+	require.ErrorIs(t, err, io.EOF)
+	require.Contains(t, out.String(), "\r> hello world\x1b[K")
+}
@@ -0,0 +1,327 @@
+/*
+Package repl implements an interactive read-eval-print loop over a quickjs.Context, to embed a debugging console into
+an application's scripting environment.
+
+Input read from a terminal is edited with a built-in line editor with history; other input is read line by line.
+Lines are collected until they form a complete statement, evaluated in the same context, so that declarations persist
+from one input to the next, and the result is printed with Value.Inspect, in color if the output is a terminal. The
+last result is available as _.
+*/
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// ErrExit is returned by a command to end the session.
+var ErrExit = errors.New("repl: exit")
+
+// CommandFunc runs a dot command with the rest of its input line.
+type CommandFunc func(r *REPL, args string) error
+
+type command struct {
+	help string
+	run  CommandFunc
+}
+
+// Option configures a REPL.
+type Option func(*REPL)
+
+// WithPrompt sets the prompt, "> " by default, and the prompt shown while a statement continues on the next line,
+// "... " by default.
+func WithPrompt(prompt, continuation string) Option {
+	return func(r *REPL) {
+		r.prompt, r.continuation = prompt, continuation
+	}
+}
+
+// WithHistorySize sets the number of input lines kept in the history, 1000 by default.
+func WithHistorySize(n int) Option {
+	return func(r *REPL) {
+		r.historySize = n
+	}
+}
+
+// WithFileName sets the file name of the evaluated input in stack traces, "<repl>" by default.
+func WithFileName(name string) Option {
+	return func(r *REPL) {
+		r.fileName = name
+	}
+}
+
+// WithCommand adds a dot command: an input line ".name args" runs fn instead of being evaluated. Commands can
+// replace the built-in .help, .exit and .break commands.
+func WithCommand(name, help string, fn CommandFunc) Option {
+	return func(r *REPL) {
+		r.commands[name] = command{help: help, run: fn}
+	}
+}
+
+// WithInspectOptions sets the options used to render results; colors are enabled by default when the output is a
+// terminal.
+func WithInspectOptions(opts ...quickjs.InspectOption) Option {
+	return func(r *REPL) {
+		r.inspectOpts = opts
+	}
+}
+
+// WithEvalOptions adds options to the evaluation of each input, such as an EvalQuota limiting each evaluation on its
+// own.
+func WithEvalOptions(opts ...quickjs.EvalOption) Option {
+	return func(r *REPL) {
+		r.evalOpts = append(r.evalOpts, opts...)
+	}
+}
+
+// REPL is an interactive session evaluating input in a context.
+type REPL struct {
+	ctx          *quickjs.Context
+	prompt       string
+	continuation string
+	historySize  int
+	fileName     string
+	commands     map[string]command
+	inspectOpts  []quickjs.InspectOption
+	evalOpts     []quickjs.EvalOption
+
+	history []string
+	pending string
+	inspect []quickjs.InspectOption
+	out     io.Writer
+	errOut  io.Writer
+}
+
+// New returns a REPL evaluating input in ctx. The context stays owned by the caller.
+func New(ctx *quickjs.Context, opts ...Option) *REPL {
+	r := &REPL{
+		ctx:          ctx,
+		prompt:       "> ",
+		continuation: "... ",
+		historySize:  1000,
+		fileName:     "<repl>",
+		commands: map[string]command{
+			"help": {help: "Print this help message", run: helpCommand},
+			"exit": {help: "Exit the REPL", run: func(*REPL, string) error { return ErrExit }},
+			"break": {help: "Discard the statement being entered", run: func(r *REPL, _ string) error {
+				r.pending = ""
+				return nil
+			}},
+		},
+		out:    io.Discard,
+		errOut: io.Discard,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.inspect = r.inspectOpts
+	return r
+}
+
+// Context returns the context the REPL evaluates input in.
+func (r *REPL) Context() *quickjs.Context {
+	return r.ctx
+}
+
+// History returns the input lines entered so far, oldest first.
+func (r *REPL) History() []string {
+	return append([]string(nil), r.history...)
+}
+
+// Output returns the writers for results and errors of the running session, for use by commands.
+func (r *REPL) Output() (out, errOut io.Writer) {
+	return r.out, r.errOut
+}
+
+// Eval evaluates src in the REPL's context and returns the result rendered by Value.Inspect. Promises are awaited, and
+// input starting with "{" is evaluated as an object literal if it parses as one.
+func (r *REPL) Eval(src string) (string, error) {
+	var ret quickjs.Value
+	var err error
+	if trimmed := strings.TrimSpace(src); strings.HasPrefix(trimmed, "{") && !strings.HasSuffix(trimmed, ";") {
+		ret, err = r.eval("(" + src + "\n)")
+		var jsErr *quickjs.Error
+		if errors.As(err, &jsErr) && strings.HasPrefix(jsErr.Cause, "SyntaxError") {
+			ret, err = r.eval(src)
+		}
+	} else {
+		ret, err = r.eval(src)
+	}
+	if err != nil {
+		return "", err
+	}
+	s := ret.Inspect(r.inspect...)
+	r.ctx.Globals().Set("_", ret)
+	return s, nil
+}
+
+func (r *REPL) eval(src string) (quickjs.Value, error) {
+	opts := append([]quickjs.EvalOption{quickjs.EvalFileName(r.fileName), quickjs.EvalAwait(true)}, r.evalOpts...)
+	return r.ctx.Eval(src, opts...)
+}
+
+// Run reads input from in until it ends or the .exit command, writing results to out and errors to errOut. If in is
+// a terminal, lines are edited with cursor keys and history.
+func (r *REPL) Run(in io.Reader, out, errOut io.Writer) error {
+	r.out, r.errOut = out, errOut
+	if f, ok := out.(*os.File); ok && isTerminal(int(f.Fd())) {
+		r.inspect = append([]quickjs.InspectOption{quickjs.InspectColors(true)}, r.inspectOpts...)
+	}
+	defer func() { r.out, r.errOut, r.inspect = io.Discard, io.Discard, r.inspectOpts }()
+
+	var lines lineReader = &plainReader{in: bufio.NewReader(in), out: out}
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		lines = &termReader{fd: int(f.Fd()), editor: newEditor(in, out, &r.history)}
+	}
+
+	r.pending = ""
+	for {
+		prompt := r.prompt
+		if r.pending != "" {
+			prompt = r.continuation
+		}
+		line, err := lines.ReadLine(prompt)
+		switch {
+		case errors.Is(err, ErrInterrupt):
+			r.pending = ""
+			continue
+		case err == io.EOF:
+			if _, ok := lines.(*plainReader); ok {
+				fmt.Fprintln(out)
+			}
+			return nil
+		case err != nil:
+			return err
+		}
+		if strings.TrimSpace(line) != "" {
+			r.addHistory(line)
+		}
+
+		if name, args, ok := r.parseCommand(line); ok {
+			if err := r.commands[name].run(r, args); err != nil {
+				if errors.Is(err, ErrExit) {
+					return nil
+				}
+				fmt.Fprintln(errOut, err)
+			}
+			continue
+		}
+
+		if r.pending != "" {
+			line = r.pending + "\n" + line
+		}
+		if strings.TrimSpace(line) == "" {
+			r.pending = ""
+			continue
+		}
+		if Incomplete(line) {
+			r.pending = line
+			continue
+		}
+		r.pending = ""
+
+		result, err := r.Eval(line)
+		if err != nil {
+			printError(errOut, err)
+			continue
+		}
+		fmt.Fprintln(out, result)
+	}
+}
+
+// parseCommand returns the name and arguments of a dot command line. Lines such as ".5" that do not name a command
+// are evaluated.
+func (r *REPL) parseCommand(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, ".") {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(line[1:], " ")
+	if _, ok := r.commands[name]; !ok {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+func (r *REPL) addHistory(line string) {
+	if n := len(r.history); n > 0 && r.history[n-1] == line {
+		return
+	}
+	r.history = append(r.history, line)
+	if over := len(r.history) - r.historySize; over > 0 {
+		r.history = append(r.history[:0], r.history[over:]...)
+	}
+}
+
+func helpCommand(r *REPL, _ string) error {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(r.out, ".%-10s %s\n", name, r.commands[name].help)
+	}
+	return nil
+}
+
+// printError prints an uncaught error with its stack trace.
+func printError(w io.Writer, err error) {
+	var jsErr *quickjs.Error
+	if errors.As(err, &jsErr) && jsErr.Stack != "" {
+		fmt.Fprintf(w, "Uncaught %s\n%s\n", jsErr.Cause, strings.TrimRight(jsErr.Stack, "\n"))
+		return
+	}
+	fmt.Fprintf(w, "Uncaught %v\n", err)
+}
+
+// Incomplete reports whether src is the beginning of a statement that continues on the next line: it has unclosed
+// brackets, template literals or comments, or ends with an arrow.
+func Incomplete(src string) bool {
+	tokens, err := jslex.Tokenize(src)
+	if err != nil {
+		var lexErr *jslex.Error
+		return errors.As(err, &lexErr) &&
+			(lexErr.Message == "unterminated template literal" || lexErr.Message == "unterminated comment")
+	}
+	depth := 0
+	for _, tok := range tokens {
+		switch {
+		case tok.Kind == jslex.TemplateHead:
+			depth++
+		case tok.Kind == jslex.TemplateTail:
+			depth--
+		case tok.Is("(") || tok.Is("[") || tok.Is("{"):
+			depth++
+		case tok.Is(")") || tok.Is("]") || tok.Is("}"):
+			depth--
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+	return len(tokens) > 1 && tokens[len(tokens)-2].Is("=>")
+}
+
+// termReader edits lines with the terminal in raw mode, restoring it while the input is evaluated.
+type termReader struct {
+	fd     int
+	editor *editor
+}
+
+func (t *termReader) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(t.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return t.editor.ReadLine(prompt)
+}
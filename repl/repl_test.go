@@ -0,0 +1,104 @@
+package repl_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/repl"
+	"github.com/stretchr/testify/require"
+)
+
+func newContext(t *testing.T) *quickjs.Context {
+	rt := quickjs.NewRuntime()
+	ctx := rt.NewContext()
+	t.Cleanup(func() {
+		ctx.Close()
+		rt.Close()
+	})
+	return ctx
+}
+
+func TestIncomplete(t *testing.T) {
+	for src, want := range map[string]bool{
+		`1 + 1`:                   false,
+		`function f() {`:          true,
+		`function f() { return }`: false,
+		"`a ${":                   true,
+		"`a\n":                    true,
+		`/* comment`:              true,
+		`[1, 2,`:                  true,
+		`const f = x =>`:          true,
+		`"unterminated`:           false,
+		`)`:                       false,
+	} {
+		require.Equal(t, want, repl.Incomplete(src), src)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := newContext(t)
+	r := repl.New(ctx, repl.WithPrompt("js> ", "... "), repl.WithCommand("double", "Double a number", func(r *repl.REPL, args string) error {
+		out, _ := r.Output()
+		_, err := out.Write([]byte(args + args + "\n"))
+		return err
+	}))
+
+	input := strings.Join([]string{
+		`const a = 20`,
+		`function twice(x) {`,
+		`  return x * 2`,
+		`}`,
+		`twice(a) + 2`,
+		`_ + 1`,
+		`{ b: [1] }`,
+		`.5`,
+		`[1,`,
+		`.break`,
+		`await Promise.resolve(1)`,
+		`Promise.resolve("done")`,
+		`missing`,
+		`.double 21`,
+		`.exit`,
+		`"unreached"`,
+	}, "\n") + "\n"
+	var out, errOut bytes.Buffer
+	require.NoError(t, r.Run(strings.NewReader(input), &out, &errOut))
+
+	lines := strings.Split(out.String(), "js> ")
+	require.Equal(t, []string{
+		"",
+		"undefined\n",
+		"... ... undefined\n",
+		"42\n",
+		"43\n",
+		"{ b: [ 1 ] }\n",
+		"0.5\n",
+		"... ",
+		"",
+		"'done'\n",
+		"",
+		"2121\n",
+		"",
+	}, lines)
+	require.Contains(t, errOut.String(), "Uncaught SyntaxError")
+	require.Contains(t, errOut.String(), "Uncaught ReferenceError: 'missing' is not defined")
+	require.Equal(t, "twice(a) + 2", r.History()[4])
+
+	result, err := r.Eval("a")
+	require.NoError(t, err)
+	require.Equal(t, "20", result)
+	_, err = r.Eval("throw new Error('boom')")
+	require.Error(t, err)
+}
+
+func TestRunEOF(t *testing.T) {
+	ctx := newContext(t)
+	var out bytes.Buffer
+	err := repl.New(ctx, repl.WithHistorySize(2)).Run(strings.NewReader("1\n2\n3"), &out, &out)
+	require.NoError(t, err)
+	require.Equal(t, "> 1\n> 2\n> 3\n> \n", out.String())
+	require.False(t, errors.Is(err, repl.ErrExit))
+}
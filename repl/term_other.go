@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package repl
+
+import "errors"
+
+// makeRaw is not supported on this platform, so input is always read line by line.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("repl: raw terminal mode is not supported on this platform")
+}
+
+func isTerminal(fd int) bool { return false }
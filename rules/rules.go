@@ -0,0 +1,280 @@
+/*
+Package rules evaluates named JavaScript expressions, such as eligibility checks or computed prices, against Go data.
+
+Each rule is compiled once into a function of the data. The data is converted as encoding/json would encode it, and
+its fields are in scope in the expression, so that a rule reads "age >= 18 && country === 'NL'"; the whole data is
+also available as data. Failing rules are reported with the position of their source.
+*/
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buke/quickjs-go"
+)
+
+// Position is a location in the source of rules.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return p.File + ":" + strconv.Itoa(p.Line)
+}
+
+// Rule is a named expression.
+type Rule struct {
+	Name string
+	Expr string
+	// Pos is where Expr starts in the source it was read from; the rule name and line 1 by default.
+	Pos Position
+}
+
+// Failure reports a rule that evaluated to false, or to an error.
+type Failure struct {
+	Rule string
+	Pos  Position
+	Err  error // nil if the rule evaluated to false
+}
+
+func (f *Failure) Error() string {
+	if f.Err == nil {
+		return fmt.Sprintf("rules: rule %q at %s failed", f.Rule, f.Pos)
+	}
+	return fmt.Sprintf("rules: rule %q at %s: %v", f.Rule, f.Pos, f.Err)
+}
+
+func (f *Failure) Unwrap() error { return f.Err }
+
+type options struct {
+	runtimeOptions []quickjs.Option
+	setup          func(*quickjs.Context) error
+}
+
+// Option configures an Engine.
+type Option func(*options)
+
+// WithRuntimeOptions sets the options of the runtime evaluating the rules, such as quickjs.WithExecuteTimeout.
+func WithRuntimeOptions(opts ...quickjs.Option) Option {
+	return func(o *options) {
+		o.runtimeOptions = opts
+	}
+}
+
+// WithSetup sets a function preparing the context before rules are added, for example to define helper functions.
+func WithSetup(setup func(*quickjs.Context) error) Option {
+	return func(o *options) {
+		o.setup = setup
+	}
+}
+
+type compiledRule struct {
+	Rule
+	fn quickjs.Value
+}
+
+// Engine holds compiled rules. It is safe for concurrent use; evaluations run one at a time.
+type Engine struct {
+	mu    sync.Mutex
+	rt    quickjs.Runtime
+	ctx   *quickjs.Context
+	rules []*compiledRule
+	names map[string]*compiledRule
+}
+
+// New returns an engine without rules.
+func New(opts ...Option) (*Engine, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e := &Engine{rt: quickjs.NewRuntime(o.runtimeOptions...), names: map[string]*compiledRule{}}
+	e.ctx = e.rt.NewContext()
+	if o.setup != nil {
+		if err := o.setup(e.ctx); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Close releases the runtime of the engine.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		r.fn.Free()
+	}
+	e.rules, e.names = nil, map[string]*compiledRule{}
+	e.ctx.Close()
+	e.rt.Close()
+}
+
+// Add compiles rules and adds them to the engine, replacing rules of the same names. A rule that does not compile is
+// reported as a *Failure, and none of the rules are added.
+func (e *Engine) Add(rules ...Rule) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Pos.File == "" {
+			r.Pos = Position{File: r.Name, Line: 1}
+		}
+		// The expression starts on the second line, so that lines of errors map to lines of the rule.
+		fn, err := e.ctx.Eval("(function (data) { with (data ?? {}) return (\n"+r.Expr+"\n) })",
+			quickjs.EvalFileName(r.Pos.File))
+		if err != nil {
+			for _, c := range compiled {
+				c.fn.Free()
+			}
+			return failure(r, err)
+		}
+		compiled = append(compiled, &compiledRule{Rule: r, fn: fn})
+	}
+	for _, c := range compiled {
+		if old, ok := e.names[c.Name]; ok {
+			old.fn.Free()
+			old.Rule, old.fn = c.Rule, c.fn
+			continue
+		}
+		e.rules = append(e.rules, c)
+		e.names[c.Name] = c
+	}
+	return nil
+}
+
+// Remove removes the named rules from the engine.
+func (e *Engine) Remove(names ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, name := range names {
+		r, ok := e.names[name]
+		if !ok {
+			continue
+		}
+		r.fn.Free()
+		delete(e.names, name)
+		for i, c := range e.rules {
+			if c == r {
+				e.rules = append(e.rules[:i], e.rules[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Names returns the names of the rules, in the order they were first added.
+func (e *Engine) Names() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names := make([]string, len(e.rules))
+	for i, r := range e.rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Eval evaluates the named rule against data and returns its value decoded as encoding/json would decode it into an
+// interface{}. Errors of the rule are reported as a *Failure.
+func (e *Engine) Eval(name string, data interface{}) (interface{}, error) {
+	var result interface{}
+	err := e.eval(name, data, func(r *compiledRule, v quickjs.Value) error {
+		b, err := v.MarshalJSON()
+		if err != nil {
+			return failure(r.Rule, err)
+		}
+		return json.Unmarshal(b, &result)
+	})
+	return result, err
+}
+
+// Check evaluates the named boolean rule against data. A rule that does not evaluate to a boolean is reported as a
+// *Failure, like its errors.
+func (e *Engine) Check(name string, data interface{}) (bool, error) {
+	ok, _, err := e.check(name, data)
+	return ok, err
+}
+
+// CheckAll evaluates all rules against data in the order they were added, stopping at the first that does not
+// evaluate to true, which is returned as a *Failure.
+func (e *Engine) CheckAll(data interface{}) error {
+	for _, name := range e.Names() {
+		ok, r, err := e.check(name, data)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &Failure{Rule: r.Name, Pos: r.Pos}
+		}
+	}
+	return nil
+}
+
+func (e *Engine) check(name string, data interface{}) (ok bool, rule Rule, err error) {
+	err = e.eval(name, data, func(r *compiledRule, v quickjs.Value) error {
+		rule = r.Rule
+		if !v.IsBool() {
+			return failure(r.Rule, fmt.Errorf("rule returned %s, not a boolean", v.Kind()))
+		}
+		ok = v.Bool()
+		return nil
+	})
+	return ok, rule, err
+}
+
+func (e *Engine) eval(name string, data interface{}, result func(*compiledRule, quickjs.Value) error) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.names[name]
+	if !ok {
+		return fmt.Errorf("rules: no rule %q", name)
+	}
+	arg, err := e.ctx.FromJSONRawMessage(raw)
+	if err != nil {
+		return err
+	}
+	defer arg.Free()
+	v, err := e.ctx.InvokeE(r.fn, e.ctx.Undefined(), arg)
+	if err != nil {
+		return failure(r.Rule, err)
+	}
+	defer v.Free()
+	return result(r, v)
+}
+
+var stackLine = regexp.MustCompile(`at (?:.* \()?(.*):(\d+)\)?$`)
+
+// failure reports an error of a rule, at the line of the rule the stack trace of a JS error points to.
+func failure(r Rule, err error) *Failure {
+	f := &Failure{Rule: r.Name, Pos: r.Pos, Err: err}
+	var jsErr *quickjs.Error
+	if !errors.As(err, &jsErr) {
+		return f
+	}
+	for _, line := range strings.Split(jsErr.Stack, "\n") {
+		m := stackLine.FindStringSubmatch(line)
+		if m == nil || m[1] != r.Pos.File {
+			continue
+		}
+		// Line 2 of the compiled function is the first line of the expression.
+		if n, _ := strconv.Atoi(m[2]); n >= 2 && n-2 <= strings.Count(r.Expr, "\n") {
+			f.Pos.Line = r.Pos.Line + n - 2
+		}
+		break
+	}
+	return f
+}
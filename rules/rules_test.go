@@ -0,0 +1,92 @@
+package rules_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/rules"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	Country string   `json:"country"`
+	Age     int      `json:"age"`
+	Items   []string `json:"items"`
+	Total   float64  `json:"total"`
+}
+
+func TestRules(t *testing.T) {
+	engine, err := rules.New(rules.WithSetup(func(ctx *quickjs.Context) error {
+		ret, err := ctx.Eval(`globalThis.within = (v, lo, hi) => v >= lo && v <= hi`)
+		ret.Free()
+		return err
+	}))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NoError(t, engine.Add(
+		rules.Rule{Name: "adult", Expr: `age >= 18`, Pos: rules.Position{File: "shop.rules", Line: 3}},
+		rules.Rule{Name: "domestic", Expr: `country === "NL" ||
+			country === "BE"`, Pos: rules.Position{File: "shop.rules", Line: 4}},
+		rules.Rule{Name: "shipping", Expr: `within(total, 0, 50) ? 4.95 : 0`},
+		rules.Rule{Name: "summary", Expr: `({count: items.length, first: data.items[0]})`},
+	))
+	require.Equal(t, []string{"adult", "domestic", "shipping", "summary"}, engine.Names())
+
+	o := order{Country: "NL", Age: 30, Items: []string{"book", "pen"}, Total: 12.5}
+	ok, err := engine.Check("adult", o)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	v, err := engine.Eval("shipping", o)
+	require.NoError(t, err)
+	require.Equal(t, 4.95, v)
+	v, err = engine.Eval("summary", o)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"count": 2.0, "first": "book"}, v)
+
+	_, err = engine.Check("shipping", o)
+	var failure *rules.Failure
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, "shipping", failure.Rule)
+	require.ErrorContains(t, err, "not a boolean")
+
+	engine.Remove("shipping", "summary")
+	require.NoError(t, engine.CheckAll(o))
+
+	o.Country = "DE"
+	err = engine.CheckAll(o)
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, &rules.Failure{Rule: "domestic", Pos: rules.Position{File: "shop.rules", Line: 4}}, failure)
+	require.EqualError(t, err, `rules: rule "domestic" at shop.rules:4 failed`)
+
+	require.NoError(t, engine.Add(rules.Rule{Name: "domestic", Expr: `true &&
+		items.find((i) => i.startsWith("x")).length > 0`, Pos: rules.Position{File: "shop.rules", Line: 10}}))
+	err = engine.CheckAll(o)
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, rules.Position{File: "shop.rules", Line: 11}, failure.Pos)
+	var jsErr *quickjs.Error
+	require.True(t, errors.As(err, &jsErr))
+	require.Contains(t, jsErr.Cause, "TypeError")
+
+	_, err = engine.Check("missing", o)
+	require.ErrorContains(t, err, `no rule "missing"`)
+}
+
+func TestRulesCompileError(t *testing.T) {
+	engine, err := rules.New()
+	require.NoError(t, err)
+	defer engine.Close()
+
+	err = engine.Add(
+		rules.Rule{Name: "ok", Expr: `true`},
+		rules.Rule{Name: "broken", Expr: "a &&\n\nb +* 2", Pos: rules.Position{File: "r.js", Line: 7}},
+	)
+	var failure *rules.Failure
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, "broken", failure.Rule)
+	require.Equal(t, rules.Position{File: "r.js", Line: 9}, failure.Pos)
+	require.ErrorContains(t, err, "SyntaxError")
+	require.Empty(t, engine.Names())
+}
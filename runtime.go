@@ -2,27 +2,105 @@ package quickjs
 
 /*
 #include "bridge.h"
-#include <time.h>
 */
 import "C"
 import (
 	"runtime"
-	"unsafe"
+	"runtime/cgo"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var classesOnce sync.Once
+
 // Runtime represents a Javascript runtime corresponding to an object heap. Several runtimes can exist at the same time but they cannot exchange objects. Inside a given runtime, no multi-threading is supported.
 type Runtime struct {
 	ref     *C.JSRuntime
 	options *Options
+	state   *runtimeState
+}
+
+// runtimeState is the mutable state shared by all copies of a Runtime.
+type runtimeState struct {
+	handle           cgo.Handle
+	handlerInstalled bool
+	deadline         time.Time
+	interruptHandler InterruptHandler
+	profilers        map[*Context]*profiler
+	trace            *traceHooks
+	trackValues      bool
+	leakHandler      LeakHandler
+	autoFree         autoFreeQueue
+	opaque           interface{}
+	collected        []func() // FinalizationRegistry callbacks waiting to run
+	quota            *quotaState
+	gcHooks          *gcHooks
+	closeLeak        *CloseLeakError
+	onCloseLeak      func(err *CloseLeakError)
+	modulePolicy     ModulePolicy
+	performanceHook  PerformanceHook
+	middleware       []Middleware
+	onThrow          func(exc Value, frames []StackFrame)
+	inOnThrow        bool   // set while onThrow runs
+	globalNames      string // JSON array of the names of the globals of a new context
+
+	mu          sync.Mutex // guards stats against Close when reading statistics from another goroutine
+	stats       *C.RuntimeStats
+	final       RuntimeStats
+	evals       atomic.Uint64
+	interrupts  atomic.Uint64
+	jobsPending atomic.Bool // whether jobs were pending after the last evaluation or run of the jobs
+
+	interruptChecks uint64     // calls of the interrupt handler
+	sampling        bool       // set while the interrupt handler allocates
+	allocFailures   uint64     // allocations refused when the last evaluation started
+	stackHits       uint64     // allocations near the stack limit when the last evaluation started
+	memoryLimit     uint64     // memory limit set by SetMemoryLimit, 0 for none
+	evalStats       []*Context // contexts evaluating with EvalStats, innermost last
+}
+
+// sampleReserve is the memory left below the memory limit under which the interrupt handler stops allocating.
+const sampleReserve = 256 << 10
+
+// interrupt is called periodically by the engine while executing JS code; a non-zero result interrupts the execution.
+func (s *runtimeState) interrupt() int {
+	s.interruptChecks++
+	// The handler runs in the middle of an operation of the engine, so the GC sentinel and the stacks of the
+	// profilers, which allocate objects, are skipped if the handler is re-entered through them and when the
+	// allocation could hit the memory limit.
+	if !s.sampling && (s.memoryLimit == 0 || uint64(s.stats.memory_used)+sampleReserve < s.memoryLimit) {
+		s.sampling = true
+		if n := len(s.evalStats); n > 0 {
+			C.ArmGCSentinel(s.evalStats[n-1].ref, s.stats)
+		}
+		for _, p := range s.profilers {
+			p.sample()
+		}
+		s.sampling = false
+	}
+	if (!s.deadline.IsZero() && time.Now().After(s.deadline)) || (s.quota != nil && s.quota.check()) ||
+		(s.interruptHandler != nil && s.interruptHandler() != 0) {
+		s.interrupts.Add(1)
+		return 1
+	}
+	return 0
 }
 
 type Options struct {
-	timeout      uint64
-	memoryLimit  uint64
-	gcThreshold  uint64
-	maxStackSize uint64
-	canBlock     bool
-	moduleImport bool
+	timeout       uint64
+	memoryLimit   uint64
+	gcThreshold   uint64
+	maxStackSize  uint64
+	canBlock      bool
+	moduleImport  bool
+	moduleLoader  ModuleLoaderFunc
+	nativeModules bool
+	autoFree      bool
+	timezone      *time.Location
+	locale        string
+	intrinsics    Intrinsic
+	limits        Limits
 }
 
 type Option func(*Options)
@@ -55,7 +133,7 @@ func WithMaxStackSize(maxStackSize uint64) Option {
 	}
 }
 
-// WithCanBlock will set the runtime's can block; default is true
+// WithCanBlock will set the runtime's can block; default is true. See Runtime.SetCanBlock.
 func WithCanBlock(canBlock bool) Option {
 	return func(o *Options) {
 		o.canBlock = canBlock
@@ -68,6 +146,93 @@ func WithModuleImport(moduleImport bool) Option {
 	}
 }
 
+// ModuleLoaderFunc returns the source code of the module with the given normalized name.
+type ModuleLoaderFunc func(ctx *Context, moduleName string) (string, error)
+
+// WithModuleLoader will set a Go function used to load imported modules instead of reading them from the file system; it implies module import.
+func WithModuleLoader(loader ModuleLoaderFunc) Option {
+	return func(o *Options) {
+		o.moduleLoader = loader
+		o.moduleImport = true
+	}
+}
+
+// WithNativeModules will allow importing QuickJS C modules compiled as shared libraries: specifiers ending in ".so"
+// or ".dylib" are loaded with dlopen and initialized with their js_init_module function, even with a module loader set;
+// it implies module import. The libraries use the QuickJS API of the program, which must export it: on Linux, build
+// with -ldflags=-extldflags=-rdynamic. Not supported on Windows. Without it, importing such a specifier fails.
+func WithNativeModules(enable bool) Option {
+	return func(o *Options) {
+		o.nativeModules = enable
+		o.moduleImport = o.moduleImport || enable
+	}
+}
+
+// WithTimezone will set the time zone used by the local time methods of Date in the runtime's contexts; default is
+// the time zone of the process.
+func WithTimezone(loc *time.Location) Option {
+	return func(o *Options) {
+		o.timezone = loc
+	}
+}
+
+// WithLocale will set the default locale, a BCP 47 language tag, of the locale-sensitive methods of the runtime's
+// contexts (Number.prototype.toLocaleString, String.prototype.localeCompare, toLocaleUpperCase and toLocaleLowerCase).
+func WithLocale(locale string) Option {
+	return func(o *Options) {
+		o.locale = locale
+	}
+}
+
+// WithIntrinsics will restrict the built-in objects of the runtime's contexts to the given set; default is
+// IntrinsicAll.
+func WithIntrinsics(intrinsics Intrinsic) Option {
+	return func(o *Options) {
+		o.intrinsics = intrinsics
+	}
+}
+
+// RuntimeOptions gathers the configuration of a runtime, applied by NewRuntimeWithOptions before any context exists.
+// Zero values keep the defaults of NewRuntime.
+type RuntimeOptions struct {
+	ExecuteTimeout uint64
+	MemoryLimit    uint64
+	GCThreshold    uint64
+	MaxStackSize   uint64
+	// DisallowBlocking makes Atomics.wait throw instead of blocking.
+	DisallowBlocking bool
+	Timezone         *time.Location
+	Locale           string
+	Intrinsics       Intrinsic
+	Limits           Limits
+	ModuleImport     bool
+	ModuleLoader     ModuleLoaderFunc
+	AutoFree         bool
+}
+
+// NewRuntimeWithOptions creates a new quickjs runtime configured with opts.
+func NewRuntimeWithOptions(opts RuntimeOptions) Runtime {
+	options := []Option{
+		WithExecuteTimeout(opts.ExecuteTimeout),
+		WithMemoryLimit(opts.MemoryLimit),
+		WithGCThreshold(opts.GCThreshold),
+		WithMaxStackSize(opts.MaxStackSize),
+		WithCanBlock(!opts.DisallowBlocking),
+		WithTimezone(opts.Timezone),
+		WithLocale(opts.Locale),
+		WithIntrinsics(opts.Intrinsics),
+		WithLimits(opts.Limits),
+		WithModuleImport(opts.ModuleImport),
+	}
+	if opts.ModuleLoader != nil {
+		options = append(options, WithModuleLoader(opts.ModuleLoader))
+	}
+	if opts.AutoFree {
+		options = append(options, WithAutoFree())
+	}
+	return NewRuntime(options...)
+}
+
 // NewRuntime creates a new quickjs runtime.
 func NewRuntime(opts ...Option) Runtime {
 	runtime.LockOSThread() // prevent multiple quickjs runtime from being created
@@ -84,7 +249,16 @@ func NewRuntime(opts ...Option) Runtime {
 		opt(options)
 	}
 
-	rt := Runtime{ref: C.JS_NewRuntime(), options: options}
+	classesOnce.Do(func() {
+		C.InitGCSentinelClass()
+		C.InitGoObjectClass()
+		C.InitWorkers()
+	})
+	state := &runtimeState{}
+	rt := Runtime{ref: C.NewRuntime(&state.stats), options: options, state: state}
+	rt.state.handle = cgo.NewHandle(rt.state)
+	// The timers and handlers of the std module belong to the runtime, shared by its contexts.
+	C.js_std_init_handlers(rt.ref)
 
 	if rt.options.timeout > 0 {
 		rt.SetExecuteTimeout(rt.options.timeout)
@@ -98,23 +272,50 @@ func NewRuntime(opts ...Option) Runtime {
 	if rt.options.maxStackSize > 0 {
 		rt.SetMaxStackSize(rt.options.maxStackSize)
 	}
-	if rt.options.canBlock {
-		C.JS_SetCanBlock(rt.ref, C.int(1))
+	if rt.options.limits.MaxAllocation > 0 {
+		rt.state.stats.max_alloc = C.size_t(rt.options.limits.MaxAllocation)
 	}
+	rt.SetCanBlock(rt.options.canBlock)
 	return rt
 }
 
 // RunGC will call quickjs's garbage collector.
 func (r Runtime) RunGC() {
-	C.JS_RunGC(r.ref)
+	r.freeCollected()
+	r.runGC()
+	r.runCollected()
 }
 
 // Close will free the runtime pointer.
 func (r Runtime) Close() {
-	C.JS_FreeRuntime(r.ref)
+	r.state.mu.Lock()
+	r.state.final = r.stats()
+	if C.FreeRuntime(r.ref, r.state.stats) < 0 {
+		r.setCloseLeak("objects are still alive when the runtime is freed")
+	}
+	r.state.stats = nil
+	r.state.mu.Unlock()
+	r.state.handle.Delete()
+	r.runCollected()
 }
 
-// SetCanBlock will set the runtime's can block; default is true
+// SetOpaque stores user data in the runtime, retrievable with Opaque from any copy of the runtime, for example
+// through ctx.Runtime() in callbacks. The data is kept on the Go side because the engine's runtime opaque pointer
+// is used by the std module.
+func (r Runtime) SetOpaque(opaque interface{}) {
+	r.state.opaque = opaque
+}
+
+// Opaque returns the user data stored with SetOpaque.
+func (r Runtime) Opaque() interface{} {
+	return r.state.opaque
+}
+
+// SetCanBlock will set the runtime's can block; default is true. Atomics.wait throws a TypeError in a runtime that
+// cannot block. Otherwise it blocks the goroutine running the runtime, and its OS thread, until another runtime
+// sharing the SharedArrayBuffer calls Atomics.notify or the wait times out: the execute timeout and the interrupt
+// handler cannot stop it. Allow blocking only in runtimes dedicated to a worker, such as os.Worker threads, and
+// always pass a timeout to Atomics.wait.
 func (r Runtime) SetCanBlock(canBlock bool) {
 	if canBlock {
 		C.JS_SetCanBlock(r.ref, C.int(1))
@@ -125,6 +326,7 @@ func (r Runtime) SetCanBlock(canBlock bool) {
 
 // SetMemoryLimit the runtime memory limit; if not set, it will be unlimit.
 func (r Runtime) SetMemoryLimit(limit uint64) {
+	r.state.memoryLimit = limit
 	C.JS_SetMemoryLimit(r.ref, C.size_t(limit))
 }
 
@@ -133,40 +335,160 @@ func (r Runtime) SetGCThreshold(threshold uint64) {
 	C.JS_SetGCThreshold(r.ref, C.size_t(threshold))
 }
 
-// SetMaxStackSize will set max runtime's stack size; default is 255
+// SetMaxStackSize will set max runtime's stack size in bytes; default is 256 KiB. Exceeding it throws a catchable
+// InternalError matching ErrStackOverflow. The size counts the C stack of nested calls through Go functions too
+// (script → Go → script), so recursion across the boundary is stopped as well; 0 disables the check, and a deep
+// recursion then crashes the process.
 func (r Runtime) SetMaxStackSize(stack_size uint64) {
-	C.JS_SetMaxStackSize(r.ref, C.size_t(stack_size))
+	C.SetMaxStackSize(r.ref, r.state.stats, C.size_t(stack_size))
 }
 
-// SetExecuteTimeout will set the runtime's execute timeout; default is 0
+// SetExecuteTimeout will set the runtime's execute timeout in seconds, counted from now; default is 0
 func (r Runtime) SetExecuteTimeout(timeout uint64) {
-	C.SetExecuteTimeout(r.ref, C.time_t(timeout))
+	if timeout == 0 {
+		r.state.deadline = time.Time{}
+		return
+	}
+	r.state.deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	r.enableInterrupts()
+}
+
+// enableInterrupts installs the interrupt handler dispatching to the execute timeout, the user interrupt handler and profilers.
+// It is only installed on demand because it calls into Go periodically.
+func (r Runtime) enableInterrupts() {
+	if !r.state.handlerInstalled {
+		C.SetInterruptHandler(r.ref, C.uintptr_t(r.state.handle))
+		r.state.handlerInstalled = true
+	}
+}
+
+// ContextOptions are the settings of a context that override those of its runtime.
+type ContextOptions struct {
+	timezone      *time.Location
+	locale        string
+	maxHostDepth  int
+	maxAsyncCalls int
+	disableEval   bool
+}
+
+// ContextOption configures a context created by NewContext.
+type ContextOption func(*ContextOptions)
+
+// ContextTimezone sets the time zone used by the local time methods of Date in the context, overriding WithTimezone,
+// so that scripts of different tenants can run in their own time zones in one runtime.
+func ContextTimezone(loc *time.Location) ContextOption {
+	return func(o *ContextOptions) {
+		o.timezone = loc
+	}
+}
+
+// ContextTZ sets the time zone of the context from a value of the TZ environment variable: an IANA time zone name such
+// as "Europe/Amsterdam", optionally prefixed with ":". As with TZ, "" and unknown names select UTC.
+func ContextTZ(tz string) ContextOption {
+	return func(o *ContextOptions) {
+		o.timezone = loadTZ(tz)
+	}
+}
+
+// ContextLocale sets the default locale, a BCP 47 language tag, of the locale-sensitive methods of the context,
+// overriding WithLocale.
+func ContextLocale(locale string) ContextOption {
+	return func(o *ContextOptions) {
+		o.locale = locale
+	}
+}
+
+// ContextMaxHostDepth limits the nesting of calls to Go functions through JS (script → Go → script → Go…) in the
+// context to depth calls; a call beyond it throws a RangeError matching ErrHostCallDepth. It bounds the recursion of
+// scripts called back by host functions more tightly than the stack size, which counts every frame.
+func ContextMaxHostDepth(depth int) ContextOption {
+	return func(o *ContextOptions) {
+		o.maxHostDepth = depth
+	}
+}
+
+// ContextMaxAsyncCalls limits the calls to the functions of AsyncFunction pending in the context, from their call
+// until their promise settles, to n; a call beyond it throws a RangeError matching ErrAsyncCallLimit. It bounds the
+// goroutines and requests that async host functions start for scripts calling them without awaiting.
+func ContextMaxAsyncCalls(n int) ContextOption {
+	return func(o *ContextOptions) {
+		o.maxAsyncCalls = n
+	}
+}
+
+// ContextDisableEval disables the evaluation of code from strings by the scripts of the context: eval and the
+// constructors of functions (Function, and those of async and generator functions reached through the constructor
+// property of their prototypes) throw an EvalError. Go code can still evaluate scripts with Eval. The evalScript and
+// loadScript functions of the std module, which scripts can import unless the module policy refuses it (see
+// SetModulePolicy), still evaluate code.
+func ContextDisableEval(disable bool) ContextOption {
+	return func(o *ContextOptions) {
+		o.disableEval = disable
+	}
+}
+
+// inheritedOptions returns the options giving a new context the time zone, locale and eval setting of ctx.
+func (ctx *Context) inheritedOptions() []ContextOption {
+	var opts []ContextOption
+	if ctx.evalDisabled {
+		opts = append(opts, ContextDisableEval(true))
+	}
+	if ctx.timezone != nil {
+		opts = append(opts, ContextTimezone(ctx.timezone))
+	}
+	if ctx.locale != nil {
+		opts = append(opts, ContextLocale(ctx.Locale()))
+	}
+	return opts
 }
 
 // NewContext creates a new JavaScript context.
 // enable BigFloat/BigDecimal support and enable .
 // enable operator overloading.
-func (r Runtime) NewContext() *Context {
-	C.js_std_init_handlers(r.ref)
+// If the time zone, locale, limits or disabled eval cannot be installed, for example when the runtime is out of
+// memory, the error is left pending in the context; NewContextE returns it instead.
+func (r Runtime) NewContext(opts ...ContextOption) *Context {
+	ctx, err := r.newContext(opts)
+	if err != nil {
+		ctx.ThrowError(err)
+	}
+	return ctx
+}
+
+// NewContextE is like NewContext but returns the error installing the time zone, locale or limits, after closing the
+// context.
+func (r Runtime) NewContextE(opts ...ContextOption) (*Context, error) {
+	ctx, err := r.newContext(opts)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// newContext creates a context, returning it with the first error of its options.
+func (r Runtime) newContext(opts []ContextOption) (*Context, error) {
+	o := ContextOptions{timezone: r.options.timezone, locale: r.options.locale}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	// create a new context (heap, global object and context stack
-	ctx_ref := C.JS_NewContext(r.ref)
+	ctx_ref := newContextWithIntrinsics(r.ref, r.options.intrinsics)
 
-	C.JS_AddIntrinsicBigFloat(ctx_ref)
-	C.JS_AddIntrinsicBigDecimal(ctx_ref)
-	C.JS_AddIntrinsicOperators(ctx_ref)
-	C.JS_EnableBignumExt(ctx_ref, C.int(1))
+	ctx := &Context{ref: ctx_ref, runtime: &r, maxHostDepth: o.maxHostDepth, maxAsync: o.maxAsyncCalls}
+	ctx.handle = cgo.NewHandle(ctx)
+	C.SetContextHandle(ctx_ref, C.uintptr_t(ctx.handle))
 
 	// set the module loader for support dynamic import
-	if r.options.moduleImport {
-		C.JS_SetModuleLoaderFunc(r.ref, (*C.JSModuleNormalizeFunc)(unsafe.Pointer(nil)), (*C.JSModuleLoaderFunc)(C.js_module_loader), unsafe.Pointer(nil))
-	}
+	r.setModuleLoader()
 
 	// import the 'std' and 'os' modules
 	C.js_init_module_std(ctx_ref, C.CString("std"))
 	C.js_init_module_os(ctx_ref, C.CString("os"))
 
-	// import setTimeout and clearTimeout from 'os' to globalThis
+	// import setTimeout and clearTimeout from 'os' to globalThis, bypassing the module policy
+	ctx.initializing = true
 	code := `
 	import { setTimeout, clearTimeout } from "os";
 	globalThis.setTimeout = setTimeout;
@@ -175,7 +497,38 @@ func (r Runtime) NewContext() *Context {
 	init_compile := C.JS_Eval(ctx_ref, C.CString(code), C.size_t(len(code)), C.CString("init.js"), C.JS_EVAL_TYPE_MODULE|C.JS_EVAL_FLAG_COMPILE_ONLY)
 	init_run := C.js_std_await(ctx_ref, C.JS_EvalFunction(ctx_ref, init_compile))
 	C.JS_FreeValue(ctx_ref, init_run)
+	ctx.initializing = false
 	// C.js_std_loop(ctx_ref)
 
-	return &Context{ref: ctx_ref, runtime: &r}
+	if r.state.globalNames == "" {
+		// Snapshot skips the globals of a new context.
+		names, err := ctx.Eval(`JSON.stringify(Object.getOwnPropertyNames(globalThis))`, EvalFlagInternal(true))
+		if err == nil {
+			r.state.globalNames = names.String()
+			names.Free()
+		}
+	}
+
+	if o.timezone != nil {
+		if err := ctx.SetTimezone(o.timezone); err != nil {
+			return ctx, err
+		}
+	}
+	if o.locale != "" {
+		if err := ctx.SetLocale(o.locale); err != nil {
+			return ctx, err
+		}
+	}
+	if r.options.limits != (Limits{}) {
+		if err := ctx.setLimits(r.options.limits); err != nil {
+			return ctx, err
+		}
+	}
+	if o.disableEval {
+		if err := ctx.disableEval(); err != nil {
+			return ctx, err
+		}
+	}
+
+	return ctx, nil
 }
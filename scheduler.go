@@ -0,0 +1,279 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Scheduler runs jobs of a context at the times of their schedules. Jobs run on the goroutine serving the context
+// with Serve (or, in an Executor, while its worker is idle), one at a time; an activation due while the previous
+// one still waits to run is skipped.
+type Scheduler struct {
+	ctx     *Context
+	mu      sync.Mutex
+	loc     *time.Location // time zone of the context, updated by SetTimezone
+	jobs    map[int]chan struct{}
+	lastID  int
+	closed  bool
+	onError func(id int, err error)
+}
+
+// Scheduler returns the scheduler of the context. Its jobs are removed when the context is closed.
+func (ctx *Context) Scheduler() *Scheduler {
+	if ctx.scheduler == nil {
+		ctx.scheduler = &Scheduler{ctx: ctx, loc: ctx.Timezone(), jobs: make(map[int]chan struct{})}
+		ctx.closeHooks = append(ctx.closeHooks, ctx.scheduler.close)
+	}
+	return ctx.scheduler
+}
+
+// OnError sets a function receiving the errors returned by the jobs, including the exceptions thrown by the JS
+// functions scheduled by scripts. It is called on the goroutine serving the context.
+func (s *Scheduler) OnError(fn func(id int, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = fn
+}
+
+// Add schedules job and returns its id. Times are computed in the time zone of the context (see SetTimezone).
+func (s *Scheduler) Add(schedule Schedule, job func(ctx *Context) error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	id := s.lastID
+	if s.closed {
+		return id
+	}
+	stop := make(chan struct{})
+	s.jobs[id] = stop
+	go s.run(id, schedule, job, stop)
+	return id
+}
+
+// AddCron schedules job with a cron expression (see ParseCron) and returns its id.
+func (s *Scheduler) AddCron(expr string, job func(ctx *Context) error) (int, error) {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return 0, err
+	}
+	return s.Add(schedule, job), nil
+}
+
+// Remove unschedules a job. An activation already running completes.
+func (s *Scheduler) Remove(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stop, ok := s.jobs[id]; ok {
+		close(stop)
+		delete(s.jobs, id)
+	}
+}
+
+// scheduled reports whether the job id is scheduled.
+func (s *Scheduler) scheduled(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[id]
+	return ok
+}
+
+// Len returns the number of scheduled jobs.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+func (s *Scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for id, stop := range s.jobs {
+		close(stop)
+		delete(s.jobs, id)
+	}
+}
+
+func (s *Scheduler) setTimezone(loc *time.Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loc = loc
+}
+
+// now returns the current time in the time zone of the context. It is called by the goroutines of the jobs, which
+// must not read the context.
+func (s *Scheduler) now() time.Time {
+	s.mu.Lock()
+	loc := s.loc
+	s.mu.Unlock()
+	return time.Now().In(loc)
+}
+
+func (s *Scheduler) run(id int, schedule Schedule, job func(ctx *Context) error, stop chan struct{}) {
+	defer s.Remove(id)
+	for next := schedule.Next(s.now()); !next.IsZero(); next = schedule.Next(s.now()) {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+		done := s.ctx.DoAsync(func(ctx *Context) error {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if err := job(ctx); err != nil {
+				s.mu.Lock()
+				onError := s.onError
+				s.mu.Unlock()
+				if onError != nil {
+					onError(id, err)
+				}
+			}
+			return nil
+		})
+		select {
+		case err := <-done:
+			if errors.Is(err, ErrContextClosed) {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+const schedulerFactory = `(add, cancel) => {
+	const jobs = new Map();
+	const schedule = (spec, ms, fn) => {
+		if (typeof fn !== "function") throw new TypeError("job must be a function");
+		const id = add(spec, ms);
+		jobs.set(id, fn);
+		return id;
+	};
+	const scheduler = {
+		cron: (expr, fn) => schedule(String(expr), 0, fn),
+		every: (ms, fn) => schedule(undefined, Number(ms), fn),
+		cancel(id) {
+			jobs.delete(id);
+			cancel(id);
+		},
+	};
+	const run = (id) => {
+		const fn = jobs.get(id);
+		if (fn) fn();
+	};
+	return [scheduler, run];
+}`
+
+// SchedulerOption configures the global scheduler object defined by InstallScheduler.
+type SchedulerOption func(*schedulerOptions)
+
+type schedulerOptions struct {
+	maxJobs     int
+	minInterval time.Duration
+}
+
+// SchedulerMaxJobs limits the jobs that the scripts of the context can have scheduled at a time to n; default is 100.
+// Scheduling one more throws a RangeError.
+func SchedulerMaxJobs(n int) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.maxJobs = n
+	}
+}
+
+// SchedulerMinInterval sets the shortest interval between the activations of the jobs of the scripts; default is
+// 10ms. Shorter intervals of scheduler.every and of "@every" cron expressions throw a RangeError.
+func SchedulerMinInterval(d time.Duration) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.minInterval = d
+	}
+}
+
+// InstallScheduler defines the global scheduler object of the context, backed by its Scheduler:
+// scheduler.cron(expr, fn) and scheduler.every(ms, fn) schedule fn and return a job id, and scheduler.cancel(id)
+// unschedules it. Each job has a goroutine of its own, so the jobs of the scripts and their intervals are limited
+// (see SchedulerMaxJobs and SchedulerMinInterval).
+func (ctx *Context) InstallScheduler(opts ...SchedulerOption) error {
+	o := schedulerOptions{maxJobs: 100, minInterval: 10 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := ctx.Scheduler()
+	scheduled := map[int]struct{}{} // ids of the jobs of the scripts
+	var run C.JSValue
+	add := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		var schedule Schedule
+		if args[0].IsUndefined() {
+			ms := args[1].Float64()
+			if !(ms >= float64(o.minInterval)/float64(time.Millisecond)) {
+				return ctx.ThrowRangeError("interval shorter than %v: %v ms", o.minInterval, ms)
+			}
+			if ms > float64(math.MaxInt64/int64(time.Millisecond)) {
+				return ctx.ThrowRangeError("interval too large: %v ms", ms)
+			}
+			schedule = Every(time.Duration(ms * float64(time.Millisecond)))
+		} else {
+			var err error
+			spec := args[0].String()
+			if schedule, err = ParseCron(spec); err != nil {
+				return ctx.ThrowSyntaxError("%s", err)
+			}
+			if first := schedule.Next(time.Now()); !first.IsZero() && schedule.Next(first).Sub(first) < o.minInterval {
+				return ctx.ThrowRangeError("interval shorter than %v: %s", o.minInterval, spec)
+			}
+		}
+		for id := range scheduled {
+			if !s.scheduled(id) {
+				delete(scheduled, id)
+			}
+		}
+		if len(scheduled) >= o.maxJobs {
+			return ctx.ThrowRangeError("scheduled jobs exceed the limit of %d", o.maxJobs)
+		}
+		var id int
+		id = s.Add(schedule, func(ctx *Context) error {
+			arg := C.JS_NewInt64(ctx.ref, C.int64_t(id))
+			ret := C.JS_Call(ctx.ref, run, C.JS_NewNull(), 1, &arg)
+			if C.JS_IsException(ret) == 1 {
+				return ctx.Exception()
+			}
+			C.JS_FreeValue(ctx.ref, ret)
+			return nil
+		})
+		scheduled[id] = struct{}{}
+		return ctx.Int64(int64(id))
+	})
+	defer add.Free()
+	cancel := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		s.Remove(int(args[0].Int64()))
+		return ctx.Undefined()
+	})
+	defer cancel.Free()
+
+	factory, err := ctx.Eval(schedulerFactory, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer factory.Free()
+	pair, err := ctx.InvokeE(factory, ctx.Null(), add, cancel)
+	if err != nil {
+		return err
+	}
+	defer pair.Free()
+	runFn := pair.GetIdx(1)
+	ctx.untrack(runFn)
+	run = runFn.ref
+	ctx.closeHooks = append(ctx.closeHooks, func() { C.JS_FreeValue(ctx.ref, run) })
+	ctx.Globals().Set("scheduler", pair.GetIdx(0))
+	return nil
+}
@@ -0,0 +1,119 @@
+package quickjs
+
+// Scope owns the values created through it and frees them when the function passed to Context.Scope returns.
+// Values consumed by the API (passed to Set, SetIdx or Throw, or returned from a Go function) must be released from
+// the scope with Keep first.
+type Scope struct {
+	ctx    *Context
+	values []Value
+}
+
+// Scope calls fn with a new scope and frees the values of the scope, in reverse creation order, when fn returns or panics.
+func (ctx *Context) Scope(fn func(s *Scope)) {
+	s := &Scope{ctx: ctx}
+	defer s.free()
+	fn(s)
+}
+
+func (s *Scope) free() {
+	for i := len(s.values) - 1; i >= 0; i-- {
+		s.values[i].Free()
+	}
+	s.values = nil
+}
+
+// Context returns the context of the scope.
+func (s *Scope) Context() *Context {
+	return s.ctx
+}
+
+// Add transfers the ownership of v to the scope and returns v.
+func (s *Scope) Add(v Value) Value {
+	s.values = append(s.values, v)
+	return v
+}
+
+// Keep releases v from the scope, so it is no longer freed when the scope ends, and returns v.
+func (s *Scope) Keep(v Value) Value {
+	for i := len(s.values) - 1; i >= 0; i-- {
+		if s.values[i].ref == v.ref {
+			s.values = append(s.values[:i], s.values[i+1:]...)
+			break
+		}
+	}
+	return v
+}
+
+// String returns a string value owned by the scope.
+func (s *Scope) String(v string) Value {
+	return s.Add(s.ctx.String(v))
+}
+
+// Object returns an empty object owned by the scope.
+func (s *Scope) Object() Value {
+	return s.Add(s.ctx.Object())
+}
+
+// Array returns an empty array owned by the scope.
+func (s *Scope) Array() *Array {
+	arr := s.ctx.Array()
+	s.Add(arr.arrayValue)
+	return arr
+}
+
+// ArrayBuffer returns an ArrayBuffer value owned by the scope.
+func (s *Scope) ArrayBuffer(binaryData []byte) Value {
+	return s.Add(s.ctx.ArrayBuffer(binaryData))
+}
+
+// BigInt64 returns a BigInt value owned by the scope.
+func (s *Scope) BigInt64(v int64) Value {
+	return s.Add(s.ctx.BigInt64(v))
+}
+
+// ParseJSON parses the given JSON string and returns an object value owned by the scope.
+func (s *Scope) ParseJSON(v string, opts ...JSONOption) Value {
+	return s.Add(s.ctx.ParseJSON(v, opts...))
+}
+
+// Error returns a new Error value owned by the scope.
+func (s *Scope) Error(err error) Value {
+	return s.Add(s.ctx.Error(err))
+}
+
+// Function returns a js function value owned by the scope.
+func (s *Scope) Function(fn func(ctx *Context, this Value, args []Value) Value) Value {
+	return s.Add(s.ctx.Function(fn))
+}
+
+// Eval evaluates code like Context.Eval; the result is owned by the scope.
+func (s *Scope) Eval(code string, opts ...EvalOption) (Value, error) {
+	v, err := s.ctx.Eval(code, opts...)
+	return s.Add(v), err
+}
+
+// Await waits for v like Context.Await; the result is owned by the scope. v is consumed and must not be owned by the scope.
+func (s *Scope) Await(v Value) (Value, error) {
+	v, err := s.ctx.Await(v)
+	return s.Add(v), err
+}
+
+// Get returns the property name of v; the result is owned by the scope.
+func (s *Scope) Get(v Value, name string) Value {
+	return s.Add(v.Get(name))
+}
+
+// GetIdx returns the element idx of v; the result is owned by the scope.
+func (s *Scope) GetIdx(v Value, idx int64) Value {
+	return s.Add(v.GetIdx(idx))
+}
+
+// Call calls the method fname of v; the result is owned by the scope.
+func (s *Scope) Call(v Value, fname string, args ...Value) Value {
+	return s.Add(v.Call(fname, args...))
+}
+
+// Invoke calls fn with this as the receiver; the result is owned by the scope.
+func (s *Scope) Invoke(fn Value, this Value, args ...Value) Value {
+	return s.Add(s.ctx.Invoke(fn, this, args...))
+}
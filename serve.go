@@ -0,0 +1,162 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrContextClosed is returned by Do when the context is closed before the call could run.
+var ErrContextClosed = errors.New("quickjs: context closed")
+
+type gatewayCall struct {
+	fn   func(*Context) error
+	done chan error
+}
+
+// gateway queues calls from other goroutines until the goroutine owning the runtime serves them, in the order they
+// were queued.
+type gateway struct {
+	once     sync.Once
+	mu       sync.Mutex
+	calls    []gatewayCall
+	ready    chan struct{} // signaled when calls are queued
+	closed   chan struct{}
+	isClosed bool
+}
+
+func (ctx *Context) gatewayChans() (chan struct{}, chan struct{}) {
+	ctx.gateway.once.Do(func() {
+		ctx.gateway.ready = make(chan struct{}, 1)
+		ctx.gateway.closed = make(chan struct{})
+	})
+	return ctx.gateway.ready, ctx.gateway.closed
+}
+
+// next removes the first queued call, if any.
+func (g *gateway) next() (gatewayCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.calls) == 0 {
+		return gatewayCall{}, false
+	}
+	call := g.calls[0]
+	g.calls[0] = gatewayCall{}
+	g.calls = g.calls[1:]
+	return call, true
+}
+
+// Do runs fn on the goroutine serving the context with Serve and waits for its result. It is safe to call from any
+// goroutine except the serving one, where fn must be called directly instead.
+func (ctx *Context) Do(fn func(*Context) error) error {
+	return <-ctx.DoAsync(fn)
+}
+
+// DoSync is an alias of Do, named after DoAsync: it waits for fn to run on the goroutine serving the context.
+func (ctx *Context) DoSync(fn func(*Context) error) error {
+	return ctx.Do(fn)
+}
+
+// DoAsync queues fn to run on the goroutine serving the context and returns a channel receiving its result. The
+// calls queued run in order.
+func (ctx *Context) DoAsync(fn func(*Context) error) <-chan error {
+	done := make(chan error, 1)
+	ready, _ := ctx.gatewayChans()
+	g := &ctx.gateway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isClosed {
+		done <- ErrContextClosed
+		return done
+	}
+	g.calls = append(g.calls, gatewayCall{fn: fn, done: done})
+	select {
+	case ready <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// Serve runs the calls queued by Do and DoAsync, and the promise jobs they schedule, until stop is closed or the
+// context is closed. It must be called from the goroutine that created the runtime. Timers only fire while a call
+// runs Loop or Await.
+func (ctx *Context) Serve(stop <-chan struct{}) {
+	ready, closed := ctx.gatewayChans()
+	for {
+		select {
+		case <-ready:
+			if !ctx.serveCalls(stop) {
+				return
+			}
+		case <-stop:
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+// serveCalls runs the queued calls in order until none is left, returning false if stop is closed first. The calls
+// left are then served by the next call of serveCalls.
+func (ctx *Context) serveCalls(stop <-chan struct{}) bool {
+	for {
+		select {
+		case <-stop:
+			ready, _ := ctx.gatewayChans()
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+			return false
+		default:
+		}
+		call, ok := ctx.gateway.next()
+		if !ok {
+			return true
+		}
+		call.done <- ctx.runCall(call.fn)
+		ctx.runPendingJobs()
+	}
+}
+
+func (ctx *Context) runCall(fn func(*Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("quickjs: panic in Do: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// runPendingJobs executes the pending promise jobs of the runtime, discarding uncaught exceptions.
+func (ctx *Context) runPendingJobs() {
+	defer ctx.runtime.noteJobs()
+	rt := C.JS_GetRuntime(ctx.ref)
+	for C.JS_IsJobPending(rt) == 1 {
+		var jobCtx *C.JSContext
+		if C.JS_ExecutePendingJob(rt, &jobCtx) < 0 {
+			C.JS_FreeValue(jobCtx, C.JS_GetException(jobCtx))
+		}
+	}
+}
+
+// closeGateway makes pending and future calls of Do fail with ErrContextClosed.
+func (ctx *Context) closeGateway() {
+	_, closed := ctx.gatewayChans()
+	g := &ctx.gateway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isClosed {
+		return
+	}
+	g.isClosed = true
+	for _, call := range g.calls {
+		call.done <- ErrContextClosed
+	}
+	g.calls = nil
+	close(closed)
+}
@@ -0,0 +1,71 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// SharedArrayBuffer is memory allocated by Go that can be mapped into several runtimes as a JS SharedArrayBuffer,
+// for example one per worker goroutine, without copying. Scripts synchronize with Atomics: Atomics.notify in one
+// runtime wakes Atomics.wait in another (see Runtime.SetCanBlock).
+//
+// The memory is reference counted: it is freed once Close has been called and every runtime has released its
+// SharedArrayBuffer objects.
+type SharedArrayBuffer struct {
+	mu   sync.Mutex
+	ptr  unsafe.Pointer
+	size int
+}
+
+// NewSharedArrayBuffer allocates size bytes of zeroed shared memory.
+func NewSharedArrayBuffer(size int) (*SharedArrayBuffer, error) {
+	if size < 0 {
+		return nil, errors.New("quickjs: negative SharedArrayBuffer size")
+	}
+	ptr := C.NewSharedBuffer(C.size_t(size))
+	if ptr == nil {
+		return nil, errors.New("quickjs: out of memory")
+	}
+	return &SharedArrayBuffer{ptr: ptr, size: size}, nil
+}
+
+// Len returns the size of the buffer in bytes.
+func (b *SharedArrayBuffer) Len() int {
+	return b.size
+}
+
+// Bytes returns the memory of the buffer, valid until Close. Accesses are not synchronized with the runtimes: use
+// sync/atomic on aligned words, or coordinate with the scripts.
+func (b *SharedArrayBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ptr == nil || b.size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(b.ptr), b.size)
+}
+
+// Close releases the reference held by Go. Runtimes still using the buffer keep it alive.
+func (b *SharedArrayBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ptr != nil {
+		C.ReleaseSharedBuffer(b.ptr)
+		b.ptr = nil
+	}
+}
+
+// SharedArrayBuffer returns a JS SharedArrayBuffer backed by the memory of b. It throws if b is closed.
+func (ctx *Context) SharedArrayBuffer(b *SharedArrayBuffer) Value {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ptr == nil {
+		return ctx.ThrowTypeError("SharedArrayBuffer is closed")
+	}
+	return ctx.track(Value{ctx: ctx, ref: C.NewSharedArrayBuffer(ctx.ref, b.ptr, C.size_t(b.size))})
+}
@@ -0,0 +1,60 @@
+package quickjs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+)
+
+// ErrBytecodeSignature is returned when signed bytecode is missing its signature or fails verification.
+var ErrBytecodeSignature = errors.New("quickjs: invalid bytecode signature")
+
+// signedBytecodeMagic starts signed bytecode and is covered by the signature, so that it cannot be confused with
+// other data signed by the same key.
+var signedBytecodeMagic = []byte("QJSSIG1\x00")
+
+// SignBytecode signs bytecode produced by Compile or CompileModule with an ed25519 private key, returning the signed
+// artifact: a header, the signature, then the bytecode. Since the bytecode is deterministic, signing the same input
+// gives the same artifact.
+func SignBytecode(buf []byte, key ed25519.PrivateKey) []byte {
+	msg := append(append([]byte{}, signedBytecodeMagic...), buf...)
+	signed := make([]byte, 0, len(signedBytecodeMagic)+ed25519.SignatureSize+len(buf))
+	signed = append(signed, signedBytecodeMagic...)
+	signed = append(signed, ed25519.Sign(key, msg)...)
+	return append(signed, buf...)
+}
+
+// VerifyBytecode checks an artifact made by SignBytecode against an ed25519 public key and returns its bytecode, or
+// ErrBytecodeSignature if it is unsigned, truncated, tampered with or signed by another key.
+func VerifyBytecode(signed []byte, pub ed25519.PublicKey) ([]byte, error) {
+	header := len(signedBytecodeMagic) + ed25519.SignatureSize
+	if len(pub) != ed25519.PublicKeySize || len(signed) < header || !bytes.HasPrefix(signed, signedBytecodeMagic) {
+		return nil, ErrBytecodeSignature
+	}
+	sig, buf := signed[len(signedBytecodeMagic):header], signed[header:]
+	msg := append(append([]byte{}, signedBytecodeMagic...), buf...)
+	if !ed25519.Verify(pub, msg, sig) {
+		return nil, ErrBytecodeSignature
+	}
+	return buf, nil
+}
+
+// EvalBytecodeVerified is like EvalBytecode for an artifact made by SignBytecode, which is verified with pub before
+// anything is loaded.
+func (ctx *Context) EvalBytecodeVerified(signed []byte, pub ed25519.PublicKey) (Value, error) {
+	buf, err := VerifyBytecode(signed, pub)
+	if err != nil {
+		return ctx.Null(), err
+	}
+	return ctx.EvalBytecode(buf)
+}
+
+// LoadModuleBytecodeVerified is like LoadModuleBytecode for an artifact made by SignBytecode, which is verified with
+// pub before anything is loaded.
+func (ctx *Context) LoadModuleBytecodeVerified(signed []byte, pub ed25519.PublicKey) (Value, error) {
+	buf, err := VerifyBytecode(signed, pub)
+	if err != nil {
+		return ctx.Null(), err
+	}
+	return ctx.LoadModuleBytecode(buf)
+}
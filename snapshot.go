@@ -0,0 +1,134 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+)
+
+// snapshotMagic prefixes the data written by Snapshot.
+var snapshotMagic = []byte("qjsnap\x01")
+
+const snapshotCollect = `(skip) => {
+	skip = new Set(skip);
+	const state = {};
+	for (const name of Object.getOwnPropertyNames(globalThis)) {
+		if (skip.has(name) || name === "__quickjs_coverage__") continue;
+		const value = globalThis[name];
+		if (typeof value !== "function" && typeof value !== "symbol") state[name] = value;
+	}
+	return state;
+}`
+
+// Snapshot serializes the global variables added to the context (properties of the global object missing from a new
+// context) so that RestoreContext can recreate them, for example after a process restart. Plain objects, arrays, typed
+// arrays, array buffers, dates and primitive values are supported, including shared and circular references.
+// Variables holding or containing other values (functions, symbols, regexps, maps, sets, promises, ...) and top-level let, const
+// and class declarations are not saved.
+func (ctx *Context) Snapshot() ([]byte, error) {
+	data, err := ctx.writeGlobals()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, snapshotMagic...), data...), nil
+}
+
+// Fork creates a new context in the same runtime whose globals are a deep copy of the globals of ctx, with the
+// limitations of Snapshot: functions and other values that cannot be serialized are not copied. The fork has the time
+// zone and locale of ctx.
+func (ctx *Context) Fork() (*Context, error) {
+	data, err := ctx.writeGlobals()
+	if err != nil {
+		return nil, err
+	}
+	fork := ctx.runtime.NewContext(ctx.inheritedOptions()...)
+	if err := fork.restore(data); err != nil {
+		fork.Close()
+		return nil, err
+	}
+	return fork, nil
+}
+
+// writeGlobals serializes the global variables added to the context.
+func (ctx *Context) writeGlobals() ([]byte, error) {
+	skip := ctx.runtime.state.globalNames
+	if skip == "" {
+		skip = "[]"
+	}
+
+	collect, err := ctx.Eval(snapshotCollect, EvalFlagInternal(true))
+	if err != nil {
+		return nil, err
+	}
+	defer collect.Free()
+	skipVal := ctx.ParseJSON(skip)
+	defer skipVal.Free()
+	state := ctx.Invoke(collect, ctx.Null(), skipVal)
+	defer state.Free()
+	if state.IsException() {
+		return nil, ctx.Exception()
+	}
+
+	props, err := state.PropertyNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range props {
+		prop := state.Get(name)
+		_, err := ctx.writeObject(prop)
+		prop.Free()
+		if err != nil {
+			state.Delete(name)
+		}
+	}
+
+	return ctx.writeObject(state)
+}
+
+// writeObject serializes v with object references allowed.
+func (ctx *Context) writeObject(v Value) ([]byte, error) {
+	var size C.size_t
+	ptr := C.JS_WriteObject(ctx.ref, &size, v.ref, C.JS_WRITE_OBJ_REFERENCE)
+	if ptr == nil {
+		return nil, ctx.Exception()
+	}
+	defer C.js_free(ctx.ref, unsafe.Pointer(ptr))
+	return C.GoBytes(unsafe.Pointer(ptr), C.int(size)), nil
+}
+
+// RestoreContext creates a new context with the global variables saved by Snapshot.
+func (r Runtime) RestoreContext(data []byte) (*Context, error) {
+	if !bytes.HasPrefix(data, snapshotMagic) || len(data) == len(snapshotMagic) {
+		return nil, errors.New("quickjs: invalid snapshot")
+	}
+	ctx := r.NewContext()
+	if err := ctx.restore(data[len(snapshotMagic):]); err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	return ctx, nil
+}
+
+func (ctx *Context) restore(data []byte) error {
+	state := Value{ctx: ctx, ref: C.JS_ReadObject(ctx.ref, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.JS_READ_OBJ_REFERENCE)}
+	if state.IsException() {
+		return ctx.Exception()
+	}
+	defer state.Free()
+
+	assign, err := ctx.Eval(`(state) => { Object.assign(globalThis, state); }`, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer assign.Free()
+	ret := ctx.Invoke(assign, ctx.Null(), state)
+	defer ret.Free()
+	if ret.IsException() {
+		return ctx.Exception()
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package quickjs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sourceMap is a decoded version 3 source map.
+type sourceMap struct {
+	sources  []string
+	lines    [][]sourceMapping // indexed by 0-based generated line
+	explicit bool              // registered by SetSourceMap rather than with evaluated code
+}
+
+// sourceMapping maps a generated column to an original position (all 0-based).
+type sourceMapping struct {
+	genCol int
+	source int
+	line   int
+	col    int
+}
+
+var (
+	inlineSourceMapRe = regexp.MustCompile(`(?m)^[ \t]*//[#@] sourceMappingURL=data:application/json;(?:charset=[\w-]+;)?base64,([A-Za-z0-9+/=]+)[ \t]*$`)
+	stackLocationRe   = regexp.MustCompile(`([^\s()]+):(\d+)(?::(\d+))?(\)?)$`)
+)
+
+const base64VLQChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// parseSourceMap decodes a version 3 source map.
+func parseSourceMap(data []byte) (*sourceMap, error) {
+	var raw struct {
+		Version    int      `json:"version"`
+		SourceRoot string   `json:"sourceRoot"`
+		Sources    []string `json:"sources"`
+		Mappings   string   `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid source map: %w", err)
+	}
+	if raw.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version %d", raw.Version)
+	}
+
+	m := &sourceMap{sources: make([]string, len(raw.Sources))}
+	for i, src := range raw.Sources {
+		if raw.SourceRoot != "" {
+			src = path.Join(raw.SourceRoot, src)
+		}
+		m.sources[i] = src
+	}
+
+	var source, line, col int
+	for _, group := range strings.Split(raw.Mappings, ";") {
+		var mappings []sourceMapping
+		genCol := 0
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+			fields, err := decodeVLQ(segment)
+			if err != nil {
+				return nil, err
+			}
+			genCol += fields[0]
+			if len(fields) < 4 {
+				continue
+			}
+			source += fields[1]
+			line += fields[2]
+			col += fields[3]
+			if source < 0 || source >= len(m.sources) {
+				return nil, errors.New("invalid source map: source index out of range")
+			}
+			mappings = append(mappings, sourceMapping{genCol: genCol, source: source, line: line, col: col})
+		}
+		m.lines = append(m.lines, mappings)
+	}
+	return m, nil
+}
+
+// decodeVLQ decodes the base64 VLQ fields of a mapping segment.
+func decodeVLQ(segment string) ([]int, error) {
+	var fields []int
+	value, shift := 0, 0
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(base64VLQChars, segment[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid source map: bad mapping character %q", segment[i])
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 || len(fields) == 0 {
+		return nil, errors.New("invalid source map: truncated mapping")
+	}
+	return fields, nil
+}
+
+// lookup returns the original position of a 1-based generated line and column; col 0 means the column is unknown.
+func (m *sourceMap) lookup(line, col int) (source string, origLine, origCol int, ok bool) {
+	if line < 1 || line > len(m.lines) || len(m.lines[line-1]) == 0 {
+		return "", 0, 0, false
+	}
+	mappings := m.lines[line-1]
+	found := mappings[0]
+	for _, mapping := range mappings {
+		if col > 0 && mapping.genCol <= col-1 {
+			found = mapping
+		}
+	}
+	return m.sources[found.source], found.line + 1, found.col + 1, true
+}
+
+// SetSourceMap registers a source map used to remap the stack traces of code evaluated with the given filename. It
+// stays registered until another map is registered for the filename, by SetSourceMap or with evaluated code.
+func (ctx *Context) SetSourceMap(filename string, data []byte) error {
+	return ctx.setSourceMap(filename, data, true)
+}
+
+func (ctx *Context) setSourceMap(filename string, data []byte, explicit bool) error {
+	m, err := parseSourceMap(data)
+	if err != nil {
+		return err
+	}
+	m.explicit = explicit
+	if ctx.sourceMaps == nil {
+		ctx.sourceMaps = make(map[string]*sourceMap)
+	}
+	ctx.sourceMaps[filename] = m
+	return nil
+}
+
+// registerSourceMap registers the given source map, or the inline source map of code if data is nil, replacing the
+// map of the code evaluated before with the filename. Code evaluated without a filename shares the default one, and
+// its maps are not registered.
+func (ctx *Context) registerSourceMap(filename string, code string, data []byte) error {
+	if filename == "<input>" {
+		return nil
+	}
+	if data == nil {
+		matches := inlineSourceMapRe.FindAllStringSubmatch(code, -1)
+		if len(matches) == 0 {
+			if m, ok := ctx.sourceMaps[filename]; ok && !m.explicit {
+				delete(ctx.sourceMaps, filename)
+			}
+			return nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(matches[len(matches)-1][1])
+		if err != nil {
+			return fmt.Errorf("invalid inline source map: %w", err)
+		}
+		data = decoded
+	}
+	return ctx.setSourceMap(filename, data, false)
+}
+
+// remapStack rewrites the locations of a stack trace using the registered source maps.
+func (ctx *Context) remapStack(stack string) string {
+	if len(ctx.sourceMaps) == 0 {
+		return stack
+	}
+	lines := strings.Split(stack, "\n")
+	for i, line := range lines {
+		loc := stackLocationRe.FindStringSubmatchIndex(line)
+		if loc == nil {
+			continue
+		}
+		m, ok := ctx.sourceMaps[line[loc[2]:loc[3]]]
+		if !ok {
+			continue
+		}
+		genLine, _ := strconv.Atoi(line[loc[4]:loc[5]])
+		genCol := 0
+		if loc[6] >= 0 {
+			genCol, _ = strconv.Atoi(line[loc[6]:loc[7]])
+		}
+		source, origLine, origCol, ok := m.lookup(genLine, genCol)
+		if !ok {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s%s:%d:%d%s", line[:loc[2]], source, origLine, origCol, line[loc[8]:loc[9]])
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,325 @@
+// Package sql defines the "sql" host module of quickjs, running allowlisted, parameterized statements on a database/sql
+// database.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+// maxSafeInteger is Number.MAX_SAFE_INTEGER.
+const maxSafeInteger = 1<<53 - 1
+
+// ErrStatementNotAllowed is thrown by the functions of the sql module for statements not allowed by Statements or
+// Policy.
+var ErrStatementNotAllowed = errors.New("sql: statement not allowed")
+
+// Options configures the sql module defined by Install.
+type Options struct {
+	statements map[string]bool
+	policy     func(query string) error
+	maxRows    int
+	timeout    time.Duration
+}
+
+// Option configures the sql module defined by Install.
+type Option func(*Options)
+
+// Statements allows the given statements. They are compared with the statements of scripts after collapsing runs of
+// white space, so that they may be formatted differently.
+func Statements(statements ...string) Option {
+	return func(o *Options) {
+		for _, s := range statements {
+			o.statements[normalizeStatement(s)] = true
+		}
+	}
+}
+
+// Policy sets a function consulted for the statements not allowed by Statements, returning an error to reject the
+// statement.
+func Policy(policy func(query string) error) Option {
+	return func(o *Options) {
+		o.policy = policy
+	}
+}
+
+// MaxRows limits the number of rows a script reads from one query.
+func MaxRows(n int) Option {
+	return func(o *Options) {
+		o.maxRows = n
+	}
+}
+
+// Timeout cancels a statement, including the reading of its rows, running longer than d.
+func Timeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = d
+	}
+}
+
+func normalizeStatement(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+const sqlPatch = `(open, next, close, exec) => {
+	function* rows(query, ...params) {
+		const r = open(query, params);
+		try {
+			for (let row; (row = next(r)) !== undefined;) yield row;
+		} finally {
+			close(r);
+		}
+	}
+	return {
+		rows,
+		query: (query, ...params) => [...rows(query, ...params)],
+		queryRow: (query, ...params) => {
+			for (const row of rows(query, ...params)) return row;
+			return null;
+		},
+		exec: (query, ...params) => exec(query, params),
+	};
+}`
+
+// sqlRows is the state of a query whose rows are read by a script.
+type sqlRows struct {
+	rows    *sql.Rows
+	cancel  context.CancelFunc
+	columns []*sql.ColumnType
+	read    int
+}
+
+func (r *sqlRows) close() {
+	r.rows.Close()
+	r.cancel()
+}
+
+// Install defines the "sql" module of ctx, running parameterized statements on db:
+//
+//	import { query, queryRow, rows, exec } from "sql";
+//	const rates = query("SELECT currency, rate FROM rates WHERE day = ?", day);
+//
+// query returns the rows as an array of objects keyed by column names, queryRow the first row or null, and rows an
+// iterator reading the rows as the script consumes them. exec returns an object with rowsAffected and lastInsertId,
+// null when the driver does not support them. Statements are rejected unless allowed by Statements or Policy.
+//
+// Parameters are converted from numbers, strings, booleans, null, dates, BigInts and byte arrays. Column values are
+// converted to numbers, or BigInts beyond the safe integers, strings, booleans, null and dates; bytes are converted to
+// strings, or to ArrayBuffers for binary columns.
+func Install(ctx *quickjs.Context, db *sql.DB, opts ...Option) error {
+	o := Options{statements: map[string]bool{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	check := func(query string) error {
+		if o.statements[normalizeStatement(query)] {
+			return nil
+		}
+		if o.policy == nil {
+			return fmt.Errorf("%w: %s", ErrStatementNotAllowed, query)
+		}
+		if err := o.policy(query); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrStatementNotAllowed, query, err)
+		}
+		return nil
+	}
+	statementContext := func() (context.Context, context.CancelFunc) {
+		if o.timeout > 0 {
+			return context.WithTimeout(context.Background(), o.timeout)
+		}
+		return context.WithCancel(context.Background())
+	}
+
+	open := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		query := args[0].String()
+		if err := check(query); err != nil {
+			return ctx.ThrowError(err)
+		}
+		params, err := params(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		stmtCtx, cancel := statementContext()
+		rows, err := db.QueryContext(stmtCtx, query, params...)
+		if err != nil {
+			cancel()
+			return ctx.ThrowError(err)
+		}
+		columns, err := rows.ColumnTypes()
+		if err != nil {
+			rows.Close()
+			cancel()
+			return ctx.ThrowError(err)
+		}
+		return ctx.GoObject(&sqlRows{rows: rows, cancel: cancel, columns: columns}, func(data interface{}) {
+			data.(*sqlRows).close()
+		})
+	})
+	defer open.Free()
+	next := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, _ := args[0].GoData()
+		r := data.(*sqlRows)
+		if !r.rows.Next() {
+			err := r.rows.Err()
+			r.close()
+			if err != nil {
+				return ctx.ThrowError(err)
+			}
+			return ctx.Undefined()
+		}
+		if r.read++; o.maxRows > 0 && r.read > o.maxRows {
+			r.close()
+			return ctx.ThrowRangeError("query returned more than %d rows", o.maxRows)
+		}
+		values, dest := make([]interface{}, len(r.columns)), make([]interface{}, len(r.columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := r.rows.Scan(dest...); err != nil {
+			return ctx.ThrowError(err)
+		}
+		row := ctx.Object()
+		for i, column := range r.columns {
+			v, err := value(ctx, column, values[i])
+			if err != nil {
+				row.Free()
+				return ctx.ThrowError(err)
+			}
+			row.Set(column.Name(), v)
+		}
+		return row
+	})
+	defer next.Free()
+	closeRows := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		data, _ := args[0].GoData()
+		data.(*sqlRows).close()
+		return ctx.Undefined()
+	})
+	defer closeRows.Free()
+	exec := ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		query := args[0].String()
+		if err := check(query); err != nil {
+			return ctx.ThrowError(err)
+		}
+		params, err := params(args[1])
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		stmtCtx, cancel := statementContext()
+		defer cancel()
+		result, err := db.ExecContext(stmtCtx, query, params...)
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		ret := ctx.Object()
+		set := func(name string, n int64, err error) {
+			if err != nil {
+				ret.Set(name, ctx.Null())
+			} else {
+				ret.Set(name, ctx.Int64(n))
+			}
+		}
+		n, err := result.RowsAffected()
+		set("rowsAffected", n, err)
+		n, err = result.LastInsertId()
+		set("lastInsertId", n, err)
+		return ret
+	})
+	defer exec.Free()
+
+	patch, err := ctx.Eval(sqlPatch, quickjs.EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	exports, err := ctx.InvokeE(patch, ctx.Null(), open, next, closeRows, exec)
+	if err != nil {
+		return err
+	}
+	return ctx.LoadHostModule("sql", exports, "query", "queryRow", "rows", "exec")
+}
+
+// params converts the array of parameters of a statement.
+func params(arr quickjs.Value) ([]interface{}, error) {
+	params := make([]interface{}, arr.Len())
+	for i := range params {
+		v := arr.GetIdx(int64(i))
+		p, err := param(v)
+		v.Free()
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+		params[i] = p
+	}
+	return params, nil
+}
+
+func param(v quickjs.Value) (interface{}, error) {
+	switch v.Kind() {
+	case quickjs.KindUndefined, quickjs.KindNull:
+		return nil, nil
+	case quickjs.KindBool:
+		return v.Bool(), nil
+	case quickjs.KindNumber:
+		if f := v.Float64(); f == math.Trunc(f) && math.Abs(f) <= maxSafeInteger {
+			return int64(f), nil
+		}
+		return v.Float64(), nil
+	case quickjs.KindBigInt:
+		if n := v.BigInt(); n.IsInt64() {
+			return n.Int64(), nil
+		}
+		return nil, errors.New("BigInt out of the range of 64-bit integers")
+	case quickjs.KindString:
+		b, err := v.StringLenBytes()
+		return string(b), err
+	case quickjs.KindDate:
+		ms := v.Call("getTime")
+		defer ms.Free()
+		return time.UnixMilli(ms.Int64()), nil
+	case quickjs.KindArrayBuffer, quickjs.KindTypedArray:
+		return v.Context().DataBytes(v)
+	}
+	return nil, fmt.Errorf("unsupported %s value", v.Kind())
+}
+
+// value converts a column value scanned by database/sql.
+func value(ctx *quickjs.Context, column *sql.ColumnType, v interface{}) (quickjs.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return ctx.Null(), nil
+	case int64:
+		if v < -maxSafeInteger || v > maxSafeInteger {
+			return ctx.BigInt64(v), nil
+		}
+		return ctx.Int64(v), nil
+	case float64:
+		return ctx.Float64(v), nil
+	case bool:
+		return ctx.Bool(v), nil
+	case string:
+		return ctx.String(v), nil
+	case []byte:
+		typ := strings.ToUpper(column.DatabaseTypeName())
+		if strings.Contains(typ, "BLOB") || strings.Contains(typ, "BINARY") || typ == "BYTEA" {
+			return ctx.ArrayBuffer(v), nil
+		}
+		return ctx.String(string(v)), nil
+	case time.Time:
+		ctor := ctx.Globals().Get("Date")
+		defer ctor.Free()
+		date := ctor.New(ctx.Float64(float64(v.UnixMilli())))
+		if date.IsException() {
+			return quickjs.Value{}, ctx.Exception()
+		}
+		return date, nil
+	}
+	return ctx.String(fmt.Sprint(v)), nil
+}
@@ -0,0 +1,151 @@
+package sql_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	quickjssql "github.com/buke/quickjs-go/sql"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB is a database/sql driver serving a table of products, for the tests of the sql module.
+type fakeDB struct{ updates [][]driver.Value }
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no transactions") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.updates = append(s.db.updates, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	products := [][]driver.Value{
+		{int64(1), "pen", 1.5, []byte{1, 2}, created, nil},
+		{int64(2), "book", 12.0, []byte{}, created, true},
+		{int64(1) << 60, "car", 15000.25, nil, created, false},
+	}
+	min, ok := args[0].(float64)
+	if n, isInt := args[0].(int64); isInt {
+		min, ok = float64(n), true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected parameter %v", args[0])
+	}
+	var rows [][]driver.Value
+	for _, p := range products {
+		if p[2].(float64) > min {
+			rows = append(rows, p)
+		}
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "name", "price", "data", "created", "discontinued"}
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	return []string{"INTEGER", "TEXT", "REAL", "BLOB", "DATETIME", "BOOLEAN"}[i]
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestInstall(t *testing.T) {
+	fake := &fakeDB{}
+	sql.Register("quickjs-fake", fake)
+	db, err := sql.Open("quickjs-fake", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, quickjssql.Install(ctx, db,
+		quickjssql.Statements("SELECT * FROM products WHERE price > ?", "UPDATE products SET price = ? WHERE id = ?"),
+		quickjssql.Policy(func(query string) error {
+			if !strings.HasPrefix(query, "SELECT ") {
+				return errors.New("read only")
+			}
+			return nil
+		}),
+		quickjssql.MaxRows(2),
+	))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { query, queryRow, rows, exec } from "sql";
+		const products = query("SELECT *\n  FROM products WHERE price > ?", 10);
+		const names = [];
+		for (const p of rows("SELECT * FROM products WHERE price > ?", 0)) {
+			names.push(p.name);
+			if (p.id === 2) break;
+		}
+		globalThis.result = {
+			products: products.map((p) => ({ ...p, data: p.data && p.data.byteLength, created: p.created.toISOString() })),
+			bigId: typeof products[1].id,
+			names,
+			none: queryRow("SELECT name FROM products WHERE price > ?", 1e6),
+			first: queryRow("SELECT name FROM products WHERE price > ?", 0.5).name,
+			updated: exec("UPDATE products SET price = ? WHERE id = ?", 2.5, 1),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result, (k, v) => typeof v === "bigint" ? String(v) : v)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"products": [
+			{"id": 2, "name": "book", "price": 12, "data": 0, "created": "2024-01-02T03:04:05.000Z", "discontinued": true},
+			{"id": "1152921504606846976", "name": "car", "price": 15000.25, "data": null, "created": "2024-01-02T03:04:05.000Z", "discontinued": false}
+		],
+		"bigId": "bigint",
+		"names": ["pen", "book"],
+		"none": null,
+		"first": "pen",
+		"updated": {"rowsAffected": 1, "lastInsertId": null}
+	}`, ret.String())
+	ret.Free()
+	require.Equal(t, [][]driver.Value{{2.5, int64(1)}}, fake.updates)
+
+	_, err = ctx.Eval(`import("sql").then(({ exec }) => exec("DELETE FROM products"))`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "sql: statement not allowed: DELETE FROM products: read only")
+	_, err = ctx.Eval(`import("sql").then(({ query }) => query("SELECT * FROM products WHERE price > ?", 0))`, quickjs.EvalAwait(true))
+	require.EqualError(t, err, "RangeError: query returned more than 2 rows")
+	_, err = ctx.Eval(`import("sql").then(({ query }) => query("SELECT * FROM products WHERE price > ?", {}))`, quickjs.EvalAwait(true))
+	require.ErrorContains(t, err, "parameter 1: unsupported Object value")
+}
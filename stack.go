@@ -0,0 +1,45 @@
+package quickjs
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is a frame of the stack trace of an error, from the innermost call.
+type StackFrame struct {
+	Func string // name of the function, <anonymous> or <eval>; empty for the location of a syntax error
+	File string // name of the file, or "native" for the built-in functions; empty if unknown
+	Line int    // 1-based line number, 0 if unknown
+	Col  int    // 1-based column number, 0 if unknown; the engine only gives lines, source maps give columns too
+}
+
+var (
+	stackFrameRe    = regexp.MustCompile(`^\s*at (.+?)(?: \((.*)\))?$`)
+	frameLocationRe = regexp.MustCompile(`^(.*?):(\d+)(?::(\d+))?$`)
+)
+
+// parseStack returns the frames of a stack trace in the format of the engine, remapped or not.
+func parseStack(stack string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range strings.Split(stack, "\n") {
+		m := stackFrameRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		frame := StackFrame{Func: m[1]}
+		if m[2] == "" && frameLocationRe.MatchString(m[1]) {
+			// The frame of a syntax error is only a location.
+			frame.Func, m[2] = "", m[1]
+		}
+		if loc := frameLocationRe.FindStringSubmatch(m[2]); loc != nil {
+			frame.File = loc[1]
+			frame.Line, _ = strconv.Atoi(loc[2])
+			frame.Col, _ = strconv.Atoi(loc[3])
+		} else {
+			frame.File = m[2]
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,101 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"expvar"
+	"time"
+)
+
+// RuntimeStats is a snapshot of the health metrics of a runtime.
+type RuntimeStats struct {
+	// Evals is the number of evaluations started (Eval, EvalFile, EvalBytecode, LoadModule, LoadModuleBytecode).
+	Evals uint64
+	// HasPendingJobs reports whether promise jobs were waiting to be executed when the last evaluation ended or the
+	// jobs last ran on the goroutine of the runtime. It is not a count: the engine does not expose one.
+	HasPendingJobs bool
+	// GCSentinelCollections is the number of times a sentinel object, re-armed when an evaluation starts, was freed
+	// by a garbage collection cycle. It undercounts the cycles: several cycles between two evaluations count once,
+	// and the cycles while the sentinel is not armed are missed.
+	GCSentinelCollections uint64
+	// MemoryUsed is the number of bytes currently allocated by the runtime.
+	MemoryUsed uint64
+	// PeakMemory is the maximum number of bytes allocated by the runtime at any time.
+	PeakMemory uint64
+	// Interrupts is the number of executions interrupted by the execute timeout or the interrupt handler.
+	Interrupts uint64
+}
+
+// Stats returns a snapshot of the runtime's metrics. It may be called from any goroutine, also after Close.
+func (r Runtime) Stats() RuntimeStats {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if r.state.stats == nil {
+		return r.state.final
+	}
+	return r.stats()
+}
+
+func (r Runtime) stats() RuntimeStats {
+	var stats C.RuntimeStats
+	C.LoadRuntimeStats(r.state.stats, &stats)
+	return RuntimeStats{
+		Evals:                 r.state.evals.Load(),
+		HasPendingJobs:        r.state.jobsPending.Load(),
+		GCSentinelCollections: uint64(stats.gc_runs),
+		MemoryUsed:            uint64(stats.memory_used),
+		PeakMemory:            uint64(stats.peak_memory),
+		Interrupts:            r.state.interrupts.Load(),
+	}
+}
+
+// noteJobs records whether jobs are pending, for Stats to read from any goroutine. It runs on the goroutine of the
+// runtime, which the engine requires.
+func (r Runtime) noteJobs() {
+	r.state.jobsPending.Store(C.JS_IsJobPending(r.ref) != 0)
+}
+
+// Expvar returns an expvar.Var reporting the runtime's metrics as JSON, to be published with expvar.Publish.
+func (r Runtime) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} { return r.Stats() })
+}
+
+// EvalStats describes the cost of an evaluation.
+type EvalStats struct {
+	// Duration is the wall time of the evaluation.
+	Duration time.Duration
+	// PeakMemoryDelta is the highest number of bytes allocated by the runtime during the evaluation, minus the number
+	// allocated when it started.
+	PeakMemoryDelta uint64
+	// GCSentinelCollections is the number of times a sentinel object, re-armed at each interrupt check, was freed by
+	// a garbage collection cycle during the evaluation. Several cycles between two checks count once, so it
+	// undercounts the cycles.
+	GCSentinelCollections uint64
+	// InterruptChecks is the number of times the engine checked for interrupts, about once every 10000 operations
+	// (function calls and loop iterations): a measure of the work done that does not depend on the machine.
+	InterruptChecks uint64
+}
+
+// EvalStats is like Eval but also returns the cost of the evaluation, so that it can be attached to the result.
+func (ctx *Context) EvalStats(code string, opts ...EvalOption) (Value, EvalStats, error) {
+	r := ctx.runtime
+	r.enableInterrupts()
+	var before C.RuntimeStats
+	C.LoadRuntimeStats(r.state.stats, &before)
+	checks := r.state.interruptChecks
+	outerPeak := C.ResetEvalPeak(r.state.stats)
+	r.state.evalStats = append(r.state.evalStats, ctx)
+	start := time.Now()
+
+	v, err := ctx.Eval(code, opts...)
+
+	stats := EvalStats{Duration: time.Since(start), InterruptChecks: r.state.interruptChecks - checks}
+	r.state.evalStats = r.state.evalStats[:len(r.state.evalStats)-1]
+	stats.PeakMemoryDelta = uint64(C.RestoreEvalPeak(r.state.stats, outerPeak) - before.memory_used)
+	var after C.RuntimeStats
+	C.LoadRuntimeStats(r.state.stats, &after)
+	stats.GCSentinelCollections = uint64(after.gc_runs - before.gc_runs)
+	return v, stats, err
+}
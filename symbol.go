@@ -0,0 +1,29 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+// symbolAtom returns the atom of a symbol private to the package, used to attach hidden properties to objects.
+func (ctx *Context) symbolAtom(description string) (C.JSAtom, error) {
+	if atom, ok := ctx.symbols[description]; ok {
+		return atom, nil
+	}
+	fn, err := ctx.Eval(`(description) => Symbol(description)`, EvalFlagInternal(true))
+	if err != nil {
+		return 0, err
+	}
+	defer fn.Free()
+	desc := ctx.String(description)
+	defer desc.Free()
+	sym := ctx.Invoke(fn, ctx.Null(), desc)
+	defer sym.Free()
+
+	atom := C.JS_ValueToAtom(ctx.ref, sym.ref)
+	if ctx.symbols == nil {
+		ctx.symbols = make(map[string]C.JSAtom)
+	}
+	ctx.symbols[description] = atom
+	return atom, nil
+}
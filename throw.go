@@ -0,0 +1,281 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"sort"
+	"strings"
+
+	"github.com/buke/quickjs-go/internal/jslex"
+)
+
+// throwHookGlobal is the global function returning the object whose value setter reports the values thrown by
+// instrumented scripts. Calling it records the line of the throw statement in the stack.
+const throwHookGlobal = "__quickjs_throw__"
+
+const throwHookPatch = `(onThrow) => {
+	const hook = Object.create(null);
+	Object.defineProperty(hook, "value", {
+		set(v) {
+			try {
+				onThrow(v, new Error().stack);
+			} catch {}
+		},
+	});
+	Object.defineProperty(globalThis, "` + throwHookGlobal + `", {
+		value: () => hook,
+		writable: false,
+		enumerable: false,
+		configurable: false,
+	});
+}`
+
+// SetOnThrow sets a function called when scripts throw, before the exception is caught, so that platforms can
+// record first-chance exceptions with the state of the script, even when the script swallows them. It receives the
+// value thrown, which is only valid during the call, and the frames of the stack at the throw, from the innermost.
+//
+// The engine has no hook for exceptions, so only two kinds of exceptions are reported when they are thrown: those of
+// the throw statements of the scripts and modules evaluated from source while the function is set, which are
+// instrumented, and those thrown by Go functions, reported when they return. The errors raised by the engine itself,
+// such as the TypeError of reading a property of undefined or a RangeError of a built-in method, and the exceptions
+// of code evaluated from bytecode or before the function was set, are not first-chance: they are only reported when
+// they reach Eval uncaught, and not at all when the script catches them. Exceptions thrown while the function runs
+// are not reported either.
+//
+// An instrumented statement throw x becomes throw __quickjs_throw__().value = (x): the global function is
+// non-writable, non-configurable and non-enumerable, but scripts can still see and call it, and the source of the
+// instrumented functions, as returned by Function.prototype.toString, shows the rewritten throw statements. A nil
+// function disables the reporting.
+func (r Runtime) SetOnThrow(fn func(exc Value, frames []StackFrame)) {
+	r.state.onThrow = fn
+}
+
+// reportThrow calls the OnThrow function with exc and the frames of stack after the first skip ones.
+func (ctx *Context) reportThrow(exc Value, stack string, skip int) {
+	state := ctx.runtime.state
+	if state.onThrow == nil || state.inOnThrow {
+		return
+	}
+	frames := parseStack(ctx.remapStack(stack))
+	if len(frames) > skip {
+		frames = frames[skip:]
+	} else {
+		frames = nil
+	}
+	if ctx.lastThrow != nil {
+		ctx.lastThrow.Free()
+	}
+	ctx.lastThrow = &Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, exc.ref)}
+	state.inOnThrow = true
+	defer func() { state.inOnThrow = false }()
+	state.onThrow(exc, frames)
+}
+
+// reported reports whether exc is the last value reported to the OnThrow function, so that an exception propagating
+// through Go is not reported twice.
+func (ctx *Context) reported(exc C.JSValue) bool {
+	return ctx.lastThrow != nil && C.JS_SameValue(ctx.ref, exc, ctx.lastThrow.ref) != 0
+}
+
+// releaseThrow frees the last value reported to the OnThrow function.
+func (ctx *Context) releaseThrow() {
+	if ctx.lastThrow != nil {
+		ctx.lastThrow.Free()
+		ctx.lastThrow = nil
+	}
+}
+
+// reportUncaught reports the pending exception reaching Eval to the OnThrow function, unless it was reported when it
+// was thrown, with the frames of its stack; the exceptions raised by the engine are only reported there.
+func (ctx *Context) reportUncaught() {
+	defer ctx.releaseThrow()
+	if ctx.runtime.state.onThrow == nil || ctx.runtime.state.inOnThrow {
+		return
+	}
+	exc := C.JS_GetException(ctx.ref)
+	defer C.JS_Throw(ctx.ref, exc)
+	if ctx.reported(exc) {
+		return
+	}
+	stack := ""
+	if C.JS_IsError(ctx.ref, exc) != 0 {
+		s := Value{ctx: ctx, ref: exc}.Get("stack")
+		stack = s.String()
+		s.Free()
+	}
+	ctx.reportThrow(Value{ctx: ctx, ref: exc}, stack, 0)
+}
+
+// reportPendingThrow reports the pending exception, thrown by a Go function or by the proxy calling it, to the
+// OnThrow function, unless it was reported when a script called by the Go function threw it.
+func (ctx *Context) reportPendingThrow() {
+	if ctx.runtime.state.onThrow == nil || ctx.runtime.state.inOnThrow {
+		return
+	}
+	exc := C.JS_GetException(ctx.ref)
+	defer C.JS_Throw(ctx.ref, exc)
+	if ctx.reported(exc) {
+		return
+	}
+	ctor := ctx.Globals().Get("Error")
+	defer ctor.Free()
+	err := Value{ctx: ctx, ref: C.JS_CallConstructor(ctx.ref, ctor.ref, 0, nil)}
+	if err.IsException() {
+		C.JS_FreeValue(ctx.ref, C.JS_GetException(ctx.ref))
+		return
+	}
+	defer err.Free()
+	stack := err.Get("stack")
+	defer stack.Free()
+	// The first frames are the proxy of Go functions, Function.prototype.call and the wrapper of the Go function.
+	ctx.reportThrow(Value{ctx: ctx, ref: exc}, stack.String(), 3)
+}
+
+// isThrowStatement reports whether the token i is the keyword of a throw statement, rather than a property name.
+func isThrowStatement(toks []jslex.Token, i int) bool {
+	if toks[i].Kind != jslex.Identifier || toks[i].Text != "throw" || i+1 >= len(toks) {
+		return false
+	}
+	if i > 0 && (toks[i-1].Is(".") || toks[i-1].Is("?.")) {
+		return false
+	}
+	next := toks[i+1]
+	if next.Kind == jslex.EOF || next.NewlineBefore {
+		return false
+	}
+	for _, p := range []string{":", "=", ";", ",", "}", ")"} {
+		if next.Is(p) {
+			return false
+		}
+	}
+	if next.Is("(") {
+		// A method named throw has a body after its parameters.
+		depth := 0
+		for j := i + 1; j < len(toks); j++ {
+			switch {
+			case toks[j].Is("("):
+				depth++
+			case toks[j].Is(")"):
+				depth--
+			}
+			if depth == 0 {
+				return j+1 >= len(toks) || !toks[j+1].Is("{") || toks[j+1].NewlineBefore
+			}
+		}
+	}
+	return true
+}
+
+// continuesExpression reports whether the token tok, on a new line after the token prev, continues the expression of
+// prev rather than starting a new statement.
+func continuesExpression(prev, tok jslex.Token) bool {
+	switch {
+	case tok.Kind == jslex.Template || tok.Kind == jslex.TemplateHead:
+		return true
+	case tok.Is("++") || tok.Is("--"):
+		return false
+	case tok.Kind == jslex.Punctuator && !tok.Is("{") && !tok.Is("}") && !tok.Is(";") && !tok.Is("!") && !tok.Is("~"):
+		return true
+	case tok.Is("in") || tok.Is("instanceof"):
+		return true
+	case prev.Kind == jslex.Punctuator:
+		return !prev.Is(")") && !prev.Is("]") && !prev.Is("}") && !prev.Is("++") && !prev.Is("--")
+	case prev.Kind == jslex.Identifier:
+		switch prev.Text {
+		case "in", "instanceof", "typeof", "void", "delete", "new", "await", "yield":
+			return true
+		}
+	}
+	return false
+}
+
+// throwExpressionEnd returns the index of the last token of the expression of the throw statement at the token i.
+func throwExpressionEnd(toks []jslex.Token, i int) int {
+	depth := 0
+	for j := i + 1; j < len(toks); j++ {
+		tok := toks[j]
+		if depth == 0 && j > i+1 {
+			if tok.Kind == jslex.EOF || tok.Is(";") || (tok.NewlineBefore && !continuesExpression(toks[j-1], tok)) {
+				return j - 1
+			}
+		}
+		switch {
+		case tok.Kind == jslex.EOF:
+			return j - 1
+		case tok.Is("(") || tok.Is("[") || tok.Is("{") || tok.Kind == jslex.TemplateHead:
+			depth++
+		case tok.Is(")") || tok.Is("]") || tok.Is("}") || tok.Kind == jslex.TemplateTail:
+			if depth == 0 {
+				return j - 1
+			}
+			depth--
+		}
+	}
+	return len(toks) - 1
+}
+
+// instrumentThrows returns code with its throw statements reporting the values thrown to the OnThrow function, or
+// code unchanged if there is no OnThrow function or no throw statement. A statement throw x becomes
+// throw __quickjs_throw__().value = (x), which throws x as well, on the same line.
+func (ctx *Context) instrumentThrows(code string) string {
+	if ctx.runtime.state.onThrow == nil || !strings.Contains(code, "throw") {
+		return code
+	}
+	toks, err := jslex.Tokenize(code)
+	if err != nil {
+		return code
+	}
+	inserts := map[int]string{}
+	for i, tok := range toks {
+		if !isThrowStatement(toks, i) {
+			continue
+		}
+		last := toks[throwExpressionEnd(toks, i)]
+		inserts[tok.Offset+len(tok.Text)] += " " + throwHookGlobal + "().value = ("
+		inserts[last.Offset+len(last.Text)] += ")"
+	}
+	if len(inserts) == 0 || ctx.installThrowHook() != nil {
+		return code
+	}
+	offsets := make([]int, 0, len(inserts))
+	for off := range inserts {
+		offsets = append(offsets, off)
+	}
+	sort.Ints(offsets)
+	var sb strings.Builder
+	last := 0
+	for _, off := range offsets {
+		sb.WriteString(code[last:off])
+		sb.WriteString(inserts[off])
+		last = off
+	}
+	sb.WriteString(code[last:])
+	return sb.String()
+}
+
+// installThrowHook defines the global function of the instrumented throw statements in the context.
+func (ctx *Context) installThrowHook() error {
+	if ctx.throwHook {
+		return nil
+	}
+	onThrow := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		// The first frame is the setter of the hook object.
+		ctx.reportThrow(args[0], args[1].String(), 1)
+		return ctx.Undefined()
+	})
+	defer onThrow.Free()
+	patch, err := ctx.Eval(throwHookPatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret, err := ctx.InvokeE(patch, ctx.Null(), onThrow)
+	if err != nil {
+		return err
+	}
+	ret.Free()
+	ctx.throwHook = true
+	return nil
+}
@@ -0,0 +1,179 @@
+package quickjs
+
+import (
+	"strings"
+	"time"
+)
+
+// The engine computes local time with the time zone of the process, so a different time zone is applied by
+// replacing the local time methods of Date with versions converting through Go's time package.
+const timezonePatch = `(offsetAt, toUTC) => {
+	if (typeof Date !== "function") return;
+	const D = Date, P = D.prototype, getTime = P.getTime, setTime = P.setTime;
+	const processGetters = ["getFullYear", "getMonth", "getDate", "getHours", "getMinutes", "getSeconds", "getMilliseconds"].map((name) => P[name]);
+	const define = (obj, name, fn) => Object.defineProperty(obj, name, { value: fn, writable: true, configurable: true });
+	const local = (d) => {
+		const t = getTime.call(d);
+		return t !== t ? NaN : t + offsetAt(t);
+	};
+	const pad = (n, len = 2) => String(n).padStart(len, "0");
+	const year = (y) => y < 0 ? "-" + pad(-y, 6) : pad(y, 4);
+	const days = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"];
+	const months = ["Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"];
+	const parts = (d) => {
+		const l = local(d);
+		if (l !== l) return null;
+		const u = new D(l);
+		return {
+			y: u.getUTCFullYear(), mo: u.getUTCMonth(), d: u.getUTCDate(), wd: u.getUTCDay(),
+			h: u.getUTCHours(), mi: u.getUTCMinutes(), s: u.getUTCSeconds(), off: (l - getTime.call(d)) / 60000,
+		};
+	};
+	const dateString = (p) => days[p.wd] + " " + months[p.mo] + " " + pad(p.d) + " " + year(p.y);
+	const timeString = (p) => {
+		const off = Math.abs(p.off);
+		return pad(p.h) + ":" + pad(p.mi) + ":" + pad(p.s) + " GMT" + (p.off < 0 ? "-" : "+") + pad(Math.floor(off / 60)) + pad(off % 60);
+	};
+	const localeDate = (p) => pad(p.mo + 1) + "/" + pad(p.d) + "/" + year(p.y);
+	const localeTime = (p) => pad(p.h % 12 || 12) + ":" + pad(p.mi) + ":" + pad(p.s) + (p.h < 12 ? " AM" : " PM");
+	const formats = {
+		toString: (p) => dateString(p) + " " + timeString(p),
+		toDateString: dateString,
+		toTimeString: timeString,
+		toLocaleString: (p) => localeDate(p) + ", " + localeTime(p),
+		toLocaleDateString: localeDate,
+		toLocaleTimeString: localeTime,
+	};
+	for (const name in formats) {
+		const format = formats[name];
+		define(P, name, function () {
+			const p = parts(this);
+			return p ? format(p) : "Invalid Date";
+		});
+	}
+
+	for (const name of ["FullYear", "Month", "Date", "Day", "Hours", "Minutes", "Seconds", "Milliseconds"]) {
+		const get = P["getUTC" + name];
+		define(P, "get" + name, function () { return get.call(new D(local(this))); });
+		if (name === "Day") continue;
+		const set = P["setUTC" + name];
+		define(P, "set" + name, function (...args) {
+			let l = local(this);
+			if (l !== l && name === "FullYear") l = 0;
+			const u = new D(l);
+			set.apply(u, args);
+			const t = getTime.call(u);
+			return setTime.call(this, t !== t ? NaN : toUTC(t));
+		});
+	}
+	define(P, "getYear", function () { return this.getFullYear() - 1900; });
+	define(P, "getTimezoneOffset", function () {
+		const t = getTime.call(this);
+		return t !== t ? NaN : -offsetAt(t) / 60000;
+	});
+
+	// Date-time strings without an offset are local time; date-only ISO strings are UTC. Without the RegExp
+	// intrinsic they keep being read in the time zone of the process.
+	let parseLocal = null;
+	if (typeof RegExp === "function") {
+		const isoLocal = new RegExp("^([+-]\\d{6}|\\d{4})-(\\d{2})-(\\d{2})T(\\d{2}):(\\d{2})(?::(\\d{2})(?:\\.(\\d{1,9}))?)?$");
+		const hasZone = new RegExp("(Z|GMT|UTC|[+-]\\d{2}:?\\d{2})(\\s*\\(.*\\))?\\s*$", "i");
+		const isoDate = new RegExp("^([+-]\\d{6}|\\d{4})(-\\d{2}(-\\d{2})?)?$");
+		const parse = D.parse;
+		parseLocal = (s) => {
+			s = String(s).trim();
+			const m = isoLocal.exec(s);
+			if (m) {
+				const ms = m[7] ? Number((m[7] + "00").slice(0, 3)) : 0;
+				const l = D.UTC(Number(m[1]), Number(m[2]) - 1, Number(m[3]), Number(m[4]), Number(m[5]), Number(m[6] || 0), ms);
+				return l !== l ? NaN : toUTC(l);
+			}
+			const t = parse(s);
+			if (t !== t || hasZone.test(s) || isoDate.test(s)) return t;
+			// The engine read the string as local time of the process: take back its wall clock time.
+			const d = new D(t);
+			return toUTC(D.UTC(...processGetters.map((get) => get.call(d))));
+		};
+		define(D, "parse", parseLocal);
+	}
+
+	// A function rather than a Proxy, which the context may lack.
+	const LocalDate = function (...args) {
+		if (!new.target) return new D().toString();
+		if (args.length >= 2) {
+			const l = D.UTC(...args);
+			args = [l !== l ? NaN : toUTC(l)];
+		} else if (args.length === 1 && typeof args[0] === "string" && parseLocal) {
+			args = [parseLocal(args[0])];
+		}
+		return Reflect.construct(D, args, new.target === LocalDate ? D : new.target);
+	};
+	Object.defineProperties(LocalDate, Object.getOwnPropertyDescriptors(D));
+	define(P, "constructor", LocalDate);
+	globalThis.Date = LocalDate;
+}`
+
+// Timezone returns the time zone used by the local time methods of Date in the context.
+func (ctx *Context) Timezone() *time.Location {
+	if ctx.timezone == nil {
+		return time.Local
+	}
+	return ctx.timezone
+}
+
+// SetTimezone makes the local time methods of Date in the context use loc, or the time zone of the process if loc is
+// nil. It may be called at any time, for example when a tenant changes its settings.
+func (ctx *Context) SetTimezone(loc *time.Location) error {
+	if loc == nil {
+		loc = time.Local
+	}
+	if ctx.timezone == nil {
+		if err := ctx.patchTimezone(); err != nil {
+			return err
+		}
+	}
+	ctx.timezone = loc
+	if ctx.scheduler != nil {
+		ctx.scheduler.setTimezone(loc)
+	}
+	return nil
+}
+
+// loadTZ returns the time zone named by a value of the TZ environment variable, or UTC.
+func loadTZ(tz string) *time.Location {
+	loc, err := time.LoadLocation(strings.TrimPrefix(tz, ":"))
+	if err != nil || tz == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// patchTimezone replaces the local time methods of Date with versions using the time zone of the context.
+func (ctx *Context) patchTimezone() error {
+	// offsetAt returns the offset of the time zone in milliseconds at the UTC time t.
+	offsetAt := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		_, offset := time.UnixMilli(args[0].Int64()).In(ctx.Timezone()).Zone()
+		return ctx.Int64(int64(offset) * 1000)
+	})
+	defer offsetAt.Free()
+	// toUTC returns the UTC time at which the wall clock of the time zone shows the time whose UTC components are
+	// those of t.
+	toUTC := ctx.Function(func(ctx *Context, this Value, args []Value) Value {
+		wall := time.UnixMilli(args[0].Int64()).UTC()
+		local := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), ctx.Timezone())
+		return ctx.Int64(local.UnixMilli())
+	})
+	defer toUTC.Free()
+
+	patch, err := ctx.Eval(timezonePatch, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer patch.Free()
+	ret := ctx.Invoke(patch, ctx.Null(), offsetAt, toUTC)
+	defer ret.Free()
+	if ret.IsException() {
+		return ctx.Exception()
+	}
+	return nil
+}
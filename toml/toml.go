@@ -0,0 +1,198 @@
+// Package toml defines the "toml" host module of quickjs, parsing and stringifying TOML in Go.
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buke/quickjs-go"
+)
+
+// tomlBuilder creates the values of a TOML document, ordering the keys of tables as they appear in the document.
+type tomlBuilder struct {
+	quickjs.TreeBuilder
+	ctx   *quickjs.Context
+	order map[string]int
+}
+
+func (b *tomlBuilder) value(v interface{}, path string) (quickjs.Value, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rank := func(name string) int {
+			if i, ok := b.order[path+"\x00"+name]; ok {
+				return i
+			}
+			return len(b.order)
+		}
+		sort.SliceStable(names, func(i, j int) bool { return rank(names[i]) < rank(names[j]) })
+		keys := make([]quickjs.Value, 0, len(names))
+		values := make([]quickjs.Value, 0, len(names))
+		for _, name := range names {
+			value, err := b.value(v[name], path+"\x00"+name)
+			if err != nil {
+				freeValues(keys)
+				freeValues(values)
+				return quickjs.Value{}, err
+			}
+			keys = append(keys, b.ctx.String(name))
+			values = append(values, value)
+		}
+		return b.Map(keys, values), nil
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return b.value(items, path)
+	case []interface{}:
+		items := make([]quickjs.Value, 0, len(v))
+		for _, item := range v {
+			value, err := b.value(item, path)
+			if err != nil {
+				freeValues(items)
+				return quickjs.Value{}, err
+			}
+			items = append(items, value)
+		}
+		return b.Array(items), nil
+	case int64:
+		return b.Int(big.NewInt(v))
+	case float64:
+		return b.ctx.Float64(v), nil
+	case bool:
+		return b.ctx.Bool(v), nil
+	case string:
+		return b.ctx.String(v), nil
+	case time.Time:
+		// Local dates and times have no time zone to be dates, and are kept as written.
+		switch v.Location().String() {
+		case "datetime-local":
+			return b.ctx.String(v.Format("2006-01-02T15:04:05.999999999")), nil
+		case "date-local":
+			return b.ctx.String(v.Format("2006-01-02")), nil
+		case "time-local":
+			return b.ctx.String(v.Format("15:04:05.999999999")), nil
+		}
+		return b.Date(float64(v.UnixMilli()))
+	}
+	return quickjs.Value{}, fmt.Errorf("unsupported TOML value %T", v)
+}
+
+// tomlValue returns the value encoded by the TOML encoder for a Go value tree.
+func tomlValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case *big.Int:
+		if !v.IsInt64() {
+			return nil, fmt.Errorf("%s is out of the range of TOML integers", v)
+		}
+		return v.Int64(), nil
+	case []byte:
+		return nil, errors.New("TOML has no binary values")
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			if item == nil {
+				return nil, errors.New("TOML has no null values")
+			}
+			var err error
+			if items[i], err = tomlValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case *quickjs.TreeMap:
+		table := make(map[string]interface{}, len(v.Keys))
+		for i, key := range v.Keys {
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.New("TOML keys must be strings")
+			}
+			// Null values are left out, as the encoder does.
+			if v.Values[i] == nil {
+				continue
+			}
+			value, err := tomlValue(v.Values[i])
+			if err != nil {
+				return nil, err
+			}
+			table[name] = value
+		}
+		return table, nil
+	}
+	return v, nil
+}
+
+// Install defines the "toml" module of ctx, parsing and stringifying TOML in Go:
+//
+//	import { parse, stringify } from "toml";
+//	const manifest = parse(text);
+//	manifest.package.version = "1.1.0";
+//	const out = stringify(manifest);
+//
+// parse(text) returns the document as plain objects and arrays, with the keys in the order of the document. Integers
+// beyond the safe range are BigInts, offset date-times are dates, and local date-times, dates and times are strings as
+// written. Invalid TOML throws a SyntaxError.
+//
+// stringify(value) returns the TOML document of an object, with the keys of each table sorted. Null values are left out
+// of tables; they and ArrayBuffers, which TOML can't represent, throw a TypeError in arrays.
+func Install(ctx *quickjs.Context) error {
+	exports := ctx.Object()
+	exports.Set("parse", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		var doc map[string]interface{}
+		md, err := toml.Decode(args.String(0), &doc)
+		if err != nil {
+			return ctx.ThrowSyntaxError("%s", err)
+		}
+		b := &tomlBuilder{TreeBuilder: quickjs.NewTreeBuilder(ctx), ctx: ctx, order: map[string]int{}}
+		for i, key := range md.Keys() {
+			path := "\x00" + strings.Join(key, "\x00")
+			if _, ok := b.order[path]; !ok {
+				b.order[path] = i
+			}
+		}
+		v, err := b.value(doc, "")
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		return v
+	}))
+	exports.Set("stringify", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		tree, err := args.Value(0).GoTree()
+		if err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		if _, ok := tree.(*quickjs.TreeMap); !ok {
+			return ctx.ThrowTypeError("TOML document must be an object")
+		}
+		doc, err := tomlValue(tree)
+		if err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		var buf bytes.Buffer
+		te := toml.NewEncoder(&buf)
+		te.Indent = ""
+		if err := te.Encode(doc); err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		return ctx.String(buf.String())
+	}))
+
+	return ctx.LoadHostModule("toml", exports, "parse", "stringify")
+}
+
+func freeValues(values []quickjs.Value) {
+	for _, v := range values {
+		v.Free()
+	}
+}
@@ -0,0 +1,79 @@
+package toml_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, toml.Install(ctx))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { parse, stringify } from "toml";
+		const manifest = parse([
+			'name = "app"',
+			"version = 3",
+			"big = 9223372036854775807",
+			"pi = 3.14",
+			"released = 2024-01-02T03:04:05Z",
+			"local = 2024-01-02T03:04:05",
+			"day = 2024-01-02",
+			"at = 07:30:00",
+			"[server]",
+			'host = "localhost"',
+			"port = 8080",
+			"[[plugins]]",
+			'name = "b"',
+			"enabled = true",
+			"[[plugins]]",
+			'name = "a"',
+		].join("\n"));
+		globalThis.result = {
+			manifest: { ...manifest, big: typeof manifest.big + ":" + manifest.big, released: manifest.released.toISOString() },
+			keys: Object.keys(manifest),
+			out: stringify({ title: "x", owner: { name: "Ann", dob: new Date(0) }, ports: [80, 443], servers: [{ ip: "10.0.0.1" }, { ip: "10.0.0.2" }], none: null, nested: { deep: { n: 1n } } }),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"manifest": {
+			"name": "app",
+			"version": 3,
+			"big": "bigint:9223372036854775807",
+			"pi": 3.14,
+			"released": "2024-01-02T03:04:05.000Z",
+			"local": "2024-01-02T03:04:05",
+			"day": "2024-01-02",
+			"at": "07:30:00",
+			"server": {"host": "localhost", "port": 8080},
+			"plugins": [{"name": "b", "enabled": true}, {"name": "a"}]
+		},
+		"keys": ["name", "version", "big", "pi", "released", "local", "day", "at", "server", "plugins"],
+		"out": "ports = [80, 443]\ntitle = \"x\"\n\n[nested]\n[nested.deep]\nn = 1\n\n[owner]\ndob = 1970-01-01T00:00:00Z\nname = \"Ann\"\n\n[[servers]]\nip = \"10.0.0.1\"\n\n[[servers]]\nip = \"10.0.0.2\"\n"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`parse("a = 1\na = 2")`:               `SyntaxError: toml: line 2 (last key "a"): Key 'a' has already been defined.`,
+		`stringify([])`:                       "TypeError: TOML document must be an object",
+		`stringify({ a: [null] })`:            "TypeError: TOML has no null values",
+		`stringify({ a: new Uint8Array(1) })`: "TypeError: TOML has no binary values",
+		`stringify({ a: 2n ** 64n })`:         "TypeError: 18446744073709551616 is out of the range of TOML integers",
+	} {
+		_, err := ctx.Eval(`import("toml").then(({ parse, stringify }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}
@@ -0,0 +1,117 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import (
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// EvalInfo describes a finished evaluation of a script, module or bytecode.
+type EvalInfo struct {
+	Filename string
+	Start    time.Time
+	Duration time.Duration
+	// MemoryDelta is the change of the runtime's used memory in bytes during the evaluation.
+	MemoryDelta int64
+	// Err is the error returned by the evaluation, if any.
+	Err error
+}
+
+// HostCallInfo describes a finished call of a Go function from JS.
+type HostCallInfo struct {
+	// Name is the name of the Go function.
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	// Err is the exception thrown by the Go function, if any.
+	Err error
+}
+
+// EvalStartHook is called before an evaluation starts.
+type EvalStartHook func(ctx *Context, filename string)
+
+// EvalEndHook is called after an evaluation ends.
+type EvalEndHook func(ctx *Context, info EvalInfo)
+
+// HostCallHook is called after a Go function bound with Function or AsyncFunction returns.
+type HostCallHook func(ctx *Context, info HostCallInfo)
+
+type traceHooks struct {
+	onEvalStart EvalStartHook
+	onEvalEnd   EvalEndHook
+	onHostCall  HostCallHook
+}
+
+// SetTraceHooks sets the hooks called around evaluations (Eval, EvalFile, EvalBytecode, LoadModule, LoadModuleBytecode)
+// and Go function calls of all contexts of the runtime. Any hook may be nil; pass nil for all of them to remove the hooks.
+// Computing the memory delta walks the JS heap, so it is only done when onEvalEnd is set.
+func (r Runtime) SetTraceHooks(onEvalStart EvalStartHook, onEvalEnd EvalEndHook, onHostCall HostCallHook) {
+	if onEvalStart == nil && onEvalEnd == nil && onHostCall == nil {
+		r.state.trace = nil
+		return
+	}
+	r.state.trace = &traceHooks{onEvalStart: onEvalStart, onEvalEnd: onEvalEnd, onHostCall: onHostCall}
+}
+
+// memoryUsed returns the memory used by the runtime in bytes.
+func (r Runtime) memoryUsed() int64 {
+	var usage C.JSMemoryUsage
+	C.JS_ComputeMemoryUsage(r.ref, &usage)
+	return int64(usage.memory_used_size)
+}
+
+// beginEval records the start of an evaluation and calls the eval start hook. It returns the function to call with
+// the result of the evaluation.
+func (ctx *Context) beginEval(filename string) func(err error) {
+	ctx.runtime.state.evals.Add(1)
+	ctx.runtime.freeCollected()
+	ctx.runtime.runCollected()
+	ctx.runtime.reportGC()
+	C.ArmGCSentinel(ctx.ref, ctx.runtime.state.stats)
+	ctx.runtime.state.allocFailures = uint64(ctx.runtime.state.stats.alloc_failures)
+	ctx.runtime.state.stackHits = uint64(ctx.runtime.state.stats.stack_hits)
+
+	hooks := ctx.runtime.state.trace
+	if hooks == nil || (hooks.onEvalStart == nil && hooks.onEvalEnd == nil) {
+		return func(error) { ctx.runtime.noteJobs() }
+	}
+	if hooks.onEvalStart != nil {
+		hooks.onEvalStart(ctx, filename)
+	}
+	if hooks.onEvalEnd == nil {
+		return func(error) { ctx.runtime.noteJobs() }
+	}
+	info := EvalInfo{Filename: filename, MemoryDelta: -ctx.runtime.memoryUsed(), Start: time.Now()}
+	return func(err error) {
+		ctx.runtime.noteJobs()
+		info.Duration = time.Since(info.Start)
+		info.MemoryDelta += ctx.runtime.memoryUsed()
+		info.Err = err
+		hooks.onEvalEnd(ctx, info)
+	}
+}
+
+// traceHostCall calls the host call hook after fn returned result.
+func (ctx *Context) traceHostCall(fn interface{}, start time.Time, result Value) {
+	hooks := ctx.runtime.state.trace
+	if hooks == nil || hooks.onHostCall == nil {
+		return
+	}
+	info := HostCallInfo{Start: start, Duration: time.Since(start)}
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		info.Name = f.Name()
+	}
+	if result.IsException() {
+		// Peek at the pending exception and throw it again.
+		exception := Value{ctx: ctx, ref: C.JS_GetException(ctx.ref)}
+		if info.Err = exception.Error(); info.Err == nil {
+			info.Err = &Error{Cause: exception.String()}
+		}
+		ctx.Throw(exception)
+	}
+	hooks.onHostCall(ctx, info)
+}
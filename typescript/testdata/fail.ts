@@ -0,0 +1,8 @@
+type Reason = string;
+
+const reason: Reason = "failed";
+
+export function fail(): never {
+	const message: string = "ts " + reason;
+	throw new Error(message);
+}
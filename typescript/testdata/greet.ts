@@ -0,0 +1,13 @@
+export interface Person {
+	name: string;
+	age?: number;
+}
+
+export enum Greeting {
+	Hello = "Hello",
+	Hi = "Hi",
+}
+
+export function greet(p: Person, g: Greeting = Greeting.Hello): string {
+	return `${g} ${p.name}`;
+}
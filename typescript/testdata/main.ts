@@ -0,0 +1,4 @@
+import { greet, Greeting, type Person } from "./greet.ts";
+
+const p: Person = { name: "TypeScript" };
+globalThis.result = greet(p, Greeting.Hi) as string;
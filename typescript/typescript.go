@@ -0,0 +1,71 @@
+/*
+Package typescript strips TypeScript types from sources so they can be evaluated or imported by quickjs.
+*/
+package typescript
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buke/quickjs-go"
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Extensions are the module name suffixes handled by ModuleLoader.
+var Extensions = []string{".ts", ".mts", ".cts"}
+
+// Transform strips the types from the given TypeScript source and returns plain JavaScript.
+// The result carries an inline source map so stack traces point to the TypeScript source named filename.
+func Transform(source string, filename string) (string, error) {
+	result := api.Transform(source, api.TransformOptions{
+		Loader:     api.LoaderTS,
+		Sourcefile: filename,
+		Sourcemap:  api.SourceMapInline,
+		Target:     api.ES2020,
+	})
+	if len(result.Errors) > 0 {
+		errs := make([]error, 0, len(result.Errors))
+		for _, msg := range result.Errors {
+			if msg.Location != nil {
+				errs = append(errs, fmt.Errorf("%s:%d:%d: %s", msg.Location.File, msg.Location.Line, msg.Location.Column, msg.Text))
+			} else {
+				errs = append(errs, errors.New(msg.Text))
+			}
+		}
+		return "", errors.Join(errs...)
+	}
+	return string(result.Code), nil
+}
+
+// IsTypeScript returns true if the module name ends with one of Extensions.
+func IsTypeScript(moduleName string) bool {
+	for _, ext := range Extensions {
+		if strings.HasSuffix(moduleName, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleLoader returns a quickjs.ModuleLoaderFunc which strips the types of TypeScript modules before they are compiled.
+// Sources are loaded by next; if next is nil, they are read from the file system.
+func ModuleLoader(next quickjs.ModuleLoaderFunc) quickjs.ModuleLoaderFunc {
+	if next == nil {
+		next = func(ctx *quickjs.Context, moduleName string) (string, error) {
+			b, err := os.ReadFile(moduleName)
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		}
+	}
+	return func(ctx *quickjs.Context, moduleName string) (string, error) {
+		code, err := next(ctx, moduleName)
+		if err != nil || !IsTypeScript(moduleName) {
+			return code, err
+		}
+		return Transform(code, moduleName)
+	}
+}
@@ -0,0 +1,58 @@
+package typescript_test
+
+import (
+	"testing"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/typescript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	code, err := typescript.Transform(`const add = (a: number, b: number): number => a + b; add(1, 2)`, "add.ts")
+	require.NoError(t, err)
+
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(code)
+	require.NoError(t, err)
+	defer ret.Free()
+	require.EqualValues(t, 3, ret.Int32())
+
+	_, err = typescript.Transform(`const a: = 1`, "bad.ts")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bad.ts:1:")
+}
+
+func TestModuleLoader(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithModuleLoader(typescript.ModuleLoader(nil)))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`import "./testdata/main.ts";`, quickjs.EvalAwait(true))
+	require.NoError(t, err)
+	defer ret.Free()
+
+	result := ctx.Globals().Get("result")
+	defer result.Free()
+	require.EqualValues(t, "Hi TypeScript", result.String())
+
+	_, err = ctx.Eval(`import "./testdata/missing.ts";`, quickjs.EvalAwait(true))
+	require.Error(t, err)
+}
+
+func TestSourceMap(t *testing.T) {
+	rt := quickjs.NewRuntime(quickjs.WithModuleLoader(typescript.ModuleLoader(nil)))
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ret, err := ctx.Eval(`import { fail } from "./testdata/fail.ts"; fail();`, quickjs.EvalAwait(true))
+	defer ret.Free()
+	require.Error(t, err)
+	require.Contains(t, err.(*quickjs.Error).Stack, "testdata/fail.ts:7:")
+}
@@ -0,0 +1,83 @@
+package quickjs
+
+import (
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// InstallUnicode defines the "unicode" module of the context, with Unicode functions implemented in Go that the
+// engine lacks or implements slowly:
+//
+//   - normalize(s, form) and isNormalized(s, form), with the forms NFC (the default), NFD, NFKC and NFKD
+//   - casefold(s), the full case folding of s, for caseless comparisons
+//   - toUpperCase(s, locale), toLowerCase(s, locale) and toTitleCase(s, locale), following the rules of the language
+//     of locale, such as the dotted i of Turkish; without locale, the rules common to all languages
+//   - graphemes(s), the array of the user-perceived characters of s, and graphemeCount(s)
+//
+// Scripts import it like any module: import { normalize } from "unicode".
+func (ctx *Context) InstallUnicode() error {
+	exports := ctx.Object()
+	exports.Set("normalize", ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+		form, ok := normForm(args.String(1, "NFC"))
+		if !ok {
+			return ctx.ThrowRangeError("The normalization form should be one of NFC, NFD, NFKC, NFKD.")
+		}
+		return ctx.String(form.String(args.String(0)))
+	}))
+	exports.Set("isNormalized", ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+		form, ok := normForm(args.String(1, "NFC"))
+		if !ok {
+			return ctx.ThrowRangeError("The normalization form should be one of NFC, NFD, NFKC, NFKD.")
+		}
+		return ctx.Bool(form.IsNormalString(args.String(0)))
+	}))
+	exports.Set("casefold", ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+		return ctx.String(cases.Fold().String(args.String(0)))
+	}))
+	for name, caser := range map[string]func(language.Tag, ...cases.Option) cases.Caser{
+		"toUpperCase": cases.Upper,
+		"toLowerCase": cases.Lower,
+		"toTitleCase": cases.Title,
+	} {
+		caser := caser
+		exports.Set(name, ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+			s, locale := args.String(0), args.String(1, "und")
+			tag, err := language.Parse(locale)
+			if err != nil {
+				return ctx.ThrowRangeError("Incorrect locale information provided: %s", locale)
+			}
+			return ctx.String(caser(tag).String(s))
+		}))
+	}
+	exports.Set("graphemes", ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+		arr := ctx.Array().arrayValue
+		g := uniseg.NewGraphemes(args.String(0))
+		for i := int64(0); g.Next(); i++ {
+			arr.SetIdx(i, ctx.String(g.Str()))
+		}
+		return arr
+	}))
+	exports.Set("graphemeCount", ctx.FunctionArgs(func(ctx *Context, this Value, args *Args) Value {
+		return ctx.Int32(int32(uniseg.GraphemeClusterCount(args.String(0))))
+	}))
+
+	return ctx.LoadHostModule("unicode", exports, "normalize", "isNormalized", "casefold", "toUpperCase", "toLowerCase",
+		"toTitleCase", "graphemes", "graphemeCount")
+}
+
+// normForm returns the normalization form named name.
+func normForm(name string) (norm.Form, bool) {
+	switch name {
+	case "NFC":
+		return norm.NFC, true
+	case "NFD":
+		return norm.NFD, true
+	case "NFKC":
+		return norm.NFKC, true
+	case "NFKD":
+		return norm.NFKD, true
+	}
+	return 0, false
+}
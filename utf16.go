@@ -0,0 +1,82 @@
+package quickjs
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// wtf8Runes returns the code points of b, a WTF-8 string, lone surrogates included.
+func wtf8Runes(b []byte) []rune {
+	runes := make([]rune, 0, len(b))
+	for i := 0; i < len(b); {
+		if isSurrogate(b[i:]) {
+			runes = append(runes, rune(b[i]&0x0f)<<12|rune(b[i+1]&0x3f)<<6|rune(b[i+2]&0x3f))
+			i += 3
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		runes = append(runes, r)
+		i += size
+	}
+	return runes
+}
+
+// Runes returns the code points of the string representation of the value, as iterating the string in JS does: its
+// lone surrogates are code points of their own, which are not valid runes in Go.
+func (v Value) Runes() ([]rune, error) {
+	b, err := v.StringLenBytes()
+	if err != nil {
+		return nil, err
+	}
+	return wtf8Runes(b), nil
+}
+
+// UTF16 returns the UTF-16 code units of the string representation of the value, which JS indexes strings by.
+func (v Value) UTF16() ([]uint16, error) {
+	runes, err := v.Runes()
+	if err != nil {
+		return nil, err
+	}
+	units := make([]uint16, 0, len(runes))
+	for _, r := range runes {
+		if utf16.IsSurrogate(r) {
+			units = append(units, uint16(r))
+		} else {
+			units = utf16.AppendRune(units, r)
+		}
+	}
+	return units, nil
+}
+
+// RuneLen returns the number of code points of the string representation of the value, the length of [...s] in JS.
+func (v Value) RuneLen() (int, error) {
+	runes, err := v.Runes()
+	return len(runes), err
+}
+
+// UTF16Len returns the number of UTF-16 code units of the string representation of the value, its length in JS.
+func (v Value) UTF16Len() (int, error) {
+	units, err := v.UTF16()
+	return len(units), err
+}
+
+// StringFromUTF16 returns a string value of UTF-16 code units, which may include lone surrogates.
+func (ctx *Context) StringFromUTF16(units []uint16) Value {
+	b := make([]byte, 0, len(units))
+	for i := 0; i < len(units); i++ {
+		r := rune(units[i])
+		if utf16.IsSurrogate(r) && i+1 < len(units) {
+			if pair := utf16.DecodeRune(r, rune(units[i+1])); pair != utf8.RuneError {
+				r = pair
+				i++
+			}
+		}
+		if utf16.IsSurrogate(r) {
+			// WTF-8 encodes lone surrogates as UTF-8 would if they were characters.
+			b = append(b, 0xe0|byte(r>>12), 0x80|byte(r>>6)&0x3f, 0x80|byte(r)&0x3f)
+		} else {
+			b = utf8.AppendRune(b, r)
+		}
+	}
+	return ctx.StringFromBytes(b)
+}
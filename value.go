@@ -10,13 +10,103 @@ import (
 	"unsafe"
 )
 
+// ErrStackOverflow is matched by the errors of the scripts that exceeded the maximum stack size (see
+// Runtime.SetMaxStackSize). The engine throws them as InternalError: stack overflow, where other engines throw a
+// RangeError.
+var ErrStackOverflow = errors.New("quickjs: stack overflow")
+
+// ErrOutOfMemory is matched by the errors of the scripts that exceeded the memory limit of the runtime (see
+// Runtime.SetMemoryLimit). The engine throws them as InternalError: out of memory.
+var ErrOutOfMemory = errors.New("quickjs: out of memory")
+
+// ErrHostCallDepth is matched by the errors of the scripts that nested more calls to Go functions than allowed by
+// ContextMaxHostDepth.
+var ErrHostCallDepth = errors.New("quickjs: host call depth exceeded")
+
+// ErrAsyncCallLimit is matched by the errors of the scripts that had more calls to async Go functions pending than
+// allowed by ContextMaxAsyncCalls.
+var ErrAsyncCallLimit = errors.New("quickjs: too many pending async host calls")
+
+// The messages of the RangeErrors matching ErrHostCallDepth and ErrAsyncCallLimit.
+const (
+	hostCallDepthMessage  = "host call depth exceeds the limit of "
+	asyncCallLimitMessage = "pending async host calls exceed the limit of "
+)
+
+// limitKind returns the sentinel error of the limit whose enforcement threw v, or nil. Scripts can throw errors with
+// the messages of the limits, so the limits are recognized from what the Go side recorded when enforcing them: the
+// errors thrown for the limits of host calls, and the allocations that the runtime refused, or made close to the
+// stack limit where the engine throws its stack overflow errors, since the current evaluation started.
+func (v Value) limitKind(cause string) error {
+	state := v.ctx.runtime.state
+	switch {
+	case cause == "InternalError: stack overflow":
+		if state.stats != nil && uint64(state.stats.stack_hits) > state.stackHits {
+			return ErrStackOverflow
+		}
+	case cause == "InternalError: out of memory":
+		if state.stats != nil && uint64(state.stats.alloc_failures) > state.allocFailures {
+			return ErrOutOfMemory
+		}
+	default:
+		for kind, err := range v.ctx.limitErrors {
+			if C.JS_SameValue(v.ctx.ref, v.ref, err.ref) != 0 {
+				return kind
+			}
+		}
+	}
+	return nil
+}
+
+// throwLimit throws the RangeError of the limit of host calls matching kind, recording it for limitKind, and reports
+// it to the OnThrow function.
+func (ctx *Context) throwLimit(kind error, format string, args ...interface{}) C.JSValue {
+	exc := ctx.ThrowRangeError(format, args...)
+	thrown := C.JS_GetException(ctx.ref)
+	if last, ok := ctx.limitErrors[kind]; ok {
+		last.Free()
+	}
+	if ctx.limitErrors == nil {
+		ctx.limitErrors = make(map[error]Value)
+	}
+	ctx.limitErrors[kind] = Value{ctx: ctx, ref: C.JS_DupValue(ctx.ref, thrown)}
+	C.JS_Throw(ctx.ref, thrown)
+	ctx.reportPendingThrow()
+	return exc.ref
+}
+
 type Error struct {
 	Cause string
 	Stack string
+	// Frames are the frames of Stack.
+	Frames []StackFrame
+	// Diagnostics locate the syntax error of a script that failed to compile.
+	Diagnostics SyntaxErrors
+	// Wrapped is the cause property of the JS error, converted to a Go error.
+	Wrapped error
+	// Errors holds the errors of an AggregateError.
+	Errors []error
+
+	kind error // sentinel error matched by errors.Is, such as ErrStackOverflow
 }
 
 func (err Error) Error() string { return err.Cause }
 
+// Unwrap returns the cause and the aggregated errors of the error, for use with errors.Is and errors.As.
+func (err Error) Unwrap() []error {
+	errs := err.Errors
+	if err.Wrapped != nil {
+		errs = append([]error{err.Wrapped}, errs...)
+	}
+	if err.kind != nil {
+		errs = append([]error{err.kind}, errs...)
+	}
+	if err.Diagnostics != nil {
+		errs = append(errs, err.Diagnostics)
+	}
+	return errs
+}
+
 // Object property names and some strings are stored as Atoms (unique strings) to save memory and allow fast comparison. Atoms are represented as a 32 bit integer. Half of the atom range is reserved for immediate integer literals from 0 to 2^{31}-1.
 type Atom struct {
 	ctx *Context
@@ -37,7 +127,7 @@ func (a Atom) String() string {
 
 // Value returns the value of the Atom object.
 func (a Atom) Value() Value {
-	return Value{ctx: a.ctx, ref: C.JS_AtomToValue(a.ctx.ref, a.ref)}
+	return a.ctx.track(Value{ctx: a.ctx, ref: C.JS_AtomToValue(a.ctx.ref, a.ref)})
 }
 
 // propertyEnum is a wrapper around JSAtom.
@@ -51,12 +141,14 @@ func (p propertyEnum) String() string { return p.atom.String() }
 
 // JSValue represents a Javascript value which can be a primitive type or an object. Reference counting is used, so it is important to explicitly duplicate (JS_DupValue(), increment the reference count) or free (JS_FreeValue(), decrement the reference count) JSValues.
 type Value struct {
-	ctx *Context
-	ref C.JSValue
+	ctx   *Context
+	ref   C.JSValue
+	owner *valueOwner // set in auto-free mode
 }
 
 // Free the value.
 func (v Value) Free() {
+	v.ctx.untrack(v)
 	C.JS_FreeValue(v.ctx.ref, v.ref)
 }
 
@@ -70,13 +162,21 @@ func (v Value) Bool() bool {
 	return C.JS_ToBool(v.ctx.ref, v.ref) == 1
 }
 
-// String returns the string representation of the value.
+// String returns the string representation of the value, up to its first NUL character; see StringLenBytes.
 func (v Value) String() string {
 	ptr := C.JS_ToCString(v.ctx.ref, v.ref)
 	defer C.JS_FreeCString(v.ctx.ref, ptr)
 	return C.GoString(ptr)
 }
 
+// goString is like String, but keeps the NUL characters that C.GoString would cut the string at.
+func (v Value) goString() string {
+	var n C.size_t
+	ptr := C.JS_ToCStringLen(v.ctx.ref, &n, v.ref)
+	defer C.JS_FreeCString(v.ctx.ref, ptr)
+	return C.GoStringN(ptr, C.int(n))
+}
+
 // JSONString returns the JSON string representation of the value.
 func (v Value) JSONStringify() string {
 	ref := C.JS_JSONStringify(v.ctx.ref, v.ref, C.JS_NewNull(), C.JS_NewNull())
@@ -211,24 +311,46 @@ func (v Value) ByteLen() int64 {
 func (v Value) Set(name string, val Value) {
 	namePtr := C.CString(name)
 	defer C.free(unsafe.Pointer(namePtr))
+	v.ctx.untrack(val)
 	C.JS_SetPropertyStr(v.ctx.ref, v.ref, namePtr, val.ref)
 }
 
 // SetIdx sets the value of the property with the given index.
 func (v Value) SetIdx(idx int64, val Value) {
+	v.ctx.untrack(val)
 	C.JS_SetPropertyUint32(v.ctx.ref, v.ref, C.uint32_t(idx), val.ref)
 }
 
+// SetE is like Set but returns the exception thrown by a setter or by a read-only property as an error.
+func (v Value) SetE(name string, val Value) error {
+	namePtr := C.CString(name)
+	defer C.free(unsafe.Pointer(namePtr))
+	v.ctx.untrack(val)
+	if C.JS_SetPropertyStr(v.ctx.ref, v.ref, namePtr, val.ref) < 0 {
+		return v.ctx.pendingError()
+	}
+	return nil
+}
+
 // Get returns the value of the property with the given name.
 func (v Value) Get(name string) Value {
 	namePtr := C.CString(name)
 	defer C.free(unsafe.Pointer(namePtr))
-	return Value{ctx: v.ctx, ref: C.JS_GetPropertyStr(v.ctx.ref, v.ref, namePtr)}
+	return v.ctx.track(Value{ctx: v.ctx, ref: C.JS_GetPropertyStr(v.ctx.ref, v.ref, namePtr)})
+}
+
+// GetE is like Get but returns the exception thrown by a getter or a proxy as an error.
+func (v Value) GetE(name string) (Value, error) {
+	val := v.Get(name)
+	if val.IsException() {
+		return v.ctx.Undefined(), v.ctx.pendingError()
+	}
+	return val, nil
 }
 
 // GetIdx returns the value of the property with the given index.
 func (v Value) GetIdx(idx int64) Value {
-	return Value{ctx: v.ctx, ref: C.JS_GetPropertyUint32(v.ctx.ref, v.ref, C.uint32_t(idx))}
+	return v.ctx.track(Value{ctx: v.ctx, ref: C.JS_GetPropertyUint32(v.ctx.ref, v.ref, C.uint32_t(idx))})
 }
 
 // Call calls the function with the given arguments.
@@ -249,9 +371,26 @@ func (v Value) Call(fname string, args ...Value) Value {
 		cargs = append(cargs, x.ref)
 	}
 	if len(cargs) == 0 {
-		return Value{ctx: v.ctx, ref: C.JS_Call(v.ctx.ref, fn.ref, v.ref, C.int(0), nil)}
+		return v.ctx.track(Value{ctx: v.ctx, ref: v.ctx.call(fn.ref, v.ref, nil)})
 	}
-	return Value{ctx: v.ctx, ref: C.JS_Call(v.ctx.ref, fn.ref, v.ref, C.int(len(cargs)), &cargs[0])}
+	return v.ctx.track(Value{ctx: v.ctx, ref: v.ctx.call(fn.ref, v.ref, cargs)})
+}
+
+// CallE is like Call but returns the exception thrown by the function as an error, and an error if v has no such
+// method.
+func (v Value) CallE(fname string, args ...Value) (Value, error) {
+	if !v.IsObject() {
+		return v.ctx.Undefined(), errors.New("Object not a object")
+	}
+	fn, err := v.GetE(fname)
+	if err != nil {
+		return fn, err
+	}
+	defer fn.Free()
+	if !fn.IsFunction() {
+		return v.ctx.Undefined(), errors.New("Object not a function")
+	}
+	return v.ctx.InvokeE(fn, v, args...)
 }
 
 // Call Class Constructor
@@ -270,25 +409,70 @@ func (v Value) CallConstructor(args ...Value) Value {
 		cargs = append(cargs, x.ref)
 	}
 	if len(cargs) == 0 {
-		return Value{ctx: v.ctx, ref: C.JS_CallConstructor(v.ctx.ref, v.ref, C.int(0), nil)}
+		return v.ctx.track(Value{ctx: v.ctx, ref: C.JS_CallConstructor(v.ctx.ref, v.ref, C.int(0), nil)})
 	}
-	return Value{ctx: v.ctx, ref: C.JS_CallConstructor(v.ctx.ref, v.ref, C.int(len(cargs)), &cargs[0])}
+	return v.ctx.track(Value{ctx: v.ctx, ref: C.JS_CallConstructor(v.ctx.ref, v.ref, C.int(len(cargs)), &cargs[0])})
 }
 
 // Error returns the error value of the value.
+// The cause chain and the errors of an AggregateError are converted too.
 func (v Value) Error() error {
 	if !v.IsError() {
 		return nil
 	}
-	cause := v.String()
+	return v.errorDepth(0)
+}
+
+func (v Value) errorDepth(depth int) *Error {
+	err := &Error{Cause: v.String()}
+	if kind := v.limitKind(err.Cause); kind != nil {
+		err.kind = kind
+	} else if v.ctx.exited != nil && uintptr(C.ValueGetPtr(v.ref)) == v.ctx.exitedPtr {
+		err.kind = v.ctx.exited
+	}
 
 	stack := v.Get("stack")
 	defer stack.Free()
+	if !stack.IsUndefined() {
+		err.Stack = v.ctx.remapStack(stack.String())
+		err.Frames = parseStack(err.Stack)
+	}
+	if depth >= maxErrorDepth {
+		return err
+	}
 
-	if stack.IsUndefined() {
-		return &Error{Cause: cause}
+	if hasCause, _ := v.HasE("cause"); hasCause {
+		cause := v.Get("cause")
+		err.Wrapped = cause.causeError(depth + 1)
+		cause.Free()
 	}
-	return &Error{Cause: cause, Stack: stack.String()}
+	if v.isAggregateError() {
+		errs := v.Get("errors")
+		defer errs.Free()
+		if errs.IsArray() {
+			n := errs.Len()
+			for i := int64(0); i < n; i++ {
+				e := errs.GetIdx(i)
+				err.Errors = append(err.Errors, e.causeError(depth+1))
+				e.Free()
+			}
+		}
+	}
+	return err
+}
+
+// causeError converts a cause or an aggregated error, which may be any value, to a Go error.
+func (v Value) causeError(depth int) error {
+	if v.IsError() {
+		return v.errorDepth(depth)
+	}
+	return &Error{Cause: v.String()}
+}
+
+func (v Value) isAggregateError() bool {
+	ctor := v.ctx.Globals().Get("AggregateError")
+	defer ctor.Free()
+	return v.IsInstanceOf(ctor)
 }
 
 // propertyEnum is a wrapper around JSValue.
@@ -326,13 +510,21 @@ func (v Value) PropertyNames() ([]string, error) {
 	return names, nil
 }
 
-// Has returns true if the value has the property with the given name.
+// Has returns true if the value has the property with the given name. An exception thrown by a proxy trap is left
+// pending, for Context.Exception; HasE returns it.
 func (v Value) Has(name string) bool {
 	prop := v.ctx.Atom(name)
 	defer prop.Free()
 	return C.JS_HasProperty(v.ctx.ref, v.ref, prop.ref) == 1
 }
 
+// HasE is like Has but returns the exception thrown by a proxy trap as an error.
+func (v Value) HasE(name string) (bool, error) {
+	prop := v.ctx.Atom(name)
+	defer prop.Free()
+	return v.ctx.boolResult(C.JS_HasProperty(v.ctx.ref, v.ref, prop.ref))
+}
+
 // HasIdx returns true if the value has the property with the given index.
 func (v Value) HasIdx(idx int64) bool {
 	prop := v.ctx.AtomIdx(idx)
@@ -340,18 +532,34 @@ func (v Value) HasIdx(idx int64) bool {
 	return C.JS_HasProperty(v.ctx.ref, v.ref, prop.ref) == 1
 }
 
-// Delete deletes the property with the given name.
+// Delete deletes the property with the given name. An exception thrown by a proxy trap is left pending, for
+// Context.Exception; DeleteE returns it.
 func (v Value) Delete(name string) bool {
 	prop := v.ctx.Atom(name)
 	defer prop.Free()
 	return C.JS_DeleteProperty(v.ctx.ref, v.ref, prop.ref, C.int(1)) == 1
 }
 
+// DeleteE is like Delete but returns the exception thrown by a proxy trap as an error.
+func (v Value) DeleteE(name string) (bool, error) {
+	prop := v.ctx.Atom(name)
+	defer prop.Free()
+	return v.ctx.boolResult(C.JS_DeleteProperty(v.ctx.ref, v.ref, prop.ref, C.int(1)))
+}
+
 // DeleteIdx deletes the property with the given index.
 func (v Value) DeleteIdx(idx int64) bool {
 	return C.JS_DeletePropertyInt64(v.ctx.ref, v.ref, C.int64_t(idx), C.int(1)) == 1
 }
 
+// boolResult converts the result of an engine predicate, returning the exception thrown when it is -1.
+func (ctx *Context) boolResult(ret C.int) (bool, error) {
+	if ret < 0 {
+		return false, ctx.pendingError()
+	}
+	return ret == 1, nil
+}
+
 // globalInstanceof checks if the value is an instance of the given global constructor
 func (v Value) globalInstanceof(name string) bool {
 	ctor := v.ctx.Globals().Get(name)
@@ -362,6 +570,33 @@ func (v Value) globalInstanceof(name string) bool {
 	return C.JS_IsInstanceOf(v.ctx.ref, v.ref, ctor.ref) == 1
 }
 
+// StrictEquals reports whether v === other.
+func (v Value) StrictEquals(other Value) bool {
+	return C.JS_StrictEq(v.ctx.ref, v.ref, other.ref) == 1
+}
+
+// SameValue reports whether v and other are the same value as defined by Object.is.
+func (v Value) SameValue(other Value) bool {
+	return C.JS_SameValue(v.ctx.ref, v.ref, other.ref) == 1
+}
+
+// SameValueZero reports whether v and other are the same value, considering +0 and -0 equal.
+func (v Value) SameValueZero(other Value) bool {
+	return C.JS_SameValueZero(v.ctx.ref, v.ref, other.ref) == 1
+}
+
+// IsInstanceOf reports whether v instanceof ctor is true. It returns false if the check throws, for example when
+// ctor is not callable.
+func (v Value) IsInstanceOf(ctor Value) bool {
+	switch C.JS_IsInstanceOf(v.ctx.ref, v.ref, ctor.ref) {
+	case 1:
+		return true
+	case -1:
+		C.JS_FreeValue(v.ctx.ref, C.JS_GetException(v.ctx.ref))
+	}
+	return false
+}
+
 func (v Value) IsNumber() bool        { return C.JS_IsNumber(v.ref) == 1 }
 func (v Value) IsBigInt() bool        { return C.JS_IsBigInt(v.ctx.ref, v.ref) == 1 }
 func (v Value) IsBigFloat() bool      { return C.JS_IsBigFloat(v.ref) == 1 }
@@ -386,3 +621,43 @@ func (v Value) IsPromise() bool {
 }
 
 func (v Value) IsConstructor() bool { return C.JS_IsConstructor(v.ctx.ref, v.ref) == 1 }
+
+// PromiseState is the state of a promise.
+type PromiseState int
+
+const (
+	PromiseNotAPromise PromiseState = -1 // the value is not a promise
+	PromisePending     PromiseState = C.JS_PROMISE_PENDING
+	PromiseFulfilled   PromiseState = C.JS_PROMISE_FULFILLED
+	PromiseRejected    PromiseState = C.JS_PROMISE_REJECTED
+)
+
+func (s PromiseState) String() string {
+	switch s {
+	case PromisePending:
+		return "pending"
+	case PromiseFulfilled:
+		return "fulfilled"
+	case PromiseRejected:
+		return "rejected"
+	}
+	return "not a promise"
+}
+
+// PromiseState returns the state of the promise without waiting for it; pending jobs are not run.
+func (v Value) PromiseState() PromiseState {
+	switch state := C.JS_PromiseState(v.ctx.ref, v.ref); state {
+	case C.JS_PROMISE_PENDING, C.JS_PROMISE_FULFILLED, C.JS_PROMISE_REJECTED:
+		return PromiseState(state)
+	}
+	return PromiseNotAPromise
+}
+
+// PromiseResult returns the value of a fulfilled promise or the reason of a rejected promise, and undefined for a
+// pending promise or a value that is not a promise. A rejection is returned as a value, not thrown.
+func (v Value) PromiseResult() Value {
+	if v.PromiseState() == PromiseNotAPromise {
+		return v.ctx.Undefined()
+	}
+	return v.ctx.track(Value{ctx: v.ctx, ref: C.JS_PromiseResult(v.ctx.ref, v.ref)})
+}
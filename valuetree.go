@@ -0,0 +1,450 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+	"unsafe"
+)
+
+// maxTreeDepth limits the nesting of the value trees converted to and from MessagePack and CBOR.
+const maxTreeDepth = 1000
+
+// Typed arrays are identified by the CBOR tags of RFC 8746 for their little-endian encoding, in CBOR and in the
+// MessagePack typed array extension alike.
+var typedArrayTags = map[string]uint64{
+	"Uint8Array":        64,
+	"Uint8ClampedArray": 68,
+	"Uint16Array":       69,
+	"Uint32Array":       70,
+	"BigUint64Array":    71,
+	"Int8Array":         72,
+	"Int16Array":        77,
+	"Int32Array":        78,
+	"BigInt64Array":     79,
+	"Float32Array":      85,
+	"Float64Array":      86,
+}
+
+func typedArrayName(tag uint64) string {
+	for name, t := range typedArrayTags {
+		if t == tag {
+			return name
+		}
+	}
+	return ""
+}
+
+// treeEncoder is implemented by the binary formats value trees are encoded to.
+type treeEncoder interface {
+	null(undefined bool)
+	bool(b bool)
+	int(n int64)
+	float(f float64)
+	bigInt(n *big.Int)
+	string(s string)
+	bytes(b []byte)
+	typedArray(tag uint64, b []byte)
+	date(ms float64)
+	array(n int)
+	object(n int)
+}
+
+// treeWalker encodes a value tree: primitives, plain objects, arrays, Maps (encoded as maps), Sets (encoded as
+// arrays), dates, BigInts, ArrayBuffers and typed arrays. Like JSON.stringify, it leaves out object properties
+// holding functions or symbols, encodes them as null in arrays, and fails on circular structures.
+type treeWalker struct {
+	ctx   *Context
+	enc   treeEncoder
+	stack []Value
+	tagFn Value
+}
+
+func (ctx *Context) encodeTree(v Value, enc treeEncoder) error {
+	tagFn, err := ctx.Eval(`(v) => Object.prototype.toString.call(v).slice(8, -1)`, EvalFlagInternal(true))
+	if err != nil {
+		return err
+	}
+	defer tagFn.Free()
+	w := treeWalker{ctx: ctx, enc: enc, tagFn: tagFn}
+	return w.walk(v)
+}
+
+// skippedInTree reports whether a value is left out of objects in value trees.
+func skippedInTree(v Value) bool {
+	return v.IsFunction() || v.IsSymbol()
+}
+
+func (w *treeWalker) walk(v Value) error {
+	switch v.Kind() {
+	case KindUndefined:
+		w.enc.null(true)
+	case KindNull, KindFunction, KindSymbol:
+		w.enc.null(false)
+	case KindBool:
+		w.enc.bool(v.Bool())
+	case KindNumber:
+		f := v.Float64()
+		if f == math.Trunc(f) && math.Abs(f) <= maxSafeInteger && !(f == 0 && math.Signbit(f)) {
+			w.enc.int(int64(f))
+		} else {
+			w.enc.float(f)
+		}
+	case KindBigInt:
+		w.enc.bigInt(v.BigInt())
+	case KindString:
+		w.enc.string(v.goString())
+	case KindDate:
+		ms := v.Call("getTime")
+		w.enc.date(ms.Float64())
+		ms.Free()
+	case KindArrayBuffer:
+		b, err := v.ToByteArray(uint(v.ByteLen()))
+		if err != nil {
+			return err
+		}
+		w.enc.bytes(b)
+	case KindTypedArray:
+		name, err := w.ctx.InvokeE(w.tagFn, w.ctx.Null(), v)
+		if err != nil {
+			return err
+		}
+		tagName := name.String()
+		name.Free()
+		tag, ok := typedArrayTags[tagName]
+		if !ok {
+			return fmt.Errorf("quickjs: unsupported typed array %s", tagName)
+		}
+		b, err := w.ctx.typedArrayBytes(v)
+		if err != nil {
+			return err
+		}
+		w.enc.typedArray(tag, b)
+	case KindArray, KindObject, KindMap, KindSet, KindError:
+		return w.walkObject(v)
+	default:
+		return fmt.Errorf("quickjs: %s value can't be encoded", v.Kind())
+	}
+	return nil
+}
+
+func (w *treeWalker) walkObject(v Value) error {
+	if len(w.stack) >= maxTreeDepth {
+		return errors.New("quickjs: value nested too deeply to encode")
+	}
+	for _, s := range w.stack {
+		if s.StrictEquals(v) {
+			return errors.New("quickjs: circular reference in value tree")
+		}
+	}
+	w.stack = append(w.stack, v)
+	defer func() { w.stack = w.stack[:len(w.stack)-1] }()
+
+	switch v.Kind() {
+	case KindArray:
+		n := v.Len()
+		w.enc.array(int(n))
+		for i := int64(0); i < n; i++ {
+			item := v.GetIdx(i)
+			err := w.walk(item)
+			item.Free()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case KindSet, KindMap:
+		entries := w.call("Array", "from", v)
+		defer entries.Free()
+		n := entries.Len()
+		if v.Kind() == KindSet {
+			w.enc.array(int(n))
+		} else {
+			w.enc.object(int(n))
+		}
+		for i := int64(0); i < n; i++ {
+			entry := entries.GetIdx(i)
+			var err error
+			if v.Kind() == KindSet {
+				err = w.walk(entry)
+			} else {
+				key, value := entry.GetIdx(0), entry.GetIdx(1)
+				if err = w.walk(key); err == nil {
+					err = w.walk(value)
+				}
+				key.Free()
+				value.Free()
+			}
+			entry.Free()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	keys := w.call("Object", "keys", v)
+	defer keys.Free()
+	n := keys.Len()
+	names := make([]string, 0, n)
+	values := make([]Value, 0, n)
+	defer func() {
+		for _, value := range values {
+			value.Free()
+		}
+	}()
+	for i := int64(0); i < n; i++ {
+		key := keys.GetIdx(i)
+		name := key.goString()
+		key.Free()
+		value, err := v.GetE(name)
+		if err != nil {
+			return err
+		}
+		if skippedInTree(value) {
+			value.Free()
+			continue
+		}
+		names = append(names, name)
+		values = append(values, value)
+	}
+	w.enc.object(len(names))
+	for i, name := range names {
+		w.enc.string(name)
+		if err := w.walk(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call calls a static method of a global constructor, such as Object.keys.
+func (w *treeWalker) call(global, method string, args ...Value) Value {
+	obj := w.ctx.Globals().Get(global)
+	defer obj.Free()
+	return obj.Call(method, args...)
+}
+
+// typedArrayBytes returns a copy of the bytes viewed by a typed array.
+func (ctx *Context) typedArrayBytes(v Value) ([]byte, error) {
+	var offset, length, bytesPerElement C.size_t
+	buf := C.JS_GetTypedArrayBuffer(ctx.ref, v.ref, &offset, &length, &bytesPerElement)
+	if C.JS_IsException(buf) == 1 {
+		return nil, ctx.Exception()
+	}
+	defer C.JS_FreeValue(ctx.ref, buf)
+	var size C.size_t
+	ptr := C.JS_GetArrayBuffer(ctx.ref, &size, buf)
+	if ptr == nil || offset+length > size {
+		return nil, ctx.Exception()
+	}
+	return C.GoBytes(unsafe.Add(unsafe.Pointer(ptr), int(offset)), C.int(length)), nil
+}
+
+// DataBytes returns the bytes of the data given to a host module: the UTF-8 encoding of a string, or a copy of the
+// bytes of an ArrayBuffer or of an ArrayBuffer view.
+func (ctx *Context) DataBytes(v Value) ([]byte, error) {
+	switch v.Kind() {
+	case KindString:
+		return []byte(v.goString()), nil
+	case KindArrayBuffer:
+		return v.ToByteArray(uint(v.ByteLen()))
+	}
+	return ctx.typedArrayBytes(v)
+}
+
+// TreeBuilder creates the values of the value trees decoded from MessagePack and CBOR, and by host modules from other
+// formats.
+type TreeBuilder struct {
+	ctx *Context
+}
+
+// NewTreeBuilder returns a builder of values of ctx.
+func NewTreeBuilder(ctx *Context) TreeBuilder {
+	return TreeBuilder{ctx: ctx}
+}
+
+// Int returns a Number for a safe integer and a BigInt otherwise, so that no precision is lost.
+func (b TreeBuilder) Int(n *big.Int) (Value, error) {
+	if n.IsInt64() && n.Int64() >= -maxSafeInteger && n.Int64() <= maxSafeInteger {
+		return b.ctx.Int64(n.Int64()), nil
+	}
+	return b.BigInt(n)
+}
+
+// BigInt returns a BigInt.
+func (b TreeBuilder) BigInt(n *big.Int) (Value, error) {
+	return b.construct("BigInt", false, b.ctx.String(n.String()))
+}
+
+// Date returns a Date of ms milliseconds since the epoch.
+func (b TreeBuilder) Date(ms float64) (Value, error) {
+	return b.construct("Date", true, b.ctx.Float64(ms))
+}
+
+// Bytes returns an ArrayBuffer holding a copy of data.
+func (b TreeBuilder) Bytes(data []byte) Value {
+	return b.ctx.ArrayBuffer(data)
+}
+
+// TypedArray returns a typed array of data, its type identified by its tag in RFC 8746.
+func (b TreeBuilder) TypedArray(tag uint64, data []byte) (Value, error) {
+	name := typedArrayName(tag)
+	if name == "" {
+		return Value{}, fmt.Errorf("quickjs: unknown typed array tag %d", tag)
+	}
+	return b.construct(name, true, b.Bytes(data))
+}
+
+// construct calls the global function name with arg, as a constructor if ctor is set, consuming arg.
+func (b TreeBuilder) construct(name string, ctor bool, arg Value) (Value, error) {
+	fn := b.ctx.Globals().Get(name)
+	defer fn.Free()
+	defer arg.Free()
+	if ctor {
+		v := fn.CallConstructor(arg)
+		if v.IsException() {
+			return Value{}, b.ctx.Exception()
+		}
+		return v, nil
+	}
+	return b.ctx.InvokeE(fn, b.ctx.Null(), arg)
+}
+
+// Map returns an object for keys that are all strings, and a Map otherwise, consuming keys and values.
+func (b TreeBuilder) Map(keys, values []Value) Value {
+	strings := true
+	for _, key := range keys {
+		strings = strings && key.IsString()
+	}
+	if strings {
+		obj := b.ctx.Object()
+		for i, key := range keys {
+			atom := C.JS_ValueToAtom(b.ctx.ref, key.ref)
+			b.ctx.untrack(values[i])
+			C.JS_DefinePropertyValue(b.ctx.ref, obj.ref, atom, values[i].ref, C.JS_PROP_C_W_E)
+			C.JS_FreeAtom(b.ctx.ref, atom)
+			key.Free()
+		}
+		return obj
+	}
+	m := b.ctx.Map()
+	for i, key := range keys {
+		m.Put(key, values[i])
+		key.Free()
+		values[i].Free()
+	}
+	return m.ToValue()
+}
+
+// Array returns an array of items, consuming them.
+func (b TreeBuilder) Array(items []Value) Value {
+	arr := b.ctx.track(Value{ctx: b.ctx, ref: C.JS_NewArray(b.ctx.ref)})
+	for i, item := range items {
+		b.ctx.untrack(item)
+		C.JS_DefinePropertyValueUint32(b.ctx.ref, arr.ref, C.uint32_t(i), item.ref, C.JS_PROP_C_W_E)
+	}
+	return arr
+}
+
+// Dup returns a new reference to v, for values appearing more than once in a tree.
+func (b TreeBuilder) Dup(v Value) Value {
+	return b.ctx.track(Value{ctx: b.ctx, ref: C.JS_DupValue(b.ctx.ref, v.ref)})
+}
+
+func freeValues(values []Value) {
+	for _, v := range values {
+		v.Free()
+	}
+}
+
+// TreeMap is an object or a Map of a Go value tree, keeping the order of its keys.
+type TreeMap struct {
+	Keys   []interface{}
+	Values []interface{}
+}
+
+// GoTree converts the value tree of v, as MarshalMsgpack does, to Go values for the text formats encoded by Go
+// libraries: nil, bool, int64, float64, *big.Int, string, []byte, time.Time, []interface{} and *TreeMap. Typed arrays
+// are converted to their bytes.
+func (v Value) GoTree() (interface{}, error) {
+	var enc goTreeEncoder
+	if err := v.ctx.encodeTree(v, &enc); err != nil {
+		return nil, err
+	}
+	return enc.root, nil
+}
+
+// goTreeFrame is an array or an object being filled by a goTreeEncoder.
+type goTreeFrame struct {
+	left   int
+	object bool
+	items  []interface{}
+}
+
+// goTreeEncoder encodes a value tree to the Go values of GoTree.
+type goTreeEncoder struct {
+	root  interface{}
+	stack []*goTreeFrame
+}
+
+func (e *goTreeEncoder) add(v interface{}) {
+	for len(e.stack) > 0 {
+		top := e.stack[len(e.stack)-1]
+		top.items = append(top.items, v)
+		if top.left--; top.left > 0 {
+			return
+		}
+		e.stack = e.stack[:len(e.stack)-1]
+		v = top.value()
+	}
+	e.root = v
+}
+
+func (f *goTreeFrame) value() interface{} {
+	if !f.object {
+		return f.items
+	}
+	m := &TreeMap{}
+	for i := 0; i < len(f.items); i += 2 {
+		m.Keys = append(m.Keys, f.items[i])
+		m.Values = append(m.Values, f.items[i+1])
+	}
+	return m
+}
+
+func (e *goTreeEncoder) container(n int, object bool) {
+	f := &goTreeFrame{left: n, object: object, items: make([]interface{}, 0, n)}
+	if n == 0 {
+		e.add(f.value())
+		return
+	}
+	e.stack = append(e.stack, f)
+}
+
+func (e *goTreeEncoder) null(bool)                     { e.add(nil) }
+func (e *goTreeEncoder) bool(b bool)                   { e.add(b) }
+func (e *goTreeEncoder) int(n int64)                   { e.add(n) }
+func (e *goTreeEncoder) float(f float64)               { e.add(f) }
+func (e *goTreeEncoder) bigInt(n *big.Int)             { e.add(n) }
+func (e *goTreeEncoder) string(s string)               { e.add(s) }
+func (e *goTreeEncoder) bytes(b []byte)                { e.add(b) }
+func (e *goTreeEncoder) typedArray(_ uint64, b []byte) { e.add(b) }
+func (e *goTreeEncoder) array(n int)                   { e.container(n, false) }
+func (e *goTreeEncoder) object(n int)                  { e.container(n*2, true) }
+
+// date encodes an invalid date as nil, like JSON.stringify.
+func (e *goTreeEncoder) date(ms float64) {
+	if math.IsNaN(ms) {
+		e.add(nil)
+		return
+	}
+	e.add(time.UnixMilli(int64(ms)).UTC())
+}
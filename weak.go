@@ -0,0 +1,115 @@
+package quickjs
+
+/*
+#include "bridge.h"
+*/
+import "C"
+import "errors"
+
+// The engine has no WeakRef or FinalizationRegistry, so collection is observed with a hidden Go object stored on the
+// target under a private symbol: the Go object is finalized when the target is.
+
+// collectWatcher holds the weak references to clear and the functions to call when the object it is attached to is
+// collected.
+type collectWatcher struct {
+	weakRefs  []*WeakRef
+	callbacks []func()
+}
+
+func (w *collectWatcher) add(weakRef *WeakRef, fn func()) {
+	if weakRef != nil {
+		w.weakRefs = append(w.weakRefs, weakRef)
+	}
+	if fn != nil {
+		w.callbacks = append(w.callbacks, fn)
+	}
+}
+
+// onCollect clears weakRef and calls fn once target has been garbage collected.
+func (ctx *Context) onCollect(target Value, weakRef *WeakRef, fn func()) error {
+	if !target.IsObject() {
+		return errors.New("quickjs: target must be an object")
+	}
+	atom, err := ctx.symbolAtom("quickjs.collect")
+	if err != nil {
+		return err
+	}
+
+	existing := Value{ctx: ctx, ref: C.JS_GetProperty(ctx.ref, target.ref, atom)}
+	data, ok := existing.GoData()
+	C.JS_FreeValue(ctx.ref, existing.ref)
+	if ok {
+		data.(*collectWatcher).add(weakRef, fn)
+		return nil
+	}
+
+	state := ctx.runtime.state
+	w := &collectWatcher{}
+	w.add(weakRef, fn)
+	watcher := ctx.GoObject(w, func(interface{}) {
+		for _, weakRef := range w.weakRefs {
+			weakRef.alive = false
+		}
+		// Finalizers run inside the garbage collector, where JS must not be called.
+		state.collected = append(state.collected, w.callbacks...)
+	})
+	ctx.untrack(watcher)
+	if C.JS_DefinePropertyValue(ctx.ref, target.ref, atom, watcher.ref, 0) < 0 {
+		return ctx.Exception()
+	}
+	return nil
+}
+
+// runCollected runs the callbacks of the objects collected since the last call.
+func (r Runtime) runCollected() {
+	for len(r.state.collected) > 0 {
+		callbacks := r.state.collected
+		r.state.collected = nil
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// WeakRef is a reference to an object that does not keep the object alive.
+type WeakRef struct {
+	ctx   *Context
+	ref   C.JSValue
+	alive bool
+}
+
+// WeakRef returns a weak reference to the object v. It fails if v is not an extensible object.
+func (ctx *Context) WeakRef(v Value) (*WeakRef, error) {
+	w := &WeakRef{ctx: ctx, ref: v.ref, alive: true}
+	if err := ctx.onCollect(v, w, nil); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Deref returns the object, which must be freed, or false if it has been garbage collected.
+func (w *WeakRef) Deref() (Value, bool) {
+	if !w.alive {
+		return w.ctx.Undefined(), false
+	}
+	return w.ctx.track(Value{ctx: w.ctx, ref: C.JS_DupValue(w.ctx.ref, w.ref)}), true
+}
+
+// FinalizationRegistry calls a Go function with a held value after each registered object is garbage collected.
+type FinalizationRegistry struct {
+	ctx     *Context
+	cleanup func(held interface{})
+}
+
+// FinalizationRegistry returns a registry calling cleanup for the registered objects. cleanup runs on the runtime's
+// goroutine after the collection, when RunGC or the next evaluation starts, or when the runtime is closed; it must not
+// use the collected object.
+func (ctx *Context) FinalizationRegistry(cleanup func(held interface{})) *FinalizationRegistry {
+	return &FinalizationRegistry{ctx: ctx, cleanup: cleanup}
+}
+
+// Register registers the object target; held is passed to the cleanup function once target is collected.
+// It fails if target is not an extensible object.
+func (r *FinalizationRegistry) Register(target Value, held interface{}) error {
+	return r.ctx.onCollect(target, nil, func() { r.cleanup(held) })
+}
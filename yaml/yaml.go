@@ -0,0 +1,304 @@
+// Package yaml defines the "yaml" host module of quickjs, parsing and stringifying YAML in Go.
+package yaml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"gopkg.in/yaml.v3"
+)
+
+// maxDepth limits the nesting of the parsed values.
+const maxDepth = 1000
+
+// yamlBuilder creates the values of a YAML document. The values of anchored nodes are kept, so that aliases refer to
+// the same value instead of copying it.
+type yamlBuilder struct {
+	quickjs.TreeBuilder
+	ctx     *quickjs.Context
+	anchors map[*yaml.Node]quickjs.Value
+}
+
+func (b *yamlBuilder) free() {
+	for _, v := range b.anchors {
+		v.Free()
+	}
+}
+
+func (b *yamlBuilder) value(n *yaml.Node, depth int) (quickjs.Value, error) {
+	if depth > maxDepth {
+		return quickjs.Value{}, errors.New("YAML value nested too deeply")
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if v, ok := b.anchors[n]; ok {
+		return b.Dup(v), nil
+	}
+	v, err := b.node(n, depth)
+	if err == nil && n.Anchor != "" {
+		b.anchors[n] = b.Dup(v)
+	}
+	return v, err
+}
+
+func (b *yamlBuilder) node(n *yaml.Node, depth int) (quickjs.Value, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return b.ctx.Null(), nil
+		}
+		return b.value(n.Content[0], depth)
+	case yaml.SequenceNode:
+		items := make([]quickjs.Value, 0, len(n.Content))
+		for _, c := range n.Content {
+			item, err := b.value(c, depth+1)
+			if err != nil {
+				freeValues(items)
+				return quickjs.Value{}, err
+			}
+			items = append(items, item)
+		}
+		return b.Array(items), nil
+	case yaml.MappingNode:
+		var keys []string
+		var values []quickjs.Value
+		if err := b.pairs(n, depth, &keys, &values, false); err != nil {
+			freeValues(values)
+			return quickjs.Value{}, err
+		}
+		names := make([]quickjs.Value, len(keys))
+		for i, key := range keys {
+			names[i] = b.ctx.String(key)
+		}
+		return b.Map(names, values), nil
+	}
+	return b.scalar(n)
+}
+
+// pairs appends the keys and values of a mapping, including the mappings merged with "<<". Keys already set are
+// replaced, unless merged, so that the keys of a mapping take precedence over the merged ones and the first merged
+// mapping over the next ones.
+func (b *yamlBuilder) pairs(n *yaml.Node, depth int, keys *[]string, values *[]quickjs.Value, merged bool) error {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: only mappings can be merged", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if key.Kind == yaml.AliasNode {
+			key = key.Alias
+		}
+		if key.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: mapping keys must be scalars", key.Line)
+		}
+		if key.ShortTag() == "!!merge" {
+			sources := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				sources = value.Content
+			}
+			for _, source := range sources {
+				if err := b.pairs(source, depth+1, keys, values, true); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		index := -1
+		for j, k := range *keys {
+			if k == key.Value {
+				index = j
+			}
+		}
+		if index >= 0 && merged {
+			continue
+		}
+		v, err := b.value(value, depth+1)
+		if err != nil {
+			return err
+		}
+		if index >= 0 {
+			(*values)[index].Free()
+			(*values)[index] = v
+		} else {
+			*keys = append(*keys, key.Value)
+			*values = append(*values, v)
+		}
+	}
+	return nil
+}
+
+func (b *yamlBuilder) scalar(n *yaml.Node) (quickjs.Value, error) {
+	switch n.ShortTag() {
+	case "!!null":
+		return b.ctx.Null(), nil
+	case "!!bool":
+		var v bool
+		if err := n.Decode(&v); err != nil {
+			return quickjs.Value{}, err
+		}
+		return b.ctx.Bool(v), nil
+	case "!!int":
+		i, ok := new(big.Int).SetString(strings.ReplaceAll(n.Value, "_", ""), 0)
+		if !ok {
+			return quickjs.Value{}, fmt.Errorf("line %d: invalid integer %q", n.Line, n.Value)
+		}
+		return b.Int(i)
+	case "!!float":
+		var v float64
+		if err := n.Decode(&v); err != nil {
+			return quickjs.Value{}, err
+		}
+		return b.ctx.Float64(v), nil
+	case "!!timestamp":
+		var v time.Time
+		if err := n.Decode(&v); err != nil {
+			return quickjs.Value{}, err
+		}
+		return b.Date(float64(v.UnixMilli()))
+	case "!!binary":
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(n.Value), ""))
+		if err != nil {
+			return quickjs.Value{}, fmt.Errorf("line %d: invalid binary: %w", n.Line, err)
+		}
+		return b.Bytes(data), nil
+	}
+	return b.ctx.String(n.Value), nil
+}
+
+// yamlNode returns the node of a Go value tree.
+func yamlNode(v interface{}) *yaml.Node {
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	}
+	switch v := v.(type) {
+	case bool:
+		return scalar("!!bool", strconv.FormatBool(v))
+	case int64:
+		return scalar("!!int", strconv.FormatInt(v, 10))
+	case *big.Int:
+		return scalar("!!int", v.String())
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return scalar("!!float", ".nan")
+		case math.IsInf(v, 1):
+			return scalar("!!float", ".inf")
+		case math.IsInf(v, -1):
+			return scalar("!!float", "-.inf")
+		}
+		return scalar("!!float", strconv.FormatFloat(v, 'g', -1, 64))
+	case string:
+		return scalar("!!str", v)
+	case []byte:
+		return scalar("!!binary", base64.StdEncoding.EncodeToString(v))
+	case time.Time:
+		return scalar("!!timestamp", v.Format(time.RFC3339Nano))
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, yamlNode(item))
+		}
+		return n
+	case *quickjs.TreeMap:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for i, key := range v.Keys {
+			n.Content = append(n.Content, yamlNode(key), yamlNode(v.Values[i]))
+		}
+		return n
+	}
+	return scalar("!!null", "null")
+}
+
+// Install defines the "yaml" module of ctx, parsing and stringifying YAML in Go:
+//
+//	import { parse, stringify } from "yaml";
+//	const config = parse(text);
+//	config.replicas += 1;
+//	const out = stringify(config);
+//
+// parse(text) returns the first document of text as plain objects and arrays, and parseAll(text) an array of all its
+// documents. Keys keep their order, aliases refer to the value of their anchor and "<<" merges mappings. Integers
+// beyond the safe range are BigInts, timestamps are dates and binary values ArrayBuffers. Invalid YAML throws a
+// SyntaxError.
+//
+// stringify(value) returns the YAML document of a value, as converted by Value.GoTree: Maps are mappings and
+// ArrayBuffers and typed arrays are binary values.
+func Install(ctx *quickjs.Context) error {
+	parse := func(text string, all bool) quickjs.Value {
+		b := &yamlBuilder{TreeBuilder: quickjs.NewTreeBuilder(ctx), ctx: ctx, anchors: map[*yaml.Node]quickjs.Value{}}
+		defer b.free()
+		dec := yaml.NewDecoder(strings.NewReader(text))
+		var docs []quickjs.Value
+		for {
+			var doc yaml.Node
+			err := dec.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				freeValues(docs)
+				return ctx.ThrowSyntaxError("%s", err)
+			}
+			v, err := b.value(&doc, 0)
+			if err != nil {
+				freeValues(docs)
+				return ctx.ThrowSyntaxError("%s", err)
+			}
+			docs = append(docs, v)
+			if !all {
+				break
+			}
+		}
+		if all {
+			return b.Array(docs)
+		}
+		if len(docs) == 0 {
+			return ctx.Null()
+		}
+		return docs[0]
+	}
+
+	exports := ctx.Object()
+	exports.Set("parse", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		return parse(args.String(0), false)
+	}))
+	exports.Set("parseAll", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		return parse(args.String(0), true)
+	}))
+	exports.Set("stringify", ctx.FunctionArgs(func(ctx *quickjs.Context, this quickjs.Value, args *quickjs.Args) quickjs.Value {
+		tree, err := args.Value(0).GoTree()
+		if err != nil {
+			return ctx.ThrowTypeError("%s", err)
+		}
+		var buf bytes.Buffer
+		ye := yaml.NewEncoder(&buf)
+		ye.SetIndent(2)
+		if err := ye.Encode(yamlNode(tree)); err != nil {
+			return ctx.ThrowError(err)
+		}
+		if err := ye.Close(); err != nil {
+			return ctx.ThrowError(err)
+		}
+		return ctx.String(buf.String())
+	}))
+
+	return ctx.LoadHostModule("yaml", exports, "parse", "parseAll", "stringify")
+}
+
+func freeValues(values []quickjs.Value) {
+	for _, v := range values {
+		v.Free()
+	}
+}
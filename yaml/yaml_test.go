@@ -0,0 +1,90 @@
+package yaml_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/buke/quickjs-go/yaml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstall(t *testing.T) {
+	rt := quickjs.NewRuntime()
+	defer rt.Close()
+	ctx := rt.NewContext()
+	defer ctx.Close()
+	require.NoError(t, yaml.Install(ctx))
+
+	ns, done, err := ctx.LoadModuleAsync(`
+		import { parse, parseAll, stringify } from "yaml";
+		const config = parse([
+			"defaults: &defaults",
+			"  replicas: 2",
+			"  image: app:1.0",
+			"web:",
+			"  <<: *defaults",
+			"  replicas: 3",
+			"  ports: [80, 443]",
+			"shared: [*defaults, *defaults]",
+			"big: 12345678901234567890",
+			"hex: 0x1F",
+			"ratio: .5",
+			"on: yes",
+			"when: 2024-01-02T03:04:05Z",
+			"blob: !!binary aGk=",
+			"none: ~",
+		].join("\n"));
+		globalThis.result = {
+			config: {
+				...config,
+				big: typeof config.big + ":" + config.big,
+				when: config.when.toISOString(),
+				blob: [...new Uint8Array(config.blob)],
+			},
+			keys: Object.keys(config.web),
+			aliased: config.shared[0] === config.defaults,
+			docs: parseAll("a: 1\n---\nb: 2\n"),
+			empty: parse(""),
+			out: stringify({ name: "app", count: 3, ratio: 0.5, on: "yes", tags: ["a", "b"], nested: { big: 2n ** 64n, when: new Date(0), empty: [] }, none: null, text: "two\nlines" }),
+			map: stringify(new Map([[1, "one"]])),
+		};
+	`, "main.js", quickjs.ModuleTimeout(time.Second))
+	require.NoError(t, err)
+	ns.Free()
+	done.Free()
+
+	ret, err := ctx.Eval(`JSON.stringify(result)`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"config": {
+			"defaults": {"replicas": 2, "image": "app:1.0"},
+			"web": {"replicas": 3, "image": "app:1.0", "ports": [80, 443]},
+			"shared": [{"replicas": 2, "image": "app:1.0"}, {"replicas": 2, "image": "app:1.0"}],
+			"big": "bigint:12345678901234567890",
+			"hex": 31,
+			"ratio": 0.5,
+			"on": "yes",
+			"when": "2024-01-02T03:04:05.000Z",
+			"blob": [104, 105],
+			"none": null
+		},
+		"keys": ["replicas", "image", "ports"],
+		"aliased": true,
+		"docs": [{"a": 1}, {"b": 2}],
+		"empty": null,
+		"out": "name: app\ncount: 3\nratio: 0.5\non: yes\ntags:\n  - a\n  - b\nnested:\n  big: !!int 18446744073709551616\n  when: 1970-01-01T00:00:00Z\n  empty: []\nnone: null\ntext: |-\n  two\n  lines\n",
+		"map": "1: one\n"
+	}`, ret.String())
+	ret.Free()
+
+	for src, want := range map[string]string{
+		`parse("a: [1")`: "SyntaxError: yaml: line 1: did not find expected ',' or ']'",
+		`parse("<<: 1")`: "SyntaxError: line 1: only mappings can be merged",
+		`parse(1)`:       "TypeError: argument 0 must be a string",
+		`(() => { const a = {}; a.a = a; return stringify(a); })()`: "TypeError: quickjs: circular reference in value tree",
+	} {
+		_, err := ctx.Eval(`import("yaml").then(({ parse, stringify }) => `+src+`)`, quickjs.EvalAwait(true))
+		require.EqualError(t, err, want, src)
+	}
+}